@@ -1,23 +1,48 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"os/user"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
+	"lanparty/internal/auth"
+	"lanparty/internal/blast"
 	"lanparty/internal/config"
+	"lanparty/internal/dedup"
 	"lanparty/internal/httpserver"
+	"lanparty/internal/manifest"
+	"lanparty/internal/migrate"
+	"lanparty/internal/sealed"
+	"lanparty/internal/swarm"
+	"lanparty/internal/tlscert"
+	"lanparty/internal/wol"
 )
 
 var (
@@ -34,6 +59,22 @@ const (
 	envPortable      = "LANPARTY_PORTABLE"
 	envFollowSymlink = "LANPARTY_FOLLOW_SYMLINKS"
 	envDisableAdmin  = "LANPARTY_DISABLE_ADMIN"
+	envWebDir        = "LANPARTY_WEB_DIR"
+	envDisable       = "LANPARTY_DISABLE"
+	envTLSCert       = "LANPARTY_TLS_CERT"
+	envTLSKey        = "LANPARTY_TLS_KEY"
+	envTLSSelfSigned = "LANPARTY_TLS_SELF_SIGNED"
+	envShutdownWait  = "LANPARTY_SHUTDOWN_TIMEOUT"
+	envWatchConfig   = "LANPARTY_WATCH_CONFIG"
+	envSocketMode    = "LANPARTY_SOCKET_MODE"
+	envSocketGroup   = "LANPARTY_SOCKET_GROUP"
+
+	// configPollEvery is how often -watch-config looks at the file.
+	configPollEvery = 2 * time.Second
+	// mountCheckEvery is how often the share roots are checked.
+	mountCheckEvery = 15 * time.Second
+	// transferCheckEvery is how often transfer rates are sampled.
+	transferCheckEvery = 5 * time.Second
 )
 
 func main() {
@@ -43,18 +84,76 @@ func main() {
 		passwdCmd(os.Args[2:])
 		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "scrub" {
+		scrubCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "blast" {
+		blastCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "receive" {
+		receiveCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fetch" {
+		fetchCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "get" {
+		getCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "pull" {
+		pullCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verifyCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "speedtest" {
+		speedtestCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importCmd(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "users" {
+		usersCmd(os.Args[2:])
+		return
+	}
 
 	var (
-		addr      = flag.String("addr", stringFromEnv(envAddr, "0.0.0.0:3923"), "listen address (env "+envAddr+")")
+		addr      = flag.String("addr", stringFromEnv(envAddr, ":3923"), "listen address (all IPv4 and IPv6 addresses by default; [fe80::1%eth0]:3923 for a link-local one), or unix:/path/to/socket (env "+envAddr+")")
+		listenAt  = flag.String("listen", "", "same as -addr, e.g. -listen unix:/run/lanparty/http.sock")
 		root      = flag.String("root", stringFromEnv(envRoot, ""), "share root (env "+envRoot+"). required if -config is not set")
 		stateDir  = flag.String("state", stringFromEnv(envStateDir, ""), "state dir for uploads/dedup/thumbs (env "+envStateDir+"); default <root>/.lanparty")
 		cfgPath   = flag.String("config", stringFromEnv(envConfigPath, ""), "path to config json (env "+envConfigPath+")")
 		portable  = flag.Bool("portable", boolFromEnv(envPortable, false), "store state in ./ .lanparty-state (env "+envPortable+")")
 		followSym = flag.Bool("follow-symlinks", boolFromEnv(envFollowSymlink, false), "allow following symlinks (env "+envFollowSymlink+")")
 		disableAd = flag.Bool("disable-admin", boolFromEnv(envDisableAdmin, false), "disable /admin UI + admin APIs (env "+envDisableAdmin+")")
+		disable   = flag.String("disable", stringFromEnv(envDisable, ""), "comma-separated subsystems to switch off: webdav,thumbs,zip,search,uploads,admin (env "+envDisable+")")
+		webDir    = flag.String("web-dir", stringFromEnv(envWebDir, ""), "serve UI files from this dir first, embedded UI for the rest (env "+envWebDir+")")
+		tlsCert   = flag.String("tls-cert", stringFromEnv(envTLSCert, ""), "serve HTTPS with this PEM certificate (env "+envTLSCert+")")
+		tlsKey    = flag.String("tls-key", stringFromEnv(envTLSKey, ""), "PEM private key for -tls-cert (env "+envTLSKey+")")
+		tlsSelf   = flag.Bool("tls-self-signed", boolFromEnv(envTLSSelfSigned, false), "serve HTTPS with a certificate generated into <state>/tls (env "+envTLSSelfSigned+")")
+		watchCfg  = flag.Bool("watch-config", boolFromEnv(envWatchConfig, true), "reload -config when the file changes; SIGHUP always reloads it (env "+envWatchConfig+")")
+		drainFor  = flag.Duration("shutdown-timeout", durationFromEnv(envShutdownWait, time.Minute), "on SIGINT/SIGTERM, how long running transfers get to finish (env "+envShutdownWait+")")
+		sockMode  = flag.String("socket-mode", stringFromEnv(envSocketMode, "0660"), "permissions of the -listen unix: socket (env "+envSocketMode+")")
+		sockGroup = flag.String("socket-group", stringFromEnv(envSocketGroup, ""), "group owning the -listen unix: socket, e.g. www-data (env "+envSocketGroup+")")
 		showVer   = flag.Bool("version", false, "print version and exit")
 	)
 	flag.Parse()
+	if *listenAt != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "addr" {
+				log.Fatal("use -listen or -addr, not both")
+			}
+		})
+		*addr = *listenAt
+	}
 
 	if *showVer {
 		fmt.Printf("lanparty %s\n", version)
@@ -90,22 +189,13 @@ func main() {
 		}
 	}
 
-	if *followSym {
-		cfg.FollowSymlinks = true
-		for name, sh := range cfg.Shares {
-			if sh.FollowSymlinks == nil || !*sh.FollowSymlinks {
-				val := true
-				sh.FollowSymlinks = &val
-				cfg.Shares[name] = sh
-			}
-		}
-	}
 	// Portable state: keep runtime state out of share roots.
 	var portableBase string
 	if *portable {
 		cwd, _ := os.Getwd()
 		portableBase = filepath.Join(cwd, ".lanparty-state")
 	}
+	applyOverrides(&cfg, *followSym, portableBase)
 
 	if cfg.Root != "" {
 		absRoot, err := filepath.Abs(cfg.Root)
@@ -114,11 +204,7 @@ func main() {
 		}
 		cfg.Root = absRoot
 		if cfg.StateDir == "" {
-			if portableBase != "" {
-				cfg.StateDir = filepath.Join(portableBase, "default")
-			} else {
-				cfg.StateDir = filepath.Join(cfg.Root, ".lanparty")
-			}
+			cfg.StateDir = filepath.Join(cfg.Root, ".lanparty")
 		}
 		if err := os.MkdirAll(cfg.StateDir, 0o755); err != nil {
 			log.Fatalf("mkdir state: %v", err)
@@ -129,6 +215,10 @@ func main() {
 		if strings.TrimSpace(name) == "" {
 			log.Fatalf("config: share name cannot be empty")
 		}
+		if sh.RAM != nil {
+			sh.Root = httpserver.RAMShareRoot(name)
+			sh.StateDir = filepath.Join(sh.Root, ".lanparty")
+		}
 		if strings.TrimSpace(sh.Root) == "" {
 			log.Fatalf("config: share %q missing root", name)
 		}
@@ -138,23 +228,33 @@ func main() {
 		}
 		sh.Root = absRoot
 		if sh.StateDir == "" {
-			if portableBase != "" {
-				sh.StateDir = filepath.Join(portableBase, "share-"+name)
-			} else {
-				sh.StateDir = filepath.Join(sh.Root, ".lanparty")
-			}
+			sh.StateDir = filepath.Join(sh.Root, ".lanparty")
 		}
 		if err := os.MkdirAll(sh.StateDir, 0o755); err != nil {
 			log.Fatalf("mkdir share state (%s): %v", name, err)
 		}
 		cfg.Shares[name] = sh
 	}
+	for name, h := range cfg.Wake {
+		if _, err := wol.MagicPacket(h.MAC); err != nil {
+			log.Fatalf("config: wake %q: %v", name, err)
+		}
+	}
+
+	var disabled []string
+	for _, f := range strings.Split(*disable, ",") {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			disabled = append(disabled, f)
+		}
+	}
+	adminOff := *disableAd || slices.Contains(disabled, "admin") || slices.Contains(cfg.Disable, "admin")
+	webdavOff := slices.Contains(disabled, "webdav") || slices.Contains(cfg.Disable, "webdav")
 
 	var (
 		genAdmin             bool
 		adminUser, adminPass string
 	)
-	if !*disableAd {
+	if !adminOff {
 		var err error
 		genAdmin, adminUser, adminPass, err = ensureAdminACL(&cfg)
 		if err != nil {
@@ -162,34 +262,139 @@ func main() {
 		}
 	}
 
+	certFile, keyFile, err := tlsFiles(cfg, *tlsCert, *tlsKey, *tlsSelf)
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	scheme := "http"
+	if certFile != "" {
+		scheme = "https"
+	}
+
 	srv, err := httpserver.New(httpserver.Options{
 		Config:       cfg,
 		ConfigPath:   *cfgPath,
 		DisableAdmin: *disableAd,
+		Disable:      disabled,
+		WebDir:       *webDir,
+		Prepare: func(c *config.Config) {
+			applyOverrides(c, *followSym, portableBase)
+			// Keep the bootstrap admin working until the file has its own.
+			if genAdmin && !hasAdminACL(c) {
+				if c.Users == nil {
+					c.Users = map[string]config.User{}
+				}
+				c.Users[adminUser] = cfg.Users[adminUser]
+				c.ACLs = append(c.ACLs, config.ACL{Path: "/admin", Read: []string{adminUser}, Write: []string{adminUser}, Admin: []string{adminUser}})
+			}
+		},
 	})
 	if err != nil {
 		log.Fatalf("server init: %v", err)
 	}
 
+	mode, err := strconv.ParseUint(*sockMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		log.Fatalf("invalid -socket-mode %q", *sockMode)
+	}
+	ln, err := listen(*addr, os.FileMode(mode), *sockGroup)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	ln = srv.Listener(ln)
+
+	where, dav := *addr, "/dav/ on "+*addr
+	tcp, isTCP := ln.Addr().(*net.TCPAddr)
+	if isTCP {
+		where = httpserver.HostURL(scheme, (&net.IPAddr{IP: tcp.IP, Zone: tcp.Zone}).String(), tcp.Port)
+		dav = where + "/dav/"
+	}
 	if cfg.Root != "" {
-		log.Printf("lanparty listening on http://%s (root=%s)", *addr, cfg.Root)
+		log.Printf("lanparty listening on %s (root=%s)", where, cfg.Root)
 	} else {
-		log.Printf("lanparty listening on http://%s (root=<none>; shares=%d)", *addr, len(cfg.Shares))
+		log.Printf("lanparty listening on %s (root=<none>; shares=%d)", where, len(cfg.Shares))
+	}
+	if isTCP && tcp.IP.IsUnspecified() {
+		for _, u := range srv.ReachableURLs(scheme) {
+			log.Printf("  reachable at %s (%s)", u.URL, u.Interface)
+		}
+	}
+	if certFile != "" {
+		if fp, err := tlscert.Fingerprint(certFile); err == nil {
+			log.Printf("tls certificate %s (sha256 %s)", certFile, fp)
+		}
 	}
 	if portableBase != "" {
 		log.Printf("portable state dir: %s", portableBase)
 	}
-	log.Printf("webdav endpoint: http://%s/dav/  (use BasicAuth if configured)", *addr)
-	if *disableAd {
+	if !webdavOff {
+		log.Printf("webdav endpoint: %s  (use BasicAuth if configured)", dav)
+	}
+	if *webDir != "" {
+		log.Printf("web UI overrides from %s", *webDir)
+	}
+	if len(disabled) > 0 || len(cfg.Disable) > 0 {
+		log.Printf("disabled: %s", strings.Join(append(slices.Clip(disabled), cfg.Disable...), ", "))
+	}
+	if adminOff {
 		log.Printf("admin endpoints disabled (config changes via file only)")
 	}
 	if genAdmin {
 		fmt.Printf("[admin] bootstrap credentials for /admin: %s / %s\n", adminUser, adminPass)
 		fmt.Println("         Update your config ACLs to use your own admin account.")
 	}
-	if err := http.ListenAndServe(*addr, withHeaders(srv.Handler())); err != nil {
+
+	hs := &http.Server{Handler: withHeaders(srv.Handler()), ConnContext: srv.ConnContext}
+	hs.RegisterOnShutdown(srv.Drain)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *cfgPath != "" {
+		reloaded := func(err error) {
+			if err != nil {
+				log.Printf("config reload: %v (keeping the running config)", err)
+			} else {
+				log.Printf("config reloaded from %s", *cfgPath)
+			}
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				reloaded(srv.Reload())
+			}
+		}()
+		if *watchCfg {
+			go srv.WatchConfig(ctx, configPollEvery, reloaded)
+		}
+	}
+	go srv.WatchMounts(ctx, mountCheckEvery)
+	go srv.WatchTransfers(ctx, transferCheckEvery)
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errc <- hs.ServeTLS(ln, certFile, keyFile)
+		} else {
+			errc <- hs.Serve(ln)
+		}
+	}()
+	select {
+	case err := <-errc:
 		log.Fatalf("listen: %v", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process the usual way.
+	stop()
+	log.Printf("shutting down; waiting up to %s for running transfers (signal again to quit now)", *drainFor)
+	sctx, cancel := context.WithTimeout(context.Background(), *drainFor)
+	defer cancel()
+	if err := hs.Shutdown(sctx); err != nil {
+		log.Printf("shutdown: %v; dropping remaining connections", err)
+		_ = hs.Close()
+	}
+	if err := srv.Close(); err != nil {
+		log.Printf("shutdown: %v", err)
 	}
+	log.Printf("stopped")
 }
 
 func passwdCmd(args []string) {
@@ -214,6 +419,946 @@ func passwdCmd(args []string) {
 	fmt.Println(string(h))
 }
 
+// importCmd translates a copyparty config or an rclone serve webdav command
+// line into a lanparty config. What cannot be translated is listed on
+// stderr.
+func importCmd(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var (
+		from   = fs.String("from", "", "copyparty config or rclone serve webdav script (required)")
+		format = fs.String("format", "auto", "auto, copyparty or rclone")
+		out    = fs.String("o", "", "write the config here instead of stdout (must not exist)")
+	)
+	_ = fs.Parse(args)
+	if *from == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: lanparty import -from <file> [-format auto|copyparty|rclone] [-o lanparty.json]")
+		os.Exit(2)
+	}
+	b, err := os.ReadFile(*from)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	if *format == "auto" {
+		*format = migrate.Detect(b)
+	}
+	var res migrate.Result
+	switch *format {
+	case "copyparty":
+		res, err = migrate.Copyparty(b)
+	case "rclone":
+		res, err = migrate.Rclone(b)
+	default:
+		log.Fatalf("import: unknown format %q", *format)
+	}
+	if err != nil {
+		log.Fatalf("import %s: %v", *format, err)
+	}
+	for _, w := range res.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	js, err := json.MarshalIndent(res.Config, "", "  ")
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	js = append(js, '\n')
+	if *out == "" {
+		_, _ = os.Stdout.Write(js)
+		return
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	if _, err := f.Write(js); err != nil {
+		_ = f.Close()
+		log.Fatalf("import: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("import: %v", err)
+	}
+}
+
+// usersCmd merges the users of an htpasswd file into a config file. A
+// server running with -watch-config (or sent SIGHUP) picks them up.
+func usersCmd(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: lanparty users import -config <file> [-overwrite] <htpasswd>")
+		os.Exit(2)
+	}
+	if len(args) == 0 || args[0] != "import" {
+		usage()
+	}
+	fs := flag.NewFlagSet("users import", flag.ExitOnError)
+	var (
+		cfgPath   = fs.String("config", stringFromEnv(envConfigPath, ""), "config json to merge into (env "+envConfigPath+")")
+		overwrite = fs.Bool("overwrite", false, "replace the hashes of users the config already has")
+	)
+	_ = fs.Parse(args[1:])
+	if *cfgPath == "" || fs.NArg() != 1 {
+		usage()
+	}
+	b, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("users import: %v", err)
+	}
+	users, skipped, err := auth.ParseHtpasswd(b)
+	if err != nil {
+		log.Fatalf("users import %s: %v", fs.Arg(0), err)
+	}
+	cb, err := os.ReadFile(*cfgPath)
+	if err != nil {
+		log.Fatalf("read config: %v", err)
+	}
+	var cfg config.Config
+	if err := json.Unmarshal(cb, &cfg); err != nil {
+		log.Fatalf("parse config: %v", err)
+	}
+	if cfg.Users == nil {
+		cfg.Users = map[string]config.User{}
+	}
+	var added, updated, kept int
+	for name, u := range users {
+		cur, ok := cfg.Users[name]
+		switch {
+		case !ok:
+			added++
+		case cur == u:
+			continue
+		case !*overwrite:
+			kept++
+			continue
+		default:
+			updated++
+		}
+		cfg.Users[name] = u
+	}
+	for _, name := range skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s: only bcrypt and md5-crypt hashes are supported\n", name)
+	}
+	if kept > 0 {
+		fmt.Fprintf(os.Stderr, "kept %d existing user(s) with a different hash; -overwrite replaces them\n", kept)
+	}
+	fmt.Printf("%d added, %d updated, %d skipped\n", added, updated, len(skipped))
+	if added+updated == 0 {
+		return
+	}
+	js, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		log.Fatalf("users import: %v", err)
+	}
+	mode := os.FileMode(0o600)
+	if st, err := os.Stat(*cfgPath); err == nil {
+		mode = st.Mode().Perm()
+	}
+	tmp := *cfgPath + fmt.Sprintf(".tmp-%d", time.Now().UnixNano())
+	if err := os.WriteFile(tmp, js, mode); err != nil {
+		log.Fatalf("users import: %v", err)
+	}
+	if err := os.Rename(tmp, *cfgPath); err != nil {
+		_ = os.Remove(tmp)
+		log.Fatalf("users import: %v", err)
+	}
+}
+
+// scrubCmd verifies the blob store of the default root and every share,
+// exiting non-zero if anything is corrupt.
+func scrubCmd(args []string) {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	var (
+		cfgPath  = fs.String("config", stringFromEnv(envConfigPath, ""), "path to config json (env "+envConfigPath+")")
+		root     = fs.String("root", stringFromEnv(envRoot, ""), "share root (env "+envRoot+")")
+		stateDir = fs.String("state", stringFromEnv(envStateDir, ""), "state dir (env "+envStateDir+"); default <root>/.lanparty")
+		portable = fs.Bool("portable", boolFromEnv(envPortable, false), "state lives in ./ .lanparty-state (env "+envPortable+")")
+		only     = fs.String("share", "", "only scrub this share (\"\" = all; \"-\" = default root)")
+	)
+	_ = fs.Parse(args)
+
+	var cfg config.Config
+	if *cfgPath != "" {
+		b, err := os.ReadFile(*cfgPath)
+		if err != nil {
+			log.Fatalf("read config: %v", err)
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			log.Fatalf("parse config: %v", err)
+		}
+	} else {
+		if strings.TrimSpace(*root) == "" {
+			fmt.Fprintln(os.Stderr, "usage: lanparty scrub -config <file> | -root <dir> [-state <dir>]")
+			os.Exit(2)
+		}
+		cfg.Root = *root
+		cfg.StateDir = *stateDir
+	}
+	var portableBase string
+	if *portable {
+		cwd, _ := os.Getwd()
+		portableBase = filepath.Join(cwd, ".lanparty-state")
+	}
+
+	type target struct{ name, root, state string }
+	var targets []target
+	if cfg.Root != "" && (*only == "" || *only == "-") {
+		st := cfg.StateDir
+		if st == "" {
+			if portableBase != "" {
+				st = filepath.Join(portableBase, "default")
+			} else {
+				st = filepath.Join(cfg.Root, ".lanparty")
+			}
+		}
+		targets = append(targets, target{name: "-", root: cfg.Root, state: st})
+	}
+	names := make([]string, 0, len(cfg.Shares))
+	for name := range cfg.Shares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if *only != "" && *only != name {
+			continue
+		}
+		sh := cfg.Shares[name]
+		st := sh.StateDir
+		if st == "" {
+			if portableBase != "" {
+				st = filepath.Join(portableBase, "share-"+name)
+			} else {
+				st = filepath.Join(sh.Root, ".lanparty")
+			}
+		}
+		targets = append(targets, target{name: name, root: sh.Root, state: st})
+	}
+	if len(targets) == 0 {
+		log.Fatalf("scrub: nothing to scrub")
+	}
+
+	var key *sealed.Key
+	if cfg.StateKeyFile != "" {
+		k, err := sealed.LoadKeyFile(cfg.StateKeyFile)
+		if err != nil {
+			log.Fatalf("state key: %v", err)
+		}
+		key = k
+	}
+
+	bad := false
+	for _, t := range targets {
+		if _, err := os.Stat(filepath.Join(t.state, "blobs")); err != nil {
+			fmt.Printf("[%s] no blob store at %s\n", t.name, t.state)
+			continue
+		}
+		store, err := dedup.New(t.state)
+		if err != nil {
+			log.Fatalf("scrub %s: %v", t.name, err)
+		}
+		if key != nil {
+			store.SetKey(key)
+		}
+		rep, err := store.Scrub(context.Background(), t.root)
+		if err != nil {
+			log.Fatalf("scrub %s: %v", t.name, err)
+		}
+		fmt.Printf("[%s] %d blobs, %d bytes, %d linked files\n", t.name, rep.Blobs, rep.Bytes, rep.Linked)
+		for _, b := range rep.Corrupt {
+			fmt.Printf("[%s] CORRUPT  %s\n", t.name, b)
+		}
+		for _, b := range rep.Misnamed {
+			fmt.Printf("[%s] MISNAMED %s\n", t.name, b)
+		}
+		for _, a := range rep.Affected {
+			fmt.Printf("[%s] AFFECTED %s (blob %s)\n", t.name, a.Path, a.Blob)
+		}
+		if !rep.OK() {
+			bad = true
+		}
+	}
+	if bad {
+		os.Exit(1)
+	}
+}
+
+// blastCmd multicasts a folder to every "lanparty receive" on the subnet.
+func blastCmd(args []string) {
+	fs := flag.NewFlagSet("blast", flag.ExitOnError)
+	var (
+		group  = fs.String("group", blast.DefaultGroup, "multicast group:port")
+		iface  = fs.String("iface", "", "network interface to send on (default: system route)")
+		rate   = fs.Int("rate", 300, "send rate in Mbit/s")
+		passes = fs.Int("passes", 2, "times to send everything; receivers fill gaps on later passes")
+		fec    = fs.Int("fec", 16, "data packets per parity packet (0 = no parity)")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: lanparty blast [-group addr] [-iface name] [-rate mbit] [-passes n] [-fec n] <folder>")
+		os.Exit(2)
+	}
+	if *fec == 0 {
+		*fec = -1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := blast.Send(ctx, fs.Arg(0), blast.SendOptions{
+		Group:    *group,
+		Iface:    *iface,
+		RateMbit: *rate,
+		Passes:   *passes,
+		FEC:      *fec,
+		Logf:     log.Printf,
+	})
+	if err != nil {
+		log.Fatalf("blast: %v", err)
+	}
+}
+
+// receiveCmd writes a blasted folder into a directory, exiting non-zero if
+// any file is missing or failed verification.
+func receiveCmd(args []string) {
+	fs := flag.NewFlagSet("receive", flag.ExitOnError)
+	var (
+		group   = fs.String("group", blast.DefaultGroup, "multicast group:port")
+		iface   = fs.String("iface", "", "network interface to join on (default: system choice)")
+		timeout = fs.Duration("timeout", 30*time.Second, "give up when the sender is silent this long")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: lanparty receive [-group addr] [-iface name] [-timeout d] <dir>")
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := blast.Receive(ctx, fs.Arg(0), blast.ReceiveOptions{
+		Group:   *group,
+		Iface:   *iface,
+		Timeout: *timeout,
+		Logf:    log.Printf,
+	})
+	fmt.Printf("%d/%d files verified, %d bytes\n", res.Verified, res.Files, res.Bytes)
+	for _, p := range res.Missing {
+		fmt.Printf("MISSING %s\n", p)
+	}
+	if err != nil {
+		log.Fatalf("receive: %v", err)
+	}
+	if res.Files == 0 || len(res.Missing) > 0 {
+		os.Exit(1)
+	}
+}
+
+// fetchCmd downloads a file through the server's swarm tracker, taking
+// verified chunks from other clients and serving its own to them.
+func fetchCmd(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	var (
+		out      = fs.String("o", "", "output file (default: last path element of the URL)")
+		token    = fs.String("token", os.Getenv("LANPARTY_TOKEN"), "bearer token (env LANPARTY_TOKEN)")
+		user     = fs.String("user", "", "user:password for basic auth")
+		listen   = fs.String("listen", ":0", "address to serve chunks to other peers on (\"\" = download only)")
+		parallel = fs.Int("parallel", 4, "chunks fetched at once")
+		seed     = fs.Duration("seed", 10*time.Minute, "keep serving chunks this long after finishing")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: lanparty fetch [-o file] [-token t | -user u:p] [-listen addr] [-seed d] <http://host/.../f/path>")
+		os.Exit(2)
+	}
+	rawURL := fs.Arg(0)
+	if *out == "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			log.Fatalf("fetch: %v", err)
+		}
+		*out = path.Base(u.Path)
+	}
+	auth := func(r *http.Request) {
+		if *token != "" {
+			r.Header.Set("Authorization", "Bearer "+*token)
+		} else if u, p, ok := strings.Cut(*user, ":"); ok {
+			r.SetBasicAuth(u, p)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	st, err := swarm.Fetch(ctx, swarm.FetchOptions{
+		URL:      rawURL,
+		Out:      *out,
+		Auth:     auth,
+		Listen:   *listen,
+		Parallel: *parallel,
+		Seed:     *seed,
+		Logf:     log.Printf,
+	})
+	if err != nil {
+		log.Fatalf("fetch: %v", err)
+	}
+	fmt.Printf("%s: %d bytes from peers, %d from host, %d served\n", *out, st.FromPeers, st.FromHost, st.Served)
+}
+
+// getCmd downloads one file over several connections at once, chunk by
+// chunk from /api/chunks, checking each chunk against its hash. A <file>.part
+// left by an interrupted run is resumed: chunks that already match are kept.
+func getCmd(args []string) {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	var (
+		out     = fs.String("o", "", "output file (default: last path element of the URL)")
+		token   = fs.String("token", os.Getenv("LANPARTY_TOKEN"), "bearer token (env LANPARTY_TOKEN)")
+		user    = fs.String("user", "", "user:password for basic auth")
+		streams = fs.Int("streams", 8, "parallel connections")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *streams < 1 {
+		fmt.Fprintln(os.Stderr, "usage: lanparty get [-o file] [-streams n] [-token t | -user u:p] <http://host/.../f/path>")
+		os.Exit(2)
+	}
+	fileURL, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("get: %v", err)
+	}
+	prefix, rel, ok := strings.Cut(fileURL.Path, "/f/")
+	if !ok || rel == "" {
+		log.Fatalf("get: %s is not a /f/ file URL", fs.Arg(0))
+	}
+	if *out == "" {
+		*out = path.Base(rel)
+	}
+	// One TCP connection per stream: no HTTP/2, which would multiplex them.
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = *streams
+	tr.ForceAttemptHTTP2 = false
+	tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	client := &http.Client{Transport: tr}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	get := func(u string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if *token != "" {
+			req.Header.Set("Authorization", "Bearer "+*token)
+		} else if u, p, ok := strings.Cut(*user, ":"); ok {
+			req.SetBasicAuth(u, p)
+		}
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+		}
+		return resp, err
+	}
+
+	base := *fileURL
+	base.Path, base.RawPath, base.RawQuery = prefix+"/api/chunks", "", "path="+url.QueryEscape(rel)
+	resp, err := get(base.String())
+	if err != nil {
+		log.Fatalf("get: chunk list: %v", err)
+	}
+	var plan struct {
+		ID     string        `json:"id"`
+		Size   int64         `json:"size"`
+		Chunks []dedup.Chunk `json:"chunks"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&plan)
+	resp.Body.Close()
+	if err != nil {
+		log.Fatalf("get: chunk list: %v", err)
+	}
+
+	part := *out + ".part"
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Fatalf("get: %v", err)
+	}
+	defer f.Close()
+	var todo []int
+	st, err := f.Stat()
+	resume := err == nil && st.Size() == plan.Size
+	if !resume {
+		if err := f.Truncate(plan.Size); err != nil {
+			log.Fatalf("get: %v", err)
+		}
+	}
+	for i, c := range plan.Chunks {
+		if resume {
+			buf := make([]byte, c.Len)
+			if _, err := f.ReadAt(buf, c.Off); err == nil && chunkOK(buf, c.Hash) {
+				continue
+			}
+		}
+		todo = append(todo, i)
+	}
+
+	start := time.Now()
+	var (
+		mu      sync.Mutex
+		fetched int64
+		firstEr error
+	)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(*streams, len(todo)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := plan.Chunks[i]
+				q := fileURL.Query()
+				q.Set("chunk", strconv.Itoa(i))
+				q.Set("session", plan.ID)
+				u := *fileURL
+				u.RawQuery = q.Encode()
+				var err error
+				for try := 0; try < 3; try++ {
+					if err = getChunk(get, u.String(), f, c); err == nil || ctx.Err() != nil {
+						break
+					}
+				}
+				mu.Lock()
+				if err == nil {
+					fetched += int64(c.Len)
+				} else if firstEr == nil {
+					firstEr = fmt.Errorf("chunk %d: %w", i, err)
+					stop()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, i := range todo {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstEr == nil {
+		firstEr = ctx.Err()
+	}
+	if firstEr != nil {
+		log.Fatalf("get: %v (kept %s to resume)", firstEr, part)
+	}
+	if err := f.Sync(); err != nil {
+		log.Fatalf("get: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("get: %v", err)
+	}
+	if err := os.Rename(part, *out); err != nil {
+		log.Fatalf("get: %v", err)
+	}
+	secs := max(time.Since(start).Seconds(), 0.001)
+	fmt.Printf("%s: %d bytes, %d of %d chunks fetched over %d streams, %.1f MB/s\n",
+		*out, plan.Size, len(todo), len(plan.Chunks), min(*streams, max(len(todo), 1)), float64(fetched)/secs/1e6)
+}
+
+// getChunk fetches one chunk, checks it and writes it into place.
+func getChunk(get func(string) (*http.Response, error), u string, f *os.File, c dedup.Chunk) error {
+	resp, err := get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, int64(c.Len)+1))
+	if err != nil {
+		return err
+	}
+	if len(buf) != c.Len || !chunkOK(buf, c.Hash) {
+		return errors.New("does not match its hash (file changed?)")
+	}
+	_, err = f.WriteAt(buf, c.Off)
+	return err
+}
+
+func chunkOK(b []byte, hash string) bool {
+	sum := sha256.Sum256(b)
+	return strings.EqualFold(hex.EncodeToString(sum[:]), hash)
+}
+
+// pullCmd downloads a folder file by file from its download plan. Partial
+// files are kept as <name>.part and resumed with Range on the next run;
+// complete files whose size and hash match are skipped.
+func pullCmd(args []string) {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	var (
+		out   = fs.String("o", "", "output folder (default: last element of the folder path)")
+		token = fs.String("token", os.Getenv("LANPARTY_TOKEN"), "bearer token (env LANPARTY_TOKEN)")
+		user  = fs.String("user", "", "user:password for basic auth")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: lanparty pull [-o dir] [-token t | -user u:p] http://host:3923[/s/<share>] <folder>")
+		os.Exit(2)
+	}
+	folder := strings.Trim(fs.Arg(1), "/")
+	if *out == "" {
+		*out = path.Base("/" + folder)
+		if folder == "" {
+			*out = "root"
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	get := func(u string, from int64) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if *token != "" {
+			req.Header.Set("Authorization", "Bearer "+*token)
+		} else if u, p, ok := strings.Cut(*user, ":"); ok {
+			req.SetBasicAuth(u, p)
+		}
+		if from > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(from, 10)+"-")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+		}
+		return resp, err
+	}
+
+	resp, err := get(strings.TrimSuffix(fs.Arg(0), "/")+"/api/download-plan?path="+url.QueryEscape(folder), 0)
+	if err != nil {
+		log.Fatalf("pull: plan: %v", err)
+	}
+	var plan struct {
+		Count int   `json:"count"`
+		Bytes int64 `json:"bytes"`
+		Files []struct {
+			Path   string `json:"path"`
+			Size   int64  `json:"size"`
+			SHA256 string `json:"sha256"`
+			URL    string `json:"url"`
+		} `json:"files"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&plan)
+	resp.Body.Close()
+	if err != nil {
+		log.Fatalf("pull: plan: %v", err)
+	}
+	fmt.Printf("%s: %d files, %d bytes\n", folder, plan.Count, plan.Bytes)
+
+	var fetched, skipped, failed int
+	for _, f := range plan.Files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			log.Printf("pull: skipping unsafe path %q", f.Path)
+			failed++
+			continue
+		}
+		dst := filepath.Join(*out, filepath.FromSlash(f.Path))
+		if st, err := os.Stat(dst); err == nil && st.Size() == f.Size {
+			if f.SHA256 == "" {
+				skipped++
+				continue
+			}
+			if sum, _, err := manifest.HashFile(dst); err == nil && sum == f.SHA256 {
+				skipped++
+				continue
+			}
+		}
+		if err := pullFile(get, f.URL, dst, f.Size, f.SHA256); err != nil {
+			if ctx.Err() != nil {
+				log.Fatalf("pull: interrupted; run again to resume")
+			}
+			log.Printf("pull: %s: %v", f.Path, err)
+			failed++
+			continue
+		}
+		fetched++
+	}
+	fmt.Printf("%d fetched, %d already there, %d failed\n", fetched, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// pullFile downloads u to dst via dst.part, continuing a partial download,
+// and checks the result against want when it is set.
+func pullFile(get func(string, int64) (*http.Response, error), u, dst string, size int64, want string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	part := dst + ".part"
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	have, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if have > size {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		have = 0
+	}
+	if have < size {
+		resp, err := get(u, have)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && have > 0 {
+			// The server ignored the range; start over.
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			have = 0
+		}
+		if _, err := f.Seek(have, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if want != "" {
+		sum, n, err := manifest.HashFile(part)
+		if err != nil {
+			return err
+		}
+		if n != size || sum != want {
+			// A corrupt partial would fail the same way forever.
+			_ = os.Remove(part)
+			return fmt.Errorf("checksum mismatch")
+		}
+	}
+	return os.Rename(part, dst)
+}
+
+// verifyCmd checks a local folder against a signed distribution manifest,
+// exiting non-zero on any difference.
+func verifyCmd(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		file   = fs.String("manifest", "", "manifest file")
+		mURL   = fs.String("url", "", "manifest URL, e.g. http://host:3923/api/manifest?path=games/cs")
+		token  = fs.String("token", os.Getenv("LANPARTY_TOKEN"), "bearer token for -url (env LANPARTY_TOKEN)")
+		user   = fs.String("user", "", "user:password for -url")
+		pubkey = fs.String("pubkey", "", "require manifests signed by this key (base64, as in publicKey)")
+		save   = fs.String("save", "", "also write the manifest to this file")
+		extra  = fs.Bool("extra", false, "report files not listed in the manifest")
+		quiet  = fs.Bool("q", false, "only print problems")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || (*file == "") == (*mURL == "") {
+		fmt.Fprintln(os.Stderr, "usage: lanparty verify (-manifest file | -url url) [-pubkey key] [-extra] <dir>")
+		os.Exit(2)
+	}
+
+	var raw []byte
+	if *file != "" {
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatalf("read manifest: %v", err)
+		}
+		raw = b
+	} else {
+		req, err := http.NewRequest(http.MethodGet, *mURL, nil)
+		if err != nil {
+			log.Fatalf("verify: %v", err)
+		}
+		if *token != "" {
+			req.Header.Set("Authorization", "Bearer "+*token)
+		} else if u, p, ok := strings.Cut(*user, ":"); ok {
+			req.SetBasicAuth(u, p)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("fetch manifest: %v", err)
+		}
+		raw, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Fatalf("fetch manifest: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("fetch manifest: %s: %s", resp.Status, strings.TrimSpace(string(raw)))
+		}
+	}
+	var m manifest.Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		log.Fatalf("parse manifest: %v", err)
+	}
+	if m.Version != manifest.Version {
+		log.Fatalf("unsupported manifest version %d", m.Version)
+	}
+	if err := m.Verify(*pubkey); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *save != "" {
+		if err := os.WriteFile(*save, raw, 0o644); err != nil {
+			log.Fatalf("save manifest: %v", err)
+		}
+	}
+	if !*quiet {
+		fmt.Printf("manifest %s: %d files, key %s\n", m.Root, len(m.Files), m.Fingerprint())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	problems, err := m.Check(ctx, fs.Arg(0), *extra, nil)
+	for _, p := range problems {
+		fmt.Printf("%-8s %s\n", p.Kind, p.Path)
+	}
+	if err != nil {
+		log.Fatalf("verify: %v", err)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problems\n", len(problems))
+		os.Exit(1)
+	}
+	if !*quiet {
+		fmt.Println("OK")
+	}
+}
+
+// speedtestCmd measures latency and throughput to a lanparty server and,
+// with -disk, the local disk, to tell a slow network from a slow disk.
+func speedtestCmd(args []string) {
+	fs := flag.NewFlagSet("speedtest", flag.ExitOnError)
+	var (
+		token = fs.String("token", os.Getenv("LANPARTY_TOKEN"), "bearer token (env LANPARTY_TOKEN)")
+		user  = fs.String("user", "", "user:password for basic auth")
+		mib   = fs.Int("mb", 200, "MiB to transfer each way")
+		disk  = fs.String("disk", "", "also time writing and reading -mb MiB in this directory")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *mib <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: lanparty speedtest [-token t | -user u:p] [-mb n] [-disk dir] http://host:3923[/s/<share>]")
+		os.Exit(2)
+	}
+	base := strings.TrimSuffix(fs.Arg(0), "/") + "/api/speedtest/"
+	n := int64(*mib) << 20
+	do := func(method, u string, body io.Reader, size int64) (*http.Response, error) {
+		req, err := http.NewRequest(method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		if *token != "" {
+			req.Header.Set("Authorization", "Bearer "+*token)
+		} else if u, p, ok := strings.Cut(*user, ":"); ok {
+			req.SetBasicAuth(u, p)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s %s: %s", method, u, resp.Status)
+		}
+		return resp, err
+	}
+	mbps := func(b int64, d time.Duration) float64 { return float64(b) * 8 / d.Seconds() / 1e6 }
+
+	var best, total time.Duration
+	const pings = 10
+	for i := 0; i < pings; i++ {
+		t := time.Now()
+		resp, err := do(http.MethodGet, base+"ping", nil, 0)
+		if err != nil {
+			log.Fatalf("ping: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		d := time.Since(t)
+		total += d
+		if best == 0 || d < best {
+			best = d
+		}
+	}
+	fmt.Printf("latency   min %v  avg %v\n", best.Round(time.Microsecond), (total / pings).Round(time.Microsecond))
+
+	t := time.Now()
+	resp, err := do(http.MethodGet, base+"download?bytes="+strconv.FormatInt(n, 10), nil, 0)
+	if err != nil {
+		log.Fatalf("download: %v", err)
+	}
+	got, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		log.Fatalf("download: %v", err)
+	}
+	fmt.Printf("download  %.0f Mbit/s (%d MiB in %v)\n", mbps(got, time.Since(t)), got>>20, time.Since(t).Round(time.Millisecond))
+
+	t = time.Now()
+	resp, err = do(http.MethodPost, base+"upload", io.LimitReader(rand.Reader, n), n)
+	if err != nil {
+		log.Fatalf("upload: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	fmt.Printf("upload    %.0f Mbit/s (%d MiB in %v)\n", mbps(n, time.Since(t)), n>>20, time.Since(t).Round(time.Millisecond))
+
+	if *disk == "" {
+		return
+	}
+	f, err := os.CreateTemp(*disk, "lanparty-speedtest-*")
+	if err != nil {
+		log.Fatalf("disk: %v", err)
+	}
+	defer os.Remove(f.Name())
+	buf := make([]byte, 1<<20)
+	_, _ = rand.Read(buf)
+	t = time.Now()
+	for left := n; left > 0; left -= int64(len(buf)) {
+		if _, err := f.Write(buf); err != nil {
+			log.Fatalf("disk write: %v", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		log.Fatalf("disk sync: %v", err)
+	}
+	fmt.Printf("disk write %.0f MB/s\n", float64(n)/1e6/time.Since(t).Seconds())
+	// Reads right after writing mostly come from the page cache; this is the
+	// best case, not the cold-disk speed.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.Fatalf("disk: %v", err)
+	}
+	t = time.Now()
+	if _, err := io.CopyBuffer(io.Discard, f, buf); err != nil {
+		log.Fatalf("disk read: %v", err)
+	}
+	fmt.Printf("disk read  %.0f MB/s (cached)\n", float64(n)/1e6/time.Since(t).Seconds())
+	f.Close()
+}
+
+// tlsFiles picks the certificate and key to serve HTTPS with, or none for
+// plain HTTP. The config's tls block wins over the flags.
+func tlsFiles(cfg config.Config, cert, key string, selfSigned bool) (string, string, error) {
+	var hosts []string
+	if t := cfg.TLS; t != nil {
+		cert, key, selfSigned, hosts = t.Cert, t.Key, t.SelfSigned, t.Hosts
+	}
+	switch {
+	case cert != "" || key != "":
+		if cert == "" || key == "" {
+			return "", "", fmt.Errorf("need both a certificate and a key")
+		}
+		if selfSigned {
+			return "", "", fmt.Errorf("a certificate and self-signed are exclusive")
+		}
+		return cert, key, nil
+	case selfSigned:
+		if cfg.StateDir == "" {
+			return "", "", fmt.Errorf("self-signed needs a state dir to keep the certificate in")
+		}
+		return tlscert.SelfSigned(filepath.Join(cfg.StateDir, "tls"), hosts)
+	}
+	return "", "", nil
+}
+
 func withHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Basic hardening / UX.
@@ -237,6 +1382,33 @@ func withHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// applyOverrides applies the command-line settings that win over the config
+// file: -follow-symlinks and -portable state dirs.
+func applyOverrides(cfg *config.Config, followSym bool, portableBase string) {
+	if followSym {
+		cfg.FollowSymlinks = true
+		for name, sh := range cfg.Shares {
+			if sh.FollowSymlinks == nil || !*sh.FollowSymlinks {
+				val := true
+				sh.FollowSymlinks = &val
+				cfg.Shares[name] = sh
+			}
+		}
+	}
+	if portableBase == "" {
+		return
+	}
+	if cfg.Root != "" && cfg.StateDir == "" {
+		cfg.StateDir = filepath.Join(portableBase, "default")
+	}
+	for name, sh := range cfg.Shares {
+		if sh.StateDir == "" && sh.RAM == nil {
+			sh.StateDir = filepath.Join(portableBase, "share-"+name)
+			cfg.Shares[name] = sh
+		}
+	}
+}
+
 func ensureAdminACL(cfg *config.Config) (bool, string, string, error) {
 	if hasAdminACL(cfg) {
 		return false, "", "", nil
@@ -287,6 +1459,79 @@ func randomToken(n int) string {
 	return base64.RawURLEncoding.EncodeToString(b)[:n]
 }
 
+// listen opens addr: a TCP host:port, or unix:/path for a Unix socket that
+// a reverse proxy on the same host connects to. A stale socket left by a
+// crashed run is replaced; one another process still answers on is not.
+func listen(addr string, mode os.FileMode, group string) (net.Listener, error) {
+	sock, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if st, err := os.Lstat(sock); err == nil {
+		if st.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", sock)
+		}
+		if c, err := net.DialTimeout("unix", sock, time.Second); err == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%s is in use", sock)
+		}
+		if err := os.Remove(sock); err != nil {
+			return nil, err
+		}
+	}
+	// Bind in a directory only this process can enter, so the socket is
+	// unreachable until it has its mode and group, then move it in place.
+	tmp, err := os.MkdirTemp(filepath.Dir(sock), ".lanparty-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	p := filepath.Join(tmp, "s")
+	ln, err := net.Listen("unix", p)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	fail := func(err error) (net.Listener, error) {
+		_ = ln.Close()
+		return nil, err
+	}
+	if err := os.Chmod(p, mode); err != nil {
+		return fail(err)
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return fail(err)
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		if err := os.Chown(p, -1, gid); err != nil {
+			return fail(err)
+		}
+	}
+	if err := os.Rename(p, sock); err != nil {
+		return fail(err)
+	}
+	return unixListener{Listener: ln, path: sock}, nil
+}
+
+// unixListener is a Unix socket listener bound elsewhere and moved to
+// path, which it removes when closed.
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l unixListener) Close() error {
+	err := l.Listener.Close()
+	_ = os.Remove(l.path)
+	return err
+}
+
 func stringFromEnv(name, fallback string) string {
 	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
 		return v
@@ -294,6 +1539,18 @@ func stringFromEnv(name, fallback string) string {
 	return fallback
 }
 
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid duration %q for %s", v, name)
+	}
+	return d
+}
+
 func boolFromEnv(name string, fallback bool) bool {
 	v := strings.TrimSpace(os.Getenv(name))
 	if v == "" {
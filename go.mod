@@ -3,7 +3,19 @@ module lanparty
 go 1.22
 
 require (
+	github.com/go-ldap/ldap/v3 v3.4.8
+	github.com/klauspost/compress v1.17.11
+	go.etcd.io/bbolt v1.3.11
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
 	golang.org/x/crypto v0.28.0
 	golang.org/x/image v0.22.0
 	golang.org/x/net v0.30.0
+	rsc.io/qr v0.2.0
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.5 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
 )
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ACL path patterns. A path with "*" or "?" in it is a glob matched one
+// folder name at a time ("/photos/*/raw"), where a "**" segment stands for
+// any number of folders and "\" escapes a character; a path starting with
+// "re:" is a regular expression matched from the start of the request path
+// ("re:/projects/[a-z]+-[0-9]+/shared"). Like a plain path, a pattern
+// covers what it matches and everything below it, and the deeper the folder
+// it matches, the more specific the ACL is.
+
+// ACLRegexPrefix marks an ACL path as a regular expression.
+const ACLRegexPrefix = "re:"
+
+type aclPattern struct {
+	glob []string // segments, for a glob
+	re   *regexp.Regexp
+	err  error
+}
+
+// aclPatterns caches compiled patterns by ACL path. Paths only come from
+// the config, so it stays small.
+var aclPatterns sync.Map
+
+// IsACLPattern reports whether ACL path ap is a glob or regex rather than a
+// plain path.
+func IsACLPattern(ap string) bool {
+	return strings.HasPrefix(ap, ACLRegexPrefix) || strings.ContainsAny(ap, "*?")
+}
+
+// CheckACLPath returns why ACL path ap cannot be used, if it is a pattern
+// that does not compile.
+func CheckACLPath(ap string) error {
+	if !IsACLPattern(ap) {
+		return nil
+	}
+	return compileACLPath(ap).err
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+// EscapeGlob escapes s for use in a glob ACL path, so it matches only
+// itself.
+func EscapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
+
+// QuoteACLPath escapes the pattern characters in the plain path p, so an
+// ACL for it covers just that folder.
+func QuoteACLPath(p string) string {
+	if !IsACLPattern(p) {
+		return p
+	}
+	return EscapeGlob(p)
+}
+
+func compileACLPath(ap string) *aclPattern {
+	if v, ok := aclPatterns.Load(ap); ok {
+		return v.(*aclPattern)
+	}
+	p := &aclPattern{}
+	if expr, ok := strings.CutPrefix(ap, ACLRegexPrefix); ok {
+		if _, p.err = regexp.Compile(expr); p.err == nil {
+			p.re, p.err = regexp.Compile(`^(?:` + expr + `)(?:/|$)`)
+		}
+	} else {
+		p.glob = splitPath(ap)
+		for _, seg := range p.glob {
+			if _, err := path.Match(seg, ""); err != nil {
+				p.err = err
+				break
+			}
+		}
+	}
+	aclPatterns.Store(ap, p)
+	return p
+}
+
+// match is ACLMatch for a pattern.
+func (p *aclPattern) match(cleanPath string) (int, bool) {
+	if p.err != nil {
+		return 0, false
+	}
+	if p.re != nil {
+		loc := p.re.FindStringIndex(cleanPath)
+		if loc == nil {
+			return 0, false
+		}
+		n := loc[1]
+		if n > 1 && cleanPath[n-1] == '/' {
+			n--
+		}
+		return n, true
+	}
+	parts := splitPath(cleanPath)
+	k, ok := globPrefix(p.glob, parts)
+	if !ok {
+		return 0, false
+	}
+	return len("/" + strings.Join(parts[:k], "/")), true
+}
+
+// globPrefix returns how many leading parts segs matches, taking as few as
+// it can for "**".
+func globPrefix(segs, parts []string) (int, bool) {
+	if len(segs) == 0 {
+		return 0, true
+	}
+	if segs[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if n, ok := globPrefix(segs[1:], parts[i:]); ok {
+				return i + n, true
+			}
+		}
+		return 0, false
+	}
+	if len(parts) == 0 {
+		return 0, false
+	}
+	if ok, _ := path.Match(segs[0], parts[0]); !ok {
+		return 0, false
+	}
+	n, ok := globPrefix(segs[1:], parts[1:])
+	return n + 1, ok
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
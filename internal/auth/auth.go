@@ -6,16 +6,19 @@ import (
 	"encoding/base64"
 	"errors"
 	"net/http"
+	"slices"
 	"strings"
-
-	"golang.org/x/crypto/bcrypt"
+	"time"
 
 	"lanparty/internal/config"
 )
 
 type ctxKey string
 
-const userKey ctxKey = "lanparty.user"
+const (
+	userKey   ctxKey = "lanparty.user"
+	groupsKey ctxKey = "lanparty.groups"
+)
 
 func UserFromContext(ctx context.Context) string {
 	v, _ := ctx.Value(userKey).(string)
@@ -26,8 +29,31 @@ func WithUser(ctx context.Context, user string) context.Context {
 	return context.WithValue(ctx, userKey, user)
 }
 
+// GroupsFromContext returns the groups a sign-in provider put the user in.
+func GroupsFromContext(ctx context.Context) []string {
+	v, _ := ctx.Value(groupsKey).([]string)
+	return v
+}
+
+func WithGroups(ctx context.Context, groups []string) context.Context {
+	return context.WithValue(ctx, groupsKey, groups)
+}
+
 func HasAuth(cfg config.Config) bool {
-	return len(cfg.Users) > 0
+	return len(cfg.Users) > 0 || cfg.OIDC != nil || cfg.LDAP != nil
+}
+
+// TokenUser returns the user a bearer token maps to, or "". Every configured
+// token is compared in constant time so lookups do not leak how much of a
+// guessed token matched.
+func TokenUser(cfg config.Config, tok string) string {
+	user := ""
+	for t, u := range cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(tok)) == 1 {
+			user = u
+		}
+	}
+	return user
 }
 
 // RequireAuth wraps a handler with optional BasicAuth.
@@ -55,7 +81,7 @@ func RequireAuth(cfg config.Config, next http.Handler) http.Handler {
 			deny(w)
 			return
 		}
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Bcrypt), []byte(p)); err != nil {
+		if !CheckPassword(user.Bcrypt, p) {
 			deny(w)
 			return
 		}
@@ -105,7 +131,65 @@ const (
 	PermAdmin
 )
 
+func (p Perm) String() string {
+	switch p {
+	case PermRead:
+		return "read"
+	case PermWrite:
+		return "write"
+	case PermAdmin:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// Request is an access question along with what a Policy may weigh.
+type Request struct {
+	User   string
+	Groups []string // as for AllowedMember
+	Path   string   // clean path, as for Allowed
+	Perm   Perm
+	Share  string // "" for the default share
+	IP     string
+	Time   time.Time
+}
+
+// A Policy has the last word on access decisions, for rules the prefix ACLs
+// cannot express. acl is what the ACLs decided.
+type Policy interface {
+	Decide(req Request, acl bool) (bool, error)
+}
+
+// AllowedRequest is Allowed followed by p, if any.
+func AllowedRequest(cfg config.Config, req Request, p Policy) (bool, error) {
+	ok, err := AllowedMember(cfg, req.User, req.Groups, req.Path, req.Perm)
+	if err != nil || p == nil {
+		return ok, err
+	}
+	return p.Decide(req, ok)
+}
+
+// OwnerAllowed reports whether ownersManage lets req.User, who uploaded
+// req.Path, rename or delete it: the ACL deciding the path has
+// ownersManage and no deny list bars the user from writing there. p, if
+// any, has the last word as in AllowedRequest.
+func OwnerAllowed(cfg config.Config, req Request, p Policy) (bool, error) {
+	a, ok := ACLFor(cfg, req.Path)
+	ok = ok && a.OwnersManage && req.User != "" && !deniedBy(cfg, req.User, req.Groups, req.Path, PermWrite)
+	if p == nil {
+		return ok, nil
+	}
+	req.Perm = PermWrite
+	return p.Decide(req, ok)
+}
+
 func Allowed(cfg config.Config, user string, cleanPath string, perm Perm) (bool, error) {
+	return AllowedMember(cfg, user, nil, cleanPath, perm)
+}
+
+// AllowedMember is Allowed for a user who belongs to groups, which ACL
+// entries name as "@group".
+func AllowedMember(cfg config.Config, user string, groups []string, cleanPath string, perm Perm) (bool, error) {
 	// cleanPath must be slash-path beginning with "/" or "" for root.
 	if cleanPath == "" {
 		cleanPath = "/"
@@ -119,39 +203,33 @@ func Allowed(cfg config.Config, user string, cleanPath string, perm Perm) (bool,
 		return true, nil
 	}
 
-	// First-match ACL by prefix.
-	for _, a := range cfg.ACLs {
-		ap := a.Path
-		if ap == "" {
-			ap = "/"
-		}
-		if !strings.HasPrefix(ap, "/") {
-			ap = "/" + ap
-		}
-		if ap != "/" && strings.HasSuffix(ap, "/") {
-			ap = strings.TrimSuffix(ap, "/")
-		}
-		if cleanPath == ap || strings.HasPrefix(cleanPath, ap+"/") || (ap == "/" && strings.HasPrefix(cleanPath, "/")) {
-			switch perm {
-			case PermRead:
-				return containsUser(a.Read, user), nil
-			case PermWrite:
-				if user == "" {
-					return false, nil
-				}
-				return containsUser(a.Write, user), nil
-			case PermAdmin:
-				if user == "" {
-					return false, nil
-				}
-				return containsUser(a.Admin, user), nil
-			default:
-				return false, errors.New("unknown perm")
+	if deniedBy(cfg, user, groups, cleanPath, perm) {
+		return false, nil
+	}
+	a, n := aclFor(cfg, cleanPath)
+	if guestGrant(cfg, user, cleanPath, perm, n) {
+		return true, nil
+	}
+	if n >= 0 {
+		switch perm {
+		case PermRead:
+			return containsUser(a.Read, user, groups), nil
+		case PermWrite:
+			if user == "" {
+				return false, nil
 			}
+			return containsUser(a.Write, user, groups), nil
+		case PermAdmin:
+			if user == "" {
+				return false, nil
+			}
+			return containsUser(a.Admin, user, groups), nil
+		default:
+			return false, errors.New("unknown perm")
 		}
 	}
 
-	// Default policy when auth enabled but no ACLs:
+	// Default policy when auth enabled but no ACL matches:
 	// - allow read to authenticated users
 	// - deny write/admin
 	switch perm {
@@ -164,17 +242,138 @@ func Allowed(cfg config.Config, user string, cleanPath string, perm Perm) (bool,
 	}
 }
 
-func containsUser(list []string, u string) bool {
+// ACLFor returns the ACL that grants access to cleanPath: the one whose
+// path covers the longest part of it, the first listed on a tie. ACLs with
+// only deny lists are skipped, as are guest grants; both are checked
+// separately (see deniedBy and guestGrant).
+func ACLFor(cfg config.Config, cleanPath string) (config.ACL, bool) {
+	a, n := aclFor(cfg, cleanPath)
+	return a, n >= 0
+}
+
+// aclFor is ACLFor, also returning how long a match the ACL is, or -1
+// without one.
+func aclFor(cfg config.Config, cleanPath string) (config.ACL, int) {
+	best, bestLen := config.ACL{}, -1
+	for _, a := range cfg.ACLs {
+		if denyOnly(a) || guestOnly(cfg, a) {
+			continue
+		}
+		if n, ok := ACLMatch(a.Path, cleanPath); ok && n > bestLen {
+			best, bestLen = a, n
+		}
+	}
+	return best, bestLen
+}
+
+// ACLMatch reports whether ACL path ap covers cleanPath, and how long the
+// part of cleanPath it matched is: ap itself, once cleaned, for a plain
+// path (see aclpath.go for patterns).
+func ACLMatch(ap, cleanPath string) (int, bool) {
+	if IsACLPattern(ap) {
+		return compileACLPath(ap).match(cleanPath)
+	}
+	if ap == "" {
+		ap = "/"
+	}
+	if !strings.HasPrefix(ap, "/") {
+		ap = "/" + ap
+	}
+	if ap != "/" && strings.HasSuffix(ap, "/") {
+		ap = strings.TrimSuffix(ap, "/")
+	}
+	if cleanPath == ap || strings.HasPrefix(cleanPath, ap+"/") || (ap == "/" && strings.HasPrefix(cleanPath, "/")) {
+		return len(ap), true
+	}
+	return 0, false
+}
+
+func denyOnly(a config.ACL) bool {
+	return len(a.Read) == 0 && len(a.Write) == 0 && len(a.Admin) == 0 && !a.OwnersManage &&
+		(len(a.DenyRead) > 0 || len(a.DenyWrite) > 0 || len(a.DenyAdmin) > 0)
+}
+
+// guestOnly reports whether a names nobody but guests (config.Guests) and
+// only grants read or write: a grant made along with a guest account. Such
+// entries never decide a path for anybody else.
+func guestOnly(cfg config.Config, a config.ACL) bool {
+	if len(cfg.Guests) == 0 || len(a.Read)+len(a.Write) == 0 || len(a.Admin) > 0 || a.OwnersManage ||
+		len(a.DenyRead)+len(a.DenyWrite)+len(a.DenyAdmin) > 0 {
+		return false
+	}
+	for _, list := range [][]string{a.Read, a.Write} {
+		for _, u := range list {
+			if _, ok := cfg.Guests[u]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// guestGrant reports whether a guest grant gives user perm on cleanPath.
+// n is how long a match the deciding ACL is: a grant adds to what that ACL
+// allows, but a narrower ACL below the granted folder still decides there.
+func guestGrant(cfg config.Config, user, cleanPath string, perm Perm, n int) bool {
+	if _, ok := cfg.Guests[user]; !ok || perm == PermAdmin {
+		return false
+	}
+	for _, a := range cfg.ACLs {
+		if !guestOnly(cfg, a) {
+			continue
+		}
+		if m, ok := ACLMatch(a.Path, cleanPath); !ok || m < n {
+			continue
+		}
+		list := a.Read
+		if perm == PermWrite {
+			list = a.Write
+		}
+		if slices.Contains(list, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedBy reports whether an ACL covering cleanPath denies user perm. Any
+// covering ACL counts, not just the longest: deny always wins.
+func deniedBy(cfg config.Config, user string, groups []string, cleanPath string, perm Perm) bool {
+	for _, a := range cfg.ACLs {
+		if _, ok := ACLMatch(a.Path, cleanPath); !ok {
+			continue
+		}
+		var deny []string
+		switch perm {
+		case PermRead:
+			deny = a.DenyRead
+		case PermWrite:
+			deny = a.DenyWrite
+		case PermAdmin:
+			deny = a.DenyAdmin
+		}
+		if containsUser(deny, user, groups) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUser(list []string, u string, groups []string) bool {
 	for _, v := range list {
 		v = strings.TrimSpace(v)
 		if v == "" {
 			continue
 		}
+		if g, ok := strings.CutPrefix(v, "@"); ok {
+			if u != "" && slices.Contains(groups, g) {
+				return true
+			}
+			continue
+		}
 		if v == "*" || subtle.ConstantTimeCompare([]byte(v), []byte(u)) == 1 {
 			return true
 		}
 	}
 	return false
 }
-
-
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"testing"
+
+	"lanparty/internal/config"
+)
+
+func TestAllowedMember(t *testing.T) {
+	cfg := config.Config{
+		Users: map[string]config.User{"alice": {}, "bob": {}, "carol": {}},
+		ACLs: []config.ACL{
+			{Path: "/", Read: []string{"*"}, Write: []string{"alice"}},
+			{Path: "/private", DenyRead: []string{"bob"}, DenyWrite: []string{"@guests"}},
+			// A longer rule granting bob read does not undo the deny.
+			{Path: "/private/shared", Read: []string{"bob", "carol"}, Write: []string{"alice", "carol"}},
+			{Path: "/games", Read: []string{"carol"}},
+		},
+	}
+	cases := []struct {
+		user   string
+		groups []string
+		path   string
+		perm   Perm
+		want   bool
+	}{
+		{"bob", nil, "/readme.txt", PermRead, true},
+		{"bob", nil, "/private/x", PermRead, false},
+		{"carol", nil, "/private/x", PermRead, true},
+		{"bob", nil, "/private/shared/x", PermRead, false}, // deny always wins
+		{"carol", nil, "/private/shared/x", PermWrite, true},
+		{"carol", []string{"guests"}, "/private/shared/x", PermWrite, false},
+		{"alice", nil, "/games/x", PermRead, false}, // longest rule grants
+		{"carol", nil, "/games/x", PermRead, true},
+	}
+	for _, c := range cases {
+		got, err := AllowedMember(cfg, c.user, c.groups, c.path, c.perm)
+		if err != nil || got != c.want {
+			t.Errorf("%q %v %s %s: got %v, %v; want %v", c.user, c.groups, c.perm, c.path, got, err, c.want)
+		}
+	}
+}
+
+// A guest's grant adds to what the ACLs allow the guest alone: everybody
+// else follows the other entries, edits included, and a narrower rule
+// still decides below the granted folder.
+func TestGuestGrant(t *testing.T) {
+	cfg := config.Config{
+		Users:  map[string]config.User{"alice": {}, "bob": {}, "guest-x": {}},
+		Guests: map[string]config.Guest{"guest-x": {}},
+		ACLs: []config.ACL{
+			{Path: "/drop", Read: []string{"guest-x"}, Write: []string{"guest-x"}},
+			{Path: "/", Read: []string{"*"}, Write: []string{"alice"}},
+			{Path: "/drop", Read: []string{"alice", "bob"}, Write: []string{"alice", "bob"}},
+			{Path: "/drop/private", Read: []string{"alice"}},
+		},
+	}
+	cases := []struct {
+		user string
+		path string
+		perm Perm
+		want bool
+	}{
+		{"guest-x", "/drop/x", PermWrite, true},
+		{"guest-x", "/readme.txt", PermRead, true},
+		{"guest-x", "/readme.txt", PermWrite, false},
+		{"guest-x", "/drop/private/x", PermRead, false},
+		{"bob", "/drop/x", PermWrite, true},
+		{"alice", "/drop/private/x", PermRead, true},
+	}
+	check := func() {
+		t.Helper()
+		for _, c := range cases {
+			got, err := Allowed(cfg, c.user, c.path, c.perm)
+			if err != nil || got != c.want {
+				t.Errorf("%q %s %s: got %v, %v; want %v", c.user, c.perm, c.path, got, err, c.want)
+			}
+		}
+	}
+	check()
+	// Narrowing /drop afterwards applies to bob; the guest keeps the grant.
+	cfg.ACLs[2].Write = []string{"alice"}
+	cases[4].want = false
+	check()
+}
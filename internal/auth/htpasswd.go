@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"lanparty/internal/config"
+)
+
+// Password hashes: users normally carry a bcrypt hash, but users read from
+// an Apache htpasswd file (config.UsersFile, "lanparty users import") may
+// also carry an md5-crypt one ("$apr1$" from htpasswd -m, or "$1$").
+
+// CheckPassword reports whether pass matches hash.
+func CheckPassword(hash, pass string) bool {
+	if magic, ok := md5CryptMagic(hash); ok {
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, magic), "$")
+		want := md5Crypt(pass, salt, magic)
+		return subtle.ConstantTimeCompare([]byte(want), []byte(hash)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
+
+// SupportedHash reports whether CheckPassword can check hash.
+func SupportedHash(hash string) bool {
+	if _, ok := md5CryptMagic(hash); ok {
+		return true
+	}
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+// ParseHtpasswd reads an htpasswd file. Lines with hashes CheckPassword
+// cannot check (crypt, {SHA}, plain text) are left out and their user
+// names returned in skipped.
+func ParseHtpasswd(b []byte) (users map[string]config.User, skipped []string, err error) {
+	users = map[string]config.User{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("line %d: want user:hash", n)
+		}
+		if !SupportedHash(hash) {
+			skipped = append(skipped, name)
+			continue
+		}
+		users[name] = config.User{Bcrypt: hash}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, err
+	}
+	return users, skipped, nil
+}
+
+func md5CryptMagic(hash string) (string, bool) {
+	for _, magic := range []string{"$apr1$", "$1$"} {
+		if strings.HasPrefix(hash, magic) && strings.Count(hash, "$") == 3 {
+			return magic, true
+		}
+	}
+	return "", false
+}
+
+const md5CryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// md5Crypt is the FreeBSD MD5 crypt (with magic "$1$"), which Apache
+// uses as "$apr1$".
+func md5Crypt(pass, salt, magic string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	p, s := []byte(pass), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(p)
+	alt.Write(s)
+	alt.Write(p)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(p)
+	h.Write([]byte(magic))
+	h.Write(s)
+	for i := len(p); i > 0; i -= 16 {
+		h.Write(altSum[:min(i, 16)])
+	}
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(p[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(p)
+		}
+		sum = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic)
+	out.WriteString(salt)
+	out.WriteByte('$')
+	enc := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			out.WriteByte(md5CryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	enc(sum[0], sum[6], sum[12], 4)
+	enc(sum[1], sum[7], sum[13], 4)
+	enc(sum[2], sum[8], sum[14], 4)
+	enc(sum[3], sum[9], sum[15], 4)
+	enc(sum[4], sum[10], sum[5], 4)
+	enc(0, 0, sum[11], 2)
+	return out.String()
+}
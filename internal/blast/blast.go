@@ -0,0 +1,104 @@
+// Package blast pushes a folder to many receivers at once over UDP multicast.
+//
+// There is no return channel. The sender repeats the whole folder for a
+// number of passes (a "carousel"); receivers join at any time, fill in what
+// they missed on later passes, and verify every file against the SHA-256 in
+// the manifest before giving it its final name.
+//
+// Every packet starts with a 21-byte header:
+//
+//	"LPB1" | type u8 | session u64 | a u32 | b u32
+//
+//	manifest  a = chunk index, b = chunk count; payload = slice of manifest JSON
+//	data      a = file index,  b = block index; payload = block bytes
+//	parity    a = file index,  b = group index; payload = XOR of the group
+//	end       a = pass,        b = 1 on the final pass
+//
+// Blocks are Manifest.Block bytes (the last block of a file is shorter). Every
+// Manifest.FEC data blocks are followed by one parity block, so a receiver
+// can rebuild a single lost block per group without waiting a pass.
+package blast
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// DefaultGroup is the multicast group and port used when none is given.
+const DefaultGroup = "239.255.77.23:7777"
+
+const (
+	magic      = "LPB1"
+	headerSize = 21
+	blockSize  = 1200 // payload per packet; stays below a 1500-byte MTU
+	defaultFEC = 16
+)
+
+const (
+	pktManifest byte = 1
+	pktData     byte = 2
+	pktParity   byte = 3
+	pktEnd      byte = 4
+)
+
+// Manifest describes one blast session.
+type Manifest struct {
+	Block int    `json:"block"`
+	FEC   int    `json:"fec"` // data blocks per parity block; 0 = no parity
+	Files []File `json:"files"`
+}
+
+// File is one file of a Manifest.
+type File struct {
+	Path   string `json:"path"` // slash-separated, relative
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (f File) blocks(block int) int {
+	return int((f.Size + int64(block) - 1) / int64(block))
+}
+
+// blockLen is the length of block i of a file.
+func (f File) blockLen(block, i int) int {
+	off := int64(i) * int64(block)
+	if rest := f.Size - off; rest < int64(block) {
+		return int(rest)
+	}
+	return block
+}
+
+type header struct {
+	typ     byte
+	session uint64
+	a, b    uint32
+}
+
+func putHeader(buf []byte, h header) {
+	copy(buf, magic)
+	buf[4] = h.typ
+	binary.BigEndian.PutUint64(buf[5:], h.session)
+	binary.BigEndian.PutUint32(buf[13:], h.a)
+	binary.BigEndian.PutUint32(buf[17:], h.b)
+}
+
+var errNotBlast = errors.New("blast: not a blast packet")
+
+func parseHeader(buf []byte) (header, []byte, error) {
+	if len(buf) < headerSize || string(buf[:4]) != magic {
+		return header{}, nil, errNotBlast
+	}
+	h := header{
+		typ:     buf[4],
+		session: binary.BigEndian.Uint64(buf[5:]),
+		a:       binary.BigEndian.Uint32(buf[13:]),
+		b:       binary.BigEndian.Uint32(buf[17:]),
+	}
+	return h, buf[headerSize:], nil
+}
+
+func xorInto(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
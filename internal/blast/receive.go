@@ -0,0 +1,374 @@
+package blast
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"lanparty/internal/fsutil"
+)
+
+// partSuffix marks files still being received.
+const partSuffix = ".blast-part"
+
+// ReceiveOptions configures Receive.
+type ReceiveOptions struct {
+	Group   string        // multicast address; default DefaultGroup
+	Iface   string        // interface to join on; "" = system default
+	Timeout time.Duration // give up after the sender is silent this long; default 30s
+	Logf    func(format string, args ...any)
+}
+
+// Result summarizes a Receive.
+type Result struct {
+	Files    int      // files in the manifest
+	Verified int      // files received and verified
+	Bytes    int64    // bytes of verified files
+	Missing  []string // files not (correctly) received
+}
+
+type rxFile struct {
+	File
+	abs    string
+	f      *os.File
+	blocks int
+	have   []uint64 // bitmap of received blocks
+	left   int
+	done   bool // verified and renamed
+	busy   bool // being verified
+}
+
+func (f *rxFile) has(i int) bool { return f.have[i/64]&(1<<(i%64)) != 0 }
+func (f *rxFile) set(i int)      { f.have[i/64] |= 1 << (i % 64); f.left-- }
+
+func (f *rxFile) reset() {
+	clear(f.have)
+	f.left = f.blocks
+}
+
+type verifyResult struct {
+	idx int
+	ok  bool
+}
+
+type receiver struct {
+	dir      string
+	logf     func(string, ...any)
+	session  uint64
+	chunks   map[uint32][]byte
+	m        *Manifest
+	files    []*rxFile
+	pending  int // files not yet done
+	verified chan verifyResult
+	verifies int // verifications in flight
+	pass     uint32
+}
+
+// Receive joins the multicast group and writes the files of the first blast
+// session it hears into dir. It returns when every file is verified, when
+// the sender finishes its last pass, or after opt.Timeout of silence from a
+// sender it has heard.
+func Receive(ctx context.Context, dir string, opt ReceiveOptions) (Result, error) {
+	if opt.Group == "" {
+		opt.Group = DefaultGroup
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = 30 * time.Second
+	}
+	logf := opt.Logf
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	gaddr, err := net.ResolveUDPAddr("udp4", opt.Group)
+	if err != nil {
+		return Result{}, err
+	}
+	var ifi *net.Interface
+	if opt.Iface != "" {
+		if ifi, err = net.InterfaceByName(opt.Iface); err != nil {
+			return Result{}, err
+		}
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Result{}, err
+	}
+	c, err := net.ListenMulticastUDP("udp4", ifi, gaddr)
+	if err != nil {
+		return Result{}, err
+	}
+	defer c.Close()
+	_ = c.SetReadBuffer(16 << 20)
+
+	rx := &receiver{dir: dir, logf: logf, chunks: map[uint32][]byte{}, verified: make(chan verifyResult, 16)}
+	defer rx.cleanup()
+	logf("listening on %s", gaddr)
+
+	buf := make([]byte, 64<<10)
+	last := time.Now()
+	finished := false
+	for ctx.Err() == nil && !finished {
+		rx.collect(false)
+		if rx.m != nil && rx.pending == 0 {
+			break
+		}
+		// Wait for a sender indefinitely, but not for one that went away.
+		if rx.session != 0 && time.Since(last) > opt.Timeout {
+			logf("no packets for %s, giving up", opt.Timeout)
+			break
+		}
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return rx.result(), err
+		}
+		h, payload, err := parseHeader(buf[:n])
+		if err != nil {
+			continue
+		}
+		if rx.session == 0 {
+			rx.session = h.session
+			logf("joined session %016x", h.session)
+		}
+		if h.session != rx.session {
+			continue
+		}
+		last = time.Now()
+		switch h.typ {
+		case pktManifest:
+			err = rx.manifestChunk(h, payload)
+		case pktData:
+			err = rx.data(h, payload)
+		case pktParity:
+			err = rx.parity(h, payload)
+		case pktEnd:
+			if h.b == 1 {
+				finished = true
+			} else if rx.m != nil && h.a != rx.pass {
+				rx.pass = h.a
+				logf("pass %d ended, %d of %d files not yet verified", h.a, rx.pending, len(rx.files))
+			}
+		}
+		if err != nil {
+			return rx.result(), err
+		}
+	}
+	rx.collect(true)
+	return rx.result(), ctx.Err()
+}
+
+func (rx *receiver) manifestChunk(h header, payload []byte) error {
+	if rx.m != nil || h.b == 0 || h.a >= h.b || h.b > 1<<16 {
+		return nil
+	}
+	rx.chunks[h.a] = append([]byte(nil), payload...)
+	if len(rx.chunks) < int(h.b) {
+		return nil
+	}
+	var b []byte
+	for i := uint32(0); i < h.b; i++ {
+		b = append(b, rx.chunks[i]...)
+	}
+	rx.chunks = nil
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("blast: bad manifest: %w", err)
+	}
+	if m.Block <= 0 || m.Block > blockSize || m.FEC < 0 {
+		return fmt.Errorf("blast: unsupported manifest (block %d, fec %d)", m.Block, m.FEC)
+	}
+	rx.files = make([]*rxFile, len(m.Files))
+	var total int64
+	for i, f := range m.Files {
+		abs, err := fsutil.JoinWithinRoot(rx.dir, fsutil.CleanRelPath(f.Path))
+		if err != nil || abs == rx.dir || f.Size < 0 {
+			return fmt.Errorf("blast: bad path in manifest: %q", f.Path)
+		}
+		rf := &rxFile{File: f, abs: abs, blocks: f.blocks(m.Block)}
+		rf.have = make([]uint64, (rf.blocks+63)/64)
+		rf.left = rf.blocks
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			return err
+		}
+		if rf.f, err = os.OpenFile(abs+partSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644); err != nil {
+			return err
+		}
+		if err := rf.f.Truncate(f.Size); err != nil {
+			return err
+		}
+		rx.files[i] = rf
+		total += f.Size
+	}
+	rx.m = &m
+	rx.pending = len(rx.files)
+	rx.logf("manifest: %d files, %d bytes", len(m.Files), total)
+	for i, rf := range rx.files {
+		if rf.left == 0 {
+			rx.verify(i)
+		}
+	}
+	return nil
+}
+
+func (rx *receiver) file(idx uint32) *rxFile {
+	if rx.m == nil || int(idx) >= len(rx.files) {
+		return nil
+	}
+	rf := rx.files[idx]
+	if rf.done || rf.busy {
+		return nil
+	}
+	return rf
+}
+
+func (rx *receiver) data(h header, payload []byte) error {
+	rf := rx.file(h.a)
+	i := int(h.b)
+	if rf == nil || i >= rf.blocks || rf.has(i) || len(payload) != rf.blockLen(rx.m.Block, i) {
+		return nil
+	}
+	if _, err := rf.f.WriteAt(payload, int64(i)*int64(rx.m.Block)); err != nil {
+		return err
+	}
+	rf.set(i)
+	if rf.left == 0 {
+		rx.verify(int(h.a))
+	}
+	return nil
+}
+
+// parity rebuilds the one missing block of a group, if exactly one is
+// missing, from the parity block and the blocks already on disk.
+func (rx *receiver) parity(h header, payload []byte) error {
+	rf := rx.file(h.a)
+	if rf == nil || rx.m.FEC <= 0 || len(payload) != rx.m.Block {
+		return nil
+	}
+	first := int(h.b) * rx.m.FEC
+	end := first + rx.m.FEC
+	if end > rf.blocks {
+		end = rf.blocks
+	}
+	missing := -1
+	for i := first; i < end; i++ {
+		if !rf.has(i) {
+			if missing >= 0 {
+				return nil
+			}
+			missing = i
+		}
+	}
+	if missing < 0 {
+		return nil
+	}
+	out := append([]byte(nil), payload...)
+	blk := make([]byte, rx.m.Block)
+	for i := first; i < end; i++ {
+		if i == missing {
+			continue
+		}
+		n := rf.blockLen(rx.m.Block, i)
+		if _, err := rf.f.ReadAt(blk[:n], int64(i)*int64(rx.m.Block)); err != nil {
+			return err
+		}
+		xorInto(out, blk[:n])
+	}
+	if _, err := rf.f.WriteAt(out[:rf.blockLen(rx.m.Block, missing)], int64(missing)*int64(rx.m.Block)); err != nil {
+		return err
+	}
+	rf.set(missing)
+	if rf.left == 0 {
+		rx.verify(int(h.a))
+	}
+	return nil
+}
+
+// verify hashes a complete file in the background; the result is picked up
+// by collect.
+func (rx *receiver) verify(idx int) {
+	rf := rx.files[idx]
+	rf.busy = true
+	rx.verifies++
+	go func() {
+		h := sha256.New()
+		_, err := io.Copy(h, io.NewSectionReader(rf.f, 0, rf.Size))
+		rx.verified <- verifyResult{idx: idx, ok: err == nil && hex.EncodeToString(h.Sum(nil)) == rf.SHA256}
+	}()
+}
+
+// collect applies finished verifications; with wait it waits for all of them.
+func (rx *receiver) collect(wait bool) {
+	for rx.verifies > 0 {
+		var v verifyResult
+		if wait {
+			v = <-rx.verified
+		} else {
+			select {
+			case v = <-rx.verified:
+			default:
+				return
+			}
+		}
+		rx.verifies--
+		rf := rx.files[v.idx]
+		rf.busy = false
+		if !v.ok {
+			rx.logf("%s: checksum mismatch, receiving again", rf.Path)
+			rf.reset()
+			continue
+		}
+		err := rf.f.Close()
+		rf.f = nil
+		if err == nil {
+			err = os.Rename(rf.abs+partSuffix, rf.abs)
+		}
+		rx.pending--
+		if err != nil {
+			// Stays busy so later packets are ignored; reported as missing.
+			rf.busy = true
+			rx.logf("%s: %v", rf.Path, err)
+			continue
+		}
+		rf.done = true
+		rx.logf("%s: ok", rf.Path)
+	}
+}
+
+func (rx *receiver) result() Result {
+	var res Result
+	for _, rf := range rx.files {
+		res.Files++
+		if rf.done {
+			res.Verified++
+			res.Bytes += rf.Size
+		} else {
+			res.Missing = append(res.Missing, rf.Path)
+		}
+	}
+	return res
+}
+
+// cleanup removes partial files; the next blast starts them from scratch.
+func (rx *receiver) cleanup() {
+	for _, rf := range rx.files {
+		if rf.f != nil {
+			_ = rf.f.Close()
+			_ = os.Remove(rf.abs + partSuffix)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package blast
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// SendOptions configures Send.
+type SendOptions struct {
+	Group    string // multicast address; default DefaultGroup
+	Iface    string // outgoing interface name; "" = system default
+	RateMbit int    // payload rate limit in Mbit/s; default 300
+	Passes   int    // carousel passes; default 2
+	FEC      int    // data blocks per parity block; default 16, -1 disables parity
+	Logf     func(format string, args ...any)
+}
+
+// BuildManifest walks dir and hashes every regular file below it. Symlinks
+// and other special files are skipped.
+func BuildManifest(ctx context.Context, dir string) (Manifest, error) {
+	m := Manifest{Block: blockSize}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, File{Path: filepath.ToSlash(rel), Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+		return nil
+	})
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	return m, err
+}
+
+type sender struct {
+	conn    net.PacketConn
+	dst     net.Addr
+	session uint64
+	rate    float64 // bytes per second
+	start   time.Time
+	sent    int64
+	buf     []byte
+
+	manifest [][]byte // encoded manifest packets
+}
+
+// Send multicasts every file below dir for opt.Passes passes.
+func Send(ctx context.Context, dir string, opt SendOptions) error {
+	if opt.Group == "" {
+		opt.Group = DefaultGroup
+	}
+	if opt.RateMbit <= 0 {
+		opt.RateMbit = 300
+	}
+	if opt.Passes <= 0 {
+		opt.Passes = 2
+	}
+	if opt.FEC == 0 {
+		opt.FEC = defaultFEC
+	} else if opt.FEC < 0 {
+		opt.FEC = 0
+	}
+	logf := opt.Logf
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+
+	dst, err := net.ResolveUDPAddr("udp4", opt.Group)
+	if err != nil {
+		return err
+	}
+	if !dst.IP.IsMulticast() {
+		return fmt.Errorf("blast: %s is not a multicast address", dst.IP)
+	}
+
+	logf("hashing %s", dir)
+	m, err := BuildManifest(ctx, dir)
+	if err != nil {
+		return err
+	}
+	m.FEC = opt.FEC
+	if len(m.Files) == 0 {
+		return errors.New("blast: no files to send")
+	}
+	var total int64
+	for _, f := range m.Files {
+		total += f.Size
+	}
+	logf("%d files, %d bytes", len(m.Files), total)
+
+	// Multicast TTL stays at the system default of 1, so packets never leave
+	// the subnet. Binding to an interface address picks the outgoing interface.
+	laddr := &net.UDPAddr{IP: net.IPv4zero}
+	if opt.Iface != "" {
+		ip, err := ifaceIPv4(opt.Iface)
+		if err != nil {
+			return err
+		}
+		laddr.IP = ip
+	}
+	c, err := net.ListenUDP("udp4", laddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var sid [8]byte
+	if _, err := rand.Read(sid[:]); err != nil {
+		return err
+	}
+	s := &sender{
+		conn:    c,
+		dst:     dst,
+		session: binary.BigEndian.Uint64(sid[:]),
+		rate:    float64(opt.RateMbit) * 1e6 / 8,
+		start:   time.Now(),
+		buf:     make([]byte, headerSize+blockSize),
+	}
+	if err := s.encodeManifest(m); err != nil {
+		return err
+	}
+	logf("session %016x to %s, %d Mbit/s, %d passes", s.session, dst, opt.RateMbit, opt.Passes)
+
+	// Repeat the manifest often enough for late joiners, but keep it a small
+	// share of the traffic.
+	every := 8 * len(s.manifest)
+	if every < 4096 {
+		every = 4096
+	}
+	for pass := 1; pass <= opt.Passes; pass++ {
+		if err := s.sendManifest(); err != nil {
+			return err
+		}
+		since := 0
+		for i, f := range m.Files {
+			err := s.sendFile(ctx, dir, uint32(i), f, m.FEC, func() error {
+				if since++; since < every {
+					return nil
+				}
+				since = 0
+				return s.sendManifest()
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Path, err)
+			}
+		}
+		final := uint32(0)
+		if pass == opt.Passes {
+			final = 1
+		}
+		// END is cheap; repeat it so a lossy link still sees it.
+		for i := 0; i < 5; i++ {
+			putHeader(s.buf, header{typ: pktEnd, session: s.session, a: uint32(pass), b: final})
+			if err := s.write(s.buf[:headerSize]); err != nil {
+				return err
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		logf("pass %d/%d done", pass, opt.Passes)
+	}
+	return nil
+}
+
+func (s *sender) encodeManifest(m Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	n := (len(b) + blockSize - 1) / blockSize
+	for i := 0; i < n; i++ {
+		end := (i + 1) * blockSize
+		if end > len(b) {
+			end = len(b)
+		}
+		pkt := make([]byte, headerSize+end-i*blockSize)
+		putHeader(pkt, header{typ: pktManifest, session: s.session, a: uint32(i), b: uint32(n)})
+		copy(pkt[headerSize:], b[i*blockSize:end])
+		s.manifest = append(s.manifest, pkt)
+	}
+	return nil
+}
+
+func (s *sender) sendManifest() error {
+	for _, pkt := range s.manifest {
+		if err := s.write(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendFile sends the blocks of one file, with a parity block after every
+// group of fec blocks. tick runs after each group.
+func (s *sender) sendFile(ctx context.Context, dir string, idx uint32, f File, fec int, tick func() error) error {
+	fh, err := os.Open(filepath.Join(dir, filepath.FromSlash(f.Path)))
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	r := bufio.NewReaderSize(fh, 1<<20)
+	blocks := f.blocks(blockSize)
+	group := fec
+	if group <= 0 {
+		group = defaultFEC // pacing of tick only
+	}
+	parity := make([]byte, blockSize)
+	for g := 0; g*group < blocks; g++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		clear(parity)
+		for i := g * group; i < blocks && i < (g+1)*group; i++ {
+			n := f.blockLen(blockSize, i)
+			pkt := s.buf[:headerSize+n]
+			if _, err := io.ReadFull(r, pkt[headerSize:]); err != nil {
+				return fmt.Errorf("file changed while sending: %w", err)
+			}
+			putHeader(pkt, header{typ: pktData, session: s.session, a: idx, b: uint32(i)})
+			if err := s.write(pkt); err != nil {
+				return err
+			}
+			xorInto(parity, pkt[headerSize:])
+		}
+		if fec > 0 {
+			pkt := s.buf[:headerSize+blockSize]
+			putHeader(pkt, header{typ: pktParity, session: s.session, a: idx, b: uint32(g)})
+			copy(pkt[headerSize:], parity)
+			if err := s.write(pkt); err != nil {
+				return err
+			}
+		}
+		if err := tick(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write sends one packet, pacing to the configured rate and riding out full
+// socket buffers.
+func (s *sender) write(pkt []byte) error {
+	s.sent += int64(len(pkt))
+	if ahead := time.Duration(float64(s.sent)/s.rate*float64(time.Second)) - time.Since(s.start); ahead > time.Millisecond {
+		time.Sleep(ahead)
+	}
+	for tries := 0; ; tries++ {
+		_, err := s.conn.WriteTo(pkt, s.dst)
+		if err == nil || tries == 100 || !errors.Is(err, syscall.ENOBUFS) {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func ifaceIPv4(name string) (net.IP, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("blast: interface %s has no IPv4 address", name)
+}
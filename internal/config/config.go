@@ -20,6 +20,11 @@ type Config struct {
 	// If true, lanparty only follows symlinks which resolve to a path still inside the share root.
 	FollowSymlinks bool `json:"followSymlinks,omitempty"`
 
+	// ReadOnly rejects uploads, mkdir, rename, move, delete, expiry and
+	// WebDAV writes whatever the ACLs grant, for archives that must not
+	// change. Shares can override it.
+	ReadOnly bool `json:"readOnly,omitempty"`
+
 	// AuthOptional enables "public + authenticated" mode when Users is set:
 	// - requests without Authorization are treated as anonymous
 	// - requests with Authorization are validated; invalid creds get 401
@@ -31,17 +36,458 @@ type Config struct {
 	// "alice": {"bcrypt":"$2a$10$..."}
 	Users map[string]User `json:"users,omitempty"`
 
+	// UsersFile is an Apache htpasswd file (bcrypt or md5-crypt entries)
+	// whose users are added to Users when the config is loaded. Users
+	// already in Users win. The file is re-read when it changes; its
+	// users are never written into the config file.
+	UsersFile string `json:"usersFile,omitempty"`
+
 	// Tokens maps bearer tokens to usernames.
 	// Request header: Authorization: Bearer <token>
 	// The token authenticates as the mapped username (ACLs still apply).
 	Tokens map[string]string `json:"tokens,omitempty"`
 
-	// ACLs is a simple first-match rule list by path prefix.
+	// TokenLabels names tokens ("steam-box", "ansible") for the admin UI.
+	// Keys are tokens from Tokens.
+	TokenLabels map[string]string `json:"tokenLabels,omitempty"`
+
+	// Guests are temporary accounts made with /api/admin/guests. Each
+	// is removed, with its tokens and ACL entries, once it expires.
+	Guests map[string]Guest `json:"guests,omitempty"`
+
+	// LDAP checks passwords of users not in Users against an LDAP
+	// directory or Active Directory.
+	LDAP *LDAP `json:"ldap,omitempty"`
+
+	// OIDC lets users sign in through an OpenID Connect provider
+	// (Authentik, Keycloak, ...) instead of a password kept here.
+	OIDC *OIDC `json:"oidc,omitempty"`
+
+	// ACLs are rules by path prefix; the longest matching path grants, and
+	// deny lists of any matching rule take rights away (see ACL).
 	// If empty:
 	// - no-auth mode: allow read+write
 	// - auth mode: allow read to all authenticated users, deny write
 	ACLs []ACL `json:"acls,omitempty"`
 
+	// Policy lets a Starlark script overrule the ACLs, for rules they
+	// cannot express (upload windows, team roles, address ranges).
+	Policy *Policy `json:"policy,omitempty"`
+
+	// TLS serves HTTPS instead of plain HTTP.
+	TLS *TLS `json:"tls,omitempty"`
+
+	// Homes gives every user a private folder, created on first login and
+	// served as the share /s/~/ ("My files").
+	Homes *Homes `json:"homes,omitempty"`
+
+	// HashLookup asks a reputation service about every finished upload
+	// and shows its verdict in listings.
+	HashLookup *HashLookup `json:"hashLookup,omitempty"`
+
+	// Notify sends messages about events (finished uploads, a nearly
+	// full disk, failed logins) to Discord, Matrix or ntfy.
+	Notify []Notifier `json:"notify,omitempty"`
+
+	// FFmpeg is the ffmpeg binary used for on-the-fly transcoding.
+	// Default: "ffmpeg" from PATH. Transcoding endpoints return 501 if it is missing.
+	FFmpeg string `json:"ffmpeg,omitempty"`
+
+	// Previewers are external commands that render thumbnails and previews
+	// of file types lanparty cannot read itself (STL, PSD, savegames).
+	Previewers []Previewer `json:"previewers,omitempty"`
+
+	// DedupChunkKiB stores blobs no share file links to any more as FastCDC
+	// chunks of about this many KiB (e.g. 1024), each kept once, so
+	// versions of a large file share most of their bytes. A blob needed in
+	// a share again is assembled back into a plain blob and linked. 0
+	// disables chunking.
+	DedupChunkKiB int `json:"dedupChunkKiB,omitempty"`
+
+	// CompressBlobs stores compressible blobs zstd-compressed in the state
+	// dir once no share file links to them any more, or their chunks with
+	// DedupChunkKiB. A blob needed in a share again is decompressed back
+	// into a plain blob and linked.
+	CompressBlobs bool `json:"compressBlobs,omitempty"`
+
+	// StateKeyFile points at a 32-byte AES key (64 hex chars or raw bytes)
+	// used to encrypt blobs, upload .part files, and cached thumbnails at
+	// rest. Keep it off the state drive. Empty disables encryption.
+	StateKeyFile string `json:"stateKeyFile,omitempty"`
+
+	// SpoolDir holds in-flight uploads (multipart bodies, resumable .part
+	// files, WebDAV PUT staging) instead of <stateDir>/uploads, e.g. on a big
+	// HDD or tmpfs. Named shares spool below <spoolDir>/s/<name>.
+	SpoolDir string `json:"spoolDir,omitempty"`
+
+	// PreallocateUploads sizes the .part file of a resumable upload to its
+	// declared size when the session starts (fallocate on Linux), so large
+	// files are not fragmented by chunks arriving one at a time. Encrypted
+	// parts (StateKeyFile) are never preallocated.
+	PreallocateUploads bool `json:"preallocateUploads,omitempty"`
+
+	// UploadSync is when resumable upload data is flushed to disk: "chunk"
+	// (default) before every chunk is acknowledged, or "finish" once when
+	// the upload completes. "finish" is faster on HDDs, but uploads in
+	// flight when the machine crashes start over from the beginning.
+	UploadSync string `json:"uploadSync,omitempty"`
+
+	// ReadCacheMiB keeps copies of repeatedly downloaded files in
+	// <stateDir>/readcache, up to this many MiB (LRU). Only useful when the
+	// share root is slow (NFS, USB, remote) and the state dir is local.
+	// 0 disables the cache.
+	ReadCacheMiB int `json:"readCacheMiB,omitempty"`
+
+	// TombstoneDays is how long deleted and renamed paths are remembered
+	// for /api/changes, so sync clients can propagate removals. Default:
+	// 30; -1 records none.
+	TombstoneDays int `json:"tombstoneDays,omitempty"`
+
+	// TrashDays is how long expired files and folders (/api/expiry) are
+	// kept in <stateDir>/trash before they are deleted. Default: 7; -1
+	// deletes them right away.
+	TrashDays int `json:"trashDays,omitempty"`
+
+	// BasePath mounts lanparty below a URL prefix such as "/files", for
+	// reverse proxies that forward a subpath. Requests work with or without
+	// the prefix (proxies differ in whether they strip it); every URL
+	// lanparty generates carries it.
+	BasePath string `json:"basePath,omitempty"`
+
+	// TrustedProxies lists the reverse proxies (addresses or CIDR
+	// networks) whose X-Forwarded-For / X-Real-IP headers name the client.
+	// Requests from anywhere else are attributed to the connection's
+	// address, whatever headers they carry.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+
+	// DavZipDirs makes GET on a WebDAV collection download the folder as
+	// a zip instead of failing, as rclone- and copyparty-style servers do.
+	DavZipDirs bool `json:"davZipDirs,omitempty"`
+
+	// Wake lists machines admins can wake via /api/admin/wake, by name.
+	// Example: "nas": {"mac":"00:11:22:33:44:55","broadcast":"192.168.1.255"}
+	Wake map[string]WakeHost `json:"wake,omitempty"`
+
+	// Branding replaces the lanparty name, colors and error pages.
+	Branding Branding `json:"branding,omitempty"`
+
+	// ProxySendfile makes /f/ downloads answer with an X-Accel-Redirect or
+	// X-Sendfile header and no body, for a fronting nginx or Apache to send
+	// the file itself. Only enable it when clients cannot reach lanparty
+	// directly: they would get empty responses.
+	ProxySendfile *ProxySendfile `json:"proxySendfile,omitempty"`
+
+	// Disable switches off subsystems: "webdav", "thumbs", "zip", "search",
+	// "uploads" and "admin". Their routes answer 404 and the UI hides them.
+	Disable []string `json:"disable,omitempty"`
+
+	// Limits caps how much heavy work runs at once. Requests over a cap
+	// wait in a short queue and get 429 when it is full.
+	Limits Limits `json:"limits,omitempty"`
+
+	// Throttle caps transfer rates, overall and per user or token.
+	Throttle *Throttle `json:"throttle,omitempty"`
+}
+
+// Throttle configures bandwidth caps in KiB/s; 0 means unlimited.
+// Downloads are /f/, zip streams and WebDAV GET; uploads are multipart,
+// resumable chunks and WebDAV PUT. A transfer gets the smaller share of
+// the overall cap and its own user's or token's.
+type Throttle struct {
+	ThrottleRates
+	// Schedule replaces the rates above during time windows, e.g.
+	// unlimited in the evening or tighter during matches. The first
+	// window that contains the current time wins.
+	Schedule []ThrottleWindow `json:"schedule,omitempty"`
+}
+
+// ThrottleRates are the caps in effect at one time.
+type ThrottleRates struct {
+	// DownKiBps and UpKiBps cap all transfers together.
+	DownKiBps int `json:"downKiBps,omitempty"`
+	UpKiBps   int `json:"upKiBps,omitempty"`
+	// PerUser caps each signed-in user; anonymous requests share one cap.
+	PerUser Rate `json:"perUser,omitempty"`
+	// Users overrides PerUser by user name.
+	Users map[string]Rate `json:"users,omitempty"`
+	// Tokens overrides the user's cap for requests made with a bearer
+	// token, keyed by its label (TokenLabels) or its id from the admin UI.
+	Tokens map[string]Rate `json:"tokens,omitempty"`
+}
+
+// ThrottleWindow is a time window with its own rates; rates it leaves
+// out are unlimited while it lasts.
+type ThrottleWindow struct {
+	// Name shows in the admin overview while the window is in effect.
+	Name string `json:"name,omitempty"`
+	// From and To are local times, "HH:MM". A window whose To is before
+	// its From runs past midnight; equal times mean the whole day.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Days limits the window to days of the week ("mon" ... "sun") it
+	// starts on. Default: every day.
+	Days []string `json:"days,omitempty"`
+	ThrottleRates
+}
+
+// Rate is a pair of bandwidth caps in KiB/s; 0 means unlimited.
+type Rate struct {
+	DownKiBps int `json:"downKiBps,omitempty"`
+	UpKiBps   int `json:"upKiBps,omitempty"`
+}
+
+// Limits are concurrency caps, server-wide and per user. 0 means the default.
+type Limits struct {
+	// Uploads caps concurrent upload requests (multipart, resumable chunks,
+	// WebDAV PUT). Default: unlimited.
+	Uploads int `json:"uploads,omitempty"`
+	// Thumbs caps thumbnails being rendered plus ffmpeg transcodes and
+	// remuxes. Default: 4.
+	Thumbs int `json:"thumbs,omitempty"`
+	// Zips caps zip downloads being streamed. Default: unlimited.
+	Zips int `json:"zips,omitempty"`
+	// Downloads caps file downloads being sent (/f/, WebDAV GET), HEAD
+	// requests and proxySendfile handoffs aside. Default: unlimited.
+	Downloads int `json:"downloads,omitempty"`
+	// PerUser caps what each user (each client address, for anonymous
+	// requests) may run at once, on top of the caps above. A user over
+	// their cap gets 429 right away instead of waiting.
+	PerUser UserLimits `json:"perUser,omitempty"`
+	// Queue is how many requests may wait for each kind of slot; -1
+	// turns them away at once. Default: 32.
+	Queue int `json:"queue,omitempty"`
+	// QueueSeconds is the longest a request waits before it gets 429.
+	// Default: 30.
+	QueueSeconds int `json:"queueSeconds,omitempty"`
+	// MinRate drops uploads, downloads and zip streams whose connection
+	// stays below a rate for long, freeing the slots of clients that
+	// dropped off the Wi-Fi without closing it. Default: no minimum.
+	MinRate MinRate `json:"minRate,omitempty"`
+}
+
+// MinRate is the slowest a transfer may run for a while.
+type MinRate struct {
+	// KiBps is the minimum rate; 0 turns the check off.
+	KiBps int `json:"kibps,omitempty"`
+	// Seconds is how long a transfer may stay below it. Default: 120.
+	Seconds int `json:"seconds,omitempty"`
+}
+
+// UserLimits are per-user concurrency caps; 0 means none.
+type UserLimits struct {
+	Uploads   int `json:"uploads,omitempty"`
+	Downloads int `json:"downloads,omitempty"`
+	Zips      int `json:"zips,omitempty"`
+}
+
+// ProxySendfile configures download offloading to a reverse proxy.
+type ProxySendfile struct {
+	// Mode is "x-accel" (nginx X-Accel-Redirect) or "x-sendfile" (Apache
+	// mod_xsendfile, lighttpd).
+	Mode string `json:"mode"`
+	// Location is the nginx internal location the share roots are mapped
+	// under: "/_lanparty/" + path for the default share and
+	// "/_lanparty/s/<name>/" + path for named shares. x-accel only.
+	Location string `json:"location,omitempty"`
+}
+
+// Branding customizes the web UI for an event or instance.
+type Branding struct {
+	// Name replaces "lanparty" in page titles and the top bar.
+	Name string `json:"name,omitempty"`
+	// Accent is a CSS color (#rgb, #rrggbb or a color name) for links,
+	// buttons and highlights.
+	Accent string `json:"accent,omitempty"`
+	// Logo is an image file shown instead of the folder mark; it is served
+	// to everyone at /branding/logo.
+	Logo string `json:"logo,omitempty"`
+	// Pages maps "403", "404" or "503" to an HTML file that browsers get
+	// instead of the plain-text error. API and WebDAV clients are unaffected.
+	Pages map[string]string `json:"pages,omitempty"`
+}
+
+// Policy configures the access policy script (see internal/policy).
+type Policy struct {
+	// Script is a Starlark file that defines allow(req).
+	Script string `json:"script"`
+	// MaxSteps bounds the work of one decision; a script that needs more
+	// denies the request. Default: 100000.
+	MaxSteps uint64 `json:"maxSteps,omitempty"`
+}
+
+// TLS configures HTTPS. Either Cert and Key are set, or SelfSigned.
+type TLS struct {
+	// Cert and Key are PEM files, as for any web server. Cert may hold
+	// the whole chain.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// SelfSigned generates a certificate into <stateDir>/tls on first run
+	// and keeps using it. Browsers warn until it is trusted.
+	SelfSigned bool `json:"selfSigned,omitempty"`
+	// Hosts are extra names and addresses for the self-signed certificate;
+	// the host name, localhost and this machine's addresses are in it
+	// anyway.
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// HashLookup configures the upload hash lookup: lanparty POSTs
+// {"sha256", "size", "path", "share", "user"} to URL and expects
+// {"verdict": "clean"|"suspicious"|"malicious"|"unknown", "detail", "link"}.
+type HashLookup struct {
+	URL string `json:"url"`
+	// Headers go with every request, e.g. the service's API key.
+	Headers map[string]string `json:"headers,omitempty"`
+	// TimeoutSeconds bounds one lookup. Default: 10.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// Guest records a temporary account: its user and tokens live in Users
+// and Tokens like any other, its grants in ACLs.
+type Guest struct {
+	Label   string `json:"label,omitempty"`
+	Created int64  `json:"created"` // unix seconds
+	Expires int64  `json:"expires"` // unix seconds
+	// ACLs are the entries made for the guest, removed with it.
+	ACLs []ACL `json:"acls,omitempty"`
+}
+
+// LDAP configures password checks by binding to a directory as the user.
+// Either UserDN names the user's entry directly, or lanparty finds it by
+// searching BaseDN for UserFilter, bound as BindDN.
+type LDAP struct {
+	// URL is ldap://host[:389] or ldaps://host[:636].
+	URL string `json:"url"`
+	// StartTLS upgrades an ldap:// connection before anything is sent.
+	StartTLS bool `json:"startTLS,omitempty"`
+	// CAFile is a PEM file of CAs to trust besides the system's, for
+	// directories with a private CA.
+	CAFile string `json:"caFile,omitempty"`
+	// BindDN and BindPassword are the account lanparty searches with.
+	// Empty: search as the user, after binding as UserDN.
+	BindDN       string `json:"bindDN,omitempty"`
+	BindPassword string `json:"bindPassword,omitempty"`
+	// UserDN is the user's DN with {user} for the name, e.g.
+	// "uid={user},ou=people,dc=lan,dc=example". Used instead of a search
+	// when set.
+	UserDN string `json:"userDN,omitempty"`
+	// BaseDN is where users (and, by default, groups) are searched.
+	BaseDN string `json:"baseDN,omitempty"`
+	// UserFilter finds the user's entry, with {user} for the name.
+	// Default: "(uid={user})"; for Active Directory
+	// "(sAMAccountName={user})".
+	UserFilter string `json:"userFilter,omitempty"`
+	// UserAttr, if set, is the attribute of the entry that becomes the
+	// lanparty user name, so its spelling does not depend on what was
+	// typed.
+	UserAttr string `json:"userAttr,omitempty"`
+	// GroupFilter finds the user's groups below GroupBaseDN, with {dn}
+	// for the user's DN and {user} for the name, e.g.
+	// "(member={dn})". Default: the user entry's memberOf attribute.
+	GroupFilter string `json:"groupFilter,omitempty"`
+	GroupBaseDN string `json:"groupBaseDN,omitempty"`
+	// Groups renames directory groups (by cn) to lanparty groups. Groups
+	// it does not name keep their cn.
+	Groups map[string]string `json:"groups,omitempty"`
+	// AllowedGroups, if set, lets only members of these (lanparty) groups
+	// sign in.
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
+	// CacheSeconds is how long a successful check is remembered, so
+	// WebDAV clients that send their password with every request do not
+	// bind every time. Default: 60; -1 disables the cache.
+	CacheSeconds int `json:"cacheSeconds,omitempty"`
+}
+
+// OIDC configures sign-in through an OpenID Connect provider, using the
+// authorization code flow. Register lanparty with the provider as a
+// confidential client whose redirect URI is
+// https://<host><basePath>/login/oidc/callback.
+type OIDC struct {
+	// Issuer is the provider's issuer URL, e.g.
+	// https://auth.example.org/application/o/lanparty/ (Authentik) or
+	// https://kc.example.org/realms/lan (Keycloak). Its
+	// /.well-known/openid-configuration names the endpoints.
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	// RedirectURL overrides the redirect URI lanparty derives from the
+	// request, e.g. behind a proxy that rewrites the host.
+	RedirectURL string `json:"redirectUrl,omitempty"`
+	// Scopes are requested besides "openid". Default: profile, email,
+	// groups.
+	Scopes []string `json:"scopes,omitempty"`
+	// UserClaim names the claim that becomes the lanparty user name.
+	// Default: "preferred_username".
+	UserClaim string `json:"userClaim,omitempty"`
+	// GroupsClaim names the claim listing the user's groups, which ACLs
+	// refer to as "@group". Default: "groups".
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+	// Groups renames provider groups to lanparty groups
+	// ("lan-admins": "admins"). Groups it does not name keep their name.
+	Groups map[string]string `json:"groups,omitempty"`
+	// AllowedGroups, if set, lets only members of these (lanparty) groups
+	// sign in.
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
+}
+
+// Notifier is one notification channel.
+type Notifier struct {
+	// Kind is "discord", "matrix" or "ntfy".
+	Kind string `json:"kind"`
+	// URL is the Discord webhook URL, the Matrix homeserver
+	// (https://matrix.example.org) or the ntfy topic URL
+	// (https://ntfy.sh/my-lan).
+	URL string `json:"url"`
+	// Room is the Matrix room id (!abc:example.org). Matrix only.
+	Room string `json:"room,omitempty"`
+	// Token is the Matrix access token, or an ntfy access token.
+	Token string `json:"token,omitempty"`
+	// Events picks what gets sent: "upload", "diskFull" and "loginFailed".
+	// Default: all of them.
+	Events []string `json:"events,omitempty"`
+	// Paths limits upload events to files below these folders, e.g.
+	// "/drop". Default: everywhere.
+	Paths []string `json:"paths,omitempty"`
+	// DiskPercent is how full a share's disk must be, in percent, for a
+	// diskFull event. Default: 90.
+	DiskPercent int `json:"diskPercent,omitempty"`
+}
+
+// Homes configures per-user home folders.
+type Homes struct {
+	// Dir holds one folder per user. A relative Dir is inside Root, and
+	// only its owner can open a home there. Default: "homes".
+	Dir string `json:"dir,omitempty"`
+	// Dav serves each user's home as their /dav/ root instead of the whole
+	// tree. The tree stays reachable through the web UI and the API.
+	Dav bool `json:"dav,omitempty"`
+	// Template is a folder whose contents are copied into every new home.
+	Template string `json:"template,omitempty"`
+	// ACLs are added in front of the top-level ACLs when /api/admin/users
+	// creates a user, with "{user}" in paths and user lists replaced by
+	// the new name, and removed again when the user is deleted. Entries
+	// for a path inside a home are the only way for others to get in.
+	ACLs []ACL `json:"acls,omitempty"`
+}
+
+// Previewer runs a command to preview files by extension.
+type Previewer struct {
+	// Exts are the extensions it handles, lowercase with the dot (".stl").
+	Exts []string `json:"exts"`
+	// Command is the program and its arguments; "{path}" in an argument is
+	// replaced by the file's absolute path and "{size}" by the wanted size
+	// in pixels (0 for a full preview). It writes a PNG, JPEG, GIF or WebP
+	// image, or an HTML page, to stdout and exits 0.
+	Command []string `json:"command"`
+	// TimeoutSeconds bounds one run. Default: 20.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// WakeHost is a Wake-on-LAN target.
+type WakeHost struct {
+	MAC string `json:"mac"`
+	// Broadcast is the address[:port] the magic packet goes to.
+	// Default: 255.255.255.255:9
+	Broadcast string `json:"broadcast,omitempty"`
 }
 
 // Share is a virtual root mounted under /s/<name>/.
@@ -55,21 +501,68 @@ type Share struct {
 	ACLs []ACL `json:"acls,omitempty"`
 	// FollowSymlinks overrides the global FollowSymlinks setting for this share when set.
 	FollowSymlinks *bool `json:"followSymlinks,omitempty"`
+	// ReadOnly overrides the global ReadOnly setting for this share when set.
+	ReadOnly *bool `json:"readOnly,omitempty"`
+	// Encrypted stores file contents sealed on disk. The share stays locked
+	// (423) until an admin enters its passphrase via /api/admin/unlock.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// ReadCacheMiB overrides the global ReadCacheMiB for this share when set.
+	ReadCacheMiB *int `json:"readCacheMiB,omitempty"`
+	// RAM makes this an ephemeral scratch share on tmpfs. Root and StateDir
+	// are ignored; the share starts empty on every launch.
+	RAM *RAMShare `json:"ram,omitempty"`
+	// DavUsers are logins for this share's WebDAV endpoint only
+	// (/s/<name>/dav/), for devices that should mount one share without
+	// a user account. They see the whole share and nothing else.
+	DavUsers map[string]DavUser `json:"davUsers,omitempty"`
+}
+
+// DavUser is a share-scoped WebDAV login.
+type DavUser struct {
+	Bcrypt string `json:"bcrypt"`
+	// Write allows uploads, renames and deletes; default read-only.
+	Write bool `json:"write,omitempty"`
+}
+
+// RAMShare configures an in-memory share.
+type RAMShare struct {
+	// SizeMiB caps the share contents plus in-flight uploads. Default: 256.
+	SizeMiB int `json:"sizeMiB,omitempty"`
+	// TTLMinutes removes files this long after their content arrived.
+	// Default: 1440 (one day).
+	TTLMinutes int `json:"ttlMinutes,omitempty"`
 }
 
 type User struct {
+	// Bcrypt is the password hash: bcrypt, or md5-crypt ("$apr1$") for
+	// users imported from htpasswd files.
 	Bcrypt string `json:"bcrypt"`
 }
 
+// ACL grants access below Path. Of the ACLs whose path covers a request,
+// the one with the longest path decides what is granted (the first listed,
+// on a tie). Deny lists are the exception to longest-match: those of every
+// ACL covering the request apply, however specific the granting one is, so
+// a deny cannot be undone further down. An ACL with only deny lists grants
+// nothing and just takes people out.
 type ACL struct {
 	// Path is a prefix match, always interpreted as a clean path like "/photos".
+	// With "*" or "?" in it, it is a glob matched per folder name
+	// ("/photos/*/raw", "**" for any number of folders); starting with
+	// "re:", a regular expression matched from the start of the path.
 	Path string `json:"path"`
 	// Read allows listing/downloading.
-	Read []string `json:"read,omitempty"` // usernames or "*"
+	Read []string `json:"read,omitempty"` // usernames, "@group" or "*"
 	// Write allows upload/mkdir/rename/delete.
-	Write []string `json:"write,omitempty"` // usernames
+	Write []string `json:"write,omitempty"` // usernames or "@group"
 	// Admin allows server-side zip, thumbnails, and destructive ops.
-	Admin []string `json:"admin,omitempty"` // usernames
+	Admin []string `json:"admin,omitempty"` // usernames or "@group"
+	// DenyRead, DenyWrite and DenyAdmin take that right away below Path,
+	// whatever any ACL grants, longer ones included.
+	DenyRead  []string `json:"denyRead,omitempty"`  // usernames, "@group" or "*"
+	DenyWrite []string `json:"denyWrite,omitempty"` // usernames, "@group" or "*"
+	DenyAdmin []string `json:"denyAdmin,omitempty"` // usernames, "@group" or "*"
+	// OwnersManage lets users rename and delete files they uploaded here
+	// without write or admin rights.
+	OwnersManage bool `json:"ownersManage,omitempty"`
 }
-
-
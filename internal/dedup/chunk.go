@@ -0,0 +1,357 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Optional chunk-level storage, for idle blobs. Share files are hardlinks
+// to whole plain blobs, so only blobs no file links to any more (replaced
+// versions, deleted files kept for instant uploads, snapshots and
+// /api/blob) can be stored any other way. ChunkIdle splits those into
+// FastCDC chunks kept once each under <stateDir>/chunks/ and replaces the
+// blob with <sha256>.chunks, its chunk list. Versions of a multi-GB file
+// that differ in a few places then share most of their chunks on disk.
+// Like a compressed blob, a chunked one is read through Open, and
+// Store.LinkOrCopy assembles it back into a plain blob when a share needs
+// it again.
+
+const chunkedExt = ".chunks"
+
+// Chunk describes one piece of a file: where it starts, how long it is and
+// its SHA-256. Chunked blobs list theirs; chunked downloads and swarm
+// plans use fixed-size ones.
+type Chunk struct {
+	Off  int64  `json:"off"`
+	Len  int    `json:"len"`
+	Hash string `json:"sha256"`
+}
+
+// chunkList is the content of a .chunks file.
+type chunkList struct {
+	Size   int64   `json:"size"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+func (s *Store) chunkedPath(sha256hex string) string {
+	return s.BlobPath(sha256hex) + chunkedExt
+}
+
+// IsChunked reports whether blobPath is a chunked blob.
+func IsChunked(blobPath string) bool {
+	return strings.HasSuffix(blobPath, chunkedExt)
+}
+
+func (s *Store) chunkDir() string {
+	return filepath.Join(filepath.Dir(s.dir), "chunks")
+}
+
+// chunkPath is where chunk h is stored plain; a compressed chunk has
+// compressedExt appended.
+func (s *Store) chunkPath(h string) string {
+	return filepath.Join(s.chunkDir(), h[:2], h)
+}
+
+func readChunkList(p string) (*chunkList, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var l chunkList
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, fmt.Errorf("dedup: %s: %w", filepath.Base(p), err)
+	}
+	return &l, nil
+}
+
+func (s *Store) openChunk(h string) (io.ReadCloser, error) {
+	p := s.chunkPath(h)
+	if f, err := os.Open(p); err == nil {
+		return f, nil
+	}
+	rc, _, err := openCompressed(p + compressedExt)
+	return rc, err
+}
+
+// openChunked returns a reader over the original content of chunked blob
+// p, plus its original size.
+func (s *Store) openChunked(p string) (io.ReadCloser, int64, error) {
+	l, err := readChunkList(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &chunkReader{s: s, chunks: l.Chunks}, l.Size, nil
+}
+
+// chunkReader reads a chunked blob chunk by chunk, opening each when it
+// gets there.
+type chunkReader struct {
+	s      *Store
+	chunks []Chunk
+	cur    io.ReadCloser
+	left   int64 // of cur
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for c.cur == nil {
+		if len(c.chunks) == 0 {
+			return 0, io.EOF
+		}
+		rc, err := c.s.openChunk(c.chunks[0].Hash)
+		if err != nil {
+			return 0, err
+		}
+		c.cur, c.left = rc, int64(c.chunks[0].Len)
+		c.chunks = c.chunks[1:]
+	}
+	n, err := c.cur.Read(p[:min(int64(len(p)), c.left)])
+	c.left -= int64(n)
+	if c.left == 0 {
+		err = c.cur.Close()
+		c.cur = nil
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *chunkReader) Close() error {
+	if c.cur == nil {
+		return nil
+	}
+	return c.cur.Close()
+}
+
+// ChunkReport says what ChunkIdle did.
+type ChunkReport struct {
+	Blobs  int   `json:"blobs"`  // chunked this run
+	Chunks int   `json:"chunks"` // new chunks stored
+	Saved  int64 `json:"saved"`  // bytes; less than zero while nothing is shared
+	// Removed are chunks no chunked blob uses any more.
+	Removed int `json:"removed"`
+}
+
+// ChunkIdle splits the plain blobs no share file links to into chunks of
+// about avg bytes, storing each chunk compressed where that pays when
+// compress is set. Afterwards it removes chunks no chunked blob uses. It
+// does nothing where the OS does not report link counts.
+func (s *Store) ChunkIdle(ctx context.Context, avg int, compress bool) (ChunkReport, error) {
+	s.chunking.Lock()
+	defer s.chunking.Unlock()
+	var rep ChunkReport
+	started := time.Now()
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return rep, err
+	}
+	for _, e := range ents {
+		if ctx.Err() != nil {
+			return rep, ctx.Err()
+		}
+		name := e.Name()
+		if !e.Type().IsRegular() || !IsHash(name) {
+			continue
+		}
+		n, saved, err := s.chunkIdle(ctx, name, avg, compress)
+		if errors.Is(err, errNoLinkCount) {
+			return rep, nil
+		}
+		if err == nil && n >= 0 {
+			rep.Blobs++
+			rep.Chunks += n
+			rep.Saved += saved
+		}
+	}
+	rep.Removed, err = s.sweepChunks(ctx, started)
+	return rep, err
+}
+
+// chunkIdle chunks blob sha if it is idle and at least two chunks long,
+// returning how many new chunks it stored (-1 if it left the blob alone)
+// and the bytes saved.
+func (s *Store) chunkIdle(ctx context.Context, sha string, avg int, compress bool) (int, int64, error) {
+	p, cp := s.BlobPath(sha), s.chunkedPath(sha)
+	st, ok, err := idle(p)
+	if err != nil || !ok || st.Size() < 2*int64(avg) {
+		return -1, 0, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return -1, 0, err
+	}
+	defer f.Close()
+	l := chunkList{Size: st.Size()}
+	var off, added int64
+	news := 0
+	h := sha256.New()
+	c := NewChunker(ctxReader{ctx, io.TeeReader(f, h)}, avg)
+	for {
+		b, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return -1, 0, err
+		}
+		sum := sha256.Sum256(b)
+		ch := Chunk{Off: off, Len: len(b), Hash: hex.EncodeToString(sum[:])}
+		n, err := s.putChunk(ctx, ch.Hash, b, compress)
+		if err != nil {
+			return -1, 0, err
+		}
+		if n > 0 {
+			news++
+			added += n
+		}
+		l.Chunks = append(l.Chunks, ch)
+		off += int64(len(b))
+	}
+	if off != st.Size() || hex.EncodeToString(h.Sum(nil)) != sha {
+		// Changed under us, or damaged: leave it to Scrub.
+		return -1, 0, nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return -1, 0, err
+	}
+	tmp := cp + ".tmp"
+	if err := writeFrom(bytes.NewReader(b), tmp); err != nil {
+		_ = os.Remove(tmp)
+		return -1, 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now, ok, err := idle(p); err != nil || !ok || !os.SameFile(st, now) {
+		_ = os.Remove(tmp)
+		return -1, 0, err // linked or replaced meanwhile
+	}
+	if err := os.Rename(tmp, cp); err != nil {
+		_ = os.Remove(tmp)
+		return -1, 0, err
+	}
+	if err := os.Remove(p); err != nil {
+		return -1, 0, err
+	}
+	return news, st.Size() - added - int64(len(b)), nil
+}
+
+// putChunk stores chunk h unless it is already there and returns the
+// bytes it wrote.
+func (s *Store) putChunk(ctx context.Context, h string, b []byte, compress bool) (int64, error) {
+	p := s.chunkPath(h)
+	if fileExists(p) || fileExists(p+compressedExt) {
+		return 0, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return 0, err
+	}
+	dst := p
+	if compress && len(b) >= minCompressSize && compresses(b[:min(len(b), compressSample)]) {
+		dst = p + compressedExt
+	}
+	tmp := dst + ".tmp"
+	var err error
+	if dst == p {
+		err = writeFrom(bytes.NewReader(b), tmp)
+	} else {
+		err = writeCompressed(ctx, bytes.NewReader(b), int64(len(b)), tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	st, err := os.Stat(dst)
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+// sweepChunks removes the chunks no chunk list names that are older than
+// since, so the chunks of a blob being chunked are never removed.
+func (s *Store) sweepChunks(ctx context.Context, since time.Time) (int, error) {
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	used := map[string]bool{}
+	for _, e := range ents {
+		if !IsChunked(e.Name()) {
+			continue
+		}
+		l, err := readChunkList(filepath.Join(s.dir, e.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // made plain meanwhile
+		}
+		if err != nil {
+			return 0, err // keep everything rather than guess
+		}
+		for _, c := range l.Chunks {
+			used[c.Hash] = true
+		}
+	}
+	removed := 0
+	err = filepath.WalkDir(s.chunkDir(), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		h := strings.TrimSuffix(d.Name(), compressedExt)
+		if !d.Type().IsRegular() || !IsHash(h) || used[h] {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || !info.ModTime().Before(since) {
+			return nil
+		}
+		if os.Remove(p) == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// plainChunkedLocked assembles chunked blob sha into the plain blob p,
+// checking its hash on the way. s.mu must be held.
+func (s *Store) plainChunkedLocked(sha, p string) error {
+	rc, _, err := s.openChunked(s.chunkedPath(sha))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	tmp := p + ".tmp"
+	if err := writeFrom(io.TeeReader(rc, h), tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sha {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("dedup: chunked blob %s does not match its hash", sha)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = os.Remove(s.chunkedPath(sha))
+	return nil
+}
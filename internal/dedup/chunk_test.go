@@ -0,0 +1,111 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func chunkIdle(t *testing.T, s *Store) ChunkReport {
+	t.Helper()
+	rep, err := s.ChunkIdle(context.Background(), 16<<10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+func readBlob(t *testing.T, s *Store, sha string) []byte {
+	t.Helper()
+	rc, size, err := s.Open(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(b)) != size {
+		t.Fatalf("Open size %d, read %d", size, len(b))
+	}
+	return b
+}
+
+// Two idle versions of a file that differ in one place share most of
+// their chunks, read back whole, and a version linked into a share again
+// is a plain blob whose chunks go once nothing lists them.
+func TestChunkIdle(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(v1)
+	v2 := append(append(append([]byte{}, v1[:300<<10]...), "patched"...), v1[300<<10:]...)
+	sha1, _ := put(t, s, v1)
+	sha2, _ := put(t, s, v2)
+
+	rep := chunkIdle(t, s)
+	if rep.Blobs != 2 {
+		t.Fatalf("chunked %d blobs, want 2", rep.Blobs)
+	}
+	l1, err := readChunkList(s.chunkedPath(sha1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared := len(l1.Chunks)*2 - rep.Chunks; shared < len(l1.Chunks)*3/4 {
+		t.Fatalf("%d new chunks for two versions of %d chunks each", rep.Chunks, len(l1.Chunks))
+	}
+	if rep.Saved < int64(len(v1))/2 {
+		t.Fatalf("saved %d bytes", rep.Saved)
+	}
+	if _, err := os.Stat(s.BlobPath(sha1)); !os.IsNotExist(err) {
+		t.Fatalf("plain blob left behind: %v", err)
+	}
+	if !bytes.Equal(readBlob(t, s, sha1), v1) || !bytes.Equal(readBlob(t, s, sha2), v2) {
+		t.Fatal("chunked blobs read back wrong")
+	}
+	st, err := s.Stats(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Chunked != 2 || st.ChunkedBytes != int64(len(v1)+len(v2)) || st.Chunks != rep.Chunks {
+		t.Fatalf("stats %+v", st)
+	}
+
+	p, ok := s.Lookup(sha2)
+	if !ok || !IsChunked(p) {
+		t.Fatalf("Lookup = %q, %v", p, ok)
+	}
+	file := filepath.Join(t.TempDir(), "game", "data.pak")
+	if err := s.LinkOrCopy(p, file); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil || !bytes.Equal(got, v2) {
+		t.Fatalf("linked file wrong: %v", err)
+	}
+	fst, _ := os.Stat(file)
+	bst, err := os.Stat(s.BlobPath(sha2))
+	if err != nil || !os.SameFile(fst, bst) {
+		t.Fatalf("share file is not the plain blob: %v", err)
+	}
+	if _, ok := s.idlePath(sha2); ok {
+		t.Fatal("chunk list left behind")
+	}
+
+	if rep := chunkIdle(t, s); rep.Blobs != 0 || rep.Removed == 0 {
+		t.Fatalf("second run %+v, want only the removal of the linked version's own chunks", rep)
+	}
+	if !bytes.Equal(readBlob(t, s, sha1), v1) {
+		t.Fatal("sweep removed chunks still in use")
+	}
+	if rep, err := s.Scrub(context.Background(), ""); err != nil || !rep.OK() {
+		t.Fatalf("scrub %+v, %v", rep, err)
+	}
+}
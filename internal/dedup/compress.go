@@ -0,0 +1,315 @@
+package dedup
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"lanparty/internal/fsutil"
+)
+
+// Optional compression at rest, for idle blobs. Share files are hardlinks
+// to plain blobs, so compressing a blob that files still link to would only
+// add a copy. Once no file links to it any more (its files were deleted or
+// replaced, and it stays for instant uploads, snapshots and /api/blob),
+// CompressIdle stores it as <sha256>.zst instead, if that pays. When a
+// compressed blob is needed in a share again, Store.LinkOrCopy turns it back
+// into a plain blob and links that, so files never become copies.
+//
+// A .zst blob is a zstd skippable frame holding the original size, so it
+// can be served with a Content-Length without decompressing it first,
+// followed by one zstd frame: standard tools decompress it as is.
+
+const compressedExt = ".zst"
+
+// minCompressSize is the smallest blob worth compressing.
+const minCompressSize = 4 << 10
+
+// compressSample is how much of a blob is trial-compressed to decide
+// whether compression pays off (already-compressed media does not).
+const compressSample = 64 << 10
+
+// sizeFrameMagic is the first skippable frame magic number (RFC 8878
+// 3.1.2); the frame holds "LP" and the original size.
+const (
+	sizeFrameMagic = 0x184D2A50
+	sizeFrameLen   = 4 + 4 + 2 + 8
+)
+
+func (s *Store) compressedPath(sha256hex string) string {
+	return s.BlobPath(sha256hex) + compressedExt
+}
+
+// IsCompressed reports whether blobPath is a compressed blob.
+func IsCompressed(blobPath string) bool {
+	return strings.HasSuffix(blobPath, compressedExt)
+}
+
+// Open returns a reader over the original content of a blob, transparently
+// decompressing or assembling it, plus its original size.
+func (s *Store) Open(sha256hex string) (io.ReadCloser, int64, error) {
+	if p := s.encryptedPath(sha256hex); fileExists(p) {
+		return s.openEncrypted(p)
+	}
+	if f, err := os.Open(s.BlobPath(sha256hex)); err == nil {
+		st, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, 0, err
+		}
+		return f, st.Size(), nil
+	}
+	if p := s.chunkedPath(sha256hex); fileExists(p) {
+		return s.openChunked(p)
+	}
+	return openCompressed(s.compressedPath(sha256hex))
+}
+
+type zstdFile struct {
+	*zstd.Decoder
+	f *os.File
+}
+
+func (z zstdFile) Close() error {
+	z.Decoder.Close()
+	return z.f.Close()
+}
+
+func openCompressed(p string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	var h [sizeFrameLen]byte
+	if _, err := io.ReadFull(f, h[:]); err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	if binary.LittleEndian.Uint32(h[0:]) != sizeFrameMagic || binary.LittleEndian.Uint32(h[4:]) != sizeFrameLen-8 || h[8] != 'L' || h[9] != 'P' {
+		_ = f.Close()
+		return nil, 0, errors.New("dedup: compressed blob without size header")
+	}
+	zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return zstdFile{Decoder: zr, f: f}, int64(binary.LittleEndian.Uint64(h[10:])), nil
+}
+
+// worthCompressing trial-compresses the head of f and reports whether it
+// shrinks by at least 10%.
+func worthCompressing(f *os.File, size int64) bool {
+	if size < minCompressSize {
+		return false
+	}
+	sample := make([]byte, min(size, compressSample))
+	n, err := io.ReadFull(io.NewSectionReader(f, 0, size), sample)
+	if err != nil || n == 0 {
+		return false
+	}
+	return compresses(sample[:n])
+}
+
+// compresses reports whether sample shrinks by at least 10%.
+func compresses(sample []byte) bool {
+	zw, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return false
+	}
+	defer zw.Close()
+	return len(zw.EncodeAll(sample, nil)) < len(sample)*9/10
+}
+
+// writeCompressed stores size bytes read from src as a compressed blob at
+// dst.
+func writeCompressed(ctx context.Context, src io.Reader, size int64, dst string) error {
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	var h [sizeFrameLen]byte
+	binary.LittleEndian.PutUint32(h[0:], sizeFrameMagic)
+	binary.LittleEndian.PutUint32(h[4:], sizeFrameLen-8)
+	h[8], h[9] = 'L', 'P'
+	binary.LittleEndian.PutUint64(h[10:], uint64(size))
+	_, err = out.Write(h[:])
+	if err == nil {
+		var zw *zstd.Encoder
+		if zw, err = zstd.NewWriter(out, zstd.WithEncoderConcurrency(1)); err == nil {
+			_, err = io.Copy(zw, ctxReader{ctx, io.LimitReader(src, size)})
+			if cerr := zw.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+	}
+	return err
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// CompressReport says what CompressIdle did.
+type CompressReport struct {
+	Blobs int   `json:"blobs"` // compressed this run
+	Saved int64 `json:"saved"` // bytes
+}
+
+// CompressIdle compresses the plain blobs no share file links to, where
+// that pays. It does nothing where the OS does not report link counts.
+func (s *Store) CompressIdle(ctx context.Context) (CompressReport, error) {
+	var rep CompressReport
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return rep, err
+	}
+	for _, e := range ents {
+		if ctx.Err() != nil {
+			return rep, ctx.Err()
+		}
+		name := e.Name()
+		if !e.Type().IsRegular() || !IsHash(name) {
+			continue
+		}
+		saved, err := s.compressIdle(ctx, name)
+		if errors.Is(err, errNoLinkCount) {
+			return rep, nil
+		}
+		if err == nil && saved != 0 {
+			rep.Blobs++
+			rep.Saved += saved
+		}
+	}
+	return rep, nil
+}
+
+var errNoLinkCount = errors.New("dedup: no link counts")
+
+// idle reports whether plain blob p is linked to by no share file.
+func idle(p string) (os.FileInfo, bool, error) {
+	st, err := os.Lstat(p)
+	if err != nil {
+		return nil, false, err
+	}
+	links, ok := fsutil.LinkCount(p, st)
+	if !ok {
+		return nil, false, errNoLinkCount
+	}
+	return st, links == 1, nil
+}
+
+// compressIdle compresses blob sha if it is idle and compressible and
+// returns the bytes saved.
+func (s *Store) compressIdle(ctx context.Context, sha string) (int64, error) {
+	p, zp := s.BlobPath(sha), s.compressedPath(sha)
+	st, ok, err := idle(p)
+	if err != nil || !ok || st.Size() < minCompressSize {
+		return 0, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if !worthCompressing(f, st.Size()) {
+		return 0, nil
+	}
+	// Compress next to the blob, then swap them while nothing can link
+	// to it.
+	tmp := zp + ".tmp"
+	if err := writeCompressed(ctx, f, st.Size(), tmp); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now, ok, err := idle(p); err != nil || !ok || !os.SameFile(st, now) {
+		_ = os.Remove(tmp)
+		return 0, err // linked or replaced meanwhile
+	}
+	zst, err := os.Stat(tmp)
+	if err == nil {
+		err = os.Rename(tmp, zp)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Remove(p); err != nil {
+		return 0, err
+	}
+	return st.Size() - zst.Size(), nil
+}
+
+// plainLocked returns the path of blob sha as a plain file, decompressing
+// or assembling it back into one if it is compressed or chunked. s.mu must
+// be held.
+func (s *Store) plainLocked(sha string) (string, error) {
+	p := s.BlobPath(sha)
+	if fileExists(p) {
+		return p, nil
+	}
+	if fileExists(s.chunkedPath(sha)) {
+		return p, s.plainChunkedLocked(sha, p)
+	}
+	zp := s.compressedPath(sha)
+	rc, _, err := openCompressed(zp)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	tmp := p + ".tmp"
+	if err := writeFrom(rc, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	_ = os.Remove(zp)
+	return p, nil
+}
+
+// writeFrom creates dst from r and syncs it.
+func writeFrom(r io.Reader, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	_ = os.Remove(dst)
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = out.Close() }()
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,126 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func put(t *testing.T, s *Store, content []byte) (string, string) {
+	t.Helper()
+	tmp := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sha, blob, _, err := s.Put(context.Background(), tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha, blob
+}
+
+func compressIdle(t *testing.T, s *Store) int {
+	t.Helper()
+	rep, err := s.CompressIdle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep.Blobs
+}
+
+// Only blobs no share file links to are compressed, and linking one into
+// a share again makes it a plain, hardlinked blob.
+func TestCompressIdle(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte(strings.Repeat("lanparty.cfg: fov 110\n", 4000))
+	sha, blob := put(t, s, content)
+	share := t.TempDir()
+	file := filepath.Join(share, "cfg", "a.cfg")
+	if err := s.LinkOrCopy(blob, file); err != nil {
+		t.Fatal(err)
+	}
+	if n := compressIdle(t, s); n != 0 {
+		t.Fatalf("compressed %d linked blobs", n)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if n := compressIdle(t, s); n != 1 {
+		t.Fatalf("compressed %d blobs, want 1", n)
+	}
+	zp, ok := s.Lookup(sha)
+	if !ok || !IsCompressed(zp) {
+		t.Fatalf("Lookup = %q, %v", zp, ok)
+	}
+	rc, size, err := s.Open(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || size != int64(len(content)) || !bytes.Equal(got, content) {
+		t.Fatalf("Open: %d bytes, size %d, %v", len(got), size, err)
+	}
+
+	if err := s.LinkOrCopy(zp, file); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(zp) {
+		t.Error("compressed blob left behind")
+	}
+	bst, err1 := os.Stat(s.BlobPath(sha))
+	fst, err2 := os.Stat(file)
+	if err1 != nil || err2 != nil || !os.SameFile(bst, fst) {
+		t.Fatalf("share file is not a link to the blob: %v, %v", err1, err2)
+	}
+	if got, _ := os.ReadFile(file); !bytes.Equal(got, content) {
+		t.Error("share file content differs")
+	}
+}
+
+// Uploading the content of a compressed blob again makes the upload the
+// plain blob.
+func TestPutOverCompressed(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte{0, 1, 2, 3}, 8<<10)
+	sha, _ := put(t, s, content)
+	if n := compressIdle(t, s); n != 1 {
+		t.Fatalf("compressed %d blobs, want 1", n)
+	}
+	if _, blob := put(t, s, content); blob != s.BlobPath(sha) {
+		t.Errorf("Put = %q, want the plain blob", blob)
+	}
+	if fileExists(s.compressedPath(sha)) {
+		t.Error("compressed blob left behind")
+	}
+}
+
+func TestSmallAndIncompressibleStayPlain(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	noise := make([]byte, 64<<10)
+	x := uint32(1)
+	for i := range noise {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		noise[i] = byte(x)
+	}
+	put(t, s, []byte("small"))
+	put(t, s, noise)
+	if n := compressIdle(t, s); n != 0 {
+		t.Errorf("compressed %d blobs", n)
+	}
+}
@@ -0,0 +1,124 @@
+package dedup
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"lanparty/internal/sealed"
+)
+
+// Optional encryption at rest. With a key set, new blobs are stored as
+// <sha256>.enc sealed streams (the hash is the associated data). That
+// hides the content, not what it is: blob names are the SHA-256 of the
+// plaintext, so anyone holding the state dir can tell whether it has a
+// file they also have, and the metadata keeps share paths in the clear.
+// Encrypted blobs cannot be hardlinked; Store.LinkOrCopy decrypts them
+// into the share.
+
+const encryptedExt = ".enc"
+
+var errNoKey = errors.New("dedup: encrypted blob but no key configured")
+
+// SetKey enables encryption at rest for blobs stored from now on. Encrypted
+// blobs are never compressed or chunked. Existing plain blobs stay readable.
+func (s *Store) SetKey(k *sealed.Key) {
+	s.key = k
+}
+
+// Key returns the state-dir encryption key, or nil.
+func (s *Store) Key() *sealed.Key {
+	return s.key
+}
+
+func (s *Store) encryptedPath(sha256hex string) string {
+	return s.BlobPath(sha256hex) + encryptedExt
+}
+
+// IsEncrypted reports whether blobPath (as returned by Put) is encrypted.
+func IsEncrypted(blobPath string) bool {
+	return strings.HasSuffix(blobPath, encryptedExt)
+}
+
+func blobHash(blobPath string) string {
+	name := blobPath[strings.LastIndexAny(blobPath, `/\`)+1:]
+	for _, ext := range []string{encryptedExt, compressedExt, chunkedExt} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+func (s *Store) openEncrypted(p string) (io.ReadCloser, int64, error) {
+	if s.key == nil {
+		return nil, 0, errNoKey
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	sr, err := s.key.NewReader(f, []byte(blobHash(p)))
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return readCloser{Reader: sr, Closer: f}, sealed.PlainSize(st.Size()), nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// writeEncrypted stores src as an encrypted blob at dst.
+func (s *Store) writeEncrypted(src *os.File, size int64, dst string) error {
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	sw, err := s.key.NewWriter(out, []byte(blobHash(dst)))
+	if err == nil {
+		_, err = io.Copy(sw, io.NewSectionReader(src, 0, size))
+	}
+	if err == nil {
+		err = sw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// LinkOrCopy materializes a blob returned by Put at dst, decrypting it if
+// needed. A compressed or chunked blob is made plain again first, so it is
+// linked.
+func (s *Store) LinkOrCopy(blobPath, dst string) error {
+	if !IsEncrypted(blobPath) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		p, err := s.plainLocked(blobHash(blobPath))
+		if err != nil {
+			return err
+		}
+		return LinkOrCopy(p, dst)
+	}
+	rc, _, err := s.openEncrypted(blobPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return writeFrom(rc, dst)
+}
@@ -0,0 +1,113 @@
+package dedup
+
+import (
+	"io"
+	"math/bits"
+)
+
+// FastCDC content-defined chunking (Xia et al., 2016): a gear rolling hash
+// with normalized chunking, so boundaries depend on content rather than
+// offsets and an insertion early in a file only disturbs nearby chunks.
+
+// Chunker splits a stream into content-defined chunks.
+type Chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+
+	min, avg, max int
+	maskS, maskL  uint64
+}
+
+// NewChunker returns a chunker targeting avg-byte chunks (clamped to at least
+// 4 KiB and at most 8 MiB). Chunks are between avg/4 and avg*8 bytes,
+// except the last one.
+func NewChunker(r io.Reader, avg int) *Chunker {
+	avg = min(max(avg, 4<<10), 8<<20)
+	b := bits.Len(uint(avg)) - 1 // log2(avg)
+	avg = 1 << b
+	return &Chunker{
+		r:     r,
+		buf:   make([]byte, avg*8),
+		min:   avg / 4,
+		avg:   avg,
+		max:   avg * 8,
+		maskS: highMask(b + 2),
+		maskL: highMask(b - 2),
+	}
+}
+
+// highMask sets the top n bits; the gear hash mixes best into high bits.
+func highMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The slice is
+// only valid until the following call.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.max && !c.eof {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	ch := c.buf[c.start : c.start+n]
+	c.start += n
+	return ch, nil
+}
+
+func (c *Chunker) cut(b []byte) int {
+	n := len(b)
+	if n <= c.min {
+		return n
+	}
+	if n > c.max {
+		n = c.max
+	}
+	normal := c.avg
+	if normal > n {
+		normal = n
+	}
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[b[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[b[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// gear is a fixed table of pseudo-random values (splitmix64 from a constant
+// seed). It must never change: chunks already stored were cut with it.
+var gear = func() (t [256]uint64) {
+	x := uint64(0x6c616e7061727479) // "lanparty"
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
@@ -0,0 +1,183 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ScrubReport summarizes a Scrub run.
+type ScrubReport struct {
+	Blobs    int      `json:"blobs"`
+	Bytes    int64    `json:"bytes"`
+	Corrupt  []string `json:"corrupt"`  // blobs whose content no longer matches their name
+	Misnamed []string `json:"misnamed"` // entries in the blob dir that are not sha256 names
+	Linked   int      `json:"linked"`   // share files hardlinked to a blob
+	// Affected lists share files hardlinked to a corrupt blob; they carry the
+	// same damaged bytes.
+	Affected []ScrubRef `json:"affected"`
+}
+
+// ScrubRef ties a share path to the blob it is hardlinked to.
+type ScrubRef struct {
+	Path string `json:"path"`
+	Blob string `json:"blob"`
+}
+
+// OK reports whether the scrub found no problems.
+func (r *ScrubReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Misnamed) == 0
+}
+
+// Scrub re-hashes every blob and reports corrupt or misnamed entries. When
+// shareRoot is set, files below it are matched against blobs (same inode) so
+// share paths backed by a corrupt blob are reported too. The state dir that
+// holds the blob store is skipped during that walk.
+func (s *Store) Scrub(ctx context.Context, shareRoot string) (*ScrubReport, error) {
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	rep := &ScrubReport{Corrupt: []string{}, Misnamed: []string{}, Affected: []ScrubRef{}}
+	type blob struct {
+		name string
+		info os.FileInfo
+	}
+	bySize := map[int64][]blob{}
+	corrupt := map[string]bool{}
+	buf := make([]byte, 1024*1024)
+	for _, e := range ents {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		name := e.Name()
+		hash := blobHash(name)
+		if !e.Type().IsRegular() || !IsHash(hash) {
+			rep.Misnamed = append(rep.Misnamed, name)
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		rep.Blobs++
+		rep.Bytes += info.Size()
+		sum, err := s.hashFile(ctx, filepath.Join(s.dir, name), buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			rep.Corrupt = append(rep.Corrupt, name)
+			corrupt[name] = true
+			continue
+		}
+		if sum != hash {
+			rep.Corrupt = append(rep.Corrupt, name)
+			corrupt[name] = true
+		}
+		if name == hash {
+			// Only plain blobs are hardlinked into the share.
+			bySize[info.Size()] = append(bySize[info.Size()], blob{name: name, info: info})
+		}
+	}
+	if shareRoot == "" {
+		return rep, nil
+	}
+
+	stateDir := filepath.Dir(s.dir)
+	err = filepath.WalkDir(shareRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if p == stateDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		for _, b := range bySize[info.Size()] {
+			if !os.SameFile(info, b.info) {
+				continue
+			}
+			rep.Linked++
+			if corrupt[b.name] {
+				rel, _ := filepath.Rel(shareRoot, p)
+				rep.Affected = append(rep.Affected, ScrubRef{Path: filepath.ToSlash(rel), Blob: b.name})
+			}
+			break
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(rep.Affected, func(i, j int) bool { return rep.Affected[i].Path < rep.Affected[j].Path })
+	return rep, nil
+}
+
+// hashFile hashes a blob file's original content, decompressing or
+// assembling it if needed.
+func (s *Store) hashFile(ctx context.Context, path string, buf []byte) (string, error) {
+	var f io.ReadCloser
+	var err error
+	switch {
+	case IsEncrypted(path):
+		f, _, err = s.openEncrypted(path)
+	case IsCompressed(path):
+		f, _, err = openCompressed(path)
+	case IsChunked(path):
+		f, _, err = s.openChunked(path)
+	default:
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	for {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		n, rerr := f.Read(buf)
+		if n > 0 {
+			_, _ = h.Write(buf[:n])
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// IsHash reports whether s is a lowercase hex SHA-256, i.e. a valid blob name.
+func IsHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package dedup
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"lanparty/internal/fsutil"
+)
+
+// Stats says how much space the store saves. Share files are hardlinks to
+// plain blobs, so a blob's link count tells how many files it backs without
+// walking the share. Compressed and chunked blobs back no files (see
+// compress.go, chunk.go); encrypted blobs are materialized as copies and
+// save nothing.
+type Stats struct {
+	Blobs         int   `json:"blobs"`
+	PhysicalBytes int64 `json:"physicalBytes"` // blob files on disk
+	// LogicalBytes is what the share files backed by blobs would take
+	// without dedup, SavedBytes what dedup saves of that.
+	LogicalBytes int64 `json:"logicalBytes"`
+	SavedBytes   int64 `json:"savedBytes"`
+	LinkedFiles  int   `json:"linkedFiles"`
+	// Orphans are plain blobs no share file links to any more.
+	Orphans     int   `json:"orphans"`
+	OrphanBytes int64 `json:"orphanBytes"`
+	// Compressed are idle blobs stored compressed; CompressedBytes is
+	// their size on disk.
+	Compressed      int   `json:"compressed"`
+	CompressedBytes int64 `json:"compressedBytes"`
+	// Chunked are idle blobs stored as chunks and ChunkedBytes their
+	// original size; the Chunks they share take ChunkBytes on disk
+	// (counted in PhysicalBytes).
+	Chunked      int   `json:"chunked"`
+	ChunkedBytes int64 `json:"chunkedBytes"`
+	Chunks       int   `json:"chunks"`
+	ChunkBytes   int64 `json:"chunkBytes"`
+	Copied       int   `json:"copied"` // encrypted blobs
+	CopiedBytes  int64 `json:"copiedBytes"`
+	// LinksKnown is false where the OS does not report link counts; the
+	// logical and saved figures are then missing.
+	LinksKnown bool       `json:"linksKnown"`
+	Top        []BlobStat `json:"top"` // most saved first
+}
+
+// BlobStat is one blob in Stats.Top.
+type BlobStat struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Files  int    `json:"files"`
+	Saved  int64  `json:"saved"`
+}
+
+// Stats computes the store's dedup statistics with up to top blobs in
+// Stats.Top. Blobs are only stat'ed, never read; chunk lists are.
+func (s *Store) Stats(ctx context.Context, top int) (*Stats, error) {
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	st := &Stats{LinksKnown: true, Top: []BlobStat{}}
+	for _, e := range ents {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		name := e.Name()
+		hash := blobHash(name)
+		if !e.Type().IsRegular() || !IsHash(hash) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		st.Blobs++
+		st.PhysicalBytes += info.Size()
+		if IsCompressed(name) {
+			st.Compressed++
+			st.CompressedBytes += info.Size()
+			continue
+		}
+		if IsChunked(name) {
+			if l, err := readChunkList(filepath.Join(s.dir, name)); err == nil {
+				st.Chunked++
+				st.ChunkedBytes += l.Size
+			}
+			continue
+		}
+		if name != hash {
+			st.Copied++
+			st.CopiedBytes += info.Size()
+			continue
+		}
+		links, ok := fsutil.LinkCount(filepath.Join(s.dir, name), info)
+		if !ok {
+			st.LinksKnown = false
+			continue
+		}
+		// One link is the blob itself.
+		files := int(links) - 1
+		if files <= 0 {
+			st.Orphans++
+			st.OrphanBytes += info.Size()
+			continue
+		}
+		saved := int64(files-1) * info.Size()
+		st.LinkedFiles += files
+		st.LogicalBytes += int64(files) * info.Size()
+		st.SavedBytes += saved
+		if saved > 0 {
+			st.Top = append(st.Top, BlobStat{SHA256: hash, Size: info.Size(), Files: files, Saved: saved})
+		}
+	}
+	err = filepath.WalkDir(s.chunkDir(), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info, err := d.Info(); err == nil && IsHash(strings.TrimSuffix(d.Name(), compressedExt)) {
+			st.Chunks++
+			st.ChunkBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	st.PhysicalBytes += st.ChunkBytes
+	sort.Slice(st.Top, func(i, j int) bool { return st.Top[i].Saved > st.Top[j].Saved })
+	if len(st.Top) > top {
+		st.Top = st.Top[:top]
+	}
+	return st, nil
+}
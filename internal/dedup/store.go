@@ -9,10 +9,20 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+
+	"lanparty/internal/sealed"
 )
 
 type Store struct {
 	dir string
+	// mu orders turning blobs into compressed or chunked ones and back
+	// against linking them (see compress.go, chunk.go).
+	mu sync.Mutex
+	// chunking serializes ChunkIdle runs.
+	chunking sync.Mutex
+	// key encrypts new blobs at rest (see encrypt.go).
+	key *sealed.Key
 }
 
 // New creates a content-addressed blob store at <stateDir>/blobs.
@@ -38,8 +48,8 @@ func (s *Store) Put(ctx context.Context, tmpFile string) (sha256hex string, blob
 	defer f.Close()
 
 	h := sha256.New()
-	var n int64
 	buf := make([]byte, 1024*1024)
+	var n int64
 	for {
 		if ctx.Err() != nil {
 			return "", "", 0, ctx.Err()
@@ -56,8 +66,43 @@ func (s *Store) Put(ctx context.Context, tmpFile string) (sha256hex string, blob
 			return "", "", 0, rerr
 		}
 	}
+	return s.place(f, tmpFile, hex.EncodeToString(h.Sum(nil)), n)
+}
 
-	sum := hex.EncodeToString(h.Sum(nil))
+// PutHashed is Put for callers that already hashed tmpFile while writing it,
+// sparing a second full read. The hash is trusted; the size is checked.
+func (s *Store) PutHashed(ctx context.Context, tmpFile, sha256hex string, size int64) (string, string, int64, error) {
+	if !IsHash(sha256hex) {
+		return "", "", 0, fmt.Errorf("dedup: bad hash %q", sha256hex)
+	}
+	f, err := os.Open(tmpFile)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", "", 0, err
+	}
+	if st.Size() != size {
+		return "", "", 0, fmt.Errorf("dedup: size mismatch: file=%d expected=%d", st.Size(), size)
+	}
+	return s.place(f, tmpFile, sha256hex, size)
+}
+
+// Lookup returns the path of the stored blob with the given hash (plain,
+// compressed, chunked or encrypted), as Put would have returned it.
+func (s *Store) Lookup(sha256hex string) (string, bool) {
+	for _, p := range []string{s.BlobPath(sha256hex), s.compressedPath(sha256hex), s.chunkedPath(sha256hex), s.encryptedPath(sha256hex)} {
+		if fileExists(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// place stores the hashed content of f (opened from tmpFile) as blob sum.
+func (s *Store) place(f *os.File, tmpFile, sum string, n int64) (sha256hex string, blobPath string, size int64, err error) {
 	dst := s.BlobPath(sum)
 
 	// fast path: blob exists
@@ -65,6 +110,30 @@ func (s *Store) Put(ctx context.Context, tmpFile string) (sha256hex string, blob
 		_ = os.Remove(tmpFile)
 		return sum, dst, st.Size(), nil
 	}
+	if zp, ok := s.idlePath(sum); ok {
+		// The new file is the plain blob again, for linking.
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := os.Rename(tmpFile, dst); err == nil {
+			_ = os.Remove(zp)
+			return sum, dst, n, nil
+		}
+		_ = os.Remove(tmpFile)
+		return sum, zp, n, nil
+	}
+	if ep := s.encryptedPath(sum); fileExists(ep) && s.key != nil {
+		_ = os.Remove(tmpFile)
+		return sum, ep, n, nil
+	}
+
+	if s.key != nil {
+		ep := s.encryptedPath(sum)
+		if err := s.writeEncrypted(f, n, ep); err != nil {
+			return "", "", 0, fmt.Errorf("store blob: %w", err)
+		}
+		_ = os.Remove(tmpFile)
+		return sum, ep, n, nil
+	}
 
 	// move into place (atomic within filesystem)
 	if err := os.Rename(tmpFile, dst); err != nil {
@@ -77,6 +146,17 @@ func (s *Store) Put(ctx context.Context, tmpFile string) (sha256hex string, blob
 	return sum, dst, n, nil
 }
 
+// idlePath returns the path of blob sum if it is stored compressed or
+// chunked.
+func (s *Store) idlePath(sum string) (string, bool) {
+	for _, p := range []string{s.compressedPath(sum), s.chunkedPath(sum)} {
+		if fileExists(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -97,7 +177,13 @@ func copyFile(src, dst string) error {
 	return out.Close()
 }
 
-// LinkOrCopy tries to hardlink blob -> dst; if that fails, it copies.
+func fileExists(p string) bool {
+	st, err := os.Stat(p)
+	return err == nil && st.Mode().IsRegular()
+}
+
+// LinkOrCopy tries to hardlink a plain blob -> dst; if that fails, it
+// copies. Store.LinkOrCopy takes any blob returned by Put.
 func LinkOrCopy(blobPath, dst string) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
 		return err
@@ -108,5 +194,3 @@ func LinkOrCopy(blobPath, dst string) error {
 	}
 	return copyFile(blobPath, dst)
 }
-
-
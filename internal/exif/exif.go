@@ -0,0 +1,180 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// A minimal EXIF reader for JPEG files. It only decodes the handful of tags
+// lanparty uses and never reads past the APP1 segment.
+
+// Info holds the subset of EXIF tags lanparty cares about.
+type Info struct {
+	// Taken is DateTimeOriginal (falling back to DateTime), interpreted in
+	// the local time zone since EXIF carries no offset. Zero if absent.
+	Taken time.Time
+	// Orientation is the TIFF orientation tag (1-8); 0 if absent.
+	Orientation int
+}
+
+// SwapsAxes reports whether the orientation rotates by 90 or 270 degrees,
+// i.e. the displayed width is the stored height.
+func (i *Info) SwapsAxes() bool {
+	return i != nil && i.Orientation >= 5 && i.Orientation <= 8
+}
+
+const (
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+var ErrNoExif = errors.New("exif: no exif data")
+
+// maxSegment bounds how much of the file is scanned for the APP1 segment.
+const maxSegment = 256 << 10
+
+// Read scans a JPEG stream for an Exif APP1 segment and decodes it.
+func Read(r io.Reader) (*Info, error) {
+	br := io.LimitReader(r, maxSegment)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, ErrNoExif
+	}
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, ErrNoExif
+		}
+		if hdr[0] != 0xFF {
+			return nil, ErrNoExif
+		}
+		marker := hdr[1]
+		// SOS / EOI: image data follows, no more metadata segments.
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, ErrNoExif
+		}
+		n := int(binary.BigEndian.Uint16(hdr[2:])) - 2
+		if n < 0 {
+			return nil, ErrNoExif
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, ErrNoExif
+		}
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return parseTIFF(seg[6:])
+		}
+	}
+}
+
+func parseTIFF(b []byte) (*Info, error) {
+	if len(b) < 8 {
+		return nil, ErrNoExif
+	}
+	var bo binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, ErrNoExif
+	}
+	t := tiff{b: b, bo: bo}
+	info := &Info{}
+	ifd0 := t.entries(bo.Uint32(b[4:]))
+	var dateTime string
+	for _, e := range ifd0 {
+		switch e.tag {
+		case tagOrientation:
+			if e.typ == 3 && e.count == 1 {
+				if o := int(t.bo.Uint16(e.raw)); o >= 1 && o <= 8 {
+					info.Orientation = o
+				}
+			}
+		case tagDateTime:
+			dateTime = t.ascii(e)
+		case tagExifIFD:
+			for _, se := range t.entries(e.value) {
+				if se.tag == tagDateTimeOriginal {
+					info.Taken = parseDate(t.ascii(se))
+				}
+			}
+		}
+	}
+	if info.Taken.IsZero() {
+		info.Taken = parseDate(dateTime)
+	}
+	return info, nil
+}
+
+type tiff struct {
+	b  []byte
+	bo binary.ByteOrder
+}
+
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value uint32 // inline value or offset
+	raw   []byte // the 4 value bytes as stored
+}
+
+func (t tiff) entries(off uint32) []entry {
+	if int(off)+2 > len(t.b) {
+		return nil
+	}
+	n := int(t.bo.Uint16(t.b[off:]))
+	p := int(off) + 2
+	out := make([]entry, 0, n)
+	for i := 0; i < n && p+12 <= len(t.b); i++ {
+		e := t.b[p : p+12]
+		out = append(out, entry{
+			tag:   t.bo.Uint16(e[0:]),
+			typ:   t.bo.Uint16(e[2:]),
+			count: t.bo.Uint32(e[4:]),
+			value: t.bo.Uint32(e[8:]),
+			raw:   e[8:12],
+		})
+		p += 12
+	}
+	return out
+}
+
+func (t tiff) ascii(e entry) string {
+	if e.typ != 2 || e.count == 0 {
+		return ""
+	}
+	var s []byte
+	if e.count <= 4 {
+		s = e.raw[:e.count]
+	} else {
+		end := int(e.value) + int(e.count)
+		if end > len(t.b) || end < int(e.value) {
+			return ""
+		}
+		s = t.b[e.value:end]
+	}
+	return strings.TrimRight(string(s), "\x00 ")
+}
+
+func parseDate(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	ts, err := time.ParseInLocation("2006:01:02 15:04:05", s, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return ts
+}
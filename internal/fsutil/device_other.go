@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fsutil
+
+import "os"
+
+// DeviceID is not supported on this platform.
+func DeviceID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
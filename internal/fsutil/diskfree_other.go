@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package fsutil
+
+import "errors"
+
+// DiskFree is not supported on this platform.
+func DiskFree(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("fsutil: disk free not supported")
+}
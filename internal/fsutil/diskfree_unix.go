@@ -0,0 +1,16 @@
+//go:build unix
+
+package fsutil
+
+import "syscall"
+
+// DiskFree returns the bytes available to unprivileged users and the total
+// size of the filesystem holding path.
+func DiskFree(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bs := uint64(st.Bsize)
+	return uint64(st.Bavail) * bs, uint64(st.Blocks) * bs, nil
+}
@@ -47,9 +47,9 @@ func JoinWithinRoot(rootAbs string, rel string) (string, error) {
 
 // ResolveWithinRoot resolves rel under rootAbs with an explicit symlink policy.
 //
-// - If followSymlinks is false: rejects any request whose resolved path would traverse a symlink
-//   in any existing path component (prevents symlink escape).
-// - If followSymlinks is true: evaluates symlinks and requires the resolved path to remain within rootAbs.
+//   - If followSymlinks is false: rejects any request whose resolved path would traverse a symlink
+//     in any existing path component (prevents symlink escape).
+//   - If followSymlinks is true: evaluates symlinks and requires the resolved path to remain within rootAbs.
 //
 // This function is for security: JoinWithinRoot blocks ".." escapes, but it does not defend against
 // symlinks inside the root pointing outside.
@@ -119,4 +119,11 @@ func ResolveWithinRoot(rootAbs string, rel string, followSymlinks bool) (string,
 	return real, nil
 }
 
-
+// RAMDir returns a directory for memory-backed scratch data: /dev/shm where
+// it exists (tmpfs on Linux), the OS temp dir otherwise.
+func RAMDir() string {
+	if st, err := os.Stat("/dev/shm"); err == nil && st.IsDir() {
+		return "/dev/shm"
+	}
+	return os.TempDir()
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package fsutil
+
+import "os"
+
+// LinkCount is not supported on this platform.
+func LinkCount(path string, info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package fsutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// LinkCount returns the number of hard links to the file at path, which
+// info describes.
+func LinkCount(path string, info os.FileInfo) (uint64, bool) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+	h, err := syscall.CreateFile(p, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, false
+	}
+	defer syscall.CloseHandle(h)
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &d); err != nil {
+		return 0, false
+	}
+	return uint64(d.NumberOfLinks), true
+}
@@ -0,0 +1,79 @@
+package httpserver
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// Reachable addresses: what a client could type to get here, one URL per
+// interface address when the server listens on all of them. IPv6
+// link-local addresses only mean something together with an interface, so
+// their URLs carry it as the zone ("http://[fe80::1%25eth0]:3923/"). The
+// zone names the server's interface; a client on the same segment puts
+// its own interface there instead.
+
+// ReachableURL is one address the server answers on.
+type ReachableURL struct {
+	Interface string `json:"interface,omitempty"`
+	URL       string `json:"url"`
+	LinkLocal bool   `json:"linkLocal,omitempty"`
+}
+
+// HostURL returns scheme://host:port, with host bracketed and its zone
+// escaped where it is an IPv6 address.
+func HostURL(scheme, host string, port int) string {
+	return (&url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port))}).String()
+}
+
+// ReachableURLs lists the URLs of the address Listener was given, per
+// interface address if it is a wildcard (IPv4 ones only for 0.0.0.0).
+// Loopback addresses come last. Unix sockets have none.
+func (s *Server) ReachableURLs(scheme string) []ReachableURL {
+	a, ok := s.listenAddr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	if !a.IP.IsUnspecified() {
+		host := (&net.IPAddr{IP: a.IP, Zone: a.Zone}).String()
+		return []ReachableURL{{Interface: a.Zone, URL: HostURL(scheme, host, a.Port), LinkLocal: a.IP.IsLinkLocalUnicast()}}
+	}
+	v4only := a.IP.To4() != nil
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out, loop []ReachableURL
+	for _, ifc := range ifaces {
+		if ifc.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, ad := range addrs {
+			ipn, ok := ad.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipn.IP
+			v6 := ip.To4() == nil
+			if v6 && v4only {
+				continue
+			}
+			host := ip.String()
+			ll := v6 && ip.IsLinkLocalUnicast()
+			if ll {
+				host += "%" + ifc.Name
+			}
+			ru := ReachableURL{Interface: ifc.Name, URL: HostURL(scheme, host, a.Port), LinkLocal: ll}
+			if ip.IsLoopback() {
+				loop = append(loop, ru)
+			} else {
+				out = append(out, ru)
+			}
+		}
+	}
+	return append(out, loop...)
+}
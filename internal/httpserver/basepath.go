@@ -0,0 +1,130 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Base path: with config.BasePath set (say "/files") lanparty is mounted
+// below that prefix behind a reverse proxy. Requests may arrive with the
+// prefix, which is stripped here, or without it when the proxy strips it
+// itself. Everything handed back to clients carries it: pages, redirects,
+// cookies, file and thumbnail URLs, WebDAV hrefs.
+
+// basePathReserved are the top-level names lanparty routes; a base path
+// starting with one would make stripped and unstripped requests ambiguous.
+var basePathReserved = []string{
+	"admin", "api", "assets", "branding", "dav", "f", "favicon.ico",
+	"healthz", "login", "logout", "s", "t", "thumb", "unauthorized",
+}
+
+// cleanBasePath returns p as "/a/b", or "" for the root.
+func cleanBasePath(p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return ""
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return ""
+	}
+	return p
+}
+
+func checkBasePath(p string) error {
+	if p == "" {
+		return nil
+	}
+	if p != cleanBasePath(p) {
+		return fmt.Errorf("%q: use the form /a/b", p)
+	}
+	if strings.ContainsAny(p, "\"'<>?#%\\ ") {
+		return fmt.Errorf("%q: only plain path characters are allowed", p)
+	}
+	first, _, _ := strings.Cut(p[1:], "/")
+	for _, r := range basePathReserved {
+		if first == r {
+			return errors.New(p + ": /" + r + " is one of lanparty's own paths")
+		}
+	}
+	return nil
+}
+
+// basePath is the configured mount prefix, or "".
+func (s *Server) basePath() string {
+	return s.config().BasePath
+}
+
+// underBasePath strips the base path from requests that carry it.
+func (s *Server) underBasePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := s.basePath()
+		if base == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == base {
+			u := base + "/"
+			if r.URL.RawQuery != "" {
+				u += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, u, http.StatusFound)
+			return
+		}
+		rest, ok := strings.CutPrefix(r.URL.Path, base+"/")
+		if !ok {
+			next.ServeHTTP(w, r) // the proxy stripped it
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + rest
+		r2.URL.RawPath = ""
+		if raw, ok := strings.CutPrefix(r.URL.RawPath, base+"/"); ok {
+			r2.URL.RawPath = "/" + raw
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// mountPrefix returns the URL path the client sees in front of r.URL.Path:
+// the base path plus /s/<share> as the client wrote it.
+func (s *Server) mountPrefix(r *http.Request) string {
+	base := s.basePath()
+	u, err := url.ParseRequestURI(r.RequestURI)
+	if err != nil || !strings.HasSuffix(u.Path, r.URL.Path) {
+		return s.sharePrefix(r)
+	}
+	p := strings.TrimSuffix(u.Path, r.URL.Path)
+	if base != "" && p != base && !strings.HasPrefix(p, base+"/") {
+		p = base + p
+	}
+	return p
+}
+
+// basePathHTML points a page's root-relative links below base and tells
+// the scripts where they are mounted.
+func basePathHTML(b []byte, base string) []byte {
+	if base == "" {
+		return b
+	}
+	for _, attr := range []string{` href="/`, ` src="/`, ` action="/`} {
+		b = bytes.ReplaceAll(b, []byte(attr), []byte(attr[:len(attr)-1]+base+"/"))
+	}
+	const bodyTag = "<body"
+	idx := bytes.Index(b, []byte(bodyTag))
+	if idx < 0 {
+		return b
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(b) + len(base) + 20)
+	buf.Write(b[:idx+len(bodyTag)])
+	buf.WriteString(` data-base-path="` + html.EscapeString(base) + `"`)
+	buf.Write(b[idx+len(bodyTag):])
+	return buf.Bytes()
+}
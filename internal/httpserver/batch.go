@@ -0,0 +1,408 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+	"lanparty/internal/fsutil"
+)
+
+// Batches: /api/batch runs a list of mkdir, rename, move and write
+// operations in order and stops at the first that fails. What the earlier
+// ones did is then undone, last first: folders they created are removed
+// again (if still empty), renamed and moved items go back, written files
+// are deleted or get their old content back. Undoing is best effort; the
+// reply says for each operation whether it stayed done, was undone or
+// could not be undone.
+//
+// To keep every step reversible, renames and moves never replace an
+// existing item, and all permissions are checked before the first
+// operation runs. Batches hold the write lock of /api/write throughout, so
+// they do not interleave with editor saves or with each other.
+
+const maxBatchOps = 256
+
+type batchOp struct {
+	Op      string `json:"op"` // mkdir|rename|move|write
+	Path    string `json:"path,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	DestDir string `json:"destDir,omitempty"`
+	Content string `json:"content,omitempty"`
+	Mode    string `json:"mode,omitempty"` // write: overwrite|rename|skip|error
+}
+
+type batchResult struct {
+	Op     string `json:"op"`
+	Path   string `json:"path,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Status string `json:"status"` // ok|skipped|error|undone|undo failed|not run
+	Error  string `json:"error,omitempty"`
+}
+
+// batchError is an operation failure with the message reported for it.
+type batchError struct{ msg string }
+
+func (e batchError) Error() string { return e.msg }
+
+// handleBatch runs several file operations as one.
+//
+//	POST /api/batch {"ops": [{"op": "mkdir", "path"},
+//	                         {"op": "rename", "from", "to"},
+//	                         {"op": "move", "from", "destDir"},
+//	                         {"op": "write", "path", "content", "mode"}]}
+//	  -> {ok, rolledBack, failed, results: [{op, path, from, to, status, error}]}
+//
+// failed is the index of the operation that failed (-1 if none);
+// rolledBack is false if some of what ran before it could not be undone.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Ops []batchOp `json:"ops"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if len(req.Ops) == 0 {
+		http.Error(w, "missing ops", http.StatusBadRequest)
+		return
+	}
+	if len(req.Ops) > maxBatchOps {
+		http.Error(w, "too many ops", http.StatusBadRequest)
+		return
+	}
+	// Check the whole batch before touching anything.
+	for i := range req.Ops {
+		op := &req.Ops[i]
+		op.Op = strings.ToLower(strings.TrimSpace(op.Op))
+		op.Mode = strings.ToLower(strings.TrimSpace(op.Mode))
+		var paths []string
+		switch op.Op {
+		case "mkdir":
+			op.Path = fsutil.CleanRelPath(op.Path)
+			paths = []string{op.Path}
+		case "rename":
+			op.From, op.To = fsutil.CleanRelPath(op.From), fsutil.CleanRelPath(op.To)
+			if op.From == "" || op.To == "" {
+				http.Error(w, fmt.Sprintf("op %d: missing from or to", i), http.StatusBadRequest)
+				return
+			}
+			paths = []string{op.From, op.To}
+		case "move":
+			op.From, op.DestDir = fsutil.CleanRelPath(op.From), fsutil.CleanRelPath(op.DestDir)
+			if op.From == "" {
+				http.Error(w, fmt.Sprintf("op %d: missing from", i), http.StatusBadRequest)
+				return
+			}
+			op.To = joinRel(op.DestDir, path.Base(op.From))
+			paths = []string{op.From, op.To}
+		case "write":
+			op.Path = fsutil.CleanRelPath(op.Path)
+			if op.Path == "" {
+				http.Error(w, fmt.Sprintf("op %d: missing path", i), http.StatusBadRequest)
+				return
+			}
+			if op.Mode == "" {
+				op.Mode = "overwrite"
+			}
+			if op.Mode != "overwrite" && op.Mode != "rename" && op.Mode != "skip" && op.Mode != "error" {
+				http.Error(w, fmt.Sprintf("op %d: bad mode", i), http.StatusBadRequest)
+				return
+			}
+			if len(op.Content) > maxEditSize {
+				http.Error(w, fmt.Sprintf("op %d: too large", i), http.StatusRequestEntityTooLarge)
+				return
+			}
+			paths = []string{op.Path}
+		default:
+			http.Error(w, fmt.Sprintf("op %d: unknown op %q", i, op.Op), http.StatusBadRequest)
+			return
+		}
+		for _, p := range paths {
+			if ok, err := s.allowed(r, auth.PermWrite, "/"+p); err != nil || !ok {
+				if s.shouldChallenge(r) {
+					s.authChallenge(w)
+				} else {
+					http.Error(w, "forbidden", http.StatusForbidden)
+				}
+				return
+			}
+		}
+	}
+
+	cfg := s.cfgForReq(r)
+	ctx := r.Context()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	results := make([]batchResult, len(req.Ops))
+	undo := make([]func() error, 0, len(req.Ops))
+	var cleanup []string // backups of overwritten files, dropped when done
+	defer func() {
+		for _, p := range cleanup {
+			_ = os.Remove(p)
+		}
+	}()
+	failed := -1
+	for i, op := range req.Ops {
+		res := &results[i]
+		*res = batchResult{Op: op.Op, Path: op.Path, From: op.From, To: op.To, Status: "ok"}
+		if ctx.Err() != nil && failed < 0 {
+			failed = i
+			res.Status, res.Error = "error", "canceled"
+		}
+		if failed >= 0 {
+			if i != failed {
+				res.Status = "not run"
+			}
+			continue
+		}
+		var (
+			u   func() error
+			err error
+		)
+		switch op.Op {
+		case "mkdir":
+			u, err = s.batchMkdir(cfg, op.Path)
+		case "rename", "move":
+			u, err = s.batchRename(r, cfg, op.From, op.To)
+		case "write":
+			var backup string
+			u, backup, err = s.batchWrite(r, cfg, op, res)
+			if backup != "" {
+				cleanup = append(cleanup, backup)
+			}
+		}
+		if err != nil {
+			failed = i
+			res.Status = "error"
+			var be batchError
+			if errors.As(err, &be) {
+				res.Error = be.msg
+			} else {
+				res.Error = op.Op + " failed"
+			}
+			continue
+		}
+		undo = append(undo, u)
+	}
+
+	rolledBack := true
+	if failed >= 0 {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if results[i].Status == "skipped" {
+				continue
+			}
+			results[i].Status = "undone"
+			if undo[i] == nil {
+				continue // it changed nothing
+			}
+			if err := undo[i](); err != nil {
+				rolledBack = false
+				results[i].Status = "undo failed"
+			}
+		}
+	}
+	writeJSON(w, map[string]any{"ok": failed < 0, "rolledBack": failed >= 0 && rolledBack, "failed": failed, "results": results})
+}
+
+// batchMkdirAll creates dir and its missing parents and returns an undo
+// that removes what it created, if still empty.
+func batchMkdirAll(dir string) (func() error, error) {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if len(created) == 0 {
+		return nil, nil
+	}
+	return func() error {
+		// created runs deepest first.
+		for _, d := range created {
+			if err := os.Remove(d); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+func (s *Server) batchMkdir(cfg config.Config, rel string) (func() error, error) {
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		return nil, batchError{"bad path"}
+	}
+	if st, err := os.Stat(abs); err == nil && !st.IsDir() {
+		return nil, batchError{"not a directory"}
+	}
+	return batchMkdirAll(abs)
+}
+
+// batchRename moves fromRel to toRel, which must not exist, copying where
+// a rename cannot.
+func (s *Server) batchRename(r *http.Request, cfg config.Config, fromRel, toRel string) (func() error, error) {
+	fromAbs, err := fsutil.ResolveWithinRoot(cfg.Root, fromRel, cfg.FollowSymlinks)
+	if err != nil {
+		return nil, batchError{"bad from"}
+	}
+	toAbs, err := fsutil.ResolveWithinRoot(cfg.Root, toRel, cfg.FollowSymlinks)
+	if err != nil {
+		return nil, batchError{"bad to"}
+	}
+	st, err := os.Stat(fromAbs)
+	if err != nil {
+		return nil, batchError{"not found"}
+	}
+	if _, err := os.Lstat(toAbs); err == nil {
+		return nil, batchError{"destination exists"}
+	}
+	if err := validateTransferTargets(st, fromAbs, toAbs); err != nil {
+		return nil, batchError{err.Error()}
+	}
+	undoDirs, err := batchMkdirAll(filepath.Dir(toAbs))
+	if err != nil {
+		return nil, batchError{"mkdir failed"}
+	}
+	if err := moveItem(r.Context(), st, fromAbs, toAbs); err != nil {
+		if undoDirs != nil {
+			_ = undoDirs()
+		}
+		return nil, err
+	}
+	s.recordRemoval(r, fromRel, toRel)
+	return func() error {
+		st, err := os.Stat(toAbs)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(fromAbs); err == nil {
+			return os.ErrExist
+		}
+		// Not the request's context: a client that went away still gets
+		// its batch undone.
+		if err := moveItem(context.Background(), st, toAbs, fromAbs); err != nil {
+			return err
+		}
+		s.recordRemoval(r, toRel, fromRel)
+		if undoDirs != nil {
+			return undoDirs()
+		}
+		return nil
+	}, nil
+}
+
+// moveItem renames src to dst, or copies and deletes it across devices.
+func moveItem(ctx context.Context, st os.FileInfo, src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if st.IsDir() {
+		if err := copyDirNoSymlinks(ctx, src, dst, false); err != nil {
+			_ = os.RemoveAll(dst)
+			return err
+		}
+		return os.RemoveAll(src)
+	}
+	if err := copyFileAtomic(ctx, src, dst, false); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// batchWrite writes op's content like /api/write. An overwritten file is
+// kept as backup (in the spool dir) until the batch is over.
+func (s *Server) batchWrite(r *http.Request, cfg config.Config, op batchOp, res *batchResult) (undo func() error, backup string, err error) {
+	rel := op.Path
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		return nil, "", batchError{"bad path"}
+	}
+	spool := s.spoolDir(r)
+	_ = os.MkdirAll(spool, 0o755)
+	if st, err := os.Stat(abs); err == nil {
+		if st.IsDir() {
+			return nil, "", batchError{"is a directory"}
+		}
+		switch op.Mode {
+		case "skip":
+			res.Status = "skipped"
+			return nil, "", nil
+		case "error":
+			return nil, "", batchError{"destination exists"}
+		case "rename":
+			parentRel := strings.TrimPrefix(path.Dir("/"+rel), "/")
+			nm, err := uniqueNameInDir(filepath.Dir(abs), filepath.Base(rel))
+			if err != nil {
+				return nil, "", err
+			}
+			rel = joinRel(parentRel, nm)
+			if abs, err = fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks); err != nil {
+				return nil, "", batchError{"bad path"}
+			}
+			res.Path = rel
+		case "overwrite":
+			backup = filepath.Join(spool, fmt.Sprintf("batch-%d.bak", time.Now().UnixNano()))
+			if err := os.Link(abs, backup); err != nil {
+				if err := copyFileAtomic(r.Context(), abs, backup, false); err != nil {
+					return nil, "", err
+				}
+			}
+		}
+	}
+	undoDirs, err := batchMkdirAll(filepath.Dir(abs))
+	if err != nil {
+		return nil, backup, batchError{"mkdir failed"}
+	}
+	tmp := filepath.Join(spool, fmt.Sprintf("wr-%d.tmp", time.Now().UnixNano()))
+	if err := os.WriteFile(tmp, []byte(op.Content), 0o644); err != nil {
+		return nil, backup, err
+	}
+	sum := sha256.Sum256([]byte(op.Content))
+	restore := func() error {
+		if err := os.Rename(backup, abs); err == nil {
+			return nil
+		}
+		return copyFileAtomic(context.Background(), backup, abs, true)
+	}
+	if _, _, err := s.commitBlob(r, tmp, hex.EncodeToString(sum[:]), rel, abs); err != nil {
+		if backup != "" {
+			_ = restore()
+		}
+		return nil, backup, err
+	}
+	return func() error {
+		if backup == "" {
+			if err := os.Remove(abs); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if undoDirs != nil {
+				return undoDirs()
+			}
+			return nil
+		}
+		return restore()
+	}, backup, nil
+}
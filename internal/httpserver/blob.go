@@ -0,0 +1,303 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/dedup"
+	"lanparty/internal/fsutil"
+)
+
+// Content-addressed access to the dedup blob store.
+//
+// Every time a blob is linked into the share its relative path is recorded in
+// the "blobrefs" metadata store (sha256 -> paths). /api/blob/<sha256> only
+// serves a blob if the caller can read at least one path that still holds
+// its content, so hashes never leak content the caller could not otherwise
+// reach.
+
+// uploaded runs what follows a finished upload of rel, stored as blob sha:
+// the hash lookup, recording the uploader and the notification.
+func (s *Server) uploaded(r *http.Request, sha, rel string) {
+	s.lookupHash(r, sha, rel)
+	s.recordOwner(r, rel)
+	s.notifyUploaded(r, rel)
+}
+
+// recordBlobRef notes that rel is backed by blob sha, and drops the blob's
+// refs to paths that are gone. The file's version goes into the hash cache
+// so blobReadable knows its content without reading it. Failures are
+// ignored: the index only gates /api/blob and the file itself is already in
+// place.
+func (s *Server) recordBlobRef(r *http.Request, sha, rel string) {
+	st, err := s.metaStore(r, "blobrefs")
+	if err != nil || sha == "" || rel == "" {
+		return
+	}
+	cfg := s.cfgForReq(r)
+	if abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks); err == nil {
+		if fi, err := os.Stat(abs); err == nil {
+			s.cacheSHA256(hashKey(abs, fi), sha)
+		}
+	}
+	_ = st.Update(sha, func(cur json.RawMessage) (json.RawMessage, error) {
+		var refs []string
+		if cur != nil {
+			_ = json.Unmarshal(cur, &refs)
+		}
+		keep := []string{rel}
+		for _, p := range refs {
+			if p == rel {
+				continue
+			}
+			abs, err := fsutil.ResolveWithinRoot(cfg.Root, p, cfg.FollowSymlinks)
+			if err != nil {
+				continue
+			}
+			if _, err := os.Stat(abs); err == nil {
+				keep = append(keep, p)
+			}
+		}
+		return json.Marshal(keep)
+	})
+}
+
+// commitBlob moves the finished temp file tmp into the dedup store and links
+// the blob to abs (the resolved path of rel), replacing any existing file.
+// known is tmp's SHA-256 if the caller hashed it while writing, else "".
+func (s *Server) commitBlob(r *http.Request, tmp, known, rel, abs string) (sha string, size int64, err error) {
+	store, _, err := s.shareDeps(r)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", 0, err
+	}
+	var blob string
+	if known != "" {
+		var st os.FileInfo
+		if st, err = os.Stat(tmp); err == nil {
+			sha, blob, size, err = store.PutHashed(r.Context(), tmp, known, st.Size())
+		}
+	} else {
+		sha, blob, size, err = store.Put(r.Context(), tmp)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", 0, err
+	}
+	if err := store.LinkOrCopy(blob, abs); err != nil {
+		return "", 0, err
+	}
+	s.recordBlobRef(r, sha, rel)
+	s.uploaded(r, sha, rel)
+	return sha, size, nil
+}
+
+// blobRefs returns the recorded paths that still hold the blob's content
+// (per match). Stale entries (deleted, renamed or overwritten files) are
+// skipped here and pruned by the next recordBlobRef for the blob.
+func (s *Server) blobRefs(r *http.Request, sha string, match func(abs string, fi os.FileInfo) bool) []string {
+	st, err := s.metaStore(r, "blobrefs")
+	if err != nil {
+		return nil
+	}
+	var refs []string
+	if ok, err := st.Get(sha, &refs); err != nil || !ok {
+		return nil
+	}
+	cfg := s.cfgForReq(r)
+	var live []string
+	for _, rel := range refs {
+		abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(abs); err == nil && match(abs, fi) {
+			live = append(live, rel)
+		}
+	}
+	return live
+}
+
+// blobReadable reports whether the caller may read one of the share paths
+// that still hold the blob. st is the blob's FileInfo for plain blobs, which
+// are hardlinked into the share. Compressed and encrypted blobs are not (st
+// nil), so a path holds one only if its current content hashes to sha;
+// recordBlobRef put the hash of the version it linked in the hash cache.
+func (s *Server) blobReadable(r *http.Request, sha string, st os.FileInfo, size int64) bool {
+	match := func(abs string, fi os.FileInfo) bool {
+		if !fi.Mode().IsRegular() || fi.Size() != size {
+			return false
+		}
+		sum, err := s.fileSHA256(abs, fi)
+		return err == nil && sum == sha
+	}
+	if st != nil {
+		match = func(_ string, fi os.FileInfo) bool { return os.SameFile(fi, st) }
+	}
+	for _, rel := range s.blobRefs(r, sha, match) {
+		if ok, err := s.allowed(r, auth.PermRead, "/"+rel); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handleBlob serves a blob by content hash.
+//
+//	GET /api/blob/<sha256>
+//
+// The response is immutable, so it carries the hash as a strong ETag and a
+// long cache lifetime.
+func (s *Server) handleBlob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sha := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/api/blob/"))
+	if !dedup.IsHash(sha) {
+		http.Error(w, "bad hash", http.StatusBadRequest)
+		return
+	}
+	store, _, err := s.shareDeps(r)
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+	rc, size, err := store.Open(sha)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer rc.Close()
+	f, plain := rc.(*os.File)
+	var st os.FileInfo
+	if plain {
+		if st, err = f.Stat(); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if !s.blobReadable(r, sha, st, size) {
+		// Unreferenced and unreadable blobs look the same to the caller.
+		if s.shouldChallenge(r) {
+			s.authChallenge(w)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+sha+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	if plain {
+		http.ServeContent(w, r, "", st.ModTime(), f)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.Copy(w, rc)
+}
+
+// handleUploadPrecheck links an already stored blob into place so the client
+// can skip the transfer ("instant upload").
+//
+//	POST /api/uploads/precheck?mode=<overwrite|rename|skip|error>
+//	     {"path":"dir/file.bin","size":123,"sha256":"..."}
+//	-> {ok, exists:true, path, sha256, size} when linked
+//	-> {ok, exists:false} when the client has to upload
+//
+// Only blobs the caller could already read through some share path are
+// linked; otherwise the hash would be a capability for anyone who knows it.
+// Unknown and unreadable blobs both answer exists:false.
+func (s *Server) handleUploadPrecheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Path   string `json:"path"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	dest := fsutil.CleanRelPath(req.Path)
+	sha := strings.ToLower(req.SHA256)
+	if dest == "" {
+		http.Error(w, "missing path", http.StatusBadRequest)
+		return
+	}
+	if !dedup.IsHash(sha) {
+		http.Error(w, "bad hash", http.StatusBadRequest)
+		return
+	}
+	mode := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mode")))
+	if mode == "" {
+		mode = "overwrite"
+	}
+	if mode != "error" && mode != "skip" && mode != "overwrite" && mode != "rename" {
+		http.Error(w, "bad mode", http.StatusBadRequest)
+		return
+	}
+	if ok, err := s.allowed(r, auth.PermWrite, "/"+dest); err != nil || !ok {
+		if s.shouldChallenge(r) {
+			s.authChallenge(w)
+		} else {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+		return
+	}
+
+	store, _, err := s.shareDeps(r)
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+	blob, ok := store.Lookup(sha)
+	if !ok {
+		writeJSON(w, map[string]any{"ok": true, "exists": false})
+		return
+	}
+	rc, size, err := store.Open(sha)
+	if err != nil {
+		writeJSON(w, map[string]any{"ok": true, "exists": false})
+		return
+	}
+	var st os.FileInfo
+	if f, plain := rc.(*os.File); plain {
+		st, _ = f.Stat()
+	}
+	_ = rc.Close()
+	if size != req.Size || !s.blobReadable(r, sha, st, size) {
+		writeJSON(w, map[string]any{"ok": true, "exists": false})
+		return
+	}
+
+	finalDest, ok := s.uploadDest(w, r, dest, mode)
+	if !ok {
+		return
+	}
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, finalDest, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	if err := store.LinkOrCopy(blob, abs); err != nil {
+		http.Error(w, "write failed", http.StatusInternalServerError)
+		return
+	}
+	s.recordBlobRef(r, sha, finalDest)
+	s.uploaded(r, sha, finalDest)
+	writeJSON(w, map[string]any{"ok": true, "exists": true, "path": finalDest, "sha256": sha, "size": size})
+}
@@ -0,0 +1,51 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lanparty/internal/config"
+)
+
+func getBlob(h http.Handler, sha string) int {
+	return serveAs(h, httptest.NewRequest(http.MethodGet, "/api/blob/"+sha, nil), "bob").Code
+}
+
+// An encrypted blob is copied into the share, so only a file that still
+// has its content unlocks it: overwriting the file with other content of
+// the same size does not.
+func TestBlobRefNeedsSameContent(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "state.key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := testServer(t, func(cfg *config.Config) { cfg.StateKeyFile = keyFile })
+	h := s.Handler()
+	w := serveAs(h, httptest.NewRequest(http.MethodPost, "/api/write", strings.NewReader(`{"path":"notes.txt","content":"gg wp"}`)), "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("write: %d %s", w.Code, w.Body)
+	}
+	sum := sha256.Sum256([]byte("gg wp"))
+	sha := hex.EncodeToString(sum[:])
+	if code := getBlob(h, sha); code != http.StatusOK {
+		t.Fatalf("GET blob: %d", code)
+	}
+	p := filepath.Join(s.config().Root, "notes.txt")
+	if err := os.WriteFile(p, []byte("ff 15"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(p, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if code := getBlob(h, sha); code != http.StatusNotFound {
+		t.Fatalf("GET blob after overwrite: %d", code)
+	}
+}
@@ -0,0 +1,182 @@
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"lanparty/internal/config"
+)
+
+// accentRe limits branding.accent to values that are safe inside a <style>.
+var accentRe = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[a-zA-Z]{3,30})$`)
+
+// brandedStatus lists the codes branding.pages may replace.
+var brandedStatus = map[string]int{"403": http.StatusForbidden, "404": http.StatusNotFound, "503": http.StatusServiceUnavailable}
+
+func checkBranding(b config.Branding) error {
+	if b.Accent != "" && !accentRe.MatchString(b.Accent) {
+		return fmt.Errorf("accent %q: want #rgb, #rrggbb or a color name", b.Accent)
+	}
+	if b.Logo != "" {
+		if _, err := os.Stat(b.Logo); err != nil {
+			return fmt.Errorf("logo: %w", err)
+		}
+	}
+	for code, p := range b.Pages {
+		if _, ok := brandedStatus[code]; !ok {
+			return fmt.Errorf("pages: unsupported status %q (403, 404 or 503)", code)
+		}
+		if _, err := os.Stat(p); err != nil {
+			return fmt.Errorf("pages %s: %w", code, err)
+		}
+	}
+	return nil
+}
+
+const (
+	defaultLogoHTML = `<span class="logo" aria-hidden="true">
+          <svg class="i logo-i"><use href="/assets/icons.svg#folders"></use></svg>
+        </span>`
+	customLogoHTML = `<span class="logo has-img" aria-hidden="true"><img src="/branding/logo" alt="" /></span>`
+)
+
+// brandHTML rewrites the embedded pages for b: the name in titles and the
+// top bar, the logo, and the accent color as a CSS variable override.
+// Pages from -web-dir get the same treatment where the markup matches.
+func brandHTML(page []byte, b config.Branding) []byte {
+	if b.Name != "" {
+		name := html.EscapeString(b.Name)
+		page = bytes.ReplaceAll(page, []byte("<title>lanparty"), []byte("<title>"+name))
+		page = bytes.ReplaceAll(page, []byte(`title="lanparty"`), []byte(`title="`+name+`"`))
+		page = bytes.ReplaceAll(page, []byte(`<span class="word">lanparty</span>`), []byte(`<span class="word">`+name+`</span>`))
+	}
+	if b.Logo != "" {
+		page = bytes.ReplaceAll(page, []byte(defaultLogoHTML), []byte(customLogoHTML))
+	}
+	if b.Accent != "" && accentRe.MatchString(b.Accent) {
+		style := fmt.Sprintf("<style>:root{--accent:%s;--accent2:%s}</style>\n  </head>", b.Accent, b.Accent)
+		page = bytes.Replace(page, []byte("</head>"), []byte(style), 1)
+	}
+	return page
+}
+
+// handleBrandingLogo serves branding.logo. It is public: the unauthorized
+// page shows it too.
+func (s *Server) handleBrandingLogo(w http.ResponseWriter, r *http.Request) {
+	p := s.config().Branding.Logo
+	if p == "" {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil || st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeForName(p))
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", st.ModTime(), f)
+}
+
+// brandErrors swaps plain-text 403/404/503 responses for the configured
+// branding pages when a browser navigates to them. API, WebDAV and
+// non-GET requests keep the short text bodies their clients expect.
+func (s *Server) brandErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages := s.config().Branding.Pages
+		if len(pages) == 0 || !wantsErrorPage(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&errorPageRW{ResponseWriter: w, pages: pages}, r)
+	})
+}
+
+func wantsErrorPage(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	p := r.URL.Path
+	if strings.HasPrefix(p, "/s/") {
+		if i := strings.Index(p[len("/s/"):], "/"); i >= 0 {
+			p = p[len("/s/")+i:]
+		}
+	}
+	return !strings.HasPrefix(p, "/api/") && !strings.HasPrefix(p, "/dav/")
+}
+
+// errorPageRW replaces the body of a branded status. Once it has, later
+// writes from the handler are dropped.
+type errorPageRW struct {
+	http.ResponseWriter
+	pages    map[string]string
+	wrote    bool
+	replaced bool
+}
+
+func (e *errorPageRW) WriteHeader(code int) {
+	if e.wrote {
+		return
+	}
+	e.wrote = true
+	if p, ok := e.pages[fmt.Sprint(code)]; ok {
+		if b, err := os.ReadFile(p); err == nil {
+			h := e.ResponseWriter.Header()
+			h.Set("Content-Type", "text/html; charset=utf-8")
+			h.Del("Content-Length")
+			h.Del("Content-Encoding")
+			e.ResponseWriter.WriteHeader(code)
+			_, _ = e.ResponseWriter.Write(b)
+			e.replaced = true
+			return
+		}
+	}
+	e.ResponseWriter.WriteHeader(code)
+}
+
+func (e *errorPageRW) Write(p []byte) (int, error) {
+	if !e.wrote {
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.replaced {
+		return len(p), nil
+	}
+	return e.ResponseWriter.Write(p)
+}
+
+// ReadFrom keeps sendfile for downloads that pass through untouched.
+func (e *errorPageRW) ReadFrom(src io.Reader) (int64, error) {
+	if !e.wrote {
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.replaced {
+		return io.Copy(io.Discard, src)
+	}
+	if rf, ok := e.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(e.ResponseWriter, src)
+}
+
+func (e *errorPageRW) Flush() {
+	if f, ok := e.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (e *errorPageRW) Unwrap() http.ResponseWriter { return e.ResponseWriter }
@@ -0,0 +1,137 @@
+package httpserver
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/fsutil"
+)
+
+// Tombstones: deleted and renamed-away paths, kept in the share's
+// "tombstones" meta store for config.TombstoneDays so sync clients can tell
+// "gone on the server" from "not downloaded yet". One record per path, the last
+// removal wins; a rename onto a path clears its tombstone.
+
+const (
+	defaultTombstoneDays = 30
+	tombstonePruneEvery  = time.Hour
+)
+
+type tombstone struct {
+	Op   string `json:"op"`           // "delete" or "rename"
+	To   string `json:"to,omitempty"` // where a rename went
+	Time int64  `json:"time"`         // unix milliseconds
+	User string `json:"user,omitempty"`
+}
+
+// tombstoneRetention returns how long tombstones are kept; 0 disables them.
+func (s *Server) tombstoneRetention() time.Duration {
+	switch d := s.config().TombstoneDays; {
+	case d < 0:
+		return 0
+	case d == 0:
+		return defaultTombstoneDays * 24 * time.Hour
+	default:
+		return time.Duration(d) * 24 * time.Hour
+	}
+}
+
+// recordRemoval remembers that rel went away, deleted (to == "") or renamed
+// to to, and takes per-path metadata along. Failures only cost sync clients a full rescan, so they are ignored.
+func (s *Server) recordRemoval(r *http.Request, rel, to string) {
+	s.moveVerdicts(r, rel, to)
+	s.moveExpiry(r, rel, to)
+	s.moveOwners(r, rel, to)
+	keep := s.tombstoneRetention()
+	if keep == 0 || rel == "" {
+		return
+	}
+	st, err := s.metaStore(r, "tombstones")
+	if err != nil {
+		return
+	}
+	t := tombstone{Op: "delete", Time: time.Now().UnixMilli(), User: auth.UserFromContext(r.Context())}
+	if to != "" {
+		t.Op, t.To = "rename", to
+		_ = st.Delete(to)
+	}
+	_ = st.Put(rel, t)
+
+	share := shareFromContext(r.Context())
+	now := time.Now()
+	if last, ok := s.tombPruned.Load(share); ok && now.Sub(last.(time.Time)) < tombstonePruneEvery {
+		return
+	}
+	s.tombPruned.Store(share, now)
+	cutoff := now.Add(-keep).UnixMilli()
+	for _, k := range st.Keys("") {
+		var old tombstone
+		if ok, err := st.Get(k, &old); err == nil && ok && old.Time < cutoff {
+			_ = st.Delete(k)
+		}
+	}
+}
+
+// handleChanges lists paths removed from a folder since a point in time.
+//
+//	GET /api/changes?path=<dir>&since=<unix ms>
+//	  -> {now, reset, changes:[{path, op, to, time, user}]}
+//
+// Pass the previous response's now as since. reset means since lies beyond
+// the retention window (or is 0): removals may be missing, so rescan the
+// folder. Only removals are reported; listings show what exists. A path
+// that was deleted and then created again still has its tombstone, so
+// compare time with the file's mtime.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	rel := fsutil.CleanRelPath(q.Get("path"))
+	var since int64
+	if v := q.Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "bad since", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	now := time.Now().UnixMilli()
+	keep := s.tombstoneRetention()
+	type change struct {
+		Path string `json:"path"`
+		tombstone
+	}
+	out := []change{}
+	if keep > 0 {
+		st, err := s.metaStore(r, "tombstones")
+		if err != nil {
+			http.Error(w, "server init failed", http.StatusInternalServerError)
+			return
+		}
+		prefix := ""
+		if rel != "" {
+			prefix = rel + "/"
+		}
+		for _, k := range st.Keys(prefix) {
+			var t tombstone
+			// Same millisecond as the last cursor counts again: a repeated
+			// removal is harmless, a missed one is not.
+			if ok, err := st.Get(k, &t); err != nil || !ok || t.Time < since {
+				continue
+			}
+			if ok, err := s.allowed(r, auth.PermRead, "/"+k); err != nil || !ok {
+				continue
+			}
+			out = append(out, change{Path: k, tombstone: t})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time < out[j].Time })
+	reset := since == 0 || keep == 0 || since < now-keep.Milliseconds()
+	writeJSON(w, map[string]any{"now": now, "reset": reset, "changes": out})
+}
@@ -0,0 +1,278 @@
+package httpserver
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"lanparty/internal/fsutil"
+)
+
+// Checksum sidecars: "game.zip.sha256" or "game.zip.md5" next to
+// "game.zip", in sha256sum/md5sum format ("<hex>  game.zip") or just the
+// bare digest. Listings flag files that have one; /api/verify checks it.
+
+// sidecarExts are tried in order; the first one present wins.
+var sidecarExts = []struct{ ext, algo string }{
+	{".sha256", "sha256"},
+	{".md5", "md5"},
+}
+
+type sidecar struct {
+	abs  string
+	algo string
+	st   os.FileInfo
+}
+
+func findSidecar(abs string) (sidecar, bool) {
+	for _, sc := range sidecarExts {
+		p := abs + sc.ext
+		if st, err := os.Stat(p); err == nil && st.Mode().IsRegular() {
+			return sidecar{abs: p, algo: sc.algo, st: st}, true
+		}
+	}
+	return sidecar{}, false
+}
+
+// isSidecarName reports whether name is a sidecar itself; those are not
+// checked for sidecars of their own.
+func isSidecarName(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, sc := range sidecarExts {
+		if ext == sc.ext {
+			return true
+		}
+	}
+	return false
+}
+
+// readSidecar returns the expected digest for the file called name. In a
+// multi-line sidecar the line must name the file; a single line applies to
+// whatever the sidecar sits next to.
+func readSidecar(sc sidecar, name string) (string, error) {
+	f, err := os.Open(sc.abs)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	want := hex.EncodedLen(sha256.Size)
+	if sc.algo == "md5" {
+		want = hex.EncodedLen(md5.Size)
+	}
+	var single string
+	lines := 0
+	scan := bufio.NewScanner(io.LimitReader(f, 1<<20))
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines++
+		sum, file, _ := strings.Cut(line, " ")
+		if _, err := hex.DecodeString(sum); err != nil || len(sum) != want {
+			continue
+		}
+		// "*name" marks binary mode; Windows tools may write backslashes.
+		file = strings.TrimPrefix(strings.TrimSpace(file), "*")
+		if file != "" && path.Base(strings.ReplaceAll(file, `\`, "/")) == name {
+			return strings.ToLower(sum), nil
+		}
+		single = strings.ToLower(sum)
+	}
+	if err := scan.Err(); err != nil {
+		return "", err
+	}
+	if lines == 1 && single != "" {
+		return single, nil
+	}
+	return "", fmt.Errorf("no %s digest for %s", sc.algo, name)
+}
+
+// verifyKey identifies one version of a file and its sidecar.
+func verifyKey(abs string, st os.FileInfo, sc sidecar) string {
+	return abs + "\x00" + strconv.FormatInt(st.Size(), 10) + "\x00" + strconv.FormatInt(st.ModTime().UnixNano(), 10) +
+		"\x00" + strconv.FormatInt(sc.st.ModTime().UnixNano(), 10)
+}
+
+// verifiedStatus returns "ok" or "mismatch" if this version was checked.
+func (s *Server) verifiedStatus(abs string, st os.FileInfo, sc sidecar) string {
+	s.hashMu.Lock()
+	defer s.hashMu.Unlock()
+	return s.verified[verifyKey(abs, st, sc)]
+}
+
+// checksumInfo fills the sidecar fields of a listing entry.
+func (s *Server) checksumInfo(it *listItem, abs string, info os.FileInfo) {
+	if info == nil || !info.Mode().IsRegular() || isSidecarName(it.Name) {
+		return
+	}
+	sc, ok := findSidecar(abs)
+	if !ok {
+		return
+	}
+	it.Checksum = sc.algo
+	it.Verified = s.verifiedStatus(abs, info, sc)
+}
+
+// handleVerify checks a file against its checksum sidecar.
+//
+//	GET /api/verify?path=<file> -> {path, sidecar, algo, expected, actual, ok}
+//
+// 404 when there is no sidecar, 422 when it has no usable digest for the
+// file. The result is remembered per file version and shows up in listings
+// as "verified".
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !st.Mode().IsRegular() {
+		http.Error(w, "not a file", http.StatusBadRequest)
+		return
+	}
+	sc, ok := findSidecar(abs)
+	if !ok {
+		http.Error(w, "no checksum sidecar", http.StatusNotFound)
+		return
+	}
+	expected, err := readSidecar(sc, filepath.Base(abs))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var actual string
+	if sc.algo == "sha256" {
+		actual, err = s.fileSHA256(abs, st)
+	} else {
+		actual, err = fileMD5(r, abs)
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return
+	}
+	status := "mismatch"
+	if actual == expected {
+		status = "ok"
+	}
+	s.hashMu.Lock()
+	if len(s.verified) >= hashCacheMax {
+		s.verified = map[string]string{}
+	}
+	s.verified[verifyKey(abs, st, sc)] = status
+	s.hashMu.Unlock()
+
+	dirRel := path.Dir(rel)
+	if dirRel == "." {
+		dirRel = ""
+	}
+	writeJSON(w, map[string]any{
+		"path":     rel,
+		"sidecar":  joinRel(dirRel, filepath.Base(sc.abs)),
+		"algo":     sc.algo,
+		"expected": expected,
+		"actual":   actual,
+		"ok":       status == "ok",
+	})
+}
+
+func fileMD5(r *http.Request, abs string) (string, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, ctxReader{r.Context(), f}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumListName is what /api/checksumlist downloads as; a file of that
+// name at the top of the folder is left out of the list.
+const checksumListName = "SHA256SUMS"
+
+// handleChecksumList returns a folder's files in sha256sum format.
+//
+//	GET /api/checksumlist?path=<dir>[&dl=1]  -> "<sha256>  <path>\n"...
+//
+// Save it into the folder and check with "sha256sum -c SHA256SUMS". Paths are
+// relative to the folder; the same folders as /api/manifest are left out,
+// and hashes share its per-version cache.
+func (s *Server) handleChecksumList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !st.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+	files, err := s.hashTree(r, abs, rel, cfg.StateDir)
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "checksum list failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var b strings.Builder
+	for _, f := range files {
+		if f.Path == checksumListName {
+			continue
+		}
+		// GNU coreutils escaping: a leading backslash marks a name with
+		// "\\" or "\n" in it.
+		name := f.Path
+		if strings.ContainsAny(name, "\\\n") {
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(name)
+			b.WriteByte('\\')
+		}
+		b.WriteString(f.SHA256)
+		b.WriteString("  ")
+		b.WriteString(name)
+		b.WriteByte('\n')
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.URL.Query().Get("dl") == "1" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", checksumListName))
+	}
+	_, _ = io.WriteString(w, b.String())
+}
@@ -0,0 +1,183 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/fsutil"
+)
+
+// Chunked downloads: /api/chunks hands out a file's chunk list (the one the
+// swarm tracker uses) and a session id; the client then fetches
+//
+//	GET /f/<path>?chunk=<i>&session=<id>
+//
+// over as many connections as it likes and checks each chunk against its
+// hash. The server answers with the chunk's byte range and counts what it
+// served per session, so progress can be followed from the server side.
+
+const chunkSessionIdle = time.Hour
+
+type chunkSession struct {
+	share, path, user string
+	size              int64
+	done              []bool
+	served            int64 // bytes, repeats included
+	started, updated  time.Time
+}
+
+type chunkSessions struct {
+	mu   sync.Mutex
+	byID map[string]*chunkSession
+}
+
+func newChunkSessions() *chunkSessions {
+	return &chunkSessions{byID: map[string]*chunkSession{}}
+}
+
+func (cs *chunkSessions) add(c *chunkSession) (string, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for k, old := range cs.byID {
+		if c.started.Sub(old.updated) > chunkSessionIdle {
+			delete(cs.byID, k)
+		}
+	}
+	cs.byID[id] = c
+	return id, nil
+}
+
+// served records chunk i of id as delivered.
+func (cs *chunkSessions) served(id, share, rel string, i, n int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	c := cs.byID[id]
+	if c == nil || c.share != share || c.path != rel || i >= len(c.done) {
+		return
+	}
+	c.done[i] = true
+	c.served += int64(n)
+	c.updated = time.Now()
+}
+
+// handleChunks starts a chunked download or reports on one.
+//
+//	GET /api/chunks?path=<file> -> {id, size, sha256, chunks:[{off, len, sha256}]}
+//	GET /api/chunks?id=<id>     -> {id, path, size, chunks, done, served, started, updated}
+//
+// Chunks are 4 MiB, or the file's dedup chunks when it has them. Sessions
+// are forgotten after an hour without a chunk.
+func (s *Server) handleChunks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	share := shareFromContext(r.Context())
+	if id := q.Get("id"); id != "" {
+		s.chunks.mu.Lock()
+		c := s.chunks.byID[id]
+		var resp map[string]any
+		if c != nil && c.share == share && c.user == auth.UserFromContext(r.Context()) {
+			done := 0
+			for _, d := range c.done {
+				if d {
+					done++
+				}
+			}
+			resp = map[string]any{
+				"id": id, "path": c.path, "size": c.size, "chunks": len(c.done), "done": done,
+				"served": c.served, "started": c.started.Unix(), "updated": c.updated.Unix(),
+			}
+		}
+		s.chunks.mu.Unlock()
+		if resp == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, resp)
+		return
+	}
+
+	rel := fsutil.CleanRelPath(q.Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil || rel == "" {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !st.Mode().IsRegular() {
+		http.Error(w, "not a file", http.StatusBadRequest)
+		return
+	}
+	sf, err := s.filePlan(r, abs, st)
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "hash failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	now := time.Now()
+	id, err := s.chunks.add(&chunkSession{
+		share: share, path: rel, user: auth.UserFromContext(r.Context()), size: sf.plan.Size,
+		done: make([]bool, len(sf.plan.Chunks)), started: now, updated: now,
+	})
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"id": id, "size": sf.plan.Size, "sha256": sf.plan.SHA256, "chunks": sf.plan.Chunks})
+}
+
+// chunkRange turns a /f/ request with chunk=<i> into a Range request for
+// that chunk and sets X-Chunk-SHA256. finish records the chunk with its
+// session once it has been sent.
+func (s *Server) chunkRange(w http.ResponseWriter, r *http.Request, rel, abs string, st os.FileInfo) (finish func(), ok bool) {
+	if s.shareKey(r) != nil {
+		http.Error(w, "not supported on encrypted shares", http.StatusNotImplemented)
+		return nil, false
+	}
+	q := r.URL.Query()
+	i, err := strconv.Atoi(q.Get("chunk"))
+	if err != nil || i < 0 {
+		http.Error(w, "bad chunk", http.StatusBadRequest)
+		return nil, false
+	}
+	sf, err := s.filePlan(r, abs, st)
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "hash failed", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	if i >= len(sf.plan.Chunks) {
+		http.Error(w, "bad chunk", http.StatusRequestedRangeNotSatisfiable)
+		return nil, false
+	}
+	c := sf.plan.Chunks[i]
+	r.Header.Set("Range", "bytes="+strconv.FormatInt(c.Off, 10)+"-"+strconv.FormatInt(c.Off+int64(c.Len)-1, 10))
+	r.Header.Del("If-Range")
+	w.Header().Set("X-Chunk-SHA256", c.Hash)
+	share, id := shareFromContext(r.Context()), q.Get("session")
+	return func() {
+		if id != "" && r.Context().Err() == nil {
+			s.chunks.served(id, share, rel, i, c.Len)
+		}
+	}, true
+}
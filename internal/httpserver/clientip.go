@@ -0,0 +1,104 @@
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Client addresses behind a reverse proxy. A request whose connection comes
+// from one of config.TrustedProxies, or over the Unix socket listener, is
+// attributed to the address the proxy reports: the last X-Forwarded-For
+// entry that is not itself a trusted proxy, or X-Real-IP. Anyone else's
+// forwarding headers are ignored, since they are trivially forged. Unix
+// socket peers carry no address, and only processes the socket's mode lets
+// in can connect, which is the proxy it is set up for.
+
+// parseTrustedProxies reads addresses ("10.0.0.2") and networks
+// ("10.0.0.0/8", "fd00::/8").
+func parseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(list))
+	for _, v := range list {
+		v = strings.TrimSpace(v)
+		if p, err := netip.ParsePrefix(v); err == nil {
+			out = append(out, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or network", v)
+		}
+		out = append(out, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+	}
+	return out, nil
+}
+
+func checkTrustedProxies(list []string) error {
+	_, err := parseTrustedProxies(list)
+	return err
+}
+
+func trusted(nets []netip.Prefix, ip string) bool {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	// A zoned address never matches a prefix; the zone only names the
+	// interface a link-local client came in on.
+	a = a.Unmap().WithZone("")
+	for _, p := range nets {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// unixPeer reports whether r came in over a Unix socket.
+func unixPeer(r *http.Request) bool {
+	a, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && a.Network() == "unix"
+}
+
+// remoteHost is the client address of r without the port.
+func (s *Server) remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	var nets []netip.Prefix
+	if p := s.proxyNets.Load(); p != nil {
+		nets = *p
+	}
+	if !unixPeer(r) && !trusted(nets, host) {
+		return host
+	}
+	// Each proxy appends the address it got the request from, so the
+	// client is the rightmost entry no trusted proxy added.
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, v := range strings.Split(h, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				hops = append(hops, v)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break // garbage from the client; stop at the last good hop
+		}
+		host = a.Unmap().String()
+		if !trusted(nets, host) {
+			return host
+		}
+	}
+	if len(hops) == 0 {
+		if a, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return a.Unmap().String()
+		}
+	}
+	return host
+}
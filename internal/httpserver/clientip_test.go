@@ -0,0 +1,40 @@
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lanparty/internal/config"
+)
+
+// Forwarding headers count from trusted proxies and over the Unix socket
+// listener, whose peers have no address, and from nobody else.
+func TestRemoteHost(t *testing.T) {
+	s := testServer(t)
+	req := func(remote string, local net.Addr) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		return r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+	}
+	tcp := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	unix := &net.UnixAddr{Name: "/run/lanparty.sock", Net: "unix"}
+	if got := s.remoteHost(req("10.0.0.2:5000", tcp)); got != "10.0.0.2" {
+		t.Errorf("untrusted peer: %q", got)
+	}
+	for _, remote := range []string{"@", ""} {
+		if got := s.remoteHost(req(remote, unix)); got != "203.0.113.7" {
+			t.Errorf("unix peer %q: %q", remote, got)
+		}
+	}
+	_, _ = s.updateConfig(func(cfg *config.Config) error {
+		cfg.TrustedProxies = []string{"10.0.0.0/8"}
+		return nil
+	})
+	if got := s.remoteHost(req("10.0.0.2:5000", tcp)); got != "203.0.113.7" {
+		t.Errorf("trusted peer: %q", got)
+	}
+}
@@ -0,0 +1,129 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Dry runs: PUT /api/admin/config?dryRun=1 takes the same body as a save,
+// normalizes it the same way and answers with the result and what would
+// change, without persisting or applying anything:
+//
+//	{ ok, dryRun: true, config, changes: [{path, from, to}], warnings }
+//
+// Paths are JSON-style ("acls[1].write", "shares.games.root"); from or to
+// is missing when a setting is added or removed. ACL lists are compared by
+// position, since the first of two rules for the same path wins.
+
+type configChange struct {
+	Path string `json:"path"`
+	From any    `json:"from,omitempty"`
+	To   any    `json:"to,omitempty"`
+}
+
+func (s *Server) adminConfigDryRun(w http.ResponseWriter, req adminConfigPayload) {
+	cur := *s.config()
+	next := cloneConfig(cur)
+	applyAdminConfig(&next, req)
+	normalized, err := normalizeConfigDirs(next, false)
+	if err == nil {
+		err = checkConfig(normalized, s.disabled)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	warnings := []string{}
+	missing := func(what, dir string) {
+		if _, err := os.Stat(dir); err != nil {
+			warnings = append(warnings, what+" "+dir+" does not exist")
+		}
+	}
+	if normalized.Root != "" {
+		missing("root", normalized.Root)
+	}
+	names := make([]string, 0, len(normalized.Shares))
+	for name := range normalized.Shares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if sh := normalized.Shares[name]; sh.RAM == nil {
+			missing("share "+name+": root", sh.Root)
+		}
+	}
+	after := makeAdminConfigPayload(normalized)
+	changes := []configChange{}
+	diffJSON("", jsonValue(makeAdminConfigPayload(cur)), jsonValue(after), &changes)
+	writeJSON(w, map[string]any{
+		"ok":       true,
+		"dryRun":   true,
+		"config":   after,
+		"changes":  changes,
+		"warnings": warnings,
+	})
+}
+
+// jsonValue returns v as encoding/json would decode it into an any, so two
+// values can be compared the way they look on the wire.
+func jsonValue(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out any
+	_ = json.Unmarshal(b, &out)
+	return out
+}
+
+// diffJSON appends the differences between two decoded JSON values.
+// Objects are compared key by key, and lists of objects of the same length
+// element by element; anything else is reported as a whole.
+func diffJSON(path string, a, b any, out *[]configChange) {
+	if ma, ok := a.(map[string]any); ok {
+		if mb, ok := b.(map[string]any); ok {
+			keys := make([]string, 0, len(ma)+len(mb))
+			for k := range ma {
+				keys = append(keys, k)
+			}
+			for k := range mb {
+				if _, ok := ma[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				p := k
+				if path != "" {
+					p = path + "." + k
+				}
+				diffJSON(p, ma[k], mb[k], out)
+			}
+			return
+		}
+	}
+	if la, ok := a.([]any); ok {
+		if lb, ok := b.([]any); ok && len(la) == len(lb) && objects(la) && objects(lb) {
+			for i := range la {
+				diffJSON(path+"["+strconv.Itoa(i)+"]", la[i], lb[i], out)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, configChange{Path: path, From: a, To: b})
+	}
+}
+
+func objects(list []any) bool {
+	for _, v := range list {
+		if _, ok := v.(map[string]any); !ok {
+			return false
+		}
+	}
+	return len(list) > 0
+}
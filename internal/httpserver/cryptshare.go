@@ -0,0 +1,328 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+
+	"lanparty/internal/fsutil"
+	"lanparty/internal/sealed"
+)
+
+// Encrypted shares.
+//
+// A share with "encrypted": true keeps every file's content sealed on disk
+// (internal/sealed stream format); names and directory layout stay plain.
+// The key is derived from a passphrase with scrypt and only ever held in
+// memory: after a restart the share answers 423 Locked until an admin unlocks
+// it through /api/admin/unlock. The first unlock sets the passphrase.
+//
+// Only the basic file manager works on encrypted shares (list, download with
+// Range, upload, mkdir/rename/move/copy/delete); features that need to read
+// content server-side (thumbnails, zip, search, media, WebDAV, ...) answer 501.
+
+const shareKeyFile = "sharekey.json"
+
+// encryptedShareRoutes are the paths served on an unlocked encrypted share.
+var encryptedShareRoutes = map[string]bool{
+	"/":             true,
+	"/login":        true,
+	"/unauthorized": true,
+	"/api/list":     true,
+	"/api/sign":     true,
+	"/api/upload":   true,
+	"/api/mkdir":    true,
+	"/api/rename":   true,
+	"/api/move":     true,
+	"/api/copy":     true,
+	"/api/delete":   true,
+}
+
+type shareKeyCheck struct {
+	Salt  []byte `json:"salt"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Check []byte `json:"check"` // sealed known plaintext, verifies the passphrase
+}
+
+const shareKeyCheckText = "lanparty encrypted share"
+
+// isEncryptedShare reports whether the request targets an encrypted share.
+func (s *Server) isEncryptedShare(name string) bool {
+	if name == "" {
+		return false
+	}
+	sh, ok := s.config().Shares[name]
+	return ok && sh.Encrypted
+}
+
+// shareKey returns the content key of the request's share if it is an
+// unlocked encrypted share, nil otherwise.
+func (s *Server) shareKey(r *http.Request) *sealed.Key {
+	name := shareFromContext(r.Context())
+	if !s.isEncryptedShare(name) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shareKeys[name]
+}
+
+// guardEncryptedShare applies lock state and the route allowlist for
+// encrypted shares. It reports whether the request may proceed.
+func (s *Server) guardEncryptedShare(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !s.isEncryptedShare(name) {
+		return true
+	}
+	p := r.URL.Path
+	if p == "/" || p == "/login" || p == "/unauthorized" {
+		return true
+	}
+	s.mu.Lock()
+	key := s.shareKeys[name]
+	s.mu.Unlock()
+	if key == nil {
+		http.Error(w, "share locked", http.StatusLocked)
+		return false
+	}
+	if encryptedShareRoutes[p] || strings.HasPrefix(p, "/f/") {
+		return true
+	}
+	http.Error(w, "not supported on encrypted shares", http.StatusNotImplemented)
+	return false
+}
+
+// unlockShare derives the share key from passphrase, creating the key check
+// file on first use, and keeps the key in memory.
+func (s *Server) unlockShare(name, passphrase string) error {
+	sh := s.config().Shares[name]
+	stateDir := sh.StateDir
+	if stateDir == "" {
+		stateDir = filepath.Join(sh.Root, ".lanparty")
+	}
+	p := filepath.Join(stateDir, shareKeyFile)
+
+	var kc shareKeyCheck
+	b, err := os.ReadFile(p)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &kc); err != nil {
+			return fmt.Errorf("%s: %w", shareKeyFile, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		kc = shareKeyCheck{Salt: make([]byte, 16), N: 1 << 15, R: 8, P: 1}
+		if _, err := rand.Read(kc.Salt); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	raw, err := scrypt.Key([]byte(passphrase), kc.Salt, kc.N, kc.R, kc.P, 32)
+	if err != nil {
+		return err
+	}
+	key, err := sealed.NewKey(raw)
+	if err != nil {
+		return err
+	}
+	if kc.Check == nil {
+		kc.Check = key.Seal([]byte(shareKeyCheckText), []byte(name))
+		out, _ := json.MarshalIndent(kc, "", "  ")
+		if err := os.MkdirAll(stateDir, 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, out, 0o600); err != nil {
+			return err
+		}
+	} else if pt, err := key.Open(kc.Check, []byte(name)); err != nil || string(pt) != shareKeyCheckText {
+		return errWrongPassphrase
+	}
+
+	s.mu.Lock()
+	s.shareKeys[name] = key
+	s.mu.Unlock()
+	return nil
+}
+
+var errWrongPassphrase = errors.New("wrong passphrase")
+
+// handleAdminUnlock manages encrypted share keys.
+//
+//	GET    /api/admin/unlock                              -> {shares:[{name, locked}]}
+//	POST   /api/admin/unlock {"share":"x","passphrase":"..."} -> unlock (first use sets it)
+//	DELETE /api/admin/unlock {"share":"x"}                -> forget the key (lock)
+func (s *Server) handleAdminUnlock(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		type st struct {
+			Name   string `json:"name"`
+			Locked bool   `json:"locked"`
+		}
+		var out []st
+		for name, sh := range s.config().Shares {
+			if sh.Encrypted {
+				out = append(out, st{Name: name})
+			}
+		}
+		s.mu.Lock()
+		for i := range out {
+			out[i].Locked = s.shareKeys[out[i].Name] == nil
+		}
+		s.mu.Unlock()
+		writeJSON(w, map[string]any{"shares": out})
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Share      string `json:"share"`
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if !s.isEncryptedShare(req.Share) {
+		http.Error(w, "not an encrypted share", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		s.mu.Lock()
+		delete(s.shareKeys, req.Share)
+		s.mu.Unlock()
+		writeJSON(w, map[string]any{"ok": true, "locked": true})
+		return
+	}
+	if req.Passphrase == "" {
+		http.Error(w, "missing passphrase", http.StatusBadRequest)
+		return
+	}
+	if err := s.unlockShare(req.Share, req.Passphrase); err != nil {
+		if errors.Is(err, errWrongPassphrase) {
+			http.Error(w, "wrong passphrase", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "unlock failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"ok": true, "locked": false})
+}
+
+// serveSealedFile serves an encrypted share file, decrypting on the fly.
+func serveSealedFile(w http.ResponseWriter, r *http.Request, key *sealed.Key, f *os.File, st os.FileInfo, name string) {
+	sk, err := key.NewSeeker(f, st.Size(), []byte(name))
+	if err != nil {
+		http.Error(w, "decrypt failed", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, st.Name(), st.ModTime(), sk)
+}
+
+// handleSealedUpload streams a multipart upload into an encrypted share,
+// sealing it on the way in. It bypasses the dedup store: blobs would hold
+// plaintext and content hashes.
+func (s *Server) handleSealedUpload(w http.ResponseWriter, r *http.Request, key *sealed.Key, rel, mode string) {
+	cfg := s.cfgForReq(r)
+	absDir, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	part, err := filePart(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer part.Close()
+	name := filepath.Base(part.FileName())
+	dstRel := joinRel(rel, name)
+	dstAbs, err := fsutil.ResolveWithinRoot(cfg.Root, dstRel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(dstAbs); err == nil {
+		switch mode {
+		case "skip":
+			writeJSON(w, map[string]any{"ok": true, "skipped": true, "path": dstRel})
+			return
+		case "error":
+			http.Error(w, "destination exists", http.StatusConflict)
+			return
+		case "rename":
+			nm, err := uniqueNameInDir(absDir, name)
+			if err != nil {
+				http.Error(w, "write failed", http.StatusInternalServerError)
+				return
+			}
+			dstRel = joinRel(rel, nm)
+			if dstAbs, err = fsutil.ResolveWithinRoot(cfg.Root, dstRel, cfg.FollowSymlinks); err != nil {
+				http.Error(w, "bad path", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dstAbs), 0o755); err != nil {
+		http.Error(w, "mkdir failed", http.StatusInternalServerError)
+		return
+	}
+	tmp := dstAbs + fmt.Sprintf(".tmp-%d", time.Now().UnixNano())
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		http.Error(w, "write failed", http.StatusInternalServerError)
+		return
+	}
+	var src io.Reader = part
+	max, capped := uploadCap(r)
+	if capped {
+		src = io.LimitReader(part, max+1)
+	}
+	size, err := writeSealed(out, key, src, shareFromContext(r.Context()))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && capped && size > max {
+		_ = os.Remove(tmp)
+		http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err == nil {
+		err = os.Rename(tmp, dstAbs)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		http.Error(w, "upload failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"ok": true, "size": size, "path": dstRel})
+}
+
+func writeSealed(out *os.File, key *sealed.Key, src io.Reader, share string) (int64, error) {
+	sw, err := key.NewWriter(out, []byte(share))
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(sw, src)
+	if err != nil {
+		return 0, err
+	}
+	if err := sw.Close(); err != nil {
+		return 0, err
+	}
+	return n, out.Sync()
+}
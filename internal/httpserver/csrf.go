@@ -0,0 +1,118 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// CSRF protection: a browser that changes something must prove the request
+// comes from a lanparty page. Pages carry a token (data-csrf on <body>) that
+// matches the browser's lanparty_csrf cookie, and the scripts send it back
+// as X-CSRF-Token on every request that is not a GET or HEAD; another site
+// can neither read the page nor set the header. Tokens are random and
+// signed with the URL signing secret under their own label, so a cookie
+// planted from a sibling host does not pass.
+//
+// Only browser requests are checked, recognized by the Origin or
+// Sec-Fetch-Site header every current browser sends: scripts, the CLI and
+// bearer-token clients need no token. WebDAV, which browsers cannot reach
+// without a CORS preflight, and the sign-in form and upload tickets, which
+// act on nothing a cookie grants, are exempt.
+
+const (
+	csrfCookie = "lanparty_csrf"
+	csrfHeader = "X-CSRF-Token"
+)
+
+func (s *Server) csrfSig(id string) string {
+	m := hmac.New(sha256.New, s.signKey())
+	m.Write([]byte("csrf\x00" + id))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (s *Server) validCSRF(tok string) bool {
+	id, sig, ok := strings.Cut(tok, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(s.csrfSig(id)))
+}
+
+// csrfToken returns the browser's CSRF token, issuing a cookie with a new
+// one if it has none.
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && s.validCSRF(c.Value) {
+		return c.Value
+	}
+	var b [18]byte
+	_, _ = rand.Read(b[:])
+	id := base64.RawURLEncoding.EncodeToString(b[:])
+	tok := id + "." + s.csrfSig(id)
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: tok, Path: s.basePath() + "/", HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	return tok
+}
+
+// csrfExempt reports whether r needs no CSRF token.
+func csrfExempt(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return true
+	}
+	if r.Header.Get("Origin") == "" && r.Header.Get("Sec-Fetch-Site") == "" {
+		return true // not a browser
+	}
+	if requestToken(r) != "" || requestBearerToken(r) != "" {
+		return true
+	}
+	p := r.URL.Path
+	if _, ok := ticketID(p); ok {
+		return true
+	}
+	return p == "/login" || p == "/logout" || p == "/dav" || strings.HasPrefix(p, "/dav/")
+}
+
+// csrfCheck turns away browser requests that change something without the
+// token of their cookie.
+func (s *Server) csrfCheck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !csrfExempt(r) {
+			tok := r.Header.Get(csrfHeader)
+			c, err := r.Cookie(csrfCookie)
+			if tok == "" || err != nil || !hmac.Equal([]byte(tok), []byte(c.Value)) || !s.validCSRF(tok) {
+				http.Error(w, "missing or bad CSRF token; reload the page", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleCSRF hands a token to pages that were not served with one.
+//
+//	GET /api/csrf -> {token}
+func (s *Server) handleCSRF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]any{"token": s.csrfToken(w, r)})
+}
+
+// markCSRFHTML puts the browser's CSRF token on the page's <body>.
+func (s *Server) markCSRFHTML(b []byte, w http.ResponseWriter, r *http.Request) []byte {
+	const bodyTag = "<body"
+	idx := bytes.Index(b, []byte(bodyTag))
+	if idx < 0 {
+		return b
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(b) + 100)
+	buf.Write(b[:idx+len(bodyTag)])
+	buf.WriteString(` data-csrf="` + html.EscapeString(s.csrfToken(w, r)) + `"`)
+	buf.Write(b[idx+len(bodyTag):])
+	return buf.Bytes()
+}
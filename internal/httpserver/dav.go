@@ -0,0 +1,367 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/webdav"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/fsutil"
+	"lanparty/internal/meta"
+	"lanparty/internal/upload"
+)
+
+// WebDAV extensions layered on top of golang.org/x/net/webdav.
+
+// isDavRangeWrite reports whether r is a partial write (PUT or PATCH carrying a
+// Content-Range header, as accepted by Apache mod_dav and sabre/dav).
+func isDavRangeWrite(r *http.Request) bool {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		return false
+	}
+	return r.Header.Get("Content-Range") != ""
+}
+
+// handleDavRangeWrite stages a partial WebDAV write in the share's upload
+// manager, keyed by user and destination path, so a large copy can resume from the last
+// acknowledged offset after a network blip. The chunk that completes the file
+// finalizes it through the dedup store like a regular resumable upload.
+//
+// The current offset is reported in X-Upload-Offset on every response (and on
+// HEAD while a session is pending) so clients know where to continue. The
+// write that starts a session goes through davPutConflict like a whole PUT;
+// after a rename, the client continues at the Location it got.
+func (s *Server) handleDavRangeWrite(w http.ResponseWriter, r *http.Request, mount, clean string) {
+	rel := fsutil.CleanRelPath(clean)
+	if rel == "" {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	start, _, total, err := upload.ParseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	if st, err := os.Stat(abs); err == nil && st.IsDir() {
+		http.Error(w, "is a directory", http.StatusMethodNotAllowed)
+		return
+	}
+	_, up, err := s.shareDeps(r)
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+
+	user := auth.UserFromContext(r.Context())
+	sess, ok := up.FindByDest(rel, user)
+	if ok && start == 0 && sess.Offset != 0 {
+		// Client restarted from scratch; drop the stale session.
+		_ = up.Cancel(sess.ID)
+		ok = false
+	}
+	if !ok {
+		if start != 0 {
+			w.Header().Set("X-Upload-Offset", "0")
+			http.Error(w, "no partial upload in progress", http.StatusConflict)
+			return
+		}
+		if !s.davPutConflict(w, r, mount, clean) {
+			return
+		}
+		if to := s.davPathToClean(r.URL.Path); to != clean {
+			rel = fsutil.CleanRelPath(to)
+			if abs, err = fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks); err != nil {
+				http.Error(w, "bad path", http.StatusBadRequest)
+				return
+			}
+		}
+		sess, err = up.Create(rel, total, user)
+		if err != nil {
+			http.Error(w, "create failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	patched, err := up.Patch(r.Context(), sess.ID, r)
+	if err != nil {
+		if cur, ok := up.Get(sess.ID); ok {
+			w.Header().Set("X-Upload-Offset", strconv.FormatInt(cur.Offset, 10))
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "upload session vanished", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("X-Upload-Offset", strconv.FormatInt(patched.Offset, 10))
+	if patched.Size < 0 || patched.Offset < patched.Size {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_, statErr := os.Stat(abs)
+	_, sha, _, err := up.Finish(r.Context(), sess.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.recordBlobRef(r, sha, rel)
+	s.uploaded(r, sha, rel)
+	if statErr == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davConflictHeader picks what a WebDAV PUT does to an existing file, as
+// mode does for /api/upload: overwrite, rename, skip or error.
+const davConflictHeader = "X-Lanparty-Conflict"
+
+// davPutConflict applies the conflict mode of a PUT onto clean, an existing
+// file. X-Lanparty-Conflict sets the mode; without it, "Overwrite: F" means
+// error and anything else overwrite, as plain WebDAV clients expect. It
+// answers the request itself for skip (204) and error (412) and returns
+// false; for rename it points r at a free name next to clean, reports the
+// new href in Location and returns true.
+func (s *Server) davPutConflict(w http.ResponseWriter, r *http.Request, mount, clean string) bool {
+	mode := strings.ToLower(strings.TrimSpace(r.Header.Get(davConflictHeader)))
+	switch {
+	case mode == "" && strings.EqualFold(strings.TrimSpace(r.Header.Get("Overwrite")), "F"):
+		mode = "error"
+	case mode == "":
+		mode = "overwrite"
+	case mode != "overwrite" && mode != "rename" && mode != "skip" && mode != "error":
+		http.Error(w, "bad "+davConflictHeader, http.StatusBadRequest)
+		return false
+	}
+	rel := fsutil.CleanRelPath(clean)
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		return true // the handler reports the bad path
+	}
+	if st, err := os.Stat(abs); err != nil || st.IsDir() {
+		return true
+	}
+	switch mode {
+	case "skip":
+		w.Header().Set(davConflictHeader, "skipped")
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	case "error":
+		http.Error(w, "destination exists", http.StatusPreconditionFailed)
+		return false
+	case "rename":
+		nm, err := uniqueNameInDir(filepath.Dir(abs), filepath.Base(abs))
+		if err != nil {
+			http.Error(w, "write failed", http.StatusInternalServerError)
+			return false
+		}
+		to := path.Join(path.Dir(clean), nm)
+		if ok, err := s.allowed(r, auth.PermWrite, to); err != nil || !ok {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return false
+		}
+		r.URL.Path, r.URL.RawPath = "/dav"+to, ""
+		w.Header().Set(davConflictHeader, "renamed")
+		w.Header().Set("Location", (&url.URL{Path: mount + to}).EscapedPath())
+	}
+	return true
+}
+
+// annotateDavPendingUpload adds X-Upload-Offset to HEAD responses for paths
+// with a partial upload of the caller's in progress.
+func (s *Server) annotateDavPendingUpload(w http.ResponseWriter, r *http.Request, clean string) {
+	_, up, err := s.shareDeps(r)
+	if err != nil {
+		return
+	}
+	if sess, ok := up.FindByDest(fsutil.CleanRelPath(clean), auth.UserFromContext(r.Context())); ok {
+		w.Header().Set("X-Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+	}
+}
+
+// davFile adds persistent dead-property storage (webdav.DeadPropsHolder) to
+// files served over WebDAV. Properties are kept in the share's "davprops"
+// metadata store keyed by relative path, so clients that PROPPATCH (Finder
+// labels, sync tools) keep their data instead of getting 403.
+type davFile struct {
+	*os.File
+	props *meta.Store
+	key   string
+	// keep, when set, picks the entries Readdir returns.
+	keep func(name string) bool
+}
+
+func (f davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	if f.keep == nil {
+		return infos, err
+	}
+	out := infos[:0]
+	for _, fi := range infos {
+		if f.keep(fi.Name()) {
+			out = append(out, fi)
+		}
+	}
+	return out, err
+}
+
+func (f davFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	var list []webdav.Property
+	if _, err := f.props.Get(f.key, &list); err != nil {
+		return nil, err
+	}
+	out := make(map[xml.Name]webdav.Property, len(list))
+	for _, p := range list {
+		out[p.XMLName] = p
+	}
+	return out, nil
+}
+
+func (f davFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	err := f.props.Update(f.key, func(cur json.RawMessage) (json.RawMessage, error) {
+		var list []webdav.Property
+		if cur != nil {
+			if err := json.Unmarshal(cur, &list); err != nil {
+				return nil, err
+			}
+		}
+		byName := make(map[xml.Name]webdav.Property, len(list))
+		for _, p := range list {
+			byName[p.XMLName] = p
+		}
+		for _, patch := range patches {
+			for _, p := range patch.Props {
+				pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+				if patch.Remove {
+					delete(byName, p.XMLName)
+				} else {
+					byName[p.XMLName] = p
+				}
+			}
+		}
+		if len(byName) == 0 {
+			return nil, nil
+		}
+		list = list[:0]
+		for _, p := range byName {
+			list = append(list, p)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].XMLName.Space != list[j].XMLName.Space {
+				return list[i].XMLName.Space < list[j].XMLName.Space
+			}
+			return list[i].XMLName.Local < list[j].XMLName.Local
+		})
+		return json.Marshal(list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []webdav.Propstat{pstat}, nil
+}
+
+// openPut stages a WebDAV PUT body in a temp file; Close hands it to put.
+func (s safeWebDAVFS) openPut(name, abs string) (webdav.File, error) {
+	if st, err := os.Stat(abs); err == nil && st.IsDir() {
+		return nil, os.ErrExist
+	}
+	if _, err := os.Stat(filepath.Dir(abs)); err != nil {
+		// Missing parent: webdav maps ErrNotExist to 409 Conflict.
+		return nil, err
+	}
+	dir := s.spool
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "dav-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp uses 0600; the blob becomes the share file's inode.
+	_ = f.Chmod(0o644)
+	rel := fsutil.CleanRelPath(strings.TrimPrefix(name, "/"))
+	pf := &davPutFile{File: f, h: sha256.New()}
+	pf.commit = func() error {
+		sha := ""
+		if pf.h != nil {
+			sha = hex.EncodeToString(pf.h.Sum(nil))
+		}
+		return s.put(f.Name(), sha, rel, abs)
+	}
+	return pf, nil
+}
+
+// davPutFile is the write side of a staged PUT. Sequential writes are hashed
+// on the way through; a Seek drops the hash and the store re-reads the file.
+type davPutFile struct {
+	*os.File
+	h      hash.Hash
+	commit func() error
+}
+
+func (f *davPutFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if f.h != nil {
+		_, _ = f.h.Write(p[:n])
+	}
+	return n, err
+}
+
+// ReadFrom keeps io.Copy (used by the webdav PUT handler) on Write;
+// *os.File's own ReadFrom would bypass the hash.
+func (f *davPutFile) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{f}, r)
+}
+
+func (f *davPutFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *davPutFile) WriteAt(p []byte, off int64) (int, error) {
+	f.h = nil
+	return f.File.WriteAt(p, off)
+}
+
+func (f *davPutFile) Seek(offset int64, whence int) (int64, error) {
+	f.h = nil
+	return f.File.Seek(offset, whence)
+}
+
+func (f *davPutFile) Truncate(size int64) error {
+	f.h = nil
+	return f.File.Truncate(size)
+}
+
+func (f *davPutFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return f.commit()
+}
@@ -0,0 +1,108 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lanparty/internal/config"
+)
+
+func davRangePut(t *testing.T, h http.Handler, user, rng, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPut, "/dav/demo.bin", strings.NewReader(body))
+	r.Header.Set("Content-Range", "bytes "+rng)
+	return serveAs(h, r, user)
+}
+
+// Two users uploading to the same path over WebDAV each get their own
+// session: neither can continue or restart the other's.
+func TestDavRangeWriteSameDest(t *testing.T) {
+	s := testServer(t)
+	h := s.Handler()
+	expect := func(w *httptest.ResponseRecorder, code int, offset string) {
+		t.Helper()
+		if w.Code != code || w.Header().Get("X-Upload-Offset") != offset {
+			t.Fatalf("got %d offset %q (%s), want %d offset %s", w.Code, w.Header().Get("X-Upload-Offset"), strings.TrimSpace(w.Body.String()), code, offset)
+		}
+	}
+	expect(davRangePut(t, h, "alice", "0-3/8", "aaaa"), http.StatusNoContent, "4")
+	// bob cannot continue alice's upload...
+	expect(davRangePut(t, h, "bob", "4-7/8", "bbbb"), http.StatusConflict, "0")
+	// ...and starting bob's leaves alice's alone.
+	expect(davRangePut(t, h, "bob", "0-3/8", "bbbb"), http.StatusNoContent, "4")
+	expect(davRangePut(t, h, "alice", "4-7/8", "AAAA"), http.StatusCreated, "8")
+	root := s.config().Root
+	if b, _ := os.ReadFile(filepath.Join(root, "demo.bin")); string(b) != "aaaaAAAA" {
+		t.Fatalf("after alice: %q", b)
+	}
+	expect(davRangePut(t, h, "bob", "4-7/8", "BBBB"), http.StatusNoContent, "8")
+	if b, _ := os.ReadFile(filepath.Join(root, "demo.bin")); string(b) != "bbbbBBBB" {
+		t.Fatalf("after bob: %q", b)
+	}
+}
+
+// A partial PUT that starts an upload honors the conflict headers like a
+// whole PUT.
+func TestDavRangeWriteConflict(t *testing.T) {
+	s := testServer(t)
+	h := s.Handler()
+	root := s.config().Root
+	if err := os.WriteFile(filepath.Join(root, "demo.bin"), []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	put := func(target, rng, body string, hdr ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		r.Header.Set("Content-Range", "bytes "+rng)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			r.Header.Set(hdr[i], hdr[i+1])
+		}
+		return serveAs(h, r, "alice")
+	}
+	if w := put("/dav/demo.bin", "0-3/8", "aaaa", "Overwrite", "F"); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Overwrite: F: %d", w.Code)
+	}
+	if w := put("/dav/demo.bin", "0-3/8", "aaaa", davConflictHeader, "skip"); w.Code != http.StatusNoContent || w.Header().Get(davConflictHeader) != "skipped" {
+		t.Fatalf("skip: %d %q", w.Code, w.Header().Get(davConflictHeader))
+	}
+	w := put("/dav/demo.bin", "0-3/8", "aaaa", davConflictHeader, "rename")
+	loc := w.Header().Get("Location")
+	if w.Code != http.StatusNoContent || loc == "" || loc == "/dav/demo.bin" {
+		t.Fatalf("rename: %d Location %q", w.Code, loc)
+	}
+	if w := put(loc, "4-7/8", "AAAA", davConflictHeader, "rename"); w.Code != http.StatusCreated {
+		t.Fatalf("second half at %s: %d %s", loc, w.Code, w.Body)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "demo.bin")); string(b) != "original" {
+		t.Fatalf("existing file changed to %q", b)
+	}
+	name, _ := url.PathUnescape(strings.TrimPrefix(loc, "/dav/"))
+	if b, _ := os.ReadFile(filepath.Join(root, name)); string(b) != "aaaaAAAA" {
+		t.Fatalf("renamed upload %q", b)
+	}
+}
+
+// WebDAV-only logins take the same hash formats as users.
+func TestDavUserMD5Crypt(t *testing.T) {
+	s := testServer(t, func(cfg *config.Config) {
+		cfg.Shares = map[string]config.Share{"lan": {
+			Root:     t.TempDir(),
+			DavUsers: map[string]config.DavUser{"tv": {Bcrypt: "$apr1$abcdefgh$5VEbMkemELfbhC5ck.U.z1"}},
+		}}
+	})
+	h := s.Handler()
+	for pass, want := range map[string]int{"pw": http.StatusMultiStatus, "nope": http.StatusUnauthorized} {
+		r := httptest.NewRequest("PROPFIND", "/s/lan/dav/", nil)
+		r.Header.Set("Depth", "0")
+		r.SetBasicAuth("tv", pass)
+		w := serveAs(h, r, "")
+		if w.Code != want {
+			t.Errorf("PROPFIND as tv:%s: %d, want %d", pass, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"lanparty/internal/config"
+)
+
+// Share-scoped WebDAV logins (config.Share.DavUsers). They authenticate
+// only against their share's /dav/ endpoint, act as the user
+// "<share>:<name>" (which no ACL can name by accident, Basic auth user
+// names cannot contain a colon) and are allowed by their own Write flag
+// instead of the ACLs.
+
+// shareDavUser returns the DAV login u of the request's share if the
+// request is for that share's WebDAV endpoint.
+func (s *Server) shareDavUser(r *http.Request, u string) (config.DavUser, bool) {
+	share := shareFromContext(r.Context())
+	if share == "" || (r.URL.Path != "/dav" && !strings.HasPrefix(r.URL.Path, "/dav/")) {
+		return config.DavUser{}, false
+	}
+	du, ok := s.config().Shares[share].DavUsers[u]
+	return du, ok && du.Bcrypt != ""
+}
+
+func davUserFromContext(ctx context.Context) (config.DavUser, bool) {
+	du, ok := ctx.Value(davUserKey).(config.DavUser)
+	return du, ok
+}
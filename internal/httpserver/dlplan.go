@@ -0,0 +1,119 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"lanparty/internal/fsutil"
+)
+
+type planFile struct {
+	Path   string `json:"path"` // relative to the folder
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	URL    string `json:"url"`
+}
+
+// handleDownloadPlan lists a folder's files as individual download URLs, so
+// a client can fetch it file by file and resume each one with Range instead
+// of restarting a zip.
+//
+//	GET /api/download-plan?path=<dir>[&hashes=0][&sign=1&ttl=<s>][&format=json|urls|aria2]
+//	  -> {root, count, bytes, files:[{path, size, sha256, url}]}
+//
+// URLs are absolute. sign=1 makes them signed /f/ links (ttl as for
+// /api/sign); otherwise the text formats embed a bearer token as
+// ?access_token= like playlists do. hashes=0 skips hashing, which matters
+// for the first plan of a big folder. urls is one URL per line; aria2 is an
+// aria2c -i input file with out= and checksum= options per file. Files are
+// sorted by path and leave out what manifests leave out.
+func (s *Server) handleDownloadPlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "urls" && format != "aria2" {
+		http.Error(w, "bad format", http.StatusBadRequest)
+		return
+	}
+	var exp int64
+	if q.Get("sign") == "1" {
+		ttl, ok := signTTL(q.Get("ttl"))
+		if !ok {
+			http.Error(w, "bad ttl", http.StatusBadRequest)
+			return
+		}
+		exp = time.Now().Add(ttl).Unix()
+	}
+	rel := fsutil.CleanRelPath(q.Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !st.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+
+	files, err := s.walkTree(r, abs, rel, cfg.StateDir, q.Get("hashes") != "0")
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "plan failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	var tokenQ string
+	if tok := requestBearerToken(r); tok != "" && exp == 0 && format != "json" {
+		tokenQ = "?access_token=" + url.QueryEscape(tok)
+	}
+	base := requestBaseURL(r)
+	plan := make([]planFile, len(files))
+	var total int64
+	for i, f := range files {
+		full := joinRel(rel, f.Path)
+		u := base + s.withSharePrefix(r, "/f/"+escapeURLPath(full)) + tokenQ
+		if exp != 0 {
+			u = base + s.signedFileURL(r, full, exp)
+		}
+		plan[i] = planFile{Path: f.Path, Size: f.Size, SHA256: f.SHA256, URL: u}
+		total += f.Size
+	}
+
+	switch format {
+	case "urls":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		var b strings.Builder
+		for _, f := range plan {
+			b.WriteString(f.URL + "\n")
+		}
+		_, _ = w.Write([]byte(b.String()))
+	case "aria2":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		var b strings.Builder
+		for _, f := range plan {
+			fmt.Fprintf(&b, "%s\n  out=%s\n", f.URL, f.Path)
+			if f.SHA256 != "" {
+				fmt.Fprintf(&b, "  checksum=sha-256=%s\n", f.SHA256)
+			}
+		}
+		_, _ = w.Write([]byte(b.String()))
+	default:
+		writeJSON(w, map[string]any{"root": "/" + rel, "count": len(plan), "bytes": total, "files": plan})
+	}
+}
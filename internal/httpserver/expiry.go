@@ -0,0 +1,282 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/fsutil"
+	"lanparty/internal/meta"
+)
+
+// Expiry: a file or folder can be given a time after which it goes away,
+// for "valid tonight only" content. Times live in the share's "expiry" meta
+// store, keyed by path, and follow renames. Once a time has passed the item
+// is left out of listings and /f/ answers 404; the sweep, which runs on
+// requests to the share at most once a minute, then moves it to
+// <stateDir>/trash/<stamp>/<path>, where it stays for config.TrashDays.
+
+const (
+	defaultTrashDays = 7
+	expirySweepEvery = time.Minute
+)
+
+type expiry struct {
+	Time int64  `json:"time"` // unix seconds
+	User string `json:"user,omitempty"`
+}
+
+// expiries answers expiry lookups for one request; a zero value (no store)
+// knows of none.
+type expiries struct {
+	store *meta.Store
+	now   int64
+}
+
+func (s *Server) expiries(r *http.Request) expiries {
+	store, err := s.metaStore(r, "expiry")
+	if err != nil {
+		return expiries{}
+	}
+	return expiries{store: store, now: time.Now().Unix()}
+}
+
+// at returns when rel itself expires, or 0.
+func (x expiries) at(rel string) int64 {
+	if x.store == nil || rel == "" {
+		return 0
+	}
+	var e expiry
+	if ok, err := x.store.Get(rel, &e); err != nil || !ok {
+		return 0
+	}
+	return e.Time
+}
+
+// gone reports whether rel or a folder above it has expired.
+func (x expiries) gone(rel string) bool {
+	for ; rel != "" && rel != "."; rel = path.Dir(rel) {
+		if t := x.at(rel); t != 0 && t <= x.now {
+			return true
+		}
+	}
+	return false
+}
+
+// annotate sets it.Expires and reports whether it should be listed.
+func (x expiries) annotate(it *listItem) bool {
+	t := x.at(it.Path)
+	if t != 0 && t <= x.now {
+		return false
+	}
+	it.Expires = t
+	return true
+}
+
+// handleExpiry reads and sets the expiry of a file or folder.
+//
+//	GET  /api/expiry?path=<rel>                -> {path, expires, user}
+//	POST /api/expiry {path, expires|in}         -> {ok, path, expires}
+//
+// expires is unix seconds, in is seconds from now; 0 for both clears it.
+// Setting one needs the same permission as deleting.
+func (s *Server) handleExpiry(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+		if ok, err := s.allowed(r, auth.PermRead, "/"+rel); err != nil || !ok {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		store, err := s.metaStore(r, "expiry")
+		if err != nil {
+			http.Error(w, "server init failed", http.StatusInternalServerError)
+			return
+		}
+		var e expiry
+		if _, err := store.Get(rel, &e); err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"path": rel, "expires": e.Time, "user": e.User})
+	case http.MethodPost:
+		var req struct {
+			Path    string `json:"path"`
+			Expires int64  `json:"expires"`
+			In      int64  `json:"in"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		rel := fsutil.CleanRelPath(req.Path)
+		if rel == "" {
+			http.Error(w, "cannot expire the share root", http.StatusBadRequest)
+			return
+		}
+		if s.cfgForReq(r).ReadOnly {
+			http.Error(w, "read-only", http.StatusForbidden)
+			return
+		}
+		if ok, err := s.allowed(r, auth.PermAdmin, "/"+rel); err != nil || !ok {
+			if s.shouldChallenge(r) {
+				s.authChallenge(w)
+			} else {
+				http.Error(w, "forbidden", http.StatusForbidden)
+			}
+			return
+		}
+		if req.Expires < 0 || req.In < 0 || (req.Expires != 0 && req.In != 0) {
+			http.Error(w, "give one of expires or in", http.StatusBadRequest)
+			return
+		}
+		at := req.Expires
+		if req.In > 0 {
+			at = time.Now().Unix() + req.In
+		}
+		cfg := s.cfgForReq(r)
+		abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+		if err != nil {
+			http.Error(w, "bad path", http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Lstat(abs); err != nil && at != 0 {
+			http.NotFound(w, r)
+			return
+		}
+		store, err := s.metaStore(r, "expiry")
+		if err != nil {
+			http.Error(w, "server init failed", http.StatusInternalServerError)
+			return
+		}
+		if at == 0 {
+			err = store.Delete(rel)
+		} else {
+			err = store.Put(rel, expiry{Time: at, User: auth.UserFromContext(r.Context())})
+		}
+		if err != nil {
+			http.Error(w, "save failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true, "path": rel, "expires": at})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// moveExpiry keeps expiry times with their items when rel is renamed to to,
+// and drops them when it is deleted (to == "").
+func (s *Server) moveExpiry(r *http.Request, rel, to string) {
+	if rel == "" {
+		return
+	}
+	store, err := s.metaStore(r, "expiry")
+	if err != nil {
+		return
+	}
+	if to == "" {
+		_ = store.DeleteTree(rel)
+	} else {
+		_ = store.MoveTree(rel, to)
+	}
+}
+
+// trashRetention returns how long expired items stay in the trash; 0 means
+// they are deleted right away.
+func (s *Server) trashRetention() time.Duration {
+	switch d := s.config().TrashDays; {
+	case d < 0:
+		return 0
+	case d == 0:
+		return defaultTrashDays * 24 * time.Hour
+	default:
+		return time.Duration(d) * 24 * time.Hour
+	}
+}
+
+func (s *Server) maybeSweepExpired(r *http.Request) {
+	share := shareFromContext(r.Context())
+	if _, _, ok := snapshotOf(share); ok || s.cfgForReq(r).ReadOnly {
+		return // read-only
+	}
+	now := time.Now()
+	if last, ok := s.expirySwept.Load(share); ok && now.Sub(last.(time.Time)) < expirySweepEvery {
+		return
+	}
+	s.expirySwept.Store(share, now)
+	// The sweep outlives the request and acts on nobody's behalf.
+	ctx := auth.WithUser(context.WithoutCancel(r.Context()), "")
+	go s.sweepExpired(r.WithContext(ctx))
+}
+
+// sweepExpired moves expired items of the request's share to the trash and
+// empties trash older than the retention.
+func (s *Server) sweepExpired(r *http.Request) {
+	cfg := s.cfgForReq(r)
+	trash := filepath.Join(cfg.StateDir, "trash")
+	keep := s.trashRetention()
+	store, err := s.metaStore(r, "expiry")
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	stamp := now.UTC().Format(snapshotStamp)
+	for _, rel := range store.Keys("") {
+		var e expiry
+		if ok, err := store.Get(rel, &e); err != nil || !ok || e.Time > now.Unix() {
+			continue
+		}
+		abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+		if err != nil {
+			_ = store.Delete(rel)
+			continue
+		}
+		if _, err := os.Lstat(abs); errors.Is(err, os.ErrNotExist) {
+			_ = store.Delete(rel)
+			continue
+		}
+		if err := trashItem(abs, filepath.Join(trash, stamp, filepath.FromSlash(rel)), keep); err != nil {
+			continue // still hidden; retried on the next sweep
+		}
+		s.recordRemoval(r, rel, "")
+	}
+
+	ents, err := os.ReadDir(trash)
+	if err != nil {
+		return
+	}
+	cutoff := now.Add(-keep)
+	for _, e := range ents {
+		t, err := time.Parse(snapshotStamp, e.Name())
+		if err == nil && t.Before(cutoff) {
+			_ = os.RemoveAll(filepath.Join(trash, e.Name()))
+		}
+	}
+}
+
+// trashItem moves abs to dst, or deletes it when there is no trash to keep
+// or the trash is on another filesystem.
+func trashItem(abs, dst string, keep time.Duration) error {
+	if keep > 0 {
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		err := os.Rename(abs, dst)
+		if err == nil || !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+	return os.RemoveAll(abs)
+}
+
+// expiredPath reports whether rel or a folder above it has expired.
+func (s *Server) expiredPath(r *http.Request, rel string) bool {
+	return s.expiries(r).gone(rel)
+}
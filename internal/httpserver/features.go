@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Subsystems that can be switched off with the "disable" config key or
+// Options.Disable, for deployments that should expose as little as
+// possible. Disabled routes answer 404 as if they did not exist.
+const (
+	featWebDAV  = "webdav"
+	featThumbs  = "thumbs"
+	featZip     = "zip"
+	featSearch  = "search"
+	featUploads = "uploads"
+	featAdmin   = "admin"
+)
+
+var features = []string{featWebDAV, featThumbs, featZip, featSearch, featUploads, featAdmin}
+
+func checkDisable(names []string) error {
+	for _, n := range names {
+		if !slices.Contains(features, n) {
+			return fmt.Errorf("unknown subsystem %q (want one of %s)", n, strings.Join(features, ", "))
+		}
+	}
+	return nil
+}
+
+// enabled reports whether subsystem name is on. The config is read on every
+// call; admin is the exception, its routes are only registered at startup.
+func (s *Server) enabled(name string) bool {
+	if name == featAdmin {
+		return !s.disableAdmin
+	}
+	return !slices.Contains(s.disabled, name) && !slices.Contains(s.config().Disable, name)
+}
+
+// feature serves h only while subsystem name is enabled.
+func (s *Server) feature(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.enabled(name) {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// markDisabledHTML lists disabled subsystems in a data-disabled attribute
+// on <body> so the UI can hide their controls.
+func (s *Server) markDisabledHTML(b []byte) []byte {
+	var off []string
+	for _, f := range features {
+		if f != featAdmin && !s.enabled(f) {
+			off = append(off, f)
+		}
+	}
+	if len(off) == 0 {
+		return b
+	}
+	const bodyTag = "<body"
+	idx := bytes.Index(b, []byte(bodyTag))
+	if idx < 0 {
+		return b
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(b) + 64)
+	buf.Write(b[:idx+len(bodyTag)])
+	buf.WriteString(` data-disabled="` + strings.Join(off, " ") + `"`)
+	buf.Write(b[idx+len(bodyTag):])
+	return buf.Bytes()
+}
@@ -0,0 +1,327 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"rsc.io/qr"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+// Guests: /api/admin/guests makes a temporary account for a walk-in in one
+// step: a user with a random password, a token, and ACL entries for the
+// folders it may read or write, all recorded in config.Guests. The admin
+// UI shows the token's sign-in link as a QR code; scanning it opens
+// lanparty with a session for the guest. An expired guest is turned away
+// at once and removed from the config by a sweep that runs on requests at
+// most once a minute.
+//
+// Grants are ACL entries that name only the guest. They add to what the
+// ACLs already allow the guest and never decide a folder for anybody else,
+// so later edits to the other entries apply as usual.
+
+const (
+	defaultGuestHours = 12
+	maxGuestHours     = 14 * 24
+	maxGuestPaths     = 32
+	guestSweepEvery   = time.Minute
+	guestTokenLabel   = "guest"
+	// guestAlphabet leaves out characters that are easily confused when
+	// read off a screen (0/o, 1/l/i).
+	guestAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// guestExpired reports whether user is a guest whose time is up.
+func guestExpired(cfg config.Config, user string) bool {
+	g, ok := cfg.Guests[user]
+	return ok && time.Now().Unix() >= g.Expires
+}
+
+// maybeSweepGuests removes expired guests from the config, at most once
+// a minute.
+func (s *Server) maybeSweepGuests() {
+	cfg := s.config()
+	if len(cfg.Guests) == 0 {
+		return
+	}
+	now := time.Now()
+	if last := s.guestsSwept.Load(); now.Unix()-last < int64(guestSweepEvery/time.Second) {
+		return
+	}
+	s.guestsSwept.Store(now.Unix())
+	expired := false
+	for u := range cfg.Guests {
+		expired = expired || guestExpired(*cfg, u)
+	}
+	if !expired {
+		return
+	}
+	go func() {
+		_, _ = s.updateConfig(func(cfg *config.Config) error {
+			for u := range cfg.Guests {
+				if guestExpired(*cfg, u) {
+					dropGuest(cfg, u)
+				}
+			}
+			_ = s.persistConfig(*cfg)
+			return nil
+		})
+	}()
+}
+
+// dropGuest removes guest u with its tokens and ACL entries.
+func dropGuest(cfg *config.Config, u string) {
+	g := cfg.Guests[u]
+	cfg.ACLs = removeACLs(cfg.ACLs, g.ACLs)
+	delete(cfg.Guests, u)
+	delete(cfg.Users, u)
+	for t, tu := range cfg.Tokens {
+		if tu == u {
+			delete(cfg.Tokens, t)
+			delete(cfg.TokenLabels, t)
+		}
+	}
+}
+
+// guestACLs returns the entries that let user read below read and write
+// below write.
+func guestACLs(cfg *config.Config, user string, read, write []string) []config.ACL {
+	var out []config.ACL
+	for _, p := range write {
+		out = append(out, config.ACL{Path: auth.QuoteACLPath(p), Read: []string{user}, Write: []string{user}})
+	}
+	for _, p := range read {
+		if slices.Contains(write, p) {
+			continue
+		}
+		if a, _ := auth.ACLFor(*cfg, p); slices.Contains(a.Read, "*") {
+			continue // readable by everybody signed in already
+		}
+		out = append(out, config.ACL{Path: auth.QuoteACLPath(p), Read: []string{user}})
+	}
+	return out
+}
+
+// cleanGuestPaths cleans the folders of a grant, dropping duplicates.
+func cleanGuestPaths(in []string) []string {
+	var out []string
+	for _, p := range in {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if p = path.Clean("/" + p); !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func guestRandom(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = guestAlphabet[int(b[i])%len(guestAlphabet)]
+	}
+	return string(b)
+}
+
+// guestToken returns the sign-in token made for guest u.
+func guestToken(cfg *config.Config, u string) string {
+	for t, tu := range cfg.Tokens {
+		if tu == u && cfg.TokenLabels[t] == guestTokenLabel {
+			return t
+		}
+	}
+	return ""
+}
+
+// guestLink is the URL that signs the guest in: the session hand-off of
+// session.go, which trades the token for a cookie.
+func (s *Server) guestLink(r *http.Request, tok string) string {
+	return requestBaseURL(r) + s.basePath() + "/api/session?" + url.Values{"access_token": {tok}}.Encode()
+}
+
+type guestView struct {
+	User    string   `json:"user"`
+	Label   string   `json:"label,omitempty"`
+	Created int64    `json:"created"`
+	Expires int64    `json:"expires"`
+	Read    []string `json:"read,omitempty"`
+	Write   []string `json:"write,omitempty"`
+	URL     string   `json:"url,omitempty"`
+}
+
+// handleAdminGuests creates, lists and removes guest accounts.
+//
+//	GET    /api/admin/guests                                    -> {guests: [...]}
+//	POST   /api/admin/guests {"label", "hours", "read", "write"} -> {ok, user, password, token, url, qr, expires}
+//	DELETE /api/admin/guests {"user"}                           -> {ok}
+//
+// read and write are folders of the default share; hours defaults to 12,
+// at most two weeks. qr is the URL of a PNG QR code of url.
+func (s *Server) handleAdminGuests(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	persisted := strings.TrimSpace(s.cfgPath) != ""
+	switch r.Method {
+	case http.MethodGet:
+		cfg := s.config()
+		out := make([]guestView, 0, len(cfg.Guests))
+		for u, g := range cfg.Guests {
+			v := guestView{User: u, Label: g.Label, Created: g.Created, Expires: g.Expires}
+			for _, a := range g.ACLs {
+				if slices.Contains(a.Write, u) {
+					v.Write = append(v.Write, a.Path)
+				} else {
+					v.Read = append(v.Read, a.Path)
+				}
+			}
+			if tok := guestToken(cfg, u); tok != "" {
+				v.URL = s.guestLink(r, tok)
+			}
+			out = append(out, v)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Expires < out[j].Expires })
+		writeJSON(w, map[string]any{"guests": out})
+	case http.MethodPost:
+		var req struct {
+			Label string   `json:"label"`
+			Hours int      `json:"hours"`
+			Read  []string `json:"read"`
+			Write []string `json:"write"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		hours := req.Hours
+		if hours == 0 {
+			hours = defaultGuestHours
+		}
+		if hours < 0 || hours > maxGuestHours {
+			http.Error(w, "bad hours", http.StatusBadRequest)
+			return
+		}
+		label := strings.TrimSpace(req.Label)
+		if len(label) > maxTokenLabel {
+			http.Error(w, "label too long", http.StatusBadRequest)
+			return
+		}
+		read, write := cleanGuestPaths(req.Read), cleanGuestPaths(req.Write)
+		if len(read)+len(write) > maxGuestPaths {
+			http.Error(w, "too many paths", http.StatusBadRequest)
+			return
+		}
+		pass := guestRandom(12)
+		h, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "bcrypt failed", http.StatusInternalServerError)
+			return
+		}
+		var b [24]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			http.Error(w, "token failed", http.StatusInternalServerError)
+			return
+		}
+		tok := base64.RawURLEncoding.EncodeToString(b[:])
+		now := time.Now()
+		exp := now.Add(time.Duration(hours) * time.Hour)
+		var user string
+		_, _ = s.updateConfig(func(cfg *config.Config) error {
+			for {
+				user = "guest-" + guestRandom(4)
+				if _, taken := cfg.Users[user]; !taken && !tokenUserExists(cfg.Tokens, user) {
+					break
+				}
+			}
+			acls := guestACLs(cfg, user, read, write)
+			cfg.ACLs = addACLs(cfg.ACLs, acls)
+			if cfg.Users == nil {
+				cfg.Users = map[string]config.User{}
+			}
+			cfg.Users[user] = config.User{Bcrypt: string(h)}
+			if cfg.Tokens == nil {
+				cfg.Tokens = map[string]string{}
+			}
+			cfg.Tokens[tok] = user
+			if cfg.TokenLabels == nil {
+				cfg.TokenLabels = map[string]string{}
+			}
+			cfg.TokenLabels[tok] = guestTokenLabel
+			if cfg.Guests == nil {
+				cfg.Guests = map[string]config.Guest{}
+			}
+			cfg.Guests[user] = config.Guest{Label: label, Created: now.Unix(), Expires: exp.Unix(), ACLs: acls}
+			_ = s.persistConfig(*cfg)
+			return nil
+		})
+		writeJSON(w, map[string]any{
+			"ok": true, "user": user, "password": pass, "token": tok, "expires": exp.Unix(),
+			"url":       s.guestLink(r, tok),
+			"qr":        s.basePath() + "/api/admin/guests/qr?" + url.Values{"user": {user}}.Encode(),
+			"persisted": persisted,
+		})
+	case http.MethodDelete:
+		var req struct {
+			User string `json:"user"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		_, _ = s.updateConfig(func(cfg *config.Config) error {
+			if _, ok := cfg.Guests[req.User]; ok {
+				dropGuest(cfg, req.User)
+				_ = s.persistConfig(*cfg)
+			}
+			return nil
+		})
+		writeJSON(w, map[string]any{"ok": true, "persisted": persisted})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminGuestQR draws a guest's sign-in link as a QR code.
+//
+//	GET /api/admin/guests/qr?user=<guest> -> image/png
+func (s *Server) handleAdminGuestQR(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.config()
+	u := r.URL.Query().Get("user")
+	tok := ""
+	if _, ok := cfg.Guests[u]; ok {
+		tok = guestToken(cfg, u)
+	}
+	if tok == "" {
+		http.NotFound(w, r)
+		return
+	}
+	code, err := qr.Encode(s.guestLink(r, tok), qr.M)
+	if err != nil {
+		http.Error(w, "qr failed", http.StatusInternalServerError)
+		return
+	}
+	code.Scale = 6
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(code.PNG())
+}
@@ -0,0 +1,102 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"lanparty/internal/config"
+)
+
+// testServer returns a server on a fresh root and state dir with users
+// alice (admin) and bob, both with password "pw". opts adjust the config
+// first.
+func testServer(t *testing.T, opts ...func(*config.Config)) *Server {
+	t.Helper()
+	h, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{
+		Root:     t.TempDir(),
+		StateDir: t.TempDir(),
+		Users: map[string]config.User{
+			"alice": {Bcrypt: string(h)},
+			"bob":   {Bcrypt: string(h)},
+		},
+		ACLs: []config.ACL{{Path: "/", Read: []string{"*"}, Write: []string{"alice", "bob"}, Admin: []string{"alice"}}},
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	s, err := New(Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// serveAs sends r to h with Basic credentials user:pw, or as is for an
+// empty user.
+func serveAs(h http.Handler, r *http.Request, user string) *httptest.ResponseRecorder {
+	if user != "" {
+		r.SetBasicAuth(user, "pw")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// Guests are added and swept out of the config while requests read it;
+// run with -race.
+func TestGuestsChangeWhileServing(t *testing.T) {
+	s := testServer(t)
+	h := s.Handler()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				w := serveAs(h, httptest.NewRequest(http.MethodGet, "/api/info", nil), "alice")
+				if w.Code != http.StatusOK {
+					t.Errorf("GET /api/info: %d %s", w.Code, w.Body)
+					return
+				}
+			}
+		}()
+	}
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for i := 0; time.Now().Before(deadline); i++ {
+		u := "guest-" + strconv.Itoa(i)
+		_, _ = s.updateConfig(func(cfg *config.Config) error {
+			if cfg.Guests == nil {
+				cfg.Guests = map[string]config.Guest{}
+			}
+			cfg.Guests[u] = config.Guest{Created: time.Now().Unix(), Expires: time.Now().Unix() - 1}
+			return nil
+		})
+		if i%50 == 0 {
+			s.guestsSwept.Store(0) // let the next request sweep
+		}
+		if i%10 == 0 {
+			_, _ = s.updateConfig(func(cfg *config.Config) error {
+				dropGuest(cfg, u)
+				return nil
+			})
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
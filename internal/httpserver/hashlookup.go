@@ -0,0 +1,165 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+	"lanparty/internal/fsutil"
+	"lanparty/internal/meta"
+)
+
+// Hash lookups (config.HashLookup): after an upload finishes its SHA-256 is
+// posted to an external reputation service and the verdict is kept in the
+// share's "verdicts" metadata store, by path. Listings show it as long as
+// the file is the version that was looked up. Lookups run in the
+// background; a service that is down just leaves files without a verdict.
+
+const (
+	defaultHashLookupTimeout = 10 * time.Second
+	// maxHashLookups bounds concurrent lookups; more wait their turn.
+	maxHashLookups = 4
+	// maxVerdictBody caps what the service may answer.
+	maxVerdictBody = 64 << 10
+)
+
+type verdict struct {
+	SHA256  string `json:"sha256"`
+	Verdict string `json:"verdict"`
+	Detail  string `json:"detail,omitempty"`
+	Link    string `json:"link,omitempty"`
+	Size    int64  `json:"size"`
+	Mtime   int64  `json:"mtime"` // unix nanoseconds of the looked-up version
+	Time    int64  `json:"time"`  // when it was looked up, unix seconds
+}
+
+func checkHashLookup(h *config.HashLookup) error {
+	if h == nil {
+		return nil
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http(s) URL")
+	}
+	return nil
+}
+
+// lookupHash starts the hash lookup for a finished upload of rel.
+func (s *Server) lookupHash(r *http.Request, sha, rel string) {
+	hl := s.config().HashLookup
+	if hl == nil || sha == "" || rel == "" {
+		return
+	}
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		return
+	}
+	store, err := s.metaStore(r, "verdicts")
+	if err != nil {
+		return
+	}
+	q := map[string]any{
+		"sha256": sha, "size": st.Size(), "path": "/" + rel,
+		"share": shareFromContext(r.Context()), "user": auth.UserFromContext(r.Context()),
+	}
+	go func() {
+		s.lookups <- struct{}{}
+		defer func() { <-s.lookups }()
+		v, err := askHashLookup(hl, q)
+		if err != nil {
+			return
+		}
+		v.SHA256, v.Size, v.Mtime, v.Time = sha, st.Size(), st.ModTime().UnixNano(), time.Now().Unix()
+		_ = store.Put(rel, v)
+	}()
+}
+
+func askHashLookup(hl *config.HashLookup, q map[string]any) (verdict, error) {
+	timeout := defaultHashLookupTimeout
+	if hl.TimeoutSeconds > 0 {
+		timeout = time.Duration(hl.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	b, err := json.Marshal(q)
+	if err != nil {
+		return verdict{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hl.URL, bytes.NewReader(b))
+	if err != nil {
+		return verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hl.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return verdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return verdict{}, errors.New("hash lookup: " + resp.Status)
+	}
+	var v verdict
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxVerdictBody)).Decode(&v); err != nil {
+		return verdict{}, err
+	}
+	switch v.Verdict = strings.ToLower(strings.TrimSpace(v.Verdict)); v.Verdict {
+	case "clean", "suspicious", "malicious":
+	default:
+		v.Verdict = "unknown"
+	}
+	// Only links the UI can safely put in an href.
+	if u, err := url.Parse(v.Link); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		v.Link = ""
+	}
+	return v, nil
+}
+
+// verdictInfo fills the verdict fields of a listing entry from store, if
+// the file is still the version that was looked up.
+func verdictInfo(it *listItem, store *meta.Store, info os.FileInfo) {
+	if store == nil || info == nil || !info.Mode().IsRegular() {
+		return
+	}
+	var v verdict
+	if ok, err := store.Get(it.Path, &v); err != nil || !ok {
+		return
+	}
+	if v.Size != info.Size() || v.Mtime != info.ModTime().UnixNano() {
+		return
+	}
+	it.Verdict, it.VerdictDetail, it.VerdictLink = v.Verdict, v.Detail, v.Link
+}
+
+// moveVerdicts keeps verdicts with their files when rel is renamed to to,
+// or drops them when it is deleted (to == "").
+func (s *Server) moveVerdicts(r *http.Request, rel, to string) {
+	if s.config().HashLookup == nil || rel == "" {
+		return
+	}
+	store, err := s.metaStore(r, "verdicts")
+	if err != nil {
+		return
+	}
+	if to == "" {
+		_ = store.DeleteTree(rel)
+	} else {
+		_ = store.MoveTree(rel, to)
+	}
+}
@@ -0,0 +1,133 @@
+package httpserver
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"lanparty/internal/fsutil"
+)
+
+// Hash maps: path -> sha256 for a folder, from what the server already knows
+// instead of reading the files, so sync and swarm clients can work out what
+// they are missing before they download anything. A file's hash is known
+// when it is still hardlinked to the dedup blob it was stored as (uploads,
+// /api/write, WebDAV PUT), or when this version of it was hashed before
+// (manifests, checksum lists, download plans).
+
+// knownSHA256 returns abs's hash if the hash cache has this version of it.
+func (s *Server) knownSHA256(abs string, st os.FileInfo) (string, bool) {
+	s.hashMu.Lock()
+	defer s.hashMu.Unlock()
+	sum, ok := s.hashCache[hashKey(abs, st)]
+	return sum, ok
+}
+
+// blobRefsBelow maps the paths below rel recorded in "blobrefs" to the
+// blob they were stored as.
+func (s *Server) blobRefsBelow(r *http.Request, rel string) map[string]string {
+	st, err := s.metaStore(r, "blobrefs")
+	if err != nil {
+		return nil
+	}
+	out := map[string]string{}
+	for _, sha := range st.Keys("") {
+		var refs []string
+		if ok, _ := st.Get(sha, &refs); !ok {
+			continue
+		}
+		for _, p := range refs {
+			if rel == "" || p == rel || strings.HasPrefix(p, rel+"/") {
+				out[p] = sha
+			}
+		}
+	}
+	return out
+}
+
+// handleHashMap returns the hashes of the files below a folder.
+//
+//	GET /api/hashmap?path=<dir>[&hash=1]  -> {root, files: {<path>: <sha256>}, unknown: [<path>]}
+//
+// Paths are relative to the folder. Files whose hash the server would have
+// to read them for are listed in unknown, unless hash=1 asks it to; those
+// hashes are cached per file version like the manifest's. Leaves out what
+// the manifest leaves out.
+func (s *Server) handleHashMap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.shareKey(r) != nil {
+		http.Error(w, "not supported on encrypted shares", http.StatusNotImplemented)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !st.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+	store, _, err := s.shareDeps(r)
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+
+	files, err := s.walkTree(r, abs, rel, cfg.StateDir, false)
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "hash map failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	hash := r.URL.Query().Get("hash") == "1"
+	refs := s.blobRefsBelow(r, rel)
+	sums := make(map[string]string, len(files))
+	unknown := []string{}
+	for _, f := range files {
+		p := filepath.Join(abs, filepath.FromSlash(f.Path))
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if sum, ok := s.knownSHA256(p, fi); ok {
+			sums[f.Path] = sum
+			continue
+		}
+		if sha, ok := refs[path.Join(rel, f.Path)]; ok {
+			// Only a hardlink to the blob proves the file was not
+			// rewritten since; copied-out blobs are not trusted.
+			if bst, err := os.Stat(store.BlobPath(sha)); err == nil && os.SameFile(fi, bst) {
+				sums[f.Path] = sha
+				continue
+			}
+		}
+		if !hash {
+			unknown = append(unknown, f.Path)
+			continue
+		}
+		sum, err := s.fileSHA256(p, fi)
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			unknown = append(unknown, f.Path)
+			continue
+		}
+		sums[f.Path] = sum
+	}
+	writeJSON(w, map[string]any{"root": "/" + rel, "files": sums, "unknown": unknown})
+}
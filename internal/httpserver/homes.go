@@ -0,0 +1,340 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+// Home folders (config.Homes). Each user's home is served as the share
+// "~<user>"; /s/~/ resolves to the caller's own, so one URL works for
+// everybody. Homes are created from the template when /api/admin/users adds
+// the user, or else the first time their user is seen.
+
+const defaultHomesDir = "homes"
+
+func checkHomes(h *config.Homes) error {
+	if h == nil {
+		return nil
+	}
+	if h.Dir != "" && !filepath.IsAbs(h.Dir) && !filepath.IsLocal(h.Dir) {
+		return errors.New("dir must be absolute or inside root")
+	}
+	if h.Template != "" {
+		if st, err := os.Stat(h.Template); err != nil || !st.IsDir() {
+			return fmt.Errorf("template %q is not a folder", h.Template)
+		}
+	}
+	return nil
+}
+
+// homeUser returns the user a home share name ("~alice") belongs to.
+func homeUser(share string) (string, bool) {
+	u, ok := strings.CutPrefix(share, "~")
+	return u, ok && validHomeName(u)
+}
+
+// validHomeName reports whether user can name a folder of its own.
+func validHomeName(user string) bool {
+	return user != "" && user != "." && user != ".." && !strings.ContainsAny(user, "/\\:\x00")
+}
+
+// homesDir returns the folder holding the homes.
+func homesDir(cfg config.Config) string {
+	d := cfg.Homes.Dir
+	if d == "" {
+		d = defaultHomesDir
+	}
+	if filepath.IsAbs(d) {
+		return d
+	}
+	return filepath.Join(cfg.Root, d)
+}
+
+// homeCfg is the share config of user's home. Its state lives outside the
+// home so the owner's files are all there is in it.
+func homeCfg(cfg config.Config, user string) config.Config {
+	stateDir := cfg.StateDir
+	if stateDir == "" {
+		stateDir = filepath.Join(cfg.Root, ".lanparty")
+	}
+	cfg.Root = filepath.Join(homesDir(cfg), user)
+	cfg.StateDir = filepath.Join(stateDir, "homes", user)
+	cfg.ACLs = []config.ACL{{Path: "/", Read: []string{user}, Write: []string{user}}}
+	return cfg
+}
+
+// isHomeShare reports whether share names a home: "~" or "~<known user>".
+func (s *Server) isHomeShare(share string) bool {
+	cfg := s.config()
+	if cfg.Homes == nil {
+		return false
+	}
+	if share == "~" {
+		return true
+	}
+	u, ok := homeUser(share)
+	if !ok {
+		return false
+	}
+	_, known := cfg.Users[u]
+	return known || tokenUserExists(cfg.Tokens, u)
+}
+
+// homesRel returns the homes folder relative to the root of share, if the
+// homes live inside it.
+func homesRel(cfg config.Config, share string) (string, bool) {
+	if share != "" || cfg.Homes == nil || filepath.IsAbs(cfg.Homes.Dir) {
+		return "", false
+	}
+	d := cfg.Homes.Dir
+	if d == "" {
+		d = defaultHomesDir
+	}
+	return filepath.ToSlash(filepath.Clean(d)), true
+}
+
+// homesFilter returns which entries of folder rel a listing shows, or nil
+// for all of them. In the homes folder, that is the caller's own home and
+// those with a folder the caller can read, like the ones homes.acls
+// shares; admins see every home.
+func (s *Server) homesFilter(r *http.Request, rel string) func(name string) bool {
+	cfg := s.cfgForReq(r)
+	d, ok := homesRel(cfg, shareFromContext(r.Context()))
+	if !ok || rel != d {
+		return nil
+	}
+	if admin, err := s.allowed(r, auth.PermAdmin, "/"); err == nil && admin {
+		return nil
+	}
+	readable := func(p string) bool {
+		ok, err := s.allowed(r, auth.PermRead, p)
+		return err == nil && ok
+	}
+	return func(name string) bool {
+		home := "/" + joinRel(rel, name)
+		if readable(home) {
+			return true
+		}
+		for _, a := range cfg.ACLs {
+			if p := path.Clean("/" + a.Path); !auth.IsACLPattern(a.Path) && strings.HasPrefix(p, home+"/") && readable(p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// homeAllowed keeps homes inside the default share private: only the owner
+// gets into /<dir>/<user>, whatever the ACLs say about the rest of the tree.
+// decided is false for paths the ACLs should judge.
+func homeAllowed(cfg config.Config, share, user string, perm auth.Perm, cleanPath string) (ok, decided bool) {
+	d, ok := homesRel(cfg, share)
+	if !ok {
+		return false, false
+	}
+	rest, found := strings.CutPrefix(cleanPath, "/"+d+"/")
+	if !found || rest == "" {
+		return false, false
+	}
+	owner, _, _ := strings.Cut(rest, "/")
+	if user != "" && user == owner {
+		return perm != auth.PermAdmin, true
+	}
+	// Everybody else only gets in through ACL entries about this home,
+	// like the ones homes.acls provisions; the rest of it stays shut.
+	// Deny lists further up still take people out.
+	home := "/" + d + "/" + owner
+	var own, deny []config.ACL
+	for _, a := range cfg.ACLs {
+		p := path.Clean("/" + a.Path)
+		switch {
+		case auth.IsACLPattern(a.Path):
+			// A pattern is about this home where it covers cleanPath
+			// below the home folder itself.
+			if n, ok := auth.ACLMatch(a.Path, cleanPath); ok && n >= len(home) {
+				own = append(own, a)
+			} else if len(a.DenyRead)+len(a.DenyWrite)+len(a.DenyAdmin) > 0 {
+				deny = append(deny, config.ACL{Path: a.Path, DenyRead: a.DenyRead, DenyWrite: a.DenyWrite, DenyAdmin: a.DenyAdmin})
+			}
+		case p == home || strings.HasPrefix(p, home+"/"):
+			own = append(own, a)
+		case len(a.DenyRead)+len(a.DenyWrite)+len(a.DenyAdmin) > 0:
+			deny = append(deny, config.ACL{Path: a.Path, DenyRead: a.DenyRead, DenyWrite: a.DenyWrite, DenyAdmin: a.DenyAdmin})
+		}
+	}
+	if len(own) == 0 {
+		return false, true
+	}
+	cfg.ACLs = append(append(own, config.ACL{Path: auth.QuoteACLPath(home)}), deny...)
+	ok, _ = auth.Allowed(cfg, user, cleanPath, perm)
+	return ok, true
+}
+
+// ensureHome creates user's home once per process.
+func (s *Server) ensureHome(cfg config.Config, user string) error {
+	dir := filepath.Join(homesDir(cfg), user)
+	if _, ok := s.homesMade.Load(dir); ok {
+		return nil
+	}
+	if err := provisionHome(dir, cfg.Homes.Template); err != nil {
+		return err
+	}
+	s.homesMade.Store(dir, struct{}{})
+	return nil
+}
+
+// provisionHome creates the home dir from template unless it exists. The
+// copy is built next to it and renamed into place, so a home is either
+// complete or missing.
+func provisionHome(dir, template string) error {
+	if _, err := os.Stat(dir); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	if template == "" {
+		if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		return nil
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".new-home-*")
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp, 0o755)
+	if err == nil {
+		err = copyDirNoSymlinks(context.Background(), template, tmp, false)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dir)
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		// Somebody else finished first.
+		if _, serr := os.Stat(dir); serr == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// homeACLs expands the homes.acls template for user.
+func homeACLs(tmpl []config.ACL, user string) []config.ACL {
+	out := cloneACLs(tmpl)
+	for i := range out {
+		out[i].Path = homeACLPath(out[i].Path, user)
+		for _, list := range [][]string{out[i].Read, out[i].Write, out[i].Admin} {
+			for j := range list {
+				list[j] = strings.ReplaceAll(list[j], "{user}", user)
+			}
+		}
+	}
+	return out
+}
+
+// homeACLPath puts user in for {user} in ACL path ap, escaped to match
+// just that name.
+func homeACLPath(ap, user string) string {
+	switch {
+	case strings.HasPrefix(ap, auth.ACLRegexPrefix):
+		return strings.ReplaceAll(ap, "{user}", regexp.QuoteMeta(user))
+	case auth.IsACLPattern(ap):
+		return strings.ReplaceAll(ap, "{user}", auth.EscapeGlob(user))
+	}
+	return auth.QuoteACLPath(strings.ReplaceAll(ap, "{user}", user))
+}
+
+func sameACL(a, b config.ACL) bool {
+	return a.Path == b.Path && slices.Equal(a.Read, b.Read) && slices.Equal(a.Write, b.Write) && slices.Equal(a.Admin, b.Admin) &&
+		slices.Equal(a.DenyRead, b.DenyRead) && slices.Equal(a.DenyWrite, b.DenyWrite) && slices.Equal(a.DenyAdmin, b.DenyAdmin) &&
+		a.OwnersManage == b.OwnersManage
+}
+
+// addACLs puts add in front of acls (so they win ties with entries for the
+// same path), skipping entries acls already has.
+func addACLs(acls, add []config.ACL) []config.ACL {
+	var out []config.ACL
+	for _, a := range add {
+		if !slices.ContainsFunc(acls, func(b config.ACL) bool { return sameACL(a, b) }) {
+			out = append(out, a)
+		}
+	}
+	return append(out, acls...)
+}
+
+// removeACLs drops the entries of acls that equal one in drop.
+func removeACLs(acls, drop []config.ACL) []config.ACL {
+	return slices.DeleteFunc(acls, func(a config.ACL) bool {
+		return slices.ContainsFunc(drop, func(b config.ACL) bool { return sameACL(a, b) })
+	})
+}
+
+// homes sits behind authentication: it creates the caller's home, points
+// /s/~/ (and /dav/ with homes.dav) at it, and turns anonymous callers away
+// from homes.
+func (s *Server) homes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.config()
+		if cfg.Homes == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user := auth.UserFromContext(r.Context())
+		share := shareFromContext(r.Context())
+		inHome := strings.HasPrefix(share, "~")
+		if user == "" || !validHomeName(user) {
+			if inHome {
+				if auth.HasAuth(*cfg) && user == "" {
+					s.authChallenge(w)
+				} else {
+					http.NotFound(w, r)
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		davHome := share == "" && cfg.Homes.Dav && (r.URL.Path == "/dav" || strings.HasPrefix(r.URL.Path, "/dav/"))
+		if err := s.ensureHome(*cfg, user); err != nil && (inHome || davHome) {
+			http.Error(w, "home unavailable", http.StatusInternalServerError)
+			return
+		}
+		if share == "~" || davHome {
+			r = r.WithContext(context.WithValue(r.Context(), shareKey, "~"+user))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// markHomesHTML tells the UI to offer a "My files" link.
+func (s *Server) markHomesHTML(b []byte) []byte {
+	if s.config().Homes == nil {
+		return b
+	}
+	const bodyTag = "<body"
+	idx := bytes.Index(b, []byte(bodyTag))
+	if idx < 0 {
+		return b
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(b) + 16)
+	buf.Write(b[:idx+len(bodyTag)])
+	buf.WriteString(` data-homes="1"`)
+	buf.Write(b[idx+len(bodyTag):])
+	return buf.Bytes()
+}
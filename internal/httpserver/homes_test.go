@@ -0,0 +1,86 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"lanparty/internal/config"
+)
+
+// Listing the homes folder shows the caller's own home and homes with a
+// folder shared with them; admins see all of them.
+func TestHomesListing(t *testing.T) {
+	s := testServer(t, func(cfg *config.Config) {
+		cfg.Users["carol"] = cfg.Users["bob"]
+		cfg.Homes = &config.Homes{Dir: "homes"}
+		cfg.ACLs = append([]config.ACL{{Path: "/homes/carol/public", Read: []string{"*"}}}, cfg.ACLs...)
+	})
+	for _, u := range []string{"alice", "bob", "carol", "dave"} {
+		if err := os.MkdirAll(filepath.Join(s.config().Root, "homes", u, "public"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.Handler()
+	list := func(user string, stream bool) []string {
+		t.Helper()
+		target := "/api/list?path=homes"
+		if stream {
+			target += "&stream=1"
+		}
+		w := serveAs(h, httptest.NewRequest(http.MethodGet, target, nil), user)
+		if w.Code != http.StatusOK {
+			t.Fatalf("list as %s: %d %s", user, w.Code, w.Body)
+		}
+		var names []string
+		dec := json.NewDecoder(w.Body)
+		for dec.More() {
+			var v struct {
+				Name  string `json:"name"`
+				Items []struct {
+					Name string `json:"name"`
+				} `json:"items"`
+			}
+			if err := dec.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			if v.Name != "" {
+				names = append(names, v.Name)
+			}
+			for _, it := range v.Items {
+				names = append(names, it.Name)
+			}
+		}
+		return names
+	}
+	for _, stream := range []bool{false, true} {
+		if got, want := list("bob", stream), []string{"bob", "carol"}; !sameNames(got, want) {
+			t.Errorf("bob (stream %v) sees %q, want %q", stream, got, want)
+		}
+		if got, want := list("alice", stream), []string{"alice", "bob", "carol", "dave"}; !sameNames(got, want) {
+			t.Errorf("alice (stream %v) sees %q, want %q", stream, got, want)
+		}
+	}
+
+	r := httptest.NewRequest("PROPFIND", "/dav/homes/", nil)
+	r.Header.Set("Depth", "1")
+	w := serveAs(h, r, "bob")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND: %d %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "/homes/bob/") || strings.Contains(body, "/homes/dave/") {
+		t.Errorf("PROPFIND as bob:\n%s", body)
+	}
+}
+
+func sameNames(got, want []string) bool {
+	sort.Strings(got)
+	return reflect.DeepEqual(got, want)
+}
@@ -0,0 +1,172 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Admin jobs: long maintenance tasks (reindexes, snapshots, blob store
+// scrubs) register here, so /api/admin/jobs can show what is running and
+// what ran lately, with durations and errors, and cancel any of them. The
+// list lives in memory; the last maxFinishedJobs finished jobs are kept.
+
+const maxFinishedJobs = 50
+
+type adminJob struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"` // reindex, snapshot, scrub
+	Share    string `json:"share"`
+	User     string `json:"user,omitempty"`
+	State    string `json:"state"` // running, done, failed, canceled
+	Detail   string `json:"detail,omitempty"`
+	Started  int64  `json:"started"` // unix ms
+	Finished int64  `json:"finished,omitempty"`
+	// DurationMs is how long the job ran, or has been running so far.
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// detail, when set, describes the job's progress. It is called
+	// without the list locked.
+	detail func() string
+}
+
+type jobList struct {
+	mu   sync.Mutex
+	seq  int
+	jobs []*adminJob // oldest first
+}
+
+// startJob registers a job of kind on share and returns the context it must
+// run under; canceling the job cancels it. detail may be nil. Pair with
+// endJob.
+func (s *Server) startJob(parent context.Context, kind, share, user string, detail func() string) (context.Context, *adminJob) {
+	ctx, cancel := context.WithCancel(parent)
+	jl := &s.jobs
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+	jl.seq++
+	j := &adminJob{
+		ID: strconv.Itoa(jl.seq), Kind: kind, Share: share, User: user, State: "running",
+		Started: time.Now().UnixMilli(), ctx: ctx, cancel: cancel, detail: detail,
+	}
+	jl.jobs = append(jl.jobs, j)
+	return ctx, j
+}
+
+// endJob records how j ended: canceled if its context was, else failed
+// with err or done.
+func (s *Server) endJob(j *adminJob, err error) {
+	detail := ""
+	if j.detail != nil {
+		detail = j.detail()
+	}
+	jl := &s.jobs
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+	switch {
+	case j.ctx.Err() != nil:
+		j.State = "canceled"
+	case err != nil:
+		j.State, j.Error = "failed", err.Error()
+	default:
+		j.State = "done"
+	}
+	j.Detail = detail
+	j.Finished = time.Now().UnixMilli()
+	j.DurationMs = j.Finished - j.Started
+	j.cancel()
+	// Drop the oldest finished jobs beyond the limit.
+	finished := 0
+	for _, o := range jl.jobs {
+		if o.Finished != 0 {
+			finished++
+		}
+	}
+	kept := jl.jobs[:0]
+	for _, o := range jl.jobs {
+		if o.Finished != 0 && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, o)
+	}
+	clear(jl.jobs[len(kept):])
+	jl.jobs = kept
+}
+
+// listJobs returns copies of the jobs, running ones first, newest first.
+func (s *Server) listJobs() []adminJob {
+	jl := &s.jobs
+	jl.mu.Lock()
+	now := time.Now().UnixMilli()
+	var running, done []adminJob
+	for i := len(jl.jobs) - 1; i >= 0; i-- {
+		j := *jl.jobs[i]
+		j.ctx, j.cancel = nil, nil
+		if j.Finished == 0 {
+			j.DurationMs = now - j.Started
+			running = append(running, j)
+		} else {
+			done = append(done, j)
+		}
+	}
+	jl.mu.Unlock()
+	// Details are read unlocked (see adminJob.detail).
+	for i := range running {
+		if running[i].detail != nil {
+			running[i].Detail = running[i].detail()
+		}
+	}
+	out := append(append(make([]adminJob, 0, len(running)+len(done)), running...), done...)
+	for i := range out {
+		out[i].detail = nil
+	}
+	return out
+}
+
+var errNoJob = errors.New("no such running job")
+
+// cancelJob cancels the running job id.
+func (s *Server) cancelJob(id string) error {
+	jl := &s.jobs
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+	for _, j := range jl.jobs {
+		if j.ID == id && j.Finished == 0 {
+			j.cancel()
+			return nil
+		}
+	}
+	return errNoJob
+}
+
+// handleAdminJobs lists the admin jobs of every share and cancels them.
+//
+//	GET    /api/admin/jobs          -> {jobs:[{id, kind, share, user, state, detail, started, finished, durationMs, error}]}
+//	DELETE /api/admin/jobs?id=<id>  -> {ok}
+//
+// A canceled job stops at its next check, so it may show as running for a
+// moment longer.
+func (s *Server) handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"jobs": s.listJobs()})
+	case http.MethodDelete:
+		if err := s.cancelJob(r.URL.Query().Get("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
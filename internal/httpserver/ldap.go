@@ -0,0 +1,273 @@
+package httpserver
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"lanparty/internal/config"
+)
+
+// LDAP passwords (config.LDAP): a user lanparty does not know from Users
+// signs in with their directory password, checked by binding as them. The
+// groups the directory puts them in go along with the request (or the
+// session of a browser sign-in), where ACL entries like "@admins" find
+// them. Local users come first: a name in Users never reaches the
+// directory.
+//
+// Successful checks are remembered for a minute by default, keyed by a
+// hash of name and password, since WebDAV and API clients send Basic
+// credentials with every request.
+
+const (
+	ldapTimeout           = 10 * time.Second
+	defaultLDAPCache      = time.Minute
+	defaultLDAPUserFilter = "(uid={user})"
+	maxLDAPCached         = 1024
+)
+
+var errLDAPDenied = errors.New("ldap: wrong user name or password")
+
+type ldapCached struct {
+	user   string
+	groups []string
+	until  time.Time
+}
+
+func checkLDAP(l *config.LDAP) error {
+	if l == nil {
+		return nil
+	}
+	u, err := url.Parse(l.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return errors.New("url must be an ldap:// or ldaps:// URL")
+	}
+	if l.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("startTLS is for ldap:// URLs")
+	}
+	if l.UserDN == "" && l.BaseDN == "" {
+		return errors.New("userDN or baseDN is required")
+	}
+	if l.UserDN != "" && !strings.Contains(l.UserDN, "{user}") {
+		return errors.New("userDN must contain {user}")
+	}
+	if l.BindDN != "" && l.BindPassword == "" {
+		return errors.New("bindDN needs bindPassword")
+	}
+	for _, f := range []string{l.UserFilter, l.GroupFilter} {
+		if f != "" {
+			if _, err := ldap.CompileFilter(strings.NewReplacer("{user}", "x", "{dn}", "x").Replace(f)); err != nil {
+				return fmt.Errorf("bad filter %q: %w", f, err)
+			}
+		}
+	}
+	if l.CAFile != "" {
+		if _, err := os.ReadFile(l.CAFile); err != nil {
+			return fmt.Errorf("caFile: %w", err)
+		}
+	}
+	for from, to := range l.Groups {
+		if from == "" || to == "" {
+			return errors.New("groups: names cannot be empty")
+		}
+	}
+	return nil
+}
+
+// ldapLogin checks user's password against the directory and returns the
+// lanparty user name and groups. errLDAPDenied means the directory said
+// no; other errors, which are logged, that it could not be asked.
+func (s *Server) ldapLogin(l *config.LDAP, user, pass string) (string, []string, error) {
+	// An empty password would be an anonymous bind, which succeeds.
+	if user == "" || pass == "" {
+		return "", nil, errLDAPDenied
+	}
+	ttl := defaultLDAPCache
+	if l.CacheSeconds > 0 {
+		ttl = time.Duration(l.CacheSeconds) * time.Second
+	} else if l.CacheSeconds < 0 {
+		ttl = 0
+	}
+	sum := sha256.Sum256([]byte(l.URL + "\x00" + user + "\x00" + pass))
+	key := string(sum[:])
+	if ttl > 0 {
+		s.ldapMu.Lock()
+		c, ok := s.ldapCache[key]
+		s.ldapMu.Unlock()
+		if ok && time.Now().Before(c.until) {
+			return c.user, c.groups, nil
+		}
+	}
+	name, groups, err := ldapCheck(l, user, pass)
+	if err != nil {
+		if !errors.Is(err, errLDAPDenied) {
+			log.Printf("ldap: %v", err)
+		}
+		return "", nil, err
+	}
+	if len(l.AllowedGroups) > 0 && !slices.ContainsFunc(l.AllowedGroups, func(g string) bool { return slices.Contains(groups, g) }) {
+		return "", nil, errLDAPDenied
+	}
+	if ttl > 0 {
+		now := time.Now()
+		s.ldapMu.Lock()
+		if len(s.ldapCache) >= maxLDAPCached {
+			for k, c := range s.ldapCache {
+				if now.After(c.until) {
+					delete(s.ldapCache, k)
+				}
+			}
+		}
+		if s.ldapCache == nil || len(s.ldapCache) >= maxLDAPCached {
+			s.ldapCache = map[string]ldapCached{}
+		}
+		s.ldapCache[key] = ldapCached{user: name, groups: groups, until: now.Add(ttl)}
+		s.ldapMu.Unlock()
+	}
+	return name, groups, nil
+}
+
+func ldapDial(l *config.LDAP) (*ldap.Conn, error) {
+	u, _ := url.Parse(l.URL)
+	tc := &tls.Config{ServerName: u.Hostname()}
+	if l.CAFile != "" {
+		pem, err := os.ReadFile(l.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(pem)
+		tc.RootCAs = pool
+	}
+	conn, err := ldap.DialURL(l.URL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}), ldap.DialWithTLSConfig(tc))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	if l.StartTLS {
+		if err := conn.StartTLS(tc); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func ldapCheck(l *config.LDAP, user, pass string) (string, []string, error) {
+	conn, err := ldapDial(l)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(cmp.Or(l.UserFilter, defaultLDAPUserFilter), "{user}", ldap.EscapeFilter(user))
+	attrs := []string{"memberOf"}
+	if l.UserAttr != "" {
+		attrs = append(attrs, l.UserAttr)
+	}
+	bind := func(dn, pw string) error {
+		err := conn.Bind(dn, pw)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return errLDAPDenied
+		}
+		return err
+	}
+	var entry *ldap.Entry
+	var dn string
+	if l.UserDN != "" {
+		dn = strings.ReplaceAll(l.UserDN, "{user}", ldap.EscapeDN(user))
+		if err := bind(dn, pass); err != nil {
+			return "", nil, err
+		}
+		if l.BaseDN != "" {
+			// Read the entry as the user, for memberOf and userAttr.
+			entry, _ = ldapFindOne(conn, l.BaseDN, filter, attrs)
+		}
+	} else {
+		if l.BindDN != "" {
+			if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+				return "", nil, fmt.Errorf("bind as bindDN: %w", err)
+			}
+		}
+		e, err := ldapFindOne(conn, l.BaseDN, filter, attrs)
+		if err != nil {
+			return "", nil, err
+		}
+		entry, dn = e, e.DN
+		if err := bind(dn, pass); err != nil {
+			return "", nil, err
+		}
+		if l.BindDN != "" && l.GroupFilter != "" {
+			// Group searches run with the search account's rights.
+			if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+				return "", nil, fmt.Errorf("bind as bindDN: %w", err)
+			}
+		}
+	}
+
+	name := user
+	if entry != nil && l.UserAttr != "" {
+		if v := entry.GetEqualFoldAttributeValue(l.UserAttr); v != "" {
+			name = v
+		}
+	}
+	if name == "" || strings.ContainsAny(name, ":/\\\x00") {
+		return "", nil, fmt.Errorf("ldap: %q is not a usable user name", name)
+	}
+
+	var cns []string
+	if l.GroupFilter != "" {
+		gf := strings.NewReplacer("{dn}", ldap.EscapeFilter(dn), "{user}", ldap.EscapeFilter(user)).Replace(l.GroupFilter)
+		res, err := conn.Search(ldap.NewSearchRequest(cmp.Or(l.GroupBaseDN, l.BaseDN), ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(ldapTimeout/time.Second), false, gf, []string{"cn"}, nil))
+		if err != nil {
+			return "", nil, fmt.Errorf("group search: %w", err)
+		}
+		for _, e := range res.Entries {
+			cns = append(cns, e.GetAttributeValue("cn"))
+		}
+	} else if entry != nil {
+		for _, g := range entry.GetEqualFoldAttributeValues("memberOf") {
+			if d, err := ldap.ParseDN(g); err == nil && len(d.RDNs) > 0 && len(d.RDNs[0].Attributes) > 0 {
+				cns = append(cns, d.RDNs[0].Attributes[0].Value)
+			}
+		}
+	}
+	var groups []string
+	for _, g := range cns {
+		if to, ok := l.Groups[g]; ok {
+			g = to
+		}
+		if g != "" && !slices.Contains(groups, g) {
+			groups = append(groups, g)
+		}
+	}
+	return name, groups, nil
+}
+
+// ldapFindOne returns the only entry below base matching filter; none or
+// several are errLDAPDenied.
+func ldapFindOne(conn *ldap.Conn, base, filter string, attrs []string) (*ldap.Entry, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout/time.Second), false, filter, attrs, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("user search: %w", err)
+	}
+	if res == nil || len(res.Entries) != 1 {
+		return nil, errLDAPDenied
+	}
+	return res.Entries[0], nil
+}
@@ -0,0 +1,225 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+// Concurrency caps for uploads, downloads, thumbnail/ffmpeg work and zip
+// streams (see config.Limits), overall and per user. Caps are read from the
+// current config on every request, so edits apply without a restart; work
+// already running keeps its slot.
+
+const (
+	limitUploads   = "uploads"
+	limitMedia     = "thumbs" // thumbnails, transcodes and remuxes
+	limitZips      = "zips"
+	limitDownloads = "downloads"
+
+	defaultThumbJobs    = 4
+	defaultLimitQueue   = 32
+	defaultQueueSeconds = 30
+)
+
+// errBusy means a slot did not free up in time, or the queue was full.
+var errBusy = errors.New("server busy")
+
+// limiter hands out up to max slots; callers over the cap wait in line.
+type limiter struct {
+	mu      sync.Mutex
+	active  int
+	waiting int
+	freed   chan struct{} // closed and replaced whenever a slot frees
+}
+
+func newLimiter() *limiter { return &limiter{freed: make(chan struct{})} }
+
+// acquire takes a slot, waiting for at most wait behind at most queue
+// others. max <= 0 means no cap.
+func (l *limiter) acquire(ctx context.Context, max, queue int, wait time.Duration) error {
+	l.mu.Lock()
+	if max <= 0 || l.active < max {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.waiting >= queue {
+		l.mu.Unlock()
+		return errBusy
+	}
+	l.waiting++
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		freed := l.freed
+		l.mu.Unlock()
+		var err error
+		select {
+		case <-freed:
+		case <-timer.C:
+			err = errBusy
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		l.mu.Lock()
+		if l.active < max {
+			l.waiting--
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			l.waiting--
+			l.mu.Unlock()
+			return err
+		}
+	}
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.active--
+	close(l.freed)
+	l.freed = make(chan struct{})
+	l.mu.Unlock()
+}
+
+func (l *limiter) stats() (active, waiting int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active, l.waiting
+}
+
+// limitCap returns the configured cap for kind; 0 means none.
+func limitCap(l config.Limits, kind string) int {
+	switch kind {
+	case limitUploads:
+		return l.Uploads
+	case limitMedia:
+		if l.Thumbs == 0 {
+			return defaultThumbJobs
+		}
+		return l.Thumbs
+	case limitZips:
+		return l.Zips
+	case limitDownloads:
+		return l.Downloads
+	}
+	return 0
+}
+
+// userLimitCap returns the per-user cap for kind; 0 means none.
+func userLimitCap(l config.Limits, kind string) int {
+	switch kind {
+	case limitUploads:
+		return l.PerUser.Uploads
+	case limitDownloads:
+		return l.PerUser.Downloads
+	case limitZips:
+		return l.PerUser.Zips
+	}
+	return 0
+}
+
+// userSlots counts the slots each user holds, by kind and user.
+type userSlots struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+// userSlot takes one of the request's user's slots of kind, or reports
+// that they hold all of them already. Anonymous requests count by client
+// address.
+func (s *Server) userSlot(r *http.Request, kind string) (func(), bool) {
+	max := userLimitCap(s.config().Limits, kind)
+	if max <= 0 {
+		return func() {}, true
+	}
+	who := auth.UserFromContext(r.Context())
+	if who == "" {
+		who = "@" + s.remoteHost(r)
+	}
+	key := kind + "\x00" + who
+	u := &s.userSlots
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.n[key] >= max {
+		return nil, false
+	}
+	if u.n == nil {
+		u.n = map[string]int{}
+	}
+	u.n[key]++
+	return func() {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if u.n[key]--; u.n[key] <= 0 {
+			delete(u.n, key)
+		}
+	}, true
+}
+
+// acquireSlot takes a slot of kind for the duration of some work.
+func (s *Server) acquireSlot(ctx context.Context, kind string) (func(), error) {
+	l := s.config().Limits
+	queue, wait := l.Queue, time.Duration(l.QueueSeconds)*time.Second
+	if queue == 0 {
+		queue = defaultLimitQueue
+	} else if queue < 0 {
+		queue = 0
+	}
+	if wait <= 0 {
+		wait = defaultQueueSeconds * time.Second
+	}
+	lim := s.limiters[kind]
+	if err := lim.acquire(ctx, limitCap(l, kind), queue, wait); err != nil {
+		return nil, err
+	}
+	return lim.release, nil
+}
+
+// limit is acquireSlot for handlers, after the user's own cap: it answers
+// 429 (with Retry-After) when no slot is free and reports whether to go on.
+func (s *Server) limit(w http.ResponseWriter, r *http.Request, kind string) (func(), bool) {
+	releaseUser, ok := s.userSlot(r, kind)
+	if !ok {
+		tooBusy(w)
+		return nil, false
+	}
+	release, err := s.acquireSlot(r.Context(), kind)
+	if err != nil {
+		releaseUser()
+		if errors.Is(err, errBusy) {
+			tooBusy(w)
+		}
+		return nil, false
+	}
+	untrack := s.trackTransfer(r, kind)
+	return func() {
+		untrack()
+		release()
+		releaseUser()
+	}, true
+}
+
+func tooBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, "too many requests, try again shortly", http.StatusTooManyRequests)
+}
+
+// limitStats reports slot use per kind for the admin overview.
+func (s *Server) limitStats() map[string]any {
+	l := s.config().Limits
+	out := map[string]any{}
+	for kind, lim := range s.limiters {
+		active, waiting := lim.stats()
+		out[kind] = map[string]int{"active": active, "waiting": waiting, "max": limitCap(l, kind), "perUser": userLimitCap(l, kind)}
+	}
+	return out
+}
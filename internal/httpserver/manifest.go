@@ -0,0 +1,191 @@
+package httpserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/fsutil"
+	"lanparty/internal/manifest"
+)
+
+// manifestKeyFile holds the ed25519 seed distribution manifests are signed
+// with. Deleting it makes the server sign with a new key.
+const manifestKeyFile = "manifest.key"
+
+// hashCacheMax bounds the in-memory file hash cache; it is simply cleared
+// when full.
+const hashCacheMax = 200000
+
+func (s *Server) manifestSigner() ed25519.PrivateKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.manifestKey != nil {
+		return s.manifestKey
+	}
+	stateDir := s.config().StateDir
+	var p string
+	if stateDir != "" {
+		p = filepath.Join(stateDir, manifestKeyFile)
+		if b, err := os.ReadFile(p); err == nil && len(b) == ed25519.SeedSize {
+			s.manifestKey = ed25519.NewKeyFromSeed(b)
+			return s.manifestKey
+		}
+	}
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		panic(err)
+	}
+	if p != "" {
+		if err := os.MkdirAll(stateDir, 0o755); err == nil {
+			_ = os.WriteFile(p, seed, 0o600)
+		}
+	}
+	s.manifestKey = ed25519.NewKeyFromSeed(seed)
+	return s.manifestKey
+}
+
+// fileSHA256 hashes abs, reusing the result while size and mtime are
+// unchanged.
+func (s *Server) fileSHA256(abs string, st os.FileInfo) (string, error) {
+	key := hashKey(abs, st)
+	s.hashMu.Lock()
+	sum, ok := s.hashCache[key]
+	s.hashMu.Unlock()
+	if ok {
+		return sum, nil
+	}
+	sum, _, err := manifest.HashFile(abs)
+	if err != nil {
+		return "", err
+	}
+	s.cacheSHA256(key, sum)
+	return sum, nil
+}
+
+// cacheSHA256 remembers sum for the file version key (see hashKey).
+func (s *Server) cacheSHA256(key, sum string) {
+	s.hashMu.Lock()
+	defer s.hashMu.Unlock()
+	if len(s.hashCache) >= hashCacheMax {
+		s.hashCache = map[string]string{}
+	}
+	s.hashCache[key] = sum
+}
+
+// hashKey identifies one version of a file in the hash cache.
+func hashKey(abs string, st os.FileInfo) string {
+	return abs + "\x00" + strconv.FormatInt(st.Size(), 10) + "\x00" + strconv.FormatInt(st.ModTime().UnixNano(), 10)
+}
+
+// handleManifest returns a signed manifest of a folder.
+//
+//	GET /api/manifest?path=<dir>[&dl=1]  -> {version, root, created, files:[{path,size,sha256}], publicKey, signature}
+//
+// Subfolders the caller cannot read and the state dir are left out. Hashes
+// are cached per file version, so repeat requests only re-hash what changed.
+// Check a copy with "lanparty verify".
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !st.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+
+	files, err := s.hashTree(r, abs, rel, cfg.StateDir)
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "manifest failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	m := manifest.Manifest{Version: manifest.Version, Root: "/" + rel, Created: time.Now().Unix(), Files: files}
+	m.Sign(s.manifestSigner())
+
+	if r.URL.Query().Get("dl") == "1" {
+		name := path.Base("/" + rel)
+		if rel == "" {
+			name = "root"
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".manifest.json"))
+	}
+	writeJSON(w, m)
+}
+
+// hashTree hashes every regular file below abs (the directory rel), sorted by
+// path. Subfolders the caller cannot read and the state dir are left out;
+// hashes come from the per-version cache where possible.
+func (s *Server) hashTree(r *http.Request, abs, rel, stateDir string) ([]manifest.File, error) {
+	return s.walkTree(r, abs, rel, stateDir, true)
+}
+
+// walkTree is hashTree with hashing optional; without it SHA256 stays empty.
+func (s *Server) walkTree(r *http.Request, abs, rel, stateDir string, hash bool) ([]manifest.File, error) {
+	files := []manifest.File{}
+	err := filepath.WalkDir(abs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if r.Context().Err() != nil {
+			return r.Context().Err()
+		}
+		sub, err := filepath.Rel(abs, p)
+		if err != nil {
+			return err
+		}
+		sub = filepath.ToSlash(sub)
+		if d.IsDir() {
+			if p == stateDir {
+				return filepath.SkipDir
+			}
+			if ok, _ := s.allowed(r, auth.PermRead, "/"+path.Join(rel, sub)); !ok {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var sum string
+		if hash {
+			if sum, err = s.fileSHA256(p, info); err != nil {
+				return err
+			}
+		}
+		files = append(files, manifest.File{Path: sub, Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
@@ -0,0 +1,744 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/exif"
+	"lanparty/internal/fsutil"
+	"lanparty/internal/tags"
+	"lanparty/internal/video"
+)
+
+// Media-oriented APIs (timeline, music library, playback positions, playlists, ...).
+
+type exifEntry struct {
+	mtime int64
+	size  int64
+	info  *exif.Info // nil when the file has no usable EXIF
+	w, h  int        // display dimensions (orientation applied); 0 if unknown
+}
+
+// maxExifCache bounds the in-memory EXIF cache; it is simply reset when full.
+const maxExifCache = 50_000
+
+// photoMeta returns cached EXIF info and display dimensions for an image,
+// re-reading them when the file's mtime or size changed. Only JPEGs carry
+// EXIF; other formats just get their header dimensions.
+func (s *Server) photoMeta(abs string, info os.FileInfo) exifEntry {
+	mt, sz := info.ModTime().UnixNano(), info.Size()
+	s.exifMu.Lock()
+	if e, ok := s.exifCache[abs]; ok && e.mtime == mt && e.size == sz {
+		s.exifMu.Unlock()
+		return e
+	}
+	s.exifMu.Unlock()
+
+	e := exifEntry{mtime: mt, size: sz}
+	if f, err := os.Open(abs); err == nil {
+		ext := strings.ToLower(filepath.Ext(abs))
+		if ext == ".jpg" || ext == ".jpeg" {
+			e.info, _ = exif.Read(f)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			if c, _, err := image.DecodeConfig(f); err == nil {
+				e.w, e.h = c.Width, c.Height
+				if e.info.SwapsAxes() {
+					e.w, e.h = e.h, e.w
+				}
+			}
+		}
+		_ = f.Close()
+	}
+
+	s.exifMu.Lock()
+	if s.exifCache == nil || len(s.exifCache) >= maxExifCache {
+		s.exifCache = map[string]exifEntry{}
+	}
+	s.exifCache[abs] = e
+	s.exifMu.Unlock()
+	return e
+}
+
+// photoExif returns the EXIF info of a JPEG; other files yield nil.
+func (s *Server) photoExif(abs string, info os.FileInfo) *exif.Info {
+	return s.photoMeta(abs, info).info
+}
+
+// photoTime is the capture time from EXIF, falling back to the file mtime.
+func (s *Server) photoTime(abs string, info os.FileInfo) (time.Time, bool) {
+	if ex := s.photoExif(abs, info); ex != nil && !ex.Taken.IsZero() {
+		return ex.Taken, true
+	}
+	return info.ModTime(), false
+}
+
+type timelinePhoto struct {
+	Path   string `json:"path"`
+	Taken  int64  `json:"taken"`
+	Exif   bool   `json:"exif"` // false: taken is the file mtime
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+type timelineBucket struct {
+	Key       string          `json:"key"` // 2006-01-02 (day) or 2006-01 (month)
+	Count     int             `json:"count"`
+	Start     int64           `json:"start"`
+	End       int64           `json:"end"`
+	CoverPath string          `json:"coverPath"`
+	Cover     string          `json:"cover"`
+	Items     []timelinePhoto `json:"items,omitempty"`
+}
+
+// handleTimeline groups the images below ?path= by capture date.
+//
+//	GET /api/timeline?path=<rel>&group=day|month&items=1
+//
+// Buckets are returned newest first; the cover is the earliest photo of each
+// bucket. Hidden (dot) directories are skipped, which also keeps the state
+// dir's thumbnail cache out of the results.
+func (s *Server) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	group := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("group")))
+	if group == "" {
+		group = "day"
+	}
+	var layout string
+	switch group {
+	case "day":
+		layout = "2006-01-02"
+	case "month":
+		layout = "2006-01"
+	default:
+		http.Error(w, "bad group", http.StatusBadRequest)
+		return
+	}
+	withItems := r.URL.Query().Get("items") == "1"
+
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	if st, err := os.Stat(abs); err != nil || !st.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+
+	const maxFiles = 200_000
+	var (
+		photos    []timelinePhoto
+		seen      int
+		truncated bool
+	)
+	ctx := r.Context()
+	_ = filepath.WalkDir(abs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		seen++
+		if seen > maxFiles {
+			truncated = true
+			return fs.SkipAll
+		}
+		if d.IsDir() {
+			if p != abs && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&os.ModeSymlink != 0 || !isImageExt(strings.ToLower(filepath.Ext(d.Name()))) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		r2, err := filepath.Rel(abs, p)
+		if err != nil {
+			return nil
+		}
+		taken, fromExif := s.photoTime(p, info)
+		pm := s.photoMeta(p, info)
+		photos = append(photos, timelinePhoto{
+			Path:   joinRel(rel, filepath.ToSlash(r2)),
+			Taken:  taken.Unix(),
+			Exif:   fromExif,
+			Width:  pm.w,
+			Height: pm.h,
+		})
+		return nil
+	})
+	if ctx.Err() != nil {
+		return
+	}
+
+	sort.Slice(photos, func(i, j int) bool {
+		if photos[i].Taken != photos[j].Taken {
+			return photos[i].Taken < photos[j].Taken
+		}
+		return photos[i].Path < photos[j].Path
+	})
+	buckets := make([]*timelineBucket, 0, 32)
+	byKey := map[string]*timelineBucket{}
+	for _, ph := range photos {
+		key := time.Unix(ph.Taken, 0).Format(layout)
+		b, ok := byKey[key]
+		if !ok {
+			b = &timelineBucket{
+				Key:       key,
+				Start:     ph.Taken,
+				CoverPath: ph.Path,
+			}
+			if s.enabled(featThumbs) {
+				b.Cover = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(ph.Path))
+			}
+			byKey[key] = b
+			buckets = append(buckets, b)
+		}
+		b.Count++
+		b.End = ph.Taken
+		if withItems {
+			b.Items = append(b.Items, ph)
+		}
+	}
+	// newest first
+	for i, j := 0, len(buckets)-1; i < j; i, j = i+1, j-1 {
+		buckets[i], buckets[j] = buckets[j], buckets[i]
+	}
+	writeJSON(w, map[string]any{
+		"path":      rel,
+		"group":     group,
+		"total":     len(photos),
+		"buckets":   buckets,
+		"truncated": truncated,
+	})
+}
+
+type tagEntry struct {
+	mtime int64
+	size  int64
+	tags  *tags.Tags // nil when the file is untagged or unsupported
+}
+
+// audioTags returns cached tags for an audio file, re-reading on change.
+func (s *Server) audioTags(abs string, info os.FileInfo) *tags.Tags {
+	mt, sz := info.ModTime().UnixNano(), info.Size()
+	s.exifMu.Lock()
+	if e, ok := s.tagCache[abs]; ok && e.mtime == mt && e.size == sz {
+		s.exifMu.Unlock()
+		return e.tags
+	}
+	s.exifMu.Unlock()
+
+	t, _ := tags.ReadFile(abs)
+
+	s.exifMu.Lock()
+	if s.tagCache == nil || len(s.tagCache) >= maxExifCache {
+		s.tagCache = map[string]tagEntry{}
+	}
+	s.tagCache[abs] = tagEntry{mtime: mt, size: sz, tags: t}
+	s.exifMu.Unlock()
+	return t
+}
+
+type videoEntry struct {
+	mtime int64
+	size  int64
+	info  *video.Info // nil when the container could not be probed
+}
+
+// videoInfo returns cached duration, size and codec of a video file,
+// re-probing on change.
+func (s *Server) videoInfo(abs string, info os.FileInfo) *video.Info {
+	mt, sz := info.ModTime().UnixNano(), info.Size()
+	s.exifMu.Lock()
+	if e, ok := s.videoCache[abs]; ok && e.mtime == mt && e.size == sz {
+		s.exifMu.Unlock()
+		return e.info
+	}
+	s.exifMu.Unlock()
+
+	v, _ := video.ReadFile(abs)
+
+	s.exifMu.Lock()
+	if s.videoCache == nil || len(s.videoCache) >= maxExifCache {
+		s.videoCache = map[string]videoEntry{}
+	}
+	s.videoCache[abs] = videoEntry{mtime: mt, size: sz, info: v}
+	s.exifMu.Unlock()
+	return v
+}
+
+func isAudioExt(ext string) bool {
+	switch ext {
+	case ".mp3", ".flac", ".m4a", ".aac", ".ogg", ".opus", ".wav":
+		return true
+	default:
+		return false
+	}
+}
+
+// coverNames are folder-level album art files, in order of preference.
+var coverNames = []string{"cover.jpg", "cover.png", "folder.jpg", "folder.png", "front.jpg", "front.png", "album.jpg", "album.png"}
+
+type musicTrack struct {
+	Path   string `json:"path"`
+	Title  string `json:"title"`
+	Artist string `json:"artist,omitempty"`
+	Track  int    `json:"track,omitempty"`
+	Size   int64  `json:"size"`
+	Mime   string `json:"mime,omitempty"`
+}
+
+type musicAlbum struct {
+	Name      string       `json:"name"`
+	Year      int          `json:"year,omitempty"`
+	Dir       string       `json:"dir"`
+	CoverPath string       `json:"coverPath,omitempty"`
+	Cover     string       `json:"cover,omitempty"`
+	Tracks    []musicTrack `json:"tracks"`
+}
+
+type musicArtist struct {
+	Name   string        `json:"name"`
+	Albums []*musicAlbum `json:"albums"`
+}
+
+// handleMusic aggregates the audio files below ?path= by artist and album.
+//
+//	GET /api/music?path=<rel>
+//
+// Tags (ID3/FLAC) win; untagged files fall back to the folder layout
+// (<artist>/<album>/<track>). Album art references a cover/folder image
+// next to the tracks when present.
+func (s *Server) handleMusic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	if st, err := os.Stat(abs); err != nil || !st.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+
+	const maxFiles = 200_000
+	var (
+		artists   []*musicArtist
+		seen      int
+		total     int
+		truncated bool
+	)
+	byArtist := map[string]*musicArtist{}
+	byAlbum := map[string]*musicAlbum{}
+	covers := map[string]string{} // dir rel -> cover rel ("" if none)
+	ctx := r.Context()
+	_ = filepath.WalkDir(abs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		seen++
+		if seen > maxFiles {
+			truncated = true
+			return fs.SkipAll
+		}
+		if d.IsDir() {
+			if p != abs && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&os.ModeSymlink != 0 || !isAudioExt(strings.ToLower(filepath.Ext(d.Name()))) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		r2, err := filepath.Rel(abs, p)
+		if err != nil {
+			return nil
+		}
+		trackRel := joinRel(rel, filepath.ToSlash(r2))
+		dirRel := strings.TrimPrefix(path.Dir("/"+trackRel), "/")
+
+		t := s.audioTags(p, info)
+		if t == nil {
+			t = &tags.Tags{}
+		}
+		artistName := t.AlbumArtist
+		if artistName == "" {
+			artistName = t.Artist
+		}
+		albumName := t.Album
+		if albumName == "" {
+			albumName = path.Base("/" + dirRel)
+			if dirRel == "" {
+				albumName = ""
+			}
+		}
+		if artistName == "" {
+			if parent := strings.TrimPrefix(path.Dir("/"+dirRel), "/"); parent != "" && dirRel != "" {
+				artistName = path.Base("/" + parent)
+			}
+		}
+		if artistName == "" {
+			artistName = "Unknown Artist"
+		}
+		if albumName == "" {
+			albumName = "Unknown Album"
+		}
+		title := t.Title
+		if title == "" {
+			title = strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))
+		}
+
+		ar, ok := byArtist[artistName]
+		if !ok {
+			ar = &musicArtist{Name: artistName}
+			byArtist[artistName] = ar
+			artists = append(artists, ar)
+		}
+		albumKey := artistName + "\x00" + albumName
+		al, ok := byAlbum[albumKey]
+		if !ok {
+			al = &musicAlbum{Name: albumName, Year: t.Year, Dir: dirRel}
+			cover, cached := covers[dirRel]
+			if !cached {
+				for _, cn := range coverNames {
+					if st, err := os.Stat(filepath.Join(filepath.Dir(p), cn)); err == nil && st.Mode().IsRegular() {
+						cover = joinRel(dirRel, cn)
+						break
+					}
+				}
+				covers[dirRel] = cover
+			}
+			if cover != "" {
+				al.CoverPath = cover
+				if s.enabled(featThumbs) {
+					al.Cover = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(cover))
+				}
+			}
+			byAlbum[albumKey] = al
+			ar.Albums = append(ar.Albums, al)
+		}
+		if al.Year == 0 {
+			al.Year = t.Year
+		}
+		al.Tracks = append(al.Tracks, musicTrack{
+			Path:   trackRel,
+			Title:  title,
+			Artist: t.Artist,
+			Track:  t.Track,
+			Size:   info.Size(),
+			Mime:   contentTypeForName(d.Name()),
+		})
+		total++
+		return nil
+	})
+	if ctx.Err() != nil {
+		return
+	}
+
+	sort.Slice(artists, func(i, j int) bool {
+		return strings.ToLower(artists[i].Name) < strings.ToLower(artists[j].Name)
+	})
+	for _, ar := range artists {
+		sort.Slice(ar.Albums, func(i, j int) bool {
+			if ar.Albums[i].Year != ar.Albums[j].Year {
+				return ar.Albums[i].Year < ar.Albums[j].Year
+			}
+			return strings.ToLower(ar.Albums[i].Name) < strings.ToLower(ar.Albums[j].Name)
+		})
+		for _, al := range ar.Albums {
+			sort.SliceStable(al.Tracks, func(i, j int) bool {
+				if al.Tracks[i].Track != al.Tracks[j].Track {
+					return al.Tracks[i].Track < al.Tracks[j].Track
+				}
+				return al.Tracks[i].Path < al.Tracks[j].Path
+			})
+		}
+	}
+	writeJSON(w, map[string]any{
+		"path":      rel,
+		"artists":   artists,
+		"tracks":    total,
+		"truncated": truncated,
+	})
+}
+
+type playbackPos struct {
+	Position float64 `json:"position"` // seconds
+	Duration float64 `json:"duration,omitempty"`
+	Updated  int64   `json:"updated"`
+}
+
+// handlePlayback stores per-user resume positions for media files, kept in
+// the share's "playback" metadata store (path -> user -> position).
+//
+//	GET    /api/playback?path=<rel>            => {path, position, duration, updated}
+//	GET    /api/playback                       => {items:[...]} (caller's positions, newest first)
+//	PUT    /api/playback?path=<rel> {position, duration}
+//	DELETE /api/playback?path=<rel>
+//
+// Anonymous callers share a single slot.
+func (s *Server) handlePlayback(w http.ResponseWriter, r *http.Request) {
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	user := auth.UserFromContext(r.Context())
+	store, err := s.metaStore(r, "playback")
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if rel == "" {
+			type item struct {
+				Path string `json:"path"`
+				playbackPos
+			}
+			items := []item{}
+			for _, k := range store.Keys("") {
+				var byUser map[string]playbackPos
+				if ok, err := store.Get(k, &byUser); err != nil || !ok {
+					continue
+				}
+				if pos, ok := byUser[user]; ok {
+					items = append(items, item{Path: k, playbackPos: pos})
+				}
+			}
+			sort.Slice(items, func(i, j int) bool { return items[i].Updated > items[j].Updated })
+			writeJSON(w, map[string]any{"items": items})
+			return
+		}
+		var byUser map[string]playbackPos
+		if _, err := store.Get(rel, &byUser); err != nil {
+			http.Error(w, "read failed", http.StatusInternalServerError)
+			return
+		}
+		pos := byUser[user]
+		writeJSON(w, map[string]any{"path": rel, "position": pos.Position, "duration": pos.Duration, "updated": pos.Updated})
+	case http.MethodPut, http.MethodPost:
+		if rel == "" {
+			http.Error(w, "missing path", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Position float64 `json:"position"`
+			Duration float64 `json:"duration,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if req.Position < 0 || req.Duration < 0 {
+			http.Error(w, "bad position", http.StatusBadRequest)
+			return
+		}
+		cfg := s.cfgForReq(r)
+		abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+		if err != nil {
+			http.Error(w, "bad path", http.StatusBadRequest)
+			return
+		}
+		if st, err := os.Stat(abs); err != nil || st.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		pos := playbackPos{Position: req.Position, Duration: req.Duration, Updated: time.Now().Unix()}
+		err = store.Update(rel, func(cur json.RawMessage) (json.RawMessage, error) {
+			byUser := map[string]playbackPos{}
+			if cur != nil {
+				_ = json.Unmarshal(cur, &byUser)
+			}
+			byUser[user] = pos
+			return json.Marshal(byUser)
+		})
+		if err != nil {
+			http.Error(w, "save failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true, "path": rel, "position": pos.Position, "duration": pos.Duration, "updated": pos.Updated})
+	case http.MethodDelete:
+		if rel == "" {
+			http.Error(w, "missing path", http.StatusBadRequest)
+			return
+		}
+		err := store.Update(rel, func(cur json.RawMessage) (json.RawMessage, error) {
+			if cur == nil {
+				return nil, nil
+			}
+			byUser := map[string]playbackPos{}
+			_ = json.Unmarshal(cur, &byUser)
+			delete(byUser, user)
+			if len(byUser) == 0 {
+				return nil, nil
+			}
+			return json.Marshal(byUser)
+		})
+		if err != nil {
+			http.Error(w, "save failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func isVideoExt(ext string) bool {
+	switch ext {
+	case ".mp4", ".m4v", ".webm", ".mkv", ".mov", ".avi":
+		return true
+	default:
+		return false
+	}
+}
+
+// requestBaseURL reconstructs the scheme://host the client used.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// handlePlaylist emits an extended M3U playlist of the audio/video files in
+// a folder so VLC/Kodi can queue it in one go.
+//
+//	GET /api/playlist?path=<rel>&recursive=1
+//
+// URLs are absolute. When the caller authenticated with a bearer token, it is
+// embedded as ?access_token= since players cannot send Authorization headers.
+func (s *Server) handlePlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	recursive := r.URL.Query().Get("recursive") == "1"
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	if st, err := os.Stat(abs); err != nil || !st.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+
+	type entry struct {
+		rel   string
+		title string
+	}
+	const maxEntries = 20_000
+	var entries []entry
+	ctx := r.Context()
+	_ = filepath.WalkDir(abs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if p != abs && (!recursive || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(d.Name()))
+		if d.Type()&os.ModeSymlink != 0 || (!isAudioExt(ext) && !isVideoExt(ext)) {
+			return nil
+		}
+		r2, err := filepath.Rel(abs, p)
+		if err != nil {
+			return nil
+		}
+		title := strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))
+		if isAudioExt(ext) {
+			if info, err := d.Info(); err == nil {
+				if t := s.audioTags(p, info); t != nil && t.Title != "" {
+					title = t.Title
+					if t.Artist != "" {
+						title = t.Artist + " - " + t.Title
+					}
+				}
+			}
+		}
+		entries = append(entries, entry{rel: joinRel(rel, filepath.ToSlash(r2)), title: title})
+		if len(entries) >= maxEntries {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return
+	}
+
+	var tokenQ string
+	if tok := requestBearerToken(r); tok != "" {
+		tokenQ = "?access_token=" + url.QueryEscape(tok)
+	}
+	base := requestBaseURL(r) + s.sharePrefix(r) + "/f/"
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, e := range entries {
+		b.WriteString("#EXTINF:-1,")
+		b.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(e.title))
+		b.WriteString("\n")
+		b.WriteString(base + escapeURLPath(e.rel) + tokenQ)
+		b.WriteString("\n")
+	}
+
+	name := path.Base("/" + rel)
+	if rel == "" {
+		name = "playlist"
+	}
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sanitizeZipBaseName(name)+".m3u8"))
+	_, _ = io.WriteString(w, b.String())
+}
+
+// escapeURLPath percent-encodes each segment of a slash-separated rel path.
+func escapeURLPath(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
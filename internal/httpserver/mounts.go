@@ -0,0 +1,283 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/fsutil"
+)
+
+// Mount health: share roots on USB drives and network mounts can vanish in
+// the middle of an event. WatchMounts checks every root in turn: it must be
+// a folder that can be read within mountCheckTimeout and, once it has been
+// seen as a mount point, still be one (an unplugged drive leaves its empty
+// mount point behind, which would pass for an empty share). Requests for
+// the files of a share whose root is down get 503 instead of empty
+// listings and failed writes; /api/info and the admin overview show which
+// shares are down. Until its first check a root counts as healthy.
+
+const mountCheckTimeout = 5 * time.Second
+
+type mountState struct {
+	mountPoint bool      // the root has been seen as a mount point
+	err        string    // why the root is down; "" when healthy
+	since      time.Time // when err last changed
+	checking   bool      // a check is running, maybe hung on a dead mount
+}
+
+// WatchMounts checks the share roots every interval until ctx is done.
+func (s *Server) WatchMounts(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		s.checkMounts()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// mountRoots returns the roots to watch: the default root, the named
+// shares' and an absolute homes dir. RAM shares live in memory.
+func (s *Server) mountRoots() []string {
+	cfg := s.config()
+	var roots []string
+	if cfg.Root != "" {
+		roots = append(roots, filepath.Clean(cfg.Root))
+	}
+	for _, sh := range cfg.Shares {
+		if sh.RAM == nil && sh.Root != "" {
+			roots = append(roots, filepath.Clean(sh.Root))
+		}
+	}
+	if cfg.Homes != nil && filepath.IsAbs(cfg.Homes.Dir) {
+		roots = append(roots, filepath.Clean(cfg.Homes.Dir))
+	}
+	return roots
+}
+
+func (s *Server) checkMounts() {
+	roots := s.mountRoots()
+	s.mountMu.Lock()
+	for root := range s.mounts {
+		if !slices.Contains(roots, root) {
+			delete(s.mounts, root)
+		}
+	}
+	s.mountMu.Unlock()
+	var wg sync.WaitGroup
+	for _, root := range roots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.checkMount(root)
+		}()
+	}
+	wg.Wait()
+}
+
+// checkMount probes root. A probe that does not return in time leaves the
+// root down until it does; no second probe is started meanwhile.
+func (s *Server) checkMount(root string) {
+	s.mountMu.Lock()
+	if s.mounts == nil {
+		s.mounts = map[string]*mountState{}
+	}
+	m := s.mounts[root]
+	if m == nil {
+		m = &mountState{since: time.Now()}
+		s.mounts[root] = m
+	}
+	if m.checking {
+		s.setMountLocked(root, m, errors.New("not responding"))
+		s.mountMu.Unlock()
+		return
+	}
+	m.checking = true
+	wasMount := m.mountPoint
+	s.mountMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mount, err := probeRoot(root, wasMount)
+		s.mountMu.Lock()
+		defer s.mountMu.Unlock()
+		m.checking = false
+		if err == nil && mount {
+			m.mountPoint = true
+		}
+		s.setMountLocked(root, m, err)
+	}()
+	timer := time.NewTimer(mountCheckTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		s.mountMu.Lock()
+		if m.checking {
+			s.setMountLocked(root, m, errors.New("not responding"))
+		}
+		s.mountMu.Unlock()
+	}
+}
+
+func (s *Server) setMountLocked(root string, m *mountState, err error) {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	if msg == m.err {
+		return
+	}
+	if msg == "" {
+		log.Printf("share root %s is available again", root)
+	} else {
+		log.Printf("share root %s is unavailable: %s", root, msg)
+	}
+	m.err, m.since = msg, time.Now()
+}
+
+// probeRoot checks that root is a readable folder and, if it was a mount
+// point, still is one. It reports whether root is a mount point now.
+func probeRoot(root string, wasMount bool) (bool, error) {
+	st, err := os.Stat(root)
+	if err != nil {
+		return false, err
+	}
+	if !st.IsDir() {
+		return false, errors.New("not a folder")
+	}
+	f, err := os.Open(root)
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Readdirnames(1)
+	f.Close()
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	mount := false
+	if pst, err := os.Stat(filepath.Dir(root)); err == nil {
+		dev, ok1 := fsutil.DeviceID(st)
+		pdev, ok2 := fsutil.DeviceID(pst)
+		mount = ok1 && ok2 && dev != pdev
+	}
+	if wasMount && !mount {
+		return false, errors.New("no longer a mount point (drive unplugged or unmounted?)")
+	}
+	return mount, nil
+}
+
+// mountDown reports why the storage of the folder root is down, and since
+// when: root or a folder above it is a watched root that failed its check.
+func (s *Server) mountDown(root string) (string, time.Time, bool) {
+	if root == "" {
+		return "", time.Time{}, false
+	}
+	root = filepath.Clean(root)
+	s.mountMu.Lock()
+	defer s.mountMu.Unlock()
+	for r, m := range s.mounts {
+		if m.err == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(r, root); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return m.err, m.since, true
+		}
+	}
+	return "", time.Time{}, false
+}
+
+// mountGuarded reports whether a request for p needs the share's files.
+// Pages, sign-in, /api/info and admin endpoints still work on a share
+// that is down.
+func mountGuarded(p string) bool {
+	switch {
+	case strings.HasPrefix(p, "/f/"), strings.HasPrefix(p, "/dav/"), strings.HasPrefix(p, "/t/"), p == "/thumb":
+		return true
+	case strings.HasPrefix(p, "/api/"):
+		return p != "/api/info" && p != "/api/session" && p != "/api/csrf" && !strings.HasPrefix(p, "/api/admin/")
+	}
+	return false
+}
+
+// guardMount answers 503 for requests that need the files of a share whose
+// storage is down. The reason stays in the log and the admin overview.
+func (s *Server) guardMount(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !mountGuarded(r.URL.Path) {
+		return true
+	}
+	if _, _, down := s.mountDown(s.shareCfg(name).Root); !down {
+		return true
+	}
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "share unavailable: its storage is not reachable (drive unplugged or network mount lost?)", http.StatusServiceUnavailable)
+	return false
+}
+
+type shareInfo struct {
+	Name      string `json:"name"` // "" = default root
+	Available bool   `json:"available"`
+	Since     int64  `json:"since,omitempty"` // unix seconds, while unavailable
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// handleInfo describes the server to clients.
+//
+//	GET /api/info -> {share, shares: [{name, available, since, readOnly}], urls: [{interface, url, linkLocal}]}
+//
+// share is the share the request went to; shares lists those the caller
+// can read. urls are the addresses the server answers on (see addrs.go).
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.config()
+	var names []string
+	if cfg.Root != "" {
+		names = append(names, "")
+	}
+	shares := make([]string, 0, len(cfg.Shares))
+	for n := range cfg.Shares {
+		shares = append(shares, n)
+	}
+	sort.Strings(shares)
+	names = append(names, shares...)
+
+	out := make([]shareInfo, 0, len(names))
+	for _, name := range names {
+		rs := r.WithContext(context.WithValue(r.Context(), shareKey, name))
+		if ok, err := s.allowed(rs, auth.PermRead, "/"); err != nil || !ok {
+			continue
+		}
+		si := shareInfo{Name: name, Available: true, ReadOnly: s.shareCfg(name).ReadOnly}
+		if _, since, down := s.mountDown(s.shareCfg(name).Root); down {
+			si.Available, si.Since = false, since.Unix()
+		}
+		out = append(out, si)
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	urls := s.ReachableURLs(scheme)
+	for i := range urls {
+		urls[i].URL += s.basePath() + "/"
+	}
+	writeJSON(w, map[string]any{"share": shareFromContext(r.Context()), "shares": out, "urls": urls})
+}
@@ -0,0 +1,263 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+	"lanparty/internal/fsutil"
+)
+
+// Notifications (config.Notify): short messages about finished uploads, a
+// nearly full disk and failed logins, sent to Discord webhooks, Matrix rooms
+// or ntfy topics. Sending happens in the background and failures are only
+// logged. Repeats are held back: a full disk is reported once every few
+// hours per share, failed logins at most every ten minutes per client
+// address, with a count.
+
+const (
+	notifyUpload      = "upload"
+	notifyDiskFull    = "diskFull"
+	notifyLoginFailed = "loginFailed"
+
+	defaultDiskPercent = 90
+	notifyTimeout      = 10 * time.Second
+	// maxNotifySends bounds concurrent sends; more wait their turn.
+	maxNotifySends   = 4
+	diskNotifyEvery  = 6 * time.Hour
+	loginNotifyEvery = 10 * time.Minute
+)
+
+func checkNotify(ns []config.Notifier) error {
+	for i, n := range ns {
+		if err := checkNotifier(n); err != nil {
+			return fmt.Errorf("%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func checkNotifier(n config.Notifier) error {
+	switch n.Kind {
+	case "discord", "ntfy":
+	case "matrix":
+		if n.Room == "" || n.Token == "" {
+			return errors.New("matrix needs room and token")
+		}
+	default:
+		return fmt.Errorf("unknown kind %q (want discord, matrix or ntfy)", n.Kind)
+	}
+	u, err := url.Parse(n.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http(s) URL")
+	}
+	for _, e := range n.Events {
+		if e != notifyUpload && e != notifyDiskFull && e != notifyLoginFailed {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	if n.DiskPercent < 0 || n.DiskPercent > 100 {
+		return errors.New("diskPercent must be 0-100")
+	}
+	return nil
+}
+
+func notifierWants(n config.Notifier, event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyOnce reports whether key was not notified about within every, and
+// marks it notified now if so.
+func (s *Server) notifyOnce(key string, every time.Duration) bool {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+	now := time.Now()
+	if last, ok := s.notified[key]; ok && now.Sub(last) < every {
+		return false
+	}
+	s.notified[key] = now
+	return true
+}
+
+// notifyUploaded reports a finished upload of rel, and a disk that filled
+// up with it.
+func (s *Server) notifyUploaded(r *http.Request, rel string) {
+	ns := s.config().Notify
+	if len(ns) == 0 || rel == "" {
+		return
+	}
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		return
+	}
+	share := shareFromContext(r.Context())
+	who := auth.UserFromContext(r.Context())
+	if who == "" {
+		who = "someone at " + s.remoteHost(r)
+	}
+	msg := fmt.Sprintf("%s uploaded /%s (%s)%s", who, rel, notifySize(st.Size()), onShare(share))
+	var usedPct int
+	if free, total, err := fsutil.DiskFree(cfg.Root); err == nil && total > 0 {
+		usedPct = int(100 - free*100/total)
+	}
+	for i, n := range ns {
+		if notifierWants(n, notifyUpload) && underAny("/"+rel, n.Paths) {
+			s.sendNotify(n, "Upload finished", msg)
+		}
+		limit := n.DiskPercent
+		if limit == 0 {
+			limit = defaultDiskPercent
+		}
+		if notifierWants(n, notifyDiskFull) && usedPct >= limit &&
+			s.notifyOnce(fmt.Sprintf("disk\x00%s\x00%d", share, i), diskNotifyEvery) {
+			s.sendNotify(n, "Disk nearly full", fmt.Sprintf("The disk%s is %d%% full", onShare(share), usedPct))
+		}
+	}
+}
+
+// notifyLoginFailed reports a failed login as user.
+func (s *Server) notifyLoginFailed(r *http.Request, user string) {
+	ns := s.config().Notify
+	if len(ns) == 0 {
+		return
+	}
+	addr := s.remoteHost(r)
+	s.notifyMu.Lock()
+	s.loginFails[addr]++
+	s.notifyMu.Unlock()
+	if !s.notifyOnce("login\x00"+addr, loginNotifyEvery) {
+		return
+	}
+	s.notifyMu.Lock()
+	count := s.loginFails[addr]
+	delete(s.loginFails, addr)
+	s.notifyMu.Unlock()
+	msg := fmt.Sprintf("Failed login as %q from %s", user, addr)
+	if count > 1 {
+		msg = fmt.Sprintf("%d failed logins from %s, the last as %q", count, addr, user)
+	}
+	for _, n := range ns {
+		if notifierWants(n, notifyLoginFailed) {
+			s.sendNotify(n, "Failed login", msg)
+		}
+	}
+}
+
+// sendNotify sends one message in the background.
+func (s *Server) sendNotify(n config.Notifier, title, msg string) {
+	go func() {
+		s.notifySends <- struct{}{}
+		defer func() { <-s.notifySends }()
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		if err := postNotify(ctx, n, title, msg); err != nil {
+			log.Printf("notify %s: %v", n.Kind, err)
+		}
+	}()
+}
+
+func postNotify(ctx context.Context, n config.Notifier, title, msg string) error {
+	var req *http.Request
+	var err error
+	switch n.Kind {
+	case "discord":
+		b, _ := json.Marshal(map[string]string{"content": "**" + title + "**: " + msg})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(b))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case "matrix":
+		b, _ := json.Marshal(map[string]string{"msgtype": "m.text", "body": title + ": " + msg})
+		txn := strconv.FormatInt(time.Now().UnixNano(), 36)
+		u := strings.TrimSuffix(n.URL, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(n.Room) + "/send/m.room.message/" + txn
+		req, err = http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(b))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+n.Token)
+		}
+	case "ntfy":
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, n.URL, strings.NewReader(msg))
+		if err == nil {
+			req.Header.Set("Title", title)
+			if n.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+n.Token)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown kind %q", n.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// underAny reports whether p is one of dirs or below one; no dirs means
+// everywhere.
+func underAny(p string, dirs []string) bool {
+	if len(dirs) == 0 {
+		return true
+	}
+	for _, d := range dirs {
+		d = path.Clean("/" + d)
+		if d == "/" || p == d || strings.HasPrefix(p, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func onShare(share string) string {
+	if share == "" {
+		return ""
+	}
+	return " on share " + share
+}
+
+func notifySize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,499 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"lanparty/internal/config"
+)
+
+// OIDC sign-in (config.OIDC): the sign-in form offers "Sign in with SSO",
+// which runs the authorization code flow with PKCE against the provider
+// and ends in an ordinary session (session.go) for the user the provider
+// names. The groups it reports go into the session, where ACL entries
+// like "@admins" find them. The flow's state, nonce and PKCE verifier wait
+// in a short-lived cookie signed like sessions, under their own label.
+//
+// The ID token comes straight from the provider's token endpoint over TLS
+// on a request lanparty makes itself, so its signature need not be checked
+// (OpenID Connect Core 3.1.3.7); its issuer, audience, expiry and nonce
+// are.
+
+const (
+	oidcCookie       = "lanparty_oidc"
+	oidcFlowTTL      = 10 * time.Minute
+	oidcTimeout      = 10 * time.Second
+	oidcDiscoveryTTL = time.Hour
+	oidcMaxResponse  = 1 << 20
+	oidcClockSkew    = time.Minute
+)
+
+// oidcProvider is the part of a provider's discovery document lanparty
+// uses.
+type oidcProvider struct {
+	Issuer      string `json:"issuer"`
+	AuthURL     string `json:"authorization_endpoint"`
+	TokenURL    string `json:"token_endpoint"`
+	UserinfoURL string `json:"userinfo_endpoint"`
+
+	fetched time.Time
+}
+
+// oidcFlow is what the flow cookie carries from /login/oidc to the
+// callback.
+type oidcFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next,omitempty"`
+	Exp      int64  `json:"exp"` // unix seconds
+}
+
+func checkOIDC(o *config.OIDC) error {
+	if o == nil {
+		return nil
+	}
+	if err := checkOIDCURL(o.Issuer); err != nil {
+		return fmt.Errorf("issuer: %w", err)
+	}
+	if o.ClientID == "" || o.ClientSecret == "" {
+		return errors.New("clientId and clientSecret are required")
+	}
+	if o.RedirectURL != "" {
+		u, err := url.Parse(o.RedirectURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("redirectUrl must be an http(s) URL")
+		}
+	}
+	for from, to := range o.Groups {
+		if from == "" || to == "" {
+			return errors.New("groups: names cannot be empty")
+		}
+	}
+	return nil
+}
+
+// checkOIDCURL accepts https URLs, and plain http ones on this machine
+// only: the ID token is trusted because of TLS.
+func checkOIDCURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if ip := net.ParseIP(u.Hostname()); u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return errors.New("must be an https URL")
+}
+
+// oidcDiscover returns the provider's endpoints, fetching them at most
+// once an hour.
+func (s *Server) oidcDiscover(ctx context.Context, o *config.OIDC) (oidcProvider, error) {
+	issuer := strings.TrimSuffix(o.Issuer, "/")
+	s.oidcMu.Lock()
+	p, ok := s.oidcProviders[issuer]
+	s.oidcMu.Unlock()
+	if ok && time.Since(p.fetched) < oidcDiscoveryTTL {
+		return p, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return oidcProvider{}, err
+	}
+	if err := oidcDo(req, &p); err != nil {
+		return oidcProvider{}, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return oidcProvider{}, fmt.Errorf("discovery: issuer is %q", p.Issuer)
+	}
+	for _, e := range []string{p.AuthURL, p.TokenURL} {
+		if err := checkOIDCURL(e); err != nil {
+			return oidcProvider{}, fmt.Errorf("discovery: endpoint %q %v", e, err)
+		}
+	}
+	if p.UserinfoURL != "" && checkOIDCURL(p.UserinfoURL) != nil {
+		p.UserinfoURL = ""
+	}
+	p.fetched = time.Now()
+	s.oidcMu.Lock()
+	if s.oidcProviders == nil {
+		s.oidcProviders = map[string]oidcProvider{}
+	}
+	s.oidcProviders[issuer] = p
+	s.oidcMu.Unlock()
+	return p, nil
+}
+
+// oidcDo sends req and decodes the JSON answer into v.
+func oidcDo(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponse))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+			Desc  string `json:"error_description"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s: %s %s", resp.Status, e.Error, e.Desc)
+		}
+		return errors.New(resp.Status)
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *Server) oidcSig(payload string) string {
+	m := hmac.New(sha256.New, s.signKey())
+	m.Write([]byte("oidc\x00" + payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (s *Server) oidcCookiePath() string {
+	return s.basePath() + "/login/oidc"
+}
+
+// oidcRedirectURL is where the provider sends the browser back to.
+func (s *Server) oidcRedirectURL(r *http.Request, o *config.OIDC) string {
+	if o.RedirectURL != "" {
+		return o.RedirectURL
+	}
+	return requestBaseURL(r) + s.basePath() + "/login/oidc/callback"
+}
+
+func oidcRandom() string {
+	var b [24]byte
+	_, _ = rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// handleOIDCLogin sends the browser to the provider.
+//
+//	GET /login/oidc[?next=/path] -> redirect to the provider's sign-in
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	o := s.config().OIDC
+	if o == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), oidcTimeout)
+	defer cancel()
+	p, err := s.oidcDiscover(ctx, o)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		http.Error(w, "sign-in provider unavailable", http.StatusBadGateway)
+		return
+	}
+	flow := oidcFlow{
+		State: oidcRandom(), Nonce: oidcRandom(), Verifier: oidcRandom() + oidcRandom(),
+		Next: r.URL.Query().Get("next"), Exp: time.Now().Add(oidcFlowTTL).Unix(),
+	}
+	b, _ := json.Marshal(flow)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    payload + "." + s.oidcSig(payload),
+		Path:     s.oidcCookiePath(),
+		MaxAge:   int(oidcFlowTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	extra := o.Scopes
+	if len(extra) == 0 {
+		extra = []string{"profile", "email", "groups"}
+	}
+	scopes := []string{"openid"}
+	for _, v := range extra {
+		if v != "openid" {
+			scopes = append(scopes, v)
+		}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.ClientID},
+		"redirect_uri":          {s.oidcRedirectURL(r, o)},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthURL+sep+q.Encode(), http.StatusFound)
+}
+
+// oidcFlowFrom returns the flow of the request's flow cookie if its
+// signature holds and it has not expired.
+func (s *Server) oidcFlowFrom(r *http.Request) (oidcFlow, bool) {
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return oidcFlow{}, false
+	}
+	payload, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.oidcSig(payload))) {
+		return oidcFlow{}, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return oidcFlow{}, false
+	}
+	var flow oidcFlow
+	if json.Unmarshal(b, &flow) != nil || time.Now().Unix() > flow.Exp {
+		return oidcFlow{}, false
+	}
+	return flow, true
+}
+
+// handleOIDCCallback finishes a provider sign-in.
+//
+//	GET /login/oidc/callback?code=&state= -> cookie + redirect to next
+//
+// Failures go back to the sign-in form with ?error=sso, or ?error=denied
+// when allowedGroups turns the user away.
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	o := s.config().OIDC
+	if o == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Value: "", Path: s.oidcCookiePath(), MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	fail := func(why string, err error) {
+		log.Printf("oidc: sign-in from %s failed: %v", s.remoteHost(r), err)
+		http.Redirect(w, r, s.basePath()+"/login?"+url.Values{"error": {why}}.Encode(), http.StatusSeeOther)
+	}
+	q := r.URL.Query()
+	flow, ok := s.oidcFlowFrom(r)
+	if !ok {
+		fail("sso", errors.New("missing or expired flow cookie"))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(flow.State)) != 1 {
+		fail("sso", errors.New("state mismatch"))
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		fail("sso", fmt.Errorf("provider: %s %s", e, q.Get("error_description")))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), oidcTimeout)
+	defer cancel()
+	user, groups, err := s.oidcExchange(ctx, r, o, flow, q.Get("code"))
+	if err != nil {
+		fail("sso", err)
+		return
+	}
+	if len(o.AllowedGroups) > 0 && !slices.ContainsFunc(o.AllowedGroups, func(g string) bool { return slices.Contains(groups, g) }) {
+		s.notifyLoginFailed(r, user)
+		fail("denied", fmt.Errorf("%q is in none of allowedGroups", user))
+		return
+	}
+	if _, err := s.startSession(w, r, session{User: user, Groups: groups, Via: "oidc"}); err != nil {
+		http.Error(w, "session failed", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, s.localNext(r, flow.Next), http.StatusSeeOther)
+}
+
+// oidcExchange trades the code for an ID token and returns the user and
+// (renamed) groups it names. Claims the ID token leaves out are looked up
+// at the userinfo endpoint.
+func (s *Server) oidcExchange(ctx context.Context, r *http.Request, o *config.OIDC, flow oidcFlow, code string) (string, []string, error) {
+	p, err := s.oidcDiscover(ctx, o)
+	if err != nil {
+		return "", nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.oidcRedirectURL(r, o)},
+		"code_verifier": {flow.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	var tok struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := oidcDo(req, &tok); err != nil {
+		return "", nil, fmt.Errorf("token: %w", err)
+	}
+	claims, err := oidcIDClaims(tok.IDToken)
+	if err != nil {
+		return "", nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return "", nil, fmt.Errorf("id token issuer is %q", iss)
+	}
+	var aud []string
+	switch v := claims["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			if a, ok := a.(string); ok {
+				aud = append(aud, a)
+			}
+		}
+	}
+	if !slices.Contains(aud, o.ClientID) {
+		return "", nil, errors.New("id token is not for this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != o.ClientID {
+		return "", nil, errors.New("id token is for another party")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Add(-oidcClockSkew).Unix() > int64(exp) {
+		return "", nil, errors.New("id token expired")
+	}
+	if nonce, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(nonce), []byte(flow.Nonce)) != 1 {
+		return "", nil, errors.New("nonce mismatch")
+	}
+
+	userClaim, groupsClaim := o.UserClaim, o.GroupsClaim
+	if userClaim == "" {
+		userClaim = "preferred_username"
+	}
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	_, hasUser := claims[userClaim]
+	_, hasGroups := claims[groupsClaim]
+	if (!hasUser || !hasGroups) && p.UserinfoURL != "" && tok.AccessToken != "" {
+		if info, err := oidcUserinfo(ctx, p.UserinfoURL, tok.AccessToken); err != nil {
+			log.Printf("oidc: userinfo: %v", err)
+		} else if info["sub"] == claims["sub"] {
+			for k, v := range info {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+	user, _ := claims[userClaim].(string)
+	user = strings.TrimSpace(user)
+	if user == "" || strings.ContainsAny(user, ":/\\\x00") {
+		return "", nil, fmt.Errorf("claim %q is not a usable user name: %q", userClaim, user)
+	}
+	if s.config().Homes != nil && !validHomeName(user) {
+		return "", nil, fmt.Errorf("claim %q is not a usable user name: %q", userClaim, user)
+	}
+	var groups []string
+	add := func(g string) {
+		if to, ok := o.Groups[g]; ok {
+			g = to
+		}
+		if g != "" && !slices.Contains(groups, g) {
+			groups = append(groups, g)
+		}
+	}
+	switch v := claims[groupsClaim].(type) {
+	case string:
+		add(v)
+	case []any:
+		for _, g := range v {
+			if g, ok := g.(string); ok {
+				add(g)
+			}
+		}
+	}
+	return user, groups, nil
+}
+
+// oidcIDClaims returns the claims of a JWT without checking its signature;
+// see the comment at the top.
+func oidcIDClaims(idToken string) (map[string]any, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("no usable id token")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("no usable id token")
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, errors.New("no usable id token")
+	}
+	return claims, nil
+}
+
+func oidcUserinfo(ctx context.Context, endpoint, accessToken string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	var info map[string]any
+	if err := oidcDo(req, &info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// markOIDCHTML flags the sign-in form when OIDC is configured, which shows
+// the "Sign in with SSO" button; "only" hides the password fields when
+// there are neither local nor LDAP users.
+func markOIDCHTML(b []byte, cfg *config.Config) []byte {
+	if cfg.OIDC == nil {
+		return b
+	}
+	mark := "1"
+	if len(cfg.Users) == 0 && cfg.LDAP == nil {
+		mark = "only"
+	}
+	const bodyTag = "<body"
+	idx := bytes.Index(b, []byte(bodyTag))
+	if idx < 0 {
+		return b
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(b) + 20)
+	buf.Write(b[:idx+len(bodyTag)])
+	buf.WriteString(` data-oidc="` + mark + `"`)
+	buf.Write(b[idx+len(bodyTag):])
+	return buf.Bytes()
+}
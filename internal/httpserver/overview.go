@@ -0,0 +1,221 @@
+package httpserver
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"lanparty/internal/fsutil"
+)
+
+// shareActivity tracks requests per share for the admin overview.
+type shareActivity struct {
+	inflight int
+	last     time.Time
+}
+
+// trackActivity marks a request to share as in flight until the returned
+// func is called.
+func (s *Server) trackActivity(share string) func() {
+	s.actMu.Lock()
+	a := s.activity[share]
+	if a == nil {
+		a = &shareActivity{}
+		s.activity[share] = a
+	}
+	a.inflight++
+	a.last = time.Now()
+	s.actMu.Unlock()
+	return func() {
+		s.actMu.Lock()
+		a.inflight--
+		a.last = time.Now()
+		s.actMu.Unlock()
+	}
+}
+
+// treeUsage is the cached result of walking a directory tree.
+type treeUsage struct {
+	files   int64
+	bytes   int64
+	scanned time.Time
+}
+
+// overviewCacheTTL bounds how often a share tree is re-walked for the overview.
+const overviewCacheTTL = time.Minute
+
+// dirUsage counts regular files and bytes below root, skipping skip (the
+// state dir when it lives inside the share). Results are cached briefly
+// since shares can be large.
+func (s *Server) dirUsage(ctx context.Context, root, skip string) (treeUsage, error) {
+	key := root + "\x00" + skip
+	s.actMu.Lock()
+	u, ok := s.usageCache[key]
+	s.actMu.Unlock()
+	if ok && time.Since(u.scanned) < overviewCacheTTL {
+		return u, nil
+	}
+	u = treeUsage{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if skip != "" && p == skip {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			u.files++
+			u.bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return treeUsage{}, err
+	}
+	u.scanned = time.Now()
+	s.actMu.Lock()
+	s.usageCache[key] = u
+	s.actMu.Unlock()
+	return u, nil
+}
+
+type shareOverview struct {
+	Name       string `json:"name"` // "" = default root
+	Root       string `json:"root"`
+	Encrypted  bool   `json:"encrypted,omitempty"`
+	Locked     bool   `json:"locked,omitempty"`
+	RAM        bool   `json:"ram,omitempty"`
+	Files      int64  `json:"files"`
+	Bytes      int64  `json:"bytes"`
+	StateBytes int64  `json:"stateBytes"`
+	CacheFiles int    `json:"cacheFiles,omitempty"` // read-through cache
+	CacheBytes int64  `json:"cacheBytes,omitempty"`
+	DiskFree   uint64 `json:"diskFree"`
+	DiskTotal  uint64 `json:"diskTotal"`
+	Uploads    int    `json:"uploads"`  // pending resumable upload sessions
+	Inflight   int    `json:"inflight"` // requests in progress
+	LastActive int64  `json:"lastActive,omitempty"`
+	ScannedAt  int64  `json:"scannedAt"`
+	Error      string `json:"error,omitempty"`
+	// Unavailable says why the share's storage is down (see mounts.go),
+	// since UnavailableSince.
+	Unavailable      string `json:"unavailable,omitempty"`
+	UnavailableSince int64  `json:"unavailableSince,omitempty"`
+}
+
+// handleAdminOverview reports usage and activity per share.
+//
+//	GET /api/admin/overview -> {shares:[...]}
+//
+// On the default root it covers every share; under /s/<name>/ only that one.
+// Tree sizes are cached for a minute.
+func (s *Server) handleAdminOverview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.adminOnly(w, r) {
+		return
+	}
+	var names []string
+	if name := shareFromContext(r.Context()); name != "" {
+		names = []string{name}
+	} else {
+		cfg := s.config()
+		if cfg.Root != "" {
+			names = append(names, "")
+		}
+		shares := make([]string, 0, len(cfg.Shares))
+		for n := range cfg.Shares {
+			shares = append(shares, n)
+		}
+		sort.Strings(shares)
+		names = append(names, shares...)
+	}
+
+	out := make([]shareOverview, 0, len(names))
+	for _, name := range names {
+		if r.Context().Err() != nil {
+			return
+		}
+		out = append(out, s.shareOverview(r.Context(), name))
+	}
+	resp := map[string]any{"shares": out, "limits": s.limitStats()}
+	if t := s.config().Throttle; t != nil {
+		rates, window := throttleRates(t, time.Now())
+		resp["throttle"] = map[string]any{"window": window, "downKiBps": rates.DownKiBps, "upKiBps": rates.UpKiBps}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) shareOverview(ctx context.Context, name string) shareOverview {
+	cfg := s.shareCfg(name)
+	ov := shareOverview{Name: name, Root: cfg.Root, Encrypted: s.isEncryptedShare(name)}
+	if ov.Encrypted {
+		s.mu.Lock()
+		ov.Locked = s.shareKeys[name] == nil
+		s.mu.Unlock()
+	}
+	if name != "" {
+		ov.RAM = s.config().Shares[name].RAM != nil
+	}
+	s.mu.Lock()
+	rc := s.rcaches[name]
+	s.mu.Unlock()
+	ov.CacheFiles, ov.CacheBytes = rc.Stats()
+	if why, since, down := s.mountDown(cfg.Root); down {
+		// Walking a dead network mount could hang the overview.
+		ov.Unavailable, ov.UnavailableSince = why, since.Unix()
+		s.actMu.Lock()
+		if a := s.activity[name]; a != nil {
+			ov.Inflight = a.inflight
+			ov.LastActive = a.last.Unix()
+		}
+		s.actMu.Unlock()
+		return ov
+	}
+
+	skip := ""
+	if rel, err := filepath.Rel(cfg.Root, cfg.StateDir); err == nil && !strings.HasPrefix(rel, "..") {
+		skip = cfg.StateDir
+	}
+	if u, err := s.dirUsage(ctx, cfg.Root, skip); err == nil {
+		ov.Files, ov.Bytes, ov.ScannedAt = u.files, u.bytes, u.scanned.Unix()
+	} else {
+		ov.Error = err.Error()
+	}
+	if u, err := s.dirUsage(ctx, cfg.StateDir, ""); err == nil {
+		ov.StateBytes = u.bytes
+	}
+	if free, total, err := fsutil.DiskFree(cfg.Root); err == nil {
+		ov.DiskFree, ov.DiskTotal = free, total
+	}
+	if ents, err := os.ReadDir(spoolDirFor(cfg, name)); err == nil {
+		for _, e := range ents {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+				ov.Uploads++
+			}
+		}
+	}
+	s.actMu.Lock()
+	if a := s.activity[name]; a != nil {
+		ov.Inflight = a.inflight
+		ov.LastActive = a.last.Unix()
+	}
+	s.actMu.Unlock()
+	return ov
+}
@@ -0,0 +1,114 @@
+package httpserver
+
+import (
+	"net/http"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/meta"
+)
+
+// Owners: every file placed by an upload (multipart, resumable, WebDAV PUT,
+// /api/write, precheck links) records who sent it in the share's "owners"
+// meta store, keyed by path; a later upload over it takes it over. Records
+// follow renames and go away with their files. Listings show the owner,
+// and where an ACL sets ownersManage, owners may rename and delete their
+// own files without write or admin rights there.
+
+type owner struct {
+	User string `json:"user"`
+	Time int64  `json:"time"` // unix seconds
+}
+
+// recordOwner notes that the request's user just uploaded rel. Anonymous
+// uploads clear any previous owner.
+func (s *Server) recordOwner(r *http.Request, rel string) {
+	store, err := s.metaStore(r, "owners")
+	if err != nil || rel == "" {
+		return
+	}
+	if user := auth.UserFromContext(r.Context()); user != "" {
+		_ = store.Put(rel, owner{User: user, Time: time.Now().Unix()})
+	} else {
+		_ = store.Delete(rel)
+	}
+}
+
+// moveOwners keeps owner records with their files when rel is renamed to
+// to, and drops them when it is deleted (to == ""). Whatever was recorded
+// at to belonged to what the rename replaced.
+func (s *Server) moveOwners(r *http.Request, rel, to string) {
+	if rel == "" {
+		return
+	}
+	store, err := s.metaStore(r, "owners")
+	if err != nil {
+		return
+	}
+	if to != "" {
+		_ = store.DeleteTree(to)
+		_ = store.MoveTree(rel, to)
+	} else {
+		_ = store.DeleteTree(rel)
+	}
+}
+
+// owners answers owner lookups for one listing; a zero value knows of none.
+type owners struct{ store *meta.Store }
+
+func (s *Server) owners(r *http.Request) owners {
+	store, err := s.metaStore(r, "owners")
+	if err != nil {
+		return owners{}
+	}
+	return owners{store: store}
+}
+
+func (o owners) of(rel string) string {
+	if o.store == nil || rel == "" {
+		return ""
+	}
+	var rec owner
+	if ok, err := o.store.Get(rel, &rec); err != nil || !ok {
+		return ""
+	}
+	return rec.User
+}
+
+func (o owners) annotate(it *listItem) {
+	if !it.IsDir {
+		it.Owner = o.of(it.Path)
+	}
+}
+
+// ownersManageAt reports whether the ACL deciding rel lets owners manage
+// their files there, for a signed-in user who can see it and whom no deny
+// list or policy keeps from writing there.
+func (s *Server) ownersManageAt(r *http.Request, rel string) bool {
+	if rel == "" || auth.UserFromContext(r.Context()) == "" {
+		return false
+	}
+	if _, ok := davUserFromContext(r.Context()); ok {
+		return false
+	}
+	if share := shareFromContext(r.Context()); share != "" {
+		if _, _, snap := snapshotOf(share); snap {
+			return false
+		}
+	}
+	cfg := s.cfgForReq(r)
+	if cfg.ReadOnly {
+		return false
+	}
+	if ok, err := auth.OwnerAllowed(cfg, s.accessRequest(r, auth.PermWrite, "/"+rel), s.policy); err != nil || !ok {
+		return false
+	}
+	ok, err := s.allowed(r, auth.PermRead, "/"+rel)
+	return err == nil && ok
+}
+
+// ownsManaged reports whether the request's user uploaded rel and may
+// manage it under ownersManage.
+func (s *Server) ownsManaged(r *http.Request, rel string) bool {
+	return s.ownersManageAt(r, rel) && s.owners(r).of(rel) == auth.UserFromContext(r.Context())
+}
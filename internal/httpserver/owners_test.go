@@ -0,0 +1,61 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+// refuseWrites is a policy that refuses every write.
+type refuseWrites struct{}
+
+func (refuseWrites) Decide(req auth.Request, acl bool) (bool, error) {
+	return acl && req.Perm != auth.PermWrite, nil
+}
+
+// ownersManage lets bob rename the files bob uploaded, but not where a deny
+// list or the policy keeps bob from writing.
+func TestOwnersManageDenied(t *testing.T) {
+	s := testServer(t, func(cfg *config.Config) {
+		cfg.ACLs = append(cfg.ACLs,
+			config.ACL{Path: "/drop", Read: []string{"*"}, Write: []string{"alice"}, OwnersManage: true},
+			config.ACL{Path: "/drop/locked", DenyWrite: []string{"bob"}},
+		)
+	})
+	root := s.config().Root
+	owners, err := s.metaStore(httptest.NewRequest(http.MethodGet, "/", nil), "owners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"drop/a.txt", "drop/locked/b.txt"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, rel)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, rel), []byte("bob's"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := owners.Put(rel, owner{User: "bob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.Handler()
+	rename := func(from, to string) int {
+		return serveAs(h, httptest.NewRequest(http.MethodPost, "/api/rename", strings.NewReader(`{"from":"`+from+`","to":"`+to+`"}`)), "bob").Code
+	}
+	if code := rename("drop/a.txt", "drop/a2.txt"); code != http.StatusOK {
+		t.Fatalf("owner rename: %d", code)
+	}
+	if code := rename("drop/locked/b.txt", "drop/locked/b2.txt"); code != http.StatusForbidden {
+		t.Fatalf("owner rename under denyWrite: %d", code)
+	}
+	s.policy = refuseWrites{}
+	if code := rename("drop/a2.txt", "drop/a3.txt"); code != http.StatusForbidden {
+		t.Fatalf("owner rename refused by the policy: %d", code)
+	}
+}
@@ -0,0 +1,192 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"lanparty/internal/config"
+	"lanparty/internal/fsutil"
+)
+
+// Preview plugins: commands from config.Previewers that turn a file of a
+// type lanparty cannot read into an image or an HTML page. Thumbnails use
+// the image; /api/preview serves either to the preview pane.
+
+const (
+	defaultPreviewTimeout = 20 * time.Second
+	// maxPreviewOutput caps what a plugin may write to stdout.
+	maxPreviewOutput = 32 << 20
+)
+
+var errPreviewTooBig = errors.New("preview output too large")
+
+func checkPreviewers(ps []config.Previewer) error {
+	for i, p := range ps {
+		if len(p.Command) == 0 || strings.TrimSpace(p.Command[0]) == "" {
+			return fmt.Errorf("previewer %d: missing command", i)
+		}
+		if len(p.Exts) == 0 {
+			return fmt.Errorf("previewer %d: missing exts", i)
+		}
+		for _, e := range p.Exts {
+			if !strings.HasPrefix(e, ".") || e != strings.ToLower(e) {
+				return fmt.Errorf("previewer %d: ext %q must be lowercase and start with a dot", i, e)
+			}
+		}
+	}
+	return nil
+}
+
+// builtinPreview reports whether lanparty previews ext itself; plugins are
+// only asked about everything else.
+func builtinPreview(ext string) bool {
+	return isImageExt(ext) || ext == ".zip" || ext == ".pdf" || isTextExt(ext) || isAudioExt(ext) || isVideoExt(ext)
+}
+
+// previewerFor returns the first previewer handling ext, or nil.
+func (s *Server) previewerFor(ext string) *config.Previewer {
+	if ext == "" || builtinPreview(ext) {
+		return nil
+	}
+	ps := s.config().Previewers
+	for i := range ps {
+		for _, e := range ps[i].Exts {
+			if e == ext {
+				return &ps[i]
+			}
+		}
+	}
+	return nil
+}
+
+// limitedBuffer fails writes past max instead of growing without bound.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errPreviewTooBig
+	}
+	return b.Buffer.Write(p)
+}
+
+// runPreviewer runs pv for abs and returns its output and content type,
+// which is an image type or text/html.
+func runPreviewer(ctx context.Context, pv *config.Previewer, abs string, size int) ([]byte, string, error) {
+	timeout := defaultPreviewTimeout
+	if pv.TimeoutSeconds > 0 {
+		timeout = time.Duration(pv.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := make([]string, len(pv.Command))
+	for i, a := range pv.Command {
+		a = strings.ReplaceAll(a, "{path}", abs)
+		args[i] = strings.ReplaceAll(a, "{size}", strconv.Itoa(size))
+	}
+	out := &limitedBuffer{max: maxPreviewOutput}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = out
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		return nil, "", err
+	}
+	b := out.Bytes()
+	ctype := http.DetectContentType(b)
+	switch {
+	case strings.HasPrefix(ctype, "image/png"), strings.HasPrefix(ctype, "image/jpeg"),
+		strings.HasPrefix(ctype, "image/gif"), strings.HasPrefix(ctype, "image/webp"):
+	case strings.HasPrefix(ctype, "text/html"):
+		ctype = "text/html; charset=utf-8"
+	default:
+		return nil, "", fmt.Errorf("previewer wrote %s, want an image or HTML", ctype)
+	}
+	return b, ctype, nil
+}
+
+// makePluginThumb renders a thumbnail from a previewer's image output.
+func makePluginThumb(ctx context.Context, pv *config.Previewer, abs string, max int) ([]byte, error) {
+	b, ctype, err := runPreviewer(ctx, pv, abs, max)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(ctype, "image/") {
+		return nil, fmt.Errorf("previewer wrote %s, want an image", ctype)
+	}
+	src, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return scaleThumb(src, max)
+}
+
+// handlePreview serves a previewer's output for a file.
+//
+//	GET /api/preview?path=<rel>[&s=<px>]
+//
+// s is passed to the command as {size} (default 0, "as large as useful").
+// HTML runs sandboxed in an opaque origin: its scripts may run but cannot
+// reach lanparty with the viewer's credentials.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	rel := fsutil.CleanRelPath(q.Get("path"))
+	size := 0
+	if v := strings.TrimSpace(q.Get("s")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "bad s", http.StatusBadRequest)
+			return
+		}
+		size = min(n, 4096)
+	}
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil || st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	pv := s.previewerFor(strings.ToLower(filepath.Ext(abs)))
+	if pv == nil {
+		http.Error(w, "no previewer for this file type", http.StatusNotFound)
+		return
+	}
+	release, ok := s.limit(w, r, limitMedia)
+	if !ok {
+		return
+	}
+	defer release()
+	b, ctype, err := runPreviewer(r.Context(), pv, abs, size)
+	if err != nil {
+		http.Error(w, "preview failed", http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", ctype)
+	// The preview pane shows this in an iframe.
+	w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	if strings.HasPrefix(ctype, "text/html") {
+		w.Header().Set("Content-Security-Policy", "sandbox allow-scripts; frame-ancestors 'self'")
+	}
+	_, _ = w.Write(b)
+}
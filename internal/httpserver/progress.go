@@ -0,0 +1,283 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"lanparty/internal/auth"
+)
+
+// Upload progress as the server sees it, for watching a transfer from
+// another device or the admin page. Resumable uploads are tracked under
+// their session id; multipart uploads only when the client names them
+// with an X-Upload-Id header. Bytes are counted as they are read off the
+// wire, so progress moves within a chunk, and the rate is taken over the
+// last few seconds.
+
+const (
+	uploadIDHeader = "X-Upload-Id"
+	// rateWindow is how far back the transfer rate looks.
+	rateWindow = 10 * time.Second
+	// progressKeep is how long finished or stalled transfers stay visible.
+	progressKeep = time.Minute
+	// progressIdle drops resumable uploads nobody has sent to in a while;
+	// the session itself still answers with its offset.
+	progressIdle = 10 * time.Minute
+)
+
+var clientUploadIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type rateSample struct {
+	t time.Time
+	n int64
+}
+
+type transfer struct {
+	id       string
+	share    string
+	path     string // destination file, or folder for multipart
+	kind     string // "resumable" or "multipart"
+	user     string
+	received int64
+	total    int64 // -1 if unknown
+	started  time.Time
+	updated  time.Time
+	active   bool // a request is sending right now
+	done     bool
+	samples  []rateSample
+}
+
+// transferView is a transfer in API responses.
+type transferView struct {
+	ID       string  `json:"id"`
+	Share    string  `json:"share,omitempty"`
+	Path     string  `json:"path"`
+	Kind     string  `json:"kind"`
+	User     string  `json:"user,omitempty"`
+	Received int64   `json:"received"`
+	Total    int64   `json:"total"`
+	Rate     float64 `json:"rate"`          // bytes/s over the last rateWindow
+	ETA      float64 `json:"eta,omitempty"` // seconds; 0 when unknown
+	Active   bool    `json:"active"`
+	Done     bool    `json:"done,omitempty"`
+	Started  int64   `json:"started"`
+	Updated  int64   `json:"updated"`
+}
+
+type uploadProgress struct {
+	mu   sync.Mutex
+	byID map[string]*transfer // by share + "\x00" + id
+}
+
+func newUploadProgress() *uploadProgress {
+	return &uploadProgress{byID: map[string]*transfer{}}
+}
+
+func progressKey(share, id string) string { return share + "\x00" + id }
+
+// begin marks a transfer as sending from offset received on.
+func (p *uploadProgress) begin(t transfer) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sweepLocked(now)
+	k := progressKey(t.share, t.id)
+	cur := p.byID[k]
+	if cur == nil {
+		cur = &t
+		cur.started = now
+		p.byID[k] = cur
+	} else {
+		cur.received = t.received
+		if t.total >= 0 {
+			cur.total = t.total
+		}
+	}
+	cur.active, cur.done, cur.updated = true, false, now
+	cur.samples = append(cur.samples, rateSample{now, cur.received})
+}
+
+func (p *uploadProgress) add(share, id string, n int64) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.byID[progressKey(share, id)]
+	if t == nil {
+		return
+	}
+	t.received += n
+	t.updated = now
+	// One sample per 250ms is plenty for a 10s window.
+	if last := t.samples[len(t.samples)-1]; now.Sub(last.t) >= 250*time.Millisecond {
+		t.samples = append(t.samples, rateSample{now, t.received})
+		cut := 0
+		for cut < len(t.samples)-1 && now.Sub(t.samples[cut].t) > rateWindow {
+			cut++
+		}
+		t.samples = t.samples[cut:]
+	}
+}
+
+// end stops the sending part; received is the authoritative offset, or -1
+// to keep the count. All bytes in marks the transfer done.
+func (p *uploadProgress) end(share, id string, received int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.byID[progressKey(share, id)]
+	if t == nil {
+		return
+	}
+	if received >= 0 {
+		t.received = received
+	}
+	t.active = false
+	t.done = t.total >= 0 && t.received >= t.total
+	t.updated = time.Now()
+}
+
+func (p *uploadProgress) forget(share, id string) {
+	p.mu.Lock()
+	delete(p.byID, progressKey(share, id))
+	p.mu.Unlock()
+}
+
+func (p *uploadProgress) get(share, id string) (transferView, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.byID[progressKey(share, id)]
+	if t == nil {
+		return transferView{}, false
+	}
+	return t.view(time.Now()), true
+}
+
+// list returns all transfers, most recently active first.
+func (p *uploadProgress) list() []transferView {
+	now := time.Now()
+	p.mu.Lock()
+	p.sweepLocked(now)
+	out := make([]transferView, 0, len(p.byID))
+	for _, t := range p.byID {
+		out = append(out, t.view(now))
+	}
+	p.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Updated > out[j].Updated })
+	return out
+}
+
+func (p *uploadProgress) sweepLocked(now time.Time) {
+	for k, t := range p.byID {
+		keep := progressIdle
+		if t.done || t.kind == "multipart" {
+			keep = progressKeep
+		}
+		if !t.active && now.Sub(t.updated) > keep {
+			delete(p.byID, k)
+		}
+	}
+}
+
+func (t *transfer) view(now time.Time) transferView {
+	v := transferView{
+		ID: t.id, Share: t.share, Path: t.path, Kind: t.kind, User: t.user,
+		Received: t.received, Total: t.total, Active: t.active, Done: t.done,
+		Started: t.started.Unix(), Updated: t.updated.Unix(),
+	}
+	// A transfer that stopped sending has no current rate.
+	if t.active && len(t.samples) > 0 {
+		first := t.samples[0]
+		if d := now.Sub(first.t).Seconds(); d >= 1 {
+			v.Rate = float64(t.received-first.n) / d
+		}
+	}
+	if v.Rate > 0 && t.total > t.received {
+		v.ETA = float64(t.total-t.received) / v.Rate
+	}
+	return v
+}
+
+// progressReader counts body bytes into a transfer as they are read.
+type progressReader struct {
+	io.ReadCloser
+	p         *uploadProgress
+	share, id string
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if n > 0 {
+		r.p.add(r.share, r.id, int64(n))
+	}
+	return n, err
+}
+
+// trackMultipart starts tracking a multipart upload to dir when the client
+// sent an upload id. The returned func ends it.
+func (s *Server) trackMultipart(r *http.Request, dir string) func() {
+	id := r.Header.Get(uploadIDHeader)
+	if !clientUploadIDRe.MatchString(id) {
+		return func() {}
+	}
+	share := shareFromContext(r.Context())
+	user := auth.UserFromContext(r.Context())
+	s.progress.begin(transfer{id: id, share: share, path: dir, kind: "multipart", user: user, total: r.ContentLength})
+	r.Body = progressReader{ReadCloser: r.Body, p: s.progress, share: share, id: id}
+	return func() { s.progress.end(share, id, -1) }
+}
+
+// handleUploadProgress reports one upload's progress.
+//
+//	GET /api/uploads/<id>/progress -> {id, path, kind, received, total, rate, eta, active, done, started, updated}
+//
+// id is a resumable session id or the X-Upload-Id of a multipart upload.
+// Watching needs read access to the destination.
+func (s *Server) handleUploadProgress(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	share := shareFromContext(r.Context())
+	v, ok := s.progress.get(share, id)
+	if !ok {
+		// No bytes seen since the server started; the session still
+		// knows how far it got.
+		_, up, err := s.shareDeps(r)
+		if err != nil {
+			http.Error(w, "server init failed", http.StatusInternalServerError)
+			return
+		}
+		sess, found := up.Get(id)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		v = transferView{ID: sess.ID, Share: share, Path: sess.DestRel, Kind: "resumable", Received: sess.Offset, Total: sess.Size, Started: sess.Created}
+	}
+	if ok, err := s.allowed(r, auth.PermRead, "/"+v.Path); err != nil || !ok {
+		if s.shouldChallenge(r) {
+			s.authChallenge(w)
+		} else {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+		return
+	}
+	writeJSON(w, v)
+}
+
+// handleAdminUploads lists uploads in progress on every share.
+//
+//	GET /api/admin/uploads -> {uploads:[...]}
+func (s *Server) handleAdminUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.adminOnly(w, r) {
+		return
+	}
+	writeJSON(w, map[string]any{"uploads": s.progress.list()})
+}
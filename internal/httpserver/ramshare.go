@@ -0,0 +1,164 @@
+package httpserver
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"lanparty/internal/config"
+	"lanparty/internal/fsutil"
+)
+
+// RAM shares.
+//
+// A share with "ram" set lives on tmpfs (fsutil.RAMDir) so everything else
+// keeps working on plain files. It is wiped at startup, capped in size, and
+// files are removed ttlMinutes after their content arrived. The sweep runs
+// lazily on requests to the share, at most once a minute.
+
+const (
+	ramDefaultMiB  = 256
+	ramDefaultTTL  = 24 * time.Hour
+	ramSweepPeriod = time.Minute
+)
+
+// RAMShareRoot is where the RAM share name keeps its files.
+func RAMShareRoot(name string) string {
+	return filepath.Join(fsutil.RAMDir(), "lanparty-ram", name)
+}
+
+func ramLimits(r *config.RAMShare) (int64, time.Duration) {
+	size := int64(r.SizeMiB) << 20
+	if size <= 0 {
+		size = ramDefaultMiB << 20
+	}
+	ttl := time.Duration(r.TTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = ramDefaultTTL
+	}
+	return size, ttl
+}
+
+// resetRAMShares empties every RAM share; called once at startup.
+func resetRAMShares(cfg config.Config) error {
+	for name, sh := range cfg.Shares {
+		if sh.RAM == nil {
+			continue
+		}
+		root := RAMShareRoot(name)
+		if err := os.RemoveAll(root); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(root, ".lanparty"), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// guardRAMShare expires old files and rejects writes that would overflow a
+// RAM share's size cap with 507.
+func (s *Server) guardRAMShare(w http.ResponseWriter, r *http.Request, name string) bool {
+	sh, ok := s.config().Shares[name]
+	if !ok || sh.RAM == nil {
+		return true
+	}
+	size, ttl := ramLimits(sh.RAM)
+	cfg := s.shareCfg(name)
+	s.maybeSweepRAM(name, cfg, ttl)
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return true
+	}
+	if r.ContentLength == 0 {
+		return true
+	}
+	// A cut-off stream could still be committed (WebDAV PUT closes the file
+	// on copy errors), so the size must be known up front.
+	if r.ContentLength < 0 {
+		http.Error(w, "length required", http.StatusLengthRequired)
+		return false
+	}
+	if r.ContentLength > size-ramUsage(cfg) {
+		http.Error(w, "share full", http.StatusInsufficientStorage)
+		return false
+	}
+	return true
+}
+
+// ramUsage sums file sizes below the share root. The blob store is skipped:
+// its blobs are hardlinks of share files and would count twice.
+func ramUsage(cfg config.Config) int64 {
+	blobs := filepath.Join(cfg.StateDir, "blobs")
+	var n int64
+	_ = filepath.WalkDir(cfg.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p == blobs {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			n += info.Size()
+		}
+		return nil
+	})
+	return n
+}
+
+func (s *Server) maybeSweepRAM(name string, cfg config.Config, ttl time.Duration) {
+	s.mu.Lock()
+	last := s.ramSwept[name]
+	due := time.Since(last) >= ramSweepPeriod
+	if due {
+		s.ramSwept[name] = time.Now()
+	}
+	s.mu.Unlock()
+	if due {
+		go sweepRAMShare(cfg, ttl)
+	}
+}
+
+// sweepRAMShare removes files older than ttl and the share directories they
+// leave empty. Files directly in the state dir (metadata) are kept.
+func sweepRAMShare(cfg config.Config, ttl time.Duration) {
+	cutoff := time.Now().Add(-ttl)
+	var dirs []string
+	_ = filepath.WalkDir(cfg.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != cfg.Root && !inDir(p, cfg.StateDir) {
+				dirs = append(dirs, p)
+			}
+			return nil
+		}
+		if filepath.Dir(p) == cfg.StateDir {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+			_ = os.Remove(p)
+		}
+		return nil
+	})
+	// Deepest first; Remove fails on directories that still have entries.
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, d := range dirs {
+		_ = os.Remove(d)
+	}
+}
+
+func inDir(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
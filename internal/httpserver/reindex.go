@@ -0,0 +1,316 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+)
+
+// A reindex job refreshes what lanparty caches about a share after files
+// were changed behind its back (rsync, another machine on the NAS): tree
+// sizes, image dimensions/EXIF, video duration/resolution and audio tags
+// used by listings, photos and music, and optionally the thumbnails. Search reads the tree live and
+// needs no rebuild. One job runs per share at a time.
+
+type reindexJob struct {
+	Share    string `json:"share"`
+	Thumbs   bool   `json:"thumbs"`
+	Phase    string `json:"phase"` // scan, metadata, thumbs, done, canceled, failed
+	Done     int    `json:"done"`
+	Total    int    `json:"total"` // files of the current phase; 0 while scanning
+	Errors   int    `json:"errors"`
+	Files    int64  `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Started  int64  `json:"started"`
+	Finished int64  `json:"finished,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+	job    *adminJob // in /api/admin/jobs
+}
+
+func (j *reindexJob) running() bool { return j.Finished == 0 }
+
+type reindexFile struct {
+	abs, rel string
+	info     os.FileInfo
+}
+
+// handleAdminReindex starts, polls and cancels the reindex of the share
+// the request is under (/s/<name>/api/admin/reindex, or the default root).
+//
+//	POST   /api/admin/reindex {"thumbs": bool} -> job (202; 409 if one is running)
+//	GET    /api/admin/reindex                  -> job, or {} if none ran yet
+//	DELETE /api/admin/reindex                  -> job
+func (s *Server) handleAdminReindex(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	name := shareFromContext(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, s.reindexStatus(name))
+	case http.MethodPost:
+		var req struct {
+			Thumbs bool `json:"thumbs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		job, ok := s.startReindex(name, req.Thumbs, auth.UserFromContext(r.Context()))
+		code := http.StatusAccepted
+		if !ok {
+			code = http.StatusConflict
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(code)
+		writeJSON(w, job)
+	case http.MethodDelete:
+		s.reindexMu.Lock()
+		if j := s.reindexJobs[name]; j != nil && j.running() {
+			j.cancel()
+		}
+		s.reindexMu.Unlock()
+		writeJSON(w, s.reindexStatus(name))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// reindexStatus returns a copy of the share's last job, or an empty object.
+func (s *Server) reindexStatus(name string) any {
+	s.reindexMu.Lock()
+	defer s.reindexMu.Unlock()
+	j := s.reindexJobs[name]
+	if j == nil {
+		return struct{}{}
+	}
+	c := *j
+	c.cancel, c.job = nil, nil
+	return c
+}
+
+func (s *Server) startReindex(name string, thumbs bool, user string) (reindexJob, bool) {
+	s.reindexMu.Lock()
+	defer s.reindexMu.Unlock()
+	if j := s.reindexJobs[name]; j != nil && j.running() {
+		return *j, false
+	}
+	j := &reindexJob{Share: name, Thumbs: thumbs, Phase: "scan", Started: time.Now().Unix()}
+	// The job outlives the request that started it.
+	ctx, aj := s.startJob(context.Background(), "reindex", name, user, func() string {
+		s.reindexMu.Lock()
+		defer s.reindexMu.Unlock()
+		if j.Total > 0 {
+			return fmt.Sprintf("%s %d/%d", j.Phase, j.Done, j.Total)
+		}
+		return j.Phase
+	})
+	j.cancel, j.job = aj.cancel, aj
+	s.reindexJobs[name] = j
+	go s.runReindex(ctx, j)
+	return *j, true
+}
+
+// reindexUpdate applies fn to j under reindexMu.
+func (s *Server) reindexUpdate(j *reindexJob, fn func(j *reindexJob)) {
+	s.reindexMu.Lock()
+	fn(j)
+	s.reindexMu.Unlock()
+}
+
+func (s *Server) runReindex(ctx context.Context, j *reindexJob) {
+	defer j.cancel()
+	name := j.Share
+	cfg := s.shareCfg(name)
+	finish := func(phase string, err error) {
+		s.reindexUpdate(j, func(j *reindexJob) {
+			j.Phase = phase
+			if err != nil {
+				j.Error = err.Error()
+			}
+			j.Finished = time.Now().Unix()
+		})
+		s.endJob(j.job, err)
+	}
+
+	// scan: walk the tree once, dropping cached entries for it first so
+	// removed files do not linger.
+	s.forgetTree(cfg.Root)
+	skip := ""
+	if rel, err := filepath.Rel(cfg.Root, cfg.StateDir); err == nil && !strings.HasPrefix(rel, "..") {
+		skip = cfg.StateDir
+	}
+	var files []reindexFile
+	u := treeUsage{}
+	err := filepath.WalkDir(cfg.Root, func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if p == cfg.Root {
+				return err
+			}
+			s.reindexUpdate(j, func(j *reindexJob) { j.Errors++ })
+			return nil
+		}
+		if d.IsDir() {
+			if skip != "" && p == skip {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		u.files++
+		u.bytes += info.Size()
+		ext := strings.ToLower(filepath.Ext(p))
+		if isImageExt(ext) || isAudioExt(ext) || isVideoExt(ext) || ext == ".zip" || isTextExt(ext) {
+			rel, _ := filepath.Rel(cfg.Root, p)
+			files = append(files, reindexFile{abs: p, rel: filepath.ToSlash(rel), info: info})
+		}
+		if u.files%1000 == 0 {
+			s.reindexUpdate(j, func(j *reindexJob) { j.Files, j.Bytes = u.files, u.bytes })
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			finish("canceled", nil)
+		} else {
+			finish("failed", err)
+		}
+		return
+	}
+	u.scanned = time.Now()
+	s.actMu.Lock()
+	s.usageCache[cfg.Root+"\x00"+skip] = u
+	delete(s.usageCache, cfg.StateDir+"\x00")
+	s.actMu.Unlock()
+	s.reindexUpdate(j, func(j *reindexJob) { j.Files, j.Bytes = u.files, u.bytes })
+
+	// Files of encrypted shares are sealed on disk; there is nothing to
+	// read metadata or thumbnails from.
+	if s.isEncryptedShare(name) {
+		finish("done", nil)
+		return
+	}
+
+	// metadata: what listings, /api/photos and /api/music read per file.
+	s.reindexUpdate(j, func(j *reindexJob) { j.Phase, j.Done, j.Total = "metadata", 0, len(files) })
+	for i, f := range files {
+		if ctx.Err() != nil {
+			finish("canceled", nil)
+			return
+		}
+		ext := strings.ToLower(filepath.Ext(f.abs))
+		if isImageExt(ext) {
+			s.photoMeta(f.abs, f.info)
+		} else if isAudioExt(ext) {
+			s.audioTags(f.abs, f.info)
+		} else if isVideoExt(ext) {
+			s.videoInfo(f.abs, f.info)
+		}
+		s.reindexUpdate(j, func(j *reindexJob) { j.Done = i + 1 })
+	}
+
+	if !j.Thumbs || !s.enabled(featThumbs) {
+		finish("done", nil)
+		return
+	}
+
+	// thumbs: the default-size ones the file list asks for.
+	thumbDir := filepath.Join(cfg.StateDir, "thumbs")
+	if err := os.MkdirAll(thumbDir, 0o755); err != nil {
+		finish("failed", err)
+		return
+	}
+	s.reindexUpdate(j, func(j *reindexJob) { j.Phase, j.Done, j.Total = "thumbs", 0, len(files) })
+	for i, f := range files {
+		if ctx.Err() != nil {
+			finish("canceled", nil)
+			return
+		}
+		ext := strings.ToLower(filepath.Ext(f.abs))
+		kind := ""
+		switch {
+		case isImageExt(ext) || ext == ".zip":
+		case isTextExt(ext) && f.info.Size() > 0 && f.info.Size() <= 1024*1024:
+			kind = "txt"
+		default:
+			s.reindexUpdate(j, func(j *reindexJob) { j.Done = i + 1 })
+			continue
+		}
+		key := thumbKey(f.rel, f.info, 256, kind)
+		p := filepath.Join(thumbDir, key)
+		failed := false
+		if _, err := os.Stat(p); err != nil {
+			b, err := s.renderThumb(ctx, key, f.abs, kind, 256)
+			// Visitors go first; the job waits its turn as long as it takes.
+			for errors.Is(err, errBusy) && ctx.Err() == nil {
+				time.Sleep(time.Second)
+				b, err = s.renderThumb(ctx, key, f.abs, kind, 256)
+			}
+			if err == nil {
+				s.writeThumbCache(name, p, key, b)
+			} else if ext != ".zip" {
+				// A zip without images has no thumbnail; that is not an error.
+				failed = true
+			}
+		}
+		s.reindexUpdate(j, func(j *reindexJob) {
+			j.Done = i + 1
+			if failed {
+				j.Errors++
+			}
+		})
+	}
+	finish("done", nil)
+}
+
+// forgetTree drops cached sizes and media metadata for everything below
+// root. Hashes stay: they are keyed by size and mtime and cost the most to
+// redo.
+func (s *Server) forgetTree(root string) {
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+	s.exifMu.Lock()
+	for k := range s.exifCache {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.exifCache, k)
+		}
+	}
+	for k := range s.tagCache {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.tagCache, k)
+		}
+	}
+	for k := range s.videoCache {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.videoCache, k)
+		}
+	}
+	s.exifMu.Unlock()
+	s.actMu.Lock()
+	for k := range s.usageCache {
+		if strings.HasPrefix(k, root+"\x00") {
+			delete(s.usageCache, k)
+		}
+	}
+	s.actMu.Unlock()
+}
@@ -0,0 +1,140 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"lanparty/internal/config"
+)
+
+// Config reloads: Reload re-reads the config file (on SIGHUP, or when
+// WatchConfig sees it change) and swaps it in like an admin edit would.
+// Requests already running finish with the snapshot they started with.
+// The policy program, TLS and the listen address stay as they were
+// started; changing those takes a restart.
+
+// Reload re-reads, normalizes and checks the config file and makes it the
+// current config. On error the running config is kept.
+func (s *Server) Reload() error {
+	if s.cfgPath == "" {
+		return errors.New("no config file to reload")
+	}
+	b, err := os.ReadFile(s.cfgPath)
+	if err != nil {
+		return err
+	}
+	return s.reload(b)
+}
+
+func (s *Server) reload(b []byte) error {
+	var cfg config.Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+	if s.prepare != nil {
+		s.prepare(&cfg)
+	}
+	cfg, err := normalizeConfig(cfg)
+	if err != nil {
+		return err
+	}
+	fileUsers, err := mergeUsersFile(&cfg)
+	if err != nil {
+		return err
+	}
+	if err := checkConfig(cfg, s.disabled); err != nil {
+		return err
+	}
+	_, err = s.updateConfig(func(cur *config.Config) error {
+		cfg.Policy, cfg.TLS = cur.Policy, cur.TLS
+		*cur = cfg
+		s.cfgSum = sha256.Sum256(b)
+		s.fileUsers = fileUsers
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.resetShareCaches()
+	return nil
+}
+
+// WatchConfig polls the config file every interval until ctx is done and
+// reloads it when its contents, or those of its users file, change,
+// reporting each attempt to done (err is nil on success). Writes by the
+// server itself (admin edits) are not reloaded.
+func (s *Server) WatchConfig(ctx context.Context, every time.Duration, done func(err error)) {
+	if s.cfgPath == "" {
+		return
+	}
+	var lastMod time.Time
+	var lastSize int64 = -1
+	var users fileStamp
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if s.usersFileChanged(&users) {
+			done(s.Reload())
+			continue
+		}
+		st, err := os.Stat(s.cfgPath)
+		if err != nil || (st.ModTime().Equal(lastMod) && st.Size() == lastSize) {
+			continue
+		}
+		lastMod, lastSize = st.ModTime(), st.Size()
+		b, err := os.ReadFile(s.cfgPath)
+		if err != nil {
+			continue
+		}
+		s.cfgMu.Lock()
+		same := sha256.Sum256(b) == s.cfgSum
+		s.cfgMu.Unlock()
+		if same {
+			continue
+		}
+		err = s.reload(b)
+		if err != nil {
+			// Do not retry the same broken contents every tick.
+			s.cfgMu.Lock()
+			s.cfgSum = sha256.Sum256(b)
+			s.cfgMu.Unlock()
+		}
+		done(err)
+	}
+}
+
+// fileStamp is what WatchConfig last saw of a file.
+type fileStamp struct {
+	path string
+	mod  time.Time
+	size int64
+}
+
+// usersFileChanged reports whether the users file of the current config
+// changed since last. Its first sighting, and that of a new path, only
+// record it: the config load that named it read it already.
+func (s *Server) usersFileChanged(last *fileStamp) bool {
+	path := s.config().UsersFile
+	if path == "" {
+		*last = fileStamp{}
+		return false
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	cur := fileStamp{path: path, mod: st.ModTime(), size: st.Size()}
+	changed := last.path == path && (!cur.mod.Equal(last.mod) || cur.size != last.size)
+	*last = cur
+	return changed
+}
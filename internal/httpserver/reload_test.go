@@ -0,0 +1,64 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lanparty/internal/config"
+)
+
+func reloadWith(t *testing.T, s *Server, fn func(*config.Config)) {
+	t.Helper()
+	cfg := cloneConfig(*s.config())
+	fn(&cfg)
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reload(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A reload that leaves a share's paths alone keeps its uploads in flight
+// and WebDAV locks; moving the share drops them.
+func TestReloadDuringUpload(t *testing.T) {
+	s := testServer(t)
+	h := s.Handler()
+	if w := davRangePut(t, h, "alice", "0-3/8", "aaaa"); w.Code != http.StatusNoContent {
+		t.Fatalf("first half: %d %s", w.Code, w.Body)
+	}
+	w := serveAs(h, httptest.NewRequest("LOCK", "/dav/locked.txt", strings.NewReader(`<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`)), "alice")
+	if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+		t.Fatalf("LOCK: %d %s", w.Code, w.Body)
+	}
+	_, up, err := s.shareDeps(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloadWith(t, s, func(cfg *config.Config) { cfg.Branding.Name = "after reload" })
+	if _, now, _ := s.shareDeps(httptest.NewRequest(http.MethodGet, "/", nil)); now != up {
+		t.Fatal("reload replaced the upload manager of an unchanged share")
+	}
+	if w := davRangePut(t, h, "alice", "4-7/8", "AAAA"); w.Code != http.StatusCreated {
+		t.Fatalf("second half: %d %s", w.Code, w.Body)
+	}
+	if b, _ := os.ReadFile(filepath.Join(s.config().Root, "demo.bin")); string(b) != "aaaaAAAA" {
+		t.Fatalf("uploaded %q", b)
+	}
+	w = serveAs(h, httptest.NewRequest(http.MethodPut, "/dav/locked.txt", strings.NewReader("x")), "bob")
+	if w.Code != http.StatusLocked {
+		t.Fatalf("PUT over a lock after reload: %d, want 423", w.Code)
+	}
+
+	reloadWith(t, s, func(cfg *config.Config) { cfg.Root = t.TempDir() })
+	if _, now, _ := s.shareDeps(httptest.NewRequest(http.MethodGet, "/", nil)); now == up {
+		t.Fatal("reload kept the upload manager of a moved share")
+	}
+}
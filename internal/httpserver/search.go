@@ -0,0 +1,280 @@
+package httpserver
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lanparty/internal/fsutil"
+)
+
+const (
+	searchMaxHits   = 500
+	searchMaxFiles  = 200_000
+	searchWorkers   = 8
+	searchBudget    = 10 * time.Second
+	searchMaxBudget = time.Minute
+)
+
+// handleSearch matches q against the relative paths below path.
+//
+//	GET /api/search?q=<text>&path=<dir>[&budget=<ms>]
+//	  -> {items, seen, truncated, reason}   reason: maxHits|maxFiles|timeout
+//
+// Directories are read by a small worker pool; dot-directories queue behind
+// the others so ordinary hits come first. The walk stops as soon as the
+// client goes away or the time budget (default 10s, max 60s) runs out.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	baseRel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeJSON(w, map[string]any{"items": []listItem{}, "seen": 0, "truncated": false})
+		return
+	}
+	budget := searchBudget
+	if v := r.URL.Query().Get("budget"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			http.Error(w, "bad budget", http.StatusBadRequest)
+			return
+		}
+		budget = min(time.Duration(ms)*time.Millisecond, searchMaxBudget)
+	}
+	cfg := s.cfgForReq(r)
+	baseAbs, err := fsutil.ResolveWithinRoot(cfg.Root, baseRel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	defer cancel()
+	sr := &searcher{
+		qlow: strings.ToLower(q),
+		item: func(rel string, d fs.DirEntry) listItem { return s.searchItem(r, rel, d) },
+	}
+	sr.run(ctx, searchNode{abs: baseAbs, rel: baseRel})
+	if r.Context().Err() != nil {
+		return
+	}
+	if sr.reason == "" && ctx.Err() != nil {
+		sr.reason = "timeout"
+	}
+
+	hits := sr.hits
+	if hits == nil {
+		hits = []listItem{}
+	}
+	// Workers finish in any order; present hits breadth-first, dot paths last.
+	sort.Slice(hits, func(i, j int) bool {
+		hi, hj := hiddenRel(hits[i].Path), hiddenRel(hits[j].Path)
+		if hi != hj {
+			return !hi
+		}
+		di, dj := strings.Count(hits[i].Path, "/"), strings.Count(hits[j].Path, "/")
+		if di != dj {
+			return di < dj
+		}
+		return strings.ToLower(hits[i].Path) < strings.ToLower(hits[j].Path)
+	})
+	writeJSON(w, map[string]any{
+		"items":     hits,
+		"seen":      sr.seen,
+		"truncated": sr.reason != "",
+		"reason":    sr.reason,
+	})
+}
+
+func (s *Server) searchItem(r *http.Request, rel string, d fs.DirEntry) listItem {
+	name := d.Name()
+	it := listItem{Name: name, Path: rel, IsDir: d.IsDir()}
+	if info, _ := d.Info(); info != nil {
+		it.Size = info.Size()
+		it.Mtime = info.ModTime().Unix()
+	}
+	if !it.IsDir {
+		ext := strings.ToLower(filepath.Ext(name))
+		it.Mime = contentTypeForName(name)
+		if s.enabled(featThumbs) {
+			if isImageExt(ext) || ext == ".zip" {
+				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(rel))
+			} else if isTextExt(ext) && it.Size > 0 && it.Size <= 1024*1024 {
+				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(rel)+"&t=txt")
+			}
+		}
+		if s.previewerFor(ext) != nil {
+			it.Preview = true
+			if it.Thumb == "" && s.enabled(featThumbs) {
+				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(rel))
+			}
+		}
+	}
+	return it
+}
+
+func hiddenRel(rel string) bool {
+	return strings.HasPrefix(rel, ".") || strings.Contains(rel, "/.")
+}
+
+type searchNode struct {
+	abs string
+	rel string // slash-separated, "" for root
+}
+
+// searcher is one parallel search. mu guards everything below it; cond wakes
+// idle workers when directories are queued or the search ends.
+type searcher struct {
+	qlow string
+	item func(rel string, d fs.DirEntry) listItem
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	normal  []searchNode
+	hidden  []searchNode
+	busy    int // workers reading a directory
+	seen    int
+	hits    []listItem
+	reason  string // limit that ended the search early
+	stopped bool
+}
+
+func (sr *searcher) run(ctx context.Context, root searchNode) {
+	sr.cond = sync.NewCond(&sr.mu)
+	sr.normal = []searchNode{root}
+	stop := context.AfterFunc(ctx, func() {
+		sr.mu.Lock()
+		sr.stopped = true
+		sr.cond.Broadcast()
+		sr.mu.Unlock()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < searchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, ok := sr.next()
+				if !ok {
+					return
+				}
+				sr.scan(n)
+				sr.mu.Lock()
+				sr.busy--
+				sr.cond.Broadcast()
+				sr.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// next hands out the next directory, or false once the search is over.
+func (sr *searcher) next() (searchNode, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	for {
+		if sr.stopped {
+			return searchNode{}, false
+		}
+		var n searchNode
+		switch {
+		case len(sr.normal) > 0:
+			n, sr.normal = sr.normal[0], sr.normal[1:]
+		case len(sr.hidden) > 0:
+			n, sr.hidden = sr.hidden[0], sr.hidden[1:]
+		case sr.busy == 0:
+			sr.stopped = true
+			sr.cond.Broadcast()
+			return searchNode{}, false
+		default:
+			sr.cond.Wait()
+			continue
+		}
+		sr.busy++
+		return n, true
+	}
+}
+
+// countLocked adds one to seen; false once the search has to stop.
+func (sr *searcher) countLocked() bool {
+	if sr.stopped {
+		return false
+	}
+	sr.seen++
+	if sr.seen > searchMaxFiles {
+		sr.stopLocked("maxFiles")
+		return false
+	}
+	return true
+}
+
+func (sr *searcher) stopLocked(reason string) {
+	sr.reason = reason
+	sr.stopped = true
+	sr.cond.Broadcast()
+}
+
+func (sr *searcher) scan(n searchNode) {
+	// The directory itself counts against maxFiles, like WalkDir.
+	sr.mu.Lock()
+	ok := sr.countLocked()
+	sr.mu.Unlock()
+	if !ok {
+		return
+	}
+	ents, err := os.ReadDir(n.abs)
+	if err != nil {
+		return
+	}
+	// ReadDir is sorted; visit dot entries after the rest.
+	sort.SliceStable(ents, func(i, j int) bool {
+		return !strings.HasPrefix(ents[i].Name(), ".") && strings.HasPrefix(ents[j].Name(), ".")
+	})
+	for _, e := range ents {
+		name := e.Name()
+		rel := name
+		if n.rel != "" {
+			rel = n.rel + "/" + name
+		}
+		// Match against the full relative path (not just basename).
+		match := strings.Contains(strings.ToLower(rel), sr.qlow)
+		var it listItem
+		if match {
+			it = sr.item(rel, e)
+		}
+
+		sr.mu.Lock()
+		if !sr.countLocked() {
+			sr.mu.Unlock()
+			return
+		}
+		if match {
+			sr.hits = append(sr.hits, it)
+			if len(sr.hits) >= searchMaxHits {
+				sr.stopLocked("maxHits")
+				sr.mu.Unlock()
+				return
+			}
+		}
+		// Queue subdirectories; symlinks are not followed (avoids loops).
+		if e.IsDir() && e.Type()&os.ModeSymlink == 0 {
+			child := searchNode{abs: filepath.Join(n.abs, name), rel: rel}
+			if strings.HasPrefix(name, ".") {
+				sr.hidden = append(sr.hidden, child)
+			} else {
+				sr.normal = append(sr.normal, child)
+			}
+			sr.cond.Signal()
+		}
+		sr.mu.Unlock()
+	}
+}
@@ -0,0 +1,56 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"lanparty/internal/config"
+)
+
+func checkProxySendfile(ps *config.ProxySendfile) error {
+	if ps == nil {
+		return nil
+	}
+	switch ps.Mode {
+	case "x-accel":
+		if !strings.HasPrefix(ps.Location, "/") {
+			return fmt.Errorf("location %q must start with /", ps.Location)
+		}
+	case "x-sendfile":
+	default:
+		return fmt.Errorf("mode %q: want x-accel or x-sendfile", ps.Mode)
+	}
+	return nil
+}
+
+// proxySendfile hands the download of abs (rel within the request's share)
+// to the fronting proxy when proxySendfile is configured. Content-Type and
+// Content-Disposition are set by then; the proxy does Range, caching
+// headers and the I/O.
+func (s *Server) proxySendfile(w http.ResponseWriter, r *http.Request, abs, rel string) bool {
+	ps := s.config().ProxySendfile
+	if ps == nil {
+		return false
+	}
+	switch ps.Mode {
+	case "x-accel":
+		loc := strings.TrimSuffix(ps.Location, "/") + "/"
+		if name := shareFromContext(r.Context()); name != "" {
+			loc += "s/" + url.PathEscape(name) + "/"
+		}
+		// nginx percent-decodes the URI, so names with % or ? survive.
+		segs := strings.Split(rel, "/")
+		for i, seg := range segs {
+			segs[i] = url.PathEscape(seg)
+		}
+		w.Header().Set("X-Accel-Redirect", loc+strings.Join(segs, "/"))
+	case "x-sendfile":
+		w.Header().Set("X-Sendfile", abs)
+	default:
+		return false
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
+}
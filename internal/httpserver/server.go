@@ -5,26 +5,34 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/rand"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"embed"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
+	"maps"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -34,6 +42,10 @@ import (
 	"lanparty/internal/config"
 	"lanparty/internal/dedup"
 	"lanparty/internal/fsutil"
+	"lanparty/internal/meta"
+	"lanparty/internal/policy"
+	"lanparty/internal/readcache"
+	"lanparty/internal/sealed"
 	"lanparty/internal/upload"
 )
 
@@ -41,11 +53,27 @@ type Options struct {
 	Config       config.Config
 	ConfigPath   string
 	DisableAdmin bool
+	// Disable switches off subsystems on top of Config.Disable (see
+	// features.go).
+	Disable []string
+	// WebDir, when set, overrides UI files (index.html, assets/...) from disk;
+	// anything missing there comes from the embedded copy.
+	WebDir string
+	// Prepare, when set, is applied to the config file's contents on
+	// Reload before they are checked, for settings the command line
+	// overrides.
+	Prepare func(cfg *config.Config)
 }
 
 type ctxKey int
 
-const shareKey ctxKey = 1
+const (
+	shareKey ctxKey = iota + 1
+	davUserKey
+	ticketCapKey
+	tokenKey
+	viaKey
+)
 
 func shareFromContext(ctx context.Context) string {
 	v := ctx.Value(shareKey)
@@ -56,21 +84,118 @@ func shareFromContext(ctx context.Context) string {
 }
 
 type Server struct {
-	cfg          config.Config
-	cfgMu        sync.RWMutex
+	// cfg holds the current *config.Config. A stored snapshot is never
+	// modified; updateConfig swaps in an edited copy.
+	cfg          atomic.Value
+	cfgMu        sync.Mutex // serializes updateConfig
 	cfgPath      string
+	cfgSum       [sha256.Size]byte // of the config file as last read or written; guarded by cfgMu
+	prepare      func(cfg *config.Config)
 	disableAdmin bool
+	disabled     []string // Options.Disable
+	// fileUsers are the users added from config.UsersFile (see
+	// usersfile.go); guarded by cfgMu.
+	fileUsers map[string]config.User
+	// proxyNets are the TrustedProxies of cfg, parsed (see clientip.go).
+	proxyNets atomic.Pointer[[]netip.Prefix]
+	// policy overrules ACL decisions when config.Policy is set; it is
+	// loaded from the config given to New.
+	policy auth.Policy
 
 	mu       sync.Mutex
 	dedup    map[string]*dedup.Store
 	uploads  map[string]*upload.Manager
 	davLocks map[string]webdav.LockSystem
+	// depsBuilt and davRoots are the config each share's store, upload
+	// manager and WebDAV locks were built for (see resetShareCaches).
+	depsBuilt map[string]shareDepsKey
+	davRoots  map[string]string
+	// metaDBs are keyed by state dir and outlive config reloads: there
+	// must never be two open on one file.
+	metaDBs  map[string]*meta.DB
+	rcaches  map[string]*readcache.Cache
+	ramSwept map[string]time.Time
+	// homesMade remembers home folders already created (see homes.go).
+	homesMade sync.Map
+	// tombPruned is when each share's tombstones were last pruned.
+	tombPruned sync.Map
+	// expirySwept is when each share was last swept for expired items.
+	expirySwept sync.Map
+	guestsSwept atomic.Int64 // unix seconds; see guests.go
+	// blobsPacked is when each share's idle blobs were last chunked or
+	// compressed.
+	blobsPacked sync.Map
+	// ticketsBusy holds the upload tickets with an upload running.
+	ticketsBusy sync.Map
+	// lookups bounds concurrent hash lookups (see hashlookup.go).
+	lookups chan struct{}
+	// shareKeys holds the keys of unlocked encrypted shares (memory only).
+	shareKeys map[string]*sealed.Key
+	// urlKey signs temporary download URLs (see signurl.go).
+	urlKey []byte
+	// manifestKey signs distribution manifests (see manifest.go).
+	manifestKey ed25519.PrivateKey
 
 	thumbMu       sync.Mutex
 	thumbInflight map[string]*thumbCall
-	thumbSem      chan struct{}
 
-	webFS fs.FS
+	limiters  map[string]*limiter // by limit kind, see limits.go
+	userSlots userSlots
+
+	throttleMu sync.Mutex
+	buckets    map[string]*bucket // see throttle.go
+
+	exifMu     sync.Mutex // guards exifCache, tagCache and videoCache
+	exifCache  map[string]exifEntry
+	tagCache   map[string]tagEntry
+	videoCache map[string]videoEntry
+
+	actMu      sync.Mutex // guards activity and usageCache
+	activity   map[string]*shareActivity
+	usageCache map[string]treeUsage
+
+	swarmMu sync.Mutex // guards swarms and their peer maps
+	swarms  map[string]*swarmFile
+	chunks  *chunkSessions // see chunks.go
+
+	writeMu sync.Mutex // serializes /api/write checks and commits and /api/batch; see textedit.go
+
+	notifyMu    sync.Mutex // guards notified and loginFails; see notify.go
+	notified    map[string]time.Time
+	loginFails  map[string]int // by client address
+	notifySends chan struct{}
+
+	oidcMu        sync.Mutex // guards oidcProviders; see oidc.go
+	oidcProviders map[string]oidcProvider
+
+	ldapMu    sync.Mutex // guards ldapCache; see ldap.go
+	ldapCache map[string]ldapCached
+
+	mountMu sync.Mutex // guards mounts; see mounts.go
+	mounts  map[string]*mountState
+
+	tails     atomic.Int32  // live tails running; see tail.go
+	draining  chan struct{} // closed by Drain
+	drainOnce sync.Once
+
+	hashMu    sync.Mutex
+	hashCache map[string]string // abs\x00size\x00mtime -> sha256
+	verified  map[string]string // verifyKey -> ok|mismatch
+
+	reindexMu   sync.Mutex
+	reindexJobs map[string]*reindexJob // by share name
+
+	jobs      jobList      // see jobs.go
+	transfers transferList // see transfers.go
+
+	listenAddr net.Addr // set by Listener, before serving; see addrs.go
+
+	stats    *statsRecorder
+	tokenUse *tokenUsage
+	progress *uploadProgress
+
+	webFS  fs.FS
+	webDir string // see Options.WebDir
 }
 
 type thumbCall struct {
@@ -131,7 +256,18 @@ func parseBasicAuthHeader(v string) (user, pass string, ok bool) {
 // safeWebDAVFS enforces lanparty's path + symlink policy for WebDAV.
 // webdav.Dir only enforces lexical containment; it may follow symlinks to escape the root.
 type safeWebDAVFS struct {
-	cfg config.Config
+	cfg   config.Config
+	props *meta.Store // dead properties (PROPPATCH); nil disables them
+	// put, when set, receives whole-file PUT bodies staged in a temp file
+	// in spool instead of letting them truncate the target in place (which
+	// would also rewrite any blob it is hardlinked to).
+	put   func(tmp, sha, rel, abs string) error
+	spool string
+	// removed, when set, hears about deleted (to == "") and renamed paths.
+	removed func(rel, to string)
+	// listed, when set, returns which entries of folder rel PROPFIND
+	// shows, nil for all (see homesFilter).
+	listed func(rel string) func(name string) bool
 }
 
 func (s safeWebDAVFS) resolve(name string) (string, error) {
@@ -153,7 +289,29 @@ func (s safeWebDAVFS) OpenFile(ctx context.Context, name string, flag int, perm
 	if err != nil {
 		return nil, err
 	}
-	return os.OpenFile(abs, flag, perm)
+	if s.put != nil && flag == os.O_RDWR|os.O_CREATE|os.O_TRUNC {
+		// The exact flags webdav.Handler uses for PUT.
+		return s.openPut(name, abs)
+	}
+	if s.props == nil {
+		return os.OpenFile(abs, flag, perm)
+	}
+	// PROPPATCH opens O_RDWR, which fails on directories; props live in the
+	// store anyway, so a read-only handle is enough.
+	if flag == os.O_RDWR {
+		if st, err := os.Stat(abs); err == nil && st.IsDir() {
+			flag = os.O_RDONLY
+		}
+	}
+	f, err := os.OpenFile(abs, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	df := davFile{File: f, props: s.props, key: fsutil.CleanRelPath(strings.TrimPrefix(name, "/"))}
+	if s.listed != nil {
+		df.keep = s.listed(df.key)
+	}
+	return df, nil
 }
 
 func (s safeWebDAVFS) RemoveAll(ctx context.Context, name string) error {
@@ -161,7 +319,17 @@ func (s safeWebDAVFS) RemoveAll(ctx context.Context, name string) error {
 	if err != nil {
 		return err
 	}
-	return os.RemoveAll(abs)
+	if err := os.RemoveAll(abs); err != nil {
+		return err
+	}
+	rel := fsutil.CleanRelPath(strings.TrimPrefix(name, "/"))
+	if s.props != nil {
+		_ = s.props.DeleteTree(rel)
+	}
+	if s.removed != nil {
+		s.removed(rel, "")
+	}
+	return nil
 }
 
 func (s safeWebDAVFS) Rename(ctx context.Context, oldName, newName string) error {
@@ -176,7 +344,17 @@ func (s safeWebDAVFS) Rename(ctx context.Context, oldName, newName string) error
 	if err := os.MkdirAll(filepath.Dir(newAbs), 0o755); err != nil {
 		return err
 	}
-	return os.Rename(oldAbs, newAbs)
+	if err := os.Rename(oldAbs, newAbs); err != nil {
+		return err
+	}
+	oldRel, newRel := fsutil.CleanRelPath(strings.TrimPrefix(oldName, "/")), fsutil.CleanRelPath(strings.TrimPrefix(newName, "/"))
+	if s.props != nil {
+		_ = s.props.MoveTree(oldRel, newRel)
+	}
+	if s.removed != nil {
+		s.removed(oldRel, newRel)
+	}
+	return nil
 }
 
 func (s safeWebDAVFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
@@ -187,7 +365,7 @@ func (s safeWebDAVFS) Stat(ctx context.Context, name string) (os.FileInfo, error
 	return os.Stat(abs)
 }
 
-//go:embed web/index.html web/admin.html web/unauthorized.html web/assets/* web/assets/fonts/*
+//go:embed web/index.html web/admin.html web/unauthorized.html web/ticket.html web/login.html web/assets/* web/assets/fonts/*
 var embeddedWeb embed.FS
 
 func New(opts Options) (*Server, error) {
@@ -195,27 +373,278 @@ func New(opts Options) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Server{
-		cfg:          opts.Config,
+	var webFS fs.FS = sub
+	if opts.WebDir != "" {
+		if webFS, err = newOverlayFS(opts.WebDir, sub); err != nil {
+			return nil, fmt.Errorf("web dir: %w", err)
+		}
+	}
+	opts.Config.BasePath = cleanBasePath(opts.Config.BasePath)
+	opts.Config = cloneConfig(opts.Config)
+	fileUsers, err := mergeUsersFile(&opts.Config)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConfig(opts.Config, opts.Disable); err != nil {
+		return nil, err
+	}
+	if err := resetRAMShares(opts.Config); err != nil {
+		return nil, fmt.Errorf("ram share: %w", err)
+	}
+	s := &Server{
 		cfgPath:      opts.ConfigPath,
-		disableAdmin: opts.DisableAdmin,
+		prepare:      opts.Prepare,
+		disableAdmin: opts.DisableAdmin || slices.Contains(opts.Disable, featAdmin) || slices.Contains(opts.Config.Disable, featAdmin),
+		disabled:     opts.Disable,
 		dedup:        map[string]*dedup.Store{},
 		uploads:      map[string]*upload.Manager{},
 		davLocks:     map[string]webdav.LockSystem{},
-		webFS:        sub,
-	}, nil
+		depsBuilt:    map[string]shareDepsKey{},
+		davRoots:     map[string]string{},
+		shareKeys:    map[string]*sealed.Key{},
+		metaDBs:      map[string]*meta.DB{},
+		rcaches:      map[string]*readcache.Cache{},
+		ramSwept:     map[string]time.Time{},
+		activity:     map[string]*shareActivity{},
+		usageCache:   map[string]treeUsage{},
+		swarms:       map[string]*swarmFile{},
+		hashCache:    map[string]string{},
+		verified:     map[string]string{},
+		reindexJobs:  map[string]*reindexJob{},
+		stats:        newStatsRecorder(opts.Config.StateDir),
+		tokenUse:     newTokenUsage(opts.Config.StateDir),
+		progress:     newUploadProgress(),
+		chunks:       newChunkSessions(),
+		lookups:      make(chan struct{}, maxHashLookups),
+		buckets:      map[string]*bucket{},
+		draining:     make(chan struct{}),
+		notified:     map[string]time.Time{},
+		loginFails:   map[string]int{},
+		notifySends:  make(chan struct{}, maxNotifySends),
+		limiters: map[string]*limiter{
+			limitUploads:   newLimiter(),
+			limitMedia:     newLimiter(),
+			limitZips:      newLimiter(),
+			limitDownloads: newLimiter(),
+		},
+		webFS:     webFS,
+		webDir:    opts.WebDir,
+		fileUsers: fileUsers,
+	}
+	if opts.Config.Policy != nil {
+		p, err := policy.New(*opts.Config.Policy)
+		if err != nil {
+			return nil, err
+		}
+		s.policy = p
+	}
+	cfg := cloneConfig(opts.Config)
+	s.storeConfig(&cfg)
+	if s.cfgPath != "" {
+		if b, err := os.ReadFile(s.cfgPath); err == nil {
+			s.cfgSum = sha256.Sum256(b)
+		}
+	}
+	return s, nil
+}
+
+// Close writes out state kept in memory (upload part files, traffic stats,
+// token usage). Call it once the listener has drained; requests still
+// running may fail afterwards.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	ups := make([]*upload.Manager, 0, len(s.uploads))
+	for _, up := range s.uploads {
+		ups = append(ups, up)
+	}
+	s.mu.Unlock()
+	for _, up := range ups {
+		up.Close()
+	}
+	// After the upload managers, whose last patches still save sessions.
+	s.mu.Lock()
+	for _, db := range s.metaDBs {
+		_ = db.Close()
+	}
+	s.mu.Unlock()
+	if s.stats.path != "" {
+		s.stats.save()
+	}
+	if s.tokenUse.path != "" {
+		s.tokenUse.save()
+	}
+	return nil
+}
+
+// checkConfig validates the parts of cfg New and Reload cannot fix up.
+func checkConfig(cfg config.Config, disable []string) error {
+	if err := checkBranding(cfg.Branding); err != nil {
+		return fmt.Errorf("branding: %w", err)
+	}
+	if err := checkProxySendfile(cfg.ProxySendfile); err != nil {
+		return fmt.Errorf("proxySendfile: %w", err)
+	}
+	if err := checkPreviewers(cfg.Previewers); err != nil {
+		return fmt.Errorf("previewers: %w", err)
+	}
+	if err := checkHomes(cfg.Homes); err != nil {
+		return fmt.Errorf("homes: %w", err)
+	}
+	switch cfg.UploadSync {
+	case "", upload.SyncChunk, upload.SyncFinish:
+	default:
+		return fmt.Errorf("uploadSync: want %q or %q", upload.SyncChunk, upload.SyncFinish)
+	}
+	if err := checkLDAP(cfg.LDAP); err != nil {
+		return fmt.Errorf("ldap: %w", err)
+	}
+	if err := checkOIDC(cfg.OIDC); err != nil {
+		return fmt.Errorf("oidc: %w", err)
+	}
+	if err := checkNotify(cfg.Notify); err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	if err := checkHashLookup(cfg.HashLookup); err != nil {
+		return fmt.Errorf("hashLookup: %w", err)
+	}
+	if err := checkTrustedProxies(cfg.TrustedProxies); err != nil {
+		return fmt.Errorf("trustedProxies: %w", err)
+	}
+	if err := checkBasePath(cfg.BasePath); err != nil {
+		return fmt.Errorf("basePath: %w", err)
+	}
+	if err := checkThrottle(cfg.Throttle); err != nil {
+		return fmt.Errorf("throttle: %w", err)
+	}
+	if err := checkMinRate(cfg.Limits.MinRate); err != nil {
+		return fmt.Errorf("limits: minRate: %w", err)
+	}
+	if err := checkACLPaths(cfg); err != nil {
+		return err
+	}
+	if err := checkDisable(append(slices.Clip(disable), cfg.Disable...)); err != nil {
+		return fmt.Errorf("disable: %w", err)
+	}
+	return nil
+}
+
+// config returns the current config snapshot. Callers must not modify it.
+// storeConfig makes cfg the current config, parsing its trusted proxies
+// once for remoteHost.
+func (s *Server) storeConfig(cfg *config.Config) {
+	nets, _ := parseTrustedProxies(cfg.TrustedProxies) // checked when loaded
+	s.proxyNets.Store(&nets)
+	s.cfg.Store(cfg)
+}
+
+func (s *Server) config() *config.Config {
+	return s.cfg.Load().(*config.Config)
+}
+
+// updateConfig applies fn to a copy of the current config and publishes the
+// result unless fn fails. Updates are serialized so none is lost; readers
+// keep using the snapshot they loaded.
+func (s *Server) updateConfig(fn func(cfg *config.Config) error) (config.Config, error) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	cfg := cloneConfig(*s.config())
+	if err := fn(&cfg); err != nil {
+		return cfg, err
+	}
+	s.storeConfig(&cfg)
+	return cfg, nil
+}
+
+func cloneConfig(in config.Config) config.Config {
+	out := in
+	out.Shares = cloneShareMap(in.Shares)
+	out.Users = maps.Clone(in.Users)
+	out.Tokens = maps.Clone(in.Tokens)
+	out.TokenLabels = maps.Clone(in.TokenLabels)
+	out.Guests = maps.Clone(in.Guests)
+	out.ACLs = cloneACLs(in.ACLs)
+	out.Wake = maps.Clone(in.Wake)
+	out.Branding.Pages = maps.Clone(in.Branding.Pages)
+	if in.ProxySendfile != nil {
+		ps := *in.ProxySendfile
+		out.ProxySendfile = &ps
+	}
+	out.Disable = slices.Clone(in.Disable)
+	out.Previewers = slices.Clone(in.Previewers)
+	out.TrustedProxies = slices.Clone(in.TrustedProxies)
+	if in.Policy != nil {
+		p := *in.Policy
+		out.Policy = &p
+	}
+	if in.LDAP != nil {
+		l := *in.LDAP
+		l.Groups = maps.Clone(in.LDAP.Groups)
+		l.AllowedGroups = slices.Clone(in.LDAP.AllowedGroups)
+		out.LDAP = &l
+	}
+	if in.OIDC != nil {
+		o := *in.OIDC
+		o.Scopes = slices.Clone(in.OIDC.Scopes)
+		o.Groups = maps.Clone(in.OIDC.Groups)
+		o.AllowedGroups = slices.Clone(in.OIDC.AllowedGroups)
+		out.OIDC = &o
+	}
+	if in.Notify != nil {
+		out.Notify = make([]config.Notifier, len(in.Notify))
+		for i, n := range in.Notify {
+			n.Events = slices.Clone(n.Events)
+			n.Paths = slices.Clone(n.Paths)
+			out.Notify[i] = n
+		}
+	}
+	if in.HashLookup != nil {
+		h := *in.HashLookup
+		h.Headers = maps.Clone(in.HashLookup.Headers)
+		out.HashLookup = &h
+	}
+	if in.Homes != nil {
+		h := *in.Homes
+		h.ACLs = cloneACLs(in.Homes.ACLs)
+		out.Homes = &h
+	}
+	if in.Throttle != nil {
+		t := *in.Throttle
+		t.Users = maps.Clone(in.Throttle.Users)
+		t.Tokens = maps.Clone(in.Throttle.Tokens)
+		if in.Throttle.Schedule != nil {
+			t.Schedule = make([]config.ThrottleWindow, len(in.Throttle.Schedule))
+			for i, w := range in.Throttle.Schedule {
+				w.Days = slices.Clone(w.Days)
+				w.Users = maps.Clone(w.Users)
+				w.Tokens = maps.Clone(w.Tokens)
+				t.Schedule[i] = w
+			}
+		}
+		out.Throttle = &t
+	}
+	return out
 }
 
 func (s *Server) cfgForReq(r *http.Request) config.Config {
-	s.cfgMu.RLock()
-	cfg := s.cfg
-	s.cfgMu.RUnlock()
-	name := shareFromContext(r.Context())
+	return s.shareCfg(shareFromContext(r.Context()))
+}
+
+// shareCfg returns the effective config of a share ("" = default root).
+func (s *Server) shareCfg(name string) config.Config {
+	cfg := *s.config()
 	if name == "" {
 		return cfg
 	}
+	if user, ok := homeUser(name); ok && cfg.Homes != nil {
+		return homeCfg(cfg, user)
+	}
 	sh, ok := cfg.Shares[name]
 	if !ok {
+		if base, stamp, ok := snapshotOf(name); ok {
+			if _, ok := cfg.Shares[base]; ok {
+				return snapshotCfg(s.shareCfg(base), stamp)
+			}
+		}
 		return cfg
 	}
 	// share root/state
@@ -232,23 +661,51 @@ func (s *Server) cfgForReq(r *http.Request) config.Config {
 	if sh.FollowSymlinks != nil {
 		cfg.FollowSymlinks = *sh.FollowSymlinks
 	}
+	if sh.ReadOnly != nil {
+		cfg.ReadOnly = *sh.ReadOnly
+	}
+	if sh.ReadCacheMiB != nil {
+		cfg.ReadCacheMiB = *sh.ReadCacheMiB
+	}
 	return cfg
 }
 
 func (s *Server) sharePrefix(r *http.Request) string {
 	if sh := shareFromContext(r.Context()); sh != "" {
-		return "/s/" + sh
+		return s.basePath() + "/s/" + sh
 	}
-	return ""
+	return s.basePath()
 }
 
 func (s *Server) withSharePrefix(r *http.Request, p string) string {
 	return s.sharePrefix(r) + p
 }
 
-func (s *Server) shareDeps(r *http.Request) (*dedup.Store, *upload.Manager, error) {
-	cfg := s.cfgForReq(r)
+// spoolDir returns where in-flight uploads of the request's share are staged:
+// <stateDir>/uploads by default, or the configured spoolDir (per share below
+// <spoolDir>/s/<name>).
+func (s *Server) spoolDir(r *http.Request) string {
 	name := shareFromContext(r.Context())
+	return spoolDirFor(s.shareCfg(name), name)
+}
+
+func spoolDirFor(cfg config.Config, name string) string {
+	if cfg.SpoolDir == "" {
+		return filepath.Join(cfg.StateDir, "uploads")
+	}
+	if name != "" {
+		return filepath.Join(cfg.SpoolDir, "s", name)
+	}
+	return cfg.SpoolDir
+}
+
+func (s *Server) shareDeps(r *http.Request) (*dedup.Store, *upload.Manager, error) {
+	return s.shareDepsFor(shareFromContext(r.Context()))
+}
+
+// shareDepsFor is shareDeps for a share by name ("" = default root).
+func (s *Server) shareDepsFor(name string) (*dedup.Store, *upload.Manager, error) {
+	cfg := s.shareCfg(name)
 	// default share uses empty name key
 	key := name
 
@@ -265,15 +722,68 @@ func (s *Server) shareDeps(r *http.Request) (*dedup.Store, *upload.Manager, erro
 	if err != nil {
 		return nil, nil, err
 	}
-	up, err := upload.New(cfg.Root, cfg.StateDir, store, cfg.FollowSymlinks)
+	if cfg.StateKeyFile != "" {
+		key, err := sealed.LoadKeyFile(cfg.StateKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("state key: %w", err)
+		}
+		store.SetKey(key)
+	}
+	db, err := s.metaDBLocked(cfg.StateDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	up, err := upload.New(cfg.Root, spoolDirFor(cfg, name), store, db.Bucket("uploads"), cfg.FollowSymlinks)
 	if err != nil {
 		return nil, nil, err
 	}
+	up.SetPreallocate(cfg.PreallocateUploads)
+	up.SetSync(cfg.UploadSync)
 	s.dedup[key] = store
 	s.uploads[key] = up
+	s.depsBuilt[key] = depsKeyFor(cfg, name)
 	return store, up, nil
 }
 
+// shareDepsKey is the part of a share's config its store and upload
+// manager are built from.
+type shareDepsKey struct {
+	root, stateDir, stateKeyFile, spoolDir string
+	followSymlinks                         bool
+}
+
+func depsKeyFor(cfg config.Config, name string) shareDepsKey {
+	return shareDepsKey{
+		root:           cfg.Root,
+		stateDir:       cfg.StateDir,
+		stateKeyFile:   cfg.StateKeyFile,
+		spoolDir:       spoolDirFor(cfg, name),
+		followSymlinks: cfg.FollowSymlinks,
+	}
+}
+
+// readCache returns the read-through cache of the request's share, or nil
+// when it is disabled.
+func (s *Server) readCache(r *http.Request) *readcache.Cache {
+	cfg := s.cfgForReq(r)
+	if cfg.ReadCacheMiB <= 0 || cfg.StateDir == "" {
+		return nil
+	}
+	key := shareFromContext(r.Context())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.rcaches[key]; ok {
+		return c
+	}
+	// A cache that cannot be opened stays off; downloads go to the source.
+	c, err := readcache.New(filepath.Join(cfg.StateDir, "readcache"), int64(cfg.ReadCacheMiB)<<20)
+	if err != nil {
+		c = nil
+	}
+	s.rcaches[key] = c
+	return c
+}
+
 func (s *Server) davLockForReq(r *http.Request) webdav.LockSystem {
 	name := shareFromContext(r.Context())
 	key := name
@@ -284,9 +794,46 @@ func (s *Server) davLockForReq(r *http.Request) webdav.LockSystem {
 	}
 	ls := webdav.NewMemLS()
 	s.davLocks[key] = ls
+	s.davRoots[key] = s.shareCfg(name).Root
 	return ls
 }
 
+// metaStore returns the named metadata store (a bucket of <stateDir>/meta.db)
+// for the request's share.
+func (s *Server) metaStore(r *http.Request, name string) (*meta.Store, error) {
+	cfg := s.cfgForReq(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.metaDBLocked(cfg.StateDir)
+	if err != nil {
+		return nil, err
+	}
+	return db.Bucket(name), nil
+}
+
+// dropMetaDB closes the database of a state dir that is going away.
+func (s *Server) dropMetaDB(stateDir string) {
+	s.mu.Lock()
+	db := s.metaDBs[stateDir]
+	delete(s.metaDBs, stateDir)
+	s.mu.Unlock()
+	if db != nil {
+		_ = db.Close()
+	}
+}
+
+func (s *Server) metaDBLocked(stateDir string) (*meta.DB, error) {
+	if db, ok := s.metaDBs[stateDir]; ok {
+		return db, nil
+	}
+	db, err := meta.OpenDB(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	s.metaDBs[stateDir] = db
+	return db, nil
+}
+
 func (s *Server) Handler() http.Handler {
 	inner := http.NewServeMux()
 	mux := http.NewServeMux()
@@ -297,25 +844,36 @@ func (s *Server) Handler() http.Handler {
 		_, _ = io.WriteString(w, "ok\n")
 	})
 
-	// Login helper for browsers (triggers BasicAuth prompt).
-	inner.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-		if !auth.HasAuth(s.cfg) {
-			http.Redirect(w, r, "/", http.StatusFound)
-			return
-		}
-		if auth.UserFromContext(r.Context()) != "" {
-			http.Redirect(w, r, "/", http.StatusFound)
-			return
-		}
-		s.authChallenge(w)
-	})
+	// Sign-in form and sign-out for browsers; see session.go.
+	inner.HandleFunc("/login", s.handleLogin)
+	inner.HandleFunc("/logout", s.handleLogout)
+	inner.HandleFunc("/login/oidc", s.handleOIDCLogin)
+	inner.HandleFunc("/login/oidc/callback", s.handleOIDCCallback)
 
 	// WebDAV
-	inner.Handle("/dav/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	inner.Handle("/dav/", s.feature(featWebDAV, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg := s.cfgForReq(r)
+		props, err := s.metaStore(r, "davprops")
+		if err != nil {
+			http.Error(w, "server init failed", http.StatusInternalServerError)
+			return
+		}
+		// Hrefs and Destination headers use the path the client sees.
+		mount := s.mountPrefix(r) + "/dav"
 		dav := &webdav.Handler{
-			Prefix:     "/dav",
-			FileSystem: safeWebDAVFS{cfg: cfg},
+			Prefix: mount,
+			FileSystem: safeWebDAVFS{cfg: cfg, props: props, spool: s.spoolDir(r), put: func(tmp, sha, rel, abs string) error {
+				if r.ContentLength >= 0 {
+					// Never commit a truncated body.
+					if st, err := os.Stat(tmp); err != nil || st.Size() != r.ContentLength {
+						_ = os.Remove(tmp)
+						return io.ErrUnexpectedEOF
+					}
+				}
+				_, _, err := s.commitBlob(r, tmp, sha, rel, abs)
+				return err
+			}, removed: func(rel, to string) { s.recordRemoval(r, rel, to) },
+				listed: func(rel string) func(string) bool { return s.homesFilter(r, rel) }},
 			LockSystem: s.davLockForReq(r),
 		}
 		// Path-aware ACL enforcement for WebDAV.
@@ -341,12 +899,57 @@ func (s *Server) Handler() http.Handler {
 				return
 			}
 		}
-		dav.ServeHTTP(w, r)
-	}))
+		if r.Method == http.MethodPut || isDavRangeWrite(r) {
+			if !s.enabled(featUploads) {
+				http.Error(w, "uploads disabled", http.StatusMethodNotAllowed)
+				return
+			}
+			release, ok := s.limit(w, r, limitUploads)
+			if !ok {
+				return
+			}
+			defer release()
+			s.throttleUp(r)
+		}
+		if r.Method == http.MethodGet {
+			w = s.throttleDown(w, r)
+		}
+		if isDavRangeWrite(r) {
+			s.handleDavRangeWrite(w, r, mount, clean)
+			return
+		}
+		if r.Method == http.MethodPut && !s.davPutConflict(w, r, mount, clean) {
+			return
+		}
+		if r.Method == http.MethodHead {
+			s.annotateDavPendingUpload(w, r, clean)
+		}
+		if cfg.DavZipDirs && s.enabled(featZip) && (r.Method == http.MethodGet || r.Method == http.MethodHead) && s.davZipDir(w, r, cfg, clean) {
+			return
+		}
+		if r.Method == http.MethodGet {
+			release, ok := s.limit(w, r, limitDownloads)
+			if !ok {
+				return
+			}
+			defer release()
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path, r2.URL.RawPath = mount+strings.TrimPrefix(r.URL.Path, "/dav"), ""
+		dav.ServeHTTP(w, r2)
+	})))
 
 	// static assets
 	assets, _ := fs.Sub(s.webFS, "assets")
 	assetFS := http.StripPrefix("/assets/", http.FileServer(http.FS(assets)))
+	if s.webDir != "" {
+		// Files on disk change without a rebuild; revalidate every time.
+		next := assetFS
+		assetFS = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache")
+			next.ServeHTTP(w, r)
+		})
+	}
 	mux.Handle("/assets/", gzipIfAccepted(assetFS, func(r *http.Request) bool {
 		ext := strings.ToLower(filepath.Ext(r.URL.Path))
 		switch ext {
@@ -357,6 +960,8 @@ func (s *Server) Handler() http.Handler {
 		}
 	}))
 
+	mux.HandleFunc("/branding/logo", s.handleBrandingLogo)
+
 	// favicon (serve a small svg; avoids embedding a binary .ico)
 	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
@@ -392,6 +997,12 @@ func (s *Server) Handler() http.Handler {
 		if s.disableAdmin {
 			b = markAdminDisabledHTML(b)
 		}
+		b = s.markDisabledHTML(b)
+		b = s.markHomesHTML(b)
+		b = s.markSessionHTML(b, r)
+		b = s.markCSRFHTML(b, w, r)
+		b = brandHTML(b, s.config().Branding)
+		b = basePathHTML(b, s.basePath())
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		_, _ = w.Write(b)
 	}), func(r *http.Request) bool {
@@ -414,7 +1025,7 @@ func (s *Server) Handler() http.Handler {
 				if s.shouldChallenge(r) {
 					s.authChallenge(w)
 				} else {
-					http.Redirect(w, r, "/unauthorized", http.StatusFound)
+					http.Redirect(w, r, s.basePath()+"/unauthorized", http.StatusFound)
 				}
 				return
 			}
@@ -423,6 +1034,9 @@ func (s *Server) Handler() http.Handler {
 				http.Error(w, "missing admin ui", http.StatusInternalServerError)
 				return
 			}
+			b = s.markCSRFHTML(b, w, r)
+			b = brandHTML(b, s.config().Branding)
+			b = basePathHTML(b, s.basePath())
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
 			_, _ = w.Write(b)
 		}), func(r *http.Request) bool { return r.URL.Path == "/admin" }))
@@ -438,47 +1052,94 @@ func (s *Server) Handler() http.Handler {
 			http.Error(w, "missing unauthorized ui", http.StatusInternalServerError)
 			return
 		}
+		b = brandHTML(b, s.config().Branding)
+		b = basePathHTML(b, s.basePath())
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		_, _ = w.Write(b)
 	}), func(r *http.Request) bool { return r.URL.Path == "/unauthorized" }))
 
 	// file serving with Range
 	inner.Handle("/f/", s.require(auth.PermRead, http.HandlerFunc(s.handleFile)))
+	inner.Handle("/api/sign", s.require(auth.PermRead, http.HandlerFunc(s.handleSign)))
+	inner.Handle("/api/session", http.HandlerFunc(s.handleSession))
+	inner.Handle("/api/csrf", http.HandlerFunc(s.handleCSRF))
+	inner.Handle("/api/info", http.HandlerFunc(s.handleInfo))
 
 	// thumbnails
-	inner.Handle("/thumb", s.require(auth.PermRead, http.HandlerFunc(s.handleThumb)))
+	inner.Handle("/thumb", s.feature(featThumbs, s.require(auth.PermRead, http.HandlerFunc(s.handleThumb))))
 
 	// api
 	inner.Handle("/api/list", s.require(auth.PermRead, http.HandlerFunc(s.handleList)))
-	inner.Handle("/api/search", s.require(auth.PermRead, http.HandlerFunc(s.handleSearch)))
+	inner.Handle("/api/search", s.feature(featSearch, s.require(auth.PermRead, http.HandlerFunc(s.handleSearch))))
+	inner.Handle("/api/timeline", s.require(auth.PermRead, http.HandlerFunc(s.handleTimeline)))
+	inner.Handle("/api/music", s.require(auth.PermRead, http.HandlerFunc(s.handleMusic)))
+	inner.Handle("/api/blob/", http.HandlerFunc(s.handleBlob))
+	inner.Handle("/api/playback", s.require(auth.PermRead, http.HandlerFunc(s.handlePlayback)))
+	inner.Handle("/api/playlist", s.require(auth.PermRead, http.HandlerFunc(s.handlePlaylist)))
+	inner.Handle("/api/audio", s.require(auth.PermRead, http.HandlerFunc(s.handleAudio)))
+	inner.Handle("/api/remux", s.require(auth.PermRead, http.HandlerFunc(s.handleRemux)))
+	inner.Handle("/api/preview", s.require(auth.PermRead, http.HandlerFunc(s.handlePreview)))
 	inner.Handle("/api/mkdir", http.HandlerFunc(s.handleMkdir))
 	inner.Handle("/api/rename", http.HandlerFunc(s.handleRename))
 	inner.Handle("/api/delete", http.HandlerFunc(s.handleDelete))
 	inner.Handle("/api/copy", http.HandlerFunc(s.handleCopy))
 	inner.Handle("/api/move", http.HandlerFunc(s.handleMove))
 	inner.Handle("/api/write", http.HandlerFunc(s.handleWrite))
+	inner.Handle("/api/batch", http.HandlerFunc(s.handleBatch))
+	inner.Handle("/api/read", s.require(auth.PermRead, http.HandlerFunc(s.handleRead)))
+	inner.Handle("/api/tail", s.require(auth.PermRead, http.HandlerFunc(s.handleTail)))
+	inner.Handle("/api/expiry", http.HandlerFunc(s.handleExpiry))
 	if !s.disableAdmin {
 		inner.Handle("/api/admin/bcrypt", http.HandlerFunc(s.handleAdminBcrypt))
 		inner.Handle("/api/admin/state", http.HandlerFunc(s.handleAdminState))
 		inner.Handle("/api/admin/config", http.HandlerFunc(s.handleAdminConfig))
 		inner.Handle("/api/admin/users", http.HandlerFunc(s.handleAdminUsers))
 		inner.Handle("/api/admin/tokens", http.HandlerFunc(s.handleAdminTokens))
-	}
-	inner.Handle("/api/upload", s.require(auth.PermWrite, http.HandlerFunc(s.handleMultipartUpload)))
+		inner.Handle("/api/admin/guests", http.HandlerFunc(s.handleAdminGuests))
+		inner.Handle("/api/admin/guests/qr", http.HandlerFunc(s.handleAdminGuestQR))
+		inner.Handle("/api/admin/scrub", http.HandlerFunc(s.handleAdminScrub))
+		inner.Handle("/api/admin/dedup/stats", http.HandlerFunc(s.handleAdminDedupStats))
+		inner.Handle("/api/admin/unlock", http.HandlerFunc(s.handleAdminUnlock))
+		inner.Handle("/api/admin/overview", http.HandlerFunc(s.handleAdminOverview))
+		inner.Handle("/api/admin/reindex", http.HandlerFunc(s.handleAdminReindex))
+		inner.Handle("/api/admin/jobs", http.HandlerFunc(s.handleAdminJobs))
+		inner.Handle("/api/admin/transfers", http.HandlerFunc(s.handleAdminTransfers))
+		inner.Handle("/api/admin/uploads", http.HandlerFunc(s.handleAdminUploads))
+		inner.Handle("/api/admin/stats/timeseries", http.HandlerFunc(s.handleAdminStats))
+		inner.Handle("/api/admin/wake", http.HandlerFunc(s.handleAdminWake))
+		inner.Handle("/api/admin/snapshots", http.HandlerFunc(s.handleAdminSnapshots))
+	}
+	inner.Handle("/api/upload", s.feature(featUploads, s.require(auth.PermWrite, http.HandlerFunc(s.handleMultipartUpload))))
 
 	// resumable uploads
-	inner.Handle("/api/uploads", s.require(auth.PermWrite, http.HandlerFunc(s.handleUploads)))
-	inner.Handle("/api/uploads/", http.HandlerFunc(s.handleUploadID))
+	inner.Handle("/api/uploads", s.feature(featUploads, http.HandlerFunc(s.handleUploads)))
+	inner.Handle("/api/uploads/precheck", s.feature(featUploads, http.HandlerFunc(s.handleUploadPrecheck)))
+	inner.Handle("/api/uploads/", s.feature(featUploads, http.HandlerFunc(s.handleUploadID)))
+
+	// one-time upload tickets (see ticket.go)
+	inner.Handle("/api/upload-tickets", s.feature(featUploads, http.HandlerFunc(s.handleUploadTickets)))
+	inner.Handle("/api/ticket/", s.feature(featUploads, http.HandlerFunc(s.handleTicket)))
+	inner.Handle("/t/", s.feature(featUploads, http.HandlerFunc(s.handleTicketPage)))
 
 	// zip (read) - supports multi-select downloads via POST
-	inner.Handle("/api/zip", http.HandlerFunc(s.handleZip))
+	inner.Handle("/api/zip", s.feature(featZip, http.HandlerFunc(s.handleZip)))
+	inner.Handle("/api/speedtest/", s.require(auth.PermRead, http.HandlerFunc(s.handleSpeedtest)))
+	inner.Handle("/api/manifest", s.require(auth.PermRead, http.HandlerFunc(s.handleManifest)))
+	inner.Handle("/api/changes", s.require(auth.PermRead, http.HandlerFunc(s.handleChanges)))
+	inner.Handle("/api/download-plan", s.require(auth.PermRead, http.HandlerFunc(s.handleDownloadPlan)))
+	inner.Handle("/api/verify", s.require(auth.PermRead, http.HandlerFunc(s.handleVerify)))
+	inner.Handle("/api/checksumlist", s.require(auth.PermRead, http.HandlerFunc(s.handleChecksumList)))
+	inner.Handle("/api/hashmap", s.require(auth.PermRead, http.HandlerFunc(s.handleHashMap)))
+	inner.Handle("/api/chunks", s.require(auth.PermRead, http.HandlerFunc(s.handleChunks)))
+	inner.Handle("/api/swarm", s.require(auth.PermRead, http.HandlerFunc(s.handleSwarm)))
+	inner.Handle("/api/zipsize", s.feature(featZip, http.HandlerFunc(s.handleZipSize)))
 	inner.Handle("/api/zipls", s.require(auth.PermRead, http.HandlerFunc(s.handleZipList)))
 	inner.Handle("/api/zipget", s.require(auth.PermRead, http.HandlerFunc(s.handleZipGet)))
 
 	// Share dispatcher: supports / (default) and /s/<share>/...
-	mux.Handle("/", s.dispatch(s.authWrap(inner)))
+	mux.Handle("/", s.brandErrors(s.dispatch(s.authWrap(s.csrfCheck(s.homes(inner))))))
 
-	return mux
+	return s.underBasePath(mux)
 }
 
 func (s *Server) dispatch(inner http.Handler) http.Handler {
@@ -490,7 +1151,7 @@ func (s *Server) dispatch(inner http.Handler) http.Handler {
 			if i < 0 {
 				// /s/<share> -> redirect to /s/<share>/
 				if rest != "" {
-					http.Redirect(w, r, "/s/"+rest+"/", http.StatusFound)
+					http.Redirect(w, r, s.basePath()+"/s/"+rest+"/", http.StatusFound)
 					return
 				}
 				http.NotFound(w, r)
@@ -501,17 +1162,32 @@ func (s *Server) dispatch(inner http.Handler) http.Handler {
 				http.NotFound(w, r)
 				return
 			}
-			if _, ok := s.cfg.Shares[share]; !ok {
+			if _, ok := s.config().Shares[share]; !ok && !s.isHomeShare(share) && !s.isSnapshotShare(share) {
 				http.NotFound(w, r)
 				return
 			}
 			// Strip /s/<share> prefix.
 			r2 := r.Clone(context.WithValue(r.Context(), shareKey, share))
 			r2.URL.Path = rest[i:] // includes leading "/"
+			if !s.guardEncryptedShare(w, r2, share) || !s.guardRAMShare(w, r2, share) || !s.guardMount(w, r2, share) {
+				return
+			}
+			s.maybeSweepExpired(r2)
+			s.maybePackBlobs(r2)
+			defer s.trackActivity(share)()
+			w, r2 = s.countTraffic(share, w, r2)
 			inner.ServeHTTP(w, r2)
 			return
 		}
-		inner.ServeHTTP(w, r.Clone(context.WithValue(r.Context(), shareKey, "")))
+		r2 := r.Clone(context.WithValue(r.Context(), shareKey, ""))
+		if !s.guardMount(w, r2, "") {
+			return
+		}
+		s.maybeSweepExpired(r2)
+		s.maybePackBlobs(r2)
+		defer s.trackActivity("")()
+		w, r2 = s.countTraffic("", w, r2)
+		inner.ServeHTTP(w, r2)
 	})
 }
 
@@ -543,12 +1219,41 @@ func (s *Server) require(perm auth.Perm, next http.Handler) http.Handler {
 func (s *Server) allowed(r *http.Request, perm auth.Perm, cleanPath string) (bool, error) {
 	user := auth.UserFromContext(r.Context())
 	cfg := s.cfgForReq(r)
-	return auth.Allowed(cfg, user, cleanPath, perm)
+	if share := shareFromContext(r.Context()); perm != auth.PermRead {
+		if _, real := cfg.Shares[share]; !real {
+			if _, _, snap := snapshotOf(share); snap {
+				return false, nil // snapshots are read-only
+			}
+		}
+	}
+	if perm == auth.PermWrite && cfg.ReadOnly {
+		return false, nil // deletes and expiry check it themselves
+	}
+	if du, ok := davUserFromContext(r.Context()); ok {
+		return perm == auth.PermRead || (perm == auth.PermWrite && du.Write), nil
+	}
+	if ok, decided := homeAllowed(cfg, shareFromContext(r.Context()), user, perm, cleanPath); decided {
+		return ok, nil
+	}
+	if s.policy == nil {
+		return auth.AllowedMember(cfg, user, auth.GroupsFromContext(r.Context()), cleanPath, perm)
+	}
+	return auth.AllowedRequest(cfg, s.accessRequest(r, perm, cleanPath), s.policy)
+}
+
+// accessRequest is the access question perm on cleanPath for r, as the
+// policy sees it.
+func (s *Server) accessRequest(r *http.Request, perm auth.Perm, cleanPath string) auth.Request {
+	return auth.Request{
+		User: auth.UserFromContext(r.Context()), Groups: auth.GroupsFromContext(r.Context()),
+		Path: cleanPath, Perm: perm, Share: shareFromContext(r.Context()),
+		IP: s.remoteHost(r), Time: time.Now(),
+	}
 }
 
 func (s *Server) shouldChallenge(r *http.Request) bool {
 	cfg := s.cfgForReq(r)
-	return (len(cfg.Users) > 0 || len(cfg.Tokens) > 0) && cfg.AuthOptional && auth.UserFromContext(r.Context()) == ""
+	return (len(cfg.Users) > 0 || len(cfg.Tokens) > 0 || cfg.OIDC != nil || cfg.LDAP != nil) && cfg.AuthOptional && auth.UserFromContext(r.Context()) == ""
 }
 
 func (s *Server) authChallenge(w http.ResponseWriter) {
@@ -559,11 +1264,58 @@ func (s *Server) authChallenge(w http.ResponseWriter) {
 func (s *Server) authWrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg := s.cfgForReq(r)
-		if len(cfg.Users) == 0 && len(cfg.Tokens) == 0 {
+		if len(cfg.Users) == 0 && len(cfg.Tokens) == 0 && cfg.OIDC == nil && cfg.LDAP == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
+		s.maybeSweepGuests()
 		authz := r.Header.Get("Authorization")
+		if queryTokenAllowed(r.URL.Path) && r.URL.Query().Has("access_token") {
+			if tok := r.URL.Query().Get("access_token"); tok != "" && strings.TrimSpace(authz) == "" {
+				authz = "Bearer " + tok
+			}
+			r = withoutAccessToken(r)
+		}
+		if strings.TrimSpace(authz) == "" && strings.HasPrefix(r.URL.Path, "/f/") && r.URL.Query().Get("sig") != "" {
+			sess, ok := s.signedURLUser(r)
+			if !ok {
+				http.Error(w, "invalid or expired link", http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(withSession(r.Context(), sess))
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, isTicket := ticketID(r.URL.Path); isTicket && strings.TrimSpace(authz) == "" {
+			sess, ok := s.ticketUser(r)
+			if !ok {
+				http.Error(w, "unknown or used upload link", http.StatusNotFound)
+				return
+			}
+			r = r.WithContext(withSession(r.Context(), sess))
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.TrimSpace(authz) == "" {
+			if sess, ok := s.liveSession(r); ok {
+				r = r.WithContext(withSession(r.Context(), sess))
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Signing in and out must work without credentials.
+			if r.URL.Path == "/login" || r.URL.Path == "/logout" || strings.HasPrefix(r.URL.Path, "/login/oidc") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Browsers opening a page go to the sign-in form rather than
+			// a Basic prompt.
+			if !cfg.AuthOptional && r.Method == http.MethodGet && (r.URL.Path == "/" || r.URL.Path == "/admin") &&
+				strings.Contains(r.Header.Get("Accept"), "text/html") {
+				q := url.Values{"next": {s.withSharePrefix(r, r.URL.Path)}}
+				http.Redirect(w, r, s.withSharePrefix(r, "/login")+"?"+q.Encode(), http.StatusSeeOther)
+				return
+			}
+		}
 		if cfg.AuthOptional && strings.TrimSpace(authz) == "" {
 			next.ServeHTTP(w, r)
 			return
@@ -575,12 +1327,13 @@ func (s *Server) authWrap(next http.Handler) http.Handler {
 				s.authChallenge(w)
 				return
 			}
-			user := cfg.Tokens[tok]
-			if user == "" {
+			user := auth.TokenUser(cfg, tok)
+			if user == "" || guestExpired(cfg, user) {
 				s.authChallenge(w)
 				return
 			}
-			r = r.WithContext(auth.WithUser(r.Context(), user))
+			s.tokenUse.touch(tok, s.remoteHost(r))
+			r = r.WithContext(context.WithValue(auth.WithUser(r.Context(), user), tokenKey, tok))
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -590,12 +1343,34 @@ func (s *Server) authWrap(next http.Handler) http.Handler {
 			s.authChallenge(w)
 			return
 		}
+		if du, ok := s.shareDavUser(r, u); ok {
+			if !auth.CheckPassword(du.Bcrypt, p) {
+				s.notifyLoginFailed(r, u)
+				s.authChallenge(w)
+				return
+			}
+			ctx := auth.WithUser(r.Context(), shareFromContext(r.Context())+":"+u)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, davUserKey, du)))
+			return
+		}
 		user, ok := cfg.Users[u]
-		if !ok {
+		if !ok && cfg.LDAP != nil {
+			name, groups, err := s.ldapLogin(cfg.LDAP, u, p)
+			if err != nil {
+				s.notifyLoginFailed(r, u)
+				s.authChallenge(w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withSession(r.Context(), session{User: name, Groups: groups, Via: "ldap"})))
+			return
+		}
+		if !ok || guestExpired(cfg, u) {
+			s.notifyLoginFailed(r, u)
 			s.authChallenge(w)
 			return
 		}
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Bcrypt), []byte(p)); err != nil {
+		if !auth.CheckPassword(user.Bcrypt, p) {
+			s.notifyLoginFailed(r, u)
 			s.authChallenge(w)
 			return
 		}
@@ -604,6 +1379,41 @@ func (s *Server) authWrap(next http.Handler) http.Handler {
 	})
 }
 
+// queryTokenAllowed reports whether ?access_token= may stand in for an
+// Authorization header on urlPath. Limited to what <img>/<video>/<audio>
+// tags, cast devices and playlist players fetch without custom headers:
+// downloads, thumbnails and the audio and remuxed video streams.
+func queryTokenAllowed(urlPath string) bool {
+	return strings.HasPrefix(urlPath, "/f/") || urlPath == "/thumb" || urlPath == "/api/audio" || urlPath == "/api/remux" ||
+		urlPath == "/api/session" // "open in browser" hand-off from the CLI
+}
+
+// withoutAccessToken drops ?access_token= from the request URL once it has
+// been consumed, so handlers never see, echo or record it.
+func withoutAccessToken(r *http.Request) *http.Request {
+	q := r.URL.Query()
+	q.Del("access_token")
+	r2 := r.Clone(r.Context())
+	r2.URL.RawQuery = q.Encode()
+	r2.RequestURI = r2.URL.RequestURI()
+	return r2
+}
+
+// requestToken returns the bearer token the request was authenticated
+// with, also when it came as ?access_token=, or "".
+func requestToken(r *http.Request) string {
+	tok, _ := r.Context().Value(tokenKey).(string)
+	return tok
+}
+
+// requestBearerToken returns the bearer token of the Authorization header, or "".
+func requestBearerToken(r *http.Request) string {
+	if authz := r.Header.Get("Authorization"); strings.HasPrefix(authz, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authz, "Bearer "))
+	}
+	return ""
+}
+
 func (s *Server) davPathToClean(urlPath string) string {
 	// /dav/foo/bar -> /foo/bar
 	p := strings.TrimPrefix(urlPath, "/dav")
@@ -620,6 +1430,7 @@ func (s *Server) davPathToClean(urlPath string) string {
 // --- handlers ---
 
 func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	w = s.throttleDown(w, r)
 	rel := fsutil.CleanRelPath(strings.TrimPrefix(r.URL.Path, "/f/"))
 	cfg := s.cfgForReq(r)
 	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
@@ -628,7 +1439,7 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	st, err := os.Stat(abs)
-	if err != nil {
+	if err != nil || s.expiredPath(r, rel) {
 		http.NotFound(w, r)
 		return
 	}
@@ -636,13 +1447,14 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "is a directory", http.StatusBadRequest)
 		return
 	}
-
-	f, err := os.Open(abs)
-	if err != nil {
-		http.Error(w, "open failed", http.StatusInternalServerError)
-		return
+	chunked := r.URL.Query().Has("chunk")
+	if chunked {
+		finish, ok := s.chunkRange(w, r, rel, abs, st)
+		if !ok {
+			return
+		}
+		defer finish()
 	}
-	defer f.Close()
 
 	ct := contentTypeForName(st.Name())
 	if ct != "" {
@@ -651,6 +1463,36 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Get("dl") == "1" {
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", st.Name()))
 	}
+
+	key := s.shareKey(r)
+	// Encrypted shares are decrypted here; the proxy cannot serve those.
+	// Nor chunks: it would take the client's Range, not the chunk's.
+	if key == nil && !chunked && s.proxySendfile(w, r, abs, rel) {
+		return
+	}
+	if r.Method != http.MethodHead {
+		release, ok := s.limit(w, r, limitDownloads)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	var f *os.File
+	if key == nil {
+		f = s.readCache(r).Open(abs, st)
+	}
+	if f == nil {
+		if f, err = os.Open(abs); err != nil {
+			http.Error(w, "open failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	defer f.Close()
+
+	if key != nil {
+		serveSealedFile(w, r, key, f, st, shareFromContext(r.Context()))
+		return
+	}
 	http.ServeContent(w, r, st.Name(), st.ModTime(), f)
 }
 
@@ -664,6 +1506,28 @@ type listItem struct {
 	Mtime  int64  `json:"mtime"`
 	Mime   string `json:"mime,omitempty"`
 	Thumb  string `json:"thumb,omitempty"`
+	// Preview is set when a preview plugin handles the file (/api/preview).
+	Preview bool `json:"preview,omitempty"`
+	// Width and Height are the display dimensions of images, with EXIF
+	// orientation applied, and of videos.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Duration (seconds) and Codec of videos whose container was probed.
+	Duration float64 `json:"duration,omitempty"`
+	Codec    string  `json:"codec,omitempty"`
+	// Checksum is "sha256" or "md5" when a sidecar sits next to the file;
+	// Verified is "ok" or "mismatch" once /api/verify checked this version.
+	Checksum string `json:"checksum,omitempty"`
+	Verified string `json:"verified,omitempty"`
+	// Verdict is what config.HashLookup said about this version of the
+	// file: "clean", "suspicious", "malicious" or "unknown".
+	Verdict       string `json:"verdict,omitempty"`
+	VerdictDetail string `json:"verdictDetail,omitempty"`
+	VerdictLink   string `json:"verdictLink,omitempty"`
+	// Expires is when the item goes to the trash (unix seconds, /api/expiry).
+	Expires int64 `json:"expires,omitempty"`
+	// Owner is the user who uploaded the file (see owners.go).
+	Owner string `json:"owner,omitempty"`
 }
 
 type readmeInfo struct {
@@ -673,6 +1537,10 @@ type readmeInfo struct {
 	Mtime int64  `json:"mtime"`
 }
 
+// handleList lists a directory.
+//
+//	GET /api/list?path=<dir>           -> {path, items, readme}
+//	GET /api/list?path=<dir>&stream=1  -> NDJSON, see streamList
 func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
 	cfg := s.cfgForReq(r)
@@ -682,7 +1550,7 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	st, err := os.Stat(abs)
-	if err != nil {
+	if err != nil || s.expiredPath(r, rel) {
 		http.NotFound(w, r)
 		return
 	}
@@ -690,57 +1558,26 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not a directory", http.StatusBadRequest)
 		return
 	}
+	sealedKey := s.shareKey(r)
+	if r.URL.Query().Get("stream") == "1" {
+		s.streamList(w, r, abs, rel, sealedKey)
+		return
+	}
 	ents, err := os.ReadDir(abs)
 	if err != nil {
 		http.Error(w, "read failed", http.StatusInternalServerError)
 		return
 	}
-	// optional README.md rendering in UI
-	var readme *readmeInfo
-	for _, cand := range []string{"README.md", "readme.md"} {
-		p := filepath.Join(abs, cand)
-		if st2, err := os.Stat(p); err == nil && st2.Mode().IsRegular() {
-			readme = &readmeInfo{
-				Path:  joinRel(rel, cand),
-				Name:  cand,
-				Size:  st2.Size(),
-				Mtime: st2.ModTime().Unix(),
-			}
-			break
-		}
-	}
+	exp, own, keep := s.expiries(r), s.owners(r), s.homesFilter(r, rel)
 	items := make([]listItem, 0, len(ents))
 	for _, e := range ents {
-		info, err := e.Info()
-		name := e.Name()
-		childRel := joinRel(rel, name)
-		childAbs := filepath.Join(abs, name)
-		isLink := (e.Type() & os.ModeSymlink) != 0
-		it := listItem{
-			Name:   name,
-			Path:   childRel,
-			IsDir:  e.IsDir(),
-			IsLink: isLink,
-		}
-		if info != nil && err == nil {
-			it.Size = info.Size()
-			it.Mtime = info.ModTime().Unix()
-		}
-		if isLink {
-			if lt, err := os.Readlink(childAbs); err == nil {
-				it.LinkTo = lt
-			}
-		}
-		if !it.IsDir {
-			ext := strings.ToLower(filepath.Ext(name))
-			it.Mime = contentTypeForName(name)
-			if isImageExt(ext) {
-				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(childRel))
-			} else if isTextExt(ext) && it.Size > 0 && it.Size <= 1024*1024 {
-				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(childRel)+"&t=txt")
-			}
+		if keep != nil && !keep(e.Name()) {
+			continue
+		}
+		if it := s.listEntry(r, abs, rel, e, sealedKey); exp.annotate(&it) {
+			own.annotate(&it)
+			items = append(items, it)
 		}
-		items = append(items, it)
 	}
 	sort.Slice(items, func(i, j int) bool {
 		if items[i].IsDir != items[j].IsDir {
@@ -751,174 +1588,144 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]any{
 		"path":   rel,
 		"items":  items,
-		"readme": readme,
+		"readme": findReadme(abs, rel, sealedKey),
 	})
 }
 
-func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
-	baseRel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
-	q := strings.TrimSpace(r.URL.Query().Get("q"))
-	if q == "" {
-		writeJSON(w, map[string]any{"items": []listItem{}, "seen": 0, "truncated": false})
-		return
-	}
-	cfg := s.cfgForReq(r)
-	baseAbs, err := fsutil.ResolveWithinRoot(cfg.Root, baseRel, cfg.FollowSymlinks)
+// streamList writes a directory as newline-delimited JSON while reading it:
+//
+//	{"path":"<dir>","readme":{...}|null}
+//	{<listItem>}                          one per entry, in directory order
+//	{"done":true,"count":N}               or {"done":true,"error":"..."}
+//
+// Entries are unsorted. A stream without the done line was cut short.
+func (s *Server) streamList(w http.ResponseWriter, r *http.Request, abs, rel string, sealedKey *sealed.Key) {
+	d, err := os.Open(abs)
 	if err != nil {
-		http.Error(w, "bad path", http.StatusBadRequest)
+		http.Error(w, "read failed", http.StatusInternalServerError)
 		return
 	}
-	// bounded search; scan hidden (dot) entries last for better UX
-	const maxHits = 500
-	const maxFiles = 200_000
-	hits := make([]listItem, 0, 64)
-	var seen int
-	var truncated bool
-	var truncReason string // "maxHits"|"maxFiles"
-	qlow := strings.ToLower(q)
-
-	type node struct {
-		abs string
-		rel string // slash-separated, "" for root
-	}
-	normalQ := make([]node, 0, 64)
-	hiddenQ := make([]node, 0, 64)
-	normalQ = append(normalQ, node{abs: baseAbs, rel: baseRel})
-
-	isHidden := func(name string) bool {
-		return strings.HasPrefix(name, ".")
+	defer d.Close()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	if err := enc.Encode(map[string]any{"path": rel, "readme": findReadme(abs, rel, sealedKey)}); err != nil {
+		return
 	}
-	pushDir := func(absDir, relDir, name string) {
-		// do not follow symlinks (avoid loops)
-		nrel := name
-		if relDir != "" {
-			nrel = relDir + "/" + name
+	exp, own, keep := s.expiries(r), s.owners(r), s.homesFilter(r, rel)
+	count := 0
+	for {
+		if r.Context().Err() != nil {
+			return
 		}
-		nabs := filepath.Join(absDir, name)
-		if isHidden(name) {
-			hiddenQ = append(hiddenQ, node{abs: nabs, rel: nrel})
-		} else {
-			normalQ = append(normalQ, node{abs: nabs, rel: nrel})
-		}
-	}
-	addHit := func(absPath string, rel string, d fs.DirEntry) {
-		name := d.Name()
-		info, _ := d.Info()
-		it := listItem{
-			Name:  name,
-			Path:  rel,
-			IsDir: d.IsDir(),
-			Size:  0,
-			Mtime: 0,
-		}
-		if info != nil {
-			it.Size = info.Size()
-			it.Mtime = info.ModTime().Unix()
-		}
-		if !it.IsDir {
-			ext := strings.ToLower(filepath.Ext(name))
-			it.Mime = contentTypeForName(name)
-			if isImageExt(ext) {
-				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(rel))
-			} else if isTextExt(ext) && it.Size > 0 && it.Size <= 1024*1024 {
-				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(rel)+"&t=txt")
+		ents, err := d.ReadDir(512)
+		for _, e := range ents {
+			if keep != nil && !keep(e.Name()) {
+				continue
+			}
+			it := s.listEntry(r, abs, rel, e, sealedKey)
+			if !exp.annotate(&it) {
+				continue
+			}
+			own.annotate(&it)
+			if err := enc.Encode(it); err != nil {
+				return
 			}
+			count++
 		}
-		hits = append(hits, it)
-	}
-
-	var errStop = errors.New("stop")
-	var errLimit = errors.New("limit")
-
-	// process normal dirs first, then hidden dirs
-	for len(normalQ) > 0 || len(hiddenQ) > 0 {
-		var n node
-		if len(normalQ) > 0 {
-			n = normalQ[0]
-			normalQ = normalQ[1:]
-		} else {
-			n = hiddenQ[0]
-			hiddenQ = hiddenQ[1:]
+		if flusher != nil {
+			flusher.Flush()
 		}
-
-		// count the directory node itself against maxFiles (similar to WalkDir behaviour)
-		seen++
-		if seen > maxFiles {
-			truncated = true
-			truncReason = "maxFiles"
+		if errors.Is(err, io.EOF) || (err == nil && len(ents) == 0) {
 			break
 		}
-
-		ents, err := os.ReadDir(n.abs)
 		if err != nil {
-			continue
+			_ = enc.Encode(map[string]any{"done": true, "error": "read failed"})
+			return
 		}
-		// ReadDir is already sorted; we just split into normal/hidden buckets.
-		normalEnts := make([]os.DirEntry, 0, len(ents))
-		hiddenEnts := make([]os.DirEntry, 0, 16)
-		for _, e := range ents {
-			if isHidden(e.Name()) {
-				hiddenEnts = append(hiddenEnts, e)
-			} else {
-				normalEnts = append(normalEnts, e)
-			}
-		}
-		processEnt := func(e os.DirEntry) error {
-			seen++
-			if seen > maxFiles {
-				truncated = true
-				truncReason = "maxFiles"
-				return errLimit
-			}
-			name := e.Name()
-			rel := name
-			if n.rel != "" {
-				rel = n.rel + "/" + name
-			}
-			// Match against the full relative path (not just basename).
-			if strings.Contains(strings.ToLower(rel), qlow) {
-				addHit(filepath.Join(n.abs, name), rel, e)
-				if len(hits) >= maxHits {
-					truncated = true
-					truncReason = "maxHits"
-					return errStop
-				}
+	}
+	_ = enc.Encode(map[string]any{"done": true, "count": count})
+}
+
+// findReadme returns the README.md of a directory for the UI, if any.
+func findReadme(abs, rel string, sealedKey *sealed.Key) *readmeInfo {
+	for _, cand := range []string{"README.md", "readme.md"} {
+		p := filepath.Join(abs, cand)
+		if st, err := os.Stat(p); err == nil && st.Mode().IsRegular() {
+			size := st.Size()
+			if sealedKey != nil {
+				size = sealed.PlainSize(size)
 			}
-			// queue dirs for later scanning
-			if e.IsDir() && (e.Type()&os.ModeSymlink) == 0 {
-				pushDir(n.abs, n.rel, name)
+			return &readmeInfo{
+				Path:  joinRel(rel, cand),
+				Name:  cand,
+				Size:  size,
+				Mtime: st.ModTime().Unix(),
 			}
-			return nil
 		}
+	}
+	return nil
+}
 
-		for _, e := range normalEnts {
-			if err := processEnt(e); err != nil {
-				if err == errStop || err == errLimit {
-					break
-				}
+// listEntry describes one directory entry of abs (rel) for /api/list.
+func (s *Server) listEntry(r *http.Request, abs, rel string, e os.DirEntry, sealedKey *sealed.Key) listItem {
+	info, err := e.Info()
+	name := e.Name()
+	childRel := joinRel(rel, name)
+	childAbs := filepath.Join(abs, name)
+	isLink := (e.Type() & os.ModeSymlink) != 0
+	it := listItem{
+		Name:   name,
+		Path:   childRel,
+		IsDir:  e.IsDir(),
+		IsLink: isLink,
+	}
+	if info != nil && err == nil {
+		it.Size = info.Size()
+		it.Mtime = info.ModTime().Unix()
+	}
+	if isLink {
+		if lt, err := os.Readlink(childAbs); err == nil {
+			it.LinkTo = lt
+		}
+	}
+	if !it.IsDir && sealedKey != nil {
+		// Encrypted share: report plaintext sizes; no server-side previews.
+		it.Mime = contentTypeForName(name)
+		it.Size = sealed.PlainSize(it.Size)
+	} else if !it.IsDir {
+		ext := strings.ToLower(filepath.Ext(name))
+		it.Mime = contentTypeForName(name)
+		if isImageExt(ext) && info != nil && info.Mode().IsRegular() {
+			pm := s.photoMeta(childAbs, info)
+			it.Width, it.Height = pm.w, pm.h
+		} else if isVideoExt(ext) && info != nil && info.Mode().IsRegular() {
+			if v := s.videoInfo(childAbs, info); v != nil {
+				it.Width, it.Height, it.Duration, it.Codec = v.Width, v.Height, v.Duration, v.Codec
 			}
 		}
-		if truncated {
-			break
+		if s.enabled(featThumbs) {
+			if isImageExt(ext) || ext == ".zip" {
+				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(childRel))
+			} else if isTextExt(ext) && it.Size > 0 && it.Size <= 1024*1024 {
+				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(childRel)+"&t=txt")
+			}
 		}
-		for _, e := range hiddenEnts {
-			if err := processEnt(e); err != nil {
-				if err == errStop || err == errLimit {
-					break
-				}
+		if s.previewerFor(ext) != nil {
+			it.Preview = true
+			if it.Thumb == "" && s.enabled(featThumbs) {
+				it.Thumb = s.withSharePrefix(r, "/thumb?path="+urlQueryEscape(childRel))
 			}
 		}
-		if truncated {
-			break
+		s.checksumInfo(&it, childAbs, info)
+		if s.config().HashLookup != nil {
+			vs, _ := s.metaStore(r, "verdicts")
+			verdictInfo(&it, vs, info)
 		}
 	}
-
-	writeJSON(w, map[string]any{
-		"items":     hits,
-		"seen":      seen,
-		"truncated": truncated,
-		"reason":    truncReason,
-	})
+	return it
 }
 
 func (s *Server) handleMkdir(w http.ResponseWriter, r *http.Request) {
@@ -970,7 +1777,15 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 	}
 	fromRel := fsutil.CleanRelPath(req.From)
 	toRel := fsutil.CleanRelPath(req.To)
-	if ok, err := s.allowed(r, auth.PermWrite, "/"+fromRel); err != nil || !ok {
+	// Owners may rename their own uploads under ownersManage, but only to
+	// a free name in such a place.
+	byOwner := false
+	ok, err := s.allowed(r, auth.PermWrite, "/"+fromRel)
+	if err == nil && !ok {
+		byOwner = s.ownsManaged(r, fromRel)
+		ok = byOwner
+	}
+	if err != nil || !ok {
 		if s.shouldChallenge(r) {
 			s.authChallenge(w)
 		} else {
@@ -978,7 +1793,13 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	if ok, err := s.allowed(r, auth.PermWrite, "/"+toRel); err != nil || !ok {
+	ok, err = s.allowed(r, auth.PermWrite, "/"+toRel)
+	toByOwner := false
+	if err == nil && !ok && byOwner {
+		toByOwner = s.ownersManageAt(r, toRel)
+		ok = toByOwner
+	}
+	if err != nil || !ok {
 		if s.shouldChallenge(r) {
 			s.authChallenge(w)
 		} else {
@@ -997,6 +1818,12 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad to", http.StatusBadRequest)
 		return
 	}
+	if toByOwner {
+		if _, err := os.Lstat(toAbs); err == nil {
+			http.Error(w, "destination exists", http.StatusConflict)
+			return
+		}
+	}
 	if err := os.MkdirAll(filepath.Dir(toAbs), 0o755); err != nil {
 		http.Error(w, "mkdir failed", http.StatusInternalServerError)
 		return
@@ -1005,6 +1832,7 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "rename failed", http.StatusInternalServerError)
 		return
 	}
+	s.recordRemoval(r, fromRel, toRel)
 	writeJSON(w, map[string]any{"ok": true})
 }
 
@@ -1021,7 +1849,15 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	rel := fsutil.CleanRelPath(req.Path)
-	if ok, err := s.allowed(r, auth.PermAdmin, "/"+rel); err != nil || !ok {
+	if s.cfgForReq(r).ReadOnly {
+		http.Error(w, "read-only", http.StatusForbidden)
+		return
+	}
+	ok, err := s.allowed(r, auth.PermAdmin, "/"+rel)
+	if err == nil && !ok {
+		ok = s.ownsManaged(r, rel) // owners only ever own files
+	}
+	if err != nil || !ok {
 		if s.shouldChallenge(r) {
 			s.authChallenge(w)
 		} else {
@@ -1039,6 +1875,7 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "delete failed", http.StatusInternalServerError)
 		return
 	}
+	s.recordRemoval(r, rel, "")
 	writeJSON(w, map[string]any{"ok": true})
 }
 
@@ -1051,6 +1888,10 @@ func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
 		Path    string `json:"path"`
 		Content string `json:"content"`
 		Mode    string `json:"mode,omitempty"` // overwrite|rename|skip|error
+		// IfMtime (unix seconds) and IfHash (sha256) make an overwrite
+		// conditional on the file being as last read; see textedit.go.
+		IfMtime int64  `json:"ifMtime,omitempty"`
+		IfHash  string `json:"ifHash,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "bad json", http.StatusBadRequest)
@@ -1069,7 +1910,12 @@ func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad mode", http.StatusBadRequest)
 		return
 	}
-	if len(req.Content) > 2*1024*1024 {
+	conditional := req.IfMtime != 0 || req.IfHash != ""
+	if conditional && mode != "overwrite" {
+		http.Error(w, "ifMtime and ifHash need mode overwrite", http.StatusBadRequest)
+		return
+	}
+	if len(req.Content) > maxEditSize {
 		http.Error(w, "too large", http.StatusRequestEntityTooLarge)
 		return
 	}
@@ -1087,6 +1933,11 @@ func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad path", http.StatusBadRequest)
 		return
 	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if conditional && !s.writePrecondition(w, abs, req.IfMtime, req.IfHash) {
+		return
+	}
 	if st, err := os.Stat(abs); err == nil {
 		if st.IsDir() {
 			http.Error(w, "is a directory", http.StatusBadRequest)
@@ -1126,17 +1977,24 @@ func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "mkdir failed", http.StatusInternalServerError)
 		return
 	}
-	tmp := abs + fmt.Sprintf(".tmp-%d", time.Now().UnixNano())
+	spool := s.spoolDir(r)
+	_ = os.MkdirAll(spool, 0o755)
+	tmp := filepath.Join(spool, fmt.Sprintf("wr-%d.tmp", time.Now().UnixNano()))
 	if err := os.WriteFile(tmp, []byte(req.Content), 0o644); err != nil {
 		http.Error(w, "write failed", http.StatusInternalServerError)
 		return
 	}
-	if err := os.Rename(tmp, abs); err != nil {
-		_ = os.Remove(tmp)
+	sum := sha256.Sum256([]byte(req.Content))
+	sha, size, err := s.commitBlob(r, tmp, hex.EncodeToString(sum[:]), rel, abs)
+	if err != nil {
 		http.Error(w, "write failed", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, map[string]any{"ok": true, "path": rel})
+	var mtime int64
+	if st, err := os.Stat(abs); err == nil {
+		mtime = st.ModTime().Unix()
+	}
+	writeJSON(w, map[string]any{"ok": true, "path": rel, "sha256": sha, "size": size, "mtime": mtime})
 }
 
 func (s *Server) handleAdminBcrypt(w http.ResponseWriter, r *http.Request) {
@@ -1194,13 +2052,11 @@ func (s *Server) adminOnly(w http.ResponseWriter, r *http.Request) bool {
 }
 
 func (s *Server) persistConfig(cfg config.Config) error {
-	s.cfgMu.RLock()
 	path := s.cfgPath
-	s.cfgMu.RUnlock()
 	if strings.TrimSpace(path) == "" {
 		return nil
 	}
-	b, err := json.MarshalIndent(cfg, "", "  ")
+	b, err := json.MarshalIndent(s.withoutFileUsers(cfg), "", "  ")
 	if err != nil {
 		return err
 	}
@@ -1208,7 +2064,12 @@ func (s *Server) persistConfig(cfg config.Config) error {
 	if err := os.WriteFile(tmp, b, 0o644); err != nil {
 		return err
 	}
-	return os.Rename(tmp, path)
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// Callers hold cfgMu (see updateConfig).
+	s.cfgSum = sha256.Sum256(b)
+	return nil
 }
 
 func (s *Server) handleAdminState(w http.ResponseWriter, r *http.Request) {
@@ -1220,29 +2081,12 @@ func (s *Server) handleAdminState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	cfg := s.cfgForReq(r)
-	type tok struct {
-		TokenPrefix string `json:"tokenPrefix"`
-		User        string `json:"user"`
-	}
 	users := make([]string, 0, len(cfg.Users))
 	for u := range cfg.Users {
 		users = append(users, u)
 	}
 	sort.Strings(users)
-	toks := make([]tok, 0, len(cfg.Tokens))
-	for t, u := range cfg.Tokens {
-		p := t
-		if len(p) > 8 {
-			p = p[:8]
-		}
-		toks = append(toks, tok{TokenPrefix: p, User: u})
-	}
-	sort.Slice(toks, func(i, j int) bool {
-		if toks[i].User != toks[j].User {
-			return toks[i].User < toks[j].User
-		}
-		return toks[i].TokenPrefix < toks[j].TokenPrefix
-	})
+	toks := s.tokenViews(cfg)
 	writeJSON(w, map[string]any{
 		"users":      users,
 		"tokens":     toks,
@@ -1251,10 +2095,119 @@ func (s *Server) handleAdminState(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleAdminScrub re-hashes the share's blob store and cross-checks files
+// hardlinked into the share (POST /api/admin/scrub). It runs synchronously;
+// expect it to take a while on large stores.
+func (s *Server) handleAdminScrub(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.adminOnly(w, r) {
+		return
+	}
+	store, _, err := s.shareDeps(r)
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+	ctx, job := s.startJob(r.Context(), "scrub", shareFromContext(r.Context()), auth.UserFromContext(r.Context()), nil)
+	rep, err := store.Scrub(ctx, s.cfgForReq(r).Root)
+	s.endJob(job, err)
+	if err != nil {
+		http.Error(w, "scrub failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"ok":     rep.OK(),
+		"report": rep,
+	})
+}
+
+// handleAdminDedupStats reports how much space the share's blob store
+// saves (GET /api/admin/dedup/stats?top=20). Blobs are only stat'ed, so it
+// is cheap to poll.
+func (s *Server) handleAdminDedupStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.adminOnly(w, r) {
+		return
+	}
+	top := 20
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "bad top", http.StatusBadRequest)
+			return
+		}
+		top = min(n, 1000)
+	}
+	store, _, err := s.shareDeps(r)
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+	st, err := store.Stats(r.Context(), top)
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "stats failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, st)
+}
+
+// blobPackEvery is how often a share's idle blobs are chunked or
+// compressed.
+const blobPackEvery = time.Hour
+
+// maybePackBlobs chunks and compresses the idle blobs of the request's
+// share in the background, at most once per blobPackEvery, when
+// dedupChunkKiB or compressBlobs is on.
+func (s *Server) maybePackBlobs(r *http.Request) {
+	share := shareFromContext(r.Context())
+	cfg := s.cfgForReq(r)
+	if _, _, ok := snapshotOf(share); ok || (cfg.DedupChunkKiB <= 0 && !cfg.CompressBlobs) {
+		return
+	}
+	now := time.Now()
+	if last, ok := s.blobsPacked.Load(share); ok && now.Sub(last.(time.Time)) < blobPackEvery {
+		return
+	}
+	s.blobsPacked.Store(share, now)
+	store, _, err := s.shareDeps(r)
+	if err != nil {
+		return
+	}
+	go func() {
+		if cfg.DedupChunkKiB > 0 {
+			rep, err := store.ChunkIdle(context.Background(), cfg.DedupChunkKiB<<10, cfg.CompressBlobs)
+			if err != nil {
+				log.Printf("chunk blobs %q: %v", share, err)
+			} else if rep.Blobs > 0 || rep.Removed > 0 {
+				log.Printf("chunk blobs %q: %d blobs, %d new chunks, %d bytes saved, %d chunks removed", share, rep.Blobs, rep.Chunks, rep.Saved, rep.Removed)
+			}
+		}
+		if !cfg.CompressBlobs {
+			return
+		}
+		// What chunking left plain, such as blobs smaller than two chunks.
+		rep, err := store.CompressIdle(context.Background())
+		if err != nil {
+			log.Printf("compress blobs %q: %v", share, err)
+		} else if rep.Blobs > 0 {
+			log.Printf("compress blobs %q: %d blobs, %d bytes saved", share, rep.Blobs, rep.Saved)
+		}
+	}()
+}
+
 type adminConfigPayload struct {
 	Root           string                  `json:"root"`
 	StateDir       string                  `json:"stateDir"`
 	FollowSymlinks bool                    `json:"followSymlinks"`
+	ReadOnly       bool                    `json:"readOnly"`
 	AuthOptional   bool                    `json:"authOptional"`
 	ACLs           []config.ACL            `json:"acls"`
 	Shares         map[string]config.Share `json:"shares"`
@@ -1266,11 +2219,8 @@ func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
 	}
 	switch r.Method {
 	case http.MethodGet:
-		s.cfgMu.RLock()
-		cfg := s.cfg
-		s.cfgMu.RUnlock()
 		writeJSON(w, map[string]any{
-			"config":     makeAdminConfigPayload(cfg),
+			"config":     makeAdminConfigPayload(*s.config()),
 			"persisted":  strings.TrimSpace(s.cfgPath) != "",
 			"configPath": s.cfgPath,
 		})
@@ -1280,30 +2230,32 @@ func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "bad json", http.StatusBadRequest)
 			return
 		}
-
-		s.cfgMu.RLock()
-		cfg := s.cfg
-		s.cfgMu.RUnlock()
-
-		cfg.Root = strings.TrimSpace(req.Root)
-		cfg.StateDir = strings.TrimSpace(req.StateDir)
-		cfg.AuthOptional = req.AuthOptional
-		cfg.FollowSymlinks = req.FollowSymlinks
-		cfg.ACLs = normalizeACLs(req.ACLs)
-		cfg.Shares = cloneShareMap(req.Shares)
-
-		normalized, err := normalizeConfig(cfg)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if r.URL.Query().Get("dryRun") == "1" {
+			s.adminConfigDryRun(w, req)
 			return
 		}
-		if err := s.persistConfig(normalized); err != nil {
-			http.Error(w, fmt.Sprintf("persist config: %v", err), http.StatusInternalServerError)
+
+		status := http.StatusBadRequest
+		normalized, err := s.updateConfig(func(cfg *config.Config) error {
+			applyAdminConfig(cfg, req)
+			normalized, err := normalizeConfig(*cfg)
+			if err != nil {
+				return err
+			}
+			if err := checkACLPaths(normalized); err != nil {
+				return err
+			}
+			if err := s.persistConfig(normalized); err != nil {
+				status = http.StatusInternalServerError
+				return fmt.Errorf("persist config: %w", err)
+			}
+			*cfg = normalized
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), status)
 			return
 		}
-		s.cfgMu.Lock()
-		s.cfg = normalized
-		s.cfgMu.Unlock()
 		s.resetShareCaches()
 
 		writeJSON(w, map[string]any{
@@ -1337,6 +2289,10 @@ func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "bad username", http.StatusBadRequest)
 			return
 		}
+		if s.config().Homes != nil && !validHomeName(u) {
+			http.Error(w, "bad username", http.StatusBadRequest)
+			return
+		}
 		if req.Password == "" {
 			http.Error(w, "missing password", http.StatusBadRequest)
 			return
@@ -1354,15 +2310,26 @@ func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "bcrypt failed", http.StatusInternalServerError)
 			return
 		}
-		s.cfgMu.Lock()
-		cfg := s.cfg
-		if cfg.Users == nil {
-			cfg.Users = map[string]config.User{}
+		_, err = s.updateConfig(func(cfg *config.Config) error {
+			// The home is in place before the user exists, so a failed
+			// copy leaves no user behind.
+			if cfg.Homes != nil {
+				if err := s.ensureHome(*cfg, u); err != nil {
+					return err
+				}
+				cfg.ACLs = addACLs(cfg.ACLs, homeACLs(cfg.Homes.ACLs, u))
+			}
+			if cfg.Users == nil {
+				cfg.Users = map[string]config.User{}
+			}
+			cfg.Users[u] = config.User{Bcrypt: string(h)}
+			_ = s.persistConfig(*cfg)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "creating home failed", http.StatusInternalServerError)
+			return
 		}
-		cfg.Users[u] = config.User{Bcrypt: string(h)}
-		s.cfg = cfg
-		s.cfgMu.Unlock()
-		_ = s.persistConfig(cfg)
 		writeJSON(w, map[string]any{"ok": true, "username": u, "bcrypt": string(h), "persisted": strings.TrimSpace(s.cfgPath) != ""})
 	case http.MethodDelete:
 		var req struct {
@@ -1373,95 +2340,37 @@ func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		u := strings.TrimSpace(req.Username)
-		s.cfgMu.Lock()
-		cfg := s.cfg
-		if cfg.Users != nil {
+		_, _ = s.updateConfig(func(cfg *config.Config) error {
 			delete(cfg.Users, u)
-		}
-		// also revoke any tokens for this user
-		if cfg.Tokens != nil {
+			if cfg.Homes != nil {
+				// The home itself stays; only its grants go.
+				cfg.ACLs = removeACLs(cfg.ACLs, homeACLs(cfg.Homes.ACLs, u))
+			}
+			// also revoke any tokens for this user
 			for t, tu := range cfg.Tokens {
 				if tu == u {
 					delete(cfg.Tokens, t)
+					delete(cfg.TokenLabels, t)
 				}
 			}
-		}
-		s.cfg = cfg
-		s.cfgMu.Unlock()
-		_ = s.persistConfig(cfg)
+			_ = s.persistConfig(*cfg)
+			return nil
+		})
 		writeJSON(w, map[string]any{"ok": true, "persisted": strings.TrimSpace(s.cfgPath) != ""})
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
-	if !s.adminOnly(w, r) {
-		return
-	}
-	switch r.Method {
-	case http.MethodPost:
-		var req struct {
-			Username string `json:"username"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad json", http.StatusBadRequest)
-			return
-		}
-		u := strings.TrimSpace(req.Username)
-		if u == "" {
-			http.Error(w, "missing username", http.StatusBadRequest)
-			return
-		}
-		// Require that the user exists (so ACL logic makes sense).
-		cfg := s.cfgForReq(r)
-		if _, ok := cfg.Users[u]; !ok {
-			http.Error(w, "unknown user", http.StatusBadRequest)
-			return
-		}
-		// generate token
-		var b [24]byte
-		if _, err := rand.Read(b[:]); err != nil {
-			http.Error(w, "token failed", http.StatusInternalServerError)
-			return
-		}
-		tok := base64.RawURLEncoding.EncodeToString(b[:])
-
-		s.cfgMu.Lock()
-		cfg = s.cfg
-		if cfg.Tokens == nil {
-			cfg.Tokens = map[string]string{}
-		}
-		cfg.Tokens[tok] = u
-		s.cfg = cfg
-		s.cfgMu.Unlock()
-		_ = s.persistConfig(cfg)
-		writeJSON(w, map[string]any{"ok": true, "token": tok, "username": u, "persisted": strings.TrimSpace(s.cfgPath) != ""})
-	case http.MethodDelete:
-		var req struct {
-			Token string `json:"token"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad json", http.StatusBadRequest)
-			return
-		}
-		tok := strings.TrimSpace(req.Token)
-		if tok == "" {
-			http.Error(w, "missing token", http.StatusBadRequest)
-			return
-		}
-		s.cfgMu.Lock()
-		cfg := s.cfg
-		if cfg.Tokens != nil {
-			delete(cfg.Tokens, tok)
-		}
-		s.cfg = cfg
-		s.cfgMu.Unlock()
-		_ = s.persistConfig(cfg)
-		writeJSON(w, map[string]any{"ok": true, "persisted": strings.TrimSpace(s.cfgPath) != ""})
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-	}
+// applyAdminConfig copies the settings the admin UI edits into cfg.
+func applyAdminConfig(cfg *config.Config, req adminConfigPayload) {
+	cfg.Root = strings.TrimSpace(req.Root)
+	cfg.StateDir = strings.TrimSpace(req.StateDir)
+	cfg.AuthOptional = req.AuthOptional
+	cfg.FollowSymlinks = req.FollowSymlinks
+	cfg.ReadOnly = req.ReadOnly
+	cfg.ACLs = normalizeACLs(req.ACLs)
+	cfg.Shares = cloneShareMap(req.Shares)
 }
 
 func makeAdminConfigPayload(cfg config.Config) adminConfigPayload {
@@ -1469,6 +2378,7 @@ func makeAdminConfigPayload(cfg config.Config) adminConfigPayload {
 		Root:           cfg.Root,
 		StateDir:       cfg.StateDir,
 		FollowSymlinks: cfg.FollowSymlinks,
+		ReadOnly:       cfg.ReadOnly,
 		AuthOptional:   cfg.AuthOptional,
 		ACLs:           cloneACLs(cfg.ACLs),
 		Shares:         cloneShareMap(cfg.Shares),
@@ -1483,6 +2393,7 @@ func cloneShareMap(in map[string]config.Share) map[string]config.Share {
 	for name, sh := range in {
 		cp := sh
 		cp.ACLs = cloneACLs(sh.ACLs)
+		cp.DavUsers = maps.Clone(sh.DavUsers)
 		out[name] = cp
 	}
 	return out
@@ -1495,15 +2406,46 @@ func cloneACLs(in []config.ACL) []config.ACL {
 	out := make([]config.ACL, len(in))
 	for i, a := range in {
 		out[i] = config.ACL{
-			Path:  a.Path,
-			Read:  cloneStringSlice(a.Read),
-			Write: cloneStringSlice(a.Write),
-			Admin: cloneStringSlice(a.Admin),
+			Path:         a.Path,
+			Read:         cloneStringSlice(a.Read),
+			Write:        cloneStringSlice(a.Write),
+			Admin:        cloneStringSlice(a.Admin),
+			DenyRead:     cloneStringSlice(a.DenyRead),
+			DenyWrite:    cloneStringSlice(a.DenyWrite),
+			DenyAdmin:    cloneStringSlice(a.DenyAdmin),
+			OwnersManage: a.OwnersManage,
 		}
 	}
 	return out
 }
 
+// checkACLPaths compiles the glob and regex ACL paths of cfg, its shares
+// and its homes.
+func checkACLPaths(cfg config.Config) error {
+	check := func(acls []config.ACL) error {
+		for i, a := range acls {
+			if err := auth.CheckACLPath(a.Path); err != nil {
+				return fmt.Errorf("[%d] path %q: %w", i, a.Path, err)
+			}
+		}
+		return nil
+	}
+	if err := check(cfg.ACLs); err != nil {
+		return fmt.Errorf("acls%w", err)
+	}
+	for name, sh := range cfg.Shares {
+		if err := check(sh.ACLs); err != nil {
+			return fmt.Errorf("shares.%s.acls%w", name, err)
+		}
+	}
+	if cfg.Homes != nil {
+		if err := check(homeACLs(cfg.Homes.ACLs, "user")); err != nil {
+			return fmt.Errorf("homes.acls%w", err)
+		}
+	}
+	return nil
+}
+
 func cloneStringSlice(in []string) []string {
 	if len(in) == 0 {
 		return nil
@@ -1520,16 +2462,22 @@ func normalizeACLs(in []config.ACL) []config.ACL {
 	out := make([]config.ACL, 0, len(in))
 	for _, acl := range in {
 		path := strings.TrimSpace(acl.Path)
-		if path == "" || path == "/" {
+		switch {
+		case strings.HasPrefix(path, auth.ACLRegexPrefix):
+		case path == "" || path == "/":
 			path = "/"
-		} else {
+		default:
 			path = "/" + strings.Trim(strings.Trim(path, " "), "/")
 		}
 		out = append(out, config.ACL{
-			Path:  path,
-			Read:  cleanStringSlice(acl.Read),
-			Write: cleanStringSlice(acl.Write),
-			Admin: cleanStringSlice(acl.Admin),
+			Path:         path,
+			Read:         cleanStringSlice(acl.Read),
+			Write:        cleanStringSlice(acl.Write),
+			Admin:        cleanStringSlice(acl.Admin),
+			DenyRead:     cleanStringSlice(acl.DenyRead),
+			DenyWrite:    cleanStringSlice(acl.DenyWrite),
+			DenyAdmin:    cleanStringSlice(acl.DenyAdmin),
+			OwnersManage: acl.OwnersManage,
 		})
 	}
 	return out
@@ -1554,8 +2502,15 @@ func cleanStringSlice(in []string) []string {
 }
 
 func normalizeConfig(cfg config.Config) (config.Config, error) {
+	return normalizeConfigDirs(cfg, true)
+}
+
+// normalizeConfigDirs is normalizeConfig, creating the state dirs only if
+// mkdir is set (a dry run leaves the disk alone).
+func normalizeConfigDirs(cfg config.Config, mkdir bool) (config.Config, error) {
 	cfg.Root = strings.TrimSpace(cfg.Root)
 	cfg.StateDir = strings.TrimSpace(cfg.StateDir)
+	cfg.BasePath = cleanBasePath(cfg.BasePath)
 	if cfg.Root == "" && len(cfg.Shares) == 0 {
 		return cfg, errors.New("configure a root path or at least one share")
 	}
@@ -1576,7 +2531,7 @@ func normalizeConfig(cfg config.Config) (config.Config, error) {
 				return cfg, fmt.Errorf("abs state dir: %w", err)
 			}
 		}
-		if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		if err := mkdirState(stateDir, mkdir); err != nil {
 			return cfg, fmt.Errorf("state dir: %w", err)
 		}
 		cfg.StateDir = stateDir
@@ -1585,14 +2540,14 @@ func normalizeConfig(cfg config.Config) (config.Config, error) {
 		if err != nil {
 			return cfg, fmt.Errorf("abs state dir: %w", err)
 		}
-		if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		if err := mkdirState(stateDir, mkdir); err != nil {
 			return cfg, fmt.Errorf("state dir: %w", err)
 		}
 		cfg.StateDir = stateDir
 	}
 
 	cfg.ACLs = normalizeACLs(cfg.ACLs)
-	shares, err := normalizeShares(cfg.Shares)
+	shares, err := normalizeShares(cfg.Shares, mkdir)
 	if err != nil {
 		return cfg, err
 	}
@@ -1600,7 +2555,7 @@ func normalizeConfig(cfg config.Config) (config.Config, error) {
 	return cfg, nil
 }
 
-func normalizeShares(in map[string]config.Share) (map[string]config.Share, error) {
+func normalizeShares(in map[string]config.Share, mkdir bool) (map[string]config.Share, error) {
 	if len(in) == 0 {
 		return map[string]config.Share{}, nil
 	}
@@ -1617,6 +2572,10 @@ func normalizeShares(in map[string]config.Share) (map[string]config.Share, error
 			return nil, fmt.Errorf("duplicate share name %q", name)
 		}
 
+		if sh.RAM != nil {
+			sh.Root = RAMShareRoot(name)
+			sh.StateDir = ""
+		}
 		root := strings.TrimSpace(sh.Root)
 		if root == "" {
 			return nil, fmt.Errorf("share %q: missing root", name)
@@ -1636,7 +2595,7 @@ func normalizeShares(in map[string]config.Share) (map[string]config.Share, error
 				return nil, fmt.Errorf("share %q: abs state dir: %w", name, err)
 			}
 		}
-		if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		if err := mkdirState(stateDir, mkdir); err != nil {
 			return nil, fmt.Errorf("share %q: state dir: %w", name, err)
 		}
 		sh.StateDir = stateDir
@@ -1646,12 +2605,47 @@ func normalizeShares(in map[string]config.Share) (map[string]config.Share, error
 	return out, nil
 }
 
+func mkdirState(dir string, mkdir bool) error {
+	if !mkdir {
+		return nil
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+// resetShareCaches drops the cached store, upload manager and WebDAV
+// locks of each share whose config they were built for changed (or that
+// is gone), after a config change. Every other share keeps them, so its
+// uploads in flight and WebDAV locks carry on; its manager only picks up
+// the new upload settings.
 func (s *Server) resetShareCaches() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.dedup = map[string]*dedup.Store{}
-	s.uploads = map[string]*upload.Manager{}
-	s.davLocks = map[string]webdav.LockSystem{}
+	var old []*upload.Manager
+	for key, built := range s.depsBuilt {
+		cfg := s.shareCfg(key)
+		if depsKeyFor(cfg, key) == built {
+			s.uploads[key].SetPreallocate(cfg.PreallocateUploads)
+			s.uploads[key].SetSync(cfg.UploadSync)
+			continue
+		}
+		old = append(old, s.uploads[key])
+		delete(s.dedup, key)
+		delete(s.uploads, key)
+		delete(s.depsBuilt, key)
+	}
+	for key, root := range s.davRoots {
+		if s.shareCfg(key).Root != root {
+			delete(s.davLocks, key)
+			delete(s.davRoots, key)
+		}
+	}
+	s.mu.Unlock()
+	// Release part files held open by the old managers once their
+	// in-flight chunks are done.
+	go func() {
+		for _, up := range old {
+			up.Close()
+		}
+	}()
 }
 
 func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
@@ -1709,10 +2703,22 @@ func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
 	type outItem struct {
 		From   string `json:"from"`
 		To     string `json:"to"`
-		Status string `json:"status"` // ok|skipped|renamed|overwritten
+		Status string `json:"status"` // ok|skipped|renamed|overwritten|error
+		Error  string `json:"error,omitempty"`
 	}
+	// Failures after the permission checks are reported per item and the
+	// rest of the batch still runs; a disconnect stops it without a reply.
+	ctx := r.Context()
 	out := make([]outItem, 0, len(req.Paths))
+	failed := false
+	fail := func(from, to, msg string) {
+		out = append(out, outItem{From: from, To: to, Status: "error", Error: msg})
+		failed = true
+	}
 	for _, p := range req.Paths {
+		if ctx.Err() != nil {
+			return
+		}
 		srcRel := fsutil.CleanRelPath(p)
 		if srcRel == "" {
 			continue
@@ -1729,25 +2735,25 @@ func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
 
 		srcAbs, err := fsutil.ResolveWithinRoot(cfg.Root, srcRel, cfg.FollowSymlinks)
 		if err != nil {
-			http.Error(w, "bad path", http.StatusBadRequest)
-			return
+			fail(srcRel, "", "bad path")
+			continue
 		}
 		st, err := os.Stat(srcAbs)
 		if err != nil {
-			http.NotFound(w, r)
-			return
+			fail(srcRel, "", "not found")
+			continue
 		}
 		base := filepath.Base(srcRel)
 		if base == "" || base == "." || base == "/" {
-			http.Error(w, "bad name", http.StatusBadRequest)
-			return
+			fail(srcRel, "", "bad name")
+			continue
 		}
 		dstName := base
 		dstRel := joinRel(destDirRel, dstName)
 		dstAbs, err := fsutil.ResolveWithinRoot(cfg.Root, dstRel, cfg.FollowSymlinks)
 		if err != nil {
-			http.Error(w, "bad dest", http.StatusBadRequest)
-			return
+			fail(srcRel, dstRel, "bad dest")
+			continue
 		}
 		// Require write permission on destination path.
 		if ok, err := s.allowed(r, auth.PermWrite, "/"+dstRel); err != nil || !ok {
@@ -1770,20 +2776,20 @@ func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
 				out = append(out, outItem{From: srcRel, To: dstRel, Status: "skipped"})
 				continue
 			case "error":
-				http.Error(w, "destination exists", http.StatusConflict)
-				return
+				fail(srcRel, dstRel, "destination exists")
+				continue
 			case "rename":
 				nm, err := uniqueNameInDir(destDirAbs, dstName)
 				if err != nil {
-					http.Error(w, "copy failed", http.StatusInternalServerError)
-					return
+					fail(srcRel, dstRel, "copy failed")
+					continue
 				}
 				dstName = nm
 				dstRel = joinRel(destDirRel, dstName)
 				dstAbs, err = fsutil.ResolveWithinRoot(cfg.Root, dstRel, cfg.FollowSymlinks)
 				if err != nil {
-					http.Error(w, "bad dest", http.StatusBadRequest)
-					return
+					fail(srcRel, dstRel, "bad dest")
+					continue
 				}
 				status = "renamed"
 			case "overwrite":
@@ -1792,27 +2798,28 @@ func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := validateTransferTargets(st, srcAbs, dstAbs); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			fail(srcRel, dstRel, err.Error())
+			continue
 		}
 		if st.IsDir() {
-			if err := copyDirNoSymlinks(srcAbs, dstAbs, mode == "overwrite"); err != nil {
-				http.Error(w, "copy failed", http.StatusInternalServerError)
-				return
-			}
+			err = copyDirNoSymlinks(ctx, srcAbs, dstAbs, mode == "overwrite")
 		} else {
-			if err := copyFileAtomic(srcAbs, dstAbs, mode == "overwrite"); err != nil {
-				if errors.Is(err, os.ErrExist) {
-					http.Error(w, "destination exists", http.StatusConflict)
-					return
-				}
-				http.Error(w, "copy failed", http.StatusInternalServerError)
+			err = copyFileAtomic(ctx, srcAbs, dstAbs, mode == "overwrite")
+		}
+		if err != nil {
+			if ctx.Err() != nil {
 				return
 			}
+			if errors.Is(err, os.ErrExist) {
+				fail(srcRel, dstRel, "destination exists")
+			} else {
+				fail(srcRel, dstRel, "copy failed")
+			}
+			continue
 		}
 		out = append(out, outItem{From: srcRel, To: dstRel, Status: status})
 	}
-	writeJSON(w, map[string]any{"ok": true, "items": out})
+	writeJSON(w, map[string]any{"ok": !failed, "items": out})
 }
 
 func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
@@ -1870,10 +2877,22 @@ func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
 	type outItem struct {
 		From   string `json:"from"`
 		To     string `json:"to"`
-		Status string `json:"status"` // ok|skipped|renamed|overwritten
+		Status string `json:"status"` // ok|skipped|renamed|overwritten|error
+		Error  string `json:"error,omitempty"`
 	}
+	// Failures after the permission checks are reported per item and the
+	// rest of the batch still runs; a disconnect stops it without a reply.
+	ctx := r.Context()
 	out := make([]outItem, 0, len(req.Paths))
+	failed := false
+	fail := func(from, to, msg string) {
+		out = append(out, outItem{From: from, To: to, Status: "error", Error: msg})
+		failed = true
+	}
 	for _, p := range req.Paths {
+		if ctx.Err() != nil {
+			return
+		}
 		srcRel := fsutil.CleanRelPath(p)
 		if srcRel == "" {
 			continue
@@ -1889,25 +2908,25 @@ func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
 		}
 		srcAbs, err := fsutil.ResolveWithinRoot(cfg.Root, srcRel, cfg.FollowSymlinks)
 		if err != nil {
-			http.Error(w, "bad path", http.StatusBadRequest)
-			return
+			fail(srcRel, "", "bad path")
+			continue
 		}
 		st, err := os.Stat(srcAbs)
 		if err != nil {
-			http.NotFound(w, r)
-			return
+			fail(srcRel, "", "not found")
+			continue
 		}
 		base := filepath.Base(srcRel)
 		if base == "" || base == "." || base == "/" {
-			http.Error(w, "bad name", http.StatusBadRequest)
-			return
+			fail(srcRel, "", "bad name")
+			continue
 		}
 		dstName := base
 		dstRel := joinRel(destDirRel, dstName)
 		dstAbs, err := fsutil.ResolveWithinRoot(cfg.Root, dstRel, cfg.FollowSymlinks)
 		if err != nil {
-			http.Error(w, "bad dest", http.StatusBadRequest)
-			return
+			fail(srcRel, dstRel, "bad dest")
+			continue
 		}
 		if ok, err := s.allowed(r, auth.PermWrite, "/"+dstRel); err != nil || !ok {
 			if s.shouldChallenge(r) {
@@ -1929,20 +2948,20 @@ func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
 				out = append(out, outItem{From: srcRel, To: dstRel, Status: "skipped"})
 				continue
 			case "error":
-				http.Error(w, "destination exists", http.StatusConflict)
-				return
+				fail(srcRel, dstRel, "destination exists")
+				continue
 			case "rename":
 				nm, err := uniqueNameInDir(destDirAbs, dstName)
 				if err != nil {
-					http.Error(w, "move failed", http.StatusInternalServerError)
-					return
+					fail(srcRel, dstRel, "move failed")
+					continue
 				}
 				dstName = nm
 				dstRel = joinRel(destDirRel, dstName)
 				dstAbs, err = fsutil.ResolveWithinRoot(cfg.Root, dstRel, cfg.FollowSymlinks)
 				if err != nil {
-					http.Error(w, "bad dest", http.StatusBadRequest)
-					return
+					fail(srcRel, dstRel, "bad dest")
+					continue
 				}
 				status = "renamed"
 			case "overwrite":
@@ -1952,8 +2971,8 @@ func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := validateTransferTargets(st, srcAbs, dstAbs); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			fail(srcRel, dstRel, err.Error())
+			continue
 		}
 		if wipeDest {
 			_ = os.RemoveAll(dstAbs)
@@ -1961,35 +2980,39 @@ func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
 
 		// Try rename first.
 		if err := os.MkdirAll(filepath.Dir(dstAbs), 0o755); err != nil {
-			http.Error(w, "mkdir failed", http.StatusInternalServerError)
-			return
+			fail(srcRel, dstRel, "mkdir failed")
+			continue
 		}
 		if err := os.Rename(srcAbs, dstAbs); err != nil {
-			// cross-device or other rename issues: copy+delete
+			// cross-device or other rename issues: copy+delete. The source
+			// is only removed once the copy is complete.
 			if st.IsDir() {
-				if err := copyDirNoSymlinks(srcAbs, dstAbs, mode == "overwrite"); err != nil {
-					http.Error(w, "move failed", http.StatusInternalServerError)
-					return
-				}
-				if err := os.RemoveAll(srcAbs); err != nil {
-					http.Error(w, "move failed", http.StatusInternalServerError)
-					return
+				err = copyDirNoSymlinks(ctx, srcAbs, dstAbs, mode == "overwrite")
+				if err == nil {
+					err = os.RemoveAll(srcAbs)
 				}
 			} else {
-				if err := copyFileAtomic(srcAbs, dstAbs, mode == "overwrite"); err != nil {
-					if errors.Is(err, os.ErrExist) {
-						http.Error(w, "destination exists", http.StatusConflict)
-						return
-					}
-					http.Error(w, "move failed", http.StatusInternalServerError)
+				err = copyFileAtomic(ctx, srcAbs, dstAbs, mode == "overwrite")
+				if err == nil {
+					_ = os.Remove(srcAbs)
+				}
+			}
+			if err != nil {
+				if ctx.Err() != nil {
 					return
 				}
-				_ = os.Remove(srcAbs)
+				if errors.Is(err, os.ErrExist) {
+					fail(srcRel, dstRel, "destination exists")
+				} else {
+					fail(srcRel, dstRel, "move failed")
+				}
+				continue
 			}
 		}
+		s.recordRemoval(r, srcRel, dstRel)
 		out = append(out, outItem{From: srcRel, To: dstRel, Status: status})
 	}
-	writeJSON(w, map[string]any{"ok": true, "items": out})
+	writeJSON(w, map[string]any{"ok": !failed, "items": out})
 }
 
 func (s *Server) handleMultipartUpload(w http.ResponseWriter, r *http.Request) {
@@ -2008,30 +3031,38 @@ func (s *Server) handleMultipartUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad path", http.StatusBadRequest)
 		return
 	}
-	if err := r.ParseMultipartForm(256 << 20); err != nil { // 256MiB memory+tmp
-		http.Error(w, "bad multipart", http.StatusBadRequest)
+	// The body is a little larger than the file; close enough to fail early.
+	if !s.enoughSpace(w, r, r.ContentLength, absDir) {
 		return
 	}
-	fh := firstFile(r.MultipartForm)
-	if fh == nil {
-		http.Error(w, "missing file", http.StatusBadRequest)
+	release, ok := s.limit(w, r, limitUploads)
+	if !ok {
 		return
 	}
-	src, err := fh.Open()
-	if err != nil {
-		http.Error(w, "open upload", http.StatusBadRequest)
+	defer release()
+	defer s.trackMultipart(r, rel)()
+	s.throttleUp(r)
+	if key := s.shareKey(r); key != nil {
+		s.handleSealedUpload(w, r, key, rel, mode)
 		return
 	}
-	defer src.Close()
-
 	store, _, err := s.shareDeps(r)
 	if err != nil {
 		http.Error(w, "server init failed", http.StatusInternalServerError)
 		return
 	}
+	part, err := filePart(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer part.Close()
 
-	tmp := filepath.Join(cfg.StateDir, "uploads", fmt.Sprintf("mp-%d.tmp", time.Now().UnixNano()))
-	if err := os.MkdirAll(filepath.Dir(tmp), 0o755); err != nil {
+	// Stream the body straight into the spool dir; ParseMultipartForm would
+	// stage large files in os.TempDir first.
+	spool := s.spoolDir(r)
+	tmp := filepath.Join(spool, fmt.Sprintf("mp-%d.tmp", time.Now().UnixNano()))
+	if err := os.MkdirAll(spool, 0o755); err != nil {
 		http.Error(w, "tmp failed", http.StatusInternalServerError)
 		return
 	}
@@ -2040,22 +3071,33 @@ func (s *Server) handleMultipartUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tmp failed", http.StatusInternalServerError)
 		return
 	}
-	_, err = io.Copy(dst, src)
+	var src io.Reader = part
+	max, capped := uploadCap(r)
+	if capped {
+		src = io.LimitReader(part, max+1)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, h), src)
 	_ = dst.Close()
 	if err != nil {
 		_ = os.Remove(tmp)
 		http.Error(w, "upload failed", http.StatusInternalServerError)
 		return
 	}
+	if capped && n > max {
+		_ = os.Remove(tmp)
+		http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	sha, blob, size, err := store.Put(r.Context(), tmp)
+	sha, blob, size, err := store.PutHashed(r.Context(), tmp, hex.EncodeToString(h.Sum(nil)), n)
 	if err != nil {
 		http.Error(w, "dedup failed", http.StatusInternalServerError)
 		return
 	}
 
 	// conflict handling
-	dstRel := joinRel(rel, fh.Filename)
+	dstRel := joinRel(rel, filepath.Base(part.FileName()))
 	dstAbs, err := fsutil.ResolveWithinRoot(cfg.Root, dstRel, cfg.FollowSymlinks)
 	if err != nil {
 		http.Error(w, "bad path", http.StatusBadRequest)
@@ -2087,33 +3129,37 @@ func (s *Server) handleMultipartUpload(w http.ResponseWriter, r *http.Request) {
 			// ok
 		}
 	}
-	if err := dedup.LinkOrCopy(blob, dstAbs); err != nil {
+	if err := store.LinkOrCopy(blob, dstAbs); err != nil {
 		http.Error(w, "write failed", http.StatusInternalServerError)
 		return
 	}
+	s.recordBlobRef(r, sha, dstRel)
+	s.uploaded(r, sha, dstRel)
 	writeJSON(w, map[string]any{"ok": true, "sha256": sha, "size": size, "path": dstRel})
 }
 
-func firstFile(mf *multipart.Form) *multipart.FileHeader {
-	if mf == nil || len(mf.File) == 0 {
-		return nil
-	}
-	// Prefer key "file" if present.
-	if v := mf.File["file"]; len(v) > 0 {
-		return v[0]
-	}
-	// Else first key lexicographically for stable behavior.
-	keys := make([]string, 0, len(mf.File))
-	for k := range mf.File {
-		keys = append(keys, k)
+// filePart returns the first file part of a multipart request without
+// buffering it; other fields before it are skipped.
+func filePart(r *http.Request) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("bad multipart")
 	}
-	sort.Strings(keys)
-	for _, k := range keys {
-		if v := mf.File[k]; len(v) > 0 {
-			return v[0]
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			return nil, errors.New("missing file")
+		}
+		name := p.FileName()
+		if name == "" {
+			_ = p.Close()
+			continue
+		}
+		if b := filepath.Base(name); b == "." || b == "/" || b == ".." {
+			return nil, errors.New("bad filename")
 		}
+		return p, nil
 	}
-	return nil
 }
 
 func (s *Server) handleUploads(w http.ResponseWriter, r *http.Request) {
@@ -2139,7 +3185,6 @@ func (s *Server) handleUploads(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "missing path", http.StatusBadRequest)
 			return
 		}
-		cfg := s.cfgForReq(r)
 		// require write on destination path
 		if ok, err := s.allowed(r, auth.PermWrite, "/"+dest); err != nil || !ok {
 			if s.shouldChallenge(r) {
@@ -2149,38 +3194,18 @@ func (s *Server) handleUploads(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
-		// conflict handling
-		finalDest := dest
-		destAbs, err := fsutil.ResolveWithinRoot(cfg.Root, dest, cfg.FollowSymlinks)
+		finalDest, ok := s.uploadDest(w, r, dest, mode)
+		if !ok {
+			return
+		}
+		cfg := s.cfgForReq(r)
+		destDir, err := fsutil.ResolveWithinRoot(cfg.Root, strings.TrimPrefix(path.Dir("/"+finalDest), "/"), cfg.FollowSymlinks)
 		if err != nil {
 			http.Error(w, "bad path", http.StatusBadRequest)
 			return
 		}
-		if _, err := os.Stat(destAbs); err == nil {
-			switch mode {
-			case "skip":
-				writeJSON(w, map[string]any{"skipped": true, "path": dest})
-				return
-			case "error":
-				http.Error(w, "destination exists", http.StatusConflict)
-				return
-			case "rename":
-				parentRel := path.Dir("/" + dest)
-				parentRel = strings.TrimPrefix(parentRel, "/")
-				parentAbs, err := fsutil.ResolveWithinRoot(cfg.Root, parentRel, cfg.FollowSymlinks)
-				if err != nil {
-					http.Error(w, "bad path", http.StatusBadRequest)
-					return
-				}
-				nm, err := uniqueNameInDir(parentAbs, filepath.Base(dest))
-				if err != nil {
-					http.Error(w, "create failed", http.StatusInternalServerError)
-					return
-				}
-				finalDest = joinRel(parentRel, nm)
-			case "overwrite":
-				// ok
-			}
+		if !s.enoughSpace(w, r, total, destDir) {
+			return
 		}
 
 		_, up, err := s.shareDeps(r)
@@ -2188,29 +3213,76 @@ func (s *Server) handleUploads(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "server init failed", http.StatusInternalServerError)
 			return
 		}
-		sess, err := up.Create(finalDest, total)
+		sess, err := up.Create(finalDest, total, auth.UserFromContext(r.Context()))
 		if err != nil {
 			http.Error(w, "create failed", http.StatusInternalServerError)
 			return
 		}
 		writeJSON(w, map[string]any{"id": sess.ID, "offset": sess.Offset, "size": sess.Size, "dest": sess.DestRel})
+	case http.MethodGet:
+		s.listUploads(w, r)
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// uploadDest applies the conflict mode to a new upload targeting dest and
+// returns the final destination. ok is false once a response has been
+// written (skipped, conflict, bad path).
+func (s *Server) uploadDest(w http.ResponseWriter, r *http.Request, dest, mode string) (finalDest string, ok bool) {
+	cfg := s.cfgForReq(r)
+	finalDest = dest
+	destAbs, err := fsutil.ResolveWithinRoot(cfg.Root, dest, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return "", false
+	}
+	if _, err := os.Stat(destAbs); err == nil {
+		switch mode {
+		case "skip":
+			writeJSON(w, map[string]any{"skipped": true, "path": dest})
+			return "", false
+		case "error":
+			http.Error(w, "destination exists", http.StatusConflict)
+			return "", false
+		case "rename":
+			parentRel := path.Dir("/" + dest)
+			parentRel = strings.TrimPrefix(parentRel, "/")
+			parentAbs, err := fsutil.ResolveWithinRoot(cfg.Root, parentRel, cfg.FollowSymlinks)
+			if err != nil {
+				http.Error(w, "bad path", http.StatusBadRequest)
+				return "", false
+			}
+			nm, err := uniqueNameInDir(parentAbs, filepath.Base(dest))
+			if err != nil {
+				http.Error(w, "create failed", http.StatusInternalServerError)
+				return "", false
+			}
+			finalDest = joinRel(parentRel, nm)
+		case "overwrite":
+			// ok
+		}
+	}
+	return finalDest, true
+}
+
 func (s *Server) handleUploadID(w http.ResponseWriter, r *http.Request) {
 	rest := strings.TrimPrefix(r.URL.Path, "/api/uploads/")
 	if rest == "" {
 		http.NotFound(w, r)
 		return
 	}
+	if id, ok := strings.CutSuffix(rest, "/progress"); ok {
+		s.handleUploadProgress(w, r, id)
+		return
+	}
 	isFinish := strings.HasSuffix(rest, "/finish")
 	id := rest
 	if isFinish {
 		id = strings.TrimSuffix(rest, "/finish")
 	}
 	id = strings.TrimSuffix(id, "/")
+	share := shareFromContext(r.Context())
 
 	// Path-aware ACL: resumable uploads are always write-scoped to the destination.
 	cfg := s.cfgForReq(r)
@@ -2224,7 +3296,7 @@ func (s *Server) handleUploadID(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	if ok2, err := s.allowed(r, auth.PermWrite, "/"+sess.DestRel); err != nil || !ok2 {
+	if ok2, err := s.allowed(r, auth.PermWrite, "/"+sess.DestRel); err != nil || !ok2 || !s.uploadOwner(r, sess.User) {
 		if s.shouldChallenge(r) {
 			s.authChallenge(w)
 		} else {
@@ -2249,22 +3321,41 @@ func (s *Server) handleUploadID(w http.ResponseWriter, r *http.Request) {
 		}
 		rel, _ := filepath.Rel(cfg.Root, dst)
 		rel = filepath.ToSlash(rel)
+		s.recordBlobRef(r, sha, rel)
+		s.uploaded(r, sha, rel)
+		s.progress.forget(share, id)
 		writeJSON(w, map[string]any{"ok": true, "path": rel, "sha256": sha, "size": size})
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		writeJSON(w, map[string]any{"id": sess.ID, "offset": sess.Offset, "size": sess.Size, "dest": sess.DestRel})
+		resp := map[string]any{"id": sess.ID, "offset": sess.Offset, "size": sess.Size, "dest": sess.DestRel, "user": sess.User, "created": sess.Created, "updated": sess.Updated}
+		if v, ok := s.progress.get(share, id); ok {
+			resp["received"], resp["rate"], resp["eta"], resp["active"] = v.Received, v.Rate, v.ETA, v.Active
+		}
+		writeJSON(w, resp)
 	case http.MethodDelete:
 		// cancel upload session
 		if err := up.Cancel(id); err != nil && !errors.Is(err, os.ErrNotExist) {
 			http.Error(w, "cancel failed", http.StatusInternalServerError)
 			return
 		}
+		s.progress.forget(share, id)
 		writeJSON(w, map[string]any{"ok": true})
 	case http.MethodPatch:
+		release, ok := s.limit(w, r, limitUploads)
+		if !ok {
+			return
+		}
+		defer release()
+		s.progress.begin(transfer{id: id, share: share, path: sess.DestRel, kind: "resumable", user: auth.UserFromContext(r.Context()), received: sess.Offset, total: sess.Size})
+		r.Body = progressReader{ReadCloser: r.Body, p: s.progress, share: share, id: id}
+		s.throttleUp(r)
 		sess, err := up.Patch(r.Context(), id, r)
+		if cur, ok := up.Get(id); ok {
+			s.progress.end(share, id, cur.Offset)
+		}
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
 				http.NotFound(w, r)
@@ -2279,31 +3370,29 @@ func (s *Server) handleUploadID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleZip(w http.ResponseWriter, r *http.Request) {
-	// Supports:
-	// - GET  /api/zip?path=<rel>
-	// - POST /api/zip (form: paths=...&paths=...&name=...)
-	// - POST /api/zip (json: {"paths":[...], "name":"..."})
-	if r.Method != http.MethodGet && r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// zipItem is one top-level entry of a zip selection.
+type zipItem struct {
+	rel string
+	abs string
+	st  os.FileInfo
+}
 
+// zipSelection parses the selection of a zip request (see handleZip), checks
+// read access on every path and resolves it. ok is false once an error
+// response has been written.
+func (s *Server) zipSelection(w http.ResponseWriter, r *http.Request) (items []zipItem, name string, ok bool) {
 	type zipReq struct {
 		Paths []string `json:"paths"`
 		Name  string   `json:"name"`
 	}
 
-	var (
-		paths []string
-		name  string
-	)
+	var paths []string
 
 	if r.Method == http.MethodGet {
 		p := fsutil.CleanRelPath(r.URL.Query().Get("path"))
 		if p == "" {
 			http.Error(w, "missing path", http.StatusBadRequest)
-			return
+			return nil, "", false
 		}
 		paths = []string{p}
 		name = filepath.Base(p)
@@ -2313,7 +3402,7 @@ func (s *Server) handleZip(w http.ResponseWriter, r *http.Request) {
 			var req zipReq
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 				http.Error(w, "bad json", http.StatusBadRequest)
-				return
+				return nil, "", false
 			}
 			for _, p := range req.Paths {
 				p = fsutil.CleanRelPath(p)
@@ -2325,7 +3414,7 @@ func (s *Server) handleZip(w http.ResponseWriter, r *http.Request) {
 		} else {
 			if err := r.ParseForm(); err != nil {
 				http.Error(w, "bad form", http.StatusBadRequest)
-				return
+				return nil, "", false
 			}
 			for _, p := range r.Form["paths"] {
 				p = fsutil.CleanRelPath(p)
@@ -2346,7 +3435,7 @@ func (s *Server) handleZip(w http.ResponseWriter, r *http.Request) {
 
 	if len(paths) == 0 {
 		http.Error(w, "missing paths", http.StatusBadRequest)
-		return
+		return nil, "", false
 	}
 
 	// default zip name
@@ -2368,36 +3457,55 @@ func (s *Server) handleZip(w http.ResponseWriter, r *http.Request) {
 			} else {
 				http.Error(w, "forbidden", http.StatusForbidden)
 			}
-			return
+			return nil, "", false
 		}
 	}
 
-	type item struct {
-		rel string
-		abs string
-		st  os.FileInfo
-	}
 	cfg := s.cfgForReq(r)
-	items := make([]item, 0, len(paths))
+	items = make([]zipItem, 0, len(paths))
 	for _, p := range paths {
 		abs, err := fsutil.ResolveWithinRoot(cfg.Root, p, cfg.FollowSymlinks)
 		if err != nil {
 			http.Error(w, "bad path", http.StatusBadRequest)
-			return
+			return nil, "", false
 		}
 		st, err := os.Stat(abs)
 		if err != nil {
 			http.NotFound(w, r)
-			return
+			return nil, "", false
 		}
-		items = append(items, item{rel: p, abs: abs, st: st})
+		items = append(items, zipItem{rel: p, abs: abs, st: st})
+	}
+	return items, name, true
+}
+
+func (s *Server) handleZip(w http.ResponseWriter, r *http.Request) {
+	// Supports:
+	// - GET  /api/zip?path=<rel>
+	// - POST /api/zip (form: paths=...&paths=...&name=...)
+	// - POST /api/zip (json: {"paths":[...], "name":"..."})
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	items, name, ok := s.zipSelection(w, r)
+	if !ok {
+		return
 	}
+	s.streamZip(w, r, items, name)
+}
 
+// streamZip writes items as name.zip, each under its base name.
+func (s *Server) streamZip(w http.ResponseWriter, r *http.Request, items []zipItem, name string) {
+	release, ok := s.limit(w, r, limitZips)
+	if !ok {
+		return
+	}
+	defer release()
+	w = s.throttleDown(w, r)
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
 	zw := zip.NewWriter(w)
-	defer zw.Close()
-
 	ctx := r.Context()
 
 	used := map[string]int{}
@@ -2416,63 +3524,171 @@ func (s *Server) handleZip(w http.ResponseWriter, r *http.Request) {
 		return fmt.Sprintf("%s (%d)%s", b, n, ext)
 	}
 
+	// The status line is long gone once entries are streaming, so per-item
+	// failures are collected and listed in a final zipErrorsName entry.
+	// A disconnect or a failed write ends the archive without a directory.
+	var failures []string
+	fail := func(rel string, err error) {
+		// Drop the PathError wrapper; it carries the absolute path.
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", rel, err))
+	}
+	addFile := func(abs, zipPath string, mod time.Time) error {
+		f, err := os.Open(abs)
+		if err != nil {
+			fail(zipPath, err)
+			return nil
+		}
+		defer f.Close()
+		wr, err := zw.CreateHeader(&zip.FileHeader{Name: zipPath, Method: zip.Deflate, Modified: mod})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(wr, ctxReader{ctx, f}); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Read errors leave a short entry behind; say so.
+			fail(zipPath, err)
+		}
+		return nil
+	}
 	addDir := func(baseAbs, baseRel string) error {
 		return filepath.WalkDir(baseAbs, func(p string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return nil
-			}
 			if ctx.Err() != nil {
 				return ctx.Err()
 			}
-			if d.IsDir() {
+			relp, rerr := filepath.Rel(baseAbs, p)
+			if rerr != nil {
 				return nil
 			}
-			relp, err := filepath.Rel(baseAbs, p)
+			zipPath := sanitizeZipPath(filepath.ToSlash(filepath.Join(baseRel, relp)))
 			if err != nil {
+				fail(zipPath, err)
 				return nil
 			}
-			zipPath := filepath.ToSlash(filepath.Join(baseRel, relp))
-			zipPath = sanitizeZipPath(zipPath)
-			if zipPath == "" {
+			if d.IsDir() || zipPath == "" {
 				return nil
 			}
-			info, _ := d.Info()
-			h := &zip.FileHeader{
-				Name:     zipPath,
-				Method:   zip.Deflate,
-				Modified: time.Now(),
-			}
-			if info != nil {
-				h.Modified = info.ModTime()
-			}
-			wr, err := zw.CreateHeader(h)
-			if err != nil {
-				return err
-			}
-			f, err := os.Open(p)
-			if err != nil {
-				return nil
+			mod := time.Now()
+			if info, err := d.Info(); err == nil {
+				mod = info.ModTime()
 			}
-			_, _ = io.Copy(wr, f)
-			_ = f.Close()
-			return nil
+			return addFile(p, zipPath, mod)
 		})
 	}
 
 	for _, it := range items {
-		top := uniqueTop(filepath.Base(it.rel))
+		top := sanitizeZipPath(uniqueTop(filepath.Base(it.rel)))
+		var err error
 		if it.st.IsDir() {
-			_ = addDir(it.abs, top)
+			err = addDir(it.abs, top)
+		} else {
+			err = addFile(it.abs, top, it.st.ModTime())
+		}
+		if err != nil {
+			return
+		}
+	}
+	if len(failures) > 0 {
+		wr, err := zw.Create(uniqueTop(zipErrorsName))
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(wr, "%d item(s) could not be added completely:\n\n%s\n", len(failures), strings.Join(failures, "\n"))
+	}
+	_ = zw.Close()
+}
+
+// davZipDir answers GET on a WebDAV collection with the folder as a zip,
+// like /api/zip?path=. It reports false for anything that is not a folder.
+func (s *Server) davZipDir(w http.ResponseWriter, r *http.Request, cfg config.Config, clean string) bool {
+	rel := fsutil.CleanRelPath(clean)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		return false
+	}
+	st, err := os.Stat(abs)
+	if err != nil || !st.IsDir() {
+		return false
+	}
+	name := rel
+	if name == "" {
+		name = filepath.Base(cfg.Root)
+	}
+	name = sanitizeZipBaseName(filepath.Base(name))
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+		return true
+	}
+	s.streamZip(w, r, []zipItem{{rel: name, abs: abs, st: st}}, name)
+	return true
+}
+
+// zipErrorsName is the entry /api/zip appends when files could not be read.
+const zipErrorsName = "_lanparty-errors.txt"
+
+// ctxReader fails reads once ctx is done, so long copies stop on disconnect.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// handleZipSize estimates what /api/zip would stream for the same selection
+// (same query, form or JSON body) without building the archive:
+//
+//	-> {entries, bytes}
+//
+// bytes is the total uncompressed size; the archive itself is usually smaller.
+func (s *Server) handleZipSize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	items, _, ok := s.zipSelection(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	var entries, total int64
+	for _, it := range items {
+		if !it.st.IsDir() {
+			entries++
+			total += it.st.Size()
 			continue
 		}
-		top = sanitizeZipPath(top)
-		wr, _ := zw.Create(top)
-		f, err := os.Open(it.abs)
-		if err == nil {
-			_, _ = io.Copy(wr, f)
-			_ = f.Close()
+		err := filepath.WalkDir(it.abs, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if d.IsDir() {
+				return nil
+			}
+			entries++
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return
 		}
 	}
+	writeJSON(w, map[string]any{"entries": entries, "bytes": total})
 }
 
 func (s *Server) handleZipList(w http.ResponseWriter, r *http.Request) {
@@ -2588,26 +3804,61 @@ func (s *Server) handleZipGet(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "is a directory", http.StatusBadRequest)
 		return
 	}
+	fn := path.Base(zf.Name)
+	if fn == "" || fn == "." || fn == "/" {
+		fn = "file"
+	}
+	ct := contentTypeForName(fn)
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ct)
+	disp := "attachment"
+	if r.URL.Query().Get("inline") == "1" && zipInlineType(ct) {
+		disp = "inline"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disp, fn))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Stored entries are plain bytes in the archive, so they can be served
+	// with ranges for seeking in videos; compressed ones only from the start.
+	if zf.Method == zip.Store {
+		if off, err := zf.DataOffset(); err == nil {
+			if f, err := os.Open(abs); err == nil {
+				defer f.Close()
+				http.ServeContent(w, r, fn, zf.Modified, io.NewSectionReader(f, off, int64(zf.UncompressedSize64)))
+				return
+			}
+		}
+	}
 	rc, err := zf.Open()
 	if err != nil {
 		http.Error(w, "open entry failed", http.StatusBadRequest)
 		return
 	}
 	defer rc.Close()
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Content-Length", strconv.FormatUint(zf.UncompressedSize64, 10))
+	_, _ = io.Copy(w, rc)
+}
 
-	fn := path.Base(zf.Name)
-	if fn == "" || fn == "." || fn == "/" {
-		fn = "file"
-	}
-	if ct := contentTypeForName(fn); ct != "" {
-		w.Header().Set("Content-Type", ct)
+// zipInlineType reports whether /api/zipget may show an entry of content
+// type ct in the browser: media, PDFs and plain text. Anything else, HTML
+// and SVG above all, would run on lanparty's origin and stays an attachment.
+func zipInlineType(ct string) bool {
+	ct, _, _ = strings.Cut(ct, ";")
+	switch {
+	case ct == "image/svg+xml":
+		return false
+	case strings.HasPrefix(ct, "image/"), strings.HasPrefix(ct, "video/"), strings.HasPrefix(ct, "audio/"):
+		return true
 	}
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fn))
-	_, _ = io.Copy(w, rc)
+	return ct == "application/pdf" || ct == "text/plain"
 }
 
 func (s *Server) handleThumb(w http.ResponseWriter, r *http.Request) {
-	// Very small thumbnailer: supports jpg/png/gif input, outputs jpeg.
+	// Very small thumbnailer: supports jpg/png/gif input (or the first image
+	// inside a .zip), outputs jpeg.
 	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
 	max := 256
 	kind := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("t"))) // ""|"txt"
@@ -2634,14 +3885,21 @@ func (s *Server) handleThumb(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	ext := strings.ToLower(filepath.Ext(abs))
-	if !isImageExt(ext) && !(kind == "txt" && isTextExt(ext)) {
+	// fallback=1 asks for a generated type icon instead of a 404 when the
+	// file has no preview, so grids of mixed files stay uniform.
+	fallback := r.URL.Query().Get("fallback") == "1"
+	if !isImageExt(ext) && !(kind == "txt" && isTextExt(ext)) && ext != ".zip" && s.previewerFor(ext) == nil {
+		if fallback {
+			serveIconThumb(w, r, ext, max)
+			return
+		}
 		http.NotFound(w, r)
 		return
 	}
 
 	thumbDir := filepath.Join(cfg.StateDir, "thumbs")
 	_ = os.MkdirAll(thumbDir, 0o755)
-	key := safeKey(rel) + "-" + fmt.Sprintf("%d", st.ModTime().Unix()) + "-" + fmt.Sprintf("%d", max) + "-" + kind + ".jpg"
+	key := thumbKey(rel, st, max, kind)
 	thumbPath := filepath.Join(thumbDir, key)
 
 	// Strong cache key: changes when file mtime or requested size changes.
@@ -2653,24 +3911,28 @@ func (s *Server) handleThumb(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if b, err := os.ReadFile(thumbPath); err == nil {
+	share := shareFromContext(r.Context())
+	if b, ok := s.readThumbCache(share, thumbPath, key); ok {
 		w.Header().Set("Content-Type", "image/jpeg")
 		w.Header().Set("ETag", etag)
 		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
 		_, _ = w.Write(b)
 		return
 	}
-	var b []byte
-	if kind == "txt" && isTextExt(ext) {
-		b, err = s.thumbDo(key, func() ([]byte, error) { return makeTextThumb(abs, max) })
-	} else {
-		b, err = s.thumbDo(key, func() ([]byte, error) { return makeThumb(abs, max) })
+	b, err := s.renderThumb(r.Context(), key, abs, kind, max)
+	if errors.Is(err, errBusy) {
+		tooBusy(w)
+		return
 	}
 	if err != nil {
+		if fallback {
+			serveIconThumb(w, r, ext, max)
+			return
+		}
 		http.NotFound(w, r)
 		return
 	}
-	_ = os.WriteFile(thumbPath, b, 0o644)
+	s.writeThumbCache(share, thumbPath, key, b)
 	w.Header().Set("Content-Type", "image/jpeg")
 	w.Header().Set("ETag", etag)
 	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
@@ -2693,15 +3955,12 @@ func writeJSON(w http.ResponseWriter, v any) {
 	_ = enc.Encode(v)
 }
 
-func (s *Server) thumbDo(key string, fn func() ([]byte, error)) ([]byte, error) {
+func (s *Server) thumbDo(ctx context.Context, key string, fn func() ([]byte, error)) ([]byte, error) {
 	// Lazy init.
 	s.thumbMu.Lock()
 	if s.thumbInflight == nil {
 		s.thumbInflight = map[string]*thumbCall{}
 	}
-	if s.thumbSem == nil {
-		s.thumbSem = make(chan struct{}, 4) // small parallelism cap
-	}
 	if c, ok := s.thumbInflight[key]; ok {
 		s.thumbMu.Unlock()
 		<-c.done
@@ -2711,10 +3970,13 @@ func (s *Server) thumbDo(key string, fn func() ([]byte, error)) ([]byte, error)
 	s.thumbInflight[key] = c
 	s.thumbMu.Unlock()
 
-	// compute
-	s.thumbSem <- struct{}{}
-	b, err := fn()
-	<-s.thumbSem
+	// compute; callers waiting on the same key share a busy result.
+	var b []byte
+	release, err := s.acquireSlot(ctx, limitMedia)
+	if err == nil {
+		b, err = fn()
+		release()
+	}
 
 	s.thumbMu.Lock()
 	c.b = b
@@ -2735,7 +3997,14 @@ func parseInt64(s string) (int64, error) {
 	return n, err
 }
 
-func copyFileAtomic(src, dst string, overwrite bool) error {
+// copyStep is how much copyFileAtomic copies between context checks; each
+// step is still a single copy_file_range/sendfile on Linux.
+const copyStep = 8 << 20
+
+func copyFileAtomic(ctx context.Context, src, dst string, overwrite bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if !overwrite {
 		if _, err := os.Stat(dst); err == nil {
 			return os.ErrExist
@@ -2755,7 +4024,15 @@ func copyFileAtomic(src, dst string, overwrite bool) error {
 	if err != nil {
 		return err
 	}
-	_, cErr := io.Copy(out, in)
+	var cErr error
+	for cErr == nil {
+		if cErr = ctx.Err(); cErr == nil {
+			_, cErr = io.CopyN(out, in, copyStep)
+		}
+	}
+	if cErr == io.EOF {
+		cErr = nil
+	}
 	sErr := out.Sync()
 	clErr := out.Close()
 	if cErr != nil {
@@ -2781,7 +4058,7 @@ func copyFileAtomic(src, dst string, overwrite bool) error {
 	return os.Rename(tmp, dst)
 }
 
-func copyDirNoSymlinks(srcDir, dstDir string, overwrite bool) error {
+func copyDirNoSymlinks(ctx context.Context, srcDir, dstDir string, overwrite bool) error {
 	// Create destination dir (or ensure it exists if overwrite allows).
 	if st, err := os.Stat(dstDir); err == nil {
 		if !st.IsDir() {
@@ -2798,6 +4075,9 @@ func copyDirNoSymlinks(srcDir, dstDir string, overwrite bool) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// Skip symlinks (avoid loops / escaping).
 		if d.Type()&os.ModeSymlink != 0 {
 			if d.IsDir() {
@@ -2816,7 +4096,7 @@ func copyDirNoSymlinks(srcDir, dstDir string, overwrite bool) error {
 		if d.IsDir() {
 			return os.MkdirAll(dst, 0o755)
 		}
-		return copyFileAtomic(p, dst, overwrite)
+		return copyFileAtomic(ctx, p, dst, overwrite)
 	})
 }
 
@@ -0,0 +1,330 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+	"lanparty/internal/meta"
+)
+
+// Session cookies.
+//
+// A session is a record in the "sessions" store of the main state dir,
+// keyed by a random id. The cookie only names it:
+//
+//	lanparty_session = id "." base64url(hmac)
+//
+// Browsers get one by signing in on the /login form, so they never have to
+// cache Basic credentials, or from /api/session, which trades credentials
+// the browser cannot keep (typically a bearer token handed over by the CLI
+// as ?access_token=) for one. /logout deletes the record, so the cookie is
+// dead even where it was copied. The HMAC uses the URL signing secret
+// (signurl.go) under its own label, so a link signature can never pass as a
+// session or the other way round.
+
+const (
+	sessionCookie = "lanparty_session"
+	sessionTTL    = 8 * time.Hour
+)
+
+type session struct {
+	User    string   `json:"user"`
+	Groups  []string `json:"groups,omitempty"`
+	Via     string   `json:"via,omitempty"` // "oidc" or "ldap" for sign-ins not from Users
+	Exp     int64    `json:"exp"`           // unix seconds
+	Created int64    `json:"created"`       // unix seconds
+	Addr    string   `json:"addr,omitempty"`
+}
+
+func (s *Server) sessionSig(id string) []byte {
+	m := hmac.New(sha256.New, s.signKey())
+	m.Write([]byte("session\x00" + id))
+	return m.Sum(nil)
+}
+
+// sessionStore returns the sessions of all shares, kept in the main state
+// dir.
+func (s *Server) sessionStore() (*meta.Store, error) {
+	stateDir := s.config().StateDir
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, err := s.metaDBLocked(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	return db.Bucket("sessions"), nil
+}
+
+// sessionID returns the id of the request's session cookie if its
+// signature holds.
+func (s *Server) sessionID(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	id, sig64, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return "", false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sig64)
+	if err != nil || !hmac.Equal(sig, s.sessionSig(id)) {
+		return "", false
+	}
+	return id, true
+}
+
+// liveSession returns the session of a live session cookie. Users signed
+// in through OIDC or LDAP stay signed in only while it is configured.
+func (s *Server) liveSession(r *http.Request) (session, bool) {
+	id, ok := s.sessionID(r)
+	if !ok {
+		return session{}, false
+	}
+	store, err := s.sessionStore()
+	if err != nil {
+		return session{}, false
+	}
+	var sess session
+	if ok, err := store.Get(id, &sess); err != nil || !ok {
+		return session{}, false
+	}
+	if time.Now().Unix() > sess.Exp {
+		_ = store.Delete(id)
+		return session{}, false
+	}
+	if !identityLive(s.cfgForReq(r), sess.User, sess.Via) {
+		return session{}, false
+	}
+	return sess, true
+}
+
+// identityLive reports whether user, signed in via "oidc", "ldap" or ""
+// (Users and tokens), may still act: its provider is still configured or
+// it is still a user, and it is not an expired guest. Sessions and signed
+// links check it on every request.
+func identityLive(cfg config.Config, user, via string) bool {
+	switch via {
+	case "oidc":
+		if cfg.OIDC == nil {
+			return false
+		}
+	case "ldap":
+		if cfg.LDAP == nil {
+			return false
+		}
+	default:
+		if _, ok := cfg.Users[user]; !ok && !tokenUserExists(cfg.Tokens, user) {
+			return false
+		}
+	}
+	return !guestExpired(cfg, user)
+}
+
+// withSession authenticates ctx as the signed-in sess: its user, groups and
+// provider.
+func withSession(ctx context.Context, sess session) context.Context {
+	ctx = auth.WithGroups(auth.WithUser(ctx, sess.User), sess.Groups)
+	if sess.Via != "" {
+		ctx = context.WithValue(ctx, viaKey, sess.Via)
+	}
+	return ctx
+}
+
+// viaFromContext returns the provider the request's user signed in
+// through, "" for Users and tokens.
+func viaFromContext(ctx context.Context) string {
+	via, _ := ctx.Value(viaKey).(string)
+	return via
+}
+
+// startSession records sess and sets its cookie. Expired sessions are
+// dropped on the way.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, sess session) (time.Time, error) {
+	store, err := s.sessionStore()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var b [18]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Time{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b[:])
+	now := time.Now()
+	exp := now.Add(sessionTTL)
+	sess.Exp, sess.Created, sess.Addr = exp.Unix(), now.Unix(), s.remoteHost(r)
+	if err := store.Put(id, sess); err != nil {
+		return time.Time{}, err
+	}
+	for _, k := range store.Keys("") {
+		var old session
+		if ok, err := store.Get(k, &old); err == nil && ok && old.Exp < now.Unix() {
+			_ = store.Delete(k)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id + "." + base64.RawURLEncoding.EncodeToString(s.sessionSig(id)),
+		Path:     s.basePath() + "/",
+		Expires:  exp,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return exp, nil
+}
+
+// endSession deletes the request's session and clears its cookie.
+func (s *Server) endSession(w http.ResponseWriter, r *http.Request) {
+	if id, ok := s.sessionID(r); ok {
+		if store, err := s.sessionStore(); err == nil {
+			_ = store.Delete(id)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: s.basePath() + "/", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+}
+
+// localNext returns next if it is a local path, else the share's start
+// page: no open redirects.
+func (s *Server) localNext(r *http.Request, next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return s.withSharePrefix(r, "/")
+	}
+	return next
+}
+
+// handleSession exchanges the request's credentials (a bearer token,
+// ?access_token= or Basic) for a session cookie.
+//
+//	GET    /api/session?access_token=<tok>&next=/path  -> cookie + redirect to next
+//	POST   /api/session (Authorization: Bearer <tok>)  -> cookie + {user, exp}
+//	DELETE /api/session                                -> end the session
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.endSession(w, r)
+		writeJSON(w, map[string]any{"ok": true})
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := auth.UserFromContext(r.Context())
+	_, _, basic := r.BasicAuth()
+	// Only credentials make a session: one made from a session cookie
+	// could renew itself forever.
+	if user == "" || (requestToken(r) == "" && !basic) {
+		s.authChallenge(w)
+		return
+	}
+	// Keep how the user signed in, so LDAP and OIDC users stay signed in
+	// with their groups.
+	sess := session{User: user, Groups: auth.GroupsFromContext(r.Context()), Via: viaFromContext(r.Context())}
+	exp, err := s.startSession(w, r, sess)
+	if err != nil {
+		http.Error(w, "session failed", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodGet {
+		http.Redirect(w, r, s.localNext(r, r.URL.Query().Get("next")), http.StatusSeeOther)
+		return
+	}
+	writeJSON(w, map[string]any{"ok": true, "user": user, "exp": exp.Unix()})
+}
+
+// handleLogin serves the sign-in form and checks what it posts.
+//
+//	GET  /login[?next=/path]                   -> the form, or a redirect when signed in
+//	POST /login (form: user, password, next)   -> cookie + redirect to next
+//
+// A failed sign-in goes back to the form with ?error=1.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !auth.HasAuth(*s.config()) {
+		http.Redirect(w, r, s.basePath()+"/", http.StatusFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if auth.UserFromContext(r.Context()) != "" {
+			http.Redirect(w, r, s.localNext(r, r.URL.Query().Get("next")), http.StatusFound)
+			return
+		}
+		b, err := fs.ReadFile(s.webFS, "login.html")
+		if err != nil {
+			http.Error(w, "missing login ui", http.StatusInternalServerError)
+			return
+		}
+		b = markOIDCHTML(b, s.config())
+		b = brandHTML(b, s.config().Branding)
+		b = basePathHTML(b, s.basePath())
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(b)
+	case http.MethodPost:
+		user, pass, next := r.PostFormValue("user"), r.PostFormValue("password"), r.PostFormValue("next")
+		cfg := s.cfgForReq(r)
+		sess := session{User: user}
+		ok := false
+		if u, local := cfg.Users[user]; local {
+			ok = !guestExpired(cfg, user) && auth.CheckPassword(u.Bcrypt, pass)
+		} else if cfg.LDAP != nil {
+			name, groups, err := s.ldapLogin(cfg.LDAP, user, pass)
+			ok, sess = err == nil, session{User: name, Groups: groups, Via: "ldap"}
+		}
+		if !ok {
+			s.notifyLoginFailed(r, user)
+			q := url.Values{"error": {"1"}}
+			if next != "" {
+				q.Set("next", next)
+			}
+			http.Redirect(w, r, s.withSharePrefix(r, "/login")+"?"+q.Encode(), http.StatusSeeOther)
+			return
+		}
+		if _, err := s.startSession(w, r, sess); err != nil {
+			http.Error(w, "session failed", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, s.localNext(r, next), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLogout ends the session and goes back to the sign-in form.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.endSession(w, r)
+	http.Redirect(w, r, s.withSharePrefix(r, "/login"), http.StatusSeeOther)
+}
+
+// markSessionHTML flags a page served to a signed-in session, which shows
+// the "Sign out" link.
+func (s *Server) markSessionHTML(b []byte, r *http.Request) []byte {
+	if _, ok := s.liveSession(r); !ok {
+		return b
+	}
+	const bodyTag = "<body"
+	idx := bytes.Index(b, []byte(bodyTag))
+	if idx < 0 {
+		return b
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(b) + 16)
+	buf.Write(b[:idx+len(bodyTag)])
+	buf.WriteString(` data-session="1"`)
+	buf.Write(b[idx+len(bodyTag):])
+	return buf.Bytes()
+}
@@ -0,0 +1,95 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lanparty/internal/config"
+)
+
+// providerServer serves crew/plan.txt, readable by group crew only, with
+// LDAP and OIDC configured. dave:pw is a directory user in crew.
+func providerServer(t *testing.T) *Server {
+	t.Helper()
+	s := testServer(t, func(cfg *config.Config) {
+		cfg.LDAP = &config.LDAP{URL: "ldap://dir.example.org", UserDN: "uid={user},dc=example,dc=org"}
+		cfg.OIDC = &config.OIDC{Issuer: "https://auth.example.org/", ClientID: "lanparty", ClientSecret: "s3cret"}
+		cfg.ACLs = append(cfg.ACLs, config.ACL{Path: "/crew", Read: []string{"@crew"}})
+	})
+	dir := filepath.Join(s.config().Root, "crew")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plan.txt"), []byte("rush B"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Stand in for the directory.
+	sum := sha256.Sum256([]byte(s.config().LDAP.URL + "\x00dave\x00pw"))
+	s.ldapCache = map[string]ldapCached{string(sum[:]): {user: "dave", groups: []string{"crew"}, until: time.Now().Add(time.Hour)}}
+	return s
+}
+
+// sessionCookieOf returns the session cookie w set.
+func sessionCookieOf(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie && c.Value != "" {
+			return c
+		}
+	}
+	t.Fatalf("no session cookie (%d %s)", w.Code, w.Body)
+	return nil
+}
+
+func getWithCookie(h http.Handler, target string, c *http.Cookie) int {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.AddCookie(c)
+	return serveAs(h, r, "").Code
+}
+
+// A session from /api/session keeps how its user signed in: an LDAP user
+// stays signed in, with their directory groups.
+func TestSessionKeepsLDAPUser(t *testing.T) {
+	s := providerServer(t)
+	h := s.Handler()
+	w := serveAs(h, httptest.NewRequest(http.MethodPost, "/api/session", nil), "dave")
+	var res struct {
+		User string `json:"user"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || res.User != "dave" {
+		t.Fatalf("POST /api/session: %d %v %+v", w.Code, err, res)
+	}
+	c := sessionCookieOf(t, w)
+	if code := getWithCookie(h, "/f/crew/plan.txt", c); code != http.StatusOK {
+		t.Fatalf("GET with the LDAP user's session: %d", code)
+	}
+}
+
+// A session cookie cannot mint another session, so it expires; the
+// cookie itself keeps working, groups included.
+func TestSessionNotFromCookie(t *testing.T) {
+	s := providerServer(t)
+	h := s.Handler()
+	w := httptest.NewRecorder()
+	if _, err := s.startSession(w, httptest.NewRequest(http.MethodGet, "/login/oidc/callback", nil), session{User: "carol", Groups: []string{"crew"}, Via: "oidc"}); err != nil {
+		t.Fatal(err)
+	}
+	c := sessionCookieOf(t, w)
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		r := httptest.NewRequest(method, "/api/session", nil)
+		r.AddCookie(c)
+		w = serveAs(h, r, "")
+		if w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+			t.Fatalf("%s /api/session with a cookie: %d %v", method, w.Code, w.Result().Cookies())
+		}
+	}
+	if code := getWithCookie(h, "/f/crew/plan.txt", c); code != http.StatusOK {
+		t.Fatalf("GET with the OIDC session: %d", code)
+	}
+}
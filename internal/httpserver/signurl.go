@@ -0,0 +1,174 @@
+package httpserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/fsutil"
+)
+
+// Signed download URLs.
+//
+//	/f/<path>?exp=<unix>&u=<user>[&via=<oidc|ldap>&g=<group>...]&sig=<hmac>
+//
+// sig is HMAC-SHA256 over share, path, user, expiry and, for users signed
+// in through OIDC or LDAP, that provider and their groups, with a server
+// secret kept in <stateDir>/urlsign.key. A valid signature authenticates the
+// request as u for that one file until exp, so media elements and external
+// players need no credentials; the ACL is still checked for u on every
+// request, and links die with the user (or guest account) as sessions do.
+// Deleting urlsign.key revokes every link.
+
+const (
+	signKeyFile    = "urlsign.key"
+	signDefaultTTL = time.Hour
+	signMaxTTL     = 7 * 24 * time.Hour
+)
+
+// signKey returns the URL signing secret, creating it on first use. Without a
+// state dir the secret lives in memory and links end with the process.
+func (s *Server) signKey() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.urlKey != nil {
+		return s.urlKey
+	}
+	stateDir := s.config().StateDir
+	var p string
+	if stateDir != "" {
+		p = filepath.Join(stateDir, signKeyFile)
+		if b, err := os.ReadFile(p); err == nil && len(b) == 32 {
+			s.urlKey = b
+			return b
+		}
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	if p != "" {
+		if err := os.MkdirAll(stateDir, 0o755); err == nil {
+			_ = os.WriteFile(p, key, 0o600)
+		}
+	}
+	s.urlKey = key
+	return key
+}
+
+func (s *Server) urlSig(share, rel string, sess session, exp int64) string {
+	m := hmac.New(sha256.New, s.signKey())
+	m.Write([]byte(share + "\x00" + rel + "\x00" + sess.User + "\x00" + strconv.FormatInt(exp, 10)))
+	if sess.Via != "" {
+		// Links of Users and tokens keep the signature they always had.
+		m.Write([]byte("\x00" + sess.Via + "\x00" + strings.Join(sess.Groups, "\x00")))
+	}
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// signedURLUser checks the signature of a /f/ request and returns who it
+// was issued for.
+func (s *Server) signedURLUser(r *http.Request) (session, bool) {
+	q := r.URL.Query()
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return session{}, false
+	}
+	rel := fsutil.CleanRelPath(strings.TrimPrefix(r.URL.Path, "/f/"))
+	sess := session{User: q.Get("u"), Via: q.Get("via")}
+	if sess.Via != "" {
+		sess.Groups = q["g"]
+	}
+	want := s.urlSig(shareFromContext(r.Context()), rel, sess, exp)
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(want)) {
+		return session{}, false
+	}
+	if sess.User != "" && !identityLive(s.cfgForReq(r), sess.User, sess.Via) {
+		return session{}, false
+	}
+	return sess, true
+}
+
+func tokenUserExists(tokens map[string]string, user string) bool {
+	for _, u := range tokens {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSign issues a signed download URL for a file.
+//
+//	GET /api/sign?path=<rel>&ttl=<seconds>  -> {url, exp}
+//
+// ttl defaults to one hour and is capped at seven days.
+func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	if rel == "" {
+		http.Error(w, "missing path", http.StatusBadRequest)
+		return
+	}
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	if st, err := os.Stat(abs); err != nil || st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	ttl, ok := signTTL(r.URL.Query().Get("ttl"))
+	if !ok {
+		http.Error(w, "bad ttl", http.StatusBadRequest)
+		return
+	}
+	exp := time.Now().Add(ttl).Unix()
+	writeJSON(w, map[string]any{"url": s.signedFileURL(r, rel, exp), "exp": exp})
+}
+
+// signTTL parses the ttl parameter of a signing request, in seconds:
+// signDefaultTTL when empty, capped at signMaxTTL.
+func signTTL(v string) (time.Duration, bool) {
+	if v == "" {
+		return signDefaultTTL, true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	// Clamp before multiplying; large n would overflow the Duration.
+	return time.Duration(min(n, int64(signMaxTTL/time.Second))) * time.Second, true
+}
+
+// signedFileURL returns the /f/ URL of rel signed for the caller until exp,
+// relative to the host.
+func (s *Server) signedFileURL(r *http.Request, rel string, exp int64) string {
+	sess := session{User: auth.UserFromContext(r.Context()), Via: viaFromContext(r.Context())}
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	if sess.User != "" {
+		q.Set("u", sess.User)
+	}
+	if sess.Via != "" {
+		sess.Groups = auth.GroupsFromContext(r.Context())
+		q.Set("via", sess.Via)
+		q["g"] = sess.Groups
+	}
+	q.Set("sig", s.urlSig(shareFromContext(r.Context()), rel, sess, exp))
+	return s.withSharePrefix(r, "/f/"+escapeURLPath(rel)) + "?" + q.Encode()
+}
@@ -0,0 +1,105 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lanparty/internal/config"
+)
+
+func TestSignTTL(t *testing.T) {
+	for v, want := range map[string]time.Duration{
+		"":                    signDefaultTTL,
+		"60":                  time.Minute,
+		"9223372036854775807": signMaxTTL,
+		"9300000000":          signMaxTTL,
+	} {
+		if got, ok := signTTL(v); !ok || got != want {
+			t.Errorf("signTTL(%q) = %v, %v; want %v", v, got, ok, want)
+		}
+	}
+	for _, v := range []string{"0", "-5", "x"} {
+		if _, ok := signTTL(v); ok {
+			t.Errorf("signTTL(%q) accepted", v)
+		}
+	}
+}
+
+// A link signed by a guest dies with the guest account.
+func TestSignedURLGuestExpiry(t *testing.T) {
+	s := testServer(t, func(cfg *config.Config) {
+		cfg.Users["guest"] = cfg.Users["bob"]
+		cfg.ACLs[0].Read = []string{"alice", "bob", "guest"}
+		cfg.Guests = map[string]config.Guest{"guest": {Created: time.Now().Unix(), Expires: time.Now().Add(time.Hour).Unix()}}
+	})
+	if err := os.WriteFile(filepath.Join(s.config().Root, "demo.dem"), []byte("demo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+	w := serveAs(h, httptest.NewRequest(http.MethodGet, "/api/sign?path=demo.dem&ttl=99999999999999", nil), "guest")
+	var res struct {
+		URL string `json:"url"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("sign: %d %v", w.Code, err)
+	}
+	if max := time.Now().Add(signMaxTTL).Unix(); res.Exp > max || res.Exp < max-60 {
+		t.Errorf("exp %d, want about %d", res.Exp, max)
+	}
+	get := func() int {
+		return serveAs(h, httptest.NewRequest(http.MethodGet, res.URL, nil), "").Code
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("GET signed link: %d", code)
+	}
+	_, _ = s.updateConfig(func(cfg *config.Config) error {
+		g := cfg.Guests["guest"]
+		g.Expires = time.Now().Unix() - 1
+		cfg.Guests["guest"] = g
+		return nil
+	})
+	if code := get(); code != http.StatusForbidden {
+		t.Fatalf("GET signed link of expired guest: %d", code)
+	}
+}
+
+// A link signed by an OIDC user works for them, groups included, and
+// stops when OIDC is no longer configured.
+func TestSignedURLProviderUser(t *testing.T) {
+	s := testServer(t, func(cfg *config.Config) {
+		cfg.OIDC = &config.OIDC{Issuer: "https://auth.example.org/", ClientID: "lanparty", ClientSecret: "s3cret"}
+		cfg.ACLs = append(cfg.ACLs, config.ACL{Path: "/crew", Read: []string{"@crew"}})
+	})
+	if err := os.MkdirAll(filepath.Join(s.config().Root, "crew"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.config().Root, "crew", "plan.txt"), []byte("rush B"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/sign", nil)
+	r = r.WithContext(withSession(r.Context(), session{User: "carol", Groups: []string{"crew"}, Via: "oidc"}))
+	link := s.signedFileURL(r, "crew/plan.txt", time.Now().Add(time.Hour).Unix())
+	h := s.Handler()
+	get := func(link string) int {
+		return serveAs(h, httptest.NewRequest(http.MethodGet, link, nil), "").Code
+	}
+	if code := get(link); code != http.StatusOK {
+		t.Fatalf("GET signed link: %d", code)
+	}
+	if code := get(link + "&g=admins"); code != http.StatusForbidden {
+		t.Fatalf("GET signed link with an added group: %d", code)
+	}
+	_, _ = s.updateConfig(func(cfg *config.Config) error {
+		cfg.OIDC = nil
+		return nil
+	})
+	if code := get(link); code != http.StatusForbidden {
+		t.Fatalf("GET signed link without OIDC: %d", code)
+	}
+}
@@ -0,0 +1,260 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+	"lanparty/internal/dedup"
+)
+
+// Snapshots: a frozen, read-only copy of a named share, mounted at
+// /s/<share>@<stamp>/. It is a tree of hardlinks to the share's dedup blobs
+// in <stateDir>/snapshots/<stamp>, so it costs no space for content the
+// blob store already has, and edits through lanparty (which replace files
+// rather than rewrite them) leave it alone. Files not yet in the store are
+// copied into it on the way. Reading goes through the share's ACLs;
+// nothing can write to a snapshot.
+
+// snapshotStamp is the layout of the <stamp> part, in UTC.
+const snapshotStamp = "20060102-150405"
+
+type snapshotInfo struct {
+	Name  string `json:"name"` // <share>@<stamp>
+	URL   string `json:"url"`
+	Time  int64  `json:"time"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+	User  string `json:"user,omitempty"`
+}
+
+// snapshotOf splits "<share>@<stamp>".
+func snapshotOf(name string) (share, stamp string, ok bool) {
+	share, stamp, ok = strings.Cut(name, "@")
+	if !ok || share == "" {
+		return "", "", false
+	}
+	if _, err := time.Parse(snapshotStamp, stamp); err != nil {
+		return "", "", false
+	}
+	return share, stamp, true
+}
+
+func snapshotsDir(stateDir string) string {
+	return filepath.Join(stateDir, "snapshots")
+}
+
+// snapshotCfg is the share config of a snapshot of the share configured
+// as cfg.
+func snapshotCfg(cfg config.Config, stamp string) config.Config {
+	dir := snapshotsDir(cfg.StateDir)
+	cfg.Root = filepath.Join(dir, stamp)
+	cfg.StateDir = filepath.Join(dir, stamp+".state")
+	return cfg
+}
+
+// isSnapshotShare reports whether share names an existing snapshot.
+func (s *Server) isSnapshotShare(share string) bool {
+	base, stamp, ok := snapshotOf(share)
+	if !ok {
+		return false
+	}
+	if _, ok := s.config().Shares[base]; !ok {
+		return false
+	}
+	st, err := os.Stat(filepath.Join(snapshotsDir(s.shareCfg(base).StateDir), stamp))
+	return err == nil && st.IsDir()
+}
+
+// handleAdminSnapshots lists, takes and deletes snapshots of the request's
+// share.
+//
+//	GET    /s/<share>/api/admin/snapshots          -> {snapshots:[{name, url, time, files, bytes, user}]}
+//	POST   /s/<share>/api/admin/snapshots          -> the new snapshot
+//	DELETE /s/<share>/api/admin/snapshots?name=<share>@<stamp>
+//
+// Taking one reads every file not hashed since it last changed, so it can
+// take a while on a big share; the request waits for it.
+func (s *Server) handleAdminSnapshots(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	share := shareFromContext(r.Context())
+	sh, ok := s.config().Shares[share]
+	if !ok {
+		http.Error(w, "snapshots need a named share", http.StatusBadRequest)
+		return
+	}
+	cfg := s.cfgForReq(r)
+	store, err := s.metaStore(r, "snapshots")
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		out := []snapshotInfo{}
+		for _, stamp := range store.Keys("") {
+			var info snapshotInfo
+			if ok, err := store.Get(stamp, &info); err != nil || !ok {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(snapshotsDir(cfg.StateDir), stamp)); err != nil {
+				continue
+			}
+			info.URL = s.basePath() + "/s/" + info.Name + "/"
+			out = append(out, info)
+		}
+		writeJSON(w, map[string]any{"snapshots": out})
+	case http.MethodPost:
+		if sh.Encrypted || sh.RAM != nil {
+			http.Error(w, "encrypted and RAM shares cannot be snapshotted", http.StatusBadRequest)
+			return
+		}
+		dedupStore, _, err := s.shareDeps(r)
+		if err != nil {
+			http.Error(w, "server init failed", http.StatusInternalServerError)
+			return
+		}
+		now := time.Now().UTC()
+		stamp := now.Format(snapshotStamp)
+		dir := filepath.Join(snapshotsDir(cfg.StateDir), stamp)
+		if _, err := os.Stat(dir); err == nil {
+			http.Error(w, "a snapshot was just taken; try again in a second", http.StatusConflict)
+			return
+		}
+		ctx, job := s.startJob(r.Context(), "snapshot", share, auth.UserFromContext(r.Context()), nil)
+		files, size, err := s.buildSnapshot(ctx, cfg, dedupStore, dir)
+		s.endJob(job, err)
+		if err != nil {
+			if r.Context().Err() == nil {
+				http.Error(w, "snapshot failed: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		info := snapshotInfo{
+			Name: share + "@" + stamp, URL: s.basePath() + "/s/" + share + "@" + stamp + "/",
+			Time: now.Unix(), Files: files, Bytes: size, User: auth.UserFromContext(r.Context()),
+		}
+		_ = store.Put(stamp, info)
+		writeJSON(w, info)
+	case http.MethodDelete:
+		base, stamp, ok := snapshotOf(r.URL.Query().Get("name"))
+		if !ok || base != share {
+			http.Error(w, "bad name", http.StatusBadRequest)
+			return
+		}
+		dir := filepath.Join(snapshotsDir(cfg.StateDir), stamp)
+		if _, err := os.Stat(dir); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			http.Error(w, "delete failed", http.StatusInternalServerError)
+			return
+		}
+		s.dropMetaDB(dir + ".state")
+		_ = os.RemoveAll(dir + ".state")
+		_ = store.Delete(stamp)
+		writeJSON(w, map[string]any{"ok": true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// buildSnapshot links every regular file below cfg.Root, minus the state
+// dir, into dir through the blob store. The tree is built next to dir and
+// only renamed into place once complete.
+func (s *Server) buildSnapshot(ctx context.Context, cfg config.Config, store *dedup.Store, dir string) (files int, size int64, err error) {
+	tmp := dir + ".tmp"
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(tmp)
+		}
+	}()
+	stateDir := filepath.Clean(cfg.StateDir)
+	err = filepath.WalkDir(cfg.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(cfg.Root, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == stateDir || d.Name() == ".lanparty" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(tmp, rel), 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil // symlinks, sockets, ...
+		}
+		st, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blob, err := s.snapshotBlob(ctx, store, p, st, filepath.Dir(dir))
+		if err != nil {
+			return err
+		}
+		if err := store.LinkOrCopy(blob, filepath.Join(tmp, rel)); err != nil {
+			return err
+		}
+		files++
+		size += st.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return files, size, os.Rename(tmp, dir)
+}
+
+// snapshotBlob returns the blob holding the content of abs, copying it into
+// the store (through work) if it is not there yet. Copying rather than
+// linking keeps the snapshot frozen even if another program later rewrites
+// abs in place.
+func (s *Server) snapshotBlob(ctx context.Context, store *dedup.Store, abs string, st os.FileInfo, work string) (string, error) {
+	sha, err := s.fileSHA256(abs, st)
+	if err != nil {
+		return "", err
+	}
+	if blob, ok := store.Lookup(sha); ok {
+		return blob, nil
+	}
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	in := filepath.Join(work, ".in-"+hex.EncodeToString(b[:]))
+	if err := copyFileAtomic(ctx, abs, in, false); err != nil {
+		return "", err
+	}
+	_ = os.Chtimes(in, st.ModTime(), st.ModTime())
+	// A file rewritten since it was hashed gets hashed again by the store.
+	var blob string
+	if now, serr := os.Stat(abs); serr == nil && now.Size() == st.Size() && now.ModTime().Equal(st.ModTime()) {
+		_, blob, _, err = store.PutHashed(ctx, in, sha, st.Size())
+	} else {
+		_, blob, _, err = store.Put(ctx, in)
+	}
+	if err != nil {
+		_ = os.Remove(in)
+	}
+	return blob, err
+}
@@ -0,0 +1,54 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"lanparty/internal/fsutil"
+)
+
+// spaceReserve stays free on every filesystem an upload lands on, so a
+// full disk still has room for config, stats and metadata writes.
+const spaceReserve = 64 << 20
+
+// enoughSpace checks that size more bytes fit on the filesystems of the
+// spool, the state dir (blob store) and destDir before an upload is
+// accepted, answering 507 otherwise. Unknown sizes and platforms without
+// DiskFree pass.
+func (s *Server) enoughSpace(w http.ResponseWriter, r *http.Request, size int64, destDir string) bool {
+	if size <= 0 {
+		return true
+	}
+	cfg := s.cfgForReq(r)
+	for _, dir := range []string{s.spoolDir(r), cfg.StateDir, destDir} {
+		if dir == "" {
+			continue
+		}
+		free, _, err := fsutil.DiskFree(existingDir(dir))
+		if err != nil {
+			continue
+		}
+		if uint64(size)+spaceReserve > free {
+			http.Error(w, fmt.Sprintf("not enough disk space: %d bytes needed, %d free", size, free), http.StatusInsufficientStorage)
+			return false
+		}
+	}
+	return true
+}
+
+// existingDir returns dir or its nearest existing parent; spool and
+// destination folders are created on demand.
+func existingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
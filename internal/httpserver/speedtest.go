@@ -0,0 +1,92 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Speed test endpoints; "lanparty speedtest" is the client.
+//
+//	GET  /api/speedtest/ping              -> {t} (unix ms); time the round trip
+//	GET  /api/speedtest/download?bytes=N  -> N incompressible bytes
+//	POST /api/speedtest/upload            -> body discarded; {bytes, seconds, mbps}
+//
+// Nothing touches the disk, so the numbers are the network (and TLS) alone.
+
+const (
+	speedDefaultBytes = 100 << 20
+	speedMaxBytes     = 4 << 30
+)
+
+var (
+	speedBufOnce sync.Once
+	speedBuf     []byte // 1 MiB of random bytes, repeated
+)
+
+func speedData() []byte {
+	speedBufOnce.Do(func() {
+		speedBuf = make([]byte, 1<<20)
+		if _, err := rand.Read(speedBuf); err != nil {
+			panic(err)
+		}
+	})
+	return speedBuf
+}
+
+func (s *Server) handleSpeedtest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.URL.Path {
+	case "/api/speedtest/ping":
+		writeJSON(w, map[string]any{"t": time.Now().UnixMilli()})
+	case "/api/speedtest/download":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		n := int64(speedDefaultBytes)
+		if v := r.URL.Query().Get("bytes"); v != "" {
+			var err error
+			n, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 || n > speedMaxBytes {
+				http.Error(w, "bad bytes", http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+		buf := speedData()
+		for n > 0 {
+			chunk := buf
+			if int64(len(chunk)) > n {
+				chunk = chunk[:n]
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			n -= int64(len(chunk))
+		}
+	case "/api/speedtest/upload":
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		start := time.Now()
+		n, err := io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, speedMaxBytes))
+		if err != nil {
+			http.Error(w, "upload failed", http.StatusBadRequest)
+			return
+		}
+		secs := time.Since(start).Seconds()
+		mbps := 0.0
+		if secs > 0 {
+			mbps = float64(n) * 8 / secs / 1e6
+		}
+		writeJSON(w, map[string]any{"bytes": n, "seconds": secs, "mbps": mbps})
+	default:
+		http.NotFound(w, r)
+	}
+}
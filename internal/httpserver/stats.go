@@ -0,0 +1,250 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transfer statistics: bytes in/out and requests per share and minute, kept
+// for statsMinutes in memory and saved to <stateDir>/stats.json so a restart
+// does not lose the evening.
+
+const (
+	statsMinutes  = 24 * 60
+	statsFile     = "stats.json"
+	statsStep     = 4 << 20 // ReadFrom slice; keeps long downloads spread over minutes
+	statsSaveEach = time.Minute
+)
+
+type statBucket struct {
+	Minute   int64 `json:"t"` // unix minute
+	Out      int64 `json:"out"`
+	In       int64 `json:"in"`
+	Requests int64 `json:"req"`
+}
+
+type statsRecorder struct {
+	mu     sync.Mutex
+	path   string
+	series map[string]*[statsMinutes]statBucket
+	saved  time.Time
+	saving bool
+}
+
+func newStatsRecorder(stateDir string) *statsRecorder {
+	st := &statsRecorder{series: map[string]*[statsMinutes]statBucket{}, saved: time.Now()}
+	if stateDir == "" {
+		return st
+	}
+	st.path = filepath.Join(stateDir, statsFile)
+	b, err := os.ReadFile(st.path)
+	if err != nil {
+		return st
+	}
+	var saved map[string][]statBucket
+	if json.Unmarshal(b, &saved) != nil {
+		return st
+	}
+	for share, bs := range saved {
+		ring := new([statsMinutes]statBucket)
+		for _, b := range bs {
+			ring[b.Minute%statsMinutes] = b
+		}
+		st.series[share] = ring
+	}
+	return st
+}
+
+func (st *statsRecorder) add(share string, out, in, reqs int64) {
+	minute := time.Now().Unix() / 60
+	st.mu.Lock()
+	ring := st.series[share]
+	if ring == nil {
+		ring = new([statsMinutes]statBucket)
+		st.series[share] = ring
+	}
+	b := &ring[minute%statsMinutes]
+	if b.Minute != minute {
+		*b = statBucket{Minute: minute}
+	}
+	b.Out += out
+	b.In += in
+	b.Requests += reqs
+	save := st.path != "" && !st.saving && time.Since(st.saved) >= statsSaveEach
+	if save {
+		st.saving = true
+	}
+	st.mu.Unlock()
+	if save {
+		go st.save()
+	}
+}
+
+func (st *statsRecorder) save() {
+	st.mu.Lock()
+	cutoff := time.Now().Unix()/60 - statsMinutes
+	out := make(map[string][]statBucket, len(st.series))
+	for share, ring := range st.series {
+		for _, b := range ring {
+			if b.Minute > cutoff && (b.Out != 0 || b.In != 0 || b.Requests != 0) {
+				out[share] = append(out[share], b)
+			}
+		}
+	}
+	st.mu.Unlock()
+	if b, err := json.Marshal(out); err == nil {
+		tmp := st.path + ".tmp"
+		if os.WriteFile(tmp, b, 0o644) == nil {
+			_ = os.Rename(tmp, st.path)
+		}
+	}
+	st.mu.Lock()
+	st.saved = time.Now()
+	st.saving = false
+	st.mu.Unlock()
+}
+
+// window returns the last n minutes of share, oldest first, zero-filled.
+func (st *statsRecorder) window(share string, n int) []statBucket {
+	now := time.Now().Unix() / 60
+	out := make([]statBucket, n)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ring := st.series[share]
+	for i := range out {
+		m := now - int64(n-1-i)
+		out[i] = statBucket{Minute: m}
+		if ring != nil {
+			if b := ring[m%statsMinutes]; b.Minute == m {
+				out[i] = b
+			}
+		}
+	}
+	return out
+}
+
+func (st *statsRecorder) shares() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	names := make([]string, 0, len(st.series))
+	for n := range st.series {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// countTraffic wraps w and r's body so their bytes land in the share's stats.
+func (s *Server) countTraffic(share string, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	s.stats.add(share, 0, 0, 1)
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingBody{ReadCloser: r.Body, add: func(n int64) { s.stats.add(share, 0, n, 0) }}
+	}
+	return &countingRW{ResponseWriter: w, add: func(n int64) { s.stats.add(share, n, 0, 0) }}, r
+}
+
+type countingBody struct {
+	io.ReadCloser
+	add func(int64)
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		c.add(int64(n))
+	}
+	return n, err
+}
+
+type countingRW struct {
+	http.ResponseWriter
+	add func(int64)
+}
+
+func (c *countingRW) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	if n > 0 {
+		c.add(int64(n))
+	}
+	return n, err
+}
+
+// ReadFrom keeps sendfile working: each slice is handed to the underlying
+// writer as a LimitedReader over the original source.
+func (c *countingRW) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := c.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{c}, src)
+	}
+	var total int64
+	for {
+		step := &io.LimitedReader{R: src, N: statsStep}
+		lr, limited := src.(*io.LimitedReader)
+		if limited {
+			if lr.N <= 0 {
+				return total, nil
+			}
+			step = &io.LimitedReader{R: lr.R, N: min(lr.N, statsStep)}
+		}
+		n, err := rf.ReadFrom(step)
+		total += n
+		c.add(n)
+		if limited {
+			lr.N -= n
+		}
+		if err != nil || n < statsStep {
+			return total, err
+		}
+	}
+}
+
+func (c *countingRW) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *countingRW) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+// handleAdminStats returns per-minute transfer history.
+//
+//	GET /api/admin/stats/timeseries?minutes=60 -> {step: 60, series: {"<share>": [{t, out, in, req}]}}
+//
+// t is the unix minute; "" is the default root. minutes defaults to 60 and is
+// capped at 24h. Under /s/<name>/ only that share is returned.
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.adminOnly(w, r) {
+		return
+	}
+	n := 60
+	if v := r.URL.Query().Get("minutes"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			http.Error(w, "bad minutes", http.StatusBadRequest)
+			return
+		}
+	}
+	if n > statsMinutes {
+		n = statsMinutes
+	}
+	names := s.stats.shares()
+	if name := shareFromContext(r.Context()); name != "" {
+		names = []string{name}
+	}
+	series := make(map[string][]statBucket, len(names))
+	for _, name := range names {
+		series[name] = s.stats.window(name, n)
+	}
+	writeJSON(w, map[string]any{"step": 60, "series": series})
+}
@@ -0,0 +1,204 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"lanparty/internal/dedup"
+	"lanparty/internal/fsutil"
+	"lanparty/internal/swarm"
+)
+
+// Swarm tracker (see package swarm for the client side).
+//
+// Chunk lists are computed once per file version (path, size, mtime) and kept
+// in memory as fixed swarm.PieceSize pieces. Peers are remembered for
+// swarmPeerTTL after their last announce.
+
+const (
+	swarmPeerTTL  = 2 * time.Minute
+	swarmIdleTTL  = time.Hour // drop chunk lists unused this long
+	swarmMaxPeers = 32        // peers returned per request
+)
+
+type swarmFile struct {
+	ready chan struct{} // closed once plan/err are set
+	plan  swarm.Plan
+	err   error
+	used  time.Time
+	peers map[string]*swarmPeer // by URL
+}
+
+type swarmPeer struct {
+	have string
+	seen time.Time
+}
+
+// handleSwarm returns a file's chunk list and peers; POST also announces the
+// caller as a peer.
+//
+//	GET  /api/swarm?path=<rel>                     -> {size, sha256, chunks, peers}
+//	POST /api/swarm?path=<rel> {port, have}        -> same, without the caller
+//
+// A peer's URL is http://<request address>:<port>; an empty have removes it.
+func (s *Server) handleSwarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil || rel == "" {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !st.Mode().IsRegular() {
+		http.Error(w, "not a file", http.StatusBadRequest)
+		return
+	}
+
+	var ann swarm.Announce
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&ann); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if ann.Port < 0 || ann.Port > 65535 {
+			http.Error(w, "bad port", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sf, err := s.filePlan(r, abs, st)
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "hash failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	self := ""
+	if ann.Port > 0 {
+		self = HostURL("http", s.remoteHost(r), ann.Port)
+	}
+	now := time.Now()
+	out := sf.plan
+	s.swarmMu.Lock()
+	if self != "" {
+		if swarm.HasAny(ann.Have) {
+			sf.peers[self] = &swarmPeer{have: ann.Have, seen: now}
+		} else {
+			delete(sf.peers, self)
+		}
+	}
+	out.Peers = make([]swarm.Peer, 0, len(sf.peers))
+	for u, p := range sf.peers {
+		if now.Sub(p.seen) > swarmPeerTTL {
+			delete(sf.peers, u)
+			continue
+		}
+		if u != self {
+			out.Peers = append(out.Peers, swarm.Peer{URL: u, Have: p.have})
+		}
+	}
+	s.swarmMu.Unlock()
+	rand.Shuffle(len(out.Peers), func(i, j int) { out.Peers[i], out.Peers[j] = out.Peers[j], out.Peers[i] })
+	if len(out.Peers) > swarmMaxPeers {
+		out.Peers = out.Peers[:swarmMaxPeers]
+	}
+	writeJSON(w, out)
+}
+
+// filePlan returns the tracker entry of abs's current version, with its
+// chunk list computed. /api/chunks shares it.
+func (s *Server) filePlan(r *http.Request, abs string, st os.FileInfo) (*swarmFile, error) {
+	key := shareFromContext(r.Context()) + "\x00" + abs + "\x00" + strconv.FormatInt(st.Size(), 10) + "\x00" + strconv.FormatInt(st.ModTime().UnixNano(), 10)
+	sf, owner := s.swarmEntry(key)
+	if owner {
+		// First request for this version computes the chunk list, even if its
+		// client goes away; others wait.
+		sf.plan, sf.err = swarmPlan(abs, st.Size())
+		close(sf.ready)
+	}
+	select {
+	case <-sf.ready:
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+	if sf.err != nil {
+		s.swarmMu.Lock()
+		if s.swarms[key] == sf {
+			delete(s.swarms, key)
+		}
+		s.swarmMu.Unlock()
+		return nil, sf.err
+	}
+	return sf, nil
+}
+
+// swarmEntry returns the tracker entry for key. owner is true for the caller
+// that created it and must set plan/err and close ready.
+func (s *Server) swarmEntry(key string) (sf *swarmFile, owner bool) {
+	s.swarmMu.Lock()
+	defer s.swarmMu.Unlock()
+	now := time.Now()
+	for k, e := range s.swarms {
+		if len(e.peers) == 0 && now.Sub(e.used) > swarmIdleTTL {
+			delete(s.swarms, k)
+		}
+	}
+	sf = s.swarms[key]
+	if sf == nil {
+		sf = &swarmFile{ready: make(chan struct{}), peers: map[string]*swarmPeer{}}
+		s.swarms[key] = sf
+		owner = true
+	}
+	sf.used = now
+	return sf, owner
+}
+
+// swarmPlan hashes abs into swarm.PieceSize pieces.
+func swarmPlan(abs string, size int64) (swarm.Plan, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return swarm.Plan{}, err
+	}
+	defer f.Close()
+	whole := sha256.New()
+	plan := swarm.Plan{Size: size, Chunks: []dedup.Chunk{}}
+	buf := make([]byte, swarm.PieceSize)
+	var off int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			whole.Write(buf[:n])
+			sum := sha256.Sum256(buf[:n])
+			plan.Chunks = append(plan.Chunks, dedup.Chunk{Off: off, Len: n, Hash: hex.EncodeToString(sum[:])})
+			off += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return swarm.Plan{}, err
+		}
+	}
+	if off != size {
+		return swarm.Plan{}, io.ErrUnexpectedEOF
+	}
+	plan.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	return plan, nil
+}
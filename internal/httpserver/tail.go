@@ -0,0 +1,242 @@
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"lanparty/internal/fsutil"
+)
+
+// Live tail: /api/tail streams the last lines of a text file and, with
+// follow=1, the lines appended to it afterwards as server-sent events, so a
+// game server's log in the share can be watched from a browser. The file is
+// polled, not watched; rotation (the path now names another file) and
+// truncation restart from the top of the new content.
+
+const (
+	tailDefaultLines = 100
+	tailMaxLines     = 1000
+	tailMaxLine      = 16 << 10  // longer lines are cut
+	tailMaxRead      = 256 << 10 // per poll, and for the initial lines
+	tailPoll         = 500 * time.Millisecond
+	tailPing         = 15 * time.Second
+	tailMaxDuration  = time.Hour
+	maxTails         = 32
+)
+
+// handleTail streams a file's last lines, then what is appended to it.
+//
+//	GET /api/tail?path=<file>[&lines=<n>][&follow=1]  -> text/event-stream
+//
+// Every line is one message event. With follow=1 the stream stays open for
+// up to an hour, sending a "reset" event when the file is truncated or
+// replaced; it ends with an "end" event whose data says why (eof, timeout,
+// gone, shutdown). lines defaults to 100, at most 1000. Lines longer than
+// 16 KiB are cut, bytes that are not UTF-8 replaced.
+func (s *Server) handleTail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	rel := fsutil.CleanRelPath(q.Get("path"))
+	lines := tailDefaultLines
+	if v := q.Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "bad lines", http.StatusBadRequest)
+			return
+		}
+		lines = min(n, tailMaxLines)
+	}
+	follow := q.Get("follow") == "1"
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(abs)
+	if err != nil || s.expiredPath(r, rel) {
+		http.NotFound(w, r)
+		return
+	}
+	defer func() { f.Close() }()
+	st, err := f.Stat()
+	if err != nil {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return
+	}
+	if !st.Mode().IsRegular() {
+		http.Error(w, "not a file", http.StatusBadRequest)
+		return
+	}
+	if follow {
+		if s.tails.Add(1) > maxTails {
+			s.tails.Add(-1)
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "too many live tails", http.StatusTooManyRequests)
+			return
+		}
+		defer s.tails.Add(-1)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	end := func(why string) {
+		fmt.Fprintf(w, "event: end\ndata: %s\n\n", why)
+		flush()
+	}
+
+	// The last lines, from at most tailMaxRead before the end.
+	off := max(st.Size()-tailMaxRead, 0)
+	buf := make([]byte, st.Size()-off)
+	n, err := f.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		end("gone")
+		return
+	}
+	buf = buf[:n]
+	off += int64(n)
+	if off-int64(n) > 0 {
+		// Started inside a line.
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			buf = buf[i+1:]
+		} else {
+			buf = nil
+		}
+	}
+	var partial []byte
+	if follow {
+		// An unfinished last line is held back until it is finished.
+		if i := bytes.LastIndexByte(buf, '\n'); i < len(buf)-1 {
+			partial = append(partial, buf[i+1:]...)
+			buf = buf[:i+1]
+		}
+	}
+	all := splitTailLines(buf)
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	if !writeTailLines(w, all) {
+		return
+	}
+	if !follow {
+		end("eof")
+		return
+	}
+	flush()
+
+	poll := time.NewTicker(tailPoll)
+	defer poll.Stop()
+	deadline := time.NewTimer(tailMaxDuration)
+	defer deadline.Stop()
+	lastWrite := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.draining:
+			end("shutdown")
+			return
+		case <-deadline.C:
+			end("timeout")
+			return
+		case <-poll.C:
+		}
+		cur, err := os.Stat(abs)
+		if err != nil {
+			end("gone")
+			return
+		}
+		if st, err := f.Stat(); err != nil || !os.SameFile(st, cur) {
+			// Rotated: follow the new file from its start.
+			nf, err := os.Open(abs)
+			if err != nil {
+				end("gone")
+				return
+			}
+			f.Close()
+			f, off, partial = nf, 0, nil
+			io.WriteString(w, "event: reset\ndata: rotated\n\n")
+		} else if cur.Size() < off {
+			off, partial = 0, nil
+			io.WriteString(w, "event: reset\ndata: truncated\n\n")
+		}
+		chunk := make([]byte, min(cur.Size()-off, tailMaxRead))
+		n, _ := f.ReadAt(chunk, off)
+		off += int64(n)
+		partial = append(partial, chunk[:n]...)
+		var ls []string
+		if i := bytes.LastIndexByte(partial, '\n'); i >= 0 {
+			ls = splitTailLines(partial[:i+1])
+			partial = append(partial[:0], partial[i+1:]...)
+		}
+		if len(partial) > tailMaxLine {
+			ls = append(ls, string(partial))
+			partial = partial[:0]
+		}
+		if len(ls) > 0 {
+			if !writeTailLines(w, ls) {
+				return
+			}
+		} else if time.Since(lastWrite) >= tailPing {
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		} else {
+			continue
+		}
+		lastWrite = time.Now()
+		flush()
+	}
+}
+
+// splitTailLines splits complete lines, dropping line ends, cutting long
+// lines and replacing invalid UTF-8.
+func splitTailLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	for i, l := range parts {
+		l = strings.TrimSuffix(l, "\r")
+		if len(l) > tailMaxLine {
+			l = l[:tailMaxLine]
+		}
+		parts[i] = strings.ToValidUTF8(l, "�")
+	}
+	return parts
+}
+
+// writeTailLines sends each line as one message event; carriage returns
+// inside a line would end the data field, so they become spaces.
+func writeTailLines(w io.Writer, lines []string) bool {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString("data: ")
+		b.WriteString(strings.ReplaceAll(l, "\r", " "))
+		b.WriteString("\n\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err == nil
+}
+
+// Drain ends live tails so a graceful shutdown need not wait for them.
+// Register it with http.Server.RegisterOnShutdown.
+func (s *Server) Drain() {
+	s.drainOnce.Do(func() { close(s.draining) })
+}
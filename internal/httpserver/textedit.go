@@ -0,0 +1,112 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"lanparty/internal/fsutil"
+)
+
+// Editing text files in the browser without clobbering each other: read a
+// file with /api/read, which returns its hash and mtime, and send them back
+// as ifHash / ifMtime with the /api/write that saves it. If the file changed
+// in between, the write answers 412 with the current hash and mtime instead
+// of overwriting, and the client can show the conflict.
+
+// maxEditSize caps files read and written through the edit API.
+const maxEditSize = 2 * 1024 * 1024
+
+// handleRead returns a text file for editing.
+//
+//	GET /api/read?path=<file>  -> {path, content, sha256, size, mtime}
+//
+// The hash is of exactly the content returned. Files over maxEditSize are
+// 413, files that are not UTF-8 text 415.
+func (s *Server) handleRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := fsutil.CleanRelPath(r.URL.Query().Get("path"))
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(abs)
+	if err != nil || s.expiredPath(r, rel) {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return
+	}
+	if st.IsDir() {
+		http.Error(w, "is a directory", http.StatusBadRequest)
+		return
+	}
+	b, err := io.ReadAll(io.LimitReader(f, maxEditSize+1))
+	if err != nil {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return
+	}
+	if len(b) > maxEditSize {
+		http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !utf8.Valid(b) {
+		http.Error(w, "not a text file", http.StatusUnsupportedMediaType)
+		return
+	}
+	sum := sha256.Sum256(b)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]any{
+		"path":    rel,
+		"content": string(b),
+		"sha256":  hex.EncodeToString(sum[:]),
+		"size":    len(b),
+		"mtime":   st.ModTime().Unix(),
+	})
+}
+
+// writePrecondition checks that abs still has the given mtime (unix
+// seconds) and hash, either of which may be unset, and otherwise answers
+// 412 with what it has now: {error, exists, sha256, mtime}. The caller
+// holds s.writeMu.
+func (s *Server) writePrecondition(w http.ResponseWriter, abs string, ifMtime int64, ifHash string) bool {
+	st, err := os.Stat(abs)
+	if err != nil || !st.Mode().IsRegular() {
+		preconditionFailed(w, map[string]any{"error": "file is gone", "exists": false})
+		return false
+	}
+	sha, err := s.fileSHA256(abs, st)
+	if err != nil {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return false
+	}
+	if (ifMtime != 0 && st.ModTime().Unix() != ifMtime) || (ifHash != "" && !strings.EqualFold(ifHash, sha)) {
+		preconditionFailed(w, map[string]any{
+			"error":  "file changed since it was read",
+			"exists": true,
+			"sha256": sha,
+			"mtime":  st.ModTime().Unix(),
+		})
+		return false
+	}
+	return true
+}
+
+func preconditionFailed(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	writeJSON(w, v)
+}
@@ -0,0 +1,298 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+// Bandwidth throttling (config.Throttle): token buckets around the copy
+// paths. Every transfer draws from the overall bucket of its direction and
+// from the bucket of its user (or token, where one has its own cap), so one
+// guest cannot take the whole link. Caps are read from the current config
+// each time a transfer draws, so edits and scheduled windows apply to
+// running transfers too. Downloads handed to a proxy (proxySendfile) are
+// out of reach.
+
+// throttleSlice is the most a transfer draws from its buckets at once.
+const throttleSlice = 16 << 10
+
+// bucket is a token bucket refilled at the rate its callers pass in.
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64 // bytes; negative once drawn ahead
+	last   time.Time
+}
+
+// take draws n bytes at rate bytes/s and returns how long to wait before
+// sending them. Up to a tenth of a second's worth may be sent in a burst.
+func (b *bucket) take(n int, rate float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	burst := max(rate/10, throttleSlice)
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func checkThrottle(t *config.Throttle) error {
+	if t == nil {
+		return nil
+	}
+	if err := checkThrottleRates(t.ThrottleRates); err != nil {
+		return err
+	}
+	for i, w := range t.Schedule {
+		if _, err := clockMinutes(w.From); err != nil {
+			return fmt.Errorf("schedule %d: from: %w", i, err)
+		}
+		if _, err := clockMinutes(w.To); err != nil {
+			return fmt.Errorf("schedule %d: to: %w", i, err)
+		}
+		for _, d := range w.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("schedule %d: unknown day %q", i, d)
+			}
+		}
+		if err := checkThrottleRates(w.ThrottleRates); err != nil {
+			return fmt.Errorf("schedule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func checkThrottleRates(t config.ThrottleRates) error {
+	rates := map[string]config.Rate{"": {DownKiBps: t.DownKiBps, UpKiBps: t.UpKiBps}, "perUser": t.PerUser}
+	for name, r := range t.Users {
+		rates["users."+name] = r
+	}
+	for name, r := range t.Tokens {
+		rates["tokens."+name] = r
+	}
+	for where, r := range rates {
+		if r.DownKiBps < 0 || r.UpKiBps < 0 {
+			if where == "" {
+				return errors.New("rates cannot be negative")
+			}
+			return fmt.Errorf("%s: rates cannot be negative", where)
+		}
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// clockMinutes parses "HH:MM" into minutes after midnight.
+func clockMinutes(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, errors.New(`want "HH:MM"`)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// throttleRates returns the rates in effect at now and the name of the
+// schedule window they come from ("" for the base rates).
+func throttleRates(t *config.Throttle, now time.Time) (config.ThrottleRates, string) {
+	m := now.Hour()*60 + now.Minute()
+	for i, w := range t.Schedule {
+		from, err1 := clockMinutes(w.From)
+		to, err2 := clockMinutes(w.To)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// The day the window started on: yesterday for the part of a
+		// window past midnight.
+		day, in := now.Weekday(), false
+		switch {
+		case from == to:
+			in = true
+		case from < to:
+			in = from <= m && m < to
+		case m >= from:
+			in = true
+		case m < to:
+			in, day = true, now.AddDate(0, 0, -1).Weekday()
+		}
+		if !in || !onWeekday(w.Days, day) {
+			continue
+		}
+		name := w.Name
+		if name == "" {
+			name = fmt.Sprintf("schedule %d", i)
+		}
+		return w.ThrottleRates, name
+	}
+	return t.ThrottleRates, ""
+}
+
+func onWeekday(days []string, d time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, v := range days {
+		if wd, ok := weekdays[strings.ToLower(v)]; ok && wd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// throttleOwn returns the bucket key and rate (KiB/s) of the user's or
+// token's own cap in one direction.
+func throttleOwn(cfg *config.Config, t config.ThrottleRates, user, tok string, up bool) (string, int) {
+	pick := func(r config.Rate) int {
+		if up {
+			return r.UpKiBps
+		}
+		return r.DownKiBps
+	}
+	if tok != "" {
+		if r, ok := t.Tokens[tokenID(tok)]; ok {
+			return "t:" + tokenID(tok), pick(r)
+		}
+		if label := cfg.TokenLabels[tok]; label != "" {
+			if r, ok := t.Tokens[label]; ok {
+				return "t:" + tokenID(tok), pick(r)
+			}
+		}
+	}
+	if r, ok := t.Users[user]; ok {
+		return "u:" + user, pick(r)
+	}
+	return "u:" + user, pick(t.PerUser)
+}
+
+// throttleWait blocks until n more bytes of the request's transfers in one
+// direction fit the caps.
+func (s *Server) throttleWait(ctx context.Context, user, tok string, up bool, n int) error {
+	cfg := s.config()
+	if cfg.Throttle == nil {
+		return nil
+	}
+	t, _ := throttleRates(cfg.Throttle, time.Now())
+	dir, global := "down", t.DownKiBps
+	if up {
+		dir, global = "up", t.UpKiBps
+	}
+	key, own := throttleOwn(cfg, t, user, tok, up)
+	var d time.Duration
+	if global > 0 {
+		d = max(d, s.bucket(dir).take(n, float64(global)*1024))
+	}
+	if own > 0 {
+		d = max(d, s.bucket(dir+"\x00"+key).take(n, float64(own)*1024))
+	}
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) bucket(key string) *bucket {
+	s.throttleMu.Lock()
+	defer s.throttleMu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{}
+		s.buckets[key] = b
+	}
+	return b
+}
+
+// throttleDown wraps w so what it sends counts against the download caps.
+// Without caps w comes back as is.
+func (s *Server) throttleDown(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if s.config().Throttle == nil {
+		return w
+	}
+	ctx, user, tok := r.Context(), auth.UserFromContext(r.Context()), requestToken(r)
+	return &throttledRW{ResponseWriter: w, wait: func(n int) error { return s.throttleWait(ctx, user, tok, false, n) }}
+}
+
+// throttleUp makes r's body count against the upload caps.
+func (s *Server) throttleUp(r *http.Request) {
+	if s.config().Throttle == nil || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	ctx, user, tok := r.Context(), auth.UserFromContext(r.Context()), requestToken(r)
+	r.Body = &throttledBody{ReadCloser: r.Body, wait: func(n int) error { return s.throttleWait(ctx, user, tok, true, n) }}
+}
+
+// throttledRW paces writes. It deliberately has no ReadFrom: sendfile
+// would bypass the pacing.
+type throttledRW struct {
+	http.ResponseWriter
+	wait func(n int) error
+}
+
+func (t *throttledRW) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), throttleSlice)
+		if err := t.wait(n); err != nil {
+			return written, err
+		}
+		m, err := t.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (t *throttledRW) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (t *throttledRW) Unwrap() http.ResponseWriter { return t.ResponseWriter }
+
+// throttledBody paces reads.
+type throttledBody struct {
+	io.ReadCloser
+	wait func(n int) error
+}
+
+func (t *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleSlice {
+		p = p[:throttleSlice]
+	}
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := t.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -1,11 +1,18 @@
 package httpserver
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
+	"io"
+	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 
 	// decoders
@@ -18,6 +25,8 @@ import (
 	"golang.org/x/image/font/basicfont"
 	"golang.org/x/image/math/fixed"
 	_ "golang.org/x/image/webp"
+
+	"lanparty/internal/exif"
 )
 
 func makeThumb(absPath string, max int) ([]byte, error) {
@@ -27,10 +36,116 @@ func makeThumb(absPath string, max int) ([]byte, error) {
 	}
 	defer f.Close()
 
+	orient := 0
+	if ext := strings.ToLower(path.Ext(absPath)); ext == ".jpg" || ext == ".jpeg" {
+		if ex, err := exif.Read(f); err == nil {
+			orient = ex.Orientation
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
 	src, _, err := image.Decode(f)
 	if err != nil {
 		return nil, err
 	}
+	return scaleThumb(orientImage(src, orient), max)
+}
+
+// orientImage applies an EXIF orientation (2-8) so the image displays
+// upright. Other values return src unchanged.
+func orientImage(src image.Image, orient int) image.Image {
+	if orient < 2 || orient > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orient >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orient {
+			case 2: // mirror horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirror vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 CW
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 CCW
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// thumbKey names the cached thumbnail of rel at size max; it changes with the
+// file's mtime. The "o" suffix marks orientation-corrected thumbnails so
+// stale sideways ones from older builds are not reused.
+func thumbKey(rel string, st os.FileInfo, max int, kind string) string {
+	return safeKey(rel) + "-" + fmt.Sprintf("%d", st.ModTime().Unix()) + "-" + fmt.Sprintf("%d", max) + "-" + kind + "o.jpg"
+}
+
+// renderThumb builds the thumbnail of abs: a text preview for kind "txt",
+// the first image of a .zip, a preview plugin's image, or the image itself.
+func (s *Server) renderThumb(ctx context.Context, key, abs, kind string, max int) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(abs))
+	if kind == "txt" && isTextExt(ext) {
+		return s.thumbDo(ctx, key, func() ([]byte, error) { return makeTextThumb(abs, max) })
+	}
+	if ext == ".zip" {
+		return s.thumbDo(ctx, key, func() ([]byte, error) { return makeZipThumb(abs, max) })
+	}
+	if pv := s.previewerFor(ext); pv != nil {
+		return s.thumbDo(ctx, key, func() ([]byte, error) { return makePluginThumb(ctx, pv, abs, max) })
+	}
+	return s.thumbDo(ctx, key, func() ([]byte, error) { return makeThumb(abs, max) })
+}
+
+// readThumbCache loads a cached thumbnail, decrypting it when the state dir
+// is encrypted. Unreadable entries (e.g. written before a key change) miss.
+func (s *Server) readThumbCache(share, p, name string) ([]byte, bool) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	store, _, err := s.shareDepsFor(share)
+	if err != nil {
+		return nil, false
+	}
+	if key := store.Key(); key != nil {
+		if b, err = key.Open(b, []byte(name)); err != nil {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+// writeThumbCache stores a thumbnail, sealed when the state dir is encrypted.
+func (s *Server) writeThumbCache(share, p, name string, b []byte) {
+	store, _, err := s.shareDepsFor(share)
+	if err != nil {
+		return
+	}
+	if key := store.Key(); key != nil {
+		b = key.Seal(b, []byte(name))
+	}
+	_ = os.WriteFile(p, b, 0o644)
+}
+
+// scaleThumb downsizes src to fit within max x max and encodes it as JPEG.
+func scaleThumb(src image.Image, max int) ([]byte, error) {
 	b := src.Bounds()
 	w, h := b.Dx(), b.Dy()
 	if w <= 0 || h <= 0 {
@@ -70,6 +185,39 @@ func makeThumb(absPath string, max int) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
+// maxZipThumbEntry caps the uncompressed size of an archive entry we are
+// willing to decode for a preview.
+const maxZipThumbEntry = 64 << 20
+
+// makeZipThumb renders a thumbnail from the first image entry (in archive
+// order) of a zip file.
+func makeZipThumb(absPath string, max int) ([]byte, error) {
+	zr, err := zip.OpenReader(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, "/") || !isImageExt(strings.ToLower(path.Ext(zf.Name))) {
+			continue
+		}
+		if zf.UncompressedSize64 == 0 || zf.UncompressedSize64 > maxZipThumbEntry {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			continue
+		}
+		src, _, err := image.Decode(io.LimitReader(rc, maxZipThumbEntry))
+		_ = rc.Close()
+		if err != nil {
+			continue
+		}
+		return scaleThumb(src, max)
+	}
+	return nil, os.ErrNotExist
+}
+
 func makeTextThumb(absPath string, max int) ([]byte, error) {
 	if max <= 0 {
 		max = 256
@@ -153,4 +301,109 @@ func makeTextThumb(absPath string, max int) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
+// iconColors tint fallback thumbnails by kind of file; other types get a
+// color picked from iconPalette by extension, so a type always looks the
+// same.
+var iconColors = map[string]color.RGBA{
+	"video":   {R: 0xb9, G: 0x1c, B: 0x1c, A: 0xff},
+	"audio":   {R: 0x7c, G: 0x3a, B: 0xed, A: 0xff},
+	"archive": {R: 0xb4, G: 0x53, B: 0x09, A: 0xff},
+	"text":    {R: 0x33, G: 0x41, B: 0x55, A: 0xff},
+	"pdf":     {R: 0xdc, G: 0x26, B: 0x26, A: 0xff},
+	"image":   {R: 0x04, G: 0x78, B: 0x57, A: 0xff},
+}
+
+var iconPalette = []color.RGBA{
+	{R: 0x1d, G: 0x4e, B: 0xd8, A: 0xff},
+	{R: 0x0e, G: 0x74, B: 0x90, A: 0xff},
+	{R: 0x4d, G: 0x7c, B: 0x0f, A: 0xff},
+	{R: 0xbe, G: 0x18, B: 0x5d, A: 0xff},
+	{R: 0x6d, G: 0x28, B: 0xd9, A: 0xff},
+	{R: 0x47, G: 0x55, B: 0x69, A: 0xff},
+}
+
+func iconColor(ext string) color.RGBA {
+	switch {
+	case isVideoExt(ext):
+		return iconColors["video"]
+	case isAudioExt(ext):
+		return iconColors["audio"]
+	case ext == ".pdf":
+		return iconColors["pdf"]
+	case isImageExt(ext):
+		return iconColors["image"]
+	case isTextExt(ext):
+		return iconColors["text"]
+	}
+	switch ext {
+	case ".zip", ".7z", ".rar", ".tar", ".gz", ".tgz", ".xz", ".bz2", ".zst", ".iso":
+		return iconColors["archive"]
+	}
+	var h uint32
+	for i := 0; i < len(ext); i++ {
+		h = h*31 + uint32(ext[i])
+	}
+	return iconPalette[h%uint32(len(iconPalette))]
+}
+
+// makeIconThumb draws a placeholder for files without a real preview: the
+// extension in capitals on a colored tile, as a max x max JPEG.
+func makeIconThumb(ext string, max int) ([]byte, error) {
+	label := strings.ToUpper(strings.TrimPrefix(ext, "."))
+	if label == "" {
+		label = "FILE"
+	}
+	if len(label) > 5 {
+		label = label[:5]
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, max, max))
+	bg := iconColor(ext)
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	// A darker band at the bottom, like a file-type badge.
+	band := color.RGBA{R: bg.R / 4 * 3, G: bg.G / 4 * 3, B: bg.B / 4 * 3, A: 0xff}
+	draw.Draw(img, image.Rect(0, max*3/4, max, max), image.NewUniform(band), image.Point{}, draw.Src)
+
+	// basicfont is 7x13; render the label small and scale it up so it
+	// spans about 60% of the tile (less for short labels).
+	face := basicfont.Face7x13
+	tw := 7 * len(label)
+	txt := image.NewRGBA(image.Rect(0, 0, tw, 13))
+	d := &font.Drawer{Dst: txt, Src: image.White, Face: face, Dot: fixed.P(0, 11)}
+	d.DrawString(label)
+	scale := max * 6 / 10 / tw
+	if lim := max * 3 / 10 / 13; scale > lim {
+		scale = lim
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	w, h := tw*scale, 13*scale
+	x, y := (max-w)/2, (max*3/4-h)/2
+	draw.NearestNeighbor.Scale(img, image.Rect(x, y, x+w, y+h), txt, txt.Bounds(), draw.Over, nil)
 
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: 82}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// serveIconThumb answers a thumbnail request with makeIconThumb. The image
+// only depends on extension and size, so it is cached like a real one.
+func serveIconThumb(w http.ResponseWriter, r *http.Request, ext string, max int) {
+	etag := `"icon-` + safeKey(strings.TrimPrefix(ext, ".")) + "-" + fmt.Sprintf("%d", max) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if inm := r.Header.Get("If-None-Match"); inm != "" && strings.Contains(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	b, err := makeIconThumb(ext, max)
+	if err != nil {
+		http.Error(w, "thumbnail failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	_, _ = w.Write(b)
+}
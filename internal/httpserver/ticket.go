@@ -0,0 +1,290 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/fsutil"
+)
+
+// Upload tickets: the upload analog of signed links. Someone with write
+// access to a folder mints a one-time URL for a guest without an account:
+//
+//	/t/<id>            a small upload page for browsers
+//	/api/ticket/<id>   GET -> {dir, maxBytes, exp}; POST multipart -> as /api/upload
+//
+// Tickets live in the share's "uploadtickets" meta store. Like a signed
+// link, a ticket authenticates its request as the user who issued it, with
+// the provider and groups they signed in with, so their ACLs still apply,
+// they end up owning the file, and the ticket dies with their account. The upload always
+// renames on conflict, may not exceed maxBytes, and uses the ticket up once
+// it succeeds.
+
+const (
+	ticketDefaultTTL = 24 * time.Hour
+	ticketMaxTTL     = 7 * 24 * time.Hour
+	// ticketSlack is what the multipart framing may add to a capped file.
+	ticketSlack = 64 << 10
+)
+
+type uploadTicket struct {
+	Dir      string   `json:"dir"`
+	MaxBytes int64    `json:"maxBytes"`
+	Exp      int64    `json:"exp"` // unix seconds
+	User     string   `json:"user,omitempty"`
+	Via      string   `json:"via,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Created  int64    `json:"created"`
+}
+
+// issuer returns the session the ticket's uploads act as.
+func (t uploadTicket) issuer() session {
+	return session{User: t.User, Via: t.Via, Groups: t.Groups}
+}
+
+// ticketID returns the ticket id of a /t/ or /api/ticket/ request path.
+func ticketID(urlPath string) (string, bool) {
+	for _, p := range []string{"/t/", "/api/ticket/"} {
+		if id, ok := strings.CutPrefix(urlPath, p); ok && id != "" && !strings.Contains(id, "/") {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// lookupTicket returns the live ticket id of the request's share. Expired
+// tickets are dropped on the way.
+func (s *Server) lookupTicket(r *http.Request, id string) (uploadTicket, bool) {
+	store, err := s.metaStore(r, "uploadtickets")
+	if err != nil {
+		return uploadTicket{}, false
+	}
+	var t uploadTicket
+	if ok, err := store.Get(id, &t); err != nil || !ok {
+		return uploadTicket{}, false
+	}
+	if time.Now().Unix() > t.Exp {
+		_ = store.Delete(id)
+		return uploadTicket{}, false
+	}
+	if t.User != "" && !identityLive(s.cfgForReq(r), t.User, t.Via) {
+		return uploadTicket{}, false
+	}
+	return t, true
+}
+
+// ticketUser checks the ticket of a /t/ or /api/ticket/ request and returns
+// who it was issued by.
+func (s *Server) ticketUser(r *http.Request) (session, bool) {
+	id, ok := ticketID(r.URL.Path)
+	if !ok {
+		return session{}, false
+	}
+	t, ok := s.lookupTicket(r, id)
+	return t.issuer(), ok
+}
+
+// handleUploadTickets issues, lists and revokes upload tickets.
+//
+//	POST   /api/upload-tickets {path, maxBytes, ttl}  -> {id, url, path, maxBytes, exp}
+//	GET    /api/upload-tickets                        -> {tickets: [{id, url, path, maxBytes, exp, user, created}]}
+//	DELETE /api/upload-tickets?id=<id>                -> {ok}
+//
+// Issuing needs write access to the folder; ttl defaults to a day and is
+// capped at seven. The list holds the caller's own tickets, and only the
+// issuer or an admin of the folder can revoke one.
+func (s *Server) handleUploadTickets(w http.ResponseWriter, r *http.Request) {
+	store, err := s.metaStore(r, "uploadtickets")
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+	user := auth.UserFromContext(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		type view struct {
+			ID       string `json:"id"`
+			URL      string `json:"url"`
+			Path     string `json:"path"`
+			MaxBytes int64  `json:"maxBytes"`
+			Exp      int64  `json:"exp"`
+			User     string `json:"user,omitempty"`
+			Created  int64  `json:"created"`
+		}
+		out := []view{}
+		for _, id := range store.Keys("") {
+			t, ok := s.lookupTicket(r, id)
+			if !ok || t.User != user {
+				continue
+			}
+			out = append(out, view{ID: id, URL: s.withSharePrefix(r, "/t/"+id), Path: t.Dir, MaxBytes: t.MaxBytes, Exp: t.Exp, User: t.User, Created: t.Created})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Created > out[j].Created })
+		writeJSON(w, map[string]any{"tickets": out})
+	case http.MethodPost:
+		var req struct {
+			Path     string `json:"path"`
+			MaxBytes int64  `json:"maxBytes"`
+			TTL      int64  `json:"ttl"` // seconds
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		rel := fsutil.CleanRelPath(req.Path)
+		if ok, err := s.allowed(r, auth.PermWrite, "/"+rel); err != nil || !ok {
+			if s.shouldChallenge(r) {
+				s.authChallenge(w)
+			} else {
+				http.Error(w, "forbidden", http.StatusForbidden)
+			}
+			return
+		}
+		if req.MaxBytes <= 0 {
+			http.Error(w, "missing maxBytes", http.StatusBadRequest)
+			return
+		}
+		if req.TTL < 0 {
+			http.Error(w, "bad ttl", http.StatusBadRequest)
+			return
+		}
+		cfg := s.cfgForReq(r)
+		abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+		if err != nil {
+			http.Error(w, "bad path", http.StatusBadRequest)
+			return
+		}
+		if st, err := os.Stat(abs); err != nil || !st.IsDir() {
+			http.Error(w, "not a folder", http.StatusNotFound)
+			return
+		}
+		ttl := ticketDefaultTTL
+		if req.TTL > 0 {
+			// Clamp before multiplying; a large ttl would overflow.
+			ttl = time.Duration(min(req.TTL, int64(ticketMaxTTL/time.Second))) * time.Second
+		}
+		var b [18]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			http.Error(w, "ticket failed", http.StatusInternalServerError)
+			return
+		}
+		id := base64.RawURLEncoding.EncodeToString(b[:])
+		now := time.Now()
+		t := uploadTicket{Dir: rel, MaxBytes: req.MaxBytes, Exp: now.Add(ttl).Unix(), User: user, Via: viaFromContext(r.Context()), Created: now.Unix()}
+		if t.Via != "" {
+			t.Groups = auth.GroupsFromContext(r.Context())
+		}
+		if err := store.Put(id, t); err != nil {
+			http.Error(w, "save failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"id": id, "url": s.withSharePrefix(r, "/t/"+id), "path": rel, "maxBytes": t.MaxBytes, "exp": t.Exp})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		var t uploadTicket
+		if ok, err := store.Get(id, &t); err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if t.User != user {
+			if ok, err := s.allowed(r, auth.PermAdmin, "/"+t.Dir); err != nil || !ok {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		if err := store.Delete(id); err != nil {
+			http.Error(w, "save failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTicket serves a ticket's details and takes its one upload.
+func (s *Server) handleTicket(w http.ResponseWriter, r *http.Request) {
+	id, _ := ticketID(r.URL.Path)
+	t, ok := s.lookupTicket(r, id)
+	if !ok {
+		http.Error(w, "unknown or used upload link", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"dir": t.Dir, "maxBytes": t.MaxBytes, "exp": t.Exp})
+	case http.MethodPost:
+		busy := shareFromContext(r.Context()) + "\x00" + id
+		if _, taken := s.ticketsBusy.LoadOrStore(busy, struct{}{}); taken {
+			http.Error(w, "an upload with this link is already running", http.StatusConflict)
+			return
+		}
+		defer s.ticketsBusy.Delete(busy)
+		if r.ContentLength > t.MaxBytes+ticketSlack {
+			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r2 := r.Clone(context.WithValue(withSession(r.Context(), t.issuer()), ticketCapKey, t.MaxBytes))
+		r2.URL.RawQuery = url.Values{"path": {t.Dir}, "mode": {"rename"}}.Encode()
+		r2.Body = http.MaxBytesReader(w, r.Body, t.MaxBytes+ticketSlack)
+		if ok, err := s.allowed(r2, auth.PermWrite, "/"+t.Dir); err != nil || !ok {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		sw := &statusRW{ResponseWriter: w, code: http.StatusOK}
+		s.handleMultipartUpload(sw, r2)
+		if sw.code == http.StatusOK {
+			if store, err := s.metaStore(r, "uploadtickets"); err == nil {
+				_ = store.Delete(id)
+			}
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTicketPage serves the upload page of a ticket.
+func (s *Server) handleTicketPage(w http.ResponseWriter, r *http.Request) {
+	id, _ := ticketID(r.URL.Path)
+	if _, ok := s.lookupTicket(r, id); !ok {
+		http.Error(w, "unknown or used upload link", http.StatusNotFound)
+		return
+	}
+	b, err := fs.ReadFile(s.webFS, "ticket.html")
+	if err != nil {
+		http.Error(w, "missing ticket ui", http.StatusInternalServerError)
+		return
+	}
+	b = brandHTML(b, s.config().Branding)
+	b = basePathHTML(b, s.basePath())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(b)
+}
+
+// uploadCap returns the size limit a ticket puts on the request's upload.
+func uploadCap(r *http.Request) (int64, bool) {
+	n, ok := r.Context().Value(ticketCapKey).(int64)
+	return n, ok
+}
+
+// statusRW remembers the status code of a response.
+type statusRW struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRW) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,82 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lanparty/internal/config"
+)
+
+// issueTicket has user:pw issue an upload ticket for dir and returns its id.
+func issueTicket(t *testing.T, h http.Handler, user, dir string) string {
+	t.Helper()
+	w := serveAs(h, httptest.NewRequest(http.MethodPost, "/api/upload-tickets", strings.NewReader(`{"path":"`+dir+`","maxBytes":1024}`)), user)
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || res.ID == "" {
+		t.Fatalf("issue ticket: %d %v", w.Code, err)
+	}
+	return res.ID
+}
+
+// ticketUpload sends name to the ticket id without credentials.
+func ticketUpload(t *testing.T, h http.Handler, id, name string) int {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fw.Write([]byte("gg"))
+	_ = mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/ticket/"+id, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return serveAs(h, r, "").Code
+}
+
+// A ticket from an LDAP user uploads with their directory groups.
+func TestTicketKeepsLDAPUser(t *testing.T) {
+	s := providerServer(t)
+	_, _ = s.updateConfig(func(cfg *config.Config) error {
+		cfg.ACLs[len(cfg.ACLs)-1].Write = []string{"@crew"}
+		return nil
+	})
+	h := s.Handler()
+	id := issueTicket(t, h, "dave", "crew")
+	if code := ticketUpload(t, h, id, "demo.dem"); code != http.StatusOK {
+		t.Fatalf("upload with the LDAP user's ticket: %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(s.config().Root, "crew", "demo.dem")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A ticket from a guest dies with the guest account, before any sweep.
+func TestTicketGuestExpiry(t *testing.T) {
+	s := testServer(t, func(cfg *config.Config) {
+		cfg.Users["guest"] = cfg.Users["bob"]
+		cfg.ACLs[0].Write = []string{"alice", "bob", "guest"}
+		cfg.Guests = map[string]config.Guest{"guest": {Created: time.Now().Unix(), Expires: time.Now().Add(time.Hour).Unix()}}
+	})
+	h := s.Handler()
+	id := issueTicket(t, h, "guest", "")
+	_, _ = s.updateConfig(func(cfg *config.Config) error {
+		g := cfg.Guests["guest"]
+		g.Expires = time.Now().Unix() - 1
+		cfg.Guests["guest"] = g
+		return nil
+	})
+	if code := ticketUpload(t, h, id, "late.dem"); code != http.StatusNotFound {
+		t.Fatalf("upload with an expired guest's ticket: %d", code)
+	}
+}
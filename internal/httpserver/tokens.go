@@ -0,0 +1,253 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"lanparty/internal/config"
+)
+
+// Token bookkeeping: labels live in the config next to the tokens; when and
+// from where each token was last used is runtime state, kept in
+// <stateDir>/tokens-used.json. Tokens are identified by tokenID there and
+// in the admin API so the secret itself never needs to be pasted back.
+
+const (
+	tokenUsageFile   = "tokens-used.json"
+	tokenUsageSaveIn = time.Minute
+	maxTokenLabel    = 64
+)
+
+var errUnknownToken = errors.New("unknown token")
+
+// tokenID is a stable, non-secret handle for a token.
+func tokenID(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:6])
+}
+
+type tokenUse struct {
+	Last int64  `json:"last"` // unix seconds
+	IP   string `json:"ip"`
+}
+
+type tokenUsage struct {
+	mu     sync.Mutex
+	path   string
+	byID   map[string]tokenUse
+	saved  time.Time
+	saving bool
+}
+
+func newTokenUsage(stateDir string) *tokenUsage {
+	tu := &tokenUsage{byID: map[string]tokenUse{}, saved: time.Now()}
+	if stateDir == "" {
+		return tu
+	}
+	tu.path = filepath.Join(stateDir, tokenUsageFile)
+	if b, err := os.ReadFile(tu.path); err == nil {
+		_ = json.Unmarshal(b, &tu.byID)
+		if tu.byID == nil {
+			tu.byID = map[string]tokenUse{}
+		}
+	}
+	return tu
+}
+
+func (tu *tokenUsage) touch(tok, ip string) {
+	id := tokenID(tok)
+	now := time.Now()
+	tu.mu.Lock()
+	tu.byID[id] = tokenUse{Last: now.Unix(), IP: ip}
+	save := tu.path != "" && !tu.saving && now.Sub(tu.saved) >= tokenUsageSaveIn
+	if save {
+		tu.saving = true
+	}
+	tu.mu.Unlock()
+	if save {
+		go tu.save()
+	}
+}
+
+func (tu *tokenUsage) get(tok string) tokenUse {
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+	return tu.byID[tokenID(tok)]
+}
+
+func (tu *tokenUsage) save() {
+	tu.mu.Lock()
+	b, err := json.Marshal(tu.byID)
+	tu.mu.Unlock()
+	if err == nil {
+		tmp := tu.path + ".tmp"
+		if os.WriteFile(tmp, b, 0o600) == nil {
+			_ = os.Rename(tmp, tu.path)
+		}
+	}
+	tu.mu.Lock()
+	tu.saved = time.Now()
+	tu.saving = false
+	tu.mu.Unlock()
+}
+
+type tokenView struct {
+	ID          string `json:"id"`
+	TokenPrefix string `json:"tokenPrefix"`
+	User        string `json:"user"`
+	Label       string `json:"label,omitempty"`
+	LastUsed    int64  `json:"lastUsed,omitempty"` // unix seconds; 0 = never seen
+	LastIP      string `json:"lastIP,omitempty"`
+}
+
+// tokenViews lists cfg's tokens for the admin UI, by user then label.
+func (s *Server) tokenViews(cfg config.Config) []tokenView {
+	out := make([]tokenView, 0, len(cfg.Tokens))
+	for t, u := range cfg.Tokens {
+		p := t
+		if len(p) > 8 {
+			p = p[:8]
+		}
+		use := s.tokenUse.get(t)
+		out = append(out, tokenView{ID: tokenID(t), TokenPrefix: p, User: u, Label: cfg.TokenLabels[t], LastUsed: use.Last, LastIP: use.IP})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].User != out[j].User {
+			return out[i].User < out[j].User
+		}
+		if out[i].Label != out[j].Label {
+			return out[i].Label < out[j].Label
+		}
+		return out[i].TokenPrefix < out[j].TokenPrefix
+	})
+	return out
+}
+
+// findToken resolves a token given in full or by id.
+func findToken(cfg *config.Config, tok, id string) (string, bool) {
+	if tok != "" {
+		_, ok := cfg.Tokens[tok]
+		return tok, ok
+	}
+	for t := range cfg.Tokens {
+		if id != "" && tokenID(t) == id {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// handleAdminTokens creates, labels and revokes API tokens.
+//
+//	POST   /api/admin/tokens {"username", "label"}        -> {ok, token, id, username, label}
+//	PATCH  /api/admin/tokens {"id" | "token", "label"}    -> {ok}
+//	DELETE /api/admin/tokens {"id" | "token"}             -> {ok}
+//
+// Tokens are listed with label and last use in /api/admin/state.
+func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+		Label    string `json:"label"`
+		Token    string `json:"token"`
+		ID       string `json:"id"`
+	}
+	switch r.Method {
+	case http.MethodPost, http.MethodPatch, http.MethodDelete:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	label := strings.TrimSpace(req.Label)
+	if len(label) > maxTokenLabel {
+		http.Error(w, "label too long", http.StatusBadRequest)
+		return
+	}
+	persisted := strings.TrimSpace(s.cfgPath) != ""
+
+	switch r.Method {
+	case http.MethodPost:
+		u := strings.TrimSpace(req.Username)
+		if u == "" {
+			http.Error(w, "missing username", http.StatusBadRequest)
+			return
+		}
+		// Require that the user exists (so ACL logic makes sense).
+		cfg := s.cfgForReq(r)
+		if _, ok := cfg.Users[u]; !ok {
+			http.Error(w, "unknown user", http.StatusBadRequest)
+			return
+		}
+		// generate token
+		var b [24]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			http.Error(w, "token failed", http.StatusInternalServerError)
+			return
+		}
+		tok := base64.RawURLEncoding.EncodeToString(b[:])
+
+		_, _ = s.updateConfig(func(cfg *config.Config) error {
+			if cfg.Tokens == nil {
+				cfg.Tokens = map[string]string{}
+			}
+			cfg.Tokens[tok] = u
+			if label != "" {
+				if cfg.TokenLabels == nil {
+					cfg.TokenLabels = map[string]string{}
+				}
+				cfg.TokenLabels[tok] = label
+			}
+			_ = s.persistConfig(*cfg)
+			return nil
+		})
+		writeJSON(w, map[string]any{"ok": true, "token": tok, "id": tokenID(tok), "username": u, "label": label, "persisted": persisted})
+	case http.MethodPatch, http.MethodDelete:
+		tok, id := strings.TrimSpace(req.Token), strings.TrimSpace(req.ID)
+		if tok == "" && id == "" {
+			http.Error(w, "missing token", http.StatusBadRequest)
+			return
+		}
+		_, err := s.updateConfig(func(cfg *config.Config) error {
+			t, ok := findToken(cfg, tok, id)
+			if !ok {
+				return errUnknownToken
+			}
+			if r.Method == http.MethodDelete {
+				delete(cfg.Tokens, t)
+				delete(cfg.TokenLabels, t)
+			} else if label == "" {
+				delete(cfg.TokenLabels, t)
+			} else {
+				if cfg.TokenLabels == nil {
+					cfg.TokenLabels = map[string]string{}
+				}
+				cfg.TokenLabels[t] = label
+			}
+			_ = s.persistConfig(*cfg)
+			return nil
+		})
+		// Revoking a token that is already gone is not an error.
+		if err != nil && r.Method == http.MethodPatch {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true, "persisted": persisted})
+	}
+}
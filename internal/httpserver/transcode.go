@@ -0,0 +1,318 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lanparty/internal/config"
+	"lanparty/internal/fsutil"
+)
+
+// maxTranscodeCache bounds <stateDir>/transcode; oldest entries are evicted.
+const maxTranscodeCache = 2 << 30
+
+type audioFormat struct {
+	codec  string
+	muxer  string
+	ext    string
+	ctype  string
+	defBit int
+}
+
+var audioFormats = map[string]audioFormat{
+	"opus": {codec: "libopus", muxer: "ogg", ext: ".opus", ctype: "audio/ogg", defBit: 96},
+	"mp3":  {codec: "libmp3lame", muxer: "mp3", ext: ".mp3", ctype: "audio/mpeg", defBit: 192},
+}
+
+// ffmpegPath resolves the configured ffmpeg binary.
+func ffmpegPath(cfg config.Config) (string, error) {
+	bin := strings.TrimSpace(cfg.FFmpeg)
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+	return exec.LookPath(bin)
+}
+
+// handleAudio transcodes an audio file on the fly for clients that cannot
+// play (or should not download) the original, e.g. FLAC/ALAC over Wi-Fi.
+//
+//	GET /api/audio?path=<rel>&fmt=opus|mp3&bitrate=<kbps>
+//
+// Finished transcodes are cached in <stateDir>/transcode keyed by path, mtime,
+// format and bitrate, and served with Range support on later requests.
+func (s *Server) handleAudio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	rel := fsutil.CleanRelPath(q.Get("path"))
+	fmtName := strings.ToLower(strings.TrimSpace(q.Get("fmt")))
+	if fmtName == "" {
+		fmtName = "opus"
+	}
+	af, ok := audioFormats[fmtName]
+	if !ok {
+		http.Error(w, "bad fmt", http.StatusBadRequest)
+		return
+	}
+	bitrate := af.defBit
+	if v := strings.TrimSpace(q.Get("bitrate")); v != "" {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(v), "k"))
+		if err != nil {
+			http.Error(w, "bad bitrate", http.StatusBadRequest)
+			return
+		}
+		bitrate = min(max(n, 32), 320)
+	}
+
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil || st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if !isAudioExt(strings.ToLower(filepath.Ext(abs))) {
+		http.Error(w, "not an audio file", http.StatusBadRequest)
+		return
+	}
+
+	cacheDir := filepath.Join(cfg.StateDir, "transcode")
+	key := fmt.Sprintf("%s-%d-%d-%s-%d%s", safeKey(rel), st.ModTime().Unix(), st.Size(), fmtName, bitrate, af.ext)
+	cachePath := filepath.Join(cacheDir, key)
+	outName := strings.TrimSuffix(st.Name(), filepath.Ext(st.Name())) + af.ext
+
+	if f, err := os.Open(cachePath); err == nil {
+		defer f.Close()
+		w.Header().Set("Content-Type", af.ctype)
+		http.ServeContent(w, r, outName, st.ModTime(), f)
+		return
+	}
+
+	bin, err := ffmpegPath(cfg)
+	if err != nil {
+		http.Error(w, "transcoding unavailable (ffmpeg not found)", http.StatusNotImplemented)
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", af.ctype)
+		return
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		http.Error(w, "cache failed", http.StatusInternalServerError)
+		return
+	}
+	release, ok := s.limit(w, r, limitMedia)
+	if !ok {
+		return
+	}
+	defer release()
+	tmp := cachePath + fmt.Sprintf(".tmp-%d", time.Now().UnixNano())
+	cf, err := os.Create(tmp)
+	if err != nil {
+		http.Error(w, "cache failed", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = cf.Close()
+		_ = os.Remove(tmp)
+	}()
+
+	cmd := exec.CommandContext(r.Context(), bin,
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-i", abs,
+		"-map", "0:a:0", "-vn",
+		"-c:a", af.codec, "-b:a", strconv.Itoa(bitrate)+"k",
+		"-f", af.muxer, "pipe:1",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, "transcode failed", http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, "transcode failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", af.ctype)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", outName))
+	_, copyErr := io.Copy(io.MultiWriter(w, cf), stdout)
+	waitErr := cmd.Wait()
+	if copyErr != nil || waitErr != nil {
+		return
+	}
+	if err := cf.Close(); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, cachePath); err == nil {
+		pruneCacheDir(cacheDir, maxTranscodeCache)
+	}
+}
+
+// pruneCacheDir removes the least recently modified files in dir until its
+// total size is at most maxBytes.
+func pruneCacheDir(dir string, maxBytes int64) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type f struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var files []f
+	var total int64
+	for _, e := range ents {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, f{path: filepath.Join(dir, e.Name()), size: info.Size(), mtime: info.ModTime()})
+		total += info.Size()
+	}
+	if total <= maxBytes {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+	for _, x := range files {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(x.path); err == nil || errors.Is(err, fs.ErrNotExist) {
+			total -= x.size
+		}
+	}
+}
+
+// remuxExts are containers /api/remux accepts; their H.264/HEVC/AV1/VP9
+// streams fit in MP4 unchanged.
+var remuxExts = map[string]bool{".mkv": true, ".webm": true, ".avi": true, ".mov": true, ".ts": true, ".m2ts": true}
+
+// handleRemux repackages a video as fragmented MP4 without re-encoding, so
+// browsers can play H.264 that arrived in an MKV. ffmpeg only copies
+// packets, which is cheap enough to do live.
+//
+//	GET /api/remux?path=<rel>[&t=<seconds>][&audio=aac]
+//
+// The first video and audio streams are kept; subtitles are dropped.
+// audio=aac re-encodes just the audio for tracks browsers cannot decode in
+// MP4 (AC-3, DTS). The stream has no length and no Range support; t starts
+// it at the nearest keyframe before that offset instead. Nothing is cached.
+func (s *Server) handleRemux(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	rel := fsutil.CleanRelPath(q.Get("path"))
+	var start float64
+	if v := strings.TrimSpace(q.Get("t")); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 {
+			http.Error(w, "bad t", http.StatusBadRequest)
+			return
+		}
+		start = t
+	}
+	audio := strings.ToLower(strings.TrimSpace(q.Get("audio")))
+	if audio != "" && audio != "copy" && audio != "aac" {
+		http.Error(w, "bad audio", http.StatusBadRequest)
+		return
+	}
+
+	cfg := s.cfgForReq(r)
+	abs, err := fsutil.ResolveWithinRoot(cfg.Root, rel, cfg.FollowSymlinks)
+	if err != nil {
+		http.Error(w, "bad path", http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(abs)
+	if err != nil || st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if !remuxExts[strings.ToLower(filepath.Ext(abs))] {
+		http.Error(w, "not a remuxable video", http.StatusBadRequest)
+		return
+	}
+	bin, err := ffmpegPath(cfg)
+	if err != nil {
+		http.Error(w, "remux unavailable (ffmpeg not found)", http.StatusNotImplemented)
+		return
+	}
+	if r.Method == http.MethodGet {
+		release, ok := s.limit(w, r, limitMedia)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	outName := strings.TrimSuffix(st.Name(), filepath.Ext(st.Name())) + ".mp4"
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", outName))
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+	if start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(start, 'f', 3, 64))
+	}
+	args = append(args, "-i", abs, "-map", "0:v:0", "-map", "0:a:0?", "-sn", "-dn", "-c:v", "copy")
+	if audio == "aac" {
+		args = append(args, "-c:a", "aac", "-b:a", "192k", "-ac", "2")
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+	if v := s.videoInfo(abs, st); v != nil && v.Codec == "hevc" {
+		// Safari only plays HEVC in MP4 tagged hvc1, not hev1.
+		args = append(args, "-tag:v", "hvc1")
+	}
+	args = append(args,
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "pipe:1",
+	)
+	cmd := exec.CommandContext(r.Context(), bin, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, "remux failed", http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, "remux failed", http.StatusInternalServerError)
+		return
+	}
+	// ffmpeg fails before its first byte for codecs MP4 cannot carry; that
+	// is still worth a status code.
+	br := bufio.NewReaderSize(stdout, 64<<10)
+	if _, err := br.Peek(1); err != nil {
+		_ = cmd.Wait()
+		w.Header().Del("Content-Disposition")
+		w.Header().Del("Accept-Ranges")
+		http.Error(w, "remux failed (stream not MP4-compatible?)", http.StatusUnprocessableEntity)
+		return
+	}
+	_, _ = io.Copy(w, br)
+	_ = cmd.Wait()
+}
@@ -0,0 +1,303 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+// Live transfers: uploads, downloads and zip streams holding a slot (see
+// limits.go) are listed with the rate their connection moves bytes at, so
+// /api/admin/transfers can show who is hogging slots and drop them, and
+// WatchTransfers can drop them on its own when limits.minRate is set. Rates
+// are counted on the connection, which main gets from Listener and hands
+// to ConnContext; over HTTP/2 the transfers sharing a connection share its
+// rate and are dropped together. Without a metered connection transfers
+// are not tracked.
+
+const (
+	// meterSlice is how much of a sendfile a metered connection hands
+	// off at once, so its count moves while a big file goes out.
+	meterSlice = 256 << 10
+	// defaultMinRateSeconds is config.MinRate.Seconds when unset.
+	defaultMinRateSeconds = 120
+)
+
+// meteredConn counts the bytes moved over a connection.
+type meteredConn struct {
+	net.Conn
+	n atomic.Int64 // both ways
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// ReadFrom keeps sendfile working for net/http, a slice at a time.
+func (c *meteredConn) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := c.Conn.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{c}, r)
+	}
+	lr, ok := r.(*io.LimitedReader)
+	if !ok {
+		lr = &io.LimitedReader{R: r, N: math.MaxInt64}
+	}
+	var total int64
+	for lr.N > 0 {
+		left := lr.N
+		lr.N = min(left, meterSlice)
+		n, err := rf.ReadFrom(lr)
+		lr.N = left - n
+		total += n
+		c.n.Add(n)
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+type meteredListener struct{ net.Listener }
+
+func (l meteredListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &meteredConn{Conn: c}, nil
+}
+
+// Listener wraps ln so the server sees how fast each connection moves,
+// and remembers its address for ReachableURLs. Serve on it with
+// http.Server.ConnContext set to ConnContext.
+func (s *Server) Listener(ln net.Listener) net.Listener {
+	s.listenAddr = ln.Addr()
+	return meteredListener{ln}
+}
+
+type connKey struct{}
+
+// ConnContext remembers c's metered connection for the requests on it.
+func (s *Server) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(interface{ NetConn() net.Conn }); ok {
+		c = tc.NetConn() // TLS
+	}
+	if mc, ok := c.(*meteredConn); ok {
+		return context.WithValue(ctx, connKey{}, mc)
+	}
+	return ctx
+}
+
+type liveTransfer struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"` // uploads, downloads or zips
+	Share   string `json:"share,omitempty"`
+	Path    string `json:"path"`
+	User    string `json:"user,omitempty"`
+	Addr    string `json:"addr"`
+	Started int64  `json:"started"` // unix seconds
+	// Bytes is what the connection moved since the transfer began.
+	Bytes int64 `json:"bytes"`
+	// Rate is bytes/s between the last two checks.
+	Rate float64 `json:"rate"`
+	// SlowSince is when the transfer fell below limits.minRate (unix
+	// seconds), while it is.
+	SlowSince int64 `json:"slowSince,omitempty"`
+
+	conn   *meteredConn
+	base   int64 // conn count when the transfer began
+	lastN  int64
+	lastAt time.Time
+	slow   time.Time
+	closed bool
+}
+
+type transferList struct {
+	mu   sync.Mutex
+	seq  int
+	byID map[string]*liveTransfer
+}
+
+// trackTransfer lists the request as a transfer of kind until the returned
+// func is called.
+func (s *Server) trackTransfer(r *http.Request, kind string) func() {
+	mc, _ := r.Context().Value(connKey{}).(*meteredConn)
+	if mc == nil || (kind != limitUploads && kind != limitDownloads && kind != limitZips) {
+		return func() {}
+	}
+	now := time.Now()
+	t := &liveTransfer{
+		Kind: kind, Share: shareFromContext(r.Context()), Path: r.URL.Path,
+		User: auth.UserFromContext(r.Context()), Addr: s.remoteHost(r), Started: now.Unix(),
+		conn: mc, base: mc.n.Load(), lastAt: now,
+	}
+	tl := &s.transfers
+	tl.mu.Lock()
+	tl.seq++
+	t.ID = strconv.Itoa(tl.seq)
+	if tl.byID == nil {
+		tl.byID = map[string]*liveTransfer{}
+	}
+	tl.byID[t.ID] = t
+	tl.mu.Unlock()
+	return func() {
+		tl.mu.Lock()
+		delete(tl.byID, t.ID)
+		tl.mu.Unlock()
+	}
+}
+
+// WatchTransfers samples the rates of running transfers every interval
+// until ctx is done, dropping those below limits.minRate for too long.
+func (s *Server) WatchTransfers(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.checkTransfers(s.config().Limits.MinRate, now)
+		}
+	}
+}
+
+func (s *Server) checkTransfers(mr config.MinRate, now time.Time) {
+	grace := time.Duration(mr.Seconds) * time.Second
+	if grace <= 0 {
+		grace = defaultMinRateSeconds * time.Second
+	}
+	tl := &s.transfers
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	for _, t := range tl.byID {
+		if t.closed {
+			continue
+		}
+		n := t.conn.n.Load() - t.base
+		if d := now.Sub(t.lastAt).Seconds(); d > 0 {
+			t.Rate = float64(n-t.lastN) / d
+		}
+		prev := t.lastAt
+		t.Bytes, t.lastN, t.lastAt = n, n, now
+		if mr.KiBps <= 0 || t.Rate >= float64(mr.KiBps)*1024 {
+			t.slow, t.SlowSince = time.Time{}, 0
+			continue
+		}
+		if t.slow.IsZero() {
+			t.slow, t.SlowSince = prev, prev.Unix()
+		}
+		if now.Sub(t.slow) >= grace {
+			log.Printf("transfer %s: dropped %s of %s for %s: below %d KiB/s for %s", t.ID, t.Kind, t.Path, transferWho(t), mr.KiBps, now.Sub(t.slow).Round(time.Second))
+			t.closed = true
+			_ = t.conn.Close()
+		}
+	}
+}
+
+func transferWho(t *liveTransfer) string {
+	if t.User == "" {
+		return t.Addr
+	}
+	return t.User + " at " + t.Addr
+}
+
+// listTransfers returns copies of the running transfers, oldest first.
+func (s *Server) listTransfers() []liveTransfer {
+	tl := &s.transfers
+	tl.mu.Lock()
+	out := make([]liveTransfer, 0, len(tl.byID))
+	for _, t := range tl.byID {
+		v := *t
+		v.Bytes = t.conn.n.Load() - t.base
+		v.conn = nil
+		out = append(out, v)
+	}
+	tl.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := strconv.Atoi(out[i].ID)
+		b, _ := strconv.Atoi(out[j].ID)
+		return a < b
+	})
+	return out
+}
+
+var errNoTransfer = errors.New("no such transfer")
+
+// killTransfer closes the connection of transfer id.
+func (s *Server) killTransfer(id, by string) error {
+	tl := &s.transfers
+	tl.mu.Lock()
+	t := tl.byID[id]
+	if t != nil {
+		t.closed = true
+	}
+	tl.mu.Unlock()
+	if t == nil {
+		return errNoTransfer
+	}
+	if by == "" {
+		by = "an admin"
+	}
+	log.Printf("transfer %s: %s of %s for %s dropped by %s", t.ID, t.Kind, t.Path, transferWho(t), by)
+	if err := t.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("close: %w", err)
+	}
+	return nil
+}
+
+// handleAdminTransfers lists the uploads, downloads and zip streams holding
+// a slot and drops them.
+//
+//	GET    /api/admin/transfers          -> {transfers:[{id, kind, share, path, user, addr, started, bytes, rate, slowSince}], minRate}
+//	DELETE /api/admin/transfers?id=<id>  -> {ok}
+func (s *Server) handleAdminTransfers(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"transfers": s.listTransfers(), "minRate": s.config().Limits.MinRate})
+	case http.MethodDelete:
+		if err := s.killTransfer(r.URL.Query().Get("id"), auth.UserFromContext(r.Context())); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errNoTransfer) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func checkMinRate(mr config.MinRate) error {
+	if mr.KiBps < 0 || mr.Seconds < 0 {
+		return errors.New("kibps and seconds cannot be negative")
+	}
+	return nil
+}
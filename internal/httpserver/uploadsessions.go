@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"net/http"
+	"time"
+
+	"lanparty/internal/auth"
+)
+
+// Resumable upload sessions belong to the user who started them, so a
+// laptop that rebooted mid-upload, or another device of the same user, can
+// find them again with GET /api/uploads and carry on by ID from their
+// offset. Admins see and may take over every session of the share.
+// Sessions without a user (anonymous, or from before sessions were
+// recorded with one) are open to anyone who may write to their
+// destination, as all sessions used to be.
+
+type uploadSession struct {
+	ID      string `json:"id"`
+	Dest    string `json:"dest"`
+	Offset  int64  `json:"offset"`
+	Size    int64  `json:"size"` // -1 when unknown
+	User    string `json:"user,omitempty"`
+	Created int64  `json:"created"`           // unix seconds
+	Updated int64  `json:"updated,omitempty"` // last chunk, unix seconds
+	Age     int64  `json:"age"`               // seconds since created
+	Active  bool   `json:"active"`            // a chunk is being received
+}
+
+// uploadOwner reports whether the caller may use a session started by
+// user.
+func (s *Server) uploadOwner(r *http.Request, user string) bool {
+	if user == "" || user == auth.UserFromContext(r.Context()) {
+		return true
+	}
+	ok, err := s.allowed(r, auth.PermAdmin, "/")
+	return err == nil && ok
+}
+
+// listUploads lists the resumable uploads in progress on the request's
+// share that the caller started, or all of them for admins.
+//
+//	GET /api/uploads -> {uploads: [{id, dest, offset, size, user, created, updated, age, active}]}
+func (s *Server) listUploads(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	admin, err := s.allowed(r, auth.PermAdmin, "/")
+	if err != nil || (!admin && user == "") {
+		if s.shouldChallenge(r) {
+			s.authChallenge(w)
+		} else {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+		return
+	}
+	_, up, err := s.shareDeps(r)
+	if err != nil {
+		http.Error(w, "server init failed", http.StatusInternalServerError)
+		return
+	}
+	share := shareFromContext(r.Context())
+	now := time.Now().Unix()
+	out := []uploadSession{}
+	for _, sess := range up.List() {
+		if !admin && sess.User != user {
+			continue
+		}
+		u := uploadSession{
+			ID: sess.ID, Dest: sess.DestRel, Offset: sess.Offset, Size: sess.Size, User: sess.User,
+			Created: sess.Created, Updated: sess.Updated, Age: max(now-sess.Created, 0),
+		}
+		if v, ok := s.progress.get(share, sess.ID); ok {
+			u.Active = v.Active
+		}
+		out = append(out, u)
+	}
+	writeJSON(w, map[string]any{"uploads": out})
+}
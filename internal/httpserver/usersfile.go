@@ -0,0 +1,64 @@
+package httpserver
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strings"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+// Users files (config.UsersFile): the users of an Apache htpasswd file join
+// Users when the config is loaded or reloaded, so an existing htpasswd
+// setup keeps working without copying hashes around. They are remembered
+// in s.fileUsers and left out again when the server writes the config
+// file, unless an admin changed their password in the meantime.
+
+// mergeUsersFile adds the users of cfg.UsersFile that cfg.Users does not
+// have and returns them.
+func mergeUsersFile(cfg *config.Config) (map[string]config.User, error) {
+	if strings.TrimSpace(cfg.UsersFile) == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(cfg.UsersFile)
+	if err != nil {
+		return nil, fmt.Errorf("usersFile: %w", err)
+	}
+	users, skipped, err := auth.ParseHtpasswd(b)
+	if err != nil {
+		return nil, fmt.Errorf("usersFile %s: %w", cfg.UsersFile, err)
+	}
+	if len(skipped) > 0 {
+		log.Printf("usersFile %s: skipped %s (only bcrypt and md5-crypt hashes are supported)", cfg.UsersFile, strings.Join(skipped, ", "))
+	}
+	added := map[string]config.User{}
+	for name, u := range users {
+		if _, ok := cfg.Users[name]; ok {
+			continue
+		}
+		if cfg.Users == nil {
+			cfg.Users = map[string]config.User{}
+		}
+		cfg.Users[name] = u
+		added[name] = u
+	}
+	return added, nil
+}
+
+// withoutFileUsers returns cfg without the users that came from its users
+// file unchanged. Callers hold cfgMu.
+func (s *Server) withoutFileUsers(cfg config.Config) config.Config {
+	if len(s.fileUsers) == 0 {
+		return cfg
+	}
+	cfg.Users = maps.Clone(cfg.Users)
+	for name, u := range s.fileUsers {
+		if cfg.Users[name] == u {
+			delete(cfg.Users, name)
+		}
+	}
+	return cfg
+}
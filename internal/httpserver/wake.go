@@ -0,0 +1,62 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"lanparty/internal/wol"
+)
+
+type wakeHostView struct {
+	Name      string `json:"name"`
+	MAC       string `json:"mac"`
+	Broadcast string `json:"broadcast"`
+}
+
+// handleAdminWake lists and wakes the machines configured under "wake".
+//
+//	GET  /api/admin/wake                 -> {hosts: [{name, mac, broadcast}]}
+//	POST /api/admin/wake {"name":"nas"}  -> {ok: true}
+//
+// Only configured names can be woken; there is no free-form MAC.
+func (s *Server) handleAdminWake(w http.ResponseWriter, r *http.Request) {
+	if !s.adminOnly(w, r) {
+		return
+	}
+	hosts := s.config().Wake
+	switch r.Method {
+	case http.MethodGet:
+		out := make([]wakeHostView, 0, len(hosts))
+		for name, h := range hosts {
+			bc := h.Broadcast
+			if bc == "" {
+				bc = wol.DefaultBroadcast
+			}
+			out = append(out, wakeHostView{Name: name, MAC: h.MAC, Broadcast: bc})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+		writeJSON(w, map[string]any{"hosts": out})
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		h, ok := hosts[req.Name]
+		if !ok {
+			http.Error(w, "unknown host", http.StatusNotFound)
+			return
+		}
+		if err := wol.Send(h.MAC, h.Broadcast); err != nil {
+			http.Error(w, fmt.Sprintf("wake: %v", err), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
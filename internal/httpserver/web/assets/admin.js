@@ -1,16 +1,35 @@
 'use strict';
 
+// Where lanparty is mounted behind a reverse proxy ("basePath"), or ''.
+const ROOT = String(document.body?.dataset.basePath || '');
+
+// Requests that change something carry the page's CSRF token (csrf.go).
+(() => {
+  const token = String(document.body?.dataset.csrf || '');
+  const plain = window.fetch.bind(window);
+  window.fetch = (input, init = {}) => {
+    const method = String(init.method || (input instanceof Request ? input.method : 'GET')).toUpperCase();
+    if (!token || method === 'GET' || method === 'HEAD') return plain(input, init);
+    const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+    headers.set('X-CSRF-Token', token);
+    return plain(input, {...init, headers});
+  };
+})();
+
 const BASE = (() => {
   try {
-    const path = String(location.pathname || '/');
+    let path = String(location.pathname || '/');
+    if (ROOT && (path === ROOT || path.startsWith(`${ROOT}/`))) {
+      path = path.slice(ROOT.length);
+    }
     const match = path.match(/^\/s\/([^/]+)(?:\/|$)/);
     if (match && match[1]) {
-      return `/s/${match[1]}`;
+      return `${ROOT}/s/${match[1]}`;
     }
   } catch (_) {
     // ignore
   }
-  return '';
+  return ROOT;
 })();
 
 const $ = (id) => document.getElementById(id);
@@ -19,6 +38,9 @@ const toasts = $('toasts');
 const els = {
   save: $('cfg-save'),
   discard: $('cfg-discard'),
+  review: $('cfg-review'),
+  reviewList: $('cfg-review-list'),
+  reviewWarnings: $('cfg-review-warnings'),
   status: $('cfg-status'),
   persist: $('cfg-persist'),
   summary: $('admin-summary'),
@@ -35,16 +57,43 @@ const els = {
   tokensList: $('tokens-list'),
   tokensEmpty: $('tokens-empty'),
   tokenUser: $('tok-user'),
+  tokenLabel: $('tok-label'),
   tokenCreate: $('tok-create'),
   tokenOutput: $('tok-output'),
   tokenCopy: $('tok-copy'),
   tokenRevoke: $('tok-revoke'),
   tokenRevokeBtn: $('tok-revoke-btn'),
+  guestLabel: $('guest-label'),
+  guestHours: $('guest-hours'),
+  guestRead: $('guest-read'),
+  guestWrite: $('guest-write'),
+  guestCreate: $('guest-create'),
+  guestCard: $('guest-card'),
+  guestQr: $('guest-qr'),
+  guestUser: $('guest-user'),
+  guestPass: $('guest-pass'),
+  guestExpires: $('guest-expires'),
+  guestUrl: $('guest-url'),
+  guestsList: $('guests-list'),
+  guestsEmpty: $('guests-empty'),
   bcryptPass: $('bcrypt-pass'),
   bcryptCost: $('bcrypt-cost'),
   bcryptGenerate: $('bcrypt-generate'),
   bcryptOutput: $('bcrypt-output'),
   bcryptCopy: $('bcrypt-copy'),
+  ovList: $('ov-list'),
+  ovRefresh: $('ov-refresh'),
+  ovTraffic: $('ov-traffic'),
+  ovTrafficShare: $('ov-traffic-share'),
+  ovTrafficRange: $('ov-traffic-range'),
+  ovUploads: $('ov-uploads'),
+  ovJobs: $('ov-jobs'),
+  ovTransfers: $('ov-transfers'),
+  ovReindex: $('ov-reindex'),
+  ovReindexMode: $('ov-reindex-mode'),
+  ovReindexStatus: $('ov-reindex-status'),
+  wakeList: $('wake-list'),
+  wakeEmpty: $('wake-empty'),
 };
 const panes = document.querySelectorAll('.admin-pane');
 const navItems = document.querySelectorAll('.nav-item');
@@ -58,6 +107,7 @@ const state = {
   configPath: '',
   users: [],
   tokens: [],
+  guests: [],
 };
 
 init();
@@ -67,18 +117,27 @@ function init() {
   initNav();
   loadConfig();
   refreshState();
+  loadOverview();
+  loadWake();
+  loadGuests();
 }
 
 function bindEvents() {
   els.save?.addEventListener('click', () => saveConfig());
   els.discard?.addEventListener('click', () => discardChanges());
+  els.review?.addEventListener('click', () => reviewConfig());
   els.shareAdd?.addEventListener('click', () => addShare());
   els.userSave?.addEventListener('click', () => createUser());
   els.tokenCreate?.addEventListener('click', () => createToken());
   els.tokenCopy?.addEventListener('click', () => copyToken());
   els.tokenRevokeBtn?.addEventListener('click', () => revokeToken());
+  els.guestCreate?.addEventListener('click', () => createGuest());
   els.bcryptGenerate?.addEventListener('click', () => generateBcrypt());
   els.bcryptCopy?.addEventListener('click', () => copyBcrypt());
+  els.ovRefresh?.addEventListener('click', () => loadOverview());
+  els.ovTrafficShare?.addEventListener('change', () => loadTraffic());
+  els.ovTrafficRange?.addEventListener('change', () => loadTraffic());
+  els.ovReindex?.addEventListener('click', () => startReindex());
 }
 
 function initNav() {
@@ -120,6 +179,7 @@ function applyConfigResponse(data) {
     root: cfg.root || '',
     stateDir: cfg.stateDir || '',
     followSymlinks: !!cfg.followSymlinks,
+    readOnly: !!cfg.readOnly,
     authOptional: !!cfg.authOptional,
     acls: normalizeAclList(cfg.acls),
   };
@@ -161,6 +221,410 @@ async function refreshState() {
   }
 }
 
+async function loadOverview() {
+  if (!els.ovList) return;
+  try {
+    const res = await fetch(`${BASE}/api/admin/overview`);
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    renderOverview(Array.isArray(data.shares) ? data.shares : []);
+  } catch (err) {
+    els.ovList.textContent = `overview failed: ${String(err)}`;
+  }
+  loadTraffic();
+  loadUploads();
+  loadTransfers();
+  loadReindex();
+  loadJobs();
+}
+
+let uploadsTimer = null;
+
+async function loadUploads() {
+  if (!els.ovUploads) return;
+  clearTimeout(uploadsTimer);
+  try {
+    const res = await fetch(`${BASE}/api/admin/uploads`);
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    const ups = Array.isArray(data.uploads) ? data.uploads : [];
+    renderUploads(ups);
+    // Follow running transfers while the overview is open.
+    if (ups.some((u) => u.active) && els.ovUploads.isConnected) {
+      uploadsTimer = setTimeout(() => loadUploads(), 2000);
+    }
+  } catch (err) {
+    els.ovUploads.textContent = `uploads failed: ${String(err)}`;
+  }
+}
+
+function renderUploads(ups) {
+  els.ovUploads.innerHTML = '';
+  if (!ups.length) {
+    els.ovUploads.innerHTML = '<div class="meta">No uploads in progress</div>';
+    return;
+  }
+  const table = document.createElement('table');
+  table.className = 'admin-table';
+  const thead = document.createElement('thead');
+  thead.innerHTML = '<tr><th>Destination</th><th>User</th><th>Received</th><th>Rate</th><th>Remaining</th><th>State</th></tr>';
+  table.appendChild(thead);
+  const tbody = document.createElement('tbody');
+  ups.forEach((u) => {
+    const tr = document.createElement('tr');
+    const dest = (u.share ? `/s/${u.share}/` : '/') + (u.path || '');
+    const got = u.total >= 0
+      ? `${fmtBytes(u.received)} of ${fmtBytes(u.total)} (${u.total ? Math.floor((u.received / u.total) * 100) : 100}%)`
+      : fmtBytes(u.received);
+    const rate = u.rate ? `${fmtBytes(u.rate)}/s` : '--';
+    const eta = u.eta ? fmtSeconds(u.eta) : '--';
+    const st = u.done ? 'complete' : u.active ? 'sending' : 'paused';
+    [dest, u.user || '--', got, rate, eta, st].forEach((text) => {
+      const td = document.createElement('td');
+      td.textContent = text;
+      tr.appendChild(td);
+    });
+    tbody.appendChild(tr);
+  });
+  table.appendChild(tbody);
+  els.ovUploads.appendChild(table);
+}
+
+let transfersTimer = null;
+
+async function loadTransfers() {
+  if (!els.ovTransfers) return;
+  clearTimeout(transfersTimer);
+  try {
+    const res = await fetch(`${BASE}/api/admin/transfers`);
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    const list = Array.isArray(data.transfers) ? data.transfers : [];
+    renderTransfers(list);
+    if (list.length && els.ovTransfers.isConnected) {
+      transfersTimer = setTimeout(() => loadTransfers(), 5000);
+    }
+  } catch (err) {
+    els.ovTransfers.textContent = `transfers failed: ${String(err)}`;
+  }
+}
+
+function renderTransfers(list) {
+  els.ovTransfers.innerHTML = '';
+  if (!list.length) {
+    els.ovTransfers.innerHTML = '<div class="meta">No transfers running</div>';
+    return;
+  }
+  const table = document.createElement('table');
+  table.className = 'admin-table';
+  const thead = document.createElement('thead');
+  thead.innerHTML = '<tr><th>Path</th><th>Kind</th><th>User</th><th>Client</th><th>Moved</th><th>Rate</th><th>Running</th><th></th></tr>';
+  table.appendChild(thead);
+  const tbody = document.createElement('tbody');
+  const now = Date.now() / 1000;
+  list.forEach((t) => {
+    const tr = document.createElement('tr');
+    const path = (t.share ? `/s/${t.share}` : '') + (t.path || '');
+    const rate = t.rate ? `${fmtBytes(t.rate)}/s` : '--';
+    const cells = [path, t.kind, t.user || '--', t.addr || '--', fmtBytes(t.bytes), rate, fmtSeconds(now - t.started)];
+    cells.forEach((text, i) => {
+      const td = document.createElement('td');
+      td.textContent = text;
+      if (i === 5 && t.slowSince) {
+        td.className = 'bad';
+        td.title = `Below the minimum rate for ${fmtSeconds(now - t.slowSince)}`;
+      }
+      tr.appendChild(td);
+    });
+    const actions = document.createElement('td');
+    const drop = document.createElement('button');
+    drop.type = 'button';
+    drop.className = 'btn ghost danger';
+    drop.textContent = 'Drop';
+    drop.addEventListener('click', async () => {
+      try {
+        const res = await fetch(`${BASE}/api/admin/transfers?id=${encodeURIComponent(t.id)}`, { method: 'DELETE' });
+        if (!res.ok) {
+          throw new Error(await res.text());
+        }
+        toast('Transfer dropped', 'ok');
+      } catch (err) {
+        toast('Drop failed', 'err', String(err));
+      }
+      loadTransfers();
+    });
+    actions.appendChild(drop);
+    tr.appendChild(actions);
+    tbody.appendChild(tr);
+  });
+  table.appendChild(tbody);
+  els.ovTransfers.appendChild(table);
+}
+
+let jobsTimer = null;
+
+async function loadJobs() {
+  if (!els.ovJobs) return;
+  clearTimeout(jobsTimer);
+  try {
+    const res = await fetch(`${BASE}/api/admin/jobs`);
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    const jobs = Array.isArray(data.jobs) ? data.jobs : [];
+    renderJobs(jobs);
+    if (jobs.some((j) => j.state === 'running') && els.ovJobs.isConnected) {
+      jobsTimer = setTimeout(() => loadJobs(), 2000);
+    }
+  } catch (err) {
+    els.ovJobs.textContent = `jobs failed: ${String(err)}`;
+  }
+}
+
+function renderJobs(jobs) {
+  els.ovJobs.innerHTML = '';
+  if (!jobs.length) {
+    els.ovJobs.innerHTML = '<div class="meta">No jobs since the server started</div>';
+    return;
+  }
+  const table = document.createElement('table');
+  table.className = 'admin-table';
+  const thead = document.createElement('thead');
+  thead.innerHTML = '<tr><th>Job</th><th>Share</th><th>User</th><th>Started</th><th>Duration</th><th>State</th><th></th></tr>';
+  table.appendChild(thead);
+  const tbody = document.createElement('tbody');
+  jobs.forEach((j) => {
+    const tr = document.createElement('tr');
+    let st = j.state;
+    if (j.detail) st += ` (${j.detail})`;
+    if (j.error) st += `: ${j.error}`;
+    const cells = [j.kind, j.share ? `/s/${j.share}/` : '/', j.user || '--', new Date(j.started).toLocaleString(), fmtSeconds(j.durationMs / 1000), st];
+    cells.forEach((text) => {
+      const td = document.createElement('td');
+      td.textContent = text;
+      tr.appendChild(td);
+    });
+    const actions = document.createElement('td');
+    if (j.state === 'running') {
+      const cancel = document.createElement('button');
+      cancel.type = 'button';
+      cancel.className = 'btn ghost danger';
+      cancel.textContent = 'Cancel';
+      cancel.addEventListener('click', async () => {
+        try {
+          const res = await fetch(`${BASE}/api/admin/jobs?id=${encodeURIComponent(j.id)}`, { method: 'DELETE' });
+          if (!res.ok) {
+            throw new Error(await res.text());
+          }
+          toast('Job canceled', 'ok');
+        } catch (err) {
+          toast('Cancel failed', 'err', String(err));
+        }
+        loadJobs();
+      });
+      actions.appendChild(cancel);
+    }
+    tr.appendChild(actions);
+    tbody.appendChild(tr);
+  });
+  table.appendChild(tbody);
+  els.ovJobs.appendChild(table);
+}
+
+function fmtSeconds(sec) {
+  sec = Math.round(Number(sec) || 0);
+  if (sec < 60) return `${sec}s`;
+  if (sec < 3600) return `${Math.floor(sec / 60)}m ${sec % 60}s`;
+  return `${Math.floor(sec / 3600)}h ${Math.floor((sec % 3600) / 60)}m`;
+}
+
+async function startReindex() {
+  try {
+    const res = await fetch(`${BASE}/api/admin/reindex`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ thumbs: els.ovReindexMode?.value === 'thumbs' }),
+    });
+    if (!res.ok && res.status !== 409) {
+      throw new Error(await res.text());
+    }
+    if (res.status === 409) {
+      toast('Reindex already running', 'info');
+    }
+    renderReindex(await res.json());
+    loadJobs();
+  } catch (err) {
+    toast('Reindex failed', 'err', String(err));
+  }
+}
+
+let reindexTimer = null;
+let reindexRunning = false;
+
+async function loadReindex() {
+  if (!els.ovReindexStatus) return;
+  try {
+    const res = await fetch(`${BASE}/api/admin/reindex`);
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    renderReindex(await res.json());
+  } catch (err) {
+    els.ovReindexStatus.textContent = `reindex status failed: ${String(err)}`;
+  }
+}
+
+function renderReindex(job) {
+  clearTimeout(reindexTimer);
+  if (!els.ovReindexStatus || !job || !job.started) return;
+  const running = !job.finished;
+  if (els.ovReindex) els.ovReindex.disabled = running;
+  let text;
+  if (running) {
+    text = job.phase === 'scan'
+      ? `Scanning: ${job.files} files, ${fmtBytes(job.bytes)}`
+      : `${job.phase === 'thumbs' ? 'Thumbnails' : 'Metadata'}: ${job.done} of ${job.total}`;
+  } else {
+    const when = new Date(job.finished * 1000).toLocaleString();
+    text = `Last reindex ${job.phase} at ${when}: ${job.files} files, ${fmtBytes(job.bytes)}`;
+    if (job.error) text += ` (${job.error})`;
+  }
+  if (job.errors) text += `, ${job.errors} errors`;
+  els.ovReindexStatus.textContent = text;
+  if (running) {
+    reindexTimer = setTimeout(() => loadReindex(), 1000);
+  } else if (reindexRunning) {
+    // Show the fresh sizes.
+    loadOverview();
+  }
+  reindexRunning = running;
+}
+
+async function loadTraffic() {
+  if (!els.ovTraffic) return;
+  const minutes = els.ovTrafficRange?.value || '360';
+  try {
+    const res = await fetch(`${BASE}/api/admin/stats/timeseries?minutes=${encodeURIComponent(minutes)}`);
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    renderTraffic(data.series || {}, Number(data.step) || 60);
+  } catch (err) {
+    els.ovTraffic.textContent = `traffic failed: ${String(err)}`;
+  }
+}
+
+function renderTraffic(series, step) {
+  const sel = els.ovTrafficShare;
+  const names = Object.keys(series).sort();
+  if (sel) {
+    const keep = sel.value;
+    sel.innerHTML = '<option value="*">All shares</option>';
+    names.forEach((name) => {
+      const opt = document.createElement('option');
+      opt.value = name;
+      opt.textContent = name ? `/s/${name}` : '/ (default)';
+      sel.appendChild(opt);
+    });
+    sel.value = names.includes(keep) ? keep : '*';
+  }
+  const picked = sel && sel.value !== '*' ? [sel.value] : names;
+  // Sum the picked shares bucket by bucket.
+  const sum = [];
+  picked.forEach((name) => {
+    (series[name] || []).forEach((b, i) => {
+      if (!sum[i]) sum[i] = { t: b.t, out: 0, in: 0 };
+      sum[i].out += b.out;
+      sum[i].in += b.in;
+    });
+  });
+
+  els.ovTraffic.innerHTML = '';
+  if (!sum.length) {
+    els.ovTraffic.innerHTML = '<div class="meta">No traffic recorded yet</div>';
+    return;
+  }
+  const mbit = (n) => (n * 8) / step / 1e6;
+  const peak = Math.max(1, ...sum.map((b) => Math.max(mbit(b.out), mbit(b.in))));
+  const W = 800;
+  const H = 200;
+  const line = (key) => sum.map((b, i) => {
+    const x = sum.length > 1 ? (i / (sum.length - 1)) * W : 0;
+    const y = H - (mbit(b[key]) / peak) * H;
+    return `${x.toFixed(1)},${y.toFixed(1)}`;
+  }).join(' ');
+  const ns = 'http://www.w3.org/2000/svg';
+  const svg = document.createElementNS(ns, 'svg');
+  svg.setAttribute('viewBox', `0 0 ${W} ${H}`);
+  svg.setAttribute('preserveAspectRatio', 'none');
+  [['out', 'traffic-out'], ['in', 'traffic-in']].forEach(([key, cls]) => {
+    const pl = document.createElementNS(ns, 'polyline');
+    pl.setAttribute('points', line(key));
+    pl.setAttribute('class', cls);
+    svg.appendChild(pl);
+  });
+  els.ovTraffic.appendChild(svg);
+
+  const total = (key) => sum.reduce((n, b) => n + b[key], 0);
+  const legend = document.createElement('div');
+  legend.className = 'meta traffic-legend';
+  const from = new Date(sum[0].t * 60000).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+  legend.textContent = `since ${from}: out ${fmtBytes(total('out'))}, in ${fmtBytes(total('in'))}, peak ${peak.toFixed(1)} Mbit/s`;
+  els.ovTraffic.appendChild(legend);
+}
+
+function renderOverview(shares) {
+  els.ovList.innerHTML = '';
+  const table = document.createElement('table');
+  table.className = 'admin-table';
+  const thead = document.createElement('thead');
+  thead.innerHTML = '<tr><th>Share</th><th>Files</th><th>Size</th><th>State dir</th><th>Disk free</th><th>Uploads</th><th>Active</th><th>Last activity</th></tr>';
+  table.appendChild(thead);
+  const tbody = document.createElement('tbody');
+  shares.forEach((sh) => {
+    const tr = document.createElement('tr');
+    let name = sh.name ? `/s/${sh.name}` : '/ (default)';
+    if (sh.encrypted) name += sh.locked ? ' (locked)' : ' (encrypted)';
+    if (sh.ram) name += ' (RAM)';
+    if (sh.unavailable) name += ' (UNAVAILABLE)';
+    const free = sh.diskTotal ? `${fmtBytes(sh.diskFree)} of ${fmtBytes(sh.diskTotal)}` : '--';
+    const last = sh.lastActive ? new Date(sh.lastActive * 1000).toLocaleString() : '--';
+    const cells = [name, String(sh.files), fmtBytes(sh.bytes), fmtBytes(sh.stateBytes), free, String(sh.uploads), String(sh.inflight), last];
+    cells.forEach((text, i) => {
+      const td = document.createElement('td');
+      td.textContent = text;
+      if (i === 0) {
+        const why = sh.unavailable ? `unavailable since ${new Date(sh.unavailableSince * 1000).toLocaleString()}: ${sh.unavailable}` : sh.error;
+        td.title = why ? `${sh.root}\n${why}` : sh.root;
+        if (sh.unavailable) td.className = 'bad';
+      }
+      tr.appendChild(td);
+    });
+    tbody.appendChild(tr);
+  });
+  table.appendChild(tbody);
+  els.ovList.appendChild(table);
+}
+
+function fmtBytes(n) {
+  n = Number(n) || 0;
+  const units = ['B', 'KB', 'MB', 'GB', 'TB', 'PB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return `${i ? n.toFixed(1) : n} ${units[i]}`;
+}
+
 function renderGeneral() {
   if (!els.general) return;
   els.general.innerHTML = '';
@@ -237,6 +701,24 @@ function renderGeneral() {
   });
   addRow('Follow symlinks', followSelect, 'Applies to the default share at "/"');
 
+  const readOnlySelect = document.createElement('select');
+  readOnlySelect.className = 'renin';
+  [
+    { value: 'false', label: 'Allow writes' },
+    { value: 'true', label: 'Read-only' },
+  ].forEach((opt) => {
+    const option = document.createElement('option');
+    option.value = opt.value;
+    option.textContent = opt.label;
+    readOnlySelect.appendChild(option);
+  });
+  readOnlySelect.value = state.config.readOnly ? 'true' : 'false';
+  readOnlySelect.addEventListener('change', (e) => {
+    state.config.readOnly = e.target.value === 'true';
+    markDirty();
+  });
+  addRow('Read-only', readOnlySelect, 'Rejects uploads, renames and deletes whatever the ACLs say; shares can override');
+
   els.general.appendChild(table);
 }
 
@@ -272,7 +754,7 @@ function renderShares() {
   const table = document.createElement('table');
   table.className = 'admin-table share-table';
   const thead = document.createElement('thead');
-  thead.innerHTML = '<tr><th>Name</th><th>Root</th><th>State dir</th><th>Symlinks</th><th>Rules</th><th style=\"text-align:right\">Actions</th></tr>';
+  thead.innerHTML = '<tr><th>Name</th><th>Root</th><th>State dir</th><th>Symlinks</th><th>Writes</th><th>Rules</th><th style=\"text-align:right\">Actions</th></tr>';
   table.appendChild(thead);
   const tbody = document.createElement('tbody');
   table.appendChild(tbody);
@@ -340,6 +822,31 @@ function renderShares() {
     }
     tr.appendChild(followTd);
 
+    const writesTd = document.createElement('td');
+    if (editing) {
+      const writesSelect = document.createElement('select');
+      writesSelect.className = 'renin';
+      [
+        { value: 'inherit', label: 'Inherit' },
+        { value: 'true', label: 'Read-only' },
+        { value: 'false', label: 'Allow writes' },
+      ].forEach((opt) => {
+        const option = document.createElement('option');
+        option.value = opt.value;
+        option.textContent = opt.label;
+        writesSelect.appendChild(option);
+      });
+      writesSelect.value = share.readOnlyMode || 'inherit';
+      writesSelect.addEventListener('change', (e) => {
+        share.readOnlyMode = e.target.value;
+        markDirty();
+      });
+      writesTd.appendChild(writesSelect);
+    } else {
+      writesTd.textContent = readOnlyModeLabel(share.readOnlyMode);
+    }
+    tr.appendChild(writesTd);
+
     const rulesTd = document.createElement('td');
     const rulesBtn = document.createElement('button');
     rulesBtn.type = 'button';
@@ -376,7 +883,7 @@ function renderShares() {
   const detailRow = document.createElement('tr');
   detailRow.className = 'share-detail-row hidden';
     const detailCell = document.createElement('td');
-  detailCell.colSpan = 6;
+  detailCell.colSpan = 7;
     detailRow.appendChild(detailCell);
 
     rulesBtn.addEventListener('click', () => {
@@ -417,7 +924,7 @@ function renderAclList(container, list, opts = {}) {
     const table = document.createElement('table');
     table.className = 'admin-table acl-table';
     const thead = document.createElement('thead');
-    thead.innerHTML = '<tr><th>Path</th><th>Read</th><th>Write</th><th>Admin</th><th style=\"text-align:right\">Actions</th></tr>';
+    thead.innerHTML = '<tr><th>Path</th><th>Read</th><th>Write</th><th>Admin</th><th>Deny</th><th style=\"text-align:right\">Actions</th></tr>';
     table.appendChild(thead);
     const tbody = document.createElement('tbody');
     list.forEach((acl, idx) => {
@@ -427,7 +934,7 @@ function renderAclList(container, list, opts = {}) {
 
       const pathTd = document.createElement('td');
       if (editing) {
-        pathTd.appendChild(createTextInput(acl.path, '/photos or /', (val) => {
+        pathTd.appendChild(createTextInput(acl.path, '/photos, /photos/*/raw or re:…', (val) => {
           acl.path = val;
           markDirty();
         }));
@@ -469,6 +976,23 @@ function renderAclList(container, list, opts = {}) {
       }
       tr.appendChild(adminTd);
 
+      const denyTd = document.createElement('td');
+      const denies = [['denyRead', 'Read'], ['denyWrite', 'Write'], ['denyAdmin', 'Admin']];
+      if (editing) {
+        denies.forEach(([key, label]) => {
+          denyTd.appendChild(createListInput(label, acl[key] || [], (vals) => {
+            acl[key] = vals;
+            markDirty();
+          }));
+        });
+      } else {
+        const parts = denies
+          .filter(([key]) => (acl[key] || []).length)
+          .map(([key, label]) => `${label.toLowerCase()}: ${formatValueList(acl[key])}`);
+        denyTd.textContent = parts.length ? parts.join('; ') : '--';
+      }
+      tr.appendChild(denyTd);
+
       const actionTd = document.createElement('td');
       actionTd.style.textAlign = 'right';
 
@@ -562,6 +1086,17 @@ function formatValueList(values, empty = '--') {
   return empty;
 }
 
+function readOnlyModeLabel(mode) {
+  switch (mode) {
+    case 'true':
+      return 'Read-only';
+    case 'false':
+      return 'Allow writes';
+    default:
+      return 'Inherit';
+  }
+}
+
 function followModeLabel(mode) {
   switch (mode) {
     case 'true':
@@ -581,6 +1116,7 @@ function addShare() {
     root: '',
     stateDir: '',
     followMode: 'inherit',
+    readOnlyMode: 'inherit',
     acls: [],
     __editing: true,
   };
@@ -599,6 +1135,10 @@ function sharesMapToList(map) {
       root: sh.root || '',
       stateDir: sh.stateDir || '',
       followMode: typeof sh.followSymlinks === 'boolean' ? (sh.followSymlinks ? 'true' : 'false') : 'inherit',
+      readOnlyMode: typeof sh.readOnly === 'boolean' ? (sh.readOnly ? 'true' : 'false') : 'inherit',
+      encrypted: !!sh.encrypted,
+      readCacheMiB: sh.readCacheMiB,
+      ram: sh.ram || null,
       acls: normalizeAclList(sh.acls),
       __editing: false,
     };
@@ -612,6 +1152,10 @@ function normalizeAclList(list) {
     read: Array.isArray(acl.read) ? [...acl.read] : [],
     write: Array.isArray(acl.write) ? [...acl.write] : [],
     admin: Array.isArray(acl.admin) ? [...acl.admin] : [],
+    denyRead: Array.isArray(acl.denyRead) ? [...acl.denyRead] : [],
+    denyWrite: Array.isArray(acl.denyWrite) ? [...acl.denyWrite] : [],
+    denyAdmin: Array.isArray(acl.denyAdmin) ? [...acl.denyAdmin] : [],
+    ownersManage: !!acl.ownersManage,
     __editing: false,
   }));
 }
@@ -628,6 +1172,7 @@ function updateStatus() {
     els.status.textContent = 'Loading configuration…';
     if (els.save) els.save.disabled = true;
     if (els.discard) els.discard.disabled = true;
+    if (els.review) els.review.disabled = true;
     return;
   }
   const savingText = state.saving ? 'Saving…' : 'Save changes';
@@ -638,6 +1183,9 @@ function updateStatus() {
   if (els.discard) {
     els.discard.disabled = !state.dirty || state.saving;
   }
+  if (els.review) {
+    els.review.disabled = !state.dirty || state.saving;
+  }
   els.status.textContent = state.dirty ? 'Unsaved changes' : 'All changes saved';
 }
 
@@ -680,6 +1228,12 @@ function sharesListToMap() {
     };
     if (share.followMode === 'true') entry.followSymlinks = true;
     else if (share.followMode === 'false') entry.followSymlinks = false;
+    if (share.readOnlyMode === 'true') entry.readOnly = true;
+    else if (share.readOnlyMode === 'false') entry.readOnly = false;
+    // Not editable here; must survive a save or the share would be served raw.
+    if (share.encrypted) entry.encrypted = true;
+    if (typeof share.readCacheMiB === 'number') entry.readCacheMiB = share.readCacheMiB;
+    if (share.ram) entry.ram = share.ram;
     map[name] = entry;
     seen.add(name);
   }
@@ -695,12 +1249,17 @@ function normalizeAclPayload(list) {
     read: parseList(Array.isArray(acl.read) ? acl.read.join(',') : acl.read),
     write: parseList(Array.isArray(acl.write) ? acl.write.join(',') : acl.write),
     admin: parseList(Array.isArray(acl.admin) ? acl.admin.join(',') : acl.admin),
+    denyRead: parseList(Array.isArray(acl.denyRead) ? acl.denyRead.join(',') : acl.denyRead),
+    denyWrite: parseList(Array.isArray(acl.denyWrite) ? acl.denyWrite.join(',') : acl.denyWrite),
+    denyAdmin: parseList(Array.isArray(acl.denyAdmin) ? acl.denyAdmin.join(',') : acl.denyAdmin),
+    ...(acl.ownersManage ? {ownersManage: true} : {}),
   }));
 }
 
 function formatPath(path) {
   const trimmed = String(path || '').trim();
   if (!trimmed || trimmed === '/') return '/';
+  if (trimmed.startsWith('re:')) return trimmed;
   return `/${trimmed.replace(/^\/+/, '').replace(/\/+$/, '')}`;
 }
 
@@ -708,23 +1267,29 @@ function formatPathLabel(path) {
   return formatPath(path || '/');
 }
 
+function configPayload() {
+  const payload = {
+    root: state.config.root || '',
+    stateDir: state.config.stateDir || '',
+    followSymlinks: !!state.config.followSymlinks,
+    readOnly: !!state.config.readOnly,
+    authOptional: !!state.config.authOptional,
+    acls: normalizeAclPayload(state.config.acls),
+    shares: sharesListToMap(),
+  };
+  if (!payload.root && Object.keys(payload.shares).length === 0) {
+    throw new Error('Set a root or define at least one share.');
+  }
+  return payload;
+}
+
 async function saveConfig() {
   if (!state.config || state.saving || !state.dirty) {
     return;
   }
   let payload;
   try {
-    payload = {
-      root: state.config.root || '',
-      stateDir: state.config.stateDir || '',
-      followSymlinks: !!state.config.followSymlinks,
-      authOptional: !!state.config.authOptional,
-      acls: normalizeAclPayload(state.config.acls),
-      shares: sharesListToMap(),
-    };
-    if (!payload.root && Object.keys(payload.shares).length === 0) {
-      throw new Error('Set a root or define at least one share.');
-    }
+    payload = configPayload();
   } catch (err) {
     toast('Cannot save config', 'err', String(err));
     return;
@@ -749,6 +1314,66 @@ async function saveConfig() {
   }
 }
 
+// reviewConfig asks the server what saving would change (a dry run) and
+// lists it in the review pane.
+async function reviewConfig() {
+  if (!state.config || state.saving || !state.dirty) {
+    return;
+  }
+  let payload;
+  try {
+    payload = configPayload();
+  } catch (err) {
+    toast('Cannot review config', 'err', String(err));
+    return;
+  }
+  try {
+    const res = await fetch(`${BASE}/api/admin/config?dryRun=1`, {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(payload),
+    });
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    renderReview(data.changes || [], data.warnings || []);
+    activatePane('review');
+  } catch (err) {
+    toast('Review failed', 'err', String(err));
+  }
+}
+
+function renderReview(changes, warnings) {
+  if (!els.reviewList) return;
+  if (els.reviewWarnings) {
+    els.reviewWarnings.textContent = warnings.join(' · ');
+  }
+  els.reviewList.innerHTML = '';
+  if (!changes.length) {
+    els.reviewList.textContent = 'No changes: saving would leave the config as it is.';
+    return;
+  }
+  const show = (v) => (v === undefined ? '—' : JSON.stringify(v));
+  const table = document.createElement('table');
+  table.className = 'admin-table';
+  const thead = document.createElement('thead');
+  thead.innerHTML = '<tr><th>Setting</th><th>Running</th><th>After saving</th></tr>';
+  table.appendChild(thead);
+  const tbody = document.createElement('tbody');
+  changes.forEach((c) => {
+    const tr = document.createElement('tr');
+    [c.path, show(c.from), show(c.to)].forEach((text) => {
+      const td = document.createElement('td');
+      td.textContent = text;
+      tr.appendChild(td);
+    });
+    tbody.appendChild(tr);
+  });
+  table.appendChild(tbody);
+  els.reviewList.appendChild(table);
+}
+
 async function discardChanges() {
   if (!state.dirty) return;
   await loadConfig();
@@ -846,6 +1471,7 @@ async function deleteUser(username) {
 
 async function createToken() {
   const username = (els.tokenUser?.value || '').trim();
+  const label = (els.tokenLabel?.value || '').trim();
   if (!username) {
     toast('Missing username', 'err');
     return;
@@ -854,7 +1480,7 @@ async function createToken() {
     const res = await fetch(`${BASE}/api/admin/tokens`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({ username }),
+      body: JSON.stringify({ username, label }),
     });
     if (!res.ok) {
       throw new Error(await res.text());
@@ -866,7 +1492,10 @@ async function createToken() {
     if (els.tokenCopy) {
       els.tokenCopy.disabled = !(data.token);
     }
-    toast('Token created', 'ok', username);
+    if (els.tokenLabel) {
+      els.tokenLabel.value = '';
+    }
+    toast('Token created', 'ok', label ? `${username} · ${label}` : username);
     refreshState();
   } catch (err) {
     toast('Token create failed', 'err', String(err));
@@ -898,6 +1527,145 @@ async function revokeToken() {
   }
 }
 
+function guestPaths(value) {
+  return String(value || '').split(',').map((p) => p.trim()).filter(Boolean);
+}
+
+async function createGuest() {
+  const label = (els.guestLabel?.value || '').trim();
+  const hours = Number(els.guestHours?.value || 0) || 0;
+  try {
+    const res = await fetch(`${BASE}/api/admin/guests`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({
+        label,
+        hours,
+        read: guestPaths(els.guestRead?.value),
+        write: guestPaths(els.guestWrite?.value),
+      }),
+    });
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    showGuest(data.user, data.url, data.expires, data.password);
+    if (els.guestLabel) {
+      els.guestLabel.value = '';
+    }
+    toast('Guest created', 'ok', label ? `${data.user} · ${label}` : data.user);
+    loadGuests();
+    refreshState();
+  } catch (err) {
+    toast('Guest create failed', 'err', String(err));
+  }
+}
+
+function showGuest(user, url, expires, password = '') {
+  if (!els.guestCard) return;
+  els.guestCard.classList.remove('hidden');
+  if (els.guestQr) {
+    els.guestQr.src = `${BASE}/api/admin/guests/qr?${new URLSearchParams({ user })}`;
+  }
+  if (els.guestUser) els.guestUser.textContent = user;
+  if (els.guestPass) {
+    els.guestPass.textContent = password || 'shown once, at creation';
+  }
+  if (els.guestExpires) {
+    els.guestExpires.textContent = `Expires ${new Date(expires * 1000).toLocaleString()}`;
+  }
+  if (els.guestUrl) els.guestUrl.value = url || '';
+}
+
+async function loadGuests() {
+  try {
+    const res = await fetch(`${BASE}/api/admin/guests`);
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    state.guests = Array.isArray(data.guests) ? data.guests : [];
+    renderGuests();
+  } catch {
+    // Not an admin, or admin endpoints are off: the pane stays empty.
+  }
+}
+
+function renderGuests() {
+  if (!els.guestsList || !els.guestsEmpty) return;
+  els.guestsList.innerHTML = '';
+  if (!state.guests.length) {
+    els.guestsEmpty.classList.remove('hidden');
+    return;
+  }
+  els.guestsEmpty.classList.add('hidden');
+  const table = document.createElement('table');
+  table.className = 'admin-table';
+  const thead = document.createElement('thead');
+  thead.innerHTML = '<tr><th>Guest</th><th>Label</th><th>Access</th><th>Expires</th><th style="text-align:right">Actions</th></tr>';
+  table.appendChild(thead);
+  const tbody = document.createElement('tbody');
+  state.guests.forEach((g) => {
+    const tr = document.createElement('tr');
+    const userTd = document.createElement('td');
+    userTd.textContent = g.user;
+    const labelTd = document.createElement('td');
+    labelTd.textContent = g.label || '—';
+    const accessTd = document.createElement('td');
+    const access = [];
+    (g.read || []).forEach((p) => access.push(`read ${p}`));
+    (g.write || []).forEach((p) => access.push(`write ${p}`));
+    accessTd.textContent = access.join(', ') || 'what everybody may read';
+    const expTd = document.createElement('td');
+    expTd.textContent = new Date(g.expires * 1000).toLocaleString();
+    if (g.expires * 1000 <= Date.now()) {
+      expTd.className = 'muted';
+    }
+    const actionTd = document.createElement('td');
+    actionTd.style.textAlign = 'right';
+    const qrBtn = document.createElement('button');
+    qrBtn.type = 'button';
+    qrBtn.className = 'btn ghost';
+    qrBtn.innerHTML = `${iconUse('eye')}QR`;
+    qrBtn.addEventListener('click', () => showGuest(g.user, g.url, g.expires));
+    const removeBtn = document.createElement('button');
+    removeBtn.type = 'button';
+    removeBtn.className = 'btn ghost danger';
+    removeBtn.innerHTML = `${iconUse('trash')}Remove`;
+    removeBtn.addEventListener('click', () => removeGuest(g.user));
+    actionTd.appendChild(qrBtn);
+    actionTd.appendChild(removeBtn);
+    tr.appendChild(userTd);
+    tr.appendChild(labelTd);
+    tr.appendChild(accessTd);
+    tr.appendChild(expTd);
+    tr.appendChild(actionTd);
+    tbody.appendChild(tr);
+  });
+  table.appendChild(tbody);
+  els.guestsList.appendChild(table);
+}
+
+async function removeGuest(user) {
+  if (!confirm(`Remove guest ${user}? Their link and password stop working.`)) return;
+  try {
+    const res = await fetch(`${BASE}/api/admin/guests`, {
+      method: 'DELETE',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ user }),
+    });
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    els.guestCard?.classList.add('hidden');
+    toast('Guest removed', 'ok', user);
+    loadGuests();
+    refreshState();
+  } catch (err) {
+    toast('Remove failed', 'err', String(err));
+  }
+}
+
 function renderTokens() {
   if (!els.tokensList || !els.tokensEmpty) return;
   els.tokensList.innerHTML = '';
@@ -909,25 +1677,43 @@ function renderTokens() {
   const table = document.createElement('table');
   table.className = 'admin-table';
   const thead = document.createElement('thead');
-  thead.innerHTML = '<tr><th>Token</th><th>User</th><th style="text-align:right">Actions</th></tr>';
+  thead.innerHTML = '<tr><th>Label</th><th>Token</th><th>User</th><th>Last used</th><th style="text-align:right">Actions</th></tr>';
   table.appendChild(thead);
   const tbody = document.createElement('tbody');
   state.tokens.forEach((tok) => {
     const tr = document.createElement('tr');
+    const labelTd = document.createElement('td');
+    labelTd.textContent = tok.label || '—';
     const tokenTd = document.createElement('td');
     tokenTd.textContent = `${tok.tokenPrefix || '????'}…`;
     const userTd = document.createElement('td');
     userTd.textContent = tok.user || 'unknown';
+    const usedTd = document.createElement('td');
+    if (tok.lastUsed) {
+      usedTd.textContent = new Date(tok.lastUsed * 1000).toLocaleString();
+      if (tok.lastIP) usedTd.textContent += ` · ${tok.lastIP}`;
+    } else {
+      usedTd.textContent = 'never';
+      usedTd.className = 'muted';
+    }
     const actionTd = document.createElement('td');
     actionTd.style.textAlign = 'right';
+    const labelBtn = document.createElement('button');
+    labelBtn.type = 'button';
+    labelBtn.className = 'btn ghost';
+    labelBtn.innerHTML = `${iconUse('edit')}Label`;
+    labelBtn.addEventListener('click', () => labelToken(tok));
     const revokeBtn = document.createElement('button');
     revokeBtn.type = 'button';
-    revokeBtn.className = 'btn ghost';
-    revokeBtn.innerHTML = `${iconUse('edit')}Prepare revoke`;
-    revokeBtn.addEventListener('click', () => prepareTokenRevoke(tok.tokenPrefix));
+    revokeBtn.className = 'btn ghost danger';
+    revokeBtn.innerHTML = `${iconUse('trash')}Revoke`;
+    revokeBtn.addEventListener('click', () => revokeTokenID(tok));
+    actionTd.appendChild(labelBtn);
     actionTd.appendChild(revokeBtn);
+    tr.appendChild(labelTd);
     tr.appendChild(tokenTd);
     tr.appendChild(userTd);
+    tr.appendChild(usedTd);
     tr.appendChild(actionTd);
     tbody.appendChild(tr);
   });
@@ -935,12 +1721,43 @@ function renderTokens() {
   els.tokensList.appendChild(table);
 }
 
-function prepareTokenRevoke(prefix) {
-  if (!els.tokenRevoke) return;
-  els.tokenRevoke.value = '';
-  els.tokenRevoke.placeholder = prefix ? `Paste full token starting with ${prefix}…` : 'Paste full token…';
-  els.tokenRevoke.focus();
-  toast('Paste full token to revoke', 'info', prefix ? `${prefix}…` : '');
+async function labelToken(tok) {
+  const label = prompt(`Label for ${tok.tokenPrefix}… (${tok.user})`, tok.label || '');
+  if (label === null) return;
+  try {
+    const res = await fetch(`${BASE}/api/admin/tokens`, {
+      method: 'PATCH',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ id: tok.id, label: label.trim() }),
+    });
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    refreshState();
+  } catch (err) {
+    toast('Label failed', 'err', String(err));
+  }
+}
+
+async function revokeTokenID(tok) {
+  const name = tok.label ? `"${tok.label}"` : `${tok.tokenPrefix}…`;
+  if (!confirm(`Revoke token ${name} of ${tok.user}? Clients using it stop working.`)) {
+    return;
+  }
+  try {
+    const res = await fetch(`${BASE}/api/admin/tokens`, {
+      method: 'DELETE',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ id: tok.id }),
+    });
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    toast('Token revoked', 'ok', name);
+    refreshState();
+  } catch (err) {
+    toast('Revoke failed', 'err', String(err));
+  }
 }
 
 async function copyToken() {
@@ -979,6 +1796,71 @@ async function generateBcrypt() {
   }
 }
 
+async function loadWake() {
+  if (!els.wakeList || !els.wakeEmpty) return;
+  try {
+    const res = await fetch(`${BASE}/api/admin/wake`);
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    const data = await res.json();
+    renderWake(Array.isArray(data.hosts) ? data.hosts : []);
+  } catch (err) {
+    els.wakeList.textContent = `wake hosts failed: ${String(err)}`;
+  }
+}
+
+function renderWake(hosts) {
+  els.wakeList.innerHTML = '';
+  if (!hosts.length) {
+    els.wakeEmpty.classList.remove('hidden');
+    return;
+  }
+  els.wakeEmpty.classList.add('hidden');
+  const table = document.createElement('table');
+  table.className = 'admin-table';
+  const thead = document.createElement('thead');
+  thead.innerHTML = '<tr><th>Name</th><th>MAC</th><th>Broadcast</th><th style="text-align:right">Actions</th></tr>';
+  table.appendChild(thead);
+  const tbody = document.createElement('tbody');
+  hosts.forEach((h) => {
+    const tr = document.createElement('tr');
+    [h.name, h.mac, h.broadcast].forEach((text) => {
+      const td = document.createElement('td');
+      td.textContent = text;
+      tr.appendChild(td);
+    });
+    const actionTd = document.createElement('td');
+    actionTd.style.textAlign = 'right';
+    const btn = document.createElement('button');
+    btn.type = 'button';
+    btn.className = 'btn ghost';
+    btn.innerHTML = `${iconUse('play')}Wake`;
+    btn.addEventListener('click', () => wakeHost(h.name));
+    actionTd.appendChild(btn);
+    tr.appendChild(actionTd);
+    tbody.appendChild(tr);
+  });
+  table.appendChild(tbody);
+  els.wakeList.appendChild(table);
+}
+
+async function wakeHost(name) {
+  try {
+    const res = await fetch(`${BASE}/api/admin/wake`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ name }),
+    });
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+    toast(`Magic packet sent to ${name}`, 'ok');
+  } catch (err) {
+    toast('Wake failed', 'err', String(err));
+  }
+}
+
 async function copyBcrypt() {
   const value = els.bcryptOutput?.value;
   if (!value) return;
@@ -1032,7 +1914,7 @@ function toast(msg, type = 'ok', sub = '', dur = 2800) {
 }
 
 function iconUse(id) {
-  return `<svg class="i" aria-hidden="true"><use href="/assets/icons.svg#${id}"></use></svg>`;
+  return `<svg class="i" aria-hidden="true"><use href="${ROOT}/assets/icons.svg#${id}"></use></svg>`;
 }
 
 function makeId() {
@@ -71,7 +71,8 @@ let clip = null; // {op:"copy"|"cut", paths:[...], base:string, ts:number}
 
 let pvCtx = null; // {items:[listItem], idx:number}
 let pvSlideTimer = null;
-let pvEditState = null; // {path, name, content, dirty}
+let pvEditState = null; // {path, name, content, sha256, dirty}
+let pvTail = null; // EventSource of a followed log
 
 let lazyObs = null;
 function lazyInit() {
@@ -328,16 +329,45 @@ async function copyText(text) {
   }
 }
 
+// Where lanparty is mounted behind a reverse proxy ("basePath"), or "".
+const ROOT = String(document.body?.dataset.basePath || "");
+
+// Requests that change something carry the page's CSRF token (csrf.go).
+(() => {
+  const token = String(document.body?.dataset.csrf || "");
+  const plain = window.fetch.bind(window);
+  window.fetch = (input, init = {}) => {
+    const method = String(init.method || (input instanceof Request ? input.method : "GET")).toUpperCase();
+    if (!token || method === "GET" || method === "HEAD") return plain(input, init);
+    const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+    headers.set("X-CSRF-Token", token);
+    return plain(input, {...init, headers});
+  };
+})();
+
 // Share-aware base path:
-// - default share: ""
-// - named share:  "/s/<share>"
+// - default share: ROOT
+// - named share:  ROOT + "/s/<share>"
 const BASE = (() => {
   try {
-    const p = String(location.pathname || "/");
+    let p = String(location.pathname || "/");
+    if (ROOT && (p === ROOT || p.startsWith(ROOT + "/"))) p = p.slice(ROOT.length);
     const m = p.match(/^\/s\/([^/]+)(?:\/|$)/);
-    if (m && m[1]) return `/s/${m[1]}`;
+    if (m && m[1]) return `${ROOT}/s/${m[1]}`;
   } catch {}
-  return "";
+  return ROOT;
+})();
+
+// Subsystems the server has switched off (see the "disable" config key).
+const DISABLED = new Set(String(document.body?.dataset.disabled || "").split(" ").filter(Boolean));
+
+// With home folders on, the topbar links between "My files" (/s/~/) and the
+// shared tree.
+(() => {
+  const a = document.getElementById("homes-link");
+  if (!a || !BASE.startsWith(`${ROOT}/s/~`)) return;
+  a.textContent = "All files";
+  a.href = `${ROOT}/`;
 })();
 
 function fileUrl(rel, opts = {}) {
@@ -360,12 +390,13 @@ function classify(item) {
   return "file";
 }
 
-function isPreviewable(kind) {
-  return ["image","video","audio","pdf","text","archive"].includes(kind);
+function isPreviewable(kind, item) {
+  // item.preview: a server-side preview plugin handles the type.
+  return ["image","video","audio","pdf","text","archive"].includes(kind) || !!item?.preview;
 }
 
 function iconUse(id) {
-  return `<svg class="i" aria-hidden="true"><use href="/assets/icons.svg#${id}"></use></svg>`;
+  return `<svg class="i" aria-hidden="true"><use href="${ROOT}/assets/icons.svg#${id}"></use></svg>`;
 }
 
 function rerenderRows() {
@@ -587,13 +618,22 @@ async function pasteTo(destDirRel) {
   if (!mode) return;
   try { localStorage.setItem("lanpartyPasteMode", mode); } catch {}
   try {
-    if (clip.op === "cut") {
-      await apiMove(clip.paths, destDirRel || "", mode);
-      clearClip();
-      toast("Moved", {type: "ok", sub: `${clip.paths.length} item(s)`});
+    const cut = clip.op === "cut";
+    const res = cut
+      ? await apiMove(clip.paths, destDirRel || "", mode)
+      : await apiCopy(clip.paths, destDirRel || "", mode);
+    const errs = (res.items || []).filter(it => it.status === "error");
+    const done = (res.items || []).length - errs.length;
+    if (errs.length) {
+      const first = errs[0];
+      toast(cut ? "Move incomplete" : "Copy incomplete", {
+        type: "err",
+        sub: `${done} done, ${errs.length} failed — ${first.from}: ${first.error}`,
+        dur: 6000,
+      });
     } else {
-      await apiCopy(clip.paths, destDirRel || "", mode);
-      toast("Copied", {type: "ok", sub: `${clip.paths.length} item(s)`});
+      if (cut) clearClip();
+      toast(cut ? "Moved" : "Copied", {type: "ok", sub: `${done} item(s)`});
     }
     await refresh();
   } catch (e) {
@@ -646,7 +686,7 @@ async function renderWidePreview(item) {
     spvDownload.setAttribute("download", item.name || "download");
   }
 
-  if (!isPreviewable(kind) || item.isDir) {
+  if (!isPreviewable(kind, item) || item.isDir) {
     const d = document.createElement("div");
     d.className = "pvempty";
     d.textContent = item.isDir ? "Folders cannot be previewed." : "No preview available for this file type.";
@@ -654,6 +694,15 @@ async function renderWidePreview(item) {
     return;
   }
 
+  if (item.preview) {
+    const f = document.createElement("iframe");
+    f.className = "pv-media";
+    f.src = `${BASE}/api/preview?path=${encodeURIComponent(item.path)}`;
+    f.sandbox = "allow-scripts";
+    f.referrerPolicy = "no-referrer";
+    spvBody.appendChild(f);
+    return;
+  }
   if (kind === "image") {
     const img = document.createElement("img");
     img.className = "pv-media pv-img";
@@ -754,13 +803,22 @@ function showCtx(x, y, item) {
   // Primary actions
   if (item.isDir) {
     addItem("open", "Open", async () => setPath(item.path), {k: "Enter"});
-    addItem("archive", "Download zip", async () => downloadZip([item.path], item.name || "folder"));
+    if (!DISABLED.has("zip")) addItem("archive", "Download zip", async () => downloadZip([item.path], item.name || "folder"));
+    addItem("download", "Download manifest", async () => {
+      window.location.href = `${BASE}/api/manifest?path=${encodeURIComponent(item.path)}&dl=1`;
+    });
+    addItem("check", "Download SHA256SUMS", async () => {
+      window.location.href = `${BASE}/api/checksumlist?path=${encodeURIComponent(item.path)}&dl=1`;
+    });
   } else {
-    if (isPreviewable(kind)) addItem("eye", "Preview", async () => openPreview(item), {k: "Enter"});
+    if (isPreviewable(kind, item)) addItem("eye", "Preview", async () => openPreview(item), {k: "Enter"});
     addItem("open", "Open", async () => window.open(fileUrl(item.path), "_blank"));
     addItem("download", "Download", async () => downloadFile(item.path), {k: "D"});
-    if (hasMulti && isSel) addItem("archive", `Download zip (${selCount})`, async () => downloadSelectedZip());
-    else addItem("archive", "Download zip", async () => downloadZip([item.path], item.name || "file"));
+    if (!DISABLED.has("zip")) {
+      if (hasMulti && isSel) addItem("archive", `Download zip (${selCount})`, async () => downloadSelectedZip());
+      else addItem("archive", "Download zip", async () => downloadZip([item.path], item.name || "file"));
+    }
+    if (item.checksum) addItem("check", "Verify checksum", async () => verifyChecksum(item));
   }
 
   addSep();
@@ -817,12 +875,39 @@ function showCtx(x, y, item) {
     if (ok) toast("Copied link", {type: "ok", sub: link});
     else toast("Copy failed", {type: "err", sub: link, dur: 4500});
   });
+  if (!item.isDir) {
+    addItem("link", "Copy temporary link (24h)", async () => {
+      try {
+        const d = await apiSign(item.path, 24 * 3600);
+        const link = `${location.origin}${d.url}`;
+        const ok = await copyText(link);
+        if (ok) toast("Copied temporary link", {type: "ok", sub: link});
+        else toast("Copy failed", {type: "err", sub: link, dur: 4500});
+      } catch (e) {
+        toast("Link failed", {type: "err", sub: String(e?.message || e), dur: 4500});
+      }
+    });
+  }
+  if (item.isDir) {
+    addItem("upload", "Create upload link…", async () => {
+      const v = prompt("Largest file the guest may send, in MiB?", "1024");
+      if (v == null) return;
+      const mib = Number(v);
+      if (!Number.isFinite(mib) || mib <= 0) throw new Error("not a size in MiB");
+      const d = await apiUploadTicket(item.path, Math.round(mib * 1048576), 24 * 3600);
+      const link = `${location.origin}${d.url}`;
+      const ok = await copyText(link);
+      if (ok) toast("Copied upload link (one file, 24h)", {type: "ok", sub: link});
+      else toast("Copy failed", {type: "err", sub: link, dur: 4500});
+    });
+  }
 
   if (!item.isDir && kind === "text") {
     addItem("edit", "Edit…", async () => {
       await openPreview(item, {keepCtx:false});
       pvEdit?.click();
     }, {k: "E"});
+    addItem("eye", "Follow live", async () => openPreview(item, {keepCtx:false, follow:true}));
   }
 
   addItem("edit", "Rename…", async () => {
@@ -838,6 +923,16 @@ function showCtx(x, y, item) {
     });
   });
 
+  addItem("clock", item.expires ? "Change expiry…" : "Expire after…", async () => {
+    const v = prompt("Move to the trash after how many hours? (0 keeps it)", item.expires ? String(Math.max(1, Math.round((item.expires * 1000 - Date.now()) / 3600000))) : "12");
+    if (v == null) return;
+    const hours = Number(v);
+    if (!Number.isFinite(hours) || hours < 0) throw new Error("not a number of hours");
+    await apiExpiry(item.path, Math.round(hours * 3600));
+    toast(hours ? "Expiry set" : "Expiry cleared", {type: "ok", sub: item.path});
+    await refresh();
+  });
+
   addItem("trash", "Delete…", async () => {
     if (hasMulti && isSel) {
       await deletePaths([...selected.values()]);
@@ -1138,8 +1233,28 @@ function downloadSelectedZip() {
   downloadZip(paths, name);
 }
 
-function downloadZip(paths, name) {
+// Selections at least this big ask before streaming a zip.
+const ZIP_WARN_BYTES = 4 * 1024 * 1024 * 1024;
+
+async function apiZipSize(paths) {
+  const body = new URLSearchParams();
+  for (const p of paths) body.append("paths", p);
+  const res = await fetch(`${BASE}/api/zipsize`, {method: "POST", body});
+  if (!res.ok) throw new Error(await res.text());
+  return await res.json();
+}
+
+async function downloadZip(paths, name) {
   if (!paths || paths.length === 0) return;
+  try {
+    const est = await apiZipSize(paths);
+    if (est.bytes >= ZIP_WARN_BYTES) {
+      const ok = await confirmToast(`This zip is ${fmtSize(est.bytes)}`, {sub: `${est.entries} files. Download anyway?`, okLabel: "Download", cancelLabel: "Cancel", icon: "archive"});
+      if (!ok) return;
+    }
+  } catch {
+    // Estimation is advisory; stream anyway.
+  }
   const form = document.createElement("form");
   form.method = "POST";
   form.action = `${BASE}/api/zip`;
@@ -1181,6 +1296,47 @@ async function apiList(rel) {
   return await res.json();
 }
 
+// apiListStream reads /api/list?stream=1 and calls onItems with each batch of
+// entries as it arrives. Resolves to {path, readme, items} once complete.
+async function apiListStream(rel, onItems, signal) {
+  const res = await fetch(`${BASE}/api/list?stream=1&path=${encodeURIComponent(rel || "")}`, {signal});
+  if (!res.ok) throw new Error(await res.text());
+  const out = {path: rel, readme: null, items: []};
+  const reader = res.body.getReader();
+  const dec = new TextDecoder();
+  let buf = "";
+  let head = true;
+  let done = false;
+  for (;;) {
+    const {value, done: eof} = await reader.read();
+    if (value) buf += dec.decode(value, {stream: true});
+    const lines = buf.split("\n");
+    buf = eof ? "" : lines.pop();
+    const batch = [];
+    for (const line of lines) {
+      if (!line) continue;
+      const v = JSON.parse(line);
+      if (head) {
+        head = false;
+        out.path = v.path;
+        out.readme = v.readme || null;
+      } else if (v.done) {
+        if (v.error) throw new Error(v.error);
+        done = true;
+      } else {
+        batch.push(v);
+      }
+    }
+    if (batch.length) {
+      out.items.push(...batch);
+      onItems(batch, out.items.length);
+    }
+    if (eof) break;
+  }
+  if (!done) throw new Error("listing interrupted");
+  return out;
+}
+
 async function loadDirs(rel) {
   rel = rel || "";
   const cached = treeCache.get(rel);
@@ -1284,6 +1440,76 @@ async function renderTree() {
   await renderChildren("", 1);
 }
 
+async function apiSign(rel, ttl) {
+  const res = await fetch(`${BASE}/api/sign?path=${encodeURIComponent(rel)}&ttl=${ttl}`);
+  if (!res.ok) throw new Error(await res.text());
+  return await res.json();
+}
+
+async function apiUploadTicket(rel, maxBytes, ttl) {
+  const res = await fetch(`${BASE}/api/upload-tickets`, {
+    method: "POST",
+    headers: {"Content-Type":"application/json"},
+    body: JSON.stringify({path: rel, maxBytes, ttl})
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return await res.json();
+}
+
+async function apiPlaybackGet(rel) {
+  const res = await fetch(`${BASE}/api/playback?path=${encodeURIComponent(rel)}`);
+  if (!res.ok) throw new Error(await res.text());
+  return await res.json();
+}
+
+async function apiPlaybackPut(rel, position, duration) {
+  const res = await fetch(`${BASE}/api/playback?path=${encodeURIComponent(rel)}`, {
+    method: "PUT",
+    headers: {"Content-Type":"application/json"},
+    body: JSON.stringify({position, duration})
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return await res.json();
+}
+
+// Resume videos where the user left off and save progress while playing.
+function trackPlayback(v, rel) {
+  let last = 0;
+  const save = () => {
+    const d = Number(v.duration) || 0;
+    let pos = Number(v.currentTime) || 0;
+    // Near the end counts as finished.
+    if (d > 0 && d - pos < 10) pos = 0;
+    apiPlaybackPut(rel, pos, d).catch(() => {});
+  };
+  v.addEventListener("loadedmetadata", async () => {
+    try {
+      const p = await apiPlaybackGet(rel);
+      if (p && p.position > 5 && (!v.duration || p.position < v.duration - 10)) {
+        v.currentTime = p.position;
+        toast(`Resumed at ${fmtDuration(p.position)}`);
+      }
+    } catch {}
+  }, {once: true});
+  v.addEventListener("timeupdate", () => {
+    const now = Date.now();
+    if (now - last < 10000) return;
+    last = now;
+    save();
+  });
+  v.addEventListener("pause", save);
+}
+
+function fmtDuration(sec) {
+  sec = Math.max(0, Math.floor(Number(sec) || 0));
+  const h = Math.floor(sec / 3600);
+  const m = Math.floor((sec % 3600) / 60);
+  const s = sec % 60;
+  const mm = String(m).padStart(h ? 2 : 1, "0");
+  const ss = String(s).padStart(2, "0");
+  return h ? `${h}:${mm}:${ss}` : `${mm}:${ss}`;
+}
+
 async function apiSearch(baseRel, q) {
   const res = await fetch(`${BASE}/api/search?path=${encodeURIComponent(baseRel || "")}&q=${encodeURIComponent(q)}`);
   if (!res.ok) throw new Error(await res.text());
@@ -1310,6 +1536,16 @@ async function apiRename(fromRel, toRel) {
   return await res.json();
 }
 
+async function apiExpiry(rel, seconds) {
+  const res = await fetch(`${BASE}/api/expiry`, {
+    method: "POST",
+    headers: {"Content-Type":"application/json"},
+    body: JSON.stringify({path: rel, in: seconds})
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return await res.json();
+}
+
 async function apiDelete(rel) {
   const res = await fetch(`${BASE}/api/delete`, {
     method: "POST",
@@ -1360,15 +1596,32 @@ async function apiUploadGet(id, signal) {
   return await res.json();
 }
 
-async function apiUploadPatch2(id, start, end, total, blob, signal) {
-  const res = await fetch(`${BASE}/api/uploads/${encodeURIComponent(id)}`, {
-    method: "PATCH",
-    headers: {"Content-Range": `bytes ${start}-${end}/${total}`},
-    body: blob,
-    signal,
-  });
+async function apiUploadList(signal) {
+  const res = await fetch(`${BASE}/api/uploads`, {method:"GET", signal});
   if (!res.ok) throw new Error(await res.text());
-  return await res.json();
+  return (await res.json()).uploads || [];
+}
+
+async function apiUploadPatch2(id, start, end, total, blob, signal) {
+  while (true) {
+    const res = await fetch(`${BASE}/api/uploads/${encodeURIComponent(id)}`, {
+      method: "PATCH",
+      headers: {"Content-Range": `bytes ${start}-${end}/${total}`},
+      body: blob,
+      signal,
+    });
+    // The server caps concurrent uploads; wait for a free slot.
+    if (res.status === 429) {
+      const secs = Number(res.headers.get("Retry-After")) || 5;
+      await new Promise((resolve, reject) => {
+        const tm = setTimeout(resolve, secs * 1000);
+        signal?.addEventListener("abort", () => { clearTimeout(tm); reject(new DOMException("aborted", "AbortError")); }, {once: true});
+      });
+      continue;
+    }
+    if (!res.ok) throw new Error(await res.text());
+    return await res.json();
+  }
 }
 
 async function apiUploadFinish2(id, signal) {
@@ -1383,12 +1636,24 @@ async function apiUploadCancel(id) {
   return await res.json();
 }
 
-async function apiWrite(rel, content, mode = "overwrite") {
+async function apiWrite(rel, content, mode = "overwrite", ifHash = "") {
   const res = await fetch(`${BASE}/api/write`, {
     method: "POST",
     headers: {"Content-Type":"application/json"},
-    body: JSON.stringify({path: rel, content, mode}),
+    body: JSON.stringify({path: rel, content, mode, ...(ifHash ? {ifHash} : {})}),
   });
+  if (res.status === 412) {
+    const cur = await res.json().catch(() => ({}));
+    const err = new Error(cur.error || "file changed since it was read");
+    err.conflict = cur;
+    throw err;
+  }
+  if (!res.ok) throw new Error(await res.text());
+  return await res.json();
+}
+
+async function apiRead(rel) {
+  const res = await fetch(`${BASE}/api/read?path=${encodeURIComponent(rel)}`);
   if (!res.ok) throw new Error(await res.text());
   return await res.json();
 }
@@ -1413,14 +1678,33 @@ async function apiMove(paths, destDir, mode = "rename") {
   return await res.json();
 }
 
+async function apiVerify(rel) {
+  const res = await fetch(`${BASE}/api/verify?path=${encodeURIComponent(rel || "")}`);
+  if (!res.ok) throw new Error(await res.text());
+  return await res.json();
+}
+
+async function verifyChecksum(item) {
+  toast("Verifying…", {type: "info", sub: item.name});
+  try {
+    const d = await apiVerify(item.path);
+    if (d.ok) toast("Checksum OK", {type: "ok", sub: `${d.algo} ${d.actual}`});
+    else toast("Checksum mismatch", {type: "err", sub: `expected ${d.expected}, got ${d.actual}`, dur: 8000});
+    item.verified = d.ok ? "ok" : "mismatch";
+    rerenderRows();
+  } catch (e) {
+    toast("Verify failed", {type: "err", sub: String(e?.message || e), dur: 4500});
+  }
+}
+
 async function apiZipList(rel) {
   const res = await fetch(`${BASE}/api/zipls?path=${encodeURIComponent(rel || "")}`);
   if (!res.ok) throw new Error(await res.text());
   return await res.json();
 }
 
-function zipEntryUrl(zipRel, entry) {
-  return `${BASE}/api/zipget?path=${encodeURIComponent(zipRel || "")}&entry=${encodeURIComponent(entry || "")}`;
+function zipEntryUrl(zipRel, entry, opts = {}) {
+  return `${BASE}/api/zipget?path=${encodeURIComponent(zipRel || "")}&entry=${encodeURIComponent(entry || "")}${opts.inline ? "&inline=1" : ""}`;
 }
 
 function renderCrumbs(rel) {
@@ -1471,6 +1755,7 @@ function closeModal() {
   pvOpen.removeAttribute("href");
   pvDownload.removeAttribute("href");
   pvEditState = null;
+  stopTail();
   if (pvEdit) pvEdit.disabled = true;
   if (pvSave) { pvSave.disabled = true; pvSave.innerHTML = `${iconUse("check")} Save`; }
   pvCtx = null;
@@ -1481,8 +1766,50 @@ function closeModal() {
   if (pvSlide) pvSlide.innerHTML = iconUse("play");
 }
 
+function stopTail() {
+  if (pvTail) pvTail.close();
+  pvTail = null;
+}
+
+// followTail shows a growing text file in the preview pane, via /api/tail.
+function followTail(item) {
+  const pre = document.createElement("pre");
+  pre.className = "pv-pre";
+  pvBody.appendChild(pre);
+  if (pvEdit) pvEdit.disabled = true;
+  const maxLines = 5000;
+  const lines = [];
+  const show = () => {
+    const atEnd = pre.scrollTop + pre.clientHeight >= pre.scrollHeight - 4;
+    pre.textContent = lines.join("\n");
+    if (atEnd) pre.scrollTop = pre.scrollHeight;
+  };
+  const es = new EventSource(`${BASE}/api/tail?path=${encodeURIComponent(item.path)}&lines=200&follow=1`);
+  pvTail = es;
+  es.onmessage = (ev) => {
+    lines.push(ev.data);
+    if (lines.length > maxLines) lines.splice(0, lines.length - maxLines);
+    show();
+  };
+  es.addEventListener("reset", (ev) => {
+    lines.push(`──── file ${ev.data} ────`);
+    show();
+  });
+  es.addEventListener("end", (ev) => {
+    stopTail();
+    lines.push(`──── stopped following (${ev.data}) ────`);
+    show();
+  });
+  es.onerror = () => {
+    if (pvTail !== es) return;
+    stopTail();
+    lines.push("──── connection lost ────");
+    show();
+  };
+}
+
 function previewableItemsInView() {
-  return (lastList || []).filter((it) => it && it.path && !it.isDir && isPreviewable(classify(it)));
+  return (lastList || []).filter((it) => it && it.path && !it.isDir && isPreviewable(classify(it), it));
 }
 
 function setPvCtxForItem(item) {
@@ -1523,9 +1850,20 @@ async function openPreview(item, opts = {}) {
   pvDownload.setAttribute("download", item.name || "download");
 
   pvBody.innerHTML = "";
+  stopTail();
   openModal();
   if (!opts.keepCtx) setPvCtxForItem(item);
 
+  if (item.preview) {
+    const f = document.createElement("iframe");
+    f.className = "pv-media";
+    f.src = `${BASE}/api/preview?path=${encodeURIComponent(item.path)}`;
+    f.sandbox = "allow-scripts";
+    f.referrerPolicy = "no-referrer";
+    pvBody.appendChild(f);
+    return;
+  }
+
   if (kind === "image") {
     const img = document.createElement("img");
     img.className = "pv-media pv-img";
@@ -1540,8 +1878,16 @@ async function openPreview(item, opts = {}) {
     v.controls = true;
     v.autoplay = true;
     v.playsInline = true;
-    v.src = url;
+    if (/\.mkv$/i.test(item.name || "")) {
+      // Browsers refuse MKV; play it repackaged as MP4 (no seeking), or
+      // the original if the server cannot remux it.
+      v.src = `${BASE}/api/remux?path=${encodeURIComponent(item.path)}`;
+      v.addEventListener("error", () => { v.src = url; }, { once: true });
+    } else {
+      v.src = url;
+    }
     pvBody.appendChild(v);
+    trackPlayback(v, item.path);
     return;
   }
   if (kind === "audio") {
@@ -1571,6 +1917,10 @@ async function openPreview(item, opts = {}) {
     pvBody.appendChild(f);
     return;
   }
+  if (kind === "text" && opts.follow) {
+    followTail(item);
+    return;
+  }
   if (kind === "text") {
     const pre = document.createElement("pre");
     pre.className = "pv-pre";
@@ -1744,6 +2094,16 @@ async function openPreview(item, opts = {}) {
           }
           const tdAct = document.createElement("td");
           tdAct.className = "right";
+          const ekind = classify({name: e.name});
+          if (["image", "video", "audio", "pdf"].includes(ekind)) {
+            const v = document.createElement("a");
+            v.className = "btn ghost zdl";
+            v.href = zipEntryUrl(item.path, prefix + (e._disp || e.name), {inline: true});
+            v.target = "_blank";
+            v.rel = "noreferrer";
+            v.innerHTML = `${iconUse("eye")} View`;
+            tdAct.appendChild(v);
+          }
           const a = document.createElement("a");
           a.className = "btn ghost zdl";
           a.href = zipEntryUrl(item.path, prefix + (e._disp || e.name));
@@ -1781,7 +2141,7 @@ function rowFor(item) {
 
   const ico = document.createElement("div");
   ico.className = "ico";
-  if (item.thumb && (kind === "image" || kind === "text")) {
+  if (item.thumb && (kind === "image" || kind === "text" || item.preview)) {
     ico.classList.add("thumb");
     ico.style.backgroundImage = `url("${item.thumb}")`;
   } else {
@@ -1829,6 +2189,7 @@ function rowFor(item) {
     b.type = "button";
     b.className = "fname openname";
     b.textContent = item.name;
+    if (item.owner) b.title = `Uploaded by ${item.owner}`;
     b.onclick = (ev) => {
       ev.preventDefault();
       ev.stopPropagation();
@@ -1837,7 +2198,7 @@ function rowFor(item) {
         setPath(item.path);
         return;
       }
-      if (isPreviewable(kind)) openPreview(item);
+      if (isPreviewable(kind, item)) openPreview(item);
       else window.open(fileUrl(item.path), "_blank");
     };
     fname = b;
@@ -1847,6 +2208,17 @@ function rowFor(item) {
   const namewrap = document.createElement("div");
   namewrap.className = "namewrap";
   namewrap.appendChild(fname);
+  if (item.checksum) namewrap.appendChild(sumBadge(item));
+  if (item.verdict && item.verdict !== "clean") namewrap.appendChild(verdictBadge(item));
+  if (item.expires) namewrap.appendChild(expiryBadge(item));
+  const vid = videoLabel(item);
+  if (vid) {
+    const v = document.createElement("span");
+    v.className = "vidinfo";
+    v.textContent = vid;
+    v.title = videoTitle(item);
+    namewrap.appendChild(v);
+  }
   if (inSearch && !ren && item.path) {
     const parts = item.path.split("/").filter(Boolean);
     parts.pop();
@@ -1937,6 +2309,59 @@ function rowFor(item) {
   return el;
 }
 
+// sumBadge marks files with a checksum sidecar and the last verify result.
+function sumBadge(item) {
+  const b = document.createElement("span");
+  b.className = "sumbad" + (item.verified === "ok" ? " ok" : item.verified === "mismatch" ? " bad" : "");
+  b.textContent = item.verified === "ok" ? "✓ " + item.checksum : item.verified === "mismatch" ? "✗ " + item.checksum : item.checksum;
+  b.title = item.verified === "ok" ? "Matches its checksum file"
+    : item.verified === "mismatch" ? "Does not match its checksum file"
+    : "Has a checksum file (right-click → Verify checksum)";
+  return b;
+}
+
+// verdictBadge shows what the hash lookup service said about a file that
+// is not known to be clean.
+function verdictBadge(item) {
+  const b = document.createElement(item.verdictLink ? "a" : "span");
+  b.className = "sumbad" + (item.verdict === "malicious" ? " bad" : item.verdict === "suspicious" ? " warn" : "");
+  b.textContent = item.verdict === "malicious" ? "⚠ malicious" : item.verdict === "suspicious" ? "⚠ suspicious" : "unscanned";
+  b.title = item.verdictDetail || (item.verdict === "unknown" ? "The hash lookup service does not know this file" : "Flagged by the hash lookup service");
+  if (item.verdictLink) {
+    b.href = item.verdictLink;
+    b.target = "_blank";
+    b.rel = "noopener noreferrer";
+    b.onclick = (e) => e.stopPropagation();
+  }
+  return b;
+}
+
+// expiryBadge counts down to when an item goes to the trash.
+function expiryBadge(item) {
+  const b = document.createElement("span");
+  const mins = Math.max(0, Math.round((item.expires * 1000 - Date.now()) / 60000));
+  b.className = "sumbad warn";
+  b.textContent = "⏳ " + (mins >= 2880 ? Math.round(mins / 1440) + "d" : mins >= 120 ? Math.round(mins / 60) + "h" : mins + "m");
+  b.title = "Moves to the trash " + new Date(item.expires * 1000).toLocaleString();
+  return b;
+}
+
+// videoLabel is "1:43:12 · 1080p" for videos the server could probe.
+function videoLabel(item) {
+  if (classify(item) !== "video") return "";
+  const parts = [];
+  if (item.duration) parts.push(fmtDuration(item.duration));
+  if (item.width && item.height) parts.push(`${Math.min(item.width, item.height)}p`);
+  return parts.join(" · ");
+}
+
+function videoTitle(item) {
+  const parts = [];
+  if (item.width && item.height) parts.push(`${item.width}×${item.height}`);
+  if (item.codec) parts.push(item.codec);
+  return parts.join(" ");
+}
+
 function rowForTile(item) {
   const el = document.createElement("div");
   el.className = "row";
@@ -1959,6 +2384,24 @@ function rowForTile(item) {
     img.alt = item.name || "";
     img.src = thumbUrl(item.thumb, 768);
     prev.appendChild(img);
+  } else if (kind === "archive" && item.thumb) {
+    // Archives without an image entry 404; fall back to the type icon.
+    const img = document.createElement("img");
+    img.loading = "lazy";
+    img.decoding = "async";
+    img.alt = item.name || "";
+    img.onerror = () => { prev.innerHTML = iconUse(kind); };
+    img.src = thumbUrl(item.thumb, 768);
+    prev.appendChild(img);
+  } else if (item.preview && item.thumb) {
+    // Plugin output may be HTML only; then there is no thumbnail.
+    const img = document.createElement("img");
+    img.loading = "lazy";
+    img.decoding = "async";
+    img.alt = item.name || "";
+    img.onerror = () => { prev.innerHTML = iconUse(kind); };
+    img.src = thumbUrl(item.thumb, 768);
+    prev.appendChild(img);
   } else if (kind === "image") {
     const img = document.createElement("img");
     img.loading = "lazy";
@@ -2056,6 +2499,7 @@ function rowForTile(item) {
     b.type = "button";
     b.className = "fname openname";
     b.textContent = item.name;
+    if (item.owner) b.title = `Uploaded by ${item.owner}`;
     b.onclick = (ev) => {
       ev.preventDefault();
       ev.stopPropagation();
@@ -2064,7 +2508,7 @@ function rowForTile(item) {
         setPath(item.path);
         return;
       }
-      if (isPreviewable(kind)) openPreview(item);
+      if (isPreviewable(kind, item)) openPreview(item);
       else window.open(fileUrl(item.path), "_blank");
     };
     fname = b;
@@ -2082,6 +2526,8 @@ function rowForTile(item) {
   } else {
     const timeLabel = tileTime.label;
     meta.textContent = timeLabel ? `${fmtSize(item.size)} · ${timeLabel}` : fmtSize(item.size);
+    const vid = videoLabel(item);
+    if (vid) meta.textContent = `${vid} · ${meta.textContent}`;
   }
   if (tileTime.title) meta.title = tileTime.title;
   namewrap.appendChild(meta);
@@ -2634,8 +3080,13 @@ async function loadReadme(info) {
   }
 }
 
+let listAbort = null;
+
 async function refresh() {
   const {rel, q} = parseView();
+  if (listAbort) listAbort.abort();
+  listAbort = new AbortController();
+  const signal = listAbort.signal;
   renderCrumbs(rel);
   setStatus("Loading…");
   rows.innerHTML = "";
@@ -2662,18 +3113,23 @@ async function refresh() {
       return;
     }
 
-    const data = await apiList(rel);
-    lastList = data.items || [];
-    applySort();
     selected = new Set(); // clear selection on navigation
     lastClickedIndex = -1;
-    for (const it of data.items || []) rows.appendChild(rowFor(it));
-    setStatus(`${(data.items||[]).length} items`);
+    // Rows appear as the server reads the directory; sorted once complete.
+    const data = await apiListStream(rel, (batch, n) => {
+      for (const it of batch) rows.appendChild(rowFor(it));
+      setStatus(`Loading… ${n} items`);
+    }, signal);
+    lastList = data.items || [];
+    applySort();
+    rerenderRows();
+    setStatus(`${lastList.length} items`);
     updateSelectionUI();
     if (opUp) opUp.disabled = rel === "";
     await loadReadme(data.readme);
     await renderTree();
   } catch (e) {
+    if (signal.aborted) return;
     setStatus(String(e));
     clearReadme();
     if (opUp) opUp.disabled = rel === "";
@@ -2779,8 +3235,10 @@ function renderUpq() {
 }
 
 async function enqueueUploads(files, useRelativePaths) {
+  if (DISABLED.has("uploads")) return;
   const dir = curPath();
   const list = [...(files || [])].filter((f) => f && f.name);
+  const batch = {}; // shared by the files dropped together; see pendingUpload
   for (const f of list) {
     const rp = useRelativePaths ? String(f.webkitRelativePath || f.name || "") : String(f.name || "");
     const destRel = dir ? `${dir}/${rp}` : rp;
@@ -2794,6 +3252,7 @@ async function enqueueUploads(files, useRelativePaths) {
       offset: 0,
       err: "",
       createdAt: Date.now(),
+      batch,
     });
   }
   renderUpq();
@@ -2845,6 +3304,7 @@ async function retryUpload(tid) {
   t.offset = 0;
   t.err = "";
   t.status = "queued";
+  t.batch = null; // its session list is stale now
   renderUpq();
   pumpUploads();
 }
@@ -2884,12 +3344,37 @@ async function requestUploadSession(t, file) {
   }
 }
 
+// pendingUpload offers to pick up an unfinished session of the same file
+// started elsewhere (another device, or before a reboot). The session list
+// is fetched once per batch, not once per file.
+async function pendingUpload(t, file) {
+  const batch = t.batch || (t.batch = {});
+  if (!batch.list) batch.list = apiUploadList().catch(() => []);
+  const list = await batch.list;
+  const sess = list.find((u) => u.dest === t.destRel && u.size === file.size && u.offset > 0 && !u.active);
+  if (!sess) return null;
+  const choice = await choiceToast("Resume upload?", {
+    sub: `${t.destRel} — ${fmtSize(sess.offset)} of ${fmtSize(sess.size)} already sent`,
+    icon: "upload",
+    type: "info",
+    choices: [{value: "resume", label: "Resume"}, {value: "new", label: "Start over"}],
+  });
+  return choice === "resume" ? sess : null;
+}
+
 async function runUploadTask(t) {
   const file = t.file;
   if (!file) return;
   t.ctrl = new AbortController();
   try {
     // Create or resume session.
+    if (!t.sessionId) {
+      const pending = await pendingUpload(t, file);
+      if (pending) {
+        t.sessionId = pending.id;
+        t.offset = pending.offset;
+      }
+    }
     if (!t.sessionId) {
       const sess = await requestUploadSession(t, file);
       if (!sess) {
@@ -3067,17 +3552,12 @@ if (pvSlide) pvSlide.onclick = () => {
 };
 if (pvEdit) pvEdit.onclick = async () => {
   if (!pvEditState || !pvEditState.path) return;
-  // Load full file (best-effort) up to 1MiB for editing.
-  const max = 1024 * 1024;
+  // The hash goes back with the save so edits by others are not clobbered.
   try {
-    const res = await fetch(fileUrl(pvEditState.path), {headers: {"Range": `bytes=0-${max-1}`}});
-    if (!res.ok && res.status !== 206) throw new Error(await res.text());
-    let txt = await res.text();
-    if (txt.length >= max) {
-      toast("File too large to edit in browser", {type:"err", sub:`Limit ${fmtSize(max)}`, dur: 4500});
-      return;
-    }
+    const d = await apiRead(pvEditState.path);
+    const txt = d.content;
     pvEditState.content = txt;
+    pvEditState.sha256 = d.sha256;
     pvEditState.dirty = false;
 
     pvBody.innerHTML = "";
@@ -3104,7 +3584,18 @@ if (pvSave) pvSave.onclick = async () => {
   pvSave.disabled = true;
   pvSave.innerHTML = `${iconUse("check")} Saving…`;
   try {
-    const r = await apiWrite(pvEditState.path, content, "overwrite");
+    let r;
+    try {
+      r = await apiWrite(pvEditState.path, content, "overwrite", pvEditState.sha256 || "");
+    } catch (e) {
+      if (!e.conflict) throw e;
+      const msg = e.conflict.exists === false
+        ? "This file was deleted since you opened it. Save it again anyway?"
+        : "Someone else changed this file since you opened it. Overwrite their changes?";
+      if (!confirm(msg)) throw e;
+      r = await apiWrite(pvEditState.path, content, "overwrite");
+    }
+    pvEditState.sha256 = r.sha256;
     toast("Saved", {type:"ok", sub: r.path || pvEditState.path});
     pvEditState.dirty = false;
     pvSave.innerHTML = `${iconUse("check")} Save`;
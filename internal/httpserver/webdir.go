@@ -0,0 +1,40 @@
+package httpserver
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// overlayFS serves each file from disk when it exists there and from the
+// embedded UI otherwise, so a -web-dir only needs the files it changes.
+// Files are looked up per request; edits show up on reload.
+type overlayFS struct {
+	disk fs.FS
+	base fs.FS
+}
+
+func newOverlayFS(dir string, base fs.FS) (fs.FS, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, errors.New("web dir is not a directory")
+	}
+	return overlayFS{disk: os.DirFS(dir), base: base}, nil
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.disk.Open(name)
+	if err == nil {
+		// A directory on disk must not hide embedded files below it.
+		if st, serr := f.Stat(); serr == nil && !st.IsDir() {
+			return f, nil
+		}
+		_ = f.Close()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.base.Open(name)
+}
@@ -0,0 +1,158 @@
+// Package manifest builds, signs and checks distribution manifests: the list
+// of files below a folder with their sizes and SHA-256, signed by the server
+// so a local copy can be proven identical to what was distributed.
+package manifest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Version is the current manifest format.
+const Version = 1
+
+// Manifest lists the files of a folder.
+type Manifest struct {
+	Version   int    `json:"version"`
+	Root      string `json:"root"` // folder the paths are relative to, as requested
+	Created   int64  `json:"created"`
+	Files     []File `json:"files"`
+	PublicKey string `json:"publicKey,omitempty"` // base64 ed25519
+	Signature string `json:"signature,omitempty"` // base64, over the manifest without it
+}
+
+// File is one entry of a Manifest.
+type File struct {
+	Path   string `json:"path"` // slash-separated, relative to Root
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// HashFile returns the hex SHA-256 and size of a file.
+func HashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func (m Manifest) signedBytes() []byte {
+	m.Signature = ""
+	b, _ := json.Marshal(m)
+	return b
+}
+
+// Sign sets PublicKey and Signature.
+func (m *Manifest) Sign(key ed25519.PrivateKey) {
+	m.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, m.signedBytes()))
+}
+
+// Verify checks the signature against the embedded key, and that the key is
+// pinned when pinned is not empty.
+func (m Manifest) Verify(pinned string) error {
+	pub, err := base64.StdEncoding.DecodeString(m.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("manifest: missing or bad public key")
+	}
+	if pinned != "" && pinned != m.PublicKey {
+		return errors.New("manifest: signed by a different key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(pub, m.signedBytes(), sig) {
+		return errors.New("manifest: bad signature")
+	}
+	return nil
+}
+
+// Fingerprint is a short form of the signing key for comparing by eye.
+func (m Manifest) Fingerprint() string {
+	sum := sha256.Sum256([]byte(m.PublicKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Problem kinds reported by Check.
+const (
+	Missing = "MISSING"
+	Size    = "SIZE"
+	Changed = "CHANGED"
+	Extra   = "EXTRA"
+)
+
+// Problem is a difference between a manifest and a local folder.
+type Problem struct {
+	Kind string
+	Path string
+}
+
+// Check compares dir with the manifest, hashing every file. With extra,
+// files in dir that the manifest does not list are reported too. progress,
+// if set, is called after each file.
+func (m Manifest) Check(ctx context.Context, dir string, extra bool, progress func(File)) ([]Problem, error) {
+	var out []Problem
+	listed := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		if ctx.Err() != nil {
+			return out, ctx.Err()
+		}
+		listed[f.Path] = true
+		p := filepath.Join(dir, filepath.FromSlash(f.Path))
+		st, err := os.Stat(p)
+		switch {
+		case err != nil:
+			out = append(out, Problem{Missing, f.Path})
+		case st.Size() != f.Size:
+			out = append(out, Problem{Size, f.Path})
+		default:
+			sum, _, err := HashFile(p)
+			if err != nil {
+				return out, err
+			}
+			if sum != f.SHA256 {
+				out = append(out, Problem{Changed, f.Path})
+			}
+		}
+		if progress != nil {
+			progress(f)
+		}
+	}
+	if !extra {
+		return out, nil
+	}
+	var extras []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); !listed[rel] {
+			extras = append(extras, rel)
+		}
+		return nil
+	})
+	sort.Strings(extras)
+	for _, p := range extras {
+		out = append(out, Problem{Extra, p})
+	}
+	return out, err
+}
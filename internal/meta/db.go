@@ -0,0 +1,162 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FileName is the database file inside a state dir.
+const FileName = "meta.db"
+
+// DB is the metadata of one state dir: every Store (bucket) in a single
+// bbolt file. Each transaction is synced to disk before Update returns, so
+// after a crash the file holds every committed transaction and nothing of
+// the others. Values are JSON; keys are stored behind a "/" since bbolt
+// has no room for the empty key (the share's root).
+type DB struct {
+	bolt *bolt.DB
+}
+
+// openTimeout bounds the wait for the file lock when another process has
+// the same state dir open.
+const openTimeout = 5 * time.Second
+
+// OpenDB opens (or creates) the database in dir and brings it up to date
+// with migrations.
+func OpenDB(dir string) (*DB, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	b, err := bolt.Open(filepath.Join(dir, FileName), 0o644, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	db := &DB{bolt: b}
+	if err := db.migrate(dir); err != nil {
+		_ = b.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Tx is a transaction: its writes are visible to its own reads at once and
+// to everybody else, and on disk, only if the whole transaction commits.
+type Tx struct {
+	tx *bolt.Tx
+}
+
+// Get decodes the value of key in bucket into v and reports whether it exists.
+func (tx *Tx) Get(bucket, key string, v any) (bool, error) {
+	raw := tx.get(bucket, key)
+	if raw == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// get returns the value of key in bucket, or nil. It is only valid for
+// the life of the transaction.
+func (tx *Tx) get(bucket, key string) []byte {
+	b := tx.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Get(dbKey(key))
+}
+
+func dbKey(key string) []byte {
+	return append([]byte{'/'}, key...)
+}
+
+// Put stores v under key in bucket.
+func (tx *Tx) Put(bucket, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.putRaw(bucket, key, b)
+}
+
+func (tx *Tx) putRaw(bucket, key string, raw []byte) error {
+	b, err := tx.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	return b.Put(dbKey(key), raw)
+}
+
+// Delete removes key from bucket. Deleting a missing key is not an error.
+func (tx *Tx) Delete(bucket, key string) error {
+	b := tx.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Delete(dbKey(key))
+}
+
+// keys returns the keys of bucket with the given prefix, sorted.
+func (tx *Tx) keys(bucket, prefix string) []string {
+	b := tx.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	var out []string
+	p := dbKey(prefix)
+	c := b.Cursor()
+	for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+		out = append(out, string(k[1:]))
+	}
+	return out
+}
+
+// Update runs fn in a transaction and commits it unless fn fails.
+// Transactions on one DB run one at a time.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	return db.bolt.Update(func(btx *bolt.Tx) error { return fn(&Tx{tx: btx}) })
+}
+
+// view runs fn in a read-only transaction; any number run at once.
+func (db *DB) view(fn func(tx *Tx) error) error {
+	return db.bolt.View(func(btx *bolt.Tx) error { return fn(&Tx{tx: btx}) })
+}
+
+// Close closes the file. Committed transactions are already on disk.
+func (db *DB) Close() error {
+	return db.bolt.Close()
+}
+
+// Bucket returns the store holding the records of one kind of metadata.
+// Names starting with "_" are reserved for the database itself.
+func (db *DB) Bucket(name string) *Store {
+	return &Store{db: db, name: name}
+}
+
+// migrations bring a state dir up to the current layout, in order. The
+// number applied is kept under "version" in the "_meta" bucket; pending
+// ones commit together with the version bump, or not at all. There are
+// none yet: meta.db is where this layout starts.
+var migrations []func(tx *Tx, dir string) error
+
+func (db *DB) migrate(dir string) error {
+	return db.Update(func(tx *Tx) error {
+		var version int
+		if _, err := tx.Get("_meta", "version", &version); err != nil {
+			return fmt.Errorf("%s: version: %w", FileName, err)
+		}
+		if version > len(migrations) {
+			return fmt.Errorf("%s: version %d is newer than this lanparty (%d)", FileName, version, len(migrations))
+		}
+		for ; version < len(migrations); version++ {
+			if err := migrations[version](tx, dir); err != nil {
+				return fmt.Errorf("%s: migration %d: %w", FileName, version+1, err)
+			}
+		}
+		return tx.Put("_meta", "version", version)
+	})
+}
@@ -0,0 +1,97 @@
+package meta
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func open(t *testing.T, dir string) *DB {
+	t.Helper()
+	db, err := OpenDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func get(t *testing.T, st *Store, key string) (string, bool) {
+	t.Helper()
+	var v string
+	ok, err := st.Get(key, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, ok
+}
+
+// A committed transaction is on disk before Update returns: a copy of the
+// file taken right then, as a crash would leave it, has it.
+func TestCommitSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir)
+	if err := db.Bucket("tombstones").Put("games/a.zip", "gone"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed := t.TempDir()
+	if err := os.WriteFile(filepath.Join(crashed, FileName), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := get(t, open(t, crashed).Bucket("tombstones"), "games/a.zip"); !ok || v != "gone" {
+		t.Fatalf("after crash: %q, %v", v, ok)
+	}
+}
+
+func TestFailedUpdateRollsBack(t *testing.T) {
+	db := open(t, t.TempDir())
+	boom := errors.New("boom")
+	err := db.Update(func(tx *Tx) error {
+		if err := tx.Put("a", "k1", "v"); err != nil {
+			return err
+		}
+		if err := tx.Put("b", "k2", "v"); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, ok := get(t, db.Bucket("a"), "k1"); ok {
+		t.Error("k1 committed")
+	}
+	if _, ok := get(t, db.Bucket("b"), "k2"); ok {
+		t.Error("k2 committed")
+	}
+}
+
+func TestTrees(t *testing.T) {
+	st := open(t, t.TempDir()).Bucket("davprops")
+	for _, k := range []string{"a", "a/b", "a/b/c", "ab", "x/a"} {
+		if err := st.Put(k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.MoveTree("a", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.Keys(""), []string{"ab", "x", "x/b", "x/b/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after move: %q, want %q", got, want)
+	}
+	if v, _ := get(t, st, "x/b"); v != "a/b" {
+		t.Errorf("x/b = %q", v)
+	}
+	if err := st.DeleteTree("x"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.Keys(""), []string{"ab"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after delete: %q, want %q", got, want)
+	}
+}
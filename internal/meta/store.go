@@ -0,0 +1,117 @@
+package meta
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Store is one bucket of a DB: a key/value store for one kind of per-share
+// metadata (WebDAV dead properties, playback positions, ...). Every change
+// is one transaction synced to disk, so it is meant for many small
+// records, not bulk data or hot counters.
+//
+// Keys are usually slash-separated relative paths; DeleteTree and MoveTree
+// treat them that way so metadata can follow files around.
+type Store struct {
+	db   *DB
+	name string
+}
+
+// Get decodes the value stored under key into v. It reports whether the key exists.
+func (s *Store) Get(key string, v any) (bool, error) {
+	var ok bool
+	err := s.db.view(func(tx *Tx) error {
+		var err error
+		ok, err = tx.Get(s.name, key, v)
+		return err
+	})
+	return ok, err
+}
+
+// Put stores v under key.
+func (s *Store) Put(key string, v any) error {
+	return s.db.Update(func(tx *Tx) error { return tx.Put(s.name, key, v) })
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (s *Store) Delete(key string) error {
+	return s.db.Update(func(tx *Tx) error { return tx.Delete(s.name, key) })
+}
+
+// Update atomically replaces the value under key with the result of fn.
+// cur is nil when the key does not exist; returning nil deletes the key.
+func (s *Store) Update(key string, fn func(cur json.RawMessage) (json.RawMessage, error)) error {
+	return s.db.Update(func(tx *Tx) error {
+		var cur json.RawMessage
+		if raw := tx.get(s.name, key); raw != nil {
+			cur = append(json.RawMessage(nil), raw...)
+		}
+		next, err := fn(cur)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return tx.Delete(s.name, key)
+		}
+		return tx.putRaw(s.name, key, next)
+	})
+}
+
+// Keys returns all keys with the given prefix, sorted.
+func (s *Store) Keys(prefix string) []string {
+	var out []string
+	_ = s.db.view(func(tx *Tx) error {
+		out = tx.keys(s.name, prefix)
+		return nil
+	})
+	return out
+}
+
+// treeKeys returns rel and the keys below rel/.
+func (tx *Tx) treeKeys(bucket, rel string) []string {
+	if rel == "" {
+		return tx.keys(bucket, "")
+	}
+	out := tx.keys(bucket, rel+"/")
+	if tx.get(bucket, rel) != nil {
+		out = append(out, rel)
+	}
+	return out
+}
+
+// DeleteTree removes rel and every key below rel/.
+func (s *Store) DeleteTree(rel string) error {
+	return s.db.Update(func(tx *Tx) error {
+		for _, k := range tx.treeKeys(s.name, rel) {
+			if err := tx.Delete(s.name, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MoveTree re-keys rel and everything below it to newRel, replacing any
+// existing entries at the destination.
+func (s *Store) MoveTree(rel, newRel string) error {
+	return s.db.Update(func(tx *Tx) error {
+		moved := map[string][]byte{}
+		for _, k := range tx.treeKeys(s.name, rel) {
+			moved[newRel+strings.TrimPrefix(k, rel)] = append([]byte(nil), tx.get(s.name, k)...)
+		}
+		if len(moved) == 0 {
+			return nil
+		}
+		for _, k := range append(tx.treeKeys(s.name, rel), tx.treeKeys(s.name, newRel)...) {
+			if err := tx.Delete(s.name, k); err != nil {
+				return err
+			}
+		}
+		for k, v := range moved {
+			if err := tx.putRaw(s.name, k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"strings"
+)
+
+// Copyparty translates a copyparty config file: the [accounts], [groups]
+// and [/volume] sections of the current format, and -a / -v arguments of
+// the older one-argument-per-line format.
+//
+//	[accounts]
+//	  ed: wark
+//	[/pub]
+//	  /srv/pub
+//	  accs:
+//	    r: *
+//	    rwmd: ed
+//
+// Permission letters map to lanparty as r, g, G, h: read; w, m, d: write;
+// a, A: admin (A also grants read and write).
+func Copyparty(b []byte) (Result, error) {
+	bl := newBuilder()
+	groups := map[string][]string{}
+	var grants []grant
+
+	section, sub := "", ""
+	var cur *volume
+	for _, raw := range lines(b) {
+		l := strings.TrimSpace(raw)
+		if l == "" {
+			continue
+		}
+		if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+			section, sub, cur = strings.TrimSpace(l[1:len(l)-1]), "", nil
+			if strings.HasPrefix(section, "/") {
+				cur = bl.volume(section)
+			}
+			continue
+		}
+		if strings.HasPrefix(l, "%") {
+			bl.warnf("include %q not followed; import that file separately", strings.TrimSpace(strings.TrimPrefix(l, "%")))
+			continue
+		}
+		if strings.HasPrefix(l, "-") {
+			// Argument style: "-a user:pass", "-v src:dst:perms".
+			fields := strings.Fields(l)
+			for i := 0; i < len(fields); i++ {
+				if i+1 >= len(fields) {
+					break
+				}
+				switch fields[i] {
+				case "-a":
+					u, p, ok := strings.Cut(fields[i+1], ":")
+					if ok {
+						bl.passwords[u] = p
+					}
+					i++
+				case "-v":
+					grants = append(grants, parseVolumeArg(bl, fields[i+1])...)
+					i++
+				}
+			}
+			continue
+		}
+		key, val, hasColon := strings.Cut(l, ":")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch {
+		case section == "accounts" && hasColon:
+			bl.passwords[key] = val
+		case section == "groups" && hasColon:
+			groups[key] = splitUsers(val)
+		case section == "global":
+			if key == "a" && hasColon {
+				if u, p, ok := strings.Cut(val, ":"); ok {
+					bl.passwords[u] = p
+				}
+			} else if key == "v" && hasColon {
+				grants = append(grants, parseVolumeArg(bl, val)...)
+			} else {
+				bl.warnf("[global] %s: not translated", key)
+			}
+		case cur != nil && hasColon && val == "" && (key == "accs" || key == "flags"):
+			sub = key
+		case cur != nil && sub == "accs" && hasColon:
+			grants = append(grants, grant{cur, key, splitUsers(val)})
+		case cur != nil && sub == "flags":
+			bl.warnf("volume %s: flag %s not translated", cur.vpath, key)
+		case cur != nil && cur.src == "" && (!hasColon || isPathLine(l)):
+			cur.src = l
+		case cur != nil:
+			bl.warnf("volume %s: %q not translated", cur.vpath, l)
+		default:
+			bl.warnf("[%s] %q not translated", section, l)
+		}
+	}
+
+	for _, g := range grants {
+		var users []string
+		for _, u := range g.users {
+			if name, ok := strings.CutPrefix(u, "@"); ok {
+				if members, ok := groups[name]; ok {
+					users = addUsers(users, members...)
+				} else {
+					bl.warnf("volume %s: unknown group @%s", g.v.vpath, name)
+				}
+				continue
+			}
+			users = addUsers(users, u)
+		}
+		applyPerms(bl, g.v, g.perms, users)
+	}
+	return bl.build()
+}
+
+// grant is one permission line of a volume before groups are expanded.
+type grant struct {
+	v     *volume
+	perms string
+	users []string
+}
+
+// parseVolumeArg reads "src:dst:perm,users:perm,users..." (an -v argument)
+// into a volume and its grants. A perm without users grants everybody.
+func parseVolumeArg(bl *builder, arg string) []grant {
+	parts := strings.Split(arg, ":")
+	// Windows drive letters: "C:\srv:pub:r".
+	if len(parts) > 1 && len(parts[0]) == 1 && strings.HasPrefix(parts[1], "\\") {
+		parts = append([]string{parts[0] + ":" + parts[1]}, parts[2:]...)
+	}
+	if len(parts) < 2 {
+		bl.warnf("-v %s: want src:dst[:perms]", arg)
+		return nil
+	}
+	v := bl.volume(parts[1])
+	v.src = parts[0]
+	var out []grant
+	for _, p := range parts[2:] {
+		perms, users, _ := strings.Cut(p, ",")
+		if perms == "c" {
+			bl.warnf("volume %s: flag %s not translated", v.vpath, users)
+			continue
+		}
+		list := splitUsers(users)
+		if len(list) == 0 {
+			list = []string{"*"}
+		}
+		out = append(out, grant{v, perms, list})
+	}
+	return out
+}
+
+// applyPerms grants users the lanparty equivalents of copyparty's letters.
+func applyPerms(bl *builder, v *volume, perms string, users []string) {
+	for _, c := range perms {
+		switch c {
+		case 'r', 'g', 'G', 'h':
+			v.read = addUsers(v.read, users...)
+		case 'w', 'm', 'd':
+			v.write = addUsers(v.write, users...)
+		case 'a':
+			v.admin = addUsers(v.admin, users...)
+		case 'A':
+			v.read = addUsers(v.read, users...)
+			v.write = addUsers(v.write, users...)
+			v.admin = addUsers(v.admin, users...)
+		case '.':
+			// "dotfiles visible": lanparty shows them anyway.
+		default:
+			bl.warnf("volume %s: permission %q not translated", v.vpath, string(c))
+		}
+	}
+}
+
+func splitUsers(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+// isPathLine reports whether l looks like a folder, including Windows ones
+// with a drive letter (which contain a colon).
+func isPathLine(l string) bool {
+	return strings.HasPrefix(l, "/") || strings.HasPrefix(l, ".") || strings.HasPrefix(l, "~") ||
+		len(l) >= 3 && l[1] == ':' && (l[2] == '\\' || l[2] == '/')
+}
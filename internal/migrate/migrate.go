@@ -0,0 +1,187 @@
+// Package migrate translates the configs of other file servers into
+// lanparty's, for `lanparty import`. It covers users, volumes and
+// permissions; everything else is reported as a warning and left out.
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"lanparty/internal/config"
+)
+
+// Result is a translated config and what did not make it across.
+type Result struct {
+	Config   config.Config
+	Warnings []string
+}
+
+// volume is one mount point: a filesystem folder at a URL path, with the
+// users allowed to read, write and administer it ("*" = everybody).
+type volume struct {
+	vpath, src         string
+	read, write, admin []string
+}
+
+// builder collects users and volumes and turns them into a config.
+type builder struct {
+	passwords map[string]string // plain text, hashed in build
+	hashes    map[string]string // already bcrypt
+	vols      []*volume
+	warnings  []string
+}
+
+func newBuilder() *builder {
+	return &builder{passwords: map[string]string{}, hashes: map[string]string{}}
+}
+
+func (b *builder) warnf(format string, args ...any) {
+	b.warnings = append(b.warnings, fmt.Sprintf(format, args...))
+}
+
+func (b *builder) volume(vpath string) *volume {
+	vpath = path.Clean("/" + strings.TrimSpace(vpath))
+	for _, v := range b.vols {
+		if v.vpath == vpath {
+			return v
+		}
+	}
+	v := &volume{vpath: vpath}
+	b.vols = append(b.vols, v)
+	return v
+}
+
+// build makes the config: the volume at "/" becomes the root, volumes whose
+// folder sits at the same place below it become ACLs there, and all others
+// become shares named after their URL path.
+func (b *builder) build() (Result, error) {
+	var cfg config.Config
+	users := make([]string, 0, len(b.passwords)+len(b.hashes))
+	for u := range b.passwords {
+		users = append(users, u)
+	}
+	for u := range b.hashes {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	if len(users) > 0 {
+		cfg.Users = map[string]config.User{}
+	}
+	for _, u := range users {
+		h, ok := b.hashes[u]
+		if !ok {
+			hb, err := bcrypt.GenerateFromPassword([]byte(b.passwords[u]), bcrypt.DefaultCost)
+			if err != nil {
+				return Result{}, fmt.Errorf("user %s: %w", u, err)
+			}
+			h = string(hb)
+		}
+		cfg.Users[u] = config.User{Bcrypt: h}
+	}
+
+	var root *volume
+	for _, v := range b.vols {
+		if v.src == "" {
+			b.warnf("volume %s has no folder; skipped", v.vpath)
+			continue
+		}
+		if v.vpath == "/" {
+			root = v
+		}
+	}
+	if root != nil {
+		cfg.Root = root.src
+	}
+	for _, v := range b.vols {
+		if v.src == "" {
+			continue
+		}
+		acl := config.ACL{Read: v.read, Write: v.write, Admin: v.admin}
+		if root != nil && (v == root || filepath.Clean(v.src) == filepath.Join(root.src, filepath.FromSlash(v.vpath))) {
+			acl.Path = v.vpath
+			cfg.ACLs = append(cfg.ACLs, acl)
+			continue
+		}
+		name := strings.ReplaceAll(strings.Trim(v.vpath, "/"), "/", "-")
+		if cfg.Shares == nil {
+			cfg.Shares = map[string]config.Share{}
+		}
+		acl.Path = "/"
+		cfg.Shares[name] = config.Share{Root: v.src, ACLs: []config.ACL{acl}}
+		if root != nil {
+			b.warnf("volume %s is not inside the root folder; it becomes the share %q at /s/%s/", v.vpath, name, name)
+		}
+	}
+	if cfg.Root == "" && len(cfg.Shares) == 0 {
+		return Result{}, errors.New("no volumes found")
+	}
+	// The deepest paths decide in lanparty; list them first as well.
+	sort.SliceStable(cfg.ACLs, func(i, j int) bool {
+		return strings.Count(cfg.ACLs[i].Path, "/")+boolInt(cfg.ACLs[i].Path != "/") >
+			strings.Count(cfg.ACLs[j].Path, "/")+boolInt(cfg.ACLs[j].Path != "/")
+	})
+	if root != nil && len(root.admin) > 0 {
+		cfg.ACLs = append([]config.ACL{{Path: "/admin", Read: root.admin, Write: root.admin, Admin: root.admin}}, cfg.ACLs...)
+	}
+	return Result{Config: cfg, Warnings: b.warnings}, nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// addUsers appends users to list, skipping duplicates.
+func addUsers(list []string, users ...string) []string {
+	for _, u := range users {
+		if u != "" && !contains(list, u) {
+			list = append(list, u)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect guesses the format of a config: "rclone" or "copyparty".
+func Detect(b []byte) string {
+	if bytes.Contains(b, []byte("serve webdav")) {
+		return "rclone"
+	}
+	return "copyparty"
+}
+
+// lines returns the lines of b without comments and trailing space.
+func lines(b []byte) []string {
+	var out []string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		l := sc.Text()
+		if i := strings.Index(l, " #"); i >= 0 {
+			l = l[:i]
+		}
+		if strings.HasPrefix(strings.TrimSpace(l), "#") {
+			l = ""
+		}
+		out = append(out, strings.TrimRight(l, " \t\r"))
+	}
+	return out
+}
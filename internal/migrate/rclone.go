@@ -0,0 +1,137 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Rclone translates an `rclone serve webdav` command line, as found in a
+// shell script or a systemd unit, into a config serving the same folder to
+// the same user:
+//
+//	rclone serve webdav /srv/files --addr :8080 --user bob --pass secret --read-only
+//
+// Only local folders can be imported; rclone remotes cannot be served by
+// lanparty.
+func Rclone(b []byte) (Result, error) {
+	args, ok := serveArgs(b)
+	if !ok {
+		return Result{}, errors.New("no `rclone serve webdav` command found")
+	}
+	bl := newBuilder()
+	var src, user, pass string
+	readOnly := false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		name, val, hasVal := strings.Cut(a, "=")
+		if !strings.HasPrefix(a, "-") {
+			if src == "" {
+				src = a
+			}
+			continue
+		}
+		takes := func() string {
+			if hasVal {
+				return val
+			}
+			if i+1 < len(args) {
+				i++
+				return args[i]
+			}
+			return ""
+		}
+		switch name {
+		case "--user":
+			user = takes()
+		case "--pass":
+			pass = takes()
+		case "--read-only":
+			readOnly = !hasVal || val == "true"
+		case "--htpasswd":
+			bl.warnf("--htpasswd %s: users not imported; add them to the config's users", takes())
+		case "--addr":
+			bl.warnf("--addr %s: start lanparty with -addr instead", takes())
+		default:
+			if hasVal || i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
+				bl.warnf("%s not translated", name)
+			} else {
+				bl.warnf("%s %s not translated", name, takes())
+			}
+		}
+	}
+	if src == "" {
+		return Result{}, errors.New("rclone serve webdav: missing folder")
+	}
+	if !isPathLine(src) {
+		return Result{}, fmt.Errorf("%s is an rclone remote; only local folders can be imported", src)
+	}
+	v := bl.volume("/")
+	v.src = src
+	switch {
+	case user != "":
+		bl.passwords[user] = pass
+		v.read = []string{user}
+		if !readOnly {
+			v.write = []string{user}
+		}
+	case readOnly:
+		bl.warnf("anonymous read-only access needs at least one user in lanparty; add users and an ACL with read [\"*\"]")
+	}
+	return bl.build()
+}
+
+// serveArgs finds the arguments after `serve webdav`, joining continued
+// lines and honoring quotes.
+func serveArgs(b []byte) ([]string, bool) {
+	joined := strings.ReplaceAll(string(b), "\\\r\n", " ")
+	joined = strings.ReplaceAll(joined, "\\\n", " ")
+	for _, l := range strings.Split(joined, "\n") {
+		if i := strings.Index(l, "#"); i >= 0 && strings.TrimSpace(l[:i]) == "" {
+			continue
+		}
+		words := splitShell(l)
+		for i := 0; i+1 < len(words); i++ {
+			if words[i] == "serve" && words[i+1] == "webdav" {
+				return words[i+2:], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// splitShell splits a command line into words like a POSIX shell would for
+// the simple cases: spaces separate, single and double quotes group.
+func splitShell(l string) []string {
+	var (
+		out   []string
+		cur   strings.Builder
+		quote rune
+		in    bool
+	)
+	for _, r := range l {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, in = r, true
+		case r == ' ' || r == '\t' || r == '\r':
+			if in {
+				out = append(out, cur.String())
+				cur.Reset()
+				in = false
+			}
+		default:
+			cur.WriteRune(r)
+			in = true
+		}
+	}
+	if in {
+		out = append(out, cur.String())
+	}
+	return out
+}
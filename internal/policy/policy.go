@@ -0,0 +1,119 @@
+// Package policy runs a Starlark script on access decisions, for rules
+// lanparty's prefix ACLs cannot express ("uploads to /tournament only
+// during match windows, and only by team captains").
+//
+// The script is loaded once and must define allow(req), which is called
+// after the ACLs for every access question:
+//
+//	def allow(req):
+//	    if req.path.startswith("/tournament/") and req.perm == "write":
+//	        return "captains" in req.groups and req.time.hour >= 18 and req.time.hour < 23
+//	    return None
+//
+// req has user, groups (those of users who signed in through a provider
+// that has them), path, perm ("read", "write" or "admin"), share ("" for
+// the default one), ip, time (a time.time in local time) and acl, what the
+// ACLs decided. allow returns True, False, or None to keep the ACL
+// decision. The time module of go.starlark.net/lib/time is predeclared and
+// print goes to the log. A script that fails, returns anything else or
+// runs longer than its step budget denies the request.
+//
+// Top-level values are frozen once the script has run, so calls share
+// nothing and run in parallel on the requests' goroutines.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+// defaultMaxSteps is config.Policy.MaxSteps when unset: plenty for a page
+// of comparisons, and well under a millisecond.
+const defaultMaxSteps = 100000
+
+// Script is an auth.Policy backed by a Starlark script.
+type Script struct {
+	name     string
+	allow    *starlark.Function
+	maxSteps uint64
+}
+
+// New loads the script of cfg and checks that it defines allow(req).
+func New(cfg config.Policy) (*Script, error) {
+	if cfg.Script == "" {
+		return nil, errors.New("policy: missing script")
+	}
+	src, err := os.ReadFile(cfg.Script)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+	s := &Script{name: cfg.Script, maxSteps: defaultMaxSteps}
+	if cfg.MaxSteps > 0 {
+		s.maxSteps = cfg.MaxSteps
+	}
+	globals, err := starlark.ExecFile(s.thread(), cfg.Script, src, starlark.StringDict{"time": starlarktime.Module})
+	if err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+	globals.Freeze()
+	fn, ok := globals["allow"].(*starlark.Function)
+	if !ok || fn.NumParams() != 1 {
+		return nil, fmt.Errorf("policy: %s does not define allow(req)", cfg.Script)
+	}
+	s.allow = fn
+	return s, nil
+}
+
+func (s *Script) thread() *starlark.Thread {
+	th := &starlark.Thread{
+		Name:  "policy",
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("policy %s: %s", s.name, msg) },
+	}
+	th.SetMaxExecutionSteps(s.maxSteps)
+	return th
+}
+
+// Decide implements auth.Policy. Failures deny; they are logged rather
+// than returned so a broken script reads as "forbidden", not as a server
+// error.
+func (s *Script) Decide(req auth.Request, acl bool) (bool, error) {
+	groups := make([]starlark.Value, len(req.Groups))
+	for i, g := range req.Groups {
+		groups[i] = starlark.String(g)
+	}
+	r := starlarkstruct.FromStringDict(starlark.String("request"), starlark.StringDict{
+		"user":   starlark.String(req.User),
+		"groups": starlark.Tuple(groups),
+		"path":   starlark.String(req.Path),
+		"perm":   starlark.String(req.Perm.String()),
+		"share":  starlark.String(req.Share),
+		"ip":     starlark.String(req.IP),
+		"time":   starlarktime.Time(req.Time.Local()),
+		"acl":    starlark.Bool(acl),
+	})
+	th := s.thread()
+	starlarktime.SetNow(th, func() (time.Time, error) { return req.Time, nil })
+	v, err := starlark.Call(th, s.allow, starlark.Tuple{r}, nil)
+	if err != nil {
+		log.Printf("policy %s: %s %s %s: %v", s.name, req.User, req.Perm, req.Path, err)
+		return false, nil
+	}
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return acl, nil
+	case starlark.Bool:
+		return bool(v), nil
+	}
+	log.Printf("policy %s: %s %s %s: allow returned %s, want True, False or None", s.name, req.User, req.Perm, req.Path, v.Type())
+	return false, nil
+}
@@ -0,0 +1,97 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"lanparty/internal/auth"
+	"lanparty/internal/config"
+)
+
+const testScript = `
+def allow(req):
+    if req.path.startswith("/tournament/") and req.perm == "write":
+        return "captains" in req.groups and req.time.hour >= 18 and req.time.hour < 23
+    if req.path == "/loop":
+        for i in range(1000000):
+            pass
+    if req.path == "/bad":
+        return "yes"
+    if req.path == "/fail":
+        fail("no")
+    return None
+`
+
+func load(t *testing.T, src string) *Script {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "policy.star")
+	if err := os.WriteFile(p, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(config.Policy{Script: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDecide(t *testing.T) {
+	s := load(t, testScript)
+	evening := time.Date(2026, 5, 2, 19, 4, 5, 0, time.Local)
+	noon := time.Date(2026, 5, 2, 12, 0, 0, 0, time.Local)
+	cases := []struct {
+		name   string
+		req    auth.Request
+		acl    bool
+		expect bool
+	}{
+		{"captain in window", auth.Request{User: "a", Groups: []string{"captains"}, Path: "/tournament/r1.dem", Perm: auth.PermWrite, Time: evening}, false, true},
+		{"captain outside window", auth.Request{User: "a", Groups: []string{"captains"}, Path: "/tournament/r1.dem", Perm: auth.PermWrite, Time: noon}, true, false},
+		{"not a captain", auth.Request{User: "b", Path: "/tournament/r1.dem", Perm: auth.PermWrite, Time: evening}, true, false},
+		{"None keeps allow", auth.Request{User: "b", Path: "/tournament/r1.dem", Perm: auth.PermRead, Time: noon}, true, true},
+		{"None keeps deny", auth.Request{User: "b", Path: "/other", Perm: auth.PermRead, Time: noon}, false, false},
+		{"over step budget", auth.Request{Path: "/loop", Perm: auth.PermRead, Time: noon}, true, false},
+		{"wrong type", auth.Request{Path: "/bad", Perm: auth.PermRead, Time: noon}, true, false},
+		{"error", auth.Request{Path: "/fail", Perm: auth.PermRead, Time: noon}, true, false},
+	}
+	for _, c := range cases {
+		got, err := s.Decide(c.req, c.acl)
+		if err != nil || got != c.expect {
+			t.Errorf("%s: got %v, %v; want %v", c.name, got, err, c.expect)
+		}
+	}
+}
+
+func TestNewRejects(t *testing.T) {
+	for _, src := range []string{"x = ", "def other(req):\n    return True\n", "def allow():\n    return True\n"} {
+		p := filepath.Join(t.TempDir(), "policy.star")
+		if err := os.WriteFile(p, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(config.Policy{Script: p}); err == nil {
+			t.Errorf("%q: loaded", src)
+		}
+	}
+}
+
+// Decisions run in parallel; run with -race.
+func TestDecideParallel(t *testing.T) {
+	s := load(t, testScript)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if ok, _ := s.Decide(auth.Request{Path: "/x", Perm: auth.PermRead, Time: time.Now()}, true); !ok {
+					t.Error("denied")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
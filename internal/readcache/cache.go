@@ -0,0 +1,214 @@
+// Package readcache keeps local copies of frequently read files from slow
+// share backends (NFS, USB disks, network mounts).
+//
+// Entries are keyed by source path, size and mtime, so a changed source file
+// simply misses and its stale copy ages out. A file is copied in the
+// background on its second read; the first read and the copy itself are
+// served from the source as usual. The cache is bounded by total size and
+// evicts least recently used files first.
+package readcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// hitsBeforeFill is how many reads make a file hot enough to copy.
+const hitsBeforeFill = 2
+
+// maxEntryFraction caps a single entry at 1/n of the cache so one huge file
+// cannot flush everything else.
+const maxEntryFraction = 4
+
+type entry struct {
+	size int64
+	used time.Time
+}
+
+// Cache is a size-bounded LRU of file copies in one directory.
+type Cache struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	size    int64
+	hits    map[string]int
+	filling map[string]bool
+	sem     chan struct{}
+}
+
+// New opens (or creates) a cache in dir holding at most maxBytes. Existing
+// entries are kept, with their mtime standing in for the last access.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:     dir,
+		max:     maxBytes,
+		entries: map[string]*entry{},
+		hits:    map[string]int{},
+		filling: map[string]bool{},
+		sem:     make(chan struct{}, 2),
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ents {
+		if e.IsDir() {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		if filepath.Ext(e.Name()) == ".tmp" {
+			_ = os.Remove(p)
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		c.entries[e.Name()] = &entry{size: info.Size(), used: info.ModTime()}
+		c.size += info.Size()
+	}
+	c.mu.Lock()
+	c.evictLocked(0)
+	c.mu.Unlock()
+	return c, nil
+}
+
+func cacheKey(abs string, st os.FileInfo) string {
+	h := sha256.Sum256([]byte(abs + "\x00" + strconv.FormatInt(st.Size(), 10) + "\x00" + strconv.FormatInt(st.ModTime().UnixNano(), 10)))
+	return hex.EncodeToString(h[:])
+}
+
+// Open returns the cached copy of abs (whose current stat is st), or nil if
+// there is none yet. A miss counts towards making the file hot and may start
+// a background copy.
+func (c *Cache) Open(abs string, st os.FileInfo) *os.File {
+	if c == nil || !st.Mode().IsRegular() || st.Size() == 0 || st.Size() > c.max/maxEntryFraction {
+		return nil
+	}
+	key := cacheKey(abs, st)
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		e.used = time.Now()
+		c.mu.Unlock()
+		f, err := os.Open(filepath.Join(c.dir, key))
+		if err != nil {
+			c.drop(key)
+			return nil
+		}
+		return f
+	}
+	c.hits[key]++
+	start := c.hits[key] >= hitsBeforeFill && !c.filling[key]
+	if start {
+		c.filling[key] = true
+		delete(c.hits, key)
+	}
+	// Hit counts for files that never become hot must not grow forever.
+	if len(c.hits) > 4096 {
+		c.hits = map[string]int{}
+	}
+	c.mu.Unlock()
+	if start {
+		go c.fill(key, abs, st)
+	}
+	return nil
+}
+
+// fill copies abs into the cache unless it changed while being copied.
+func (c *Cache) fill(key, abs string, st os.FileInfo) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	defer func() {
+		c.mu.Lock()
+		delete(c.filling, key)
+		c.mu.Unlock()
+	}()
+
+	src, err := os.Open(abs)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(c.dir, "fill-*.tmp")
+	if err != nil {
+		return
+	}
+	n, err := io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || n != st.Size() {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	if now, err := os.Stat(abs); err != nil || cacheKey(abs, now) != key {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictLocked(n)
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, key)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	c.entries[key] = &entry{size: n, used: time.Now()}
+	c.size += n
+}
+
+// evictLocked removes least recently used entries until need more bytes fit.
+func (c *Cache) evictLocked(need int64) {
+	if c.size+need <= c.max {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return c.entries[keys[i]].used.Before(c.entries[keys[j]].used) })
+	for _, k := range keys {
+		if c.size+need <= c.max {
+			return
+		}
+		// Open readers keep their copy on unix; on windows the remove fails
+		// and the entry is retried next time.
+		if err := os.Remove(filepath.Join(c.dir, k)); err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		c.size -= c.entries[k].size
+		delete(c.entries, k)
+	}
+}
+
+func (c *Cache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.size
+		delete(c.entries, key)
+	}
+	_ = os.Remove(filepath.Join(c.dir, key))
+}
+
+// Stats reports the number of cached files and their total size.
+func (c *Cache) Stats() (files int, bytes int64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
@@ -0,0 +1,327 @@
+package sealed
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// AES-256-GCM encryption for data lanparty keeps at rest in the state dir.
+//
+// Small values (thumbnails) are sealed in one piece: nonce || ciphertext.
+// Large files use a segmented stream so they never have to fit in memory:
+//
+//	"LPS1" || 7-byte random prefix || segment...
+//
+// Each segment holds up to SegmentSize plaintext bytes sealed with the nonce
+// prefix || 4-byte counter || last-flag. Every segment but the last is full,
+// so truncation and reordering are detected. Callers pass associated data
+// (e.g. the blob hash) to bind ciphertext to its name.
+
+const (
+	SegmentSize = 64 << 10
+	magic       = "LPS1"
+	prefixLen   = 7
+	// HeaderSize is the stream header length.
+	HeaderSize = len(magic) + prefixLen
+)
+
+var ErrCorrupt = errors.New("sealed: corrupt or wrong key")
+
+// Key is an AES-256-GCM key.
+type Key struct {
+	aead cipher.AEAD
+}
+
+// NewKey builds a key from 32 raw bytes.
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("sealed: key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{aead: aead}, nil
+}
+
+// LoadKeyFile reads a key file holding 64 hex characters (whitespace
+// ignored) or exactly 32 raw bytes.
+func LoadKeyFile(path string) (*Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if s := strings.TrimSpace(string(b)); len(s) == 64 {
+		if raw, err := hex.DecodeString(s); err == nil {
+			return NewKey(raw)
+		}
+	}
+	return NewKey(b)
+}
+
+// Seal encrypts a small value in one piece.
+func (k *Key) Seal(plain, ad []byte) []byte {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return k.aead.Seal(nonce, nonce, plain, ad)
+}
+
+// Open decrypts a value produced by Seal.
+func (k *Key) Open(b, ad []byte) ([]byte, error) {
+	ns := k.aead.NonceSize()
+	if len(b) < ns+k.aead.Overhead() {
+		return nil, ErrCorrupt
+	}
+	out, err := k.aead.Open(nil, b[:ns], b[ns:], ad)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return out, nil
+}
+
+func (k *Key) nonce(prefix []byte, ctr uint32, last bool) []byte {
+	n := make([]byte, 12)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixLen:], ctr)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+// Writer encrypts a stream; Close must be called to write the final segment.
+type Writer struct {
+	k      *Key
+	w      io.Writer
+	ad     []byte
+	prefix []byte
+	ctr    uint32
+	buf    []byte
+	out    []byte
+	err    error
+}
+
+// NewWriter starts a sealed stream on w.
+func (k *Key) NewWriter(w io.Writer, ad []byte) (*Writer, error) {
+	prefix := make([]byte, prefixLen)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append([]byte(magic), prefix...)); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		k: k, w: w, ad: ad, prefix: prefix,
+		buf: make([]byte, 0, SegmentSize),
+		out: make([]byte, 0, SegmentSize+k.aead.Overhead()),
+	}, nil
+}
+
+func (sw *Writer) Write(p []byte) (int, error) {
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	n := 0
+	for len(p) > 0 {
+		// Only flush a full segment once more data follows, so the last
+		// segment is always short (possibly empty) and carries the flag.
+		if len(sw.buf) == SegmentSize {
+			if sw.err = sw.flush(false); sw.err != nil {
+				return n, sw.err
+			}
+		}
+		c := copy(sw.buf[len(sw.buf):SegmentSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (sw *Writer) flush(last bool) error {
+	if sw.ctr == ^uint32(0) {
+		return errors.New("sealed: stream too long")
+	}
+	sw.out = sw.k.aead.Seal(sw.out[:0], sw.k.nonce(sw.prefix, sw.ctr, last), sw.buf, sw.ad)
+	sw.ctr++
+	sw.buf = sw.buf[:0]
+	_, err := sw.w.Write(sw.out)
+	return err
+}
+
+// Close writes the final segment. It does not close the underlying writer.
+func (sw *Writer) Close() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if len(sw.buf) == SegmentSize {
+		if sw.err = sw.flush(false); sw.err != nil {
+			return sw.err
+		}
+	}
+	sw.err = sw.flush(true)
+	if sw.err == nil {
+		sw.err = errors.New("sealed: writer closed")
+		return nil
+	}
+	return sw.err
+}
+
+// Reader decrypts a sealed stream.
+type Reader struct {
+	k      *Key
+	r      io.Reader
+	ad     []byte
+	prefix []byte
+	ctr    uint32
+	in     []byte
+	plain  []byte
+	done   bool
+}
+
+// NewReader reads the stream header from r.
+func (k *Key) NewReader(r io.Reader, ad []byte) (*Reader, error) {
+	hdr := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, hdr); err != nil || string(hdr[:len(magic)]) != magic {
+		return nil, ErrCorrupt
+	}
+	return &Reader{
+		k: k, r: r, ad: ad, prefix: hdr[len(magic):],
+		in: make([]byte, SegmentSize+k.aead.Overhead()),
+	}, nil
+}
+
+func (sr *Reader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(sr.r, sr.in)
+		last := false
+		switch {
+		case err == nil:
+		case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+			last = true
+		default:
+			return 0, err
+		}
+		plain, oerr := sr.k.aead.Open(sr.in[:0], sr.k.nonce(sr.prefix, sr.ctr, last), sr.in[:n], sr.ad)
+		if oerr != nil {
+			return 0, ErrCorrupt
+		}
+		sr.ctr++
+		sr.plain = plain
+		sr.done = last
+	}
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// PlainSize returns the plaintext length of a well-formed sealed stream of
+// cipherLen bytes.
+func PlainSize(cipherLen int64) int64 {
+	const tag = 16
+	body := cipherLen - int64(HeaderSize)
+	if body < tag {
+		return 0
+	}
+	segs := body/(SegmentSize+tag) + 1
+	return body - segs*tag
+}
+
+// Seeker gives random access to a sealed stream stored in an io.ReaderAt,
+// decrypting only the segments that are read. It implements io.ReadSeeker,
+// so sealed files can be served with http.ServeContent (Range requests).
+type Seeker struct {
+	k      *Key
+	ra     io.ReaderAt
+	ad     []byte
+	prefix []byte
+	size   int64 // plaintext size
+	segs   int64
+	pos    int64
+
+	cur   int64 // index of the decrypted segment in plain, -1 if none
+	in    []byte
+	plain []byte
+}
+
+// NewSeeker opens a sealed stream of cipherLen bytes for random access.
+func (k *Key) NewSeeker(ra io.ReaderAt, cipherLen int64, ad []byte) (*Seeker, error) {
+	hdr := make([]byte, HeaderSize)
+	if _, err := ra.ReadAt(hdr, 0); err != nil || string(hdr[:len(magic)]) != magic {
+		return nil, ErrCorrupt
+	}
+	size := PlainSize(cipherLen)
+	body := cipherLen - int64(HeaderSize)
+	if body < int64(k.aead.Overhead()) {
+		return nil, ErrCorrupt
+	}
+	return &Seeker{
+		k: k, ra: ra, ad: ad, prefix: hdr[len(magic):],
+		size: size,
+		segs: body/(SegmentSize+int64(k.aead.Overhead())) + 1,
+		cur:  -1,
+		in:   make([]byte, SegmentSize+k.aead.Overhead()),
+	}, nil
+}
+
+// Size returns the plaintext size.
+func (s *Seeker) Size() int64 { return s.size }
+
+func (s *Seeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("sealed: bad whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("sealed: negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *Seeker) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	seg := s.pos / SegmentSize
+	if seg != s.cur {
+		tag := int64(s.k.aead.Overhead())
+		off := int64(HeaderSize) + seg*(SegmentSize+tag)
+		n, err := s.ra.ReadAt(s.in, off)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		last := seg == s.segs-1
+		plain, err := s.k.aead.Open(s.in[:0], s.k.nonce(s.prefix, uint32(seg), last), s.in[:n], s.ad)
+		if err != nil {
+			s.cur = -1
+			return 0, ErrCorrupt
+		}
+		s.cur, s.plain = seg, plain
+	}
+	n := copy(p, s.plain[s.pos-seg*SegmentSize:])
+	s.pos += int64(n)
+	return n, nil
+}
@@ -0,0 +1,438 @@
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const announceEvery = 10 * time.Second
+
+// FetchOptions configures Fetch.
+type FetchOptions struct {
+	// URL is the file's download URL on the server, e.g.
+	// http://host:3923/s/games/f/pack.zip
+	URL string
+	// Out is the destination file.
+	Out string
+	// Auth adds credentials to requests sent to the server.
+	Auth func(*http.Request)
+	// Listen is the address chunks are served to other peers on; "" only
+	// downloads.
+	Listen string
+	// Parallel is the number of chunks fetched at once. Default 4.
+	Parallel int
+	// Seed keeps serving chunks this long after the download finished.
+	Seed time.Duration
+	Logf func(format string, args ...any)
+}
+
+// Stats reports where a Fetch got its bytes from.
+type Stats struct {
+	FromPeers int64
+	FromHost  int64
+	Served    int64
+}
+
+type client struct {
+	opt     FetchOptions
+	api     string // /api/swarm?path=...
+	fileURL string
+	http    *http.Client
+	port    int
+
+	mu    sync.Mutex
+	plan  Plan
+	have  []bool
+	index map[string]int // chunk hash -> an index holding it
+	f     *os.File
+	stats Stats
+}
+
+// Fetch downloads opt.URL into opt.Out through the swarm, serving finished
+// chunks to other peers while it runs and for opt.Seed afterwards.
+func Fetch(ctx context.Context, opt FetchOptions) (Stats, error) {
+	if opt.Parallel <= 0 {
+		opt.Parallel = 4
+	}
+	if opt.Logf == nil {
+		opt.Logf = func(string, ...any) {}
+	}
+	if opt.Auth == nil {
+		opt.Auth = func(*http.Request) {}
+	}
+	api, err := swarmURL(opt.URL)
+	if err != nil {
+		return Stats{}, err
+	}
+	c := &client{opt: opt, api: api, fileURL: opt.URL, http: &http.Client{Timeout: 2 * time.Minute}}
+
+	if opt.Listen != "" {
+		ln, err := net.Listen("tcp", opt.Listen)
+		if err != nil {
+			return Stats{}, err
+		}
+		c.port = ln.Addr().(*net.TCPAddr).Port
+		srv := &http.Server{Handler: http.HandlerFunc(c.serveChunk), ReadHeaderTimeout: 10 * time.Second}
+		go func() { _ = srv.Serve(ln) }()
+		defer srv.Close()
+		opt.Logf("serving chunks on %s", ln.Addr())
+	}
+
+	plan, err := c.announce(ctx, "")
+	if err != nil {
+		return Stats{}, err
+	}
+	c.plan = plan
+	c.have = make([]bool, len(plan.Chunks))
+	c.index = make(map[string]int, len(plan.Chunks))
+	opt.Logf("%d bytes in %d chunks, %d peers", plan.Size, len(plan.Chunks), len(plan.Peers))
+
+	part := opt.Out + ".part"
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer func() {
+		c.mu.Lock()
+		if c.f != nil {
+			_ = c.f.Close()
+		}
+		c.mu.Unlock()
+	}()
+	if err := f.Truncate(plan.Size); err != nil {
+		return Stats{}, err
+	}
+	c.f = f
+
+	if err := c.download(ctx); err != nil {
+		_ = os.Remove(part)
+		return c.stats, err
+	}
+	if err := c.finish(part); err != nil {
+		return c.stats, err
+	}
+	opt.Logf("done: %d bytes from peers, %d from host", c.stats.FromPeers, c.stats.FromHost)
+
+	if c.port != 0 && opt.Seed > 0 {
+		opt.Logf("seeding for %s", opt.Seed)
+		_, _ = c.announce(ctx, c.haveString())
+		t := time.NewTimer(opt.Seed)
+		tick := time.NewTicker(announceEvery)
+	seed:
+		for {
+			select {
+			case <-ctx.Done():
+				break seed
+			case <-t.C:
+				break seed
+			case <-tick.C:
+				_, _ = c.announce(ctx, c.haveString())
+			}
+		}
+		t.Stop()
+		tick.Stop()
+	}
+	if c.port != 0 {
+		// Leave the swarm so nobody tries us after we stop serving.
+		leave, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, _ = c.announce(leave, "")
+		cancel()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats, nil
+}
+
+// swarmURL turns .../f/<path> into .../api/swarm?path=<path>.
+func swarmURL(fileURL string) (string, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return "", err
+	}
+	i := strings.Index(u.Path, "/f/")
+	if i < 0 {
+		return "", fmt.Errorf("swarm: %s is not a /f/ download URL", fileURL)
+	}
+	rel := u.Path[i+len("/f/"):]
+	api := *u
+	api.Path = u.Path[:i] + "/api/swarm"
+	api.RawPath = ""
+	api.RawQuery = url.Values{"path": {rel}}.Encode()
+	return api.String(), nil
+}
+
+func (c *client) haveString() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return EncodeHave(c.have)
+}
+
+func (c *client) announce(ctx context.Context, have string) (Plan, error) {
+	body, _ := json.Marshal(Announce{Port: c.port, Have: have})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.api, bytes.NewReader(body))
+	if err != nil {
+		return Plan{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.opt.Auth(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Plan{}, fmt.Errorf("swarm: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var p Plan
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return Plan{}, err
+	}
+	if c.plan.Chunks != nil {
+		c.mu.Lock()
+		c.plan.Peers = p.Peers
+		c.mu.Unlock()
+	}
+	return p, nil
+}
+
+func (c *client) download(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	errc := make(chan error, c.opt.Parallel)
+	var wg sync.WaitGroup
+	for w := 0; w < c.opt.Parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := c.fetch(ctx, i); err != nil {
+					errc <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		tick := time.NewTicker(announceEvery)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				_, _ = c.announce(ctx, c.haveString())
+			}
+		}
+	}()
+
+	// Random order spreads chunks across peers quickly.
+feed:
+	for _, i := range rand.Perm(len(c.plan.Chunks)) {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	select {
+	case err := <-errc:
+		return err
+	default:
+		return ctx.Err()
+	}
+}
+
+// fetch gets chunk i from a peer that has it, or from the host.
+func (c *client) fetch(ctx context.Context, i int) error {
+	ch := c.plan.Chunks[i]
+	c.mu.Lock()
+	var peers []string
+	for _, p := range c.plan.Peers {
+		if have := DecodeHave(p.Have, len(c.plan.Chunks)); have[i] {
+			peers = append(peers, p.URL)
+		}
+	}
+	c.mu.Unlock()
+	rand.Shuffle(len(peers), func(a, b int) { peers[a], peers[b] = peers[b], peers[a] })
+	if len(peers) > 3 {
+		peers = peers[:3]
+	}
+
+	for _, p := range peers {
+		data, err := c.get(ctx, strings.TrimSuffix(c.peerURL(p), "/")+"/chunk/"+ch.Hash, "", false, ch.Len)
+		if err == nil && verify(data, ch.Hash) {
+			return c.store(i, data, true)
+		}
+	}
+	rng := "bytes=" + strconv.FormatInt(ch.Off, 10) + "-" + strconv.FormatInt(ch.Off+int64(ch.Len)-1, 10)
+	data, err := c.get(ctx, c.fileURL, rng, true, ch.Len)
+	if err != nil {
+		return err
+	}
+	if !verify(data, ch.Hash) {
+		return fmt.Errorf("swarm: chunk %d from host does not match its hash (file changed?)", i)
+	}
+	return c.store(i, data, false)
+}
+
+// peerURL points a link-local peer at the interface this client reaches
+// the host over: the zone in a peer's URL names the host's interface, not
+// ours.
+func (c *client) peerURL(p string) string {
+	u, err := url.Parse(p)
+	if err != nil {
+		return p
+	}
+	a, err := netip.ParseAddr(u.Hostname())
+	if err != nil || a.Zone() == "" || !a.IsLinkLocalUnicast() {
+		return p
+	}
+	h, err := url.Parse(c.fileURL)
+	if err != nil {
+		return p
+	}
+	ha, err := netip.ParseAddr(h.Hostname())
+	if err != nil || ha.Zone() == "" || !ha.IsLinkLocalUnicast() {
+		return p
+	}
+	u.Host = net.JoinHostPort(a.WithZone(ha.Zone()).String(), u.Port())
+	return u.String()
+}
+
+func (c *client) get(ctx context.Context, u, rng string, auth bool, n int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	if auth {
+		c.opt.Auth(req)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	want := http.StatusOK
+	if rng != "" {
+		want = http.StatusPartialContent
+	}
+	if resp.StatusCode != want {
+		return nil, fmt.Errorf("swarm: %s: %s", u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(n)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != n {
+		return nil, fmt.Errorf("swarm: %s: short chunk", u)
+	}
+	return data, nil
+}
+
+func verify(data []byte, hash string) bool {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == hash
+}
+
+func (c *client) store(i int, data []byte, fromPeer bool) error {
+	ch := c.plan.Chunks[i]
+	c.mu.Lock()
+	f := c.f
+	c.mu.Unlock()
+	if _, err := f.WriteAt(data, ch.Off); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.have[i] = true
+	c.index[ch.Hash] = i
+	if fromPeer {
+		c.stats.FromPeers += int64(len(data))
+	} else {
+		c.stats.FromHost += int64(len(data))
+	}
+	return nil
+}
+
+// finish checks the whole file and moves it into place, reopening it for
+// seeding.
+func (c *client) finish(part string) error {
+	c.mu.Lock()
+	f := c.f
+	c.mu.Unlock()
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, c.plan.Size)); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != c.plan.SHA256 {
+		return fmt.Errorf("swarm: file hash %s, want %s", got, c.plan.SHA256)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.f.Close()
+	c.f = nil
+	if err := os.Rename(part, c.opt.Out); err != nil {
+		return err
+	}
+	nf, err := os.Open(c.opt.Out)
+	if err != nil {
+		return err
+	}
+	c.f = nf
+	return nil
+}
+
+// serveChunk answers GET /chunk/<sha256> from other peers.
+func (c *client) serveChunk(w http.ResponseWriter, r *http.Request) {
+	hash, ok := strings.CutPrefix(r.URL.Path, "/chunk/")
+	if !ok || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	c.mu.Lock()
+	i, ok := c.index[hash]
+	f := c.f
+	c.mu.Unlock()
+	if !ok || f == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ch := c.plan.Chunks[i]
+	buf := make([]byte, ch.Len)
+	if _, err := f.ReadAt(buf, ch.Off); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "read failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if n, _ := w.Write(buf); n > 0 {
+		c.mu.Lock()
+		c.stats.Served += int64(n)
+		c.mu.Unlock()
+	}
+}
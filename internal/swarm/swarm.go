@@ -0,0 +1,82 @@
+// Package swarm lets clients downloading the same large file fetch verified
+// chunks from each other instead of all pulling from the host.
+//
+// The lanparty server is only the tracker: /api/swarm hands out the file's
+// chunk list (offset, length, sha256) and the peers that announced having
+// some of them. Peers serve chunks they hold as
+//
+//	GET http://<peer>/chunk/<sha256>
+//
+// and every chunk is verified against the server's hash before it is used,
+// so a bad or malicious peer can only waste a request. Chunks nobody has are
+// fetched from the host with a Range request.
+package swarm
+
+import (
+	"encoding/base64"
+
+	"lanparty/internal/dedup"
+)
+
+// PieceSize is the chunk size of swarm plans.
+const PieceSize = 4 << 20
+
+// Plan is the tracker's answer for one file.
+type Plan struct {
+	Size   int64         `json:"size"`
+	SHA256 string        `json:"sha256"`
+	Chunks []dedup.Chunk `json:"chunks"`
+	Peers  []Peer        `json:"peers"`
+}
+
+// Peer is another client holding some chunks of the file.
+type Peer struct {
+	URL  string `json:"url"`
+	Have string `json:"have"` // EncodeHave bitmap over Plan.Chunks
+}
+
+// Announce is what a client posts to /api/swarm.
+type Announce struct {
+	Port int    `json:"port"` // 0 = download only, do not list as a peer
+	Have string `json:"have"`
+}
+
+// EncodeHave packs a chunk bitmap for the wire.
+func EncodeHave(have []bool) string {
+	b := make([]byte, (len(have)+7)/8)
+	for i, ok := range have {
+		if ok {
+			b[i/8] |= 1 << (i % 8)
+		}
+	}
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+// DecodeHave unpacks a bitmap for n chunks; bad input means "nothing".
+func DecodeHave(s string, n int) []bool {
+	have := make([]bool, n)
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return have
+	}
+	for i := range have {
+		if i/8 < len(b) && b[i/8]&(1<<(i%8)) != 0 {
+			have[i] = true
+		}
+	}
+	return have
+}
+
+// HasAny reports whether a bitmap has at least one chunk.
+func HasAny(s string) bool {
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// A minimal audio tag reader: ID3v2 (2.2-2.4) and ID3v1 for MP3, Vorbis
+// comments for FLAC. Only the fields the music library needs are decoded.
+
+// Tags holds basic track metadata. Empty fields mean "not tagged".
+type Tags struct {
+	Title       string `json:"title,omitempty"`
+	Artist      string `json:"artist,omitempty"`
+	AlbumArtist string `json:"albumArtist,omitempty"`
+	Album       string `json:"album,omitempty"`
+	Track       int    `json:"track,omitempty"`
+	Year        int    `json:"year,omitempty"`
+}
+
+var ErrNoTags = errors.New("tags: no supported tags")
+
+// maxTagSize bounds how much metadata is read from a file.
+const maxTagSize = 4 << 20
+
+// ReadFile reads tags from the audio file at path, dispatching on extension.
+func ReadFile(path string) (*Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		if t, err := readID3v2(f); err == nil {
+			return t, nil
+		}
+		return readID3v1(f)
+	case ".flac":
+		return readFLAC(f)
+	default:
+		return nil, ErrNoTags
+	}
+}
+
+func readID3v2(f io.ReadSeeker) (*Tags, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var hdr [10]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return nil, err
+	}
+	if string(hdr[:3]) != "ID3" {
+		return nil, ErrNoTags
+	}
+	ver := hdr[3]
+	flags := hdr[5]
+	size := syncsafe(hdr[6:10])
+	if size <= 0 || size > maxTagSize {
+		return nil, ErrNoTags
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(f, body); err != nil {
+		return nil, err
+	}
+	if flags&0x40 != 0 && ver >= 3 && len(body) >= 4 {
+		// extended header
+		n := int(binary.BigEndian.Uint32(body[:4]))
+		if ver == 4 {
+			n = syncsafe(body[:4])
+		} else {
+			n += 4
+		}
+		if n > len(body) {
+			return nil, ErrNoTags
+		}
+		body = body[n:]
+	}
+
+	t := &Tags{}
+	idLen, hdrLen := 4, 10
+	if ver == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(body) >= hdrLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var n int
+		switch ver {
+		case 2:
+			n = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 4:
+			n = syncsafe(body[4:8])
+		default:
+			n = int(binary.BigEndian.Uint32(body[4:8]))
+		}
+		if n < 0 || hdrLen+n > len(body) {
+			break
+		}
+		data := body[hdrLen : hdrLen+n]
+		body = body[hdrLen+n:]
+		switch id {
+		case "TIT2", "TT2":
+			t.Title = decodeText(data)
+		case "TPE1", "TP1":
+			t.Artist = decodeText(data)
+		case "TPE2", "TP2":
+			t.AlbumArtist = decodeText(data)
+		case "TALB", "TAL":
+			t.Album = decodeText(data)
+		case "TRCK", "TRK":
+			t.Track = leadingInt(decodeText(data))
+		case "TYER", "TDRC", "TYE":
+			t.Year = leadingInt(decodeText(data))
+		}
+	}
+	return t, nil
+}
+
+func readID3v1(f io.ReadSeeker) (*Tags, error) {
+	if _, err := f.Seek(-128, io.SeekEnd); err != nil {
+		return nil, ErrNoTags
+	}
+	var b [128]byte
+	if _, err := io.ReadFull(f, b[:]); err != nil {
+		return nil, err
+	}
+	if string(b[:3]) != "TAG" {
+		return nil, ErrNoTags
+	}
+	field := func(p []byte) string {
+		return strings.TrimRight(latin1(p), "\x00 ")
+	}
+	t := &Tags{
+		Title:  field(b[3:33]),
+		Artist: field(b[33:63]),
+		Album:  field(b[63:93]),
+		Year:   leadingInt(field(b[93:97])),
+	}
+	// ID3v1.1: track number in the last comment byte.
+	if b[125] == 0 && b[126] != 0 {
+		t.Track = int(b[126])
+	}
+	return t, nil
+}
+
+func readFLAC(f io.Reader) (*Tags, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != "fLaC" {
+		return nil, ErrNoTags
+	}
+	for {
+		var bh [4]byte
+		if _, err := io.ReadFull(f, bh[:]); err != nil {
+			return nil, err
+		}
+		last := bh[0]&0x80 != 0
+		typ := bh[0] & 0x7f
+		n := int(bh[1])<<16 | int(bh[2])<<8 | int(bh[3])
+		if typ == 4 {
+			if n > maxTagSize {
+				return nil, ErrNoTags
+			}
+			b := make([]byte, n)
+			if _, err := io.ReadFull(f, b); err != nil {
+				return nil, err
+			}
+			return parseVorbisComments(b)
+		}
+		if last {
+			return nil, ErrNoTags
+		}
+		if _, err := io.CopyN(io.Discard, f, int64(n)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func parseVorbisComments(b []byte) (*Tags, error) {
+	r := bytes.NewReader(b)
+	var vlen uint32
+	if err := binary.Read(r, binary.LittleEndian, &vlen); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(int64(vlen), io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	t := &Tags{}
+	for i := uint32(0); i < count; i++ {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			break
+		}
+		if int(n) > r.Len() {
+			break
+		}
+		c := make([]byte, n)
+		_, _ = io.ReadFull(r, c)
+		k, v, ok := strings.Cut(string(c), "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(k) {
+		case "TITLE":
+			t.Title = v
+		case "ARTIST":
+			t.Artist = v
+		case "ALBUMARTIST", "ALBUM ARTIST":
+			t.AlbumArtist = v
+		case "ALBUM":
+			t.Album = v
+		case "TRACKNUMBER":
+			t.Track = leadingInt(v)
+		case "DATE", "YEAR":
+			t.Year = leadingInt(v)
+		}
+	}
+	return t, nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// decodeText decodes an ID3v2 text frame (encoding byte + payload).
+func decodeText(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	enc, p := b[0], b[1:]
+	var s string
+	switch enc {
+	case 1, 2: // UTF-16 with BOM / UTF-16BE
+		be := enc == 2
+		if len(p) >= 2 {
+			switch {
+			case p[0] == 0xFF && p[1] == 0xFE:
+				be, p = false, p[2:]
+			case p[0] == 0xFE && p[1] == 0xFF:
+				be, p = true, p[2:]
+			}
+		}
+		u := make([]uint16, 0, len(p)/2)
+		for i := 0; i+1 < len(p); i += 2 {
+			if be {
+				u = append(u, uint16(p[i])<<8|uint16(p[i+1]))
+			} else {
+				u = append(u, uint16(p[i+1])<<8|uint16(p[i]))
+			}
+		}
+		s = string(utf16.Decode(u))
+	case 3:
+		s = string(p)
+	default:
+		s = latin1(p)
+	}
+	// Multiple values are NUL-separated; keep the first.
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// leadingInt parses "3/12" or "2004-05-06" style values.
+func leadingInt(s string) int {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
@@ -0,0 +1,142 @@
+// Package tlscert provides the self-signed certificate lanparty serves HTTPS
+// with when no real one is configured.
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	certFile = "cert.pem"
+	keyFile  = "key.pem"
+	validFor = 10 * 365 * 24 * time.Hour
+)
+
+// SelfSigned returns the certificate and key files in dir, generating them
+// first if they do not exist yet. The certificate names this machine (host
+// name, localhost and interface addresses) plus hosts.
+func SelfSigned(dir string, hosts []string) (cert, key string, err error) {
+	cert, key = filepath.Join(dir, certFile), filepath.Join(dir, keyFile)
+	_, errC := os.Stat(cert)
+	_, errK := os.Stat(key)
+	if errC == nil && errK == nil {
+		return cert, key, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+	certPEM, keyPEM, err := generate(names(hosts))
+	if err != nil {
+		return "", "", err
+	}
+	// Key first: a certificate without its key would be taken as valid on
+	// the next run.
+	if err := os.WriteFile(key, keyPEM, 0o600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(cert, certPEM, 0o644); err != nil {
+		return "", "", err
+	}
+	return cert, key, nil
+}
+
+// names lists what the certificate should be valid for.
+func names(extra []string) []string {
+	out := []string{"localhost", "127.0.0.1", "::1"}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		out = append(out, h)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && !ipn.IP.IsLinkLocalUnicast() {
+				out = append(out, ipn.IP.String())
+			}
+		}
+	}
+	return append(out, extra...)
+}
+
+func generate(hosts []string) (certPEM, keyPEM []byte, err error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	host, _ := os.Hostname()
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"lanparty"}, CommonName: "lanparty " + host},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	seen := map[string]bool{}
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if h == "" || seen[h] {
+			continue
+		}
+		seen[h] = true
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the first certificate in
+// a PEM file, as browsers show it (colon-separated hex), so users can
+// check they are talking to the right server before accepting it.
+func Fingerprint(certFile string) (string, error) {
+	b, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", err
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil || blk.Type != "CERTIFICATE" {
+		return "", errors.New("tlscert: no certificate in " + certFile)
+	}
+	sum := sha256.Sum256(blk.Bytes)
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	var sb strings.Builder
+	for i := 0; i < len(h); i += 2 {
+		if i > 0 {
+			sb.WriteByte(':')
+		}
+		sb.WriteString(h[i : i+2])
+	}
+	return sb.String(), nil
+}
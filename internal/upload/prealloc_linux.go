@@ -0,0 +1,19 @@
+//go:build linux
+
+package upload
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes for f, so the file is laid out in as few
+// extents as the filesystem can manage. Filesystems without fallocate get
+// a sparse file of that size.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return f.Truncate(size)
+	}
+	return err
+}
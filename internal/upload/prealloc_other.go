@@ -0,0 +1,10 @@
+//go:build !linux
+
+package upload
+
+import "os"
+
+// preallocate sizes f to size bytes. Only Linux reserves the blocks.
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,121 @@
+package upload
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Encrypted .part files. When the blob store has a key, each PATCH appends
+// one record to the part file:
+//
+//	8-byte big-endian record length || sealed stream of the chunk
+//
+// The stream's associated data is "<id>:<offset>", so records cannot be
+// reordered or moved between sessions. Only PartBytes (saved with the
+// session) counts as committed; anything after it is a torn write and is
+// truncated by the next PATCH.
+
+func recordAD(id string, off int64) []byte {
+	return []byte(fmt.Sprintf("%s:%d", id, off))
+}
+
+// appendSealed writes the body as a new record at s.PartBytes. It returns the
+// plaintext bytes written and the new committed part length.
+func (m *Manager) appendSealed(f *os.File, s *session, start int64, body io.Reader) (int64, int64, error) {
+	key := m.dedup.Key()
+	if key == nil {
+		return 0, 0, errors.New("upload: session is encrypted but no key is configured")
+	}
+	if err := f.Truncate(s.PartBytes); err != nil {
+		return 0, 0, err
+	}
+	if _, err := f.Seek(s.PartBytes+8, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	cw := &countingWriter{w: f}
+	sw, err := key.NewWriter(cw, recordAD(s.ID, start))
+	if err != nil {
+		return 0, 0, err
+	}
+	wrote, err := io.Copy(sw, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := sw.Close(); err != nil {
+		return 0, 0, err
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(cw.n))
+	if _, err := f.WriteAt(hdr[:], s.PartBytes); err != nil {
+		return 0, 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, 0, err
+	}
+	return wrote, s.PartBytes + 8 + cw.n, nil
+}
+
+// unsealPart decrypts the committed records of partPath into dst.
+func (m *Manager) unsealPart(partPath, dst string, s *session) error {
+	key := m.dedup.Key()
+	if key == nil {
+		return errors.New("upload: session is encrypted but no key is configured")
+	}
+	in, err := os.Open(partPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = out.Close() }()
+
+	var pos, off int64
+	for pos < s.PartBytes {
+		var hdr [8]byte
+		if _, err := in.ReadAt(hdr[:], pos); err != nil {
+			return err
+		}
+		n := int64(binary.BigEndian.Uint64(hdr[:]))
+		if n <= 0 || pos+8+n > s.PartBytes {
+			return errors.New("upload: corrupt encrypted part")
+		}
+		sr, err := key.NewReader(io.NewSectionReader(in, pos+8, n), recordAD(s.ID, off))
+		if err != nil {
+			return err
+		}
+		w, err := io.Copy(out, sr)
+		if err != nil {
+			return err
+		}
+		off += w
+		pos += 8 + n
+	}
+	if off != s.Offset {
+		return fmt.Errorf("upload: decrypted %d bytes, expected %d", off, s.Offset)
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -3,37 +3,87 @@ package upload
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"lanparty/internal/dedup"
 	"lanparty/internal/fsutil"
+	"lanparty/internal/meta"
 )
 
 // A minimal resumable upload protocol:
 // - POST   /api/uploads?path=<destRel>  => {id, offset}
 // - PATCH  /api/uploads/<id> (Content-Range: bytes <start>-<end>/<total>) body=chunk
 // - POST   /api/uploads/<id>/finish    => finalize into dest (dedup store)
+// - GET    /api/uploads                => the caller's sessions, to resume one elsewhere
 //
-// State is stored on disk in <stateDir>/uploads/<id>.{part,json}
+// Data goes to <spoolDir>/<id>.part; session records live in a meta store.
 
 type Manager struct {
-	rootAbs  string
+	rootAbs        string
 	followSymlinks bool
-	dir      string
-	dedup    *dedup.Store
-	mu       sync.Mutex
-	sessions map[string]*session
+	dir            string
+	dedup          *dedup.Store
+	store          *meta.Store // session records by id
+
+	mu       sync.Mutex // guards sessions and swept
+	sessions map[string]*entry
+	swept    time.Time
+
+	// prealloc and syncFinish change on config reloads, while uploads run.
+	prealloc   atomic.Bool
+	syncFinish atomic.Bool
+}
+
+// Sync policies for SetSync.
+const (
+	SyncChunk  = "chunk"  // flush every chunk before acknowledging it
+	SyncFinish = "finish" // flush once, when the upload is finished
+)
+
+// idleClose is how long a session's part file stays open without a PATCH.
+const idleClose = 2 * time.Minute
+
+// entry is a live session. io serializes Patch, Finish and Cancel on it and
+// guards f, used and gone; mu guards s, so Get stays cheap while a chunk is
+// being written. Sessions never wait on each other.
+type entry struct {
+	io   sync.Mutex
+	f    *os.File // open .part, kept across patches
+	used time.Time
+	gone bool // finished or cancelled
+
+	mu sync.Mutex
+	s  session
+}
+
+func (e *entry) snapshot() *session {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cp := e.s
+	return &cp
+}
+
+func (e *entry) closeFile() {
+	if e.f != nil {
+		_ = e.f.Close()
+		e.f = nil
+	}
 }
 
 type session struct {
@@ -42,144 +92,378 @@ type session struct {
 	Size    int64  `json:"size"`   // total if known, else -1
 	Offset  int64  `json:"offset"` // written bytes
 	Created int64  `json:"created"`
+	// User started the session; "" for anonymous uploads and sessions
+	// from before it was recorded.
+	User string `json:"user,omitempty"`
+	// Updated is when the last chunk arrived (unix seconds), 0 before the
+	// first.
+	Updated int64 `json:"updated,omitempty"`
+	// Sealed sessions store the .part as encrypted records (see sealed.go);
+	// PartBytes is the committed length of that file.
+	Sealed    bool  `json:"sealed,omitempty"`
+	PartBytes int64 `json:"partBytes,omitempty"`
+	// Hash is the marshaled SHA-256 state over the first Offset bytes, so
+	// Finish does not have to re-read the part. Empty for sessions created
+	// before it existed; those are hashed by the store.
+	Hash []byte `json:"hash,omitempty"`
+	// Unsynced marks a session whose saved Offset may be ahead of what
+	// reached the disk (SyncFinish). If the process dies before Close
+	// flushes it, the session starts over when loaded again.
+	Unsynced bool `json:"unsynced,omitempty"`
 }
 
-func New(rootAbs, stateDir string, store *dedup.Store, followSymlinks bool) (*Manager, error) {
-	dir := filepath.Join(stateDir, "uploads")
+// New returns a manager keeping part files in spoolDir and session records
+// in sessions.
+func New(rootAbs, spoolDir string, store *dedup.Store, sessions *meta.Store, followSymlinks bool) (*Manager, error) {
+	dir := spoolDir
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return nil, err
 	}
 	m := &Manager{
-		rootAbs:  rootAbs,
+		rootAbs:        rootAbs,
 		followSymlinks: followSymlinks,
-		dir:      dir,
-		dedup:    store,
-		sessions: map[string]*session{},
+		dir:            dir,
+		dedup:          store,
+		store:          sessions,
+		sessions:       map[string]*entry{},
+		swept:          time.Now(),
 	}
 	_ = m.loadExisting()
 	return m, nil
 }
 
+// SetPreallocate makes Create size the .part file of uploads of known size
+// up front. Encrypted parts are never preallocated.
+func (m *Manager) SetPreallocate(on bool) {
+	m.prealloc.Store(on)
+}
+
+// SetSync sets when chunks are flushed to disk: SyncChunk (the default, so
+// an acknowledged chunk survives a crash) or SyncFinish (fewer flushes,
+// but a crash restarts uploads in flight from the beginning).
+func (m *Manager) SetSync(policy string) {
+	m.syncFinish.Store(policy == SyncFinish)
+}
+
 func (m *Manager) loadExisting() error {
+	m.importJSON()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range m.store.Keys("") {
+		var s session
+		if ok, err := m.store.Get(id, &s); !ok || err != nil {
+			continue
+		}
+		if s.ID == "" {
+			continue
+		}
+		if s.Unsynced {
+			// Chunks past some point may never have reached the disk.
+			s.Offset, s.PartBytes, s.Unsynced = 0, 0, false
+			s.Hash, _ = sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+			_ = m.store.Put(s.ID, s)
+		}
+		m.sessions[s.ID] = &entry{s: s}
+	}
+	return nil
+}
+
+// importJSON moves <id>.json session files, kept next to the parts by
+// older versions, into the store.
+func (m *Manager) importJSON() {
 	ents, err := os.ReadDir(m.dir)
 	if err != nil {
-		return err
+		return
 	}
-	m.mu.Lock()
-	defer m.mu.Unlock()
 	for _, e := range ents {
 		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
 			continue
 		}
-		b, err := os.ReadFile(filepath.Join(m.dir, e.Name()))
+		p := filepath.Join(m.dir, e.Name())
+		b, err := os.ReadFile(p)
 		if err != nil {
 			continue
 		}
 		var s session
-		if json.Unmarshal(b, &s) != nil {
+		if json.Unmarshal(b, &s) != nil || s.ID == "" {
 			continue
 		}
-		if s.ID != "" {
-			cp := s
-			m.sessions[s.ID] = &cp
+		if m.store.Put(s.ID, s) == nil {
+			_ = os.Remove(p)
 		}
 	}
-	return nil
 }
 
-func (m *Manager) Create(destRel string, total int64) (*session, error) {
+// Close releases open part files, waiting for in-flight patches, and
+// flushes them so sessions resume from their saved offsets after a restart.
+// The manager stays usable; files are reopened on demand.
+func (m *Manager) Close() {
+	for _, e := range m.entries() {
+		e.io.Lock()
+		s := e.snapshot()
+		var err error
+		if e.f != nil {
+			err = e.f.Sync()
+		} else if s.Unsynced && !e.gone {
+			err = m.syncPart(s.ID)
+		}
+		e.closeFile()
+		if s.Unsynced && !e.gone && err == nil {
+			s.Unsynced = false
+			if m.save(s) == nil {
+				e.mu.Lock()
+				e.s = *s
+				e.mu.Unlock()
+			}
+		}
+		e.io.Unlock()
+	}
+}
+
+func (m *Manager) entries() []*entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*entry, 0, len(m.sessions))
+	for _, e := range m.sessions {
+		out = append(out, e)
+	}
+	return out
+}
+
+func (m *Manager) entry(id string) (*entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.sessions[id]
+	return e, ok
+}
+
+// closeIdle closes part files of sessions nobody patched for idleClose, at
+// most once a minute. Busy sessions are skipped.
+func (m *Manager) closeIdle() {
+	m.mu.Lock()
+	if time.Since(m.swept) < time.Minute {
+		m.mu.Unlock()
+		return
+	}
+	m.swept = time.Now()
+	m.mu.Unlock()
+	for _, e := range m.entries() {
+		if !e.io.TryLock() {
+			continue
+		}
+		if e.f != nil && time.Since(e.used) > idleClose {
+			e.closeFile()
+		}
+		e.io.Unlock()
+	}
+}
+
+// Create starts a session uploading to destRel for user.
+func (m *Manager) Create(destRel string, total int64, user string) (*session, error) {
 	id, err := newID()
 	if err != nil {
 		return nil, err
 	}
 	destRel = fsutil.CleanRelPath(destRel)
-	s := &session{
+	s := session{
 		ID:      id,
 		DestRel: destRel,
 		Size:    total,
 		Offset:  0,
 		Created: time.Now().Unix(),
+		User:    user,
+		Sealed:  m.dedup.Key() != nil,
+	}
+	s.Hash, _ = sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err := m.save(&s); err != nil {
+		return nil, err
 	}
 	m.mu.Lock()
-	m.sessions[id] = s
+	m.sessions[id] = &entry{s: s}
 	m.mu.Unlock()
-	if err := m.save(s); err != nil {
-		return nil, err
+	m.preallocatePart(&s)
+	return &s, nil
+}
+
+// preallocatePart sizes the part file of a plain session of known size,
+// if enabled. It is best effort: a part that could not be preallocated
+// just grows chunk by chunk.
+func (m *Manager) preallocatePart(s *session) {
+	if !m.prealloc.Load() || s.Sealed || s.Size <= 0 {
+		return
+	}
+	f, err := os.OpenFile(filepath.Join(m.dir, s.ID+".part"), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err == nil && st.Size() == 0 {
+		_ = preallocate(f, s.Size)
 	}
-	return s, nil
+}
+
+// syncPart flushes the part file of session id.
+func (m *Manager) syncPart(id string) error {
+	f, err := os.OpenFile(filepath.Join(m.dir, id+".part"), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
 }
 
 func (m *Manager) Get(id string) (*session, bool) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	s, ok := m.sessions[id]
+	e, ok := m.entry(id)
 	if !ok {
 		return nil, false
 	}
-	cp := *s
-	return &cp, true
+	return e.snapshot(), true
 }
 
+// List returns the sessions in progress, oldest first.
+func (m *Manager) List() []*session {
+	es := m.entries()
+	out := make([]*session, 0, len(es))
+	for _, e := range es {
+		out = append(out, e.snapshot())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Created != out[j].Created {
+			return out[i].Created < out[j].Created
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// FindByDest returns the in-progress session user started targeting
+// destRel, if any. Protocols without upload IDs (WebDAV partial PUT) use
+// this to resume; other users' sessions for the same path are not theirs
+// to continue or cancel.
+func (m *Manager) FindByDest(destRel, user string) (*session, bool) {
+	destRel = fsutil.CleanRelPath(destRel)
+	for _, e := range m.entries() {
+		if s := e.snapshot(); s.DestRel == destRel && s.User == user {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Patch appends one chunk. Chunks of a session are applied one at a time;
+// different sessions proceed in parallel. The session only advances once the
+// chunk is on disk and its state saved.
 func (m *Manager) Patch(ctx context.Context, id string, r *http.Request) (*session, error) {
-	m.mu.Lock()
-	s, ok := m.sessions[id]
-	m.mu.Unlock()
+	m.closeIdle()
+	e, ok := m.entry(id)
 	if !ok {
 		return nil, os.ErrNotExist
 	}
-	start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	start, end, total, err := ParseContentRange(r.Header.Get("Content-Range"))
 	if err != nil {
 		return nil, err
 	}
+	e.io.Lock()
+	defer e.io.Unlock()
+	if e.gone {
+		return nil, os.ErrNotExist
+	}
+	s := e.snapshot()
 	if start != s.Offset {
 		return nil, fmt.Errorf("offset mismatch: have %d want %d", s.Offset, start)
 	}
+	learned := false
 	if s.Size < 0 && total >= 0 {
-		s.Size = total
+		s.Size, learned = total, true
 	}
 	if s.Size >= 0 && total >= 0 && s.Size != total {
 		return nil, fmt.Errorf("size mismatch: have %d want %d", s.Size, total)
 	}
 
-	partPath := filepath.Join(m.dir, id+".part")
-	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		return nil, err
+	if learned && start == 0 {
+		m.preallocatePart(s)
 	}
-	defer f.Close()
-	if _, err := f.Seek(start, io.SeekStart); err != nil {
-		return nil, err
+	if e.f == nil {
+		f, err := os.OpenFile(filepath.Join(m.dir, id+".part"), os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		e.f = f
 	}
+	e.used = time.Now()
+	f := e.f
 
-	// stream copy
-	wrote, err := io.CopyN(f, r.Body, (end-start)+1)
-	if err != nil {
-		return nil, err
+	body := io.LimitReader(r.Body, (end-start)+1)
+	h := resumeHash(s.Hash)
+	if h != nil {
+		body = io.TeeReader(body, h)
+	}
+
+	var wrote int64
+	if s.Sealed {
+		var partBytes int64
+		wrote, partBytes, err = m.appendSealed(f, s, start, body)
+		if err != nil {
+			e.closeFile()
+			return nil, err
+		}
+		s.PartBytes = partBytes
+	} else {
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			e.closeFile()
+			return nil, err
+		}
+		// stream copy
+		if wrote, err = io.Copy(f, body); err != nil {
+			return nil, err
+		}
+		if wrote == (end-start)+1 && !m.syncFinish.Load() {
+			if err := f.Sync(); err != nil {
+				e.closeFile()
+				return nil, err
+			}
+		}
 	}
 	if wrote != (end-start)+1 {
 		return nil, fmt.Errorf("short write: %d != %d", wrote, (end-start)+1)
 	}
-	if err := f.Sync(); err != nil {
-		return nil, err
-	}
 
-	m.mu.Lock()
 	s.Offset += wrote
-	m.mu.Unlock()
+	s.Updated = time.Now().Unix()
+	s.Hash = saveHash(h)
+	s.Unsynced = s.Unsynced || (m.syncFinish.Load() && !s.Sealed)
 	if err := m.save(s); err != nil {
 		return nil, err
 	}
-	cp := *s
-	return &cp, nil
+	e.mu.Lock()
+	e.s = *s
+	e.mu.Unlock()
+	return s, nil
 }
 
 func (m *Manager) Finish(ctx context.Context, id string) (dstAbs string, sha256hex string, size int64, err error) {
-	m.mu.Lock()
-	s, ok := m.sessions[id]
-	m.mu.Unlock()
+	e, ok := m.entry(id)
 	if !ok {
 		return "", "", 0, os.ErrNotExist
 	}
+	e.io.Lock()
+	defer e.io.Unlock()
+	if e.gone {
+		return "", "", 0, os.ErrNotExist
+	}
+	s := e.snapshot()
+	if s.Unsynced {
+		var err error
+		if e.f != nil {
+			err = e.f.Sync()
+		} else {
+			err = m.syncPart(id)
+		}
+		if err != nil {
+			return "", "", 0, err
+		}
+	}
+	e.closeFile()
 	if s.Size >= 0 && s.Offset != s.Size {
 		return "", "", 0, fmt.Errorf("upload incomplete: offset=%d size=%d", s.Offset, s.Size)
 	}
@@ -189,28 +473,46 @@ func (m *Manager) Finish(ctx context.Context, id string) (dstAbs string, sha256h
 	if err != nil {
 		return "", "", 0, err
 	}
-	if s.Size >= 0 && st.Size() != s.Size {
-		return "", "", 0, fmt.Errorf("size mismatch: file=%d expected=%d", st.Size(), s.Size)
-	}
-	tmpPath := filepath.Join(m.dir, id+".tmp")
-	_ = os.Remove(tmpPath)
-	if err := os.Rename(partPath, tmpPath); err != nil {
+	dstAbs, err = fsutil.ResolveWithinRoot(m.rootAbs, s.DestRel, m.followSymlinks)
+	if err != nil {
 		return "", "", 0, err
 	}
+	var tmpPath string
+	if s.Sealed {
+		// Decrypt next to the destination so plaintext never lands in the
+		// state dir; Put re-encrypts it into the blob store.
+		tmpPath = filepath.Join(filepath.Dir(dstAbs), ".lanparty-"+id+".tmp")
+		if err := m.unsealPart(partPath, tmpPath, s); err != nil {
+			_ = os.Remove(tmpPath)
+			return "", "", 0, err
+		}
+		_ = os.Remove(partPath)
+	} else {
+		if s.Size >= 0 && st.Size() != s.Size {
+			return "", "", 0, fmt.Errorf("size mismatch: file=%d expected=%d", st.Size(), s.Size)
+		}
+		tmpPath = filepath.Join(m.dir, id+".tmp")
+		_ = os.Remove(tmpPath)
+		if err := os.Rename(partPath, tmpPath); err != nil {
+			return "", "", 0, err
+		}
+	}
 
-	sha256hex, blobPath, size, err := m.dedup.Put(ctx, tmpPath)
-	if err != nil {
-		return "", "", 0, err
+	var blobPath string
+	if h := resumeHash(s.Hash); h != nil {
+		sha256hex, blobPath, size, err = m.dedup.PutHashed(ctx, tmpPath, hex.EncodeToString(h.Sum(nil)), s.Offset)
+	} else {
+		sha256hex, blobPath, size, err = m.dedup.Put(ctx, tmpPath)
 	}
-	dstAbs, err = fsutil.ResolveWithinRoot(m.rootAbs, s.DestRel, m.followSymlinks)
 	if err != nil {
 		return "", "", 0, err
 	}
-	if err := dedup.LinkOrCopy(blobPath, dstAbs); err != nil {
+	if err := m.dedup.LinkOrCopy(blobPath, dstAbs); err != nil {
 		return "", "", 0, err
 	}
 
-	_ = os.Remove(filepath.Join(m.dir, id+".json"))
+	_ = m.store.Delete(id)
+	e.gone = true
 	m.mu.Lock()
 	delete(m.sessions, id)
 	m.mu.Unlock()
@@ -218,15 +520,22 @@ func (m *Manager) Finish(ctx context.Context, id string) (dstAbs string, sha256h
 	return dstAbs, sha256hex, size, nil
 }
 
+// Cancel drops a session and its files, after any in-flight PATCH on it.
 func (m *Manager) Cancel(id string) error {
 	m.mu.Lock()
-	_, ok := m.sessions[id]
+	e, ok := m.sessions[id]
 	if ok {
 		delete(m.sessions, id)
 	}
 	m.mu.Unlock()
+	if ok {
+		e.io.Lock()
+		e.gone = true
+		e.closeFile()
+		e.io.Unlock()
+	}
 	// Best-effort remove files.
-	_ = os.Remove(filepath.Join(m.dir, id+".json"))
+	_ = m.store.Delete(id)
 	_ = os.Remove(filepath.Join(m.dir, id+".part"))
 	_ = os.Remove(filepath.Join(m.dir, id+".tmp"))
 	if !ok {
@@ -236,13 +545,7 @@ func (m *Manager) Cancel(id string) error {
 }
 
 func (m *Manager) save(s *session) error {
-	b, _ := json.MarshalIndent(s, "", "  ")
-	tmp := filepath.Join(m.dir, s.ID+".json.tmp")
-	final := filepath.Join(m.dir, s.ID+".json")
-	if err := os.WriteFile(tmp, b, 0o644); err != nil {
-		return err
-	}
-	return os.Rename(tmp, final)
+	return m.store.Put(s.ID, s)
 }
 
 func newID() (string, error) {
@@ -253,7 +556,9 @@ func newID() (string, error) {
 	return hex.EncodeToString(b[:]), nil
 }
 
-func parseContentRange(v string) (start, end, total int64, err error) {
+// ParseContentRange parses a request Content-Range header of the form
+// "bytes <start>-<end>/<total>". A total of "*" is reported as -1.
+func ParseContentRange(v string) (start, end, total int64, err error) {
 	// "bytes <start>-<end>/<total>" where total may be "*"
 	v = strings.TrimSpace(v)
 	if !strings.HasPrefix(v, "bytes ") {
@@ -289,4 +594,22 @@ func parseContentRange(v string) (start, end, total int64, err error) {
 	return start, end, total, nil
 }
 
+// resumeHash restores a SHA-256 state saved by saveHash; nil if there is none.
+func resumeHash(state []byte) hash.Hash {
+	if len(state) == 0 {
+		return nil
+	}
+	h := sha256.New()
+	if h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state) != nil {
+		return nil
+	}
+	return h
+}
 
+func saveHash(h hash.Hash) []byte {
+	if h == nil {
+		return nil
+	}
+	b, _ := h.(encoding.BinaryMarshaler).MarshalBinary()
+	return b
+}
@@ -0,0 +1,569 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A minimal container probe: MP4/MOV (moov box), Matroska/WebM (EBML
+// Segment Info and Tracks) and AVI (hdrl). Only what a listing shows is
+// decoded; nothing is demuxed.
+
+// Info describes the first video track of a file. Zero fields are unknown.
+type Info struct {
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Width    int     `json:"width,omitempty"`    // display size, rotation applied
+	Height   int     `json:"height,omitempty"`
+	Codec    string  `json:"codec,omitempty"` // "h264", "hevc", "av1", "vp9", ...
+}
+
+var ErrUnsupported = errors.New("video: unsupported container")
+
+// maxHeader bounds how much container metadata is read from a file.
+const maxHeader = 16 << 20
+
+// ReadFile probes the video file at path, dispatching on extension.
+func ReadFile(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v", ".mov":
+		return readMP4(f)
+	case ".mkv", ".webm":
+		return readMKV(f)
+	case ".avi":
+		return readAVI(f)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// --- MP4 / QuickTime ---
+
+// nextBox reads a box header at the current offset and returns its type
+// and payload size; -1 means "to the end of the file".
+func nextBox(r io.Reader) (typ string, size int64, err error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", 0, err
+	}
+	size = int64(binary.BigEndian.Uint32(hdr[:4]))
+	typ = string(hdr[4:8])
+	switch size {
+	case 0:
+		return typ, -1, nil
+	case 1:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(ext[:])) - 16
+	default:
+		size -= 8
+	}
+	if size < 0 {
+		return "", 0, ErrUnsupported
+	}
+	return typ, size, nil
+}
+
+func readMP4(f io.ReadSeeker) (*Info, error) {
+	for {
+		typ, size, err := nextBox(f)
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		if typ != "moov" {
+			if size < 0 {
+				return nil, ErrUnsupported
+			}
+			if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if size < 0 || size > maxHeader {
+			return nil, ErrUnsupported
+		}
+		moov := make([]byte, size)
+		if _, err := io.ReadFull(f, moov); err != nil {
+			return nil, err
+		}
+		return parseMoov(moov)
+	}
+}
+
+// boxes walks the boxes in b, calling fn with each type and payload.
+func boxes(b []byte, fn func(typ string, p []byte)) {
+	for len(b) >= 8 {
+		size := int64(binary.BigEndian.Uint32(b[:4]))
+		typ := string(b[4:8])
+		hdr := int64(8)
+		if size == 1 && len(b) >= 16 {
+			size = int64(binary.BigEndian.Uint64(b[8:16]))
+			hdr = 16
+		} else if size == 0 {
+			size = int64(len(b))
+		}
+		if size < hdr || size > int64(len(b)) {
+			return
+		}
+		fn(typ, b[hdr:size])
+		b = b[size:]
+	}
+}
+
+func parseMoov(moov []byte) (*Info, error) {
+	info := &Info{}
+	found := false
+	boxes(moov, func(typ string, p []byte) {
+		switch typ {
+		case "mvhd":
+			info.Duration = mvhdDuration(p)
+		case "trak":
+			if found {
+				return
+			}
+			if w, h, codec, ok := videoTrak(p); ok {
+				info.Width, info.Height, info.Codec = w, h, codec
+				found = true
+			}
+		}
+	})
+	if !found && info.Duration == 0 {
+		return nil, ErrUnsupported
+	}
+	return info, nil
+}
+
+func mvhdDuration(p []byte) float64 {
+	var scale uint32
+	var dur uint64
+	switch {
+	case len(p) >= 32 && p[0] == 1:
+		scale = binary.BigEndian.Uint32(p[20:24])
+		dur = binary.BigEndian.Uint64(p[24:32])
+	case len(p) >= 20 && p[0] == 0:
+		scale = binary.BigEndian.Uint32(p[12:16])
+		dur = uint64(binary.BigEndian.Uint32(p[16:20]))
+	}
+	// All-ones is "unknown", as in fragmented files.
+	if scale == 0 || dur == math.MaxUint32 || dur == math.MaxUint64 {
+		return 0
+	}
+	return float64(dur) / float64(scale)
+}
+
+// videoTrak reports the display size and codec of a video trak.
+func videoTrak(trak []byte) (w, h int, codec string, ok bool) {
+	var tkhd, mdia []byte
+	boxes(trak, func(typ string, p []byte) {
+		switch typ {
+		case "tkhd":
+			tkhd = p
+		case "mdia":
+			mdia = p
+		}
+	})
+	isVideo := false
+	boxes(mdia, func(typ string, p []byte) {
+		switch typ {
+		case "hdlr":
+			isVideo = len(p) >= 12 && string(p[8:12]) == "vide"
+		case "minf":
+			boxes(p, func(typ string, p []byte) {
+				if typ != "stbl" {
+					return
+				}
+				boxes(p, func(typ string, p []byte) {
+					// stsd: version/flags, entry count, then the first
+					// sample entry whose type is the codec fourcc.
+					if typ == "stsd" && len(p) >= 16 {
+						codec = fourccCodec(string(p[12:16]))
+					}
+				})
+			})
+		}
+	})
+	if !isVideo {
+		return 0, 0, "", false
+	}
+	if len(tkhd) >= 84 {
+		// Width and height are 16.16 fixed point at the end; the matrix
+		// before them says whether the track is shown rotated.
+		matrix := 40
+		if tkhd[0] == 1 {
+			matrix = 52
+		}
+		n := len(tkhd)
+		w = int(binary.BigEndian.Uint32(tkhd[n-8:n-4]) >> 16)
+		h = int(binary.BigEndian.Uint32(tkhd[n-4:]) >> 16)
+		if matrix+36 <= n-8 {
+			a := int32(binary.BigEndian.Uint32(tkhd[matrix:]))
+			b := int32(binary.BigEndian.Uint32(tkhd[matrix+4:]))
+			if a == 0 && b != 0 {
+				w, h = h, w
+			}
+		}
+	}
+	return w, h, codec, true
+}
+
+func fourccCodec(cc string) string {
+	switch cc {
+	case "avc1", "avc3":
+		return "h264"
+	case "hvc1", "hev1":
+		return "hevc"
+	case "av01":
+		return "av1"
+	case "vp08":
+		return "vp8"
+	case "vp09":
+		return "vp9"
+	case "mp4v":
+		return "mpeg4"
+	case "apcn", "apch", "apcs", "apco", "ap4h":
+		return "prores"
+	}
+	return strings.TrimSpace(cc)
+}
+
+// --- Matroska / WebM ---
+
+const (
+	ebmlHeader    = 0x1A45DFA3
+	mkvSegment    = 0x18538067
+	mkvInfo       = 0x1549A966
+	mkvScale      = 0x2AD7B1
+	mkvDuration   = 0x4489
+	mkvTracks     = 0x1654AE6B
+	mkvTrackEntry = 0xAE
+	mkvTrackType  = 0x83
+	mkvCodecID    = 0x86
+	mkvVideo      = 0xE0
+	mkvPixelW     = 0xB0
+	mkvPixelH     = 0xBA
+	mkvDisplayW   = 0x54B0
+	mkvDisplayH   = 0x54BA
+	mkvCluster    = 0x1F43B675
+)
+
+// ebmlVint reads a variable-length integer. With keepMarker (element IDs)
+// the length marker bit stays in the value. unknown is set for sizes with
+// all value bits set.
+func ebmlVint(r io.ByteReader, keepMarker bool) (v uint64, unknown bool, err error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	n := 1
+	for mask := byte(0x80); n <= 8 && b&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 {
+		return 0, false, ErrUnsupported
+	}
+	v = uint64(b)
+	if !keepMarker {
+		v &= uint64(0xFF >> n)
+	}
+	all := v == uint64(0xFF>>n)
+	for i := 1; i < n; i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		v = v<<8 | uint64(c)
+		if c != 0xFF {
+			all = false
+		}
+	}
+	return v, all && !keepMarker, nil
+}
+
+func readMKV(f io.ReadSeeker) (*Info, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil || binary.BigEndian.Uint32(head[:]) != ebmlHeader {
+		return nil, ErrUnsupported
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := &byteSeeker{f: f}
+	// EBML header, then the Segment.
+	for {
+		id, size, unknown, err := ebmlElement(br)
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		if id == mkvSegment {
+			break
+		}
+		if unknown {
+			return nil, ErrUnsupported
+		}
+		if err := br.skip(int64(size)); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &Info{}
+	scale := uint64(1000000) // ns per timestamp tick
+	var dur float64
+	haveInfo, haveTracks := false, false
+	for !(haveInfo && haveTracks) {
+		id, size, unknown, err := ebmlElement(br)
+		// Info and Tracks come before the media in practice.
+		if err != nil || unknown || id == mkvCluster {
+			break
+		}
+		switch id {
+		case mkvInfo, mkvTracks:
+			if size > maxHeader {
+				return nil, ErrUnsupported
+			}
+			b := make([]byte, size)
+			if _, err := io.ReadFull(br, b); err != nil {
+				return nil, err
+			}
+			if id == mkvInfo {
+				haveInfo = true
+				ebmlChildren(b, func(id uint64, p []byte) {
+					switch id {
+					case mkvScale:
+						if v := ebmlUint(p); v > 0 {
+							scale = v
+						}
+					case mkvDuration:
+						dur = ebmlFloat(p)
+					}
+				})
+			} else {
+				haveTracks = true
+				mkvVideoTrack(b, info)
+			}
+		default:
+			if err := br.skip(int64(size)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !haveInfo && !haveTracks {
+		return nil, ErrUnsupported
+	}
+	info.Duration = dur * float64(scale) / 1e9
+	return info, nil
+}
+
+func mkvVideoTrack(tracks []byte, info *Info) {
+	done := false
+	ebmlChildren(tracks, func(id uint64, entry []byte) {
+		if id != mkvTrackEntry || done {
+			return
+		}
+		var typ uint64
+		var codec string
+		var w, h, dw, dh int
+		ebmlChildren(entry, func(id uint64, p []byte) {
+			switch id {
+			case mkvTrackType:
+				typ = ebmlUint(p)
+			case mkvCodecID:
+				codec = string(bytes.TrimRight(p, "\x00"))
+			case mkvVideo:
+				ebmlChildren(p, func(id uint64, p []byte) {
+					switch id {
+					case mkvPixelW:
+						w = int(ebmlUint(p))
+					case mkvPixelH:
+						h = int(ebmlUint(p))
+					case mkvDisplayW:
+						dw = int(ebmlUint(p))
+					case mkvDisplayH:
+						dh = int(ebmlUint(p))
+					}
+				})
+			}
+		})
+		if typ != 1 {
+			return
+		}
+		done = true
+		// Display size only matters when it sets a different aspect
+		// (anamorphic video); keep the pixel height then.
+		if dw > 0 && dh > 0 && h > 0 && dw*h != dh*w {
+			w = h * dw / dh
+		}
+		info.Width, info.Height, info.Codec = w, h, mkvCodec(codec)
+	})
+}
+
+func mkvCodec(id string) string {
+	switch {
+	case strings.HasPrefix(id, "V_MPEG4/ISO/AVC"):
+		return "h264"
+	case strings.HasPrefix(id, "V_MPEGH/ISO/HEVC"):
+		return "hevc"
+	case id == "V_AV1":
+		return "av1"
+	case id == "V_VP8":
+		return "vp8"
+	case id == "V_VP9":
+		return "vp9"
+	case strings.HasPrefix(id, "V_MPEG4/"):
+		return "mpeg4"
+	case id == "V_MPEG2":
+		return "mpeg2"
+	}
+	return strings.ToLower(strings.TrimPrefix(id, "V_"))
+}
+
+func ebmlElement(r io.ByteReader) (id, size uint64, unknown bool, err error) {
+	if id, _, err = ebmlVint(r, true); err != nil {
+		return 0, 0, false, err
+	}
+	size, unknown, err = ebmlVint(r, false)
+	return id, size, unknown, err
+}
+
+// ebmlChildren walks the elements in b. Elements of unknown size end it.
+func ebmlChildren(b []byte, fn func(id uint64, p []byte)) {
+	r := bytes.NewReader(b)
+	for r.Len() > 0 {
+		id, size, unknown, err := ebmlElement(r)
+		if err != nil || unknown || size > uint64(r.Len()) {
+			return
+		}
+		off := len(b) - r.Len()
+		fn(id, b[off:off+int(size)])
+		_, _ = r.Seek(int64(size), io.SeekCurrent)
+	}
+}
+
+func ebmlUint(p []byte) uint64 {
+	var v uint64
+	for _, c := range p {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func ebmlFloat(p []byte) float64 {
+	switch len(p) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(p)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(p))
+	}
+	return 0
+}
+
+// byteSeeker is a ReadSeeker with ReadByte and a skip that seeks.
+type byteSeeker struct {
+	f   io.ReadSeeker
+	one [1]byte
+}
+
+func (b *byteSeeker) Read(p []byte) (int, error) { return b.f.Read(p) }
+
+func (b *byteSeeker) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(b.f, b.one[:]); err != nil {
+		return 0, err
+	}
+	return b.one[0], nil
+}
+
+func (b *byteSeeker) skip(n int64) error {
+	_, err := b.f.Seek(n, io.SeekCurrent)
+	return err
+}
+
+// --- AVI ---
+
+func readAVI(f io.ReadSeeker) (*Info, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil || string(hdr[:4]) != "RIFF" || string(hdr[8:12]) != "AVI " {
+		return nil, ErrUnsupported
+	}
+	// The first chunk is LIST hdrl with the main header and stream headers.
+	var list [12]byte
+	if _, err := io.ReadFull(f, list[:]); err != nil || string(list[:4]) != "LIST" || string(list[8:12]) != "hdrl" {
+		return nil, ErrUnsupported
+	}
+	size := int64(binary.LittleEndian.Uint32(list[4:8])) - 4
+	if size <= 0 || size > maxHeader {
+		return nil, ErrUnsupported
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	info := &Info{}
+	found := false
+	riffChunks(b, func(id string, p []byte) {
+		switch id {
+		case "avih":
+			if len(p) >= 40 {
+				usPerFrame := binary.LittleEndian.Uint32(p[0:4])
+				frames := binary.LittleEndian.Uint32(p[16:20])
+				info.Duration = float64(frames) * float64(usPerFrame) / 1e6
+				info.Width = int(binary.LittleEndian.Uint32(p[32:36]))
+				info.Height = int(binary.LittleEndian.Uint32(p[36:40]))
+				found = true
+			}
+		case "LIST":
+			if len(p) < 4 || string(p[:4]) != "strl" || info.Codec != "" {
+				return
+			}
+			riffChunks(p[4:], func(id string, p []byte) {
+				if id == "strh" && len(p) >= 8 && string(p[:4]) == "vids" {
+					info.Codec = aviCodec(string(p[4:8]))
+				}
+			})
+		}
+	})
+	if !found {
+		return nil, ErrUnsupported
+	}
+	return info, nil
+}
+
+func riffChunks(b []byte, fn func(id string, p []byte)) {
+	for len(b) >= 8 {
+		id := string(b[:4])
+		n := int(binary.LittleEndian.Uint32(b[4:8]))
+		if n < 0 || 8+n > len(b) {
+			return
+		}
+		fn(id, b[8:8+n])
+		n += n & 1 // chunks are word aligned
+		if 8+n > len(b) {
+			return
+		}
+		b = b[8+n:]
+	}
+}
+
+func aviCodec(cc string) string {
+	switch strings.ToLower(cc) {
+	case "h264", "x264", "avc1":
+		return "h264"
+	case "hevc", "h265", "x265":
+		return "hevc"
+	case "xvid", "divx", "dx50", "fmp4", "mp4v":
+		return "mpeg4"
+	case "mjpg":
+		return "mjpeg"
+	}
+	return strings.ToLower(strings.TrimRight(cc, " \x00"))
+}
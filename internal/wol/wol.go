@@ -0,0 +1,60 @@
+// Package wol sends Wake-on-LAN magic packets.
+package wol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// DefaultBroadcast is used when a host has no broadcast address configured.
+const DefaultBroadcast = "255.255.255.255:9"
+
+// MagicPacket returns the 102-byte packet for mac: six 0xFF bytes followed
+// by the address repeated 16 times.
+func MagicPacket(mac string) ([]byte, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("wol: %s is not a 48-bit MAC", mac)
+	}
+	pkt := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		pkt = append(pkt, hw...)
+	}
+	return pkt, nil
+}
+
+// Send broadcasts a magic packet for mac to addr (host[:port], default
+// port 9). An empty addr means DefaultBroadcast.
+func Send(mac, addr string) error {
+	pkt, err := MagicPacket(mac)
+	if err != nil {
+		return err
+	}
+	if addr == "" {
+		addr = DefaultBroadcast
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "9")
+	}
+	ua, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	// Go sets SO_BROADCAST on UDP sockets, so this works for x.x.x.255 too.
+	conn, err := net.DialUDP("udp4", nil, ua)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// A few copies; WoL is fire-and-forget over UDP.
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}